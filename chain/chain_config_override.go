@@ -0,0 +1,124 @@
+/*
+   Copyright 2023 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package chain
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// ForkOverride - moves activation point of one fork. Fork is addressed by it's json name: "londonBlock", "shanghaiTime", etc...
+// nil At means fork disabled
+type ForkOverride struct {
+	Fork string
+	At   *big.Int
+}
+
+func OverrideFork(fork string, at uint64) ForkOverride {
+	return ForkOverride{Fork: fork, At: new(big.Int).SetUint64(at)}
+}
+func DisableFork(fork string) ForkOverride { return ForkOverride{Fork: fork} }
+
+// WithOverrides - returns copy of config with applied overrides. Allows configure test networks and shadow forks
+// without editing of embedded json. Result is validated: forks order and compatibility of time-based forks.
+func (c *Config) WithOverrides(overrides ...ForkOverride) (*Config, error) {
+	cpy := *c
+	fields := cpy.forkFields()
+	for _, o := range overrides {
+		field, ok := fields[o.Fork]
+		if !ok {
+			return nil, fmt.Errorf("unknown fork: %s", o.Fork)
+		}
+		if o.At == nil {
+			*field = nil
+			continue
+		}
+		*field = new(big.Int).Set(o.At)
+	}
+	if err := cpy.CheckConfigForkOrder(); err != nil {
+		return nil, err
+	}
+	if err := cpy.checkTimeForkOrder(); err != nil {
+		return nil, err
+	}
+	return &cpy, nil
+}
+
+// forkFields - overridable fields, by json name
+func (c *Config) forkFields() map[string]**big.Int {
+	return map[string]**big.Int{
+		"homesteadBlock":      &c.HomesteadBlock,
+		"daoForkBlock":        &c.DAOForkBlock,
+		"eip150Block":         &c.TangerineWhistleBlock,
+		"eip155Block":         &c.SpuriousDragonBlock,
+		"eip158Block":         &c.EIP158Block,
+		"byzantiumBlock":      &c.ByzantiumBlock,
+		"constantinopleBlock": &c.ConstantinopleBlock,
+		"petersburgBlock":     &c.PetersburgBlock,
+		"istanbulBlock":       &c.IstanbulBlock,
+		"muirGlacierBlock":    &c.MuirGlacierBlock,
+		"berlinBlock":         &c.BerlinBlock,
+		"londonBlock":         &c.LondonBlock,
+		"arrowGlacierBlock":   &c.ArrowGlacierBlock,
+		"grayGlacierBlock":    &c.GrayGlacierBlock,
+		"mergeNetsplitBlock":  &c.MergeNetsplitBlock,
+		"shanghaiTime":        &c.ShanghaiTime,
+		"cancunTime":          &c.CancunTime,
+		"shardingForkTime":    &c.ShardingForkTime,
+
+		"ramanujanBlock":  &c.RamanujanBlock,
+		"nielsBlock":      &c.NielsBlock,
+		"mirrorSyncBlock": &c.MirrorSyncBlock,
+		"brunoBlock":      &c.BrunoBlock,
+		"eulerBlock":      &c.EulerBlock,
+		"gibbsBlock":      &c.GibbsBlock,
+		"nanoBlock":       &c.NanoBlock,
+		"moranBlock":      &c.MoranBlock,
+
+		"posdaoBlock":                   &c.PosdaoBlock,
+		"eip1559FeeCollectorTransition": &c.Eip1559FeeCollectorTransition,
+	}
+}
+
+// checkTimeForkOrder - time-based forks activated only after The Merge and in order
+func (c *Config) checkTimeForkOrder() error {
+	timeForks := []forkPoint{
+		{name: "shanghaiTime", block: c.ShanghaiTime},
+		{name: "cancunTime", block: c.CancunTime},
+		{name: "shardingForkTime", block: c.ShardingForkTime, canSkip: true},
+	}
+	var lastFork forkPoint
+	for _, fork := range timeForks {
+		if fork.block != nil && c.TerminalTotalDifficulty == nil {
+			return fmt.Errorf("unsupported fork ordering: %v enabled at %v, but terminalTotalDifficulty not set", fork.name, fork.block)
+		}
+		if lastFork.name != "" {
+			if lastFork.block == nil && fork.block != nil {
+				return fmt.Errorf("unsupported fork ordering: %v not enabled, but %v enabled at %v",
+					lastFork.name, fork.name, fork.block)
+			}
+			if lastFork.block != nil && fork.block != nil && lastFork.block.Cmp(fork.block) > 0 {
+				return fmt.Errorf("unsupported fork ordering: %v enabled at %v, but %v enabled at %v",
+					lastFork.name, lastFork.block, fork.name, fork.block)
+			}
+		}
+		if !fork.canSkip || fork.block != nil {
+			lastFork = fork
+		}
+	}
+	return nil
+}
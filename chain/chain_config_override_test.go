@@ -0,0 +1,68 @@
+/*
+   Copyright 2023 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package chain
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithOverrides(t *testing.T) {
+	cfg := &Config{
+		ChainID:               big.NewInt(1337),
+		HomesteadBlock:        big.NewInt(0),
+		TangerineWhistleBlock: big.NewInt(0),
+		SpuriousDragonBlock:   big.NewInt(0),
+		ByzantiumBlock:        big.NewInt(0),
+		ConstantinopleBlock:   big.NewInt(0),
+		PetersburgBlock:       big.NewInt(0),
+		IstanbulBlock:         big.NewInt(0),
+		BerlinBlock:           big.NewInt(0),
+		LondonBlock:           big.NewInt(10),
+	}
+
+	overridden, err := cfg.WithOverrides(OverrideFork("londonBlock", 20))
+	require.NoError(t, err)
+	require.True(t, overridden.IsLondon(20))
+	require.False(t, overridden.IsLondon(19))
+	require.True(t, cfg.IsLondon(10), "original config must stay untouched")
+
+	_, err = cfg.WithOverrides(OverrideFork("berlinBlock", 30))
+	require.Error(t, err)
+
+	_, err = cfg.WithOverrides(DisableFork("berlinBlock"))
+	require.Error(t, err)
+
+	_, err = cfg.WithOverrides(OverrideFork("noSuchFork", 1))
+	require.Error(t, err)
+
+	_, err = cfg.WithOverrides(OverrideFork("shanghaiTime", 100))
+	require.Error(t, err, "time-based fork before The Merge")
+
+	cfg.TerminalTotalDifficulty = big.NewInt(0)
+	overridden, err = cfg.WithOverrides(OverrideFork("shanghaiTime", 100), OverrideFork("cancunTime", 200))
+	require.NoError(t, err)
+	require.True(t, overridden.IsShanghai(100))
+	require.False(t, overridden.IsCancun(199))
+
+	_, err = cfg.WithOverrides(OverrideFork("shanghaiTime", 300), OverrideFork("cancunTime", 200))
+	require.Error(t, err)
+	_, err = cfg.WithOverrides(OverrideFork("cancunTime", 200))
+	require.Error(t, err)
+}
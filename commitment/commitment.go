@@ -149,8 +149,8 @@ func EncodeBranch(bitmap, touchMap, afterMap uint16, retriveCell func(nibble int
 			if cell.spl > 0 {
 				fieldBits |= StoragePlainPart
 			}
-			if cell.hl > 0 {
-				fieldBits |= HashPart
+			if cell.hl > 0 && cell.spl == 0 {
+				fieldBits |= HashPart // hash of storage leaf is recomputed from its value, see Canonicalize
 			}
 			branchData = append(branchData, byte(fieldBits))
 			if cell.extLen > 0 && cell.spl == 0 {
@@ -168,7 +168,7 @@ func EncodeBranch(bitmap, touchMap, afterMap uint16, retriveCell func(nibble int
 				branchData = append(branchData, bitmapBuf[:n]...)
 				branchData = append(branchData, cell.spk[:cell.spl]...)
 			}
-			if cell.hl > 0 {
+			if cell.hl > 0 && cell.spl == 0 {
 				n := binary.PutUvarint(bitmapBuf[:], uint64(cell.hl))
 				branchData = append(branchData, bitmapBuf[:n]...)
 				branchData = append(branchData, cell.h[:cell.hl]...)
@@ -345,6 +345,47 @@ func (branchData BranchData) ReplacePlainKeys(accountPlainKeys [][]byte, storage
 	return newData, nil
 }
 
+// Canonicalize - minimal encoding of branchData: hashes of cells with storage plain key are dropped (hash of storage
+// leaf, often embedded small node, is recomputed from storage value by trie and never read from branch). Hashed key
+// is dropped for such cells as well, as EncodeBranch does. Canonical and non-canonical encodings decode into same trie,
+// so branches written by older versions are canonicalized on merge of files.
+func (branchData BranchData) Canonicalize(newData []byte) (BranchData, error) {
+	touchMap := binary.BigEndian.Uint16(branchData[0:])
+	afterMap := binary.BigEndian.Uint16(branchData[2:])
+	pos := 4
+	newData = append(newData, branchData[:4]...)
+	for bitset, j := touchMap&afterMap, 0; bitset != 0; j++ {
+		bit := bitset & -bitset
+		fieldBits := PartFlags(branchData[pos])
+		pos++
+		canonical := fieldBits
+		if fieldBits&StoragePlainPart != 0 {
+			canonical &^= HashedKeyPart | HashPart
+		}
+		newData = append(newData, byte(canonical))
+		for _, part := range []PartFlags{HashedKeyPart, AccountPlainPart, StoragePlainPart, HashPart} {
+			if fieldBits&part == 0 {
+				continue
+			}
+			l, n := binary.Uvarint(branchData[pos:])
+			if n == 0 {
+				return nil, fmt.Errorf("canonicalize buffer too small for field %d len", part)
+			} else if n < 0 {
+				return nil, fmt.Errorf("canonicalize value overflow for field %d len", part)
+			}
+			if len(branchData) < pos+n+int(l) {
+				return nil, fmt.Errorf("canonicalize buffer too small for field %d", part)
+			}
+			if canonical&part != 0 {
+				newData = append(newData, branchData[pos:pos+n+int(l)]...)
+			}
+			pos += n + int(l)
+		}
+		bitset ^= bit
+	}
+	return newData, nil
+}
+
 // IsComplete determines whether given branch data is complete, meaning that all information about all the children is present
 // Each of 16 children of a branch node have two attributes
 // touch - whether this child has been modified or deleted in this branchData (corresponding bit in touchMap is set)
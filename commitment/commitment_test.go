@@ -1,6 +1,7 @@
 package commitment
 
 import (
+	"encoding/binary"
 	"encoding/hex"
 	"fmt"
 	"math/rand"
@@ -199,3 +200,71 @@ func TestBranchData_ReplacePlainKeys(t *testing.T) {
 	require.True(t, len(shortApk) == len(rextA))
 	require.True(t, len(shortSpk) == len(rextS))
 }
+
+func TestBranchData_Canonicalize(t *testing.T) {
+	row, bm := generateCellRow(t, 16)
+
+	cg := func(nibble int, skip bool) (*Cell, error) {
+		return row[nibble], nil
+	}
+
+	enc, _, err := EncodeBranch(bm, bm, bm, cg)
+	require.NoError(t, err)
+
+	// encoding of older versions: hash and hashed key are written for cells with storage plain key too
+	var numBuf [binary.MaxVarintLen64]byte
+	legacy := BranchData(append([]byte{}, enc[:4]...))
+	putField := func(b []byte) {
+		legacy = append(legacy, numBuf[:binary.PutUvarint(numBuf[:], uint64(len(b)))]...)
+		legacy = append(legacy, b...)
+	}
+	for _, c := range row {
+		var fieldBits PartFlags
+		if c.extLen > 0 {
+			fieldBits |= HashedKeyPart
+		}
+		if c.apl > 0 {
+			fieldBits |= AccountPlainPart
+		}
+		if c.spl > 0 {
+			fieldBits |= StoragePlainPart
+		}
+		fieldBits |= HashPart
+		legacy = append(legacy, byte(fieldBits))
+		if c.extLen > 0 {
+			putField(c.extension[:c.extLen])
+		}
+		if c.apl > 0 {
+			putField(c.apk[:c.apl])
+		}
+		if c.spl > 0 {
+			putField(c.spk[:c.spl])
+		}
+		putField(c.h[:c.hl])
+	}
+
+	canonical, err := legacy.Canonicalize(nil)
+	require.NoError(t, err)
+	require.EqualValues(t, enc, canonical)
+
+	again, err := canonical.Canonicalize(nil)
+	require.NoError(t, err)
+	require.EqualValues(t, canonical, again)
+
+	legacyA, legacyS, err := legacy.ExtractPlainKeys()
+	require.NoError(t, err)
+	canonicalA, canonicalS, err := canonical.ExtractPlainKeys()
+	require.NoError(t, err)
+	require.EqualValues(t, legacyA, canonicalA)
+	require.EqualValues(t, legacyS, canonicalS)
+
+	_, _, cells, err := canonical.DecodeCells()
+	require.NoError(t, err)
+	for i, c := range cells {
+		if row[i].spl > 0 {
+			require.Zerof(t, c.hl, "hash of storage cell %d must be dropped", i)
+		} else {
+			require.EqualValues(t, row[i].h, c.h)
+		}
+	}
+}
@@ -24,6 +24,7 @@ import (
 	"hash"
 	"io"
 	"math/bits"
+	"sort"
 	"strings"
 
 	"github.com/holiman/uint256"
@@ -1362,6 +1363,19 @@ var (
 )
 
 func (s *state) Encode(buf []byte) ([]byte, error) {
+	ee := bytes.NewBuffer(buf)
+	if err := s.EncodeTo(ee); err != nil {
+		return nil, err
+	}
+	return ee.Bytes(), nil
+}
+
+func (s *state) Decode(buf []byte) error {
+	return s.DecodeFrom(bytes.NewReader(buf))
+}
+
+// EncodeTo - streaming version of Encode: writes encoded state to w without building it in one buffer
+func (s *state) EncodeTo(ee io.Writer) error {
 	var rootFlags stateRootFlag
 	if s.RootPresent {
 		rootFlags |= stateRootPresent
@@ -1373,34 +1387,33 @@ func (s *state) Encode(buf []byte) ([]byte, error) {
 		rootFlags |= stateRootTouched
 	}
 
-	ee := bytes.NewBuffer(buf)
 	if err := binary.Write(ee, binary.BigEndian, s.CurrentKeyLen); err != nil {
-		return nil, fmt.Errorf("encode currentKeyLen: %w", err)
+		return fmt.Errorf("encode currentKeyLen: %w", err)
 	}
 	if err := binary.Write(ee, binary.BigEndian, int8(rootFlags)); err != nil {
-		return nil, fmt.Errorf("encode rootFlags: %w", err)
+		return fmt.Errorf("encode rootFlags: %w", err)
 	}
 	if n, err := ee.Write(s.CurrentKey[:]); err != nil || n != len(s.CurrentKey) {
-		return nil, fmt.Errorf("encode currentKey: %w", err)
+		return fmt.Errorf("encode currentKey: %w", err)
 	}
 	if err := binary.Write(ee, binary.BigEndian, uint16(len(s.Root))); err != nil {
-		return nil, fmt.Errorf("encode root len: %w", err)
+		return fmt.Errorf("encode root len: %w", err)
 	}
 	if n, err := ee.Write(s.Root); err != nil || n != len(s.Root) {
-		return nil, fmt.Errorf("encode root: %w", err)
+		return fmt.Errorf("encode root: %w", err)
 	}
 	d := make([]byte, len(s.Depths))
 	for i := 0; i < len(s.Depths); i++ {
 		d[i] = byte(s.Depths[i])
 	}
 	if n, err := ee.Write(d); err != nil || n != len(s.Depths) {
-		return nil, fmt.Errorf("encode depths: %w", err)
+		return fmt.Errorf("encode depths: %w", err)
 	}
 	if err := binary.Write(ee, binary.BigEndian, s.TouchMap); err != nil {
-		return nil, fmt.Errorf("encode touchMap: %w", err)
+		return fmt.Errorf("encode touchMap: %w", err)
 	}
 	if err := binary.Write(ee, binary.BigEndian, s.AfterMap); err != nil {
-		return nil, fmt.Errorf("encode afterMap: %w", err)
+		return fmt.Errorf("encode afterMap: %w", err)
 	}
 
 	var before1, before2 uint64
@@ -1415,16 +1428,16 @@ func (s *state) Encode(buf []byte) ([]byte, error) {
 		}
 	}
 	if err := binary.Write(ee, binary.BigEndian, before1); err != nil {
-		return nil, fmt.Errorf("encode branchBefore_1: %w", err)
+		return fmt.Errorf("encode branchBefore_1: %w", err)
 	}
 	if err := binary.Write(ee, binary.BigEndian, before2); err != nil {
-		return nil, fmt.Errorf("encode branchBefore_2: %w", err)
+		return fmt.Errorf("encode branchBefore_2: %w", err)
 	}
-	return ee.Bytes(), nil
+	return nil
 }
 
-func (s *state) Decode(buf []byte) error {
-	aux := bytes.NewBuffer(buf)
+// DecodeFrom - streaming version of Decode: reads encoded state from aux
+func (s *state) DecodeFrom(aux io.Reader) error {
 	if err := binary.Read(aux, binary.BigEndian, &s.CurrentKeyLen); err != nil {
 		return fmt.Errorf("currentKeyLen: %w", err)
 	}
@@ -1442,7 +1455,7 @@ func (s *state) Decode(buf []byte) error {
 	if rootFlags&stateRootChecked != 0 {
 		s.RootChecked = true
 	}
-	if n, err := aux.Read(s.CurrentKey[:]); err != nil || n != 128 {
+	if _, err := io.ReadFull(aux, s.CurrentKey[:]); err != nil {
 		return fmt.Errorf("currentKey: %w", err)
 	}
 	var rootSize uint16
@@ -1450,7 +1463,7 @@ func (s *state) Decode(buf []byte) error {
 		return fmt.Errorf("root size: %w", err)
 	}
 	s.Root = make([]byte, rootSize)
-	if _, err := aux.Read(s.Root); err != nil {
+	if _, err := io.ReadFull(aux, s.Root); err != nil {
 		return fmt.Errorf("root: %w", err)
 	}
 	d := make([]byte, len(s.Depths))
@@ -1577,12 +1590,22 @@ func (c *Cell) decodeBytes(buf []byte) error {
 
 // Encode current state of hph into bytes
 func (hph *HexPatriciaHashed) EncodeCurrentState(buf []byte) ([]byte, error) {
-	s := state{
+	s := hph.currentState()
+	return s.Encode(buf)
+}
+
+// EncodeCurrentStateTo - streaming version of EncodeCurrentState: writes encoded state of hph to w
+func (hph *HexPatriciaHashed) EncodeCurrentStateTo(w io.Writer) error {
+	s := hph.currentState()
+	return s.EncodeTo(w)
+}
+
+func (hph *HexPatriciaHashed) currentState() *state {
+	s := &state{
 		CurrentKeyLen: int8(hph.currentKeyLen),
 		RootChecked:   hph.rootChecked,
 		RootTouched:   hph.rootTouched,
 		RootPresent:   hph.rootPresent,
-		Root:          make([]byte, 0),
 	}
 
 	s.Root = hph.root.bytes()
@@ -1591,18 +1614,22 @@ func (hph *HexPatriciaHashed) EncodeCurrentState(buf []byte) ([]byte, error) {
 	copy(s.BranchBefore[:], hph.branchBefore[:])
 	copy(s.TouchMap[:], hph.touchMap[:])
 	copy(s.AfterMap[:], hph.afterMap[:])
-
-	return s.Encode(buf)
+	return s
 }
 
 // buf expected to be encoded hph state. Decode state and set up hph to that state.
 func (hph *HexPatriciaHashed) SetState(buf []byte) error {
+	return hph.SetStateFrom(bytes.NewReader(buf))
+}
+
+// SetStateFrom - streaming version of SetState: reads encoded hph state from r and sets up hph to that state.
+func (hph *HexPatriciaHashed) SetStateFrom(r io.Reader) error {
 	if hph.activeRows != 0 {
 		return fmt.Errorf("has active rows, could not reset state")
 	}
 
 	var s state
-	if err := s.Decode(buf); err != nil {
+	if err := s.DecodeFrom(r); err != nil {
 		return err
 	}
 
@@ -1626,6 +1653,67 @@ func (hph *HexPatriciaHashed) SetState(buf []byte) error {
 	return nil
 }
 
+// RollbackTo - moves trie back to state `buf` (encoded by EncodeCurrentState at point of unwind) without SeekCommitment and
+// replay: only subtrees of `touchedSince` (plain keys changed after that point) are recomputed, with values given by
+// accountFn/storageFn - they must be already unwound. Branches are read by branchFn as they are now (not unwound):
+// returned branch updates restore them. Error if resulting root hash is not root hash of `buf`: then trie is reset
+// and must be set up again (SetState).
+func (hph *HexPatriciaHashed) RollbackTo(buf []byte, touchedSince [][]byte) (branchNodeUpdates map[string]BranchData, err error) {
+	if hph.activeRows != 0 {
+		return nil, fmt.Errorf("has active rows, could not rollback state")
+	}
+	var s state
+	if err = s.Decode(buf); err != nil {
+		return nil, err
+	}
+	var root Cell
+	if err = root.decodeBytes(s.Root); err != nil {
+		return nil, err
+	}
+	expectHash, err := hph.computeCellHash(&root, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	plainKeys := make([][]byte, 0, len(touchedSince))
+	hashedKeys := make([][]byte, 0, len(touchedSince))
+	seen := make(map[string]struct{}, len(touchedSince))
+	for _, key := range touchedSince {
+		if _, ok := seen[string(key)]; ok {
+			continue
+		}
+		seen[string(key)] = struct{}{}
+		plainKeys = append(plainKeys, key)
+		hashedKeys = append(hashedKeys, hph.hashAndNibblizeKey(key))
+	}
+	sort.Sort(&keysByHash{plainKeys: plainKeys, hashedKeys: hashedKeys}) // trie is walked in order of hashed keys
+
+	rootHash, branchNodeUpdates, err := hph.ReviewKeys(plainKeys, hashedKeys)
+	if err != nil {
+		hph.Reset()
+		return nil, fmt.Errorf("rollback: %w", err)
+	}
+	if !bytes.Equal(rootHash, expectHash[1:]) {
+		hph.Reset()
+		return nil, fmt.Errorf("rollback: root hash %x, expected %x - touched keys are incomplete or state is not unwound", rootHash, expectHash[1:])
+	}
+	if err = hph.SetState(buf); err != nil {
+		return nil, err
+	}
+	return branchNodeUpdates, nil
+}
+
+type keysByHash struct {
+	plainKeys, hashedKeys [][]byte
+}
+
+func (k *keysByHash) Len() int           { return len(k.hashedKeys) }
+func (k *keysByHash) Less(i, j int) bool { return bytes.Compare(k.hashedKeys[i], k.hashedKeys[j]) < 0 }
+func (k *keysByHash) Swap(i, j int) {
+	k.plainKeys[i], k.plainKeys[j] = k.plainKeys[j], k.plainKeys[i]
+	k.hashedKeys[i], k.hashedKeys[j] = k.hashedKeys[j], k.hashedKeys[i]
+}
+
 func bytesToUint64(buf []byte) (x uint64) {
 	for i, b := range buf {
 		x = x<<8 + uint64(b)
@@ -1798,19 +1886,18 @@ func (hph *HexPatriciaHashed) ProcessUpdates(plainKeys, hashedKeys [][]byte, upd
 	return rootHash, branchNodeUpdates, nil
 }
 
-// nolint
 // Hashes provided key and expands resulting hash into nibbles (each byte split into two nibbles by 4 bits)
 func (hph *HexPatriciaHashed) hashAndNibblizeKey(key []byte) []byte {
 	hashedKey := make([]byte, length.Hash)
 
 	hph.keccak.Reset()
-	hph.keccak.Write(key[:length.Addr])
+	hph.keccak.Write(key[:hph.accountKeyLen])
 	copy(hashedKey[:length.Hash], hph.keccak.Sum(nil))
 
-	if len(key[length.Addr:]) > 0 {
+	if len(key[hph.accountKeyLen:]) > 0 {
 		hashedKey = append(hashedKey, make([]byte, length.Hash)...)
 		hph.keccak.Reset()
-		hph.keccak.Write(key[length.Addr:])
+		hph.keccak.Write(key[hph.accountKeyLen:])
 		copy(hashedKey[length.Hash:], hph.keccak.Sum(nil))
 	}
 
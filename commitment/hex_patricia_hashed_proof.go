@@ -0,0 +1,311 @@
+/*
+   Copyright 2023 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package commitment
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+
+	"github.com/ledgerwatch/erigon-lib/common/length"
+)
+
+// Proof - Merkle proof of one key in format of eth_getProof: RLP encoded trie nodes on the path from the root to the
+// key. Nodes embedded into their parent (shorter than 32 bytes) are not listed separately.
+// Proof of storage key starts from storage root of the account, proof of the account itself is separate key.
+//
+// Proof of absent key (Absent is true) ends by node which proves non-membership: branch node with empty child at
+// the next nibble of the key, or leaf/extension node with key diverging from the key. Empty trie (storage of absent
+// account or of account without storage) has no nodes.
+type Proof struct {
+	PlainKey, HashedKey []byte
+	Nodes               [][]byte
+	Absent              bool
+	StorageRoot         []byte // for present account - root hash of it's storage trie
+}
+
+// ProveKeys - proofs of present and absent keys. Committed trie is read same way as ReviewKeys does (by branchFn,
+// accountFn and storageFn), but nothing is modified: must be called when there are no pending updates - after
+// ReviewKeys/ProcessUpdates or SetState.
+func (hph *HexPatriciaHashed) ProveKeys(plainKeys, hashedKeys [][]byte) ([]*Proof, error) {
+	proofs := make([]*Proof, len(hashedKeys))
+	for i, hashedKey := range hashedKeys {
+		p := &Proof{PlainKey: plainKeys[i], HashedKey: hashedKey}
+		if err := hph.proveKey(p); err != nil {
+			return nil, fmt.Errorf("prove key %x: %w", plainKeys[i], err)
+		}
+		if hph.trace {
+			fmt.Printf("proof of [%x]: absent=%t, nodes=%d\n", hashedKey, p.Absent, len(p.Nodes))
+		}
+		proofs[i] = p
+	}
+	return proofs, nil
+}
+
+// proveKey - walks from the root down to p.HashedKey. `cell` refers to the node at position `depth` of the key.
+func (hph *HexPatriciaHashed) proveKey(p *Proof) error {
+	hashedKey := p.HashedKey
+	storageKey := len(hashedKey) > 64
+	cell := new(Cell)
+	*cell = hph.root
+	if !hph.rootChecked && cell.hl == 0 && cell.extLen == 0 && cell.apl == 0 && cell.spl == 0 {
+		// Root is not known to this instance, it's branch node stored under empty prefix (or trie is empty)
+		cell.hl = length.Hash
+	}
+	depth := 0
+	storageTrie := false
+	add := func(node []byte) {
+		if storageTrie == storageKey && (len(p.Nodes) == 0 || len(node) >= length.Hash) {
+			p.Nodes = append(p.Nodes, node)
+		}
+	}
+	var keyBuf [129]byte
+	row := new([16]Cell)
+	for {
+		switch {
+		case cell.apl > 0 && depth <= 64:
+			if err := hph.accountFn(cell.apk[:cell.apl], cell); err != nil {
+				return fmt.Errorf("accountFn for key %x failed: %w", cell.apk[:cell.apl], err)
+			}
+			storageRoot := new(Cell)
+			storageRoot.fillStorageRoot(cell)
+			storageRootHash, err := hph.storageRootHash(storageRoot)
+			if err != nil {
+				return err
+			}
+			if err = hashKey(hph.keccak, cell.apk[:cell.apl], keyBuf[:], depth); err != nil {
+				return err
+			}
+			node := hph.accountLeafNode(cell, keyBuf[:64-depth], storageRootHash)
+			add(node)
+			if !bytes.Equal(keyBuf[:64-depth], hashedKey[depth:64]) {
+				p.Absent = true
+				return nil
+			}
+			if !storageKey {
+				p.StorageRoot = storageRootHash
+				return nil
+			}
+			cell, depth, storageTrie = storageRoot, 64, true
+		case cell.spl > 0 && depth >= 64:
+			if err := hph.storageFn(cell.spk[:cell.spl], cell); err != nil {
+				return fmt.Errorf("storageFn for key %x failed: %w", cell.spk[:cell.spl], err)
+			}
+			node, err := hph.storageLeafNode(cell, depth, keyBuf[:])
+			if err != nil {
+				return err
+			}
+			add(node)
+			p.Absent = !bytes.Equal(keyBuf[:128-depth], hashedKey[depth:])
+			return nil
+		case cell.extLen > 0:
+			add(extensionNode(cell.extension[:cell.extLen], cell.h[:cell.hl]))
+			if !bytes.HasPrefix(hashedKey[depth:], cell.extension[:cell.extLen]) {
+				p.Absent = true
+				return nil
+			}
+			depth += cell.extLen
+			fallthrough
+		case cell.hl > 0:
+			afterMap, err := hph.loadProofBranch(hashedKey[:depth], row)
+			if err != nil {
+				return err
+			}
+			if afterMap == 0 {
+				if depth == 0 {
+					p.Absent = true
+					return nil
+				}
+				return fmt.Errorf("branch node [%x] not found", hashedKey[:depth])
+			}
+			node, err := hph.branchNode(afterMap, depth+1, row)
+			if err != nil {
+				return err
+			}
+			add(node)
+			nibble := hashedKey[depth]
+			if afterMap&(uint16(1)<<nibble) == 0 {
+				p.Absent = true
+				return nil
+			}
+			next := new(Cell)
+			*next = row[nibble]
+			cell, depth = next, depth+1
+		default:
+			p.Absent = true
+			return nil
+		}
+	}
+}
+
+// fillStorageRoot - cell referring storage trie root of account cell `acc` (at depth 64)
+func (cell *Cell) fillStorageRoot(acc *Cell) {
+	cell.fillEmpty()
+	switch {
+	case acc.spl > 0:
+		cell.spl = acc.spl
+		copy(cell.spk[:], acc.spk[:acc.spl])
+		cell.setStorage(acc.Storage[:acc.StorageLen])
+	case acc.hl > 0:
+		cell.extLen = acc.extLen
+		copy(cell.extension[:], acc.extension[:acc.extLen])
+		cell.hl = acc.hl
+		copy(cell.h[:], acc.h[:acc.hl])
+	}
+}
+
+// storageRootHash - same as computeCellHash does for account cell
+func (hph *HexPatriciaHashed) storageRootHash(root *Cell) ([]byte, error) {
+	switch {
+	case root.spl > 0:
+		var keyBuf [129]byte
+		node, err := hph.storageLeafNode(root, 64, keyBuf[:])
+		if err != nil {
+			return nil, err
+		}
+		return hph.nodeHash(node)
+	case root.extLen > 0:
+		return hph.nodeHash(extensionNode(root.extension[:root.extLen], root.h[:root.hl]))
+	case root.hl > 0:
+		return append([]byte{}, root.h[:root.hl]...), nil
+	default:
+		return append([]byte{}, EmptyRootHash...), nil
+	}
+}
+
+func (hph *HexPatriciaHashed) nodeHash(node []byte) ([]byte, error) {
+	hph.keccak.Reset()
+	if _, err := hph.keccak.Write(node); err != nil {
+		return nil, err
+	}
+	h := make([]byte, length.Hash)
+	if _, err := hph.keccak.Read(h); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// loadProofBranch - like unfoldBranchNode, but into `row` and without touching the grid
+func (hph *HexPatriciaHashed) loadProofBranch(prefix []byte, row *[16]Cell) (afterMap uint16, err error) {
+	branchData, err := hph.branchFn(hexToCompact(prefix))
+	if err != nil {
+		return 0, err
+	}
+	if len(branchData) == 0 {
+		return 0, nil
+	}
+	if len(branchData) < 2 {
+		return 0, fmt.Errorf("branch node [%x] too short: [%x]", prefix, branchData)
+	}
+	afterMap = binary.BigEndian.Uint16(branchData[0:])
+	pos := 2
+	for bitset := afterMap; bitset != 0; {
+		bit := bitset & -bitset
+		nibble := bits.TrailingZeros16(bit)
+		cell := &row[nibble]
+		cell.fillEmpty()
+		if pos >= len(branchData) {
+			return 0, fmt.Errorf("branch node [%x] too short: [%x]", prefix, branchData)
+		}
+		fieldBits := branchData[pos]
+		pos++
+		if pos, err = cell.fillFromFields(branchData, pos, PartFlags(fieldBits)); err != nil {
+			return 0, fmt.Errorf("prefix [%x], branchData[%x]: %w", prefix, branchData, err)
+		}
+		if cell.apl > 0 {
+			if err = hph.accountFn(cell.apk[:cell.apl], cell); err != nil {
+				return 0, fmt.Errorf("accountFn for key %x failed: %w", cell.apk[:cell.apl], err)
+			}
+		}
+		if cell.spl > 0 {
+			if err = hph.storageFn(cell.spk[:cell.spl], cell); err != nil {
+				return 0, fmt.Errorf("storageFn for key %x failed: %w", cell.spk[:cell.spl], err)
+			}
+		}
+		bitset ^= bit
+	}
+	return afterMap, nil
+}
+
+// branchNode - 17 items: references to children (hash or embedded node) and empty value
+func (hph *HexPatriciaHashed) branchNode(afterMap uint16, depth int, row *[16]Cell) ([]byte, error) {
+	payload := make([]byte, 0, 17*(length.Hash+1))
+	for nibble := 0; nibble < 16; nibble++ {
+		if afterMap&(uint16(1)<<nibble) == 0 {
+			payload = append(payload, 0x80)
+			continue
+		}
+		ref, err := hph.computeCellHash(&row[nibble], depth, hph.hashAuxBuffer[:0])
+		if err != nil {
+			return nil, err
+		}
+		payload = append(payload, ref...)
+	}
+	payload = append(payload, 0x80)
+	return appendRlpList(nil, payload), nil
+}
+
+func (hph *HexPatriciaHashed) accountLeafNode(cell *Cell, key []byte, storageRootHash []byte) []byte {
+	var valBuf [128]byte
+	valLen := cell.accountForHashing(valBuf[:], *(*[length.Hash]byte)(storageRootHash))
+	return leafNode(key, valBuf[:valLen])
+}
+
+// storageLeafNode - also leaves hashed key of the leaf (from `depth`) in keyBuf
+func (hph *HexPatriciaHashed) storageLeafNode(cell *Cell, depth int, keyBuf []byte) ([]byte, error) {
+	if err := hashKey(hph.keccak, cell.spk[hph.accountKeyLen:cell.spl], keyBuf, depth-64); err != nil {
+		return nil, err
+	}
+	return leafNode(keyBuf[:128-depth], appendRlpString(nil, cell.Storage[:cell.StorageLen])), nil
+}
+
+func leafNode(key, val []byte) []byte {
+	hexKey := make([]byte, len(key)+1)
+	copy(hexKey, key)
+	hexKey[len(key)] = 16 // terminator
+	payload := appendRlpString(nil, hexToCompact(hexKey))
+	return appendRlpList(nil, appendRlpString(payload, val))
+}
+
+func extensionNode(key, hash []byte) []byte {
+	payload := appendRlpString(nil, hexToCompact(key))
+	return appendRlpList(nil, appendRlpString(payload, hash))
+}
+
+func appendRlpString(buf, s []byte) []byte {
+	if len(s) == 1 && s[0] < 0x80 {
+		return append(buf, s[0])
+	}
+	return append(appendRlpPrefix(buf, 0x80, len(s)), s...)
+}
+
+func appendRlpList(buf, payload []byte) []byte {
+	return append(appendRlpPrefix(buf, 0xc0, len(payload)), payload...)
+}
+
+func appendRlpPrefix(buf []byte, offset byte, l int) []byte {
+	if l < 56 {
+		return append(buf, offset+byte(l))
+	}
+	lenBytes := (bits.Len(uint(l)) + 7) / 8
+	buf = append(buf, offset+55+byte(lenBytes))
+	for i := lenBytes - 1; i >= 0; i-- {
+		buf = append(buf, byte(l>>(8*i)))
+	}
+	return buf
+}
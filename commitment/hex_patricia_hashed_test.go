@@ -17,14 +17,18 @@
 package commitment
 
 import (
+	"bytes"
 	"encoding/hex"
 	"fmt"
 	"math/rand"
 	"testing"
 
 	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/sha3"
 
+	"github.com/ledgerwatch/erigon-lib/common"
 	"github.com/ledgerwatch/erigon-lib/common/length"
+	"github.com/ledgerwatch/erigon-lib/rlp"
 )
 
 func Test_HexPatriciaHashed_ResetThenSingularUpdates(t *testing.T) {
@@ -521,3 +525,235 @@ func Test_HexPatriciaHashed_ProcessUpdates_UniqueRepresentation_AfterStateRestor
 		"expected equal roots, got sequential [%v] != batch [%v]", hex.EncodeToString(roots[len(roots)-1]), hex.EncodeToString(batchRoot))
 	require.Lenf(t, batchRoot, 32, "root hash length should be equal to 32 bytes")
 }
+
+// verifyProof - walks proof nodes as eth_getProof client does, returns value of the key or nil if key is absent
+func verifyProof(t *testing.T, rootHash, hashedKey []byte, nodes [][]byte) []byte {
+	t.Helper()
+	if bytes.Equal(rootHash, EmptyRootHash) {
+		require.Empty(t, nodes)
+		return nil
+	}
+	keccak := sha3.NewLegacyKeccak256()
+	hashOf := func(node []byte) []byte {
+		keccak.Reset()
+		keccak.Write(node)
+		return keccak.Sum(nil)
+	}
+	require.NotEmpty(t, nodes)
+	require.Equal(t, rootHash, hashOf(nodes[0]), "root node")
+	node, next, pos := nodes[0], 1, 0
+	for {
+		var items [][]byte // raw encodings of items
+		listPos, _, err := rlp.List(node, 0)
+		require.NoError(t, err)
+		itemPos := listPos
+		_, err = rlp.ListItems(node, 0, func(dataPos, dataLen int, isList bool) error {
+			items = append(items, node[itemPos:dataPos+dataLen])
+			itemPos = dataPos + dataLen
+			return nil
+		})
+		require.NoError(t, err)
+		var child []byte
+		switch len(items) {
+		case 17:
+			child = items[hashedKey[pos]]
+			pos++
+		case 2:
+			dataPos, dataLen, err := rlp.String(items[0], 0)
+			require.NoError(t, err)
+			compact := items[0][dataPos : dataPos+dataLen]
+			path := CompactedKeyToHex(compact)
+			if compact[0]&0x20 != 0 { // leaf
+				require.Equal(t, next, len(nodes), "leaf must be last node")
+				if !bytes.Equal(path[:len(path)-1], hashedKey[pos:]) {
+					return nil
+				}
+				dataPos, dataLen, err = rlp.String(items[1], 0)
+				require.NoError(t, err)
+				return items[1][dataPos : dataPos+dataLen]
+			}
+			if !bytes.HasPrefix(hashedKey[pos:], path) {
+				require.Equal(t, next, len(nodes), "diverging extension must be last node")
+				return nil
+			}
+			pos += len(path)
+			child = items[1]
+		default:
+			t.Fatalf("unexpected node with %d items: [%x]", len(items), node)
+		}
+		if len(child) == 1 && child[0] == 0x80 {
+			require.Equal(t, next, len(nodes), "empty child must be in last node")
+			return nil
+		}
+		if child[0] >= 0xc0 { // embedded node
+			node = child
+			continue
+		}
+		require.Less(t, next, len(nodes), "proof is too short")
+		require.Equal(t, child[1:], hashOf(nodes[next]))
+		node = nodes[next]
+		next++
+	}
+}
+
+func Test_HexPatriciaHashed_ProveKeys(t *testing.T) {
+	ms := NewMockState(t)
+	hph := NewHexPatriciaHashed(1, ms.branchFn, ms.accountFn, ms.storageFn)
+	plainKeys, hashedKeys, updates := NewUpdateBuilder().
+		Balance("00", 4).
+		Balance("01", 5).
+		Balance("02", 6).
+		Balance("03", 7).
+		Balance("04", 8).
+		Storage("04", "01", "0401").
+		Storage("03", "56", "050505").
+		Storage("03", "57", "060606").
+		Storage("03", "58", "07").
+		Balance("05", 9).
+		Storage("05", "02", "8989").
+		Nonce("06", 1).
+		Build()
+	require.NoError(t, ms.applyPlainUpdates(plainKeys, updates))
+	rootHash, branchNodeUpdates, err := hph.ReviewKeys(plainKeys, hashedKeys)
+	require.NoError(t, err)
+	ms.applyBranchNodeUpdates(branchNodeUpdates)
+
+	absentPlainKeys, absentHashedKeys, _ := NewUpdateBuilder().
+		Balance("0a", 1).
+		Balance("ff", 1).
+		Storage("03", "99", "01"). // account with storage
+		Storage("05", "03", "01"). // account with one slot
+		Storage("00", "01", "01"). // account without storage
+		Storage("0a", "01", "01"). // absent account
+		Build()
+
+	// fresh instance reads root from db
+	fresh := NewHexPatriciaHashed(1, ms.branchFn, ms.accountFn, ms.storageFn)
+	for _, trie := range []*HexPatriciaHashed{hph, fresh} {
+		storageRoots := map[string][]byte{}
+		check := func(plainKeys, hashedKeys [][]byte, absent bool) {
+			proofs, err := trie.ProveKeys(plainKeys, hashedKeys)
+			require.NoError(t, err)
+			// accounts go first - to collect storage roots
+			for _, storage := range []bool{false, true} {
+				for i, p := range proofs {
+					if (len(hashedKeys[i]) > 64) != storage {
+						continue
+					}
+					require.Equal(t, absent, p.Absent, "key %x", plainKeys[i])
+					root, key := rootHash, hashedKeys[i]
+					if storage {
+						key = key[64:]
+						var ok bool
+						if root, ok = storageRoots[string(plainKeys[i][:1])]; !ok {
+							root = EmptyRootHash
+						}
+					} else if !absent {
+						storageRoots[string(plainKeys[i])] = p.StorageRoot
+					}
+					val := verifyProof(t, root, key, p.Nodes)
+					require.Equal(t, absent, val == nil, "key %x", plainKeys[i])
+					if storage && !absent {
+						var cell Cell
+						require.NoError(t, ms.storageFn(plainKeys[i], &cell))
+						require.Equal(t, appendRlpString(nil, cell.Storage[:cell.StorageLen]), val)
+					}
+				}
+			}
+		}
+		check(plainKeys, hashedKeys, false)
+		check(absentPlainKeys, absentHashedKeys, true)
+		require.Equal(t, EmptyRootHash, storageRoots["\x00"])
+	}
+}
+
+func Test_HexPatriciaHashed_RollbackTo(t *testing.T) {
+	ms := NewMockState(t)
+	plainKeys, hashedKeys, updates := NewUpdateBuilder().
+		Balance("f5", 4).
+		Balance("ff", 900234).
+		Balance("04", 1233).
+		Storage("04", "01", "0401").
+		Balance("ba", 065606).
+		Balance("03", 7).
+		Storage("03", "56", "050505").
+		Balance("05", 9).
+		Storage("05", "02", "8989").
+		Build()
+	require.NoError(t, ms.applyPlainUpdates(plainKeys, updates))
+	hph := NewHexPatriciaHashed(1, ms.branchFn, ms.accountFn, ms.storageFn)
+	rootBefore, branchUpdates, err := hph.ReviewKeys(plainKeys, hashedKeys)
+	require.NoError(t, err)
+	ms.applyBranchNodeUpdates(branchUpdates)
+	stateBefore, err := hph.EncodeCurrentState(nil)
+	require.NoError(t, err)
+	plainBefore := make(map[string][]byte, len(ms.sm))
+	for k, v := range ms.sm {
+		plainBefore[k] = common.Copy(v)
+	}
+
+	plainKeys, hashedKeys, updates = NewUpdateBuilder().
+		Balance("ff", 1).
+		Balance("b9", 6).
+		Storage("04", "01", "0402").
+		Storage("b9", "03", "0303").
+		Delete("05").
+		DeleteStorage("05", "02").
+		DeleteStorage("03", "56").
+		Build()
+	require.NoError(t, ms.applyPlainUpdates(plainKeys, updates))
+	rootAfter, branchUpdates, err := hph.ReviewKeys(plainKeys, hashedKeys)
+	require.NoError(t, err)
+	ms.applyBranchNodeUpdates(branchUpdates)
+	require.NotEqualValues(t, rootBefore, rootAfter)
+
+	// unwind: plain state is restored, branches are not (fns of trie hold copy of MockState: same maps)
+	for k := range ms.sm {
+		delete(ms.sm, k)
+	}
+	for k, v := range plainBefore {
+		ms.sm[k] = v
+	}
+	branchUpdates, err = hph.RollbackTo(stateBefore, plainKeys)
+	require.NoError(t, err)
+	ms.applyBranchNodeUpdates(branchUpdates)
+	root, err := hph.RootHash()
+	require.NoError(t, err)
+	require.EqualValues(t, rootBefore, root)
+
+	// rolled back trie and branches are same as never advanced ones
+	ms2 := NewMockState(t)
+	plainKeys, hashedKeys, updates = NewUpdateBuilder().
+		Balance("f5", 4).
+		Balance("ff", 900234).
+		Balance("04", 1233).
+		Storage("04", "01", "0401").
+		Balance("ba", 065606).
+		Balance("03", 7).
+		Storage("03", "56", "050505").
+		Balance("05", 9).
+		Storage("05", "02", "8989").
+		Build()
+	require.NoError(t, ms2.applyPlainUpdates(plainKeys, updates))
+	hph2 := NewHexPatriciaHashed(1, ms2.branchFn, ms2.accountFn, ms2.storageFn)
+	_, branchUpdates, err = hph2.ReviewKeys(plainKeys, hashedKeys)
+	require.NoError(t, err)
+	ms2.applyBranchNodeUpdates(branchUpdates)
+
+	plainKeys, hashedKeys, updates = NewUpdateBuilder().
+		Balance("ba", 2).
+		Storage("05", "02", "0101").
+		Balance("b9", 7).
+		Build()
+	require.NoError(t, ms.applyPlainUpdates(plainKeys, updates))
+	require.NoError(t, ms2.applyPlainUpdates(plainKeys, updates))
+	root, _, err = hph.ReviewKeys(plainKeys, hashedKeys)
+	require.NoError(t, err)
+	root2, _, err := hph2.ReviewKeys(plainKeys, hashedKeys)
+	require.NoError(t, err)
+	require.EqualValues(t, root2, root)
+
+	// incomplete list of touched keys is detected
+	_, err = hph.RollbackTo(stateBefore, plainKeys[:1])
+	require.Error(t, err)
+}
@@ -52,6 +52,12 @@ func BigToAddress(b *big.Int) Address { return BytesToAddress(b.Bytes()) }
 // If s is larger than len(h), s will be cropped from the left.
 func HexToAddress(s string) Address { return BytesToAddress(hexutility.FromHex(s)) }
 
+// AddressFromHex - strict version of HexToAddress: s must be 0x-prefixed hex of exactly 20 bytes, checksum is not verified
+func AddressFromHex(s string) (a Address, err error) {
+	err = hexutility.DecodeFixed(s, a[:])
+	return a, err
+}
+
 // IsHexAddress verifies whether a string can represent a valid hex-encoded
 // Ethereum address or not.
 func IsHexAddress(s string) bool {
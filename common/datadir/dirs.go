@@ -17,7 +17,12 @@
 package datadir
 
 import (
+	"errors"
+	"fmt"
+	"os"
 	"path/filepath"
+
+	"github.com/ledgerwatch/erigon-lib/common/dir"
 )
 
 // Dirs is the file system folder the node should use for any data storage
@@ -33,6 +38,7 @@ type Dirs struct {
 	SnapHistory     string
 	TxPool          string
 	Nodes           string
+	Downloader      string
 }
 
 func New(datadir string) Dirs {
@@ -55,5 +61,81 @@ func New(datadir string) Dirs {
 		SnapHistory:     filepath.Join(datadir, "snapshots", "history"),
 		TxPool:          filepath.Join(datadir, "txpool"),
 		Nodes:           filepath.Join(datadir, "nodes"),
+		Downloader:      filepath.Join(datadir, "downloader"),
+	}
+}
+
+// Open - New, then creates missing dirs, applies layout Migrations and checks that all dirs are writable.
+// Result can be passed to NewAggregatorV3FromDirs and friends instead of separate paths.
+func Open(datadir string) (Dirs, error) {
+	dirs := New(datadir)
+	if err := dirs.MkdirAll(); err != nil {
+		return dirs, err
+	}
+	if err := dirs.Migrate(Migrations); err != nil {
+		return dirs, err
+	}
+	if err := dirs.CheckWritable(); err != nil {
+		return dirs, err
+	}
+	return dirs, nil
+}
+
+const dirPerm = 0764 // user rwx, group rw, other r - same as dir.MustExist
+
+// All - managed dirs, DataDir first
+func (d Dirs) All() []string {
+	return []string{d.DataDir, d.Chaindata, d.Tmp, d.Snap, d.SnapHistory, d.TxPool, d.Nodes, d.Downloader}
+}
+
+func (d Dirs) MkdirAll() error {
+	for _, path := range d.All() {
+		if err := os.MkdirAll(path, dirPerm); err != nil {
+			return fmt.Errorf("datadir: %w", err)
+		}
+	}
+	return nil
+}
+
+var (
+	ErrNotWritable  = errors.New("datadir: dir is not writable")
+	ErrLowFreeSpace = errors.New("datadir: low free space")
+)
+
+// CheckWritable - creates and removes file in each dir, returns ErrNotWritable if it's not possible
+func (d Dirs) CheckWritable() error {
+	for _, path := range d.All() {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("datadir: %w", err)
+		}
+		if !fi.IsDir() {
+			return fmt.Errorf("%w: %s is not a dir", ErrNotWritable, path)
+		}
+		f, err := os.CreateTemp(path, ".writable-*")
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrNotWritable, err)
+		}
+		name := f.Name()
+		f.Close()
+		if err = os.Remove(name); err != nil {
+			return fmt.Errorf("%w: %s", ErrNotWritable, err)
+		}
+	}
+	return nil
+}
+
+// CheckFreeSpace - returns ErrLowFreeSpace if filesystem of any dir has less than `min` bytes available.
+// Dirs may be symlinks to different disks, so each one is checked.
+func (d Dirs) CheckFreeSpace(min uint64) error {
+	for _, path := range d.All() {
+		free, err := dir.FreeSpace(path)
+		if err != nil {
+			return fmt.Errorf("datadir: %w", err)
+		}
+		if free < min {
+			return fmt.Errorf("%w: %d bytes available in %s, need %d", ErrLowFreeSpace, free, path, min)
+		}
 	}
+	return nil
 }
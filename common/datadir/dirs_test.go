@@ -0,0 +1,60 @@
+/*
+   Copyright 2023 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package datadir
+
+import (
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpen(t *testing.T) {
+	root := t.TempDir()
+	// old layout: downloader db inside snapshots
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "snapshots", "db"), 0764))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "snapshots", "db", "mdbx.dat"), []byte{1}, 0644))
+
+	dirs, err := Open(root)
+	require.NoError(t, err)
+	for _, path := range dirs.All() {
+		fi, err := os.Stat(path)
+		require.NoError(t, err)
+		require.True(t, fi.IsDir())
+	}
+	require.FileExists(t, filepath.Join(dirs.Downloader, "mdbx.dat"))
+	require.NoDirExists(t, filepath.Join(dirs.Snap, "db"))
+
+	// migration is applied once
+	calls := 0
+	m := []Migration{{Name: "test", Apply: func(Dirs) error { calls++; return nil }}}
+	require.NoError(t, dirs.Migrate(m))
+	require.NoError(t, dirs.Migrate(m))
+	require.Equal(t, 1, calls)
+	_, err = Open(root)
+	require.NoError(t, err)
+
+	require.NoError(t, dirs.CheckFreeSpace(0))
+	require.True(t, errors.Is(dirs.CheckFreeSpace(math.MaxUint64), ErrLowFreeSpace))
+
+	require.NoError(t, os.RemoveAll(dirs.Nodes))
+	require.NoError(t, os.WriteFile(dirs.Nodes, []byte{1}, 0644))
+	require.True(t, errors.Is(dirs.CheckWritable(), ErrNotWritable))
+}
@@ -0,0 +1,107 @@
+/*
+   Copyright 2023 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package datadir
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ledgerwatch/erigon-lib/common/dir"
+)
+
+// Migration - change of files layout inside DataDir. Must be idempotent: process may be killed before
+// migration is recorded as applied.
+type Migration struct {
+	Name  string
+	Apply func(d Dirs) error
+}
+
+// Migrations - applied by Open, in order
+var Migrations = []Migration{
+	{Name: "downloader_db_out_of_snapshots", Apply: downloaderDBOutOfSnapshots},
+}
+
+// layoutFile - names of applied migrations, one per line
+const layoutFile = "layout.migrations"
+
+func (d Dirs) appliedMigrations() (map[string]struct{}, error) {
+	applied := map[string]struct{}{}
+	f, err := os.Open(filepath.Join(d.DataDir, layoutFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return applied, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if name := strings.TrimSpace(sc.Text()); name != "" {
+			applied[name] = struct{}{}
+		}
+	}
+	return applied, sc.Err()
+}
+
+// Migrate - applies not yet applied `migrations` and records them in DataDir
+func (d Dirs) Migrate(migrations []Migration) error {
+	applied, err := d.appliedMigrations()
+	if err != nil {
+		return fmt.Errorf("datadir migrations: %w", err)
+	}
+	for _, m := range migrations {
+		if _, ok := applied[m.Name]; ok {
+			continue
+		}
+		if err := m.Apply(d); err != nil {
+			return fmt.Errorf("datadir migration %s: %w", m.Name, err)
+		}
+		f, err := os.OpenFile(filepath.Join(d.DataDir, layoutFile), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return fmt.Errorf("datadir migration %s: %w", m.Name, err)
+		}
+		_, err = f.WriteString(m.Name + "\n")
+		if err == nil {
+			err = f.Sync()
+		}
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("datadir migration %s: %w", m.Name, err)
+		}
+	}
+	return nil
+}
+
+// downloaderDBOutOfSnapshots - downloader's db used to live in snapshots/db, now it's Dirs.Downloader
+func downloaderDBOutOfSnapshots(d Dirs) error {
+	from := filepath.Join(d.Snap, "db")
+	if !dir.Exist(from) {
+		return nil
+	}
+	if dir.HasFileOfType(d.Downloader, ".dat") {
+		return fmt.Errorf("both %s and %s have downloader db, remove one of them", from, d.Downloader)
+	}
+	if err := os.Remove(d.Downloader); err != nil && !os.IsNotExist(err) { // only empty dir created by MkdirAll
+		return err
+	}
+	return os.Rename(from, d.Downloader)
+}
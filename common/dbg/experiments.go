@@ -17,247 +17,82 @@
 package dbg
 
 import (
-	"os"
+	"fmt"
 	"runtime"
 	"strconv"
-	"sync"
 	"time"
-
-	"github.com/ledgerwatch/log/v3"
 )
 
-var doMemstat = true
+var noMemstat = func() *flag[bool] {
+	f := envBool("NO_MEMSTAT")
+	f.fromEnv = func(string) (bool, error) { return true, nil } // any value
+	return f
+}()
 
-func init() {
-	_, ok := os.LookupEnv("NO_MEMSTAT")
-	if ok {
-		doMemstat = false
-	}
-}
-
-func DoMemStat() bool { return doMemstat }
+func DoMemStat() bool { return !noMemstat.Get() }
 func ReadMemStats(m *runtime.MemStats) {
-	if doMemstat {
+	if DoMemStat() {
 		runtime.ReadMemStats(m)
 	}
 }
 
 var (
-	writeMap     bool
-	writeMapOnce sync.Once
-)
-
-func WriteMap() bool {
-	writeMapOnce.Do(func() {
-		v, _ := os.LookupEnv("WRITE_MAP")
-		if v == "true" {
-			writeMap = true
-			log.Info("[Experiment]", "WRITE_MAP", writeMap)
-		}
-	})
-	return writeMap
-}
-
-var (
-	dirtySace     uint64
-	dirtySaceOnce sync.Once
-)
-
-func DirtySpace() uint64 {
-	dirtySaceOnce.Do(func() {
-		v, _ := os.LookupEnv("MDBX_DIRTY_SPACE_MB")
-		if v != "" {
-			i, err := strconv.Atoi(v)
-			if err != nil {
-				panic(err)
-			}
-			dirtySace = uint64(i * 1024 * 1024)
-			log.Info("[Experiment]", "MDBX_DIRTY_SPACE_MB", dirtySace)
-		}
-	})
-	return dirtySace
-}
-
-var (
-	noSync     bool
-	noSyncOnce sync.Once
-)
-
-func NoSync() bool {
-	noSyncOnce.Do(func() {
-		v, _ := os.LookupEnv("NO_SYNC")
-		if v == "true" {
-			noSync = true
-			log.Info("[Experiment]", "NO_SYNC", noSync)
+	writeMap         = envBool("WRITE_MAP")
+	dirtySpace       = envUint("MDBX_DIRTY_SPACE_MB", 1024*1024)
+	noSync           = envBool("NO_SYNC")
+	mdbxReadahead    = envBool("MDBX_READAHEAD")
+	discardHistory   = envBool("DISCARD_HISTORY")
+	bigRoTx          = envUint("DEBUG_BIG_RO_TX_KB", 1)
+	bigRwTx          = envUint("DEBUG_BIG_RW_TX_KB", 1)
+	slowCommit       = envMs("DEBUG_SLOW_COMMIT_MS")
+	stopBeforeStage  = envString("STOP_BEFORE_STAGE") // see names in eth/stagedsync/stages/stages.go
+	stopAfterStage   = envString("STOP_AFTER_STAGE")  // see names in eth/stagedsync/stages/stages.go
+	stopAfterReconst = envBool("STOP_AFTER_RECONSTITUTE")
+	strictState      = envBool("STRICT_STATE")
+	indexBloomBits   = envUint("ERIGON_INDEX_BLOOM_BITS", 1)
+	indexFingerprint = envBool("ERIGON_INDEX_FINGERPRINTS")
+	mergeTr          = newFlag("MERGE_THRESHOLD", func(s string) (int, error) {
+		i, err := strconv.Atoi(s)
+		if err != nil {
+			return 0, err
 		}
-	})
-	return noSync
-}
-
-var (
-	mergeTr     int
-	mergeTrOnce sync.Once
-)
-
-func MergeTr() int {
-	mergeTrOnce.Do(func() {
-		v, _ := os.LookupEnv("MERGE_THRESHOLD")
-		if v != "" {
-			i, err := strconv.Atoi(v)
-			if err != nil {
-				panic(err)
-			}
-			if i < 0 || i > 4 {
-				panic(i)
-			}
-			mergeTr = i
-			log.Info("[Experiment]", "MERGE_THRESHOLD", mergeTr)
+		if i < 0 || i > 4 {
+			return 0, fmt.Errorf("expected value in [0, 4]: %d", i)
 		}
+		return i, nil
 	})
-	return mergeTr
-}
-
-var (
-	mdbxReadahead     bool
-	mdbxReadaheadOnce sync.Once
 )
 
-func MdbxReadAhead() bool {
-	mdbxReadaheadOnce.Do(func() {
-		v, _ := os.LookupEnv("MDBX_READAHEAD")
-		if v == "true" {
-			mdbxReadahead = true
-			log.Info("[Experiment]", "MDBX_READAHEAD", mdbxReadahead)
-		}
-	})
-	return mdbxReadahead
-}
-
-var (
-	discardHistory     bool
-	discardHistoryOnce sync.Once
-)
-
-func DiscardHistory() bool {
-	discardHistoryOnce.Do(func() {
-		v, _ := os.LookupEnv("DISCARD_HISTORY")
-		if v == "true" {
-			discardHistory = true
-			log.Info("[Experiment]", "DISCARD_HISTORY", discardHistory)
-		}
-	})
-	return discardHistory
-}
-
-var (
-	bigRoTx    uint
-	getBigRoTx sync.Once
-)
+func WriteMap() bool       { return writeMap.Get() }
+func DirtySpace() uint64   { return dirtySpace.Get() }
+func NoSync() bool         { return noSync.Get() }
+func MergeTr() int         { return mergeTr.Get() }
+func MdbxReadAhead() bool  { return mdbxReadahead.Get() }
+func DiscardHistory() bool { return discardHistory.Get() }
 
 // DEBUG_BIG_RO_TX_KB - print logs with info about large read-only transactions
 // DEBUG_BIG_RW_TX_KB - print logs with info about large read-write transactions
 // DEBUG_SLOW_COMMIT_MS - print logs with commit timing details if commit is slower than this threshold
-func BigRoTxKb() uint {
-	getBigRoTx.Do(func() {
-		v, _ := os.LookupEnv("DEBUG_BIG_RO_TX_KB")
-		if v != "" {
-			i, err := strconv.Atoi(v)
-			if err != nil {
-				panic(err)
-			}
-			bigRoTx = uint(i)
-			log.Info("[Experiment]", "DEBUG_BIG_RO_TX_KB", bigRoTx)
-		}
-	})
-	return bigRoTx
-}
-
-var (
-	bigRwTx    uint
-	getBigRwTx sync.Once
-)
-
-func BigRwTxKb() uint {
-	getBigRwTx.Do(func() {
-		v, _ := os.LookupEnv("DEBUG_BIG_RW_TX_KB")
-		if v != "" {
-			i, err := strconv.Atoi(v)
-			if err != nil {
-				panic(err)
-			}
-			bigRwTx = uint(i)
-			log.Info("[Experiment]", "DEBUG_BIG_RW_TX_KB", bigRwTx)
-		}
-	})
-	return bigRwTx
-}
-
-var (
-	slowCommit    time.Duration
-	getSlowCommit sync.Once
-)
-
-func SlowCommit() time.Duration {
-	getSlowCommit.Do(func() {
-		v, _ := os.LookupEnv("DEBUG_SLOW_COMMIT_MS")
-		if v != "" {
-			i, err := strconv.Atoi(v)
-			if err != nil {
-				panic(err)
-			}
-			slowCommit = time.Duration(i) * time.Millisecond
-			log.Info("[Experiment]", "DEBUG_BIG_RW_TX_KB", slowCommit)
-		}
-	})
-	return slowCommit
-}
-
-var (
-	stopBeforeStage     string
-	stopBeforeStageFlag sync.Once
-	stopAfterStage      string
-	stopAfterStageFlag  sync.Once
-)
-
-func StopBeforeStage() string {
-	f := func() {
-		v, _ := os.LookupEnv("STOP_BEFORE_STAGE") // see names in eth/stagedsync/stages/stages.go
-		if v != "" {
-			stopBeforeStage = v
-			log.Info("[Experiment]", "STOP_BEFORE_STAGE", stopBeforeStage)
-		}
-	}
-	stopBeforeStageFlag.Do(f)
-	return stopBeforeStage
-}
+func BigRoTxKb() uint           { return uint(bigRoTx.Get()) }
+func BigRwTxKb() uint           { return uint(bigRwTx.Get()) }
+func SlowCommit() time.Duration { return slowCommit.Get() }
+func StopBeforeStage() string   { return stopBeforeStage.Get() }
 
 // TODO(allada) We should possibly consider removing `STOP_BEFORE_STAGE`, as `STOP_AFTER_STAGE` can
 // perform all same the functionality, but due to reverse compatibility reasons we are going to
 // leave it.
-func StopAfterStage() string {
-	f := func() {
-		v, _ := os.LookupEnv("STOP_AFTER_STAGE") // see names in eth/stagedsync/stages/stages.go
-		if v != "" {
-			stopAfterStage = v
-			log.Info("[Experiment]", "STOP_AFTER_STAGE", stopAfterStage)
-		}
-	}
-	stopAfterStageFlag.Do(f)
-	return stopAfterStage
-}
+func StopAfterStage() string { return stopAfterStage.Get() }
+func StopAfterReconst() bool { return stopAfterReconst.Get() }
 
-var (
-	stopAfterReconst     bool
-	stopAfterReconstOnce sync.Once
-)
+// STRICT_STATE - check invariants of state files and writes at runtime, panic on first violation
+func StrictState() bool { return strictState.Get() }
 
-func StopAfterReconst() bool {
-	stopAfterReconstOnce.Do(func() {
-		v, _ := os.LookupEnv("STOP_AFTER_RECONSTITUTE")
-		if v == "true" {
-			stopAfterReconst = true
-			log.Info("[Experiment]", "STOP_AFTER_RECONSTITUTE", writeMap)
-		}
-	})
-	return stopAfterReconst
-}
+// ERIGON_INDEX_BLOOM_BITS - bits per key of bloom filter embedded into .efi/.kvi files built by this process:
+// most lookups of rarely changed keys are in files which don't have them, filter skips such files without read of
+// data file. 0 - no filter (default), see recsplit.RecSplitArgs.BloomBitsPerKey
+func IndexBloomBitsPerKey() int { return int(indexBloomBits.Get()) }
+
+// ERIGON_INDEX_FINGERPRINTS - .efi/.kvi/.vi files built by this process keep fingerprint of key in each record: lookup
+// of key which is not in file is rejected by index, without read of data file and comparison of keys.
+// Costs 8 bytes per key, see recsplit.RecSplitArgs.Fingerprints
+func IndexFingerprints() bool { return indexFingerprint.Get() }
@@ -0,0 +1,165 @@
+/*
+   Copyright 2023 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package dbg
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ledgerwatch/log/v3"
+	"go.uber.org/atomic"
+)
+
+// flag - debug flag: initialized from env variable (of same name) at first read, can be changed at runtime by SetFlag.
+// Values read once (for example at DB open) are not affected by SetFlag - only next readers see new value.
+type flag[T any] struct {
+	name    string
+	fromEnv func(string) (T, error) // env parsing has own rules for backward compatibility
+	parse   func(string) (T, error)
+	format  func(T) string // inverse of parse
+	once    sync.Once
+	v       atomic.Value
+}
+
+// flagI - type-erased flag for SetFlag/Snapshot
+type flagI interface {
+	set(s string) error
+	String() string
+}
+
+var (
+	flags     = map[string]flagI{}
+	flagsLock sync.Mutex
+)
+
+func newFlag[T any](name string, parse func(string) (T, error)) *flag[T] {
+	fromEnv := func(s string) (T, error) {
+		if s == "" { // empty env variable - same as unset
+			var zero T
+			return zero, nil
+		}
+		return parse(s)
+	}
+	f := &flag[T]{name: name, parse: parse, fromEnv: fromEnv, format: func(v T) string { return fmt.Sprintf("%v", v) }}
+	flagsLock.Lock()
+	defer flagsLock.Unlock()
+	flags[name] = f
+	return f
+}
+
+func (f *flag[T]) load() {
+	var v T
+	if s, ok := os.LookupEnv(f.name); ok { // fromEnv decides about empty value: bool flags like NO_MEMSTAT are enabled by it
+		var err error
+		if v, err = f.fromEnv(s); err != nil {
+			panic(fmt.Errorf("env %s: %w", f.name, err))
+		}
+		log.Info("[Experiment]", f.name, v)
+	}
+	f.v.Store(v)
+}
+
+func (f *flag[T]) Get() T {
+	f.once.Do(f.load)
+	return f.v.Load().(T)
+}
+
+func (f *flag[T]) Set(v T) {
+	f.once.Do(f.load)
+	f.v.Store(v)
+	log.Info("[dbg] flag changed", f.name, v)
+}
+
+func (f *flag[T]) set(s string) error {
+	v, err := f.parse(s)
+	if err != nil {
+		return fmt.Errorf("flag %s: %w", f.name, err)
+	}
+	f.Set(v)
+	return nil
+}
+
+func (f *flag[T]) String() string { return f.format(f.Get()) }
+
+// SetFlag - changes debug flag at runtime. `name` - name of env variable of flag (for example "STRICT_STATE"),
+// `value` - in format of env variable.
+func SetFlag(name, value string) error {
+	flagsLock.Lock()
+	f, ok := flags[name]
+	flagsLock.Unlock()
+	if !ok {
+		return fmt.Errorf("unknown debug flag: %s", name)
+	}
+	return f.set(value)
+}
+
+// Snapshot - current values of all debug flags: name -> value
+func Snapshot() map[string]string {
+	flagsLock.Lock()
+	all := make(map[string]flagI, len(flags))
+	for name, f := range flags {
+		all[name] = f
+	}
+	flagsLock.Unlock()
+	res := make(map[string]string, len(all))
+	for name, f := range all {
+		res[name] = f.String() // reads env of not yet used flags
+	}
+	return res
+}
+
+// envBool - env variables of bool flags are enabled only by "true" value
+func envBool(name string) *flag[bool] {
+	f := newFlag(name, strconv.ParseBool)
+	f.fromEnv = func(s string) (bool, error) { return s == "true", nil }
+	return f
+}
+
+func envString(name string) *flag[string] {
+	return newFlag(name, func(s string) (string, error) { return s, nil })
+}
+
+// envUint - `unit` multiplier of value in env variable (for example 1024 for KB)
+func envUint(name string, unit uint64) *flag[uint64] {
+	f := newFlag(name, func(s string) (uint64, error) {
+		i, err := strconv.Atoi(s)
+		if err != nil {
+			return 0, err
+		}
+		if i < 0 {
+			return 0, fmt.Errorf("negative value: %d", i)
+		}
+		return uint64(i) * unit, nil
+	})
+	f.format = func(v uint64) string { return strconv.FormatUint(v/unit, 10) }
+	return f
+}
+
+func envMs(name string) *flag[time.Duration] {
+	f := newFlag(name, func(s string) (time.Duration, error) {
+		i, err := strconv.Atoi(s)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(i) * time.Millisecond, nil
+	})
+	f.format = func(v time.Duration) string { return strconv.FormatInt(v.Milliseconds(), 10) }
+	return f
+}
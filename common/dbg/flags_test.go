@@ -0,0 +1,60 @@
+/*
+   Copyright 2023 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package dbg
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetFlag(t *testing.T) {
+	for _, name := range []string{"DEBUG_SLOW_COMMIT_MS", "STRICT_STATE", "MDBX_DIRTY_SPACE_MB"} {
+		prev := Snapshot()[name]
+		name := name
+		t.Cleanup(func() { require.NoError(t, SetFlag(name, prev)) })
+	}
+
+	require.NoError(t, SetFlag("DEBUG_SLOW_COMMIT_MS", "200"))
+	require.Equal(t, 200*time.Millisecond, SlowCommit())
+	require.Equal(t, "200", Snapshot()["DEBUG_SLOW_COMMIT_MS"])
+
+	require.NoError(t, SetFlag("STRICT_STATE", "true"))
+	require.True(t, StrictState())
+	require.Equal(t, "true", Snapshot()["STRICT_STATE"])
+
+	require.Error(t, SetFlag("MERGE_THRESHOLD", "5"))
+	require.Error(t, SetFlag("NO_SUCH_FLAG", "1"))
+
+	require.NoError(t, SetFlag("MDBX_DIRTY_SPACE_MB", "2"))
+	require.Equal(t, uint64(2*1024*1024), DirtySpace())
+	require.Equal(t, "2", Snapshot()["MDBX_DIRTY_SPACE_MB"])
+}
+
+func TestFlagFromEnv(t *testing.T) {
+	// not registered copies of flags: env is read at first Get
+	t.Setenv("DEBUG_SLOW_COMMIT_MS", "50")
+	require.Equal(t, 50*time.Millisecond, (&flag[time.Duration]{name: "DEBUG_SLOW_COMMIT_MS", fromEnv: slowCommit.fromEnv}).Get())
+	t.Setenv("DEBUG_SLOW_COMMIT_MS", "") // empty - same as unset
+	require.Zero(t, (&flag[time.Duration]{name: "DEBUG_SLOW_COMMIT_MS", fromEnv: slowCommit.fromEnv}).Get())
+
+	t.Setenv("STRICT_STATE", "")
+	require.False(t, (&flag[bool]{name: "STRICT_STATE", fromEnv: strictState.fromEnv}).Get())
+	t.Setenv("NO_MEMSTAT", "") // any value, even empty, disables memstat
+	require.True(t, (&flag[bool]{name: "NO_MEMSTAT", fromEnv: noMemstat.fromEnv}).Get())
+}
@@ -61,6 +61,12 @@ func BigToHash(b *big.Int) Hash { return BytesToHash(b.Bytes()) }
 // If b is larger than len(h), b will be cropped from the left.
 func HexToHash(s string) Hash { return BytesToHash(hexutility.FromHex(s)) }
 
+// HashFromHex - strict version of HexToHash: s must be 0x-prefixed hex of exactly 32 bytes, see hexutility.DecodeFixed
+func HashFromHex(s string) (h Hash, err error) {
+	err = hexutility.DecodeFixed(s, h[:])
+	return h, err
+}
+
 // Bytes gets the byte representation of the underlying hash.
 func (h Hash) Bytes() []byte { return h[:] }
 
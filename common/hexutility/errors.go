@@ -20,6 +20,11 @@ var (
 	ErrMissingPrefix = &decError{"hex string without 0x prefix"}
 	ErrOddLength     = &decError{"hex string of odd length"}
 	ErrSyntax        = &decError{"invalid hex string"}
+	ErrWrongLength   = &decError{"hex string of wrong length"}
+	ErrEmptyNumber   = &decError{"hex string \"0x\""}
+	ErrLeadingZero   = &decError{"hex number with leading zero digits"}
+	ErrUint64Range   = &decError{"hex number > 64 bits"}
+	ErrUint256Range  = &decError{"hex number > 256 bits"}
 )
 
 type decError struct{ msg string }
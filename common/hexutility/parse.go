@@ -0,0 +1,105 @@
+/*
+   Copyright 2022 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package hexutility
+
+import "github.com/holiman/uint256"
+
+// Strict parsers of user/rpc input: unlike FromHex/HexToHash they don't pad, crop or ignore invalid input,
+// return one of errors.go (no wrapping - compare by ==) and don't allocate. `out` is not modified on error.
+// UnmarshalFixedText (json/text of common.Hash, common.Address, ...) decodes by same code.
+
+// DecodeFixed - 0x-prefixed hex of exactly len(out) bytes (hashes, addresses, ...)
+func DecodeFixed(input string, out []byte) error {
+	if !Has0xPrefix(input) {
+		return ErrMissingPrefix
+	}
+	raw := input[2:]
+	if len(raw)%2 != 0 {
+		return ErrOddLength
+	}
+	if len(raw) != 2*len(out) {
+		return ErrWrongLength
+	}
+	return decodeFixed(raw, out)
+}
+
+// decodeFixed - hex without prefix, len(raw) == 2*len(out)
+func decodeFixed[T string | []byte](raw T, out []byte) error {
+	for i := 0; i < len(raw); i++ {
+		if decodeNibble(raw[i]) == badNibble {
+			return ErrSyntax
+		}
+	}
+	for i := range out {
+		out[i] = byte(decodeNibble(raw[2*i])<<4 | decodeNibble(raw[2*i+1]))
+	}
+	return nil
+}
+
+// checkNumber - 0x-prefixed hex quantity without leading zeros, at most maxDigits digits
+func checkNumber(input string, maxDigits int, errRange error) (string, error) {
+	if !Has0xPrefix(input) {
+		return "", ErrMissingPrefix
+	}
+	raw := input[2:]
+	if len(raw) == 0 {
+		return "", ErrEmptyNumber
+	}
+	if len(raw) > 1 && raw[0] == '0' {
+		return "", ErrLeadingZero
+	}
+	if len(raw) > maxDigits {
+		return "", errRange
+	}
+	return raw, nil
+}
+
+// DecodeUint64 - 0x-prefixed hex quantity (as in json-rpc: no leading zeros) of at most 64 bits
+func DecodeUint64(input string) (uint64, error) {
+	raw, err := checkNumber(input, 16, ErrUint64Range)
+	if err != nil {
+		return 0, err
+	}
+	var v uint64
+	for i := 0; i < len(raw); i++ {
+		nib := decodeNibble(raw[i])
+		if nib == badNibble {
+			return 0, ErrSyntax
+		}
+		v = v<<4 | nib
+	}
+	return v, nil
+}
+
+// DecodeUint256 - 0x-prefixed hex quantity (as in json-rpc: no leading zeros) of at most 256 bits
+func DecodeUint256(input string, out *uint256.Int) error {
+	raw, err := checkNumber(input, 64, ErrUint256Range)
+	if err != nil {
+		return err
+	}
+	var v uint256.Int
+	for i := 0; i < len(raw); i++ {
+		nib := decodeNibble(raw[i])
+		if nib == badNibble {
+			return ErrSyntax
+		}
+		pos := len(raw) - 1 - i // nibbles from least significant
+		v[pos/16] |= nib << (4 * (pos % 16))
+	}
+	*out = v
+	return nil
+}
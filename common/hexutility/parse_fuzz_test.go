@@ -0,0 +1,94 @@
+//go:build !nofuzz
+
+/*
+   Copyright 2022 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package hexutility
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/holiman/uint256"
+)
+
+// go test -trimpath -v -fuzz=FuzzDecodeFixed -fuzztime=10s ./common/hexutility
+
+func FuzzDecodeFixed(f *testing.F) {
+	f.Add("0x0102", 2)
+	f.Add("0x01zz", 2)
+	f.Add("0x", 0)
+	f.Fuzz(func(t *testing.T, input string, size int) {
+		if size < 0 || size > 64 {
+			return
+		}
+		out := make([]byte, size)
+		err := DecodeFixed(input, out)
+		// reference: lenient decoding + explicit checks
+		var want []byte
+		var wantErr error
+		if !Has0xPrefix(input) {
+			wantErr = ErrMissingPrefix
+		} else if want, wantErr = hex.DecodeString(input[2:]); wantErr == nil && len(want) != size {
+			wantErr = ErrWrongLength
+		}
+		if (err == nil) != (wantErr == nil) {
+			t.Fatalf("%q, size %d: err %v, reference err %v", input, size, err, wantErr)
+		}
+		if err == nil && !bytes.Equal(out, want) {
+			t.Fatalf("%q: %x != %x", input, out, want)
+		}
+		if err != nil && !bytes.Equal(out, make([]byte, size)) {
+			t.Fatalf("%q: out modified on error", input)
+		}
+	})
+}
+
+func FuzzDecodeUint64(f *testing.F) {
+	f.Add("0x0")
+	f.Add("0x01")
+	f.Add("0xffffffffffffffff")
+	f.Fuzz(func(t *testing.T, input string) {
+		v, err := DecodeUint64(input)
+		var v256 uint256.Int
+		err256 := DecodeUint256(input, &v256)
+		if (err == nil) != (err256 == nil && v256.IsUint64()) {
+			t.Fatalf("%q: err %v, DecodeUint256 err %v", input, err, err256)
+		}
+		if err == nil && v != v256.Uint64() {
+			t.Fatalf("%q: %d != %d", input, v, v256.Uint64())
+		}
+	})
+}
+
+func FuzzDecodeUint256(f *testing.F) {
+	f.Add("0x0")
+	f.Add("0x00")
+	f.Add("0x123456789abcdef0123456789abcdef")
+	f.Add("0x10000000000000000000000000000000000000000000000000000000000000000")
+	f.Fuzz(func(t *testing.T, input string) {
+		var v uint256.Int
+		err := DecodeUint256(input, &v)
+		want, wantErr := uint256.FromHex(input)
+		if (err == nil) != (wantErr == nil) {
+			t.Fatalf("%q: err %v, uint256.FromHex err %v", input, err, wantErr)
+		}
+		if err == nil && !v.Eq(want) {
+			t.Fatalf("%q: %s != %s", input, v.Hex(), want.Hex())
+		}
+	})
+}
@@ -0,0 +1,121 @@
+/*
+   Copyright 2022 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package hexutility
+
+import (
+	"testing"
+
+	"github.com/holiman/uint256"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeFixed(t *testing.T) {
+	for _, c := range []struct {
+		input string
+		size  int
+		want  []byte
+		err   error
+	}{
+		{"0x0102", 2, []byte{1, 2}, nil},
+		{"0XaBcD", 2, []byte{0xab, 0xcd}, nil},
+		{"0x", 0, []byte{}, nil},
+		{"", 2, nil, ErrMissingPrefix},
+		{"0102", 2, nil, ErrMissingPrefix},
+		{"0x010", 2, nil, ErrOddLength},
+		{"0x01", 2, nil, ErrWrongLength},
+		{"0x010203", 2, nil, ErrWrongLength},
+		{"0x01zz", 2, nil, ErrSyntax},
+	} {
+		out := make([]byte, c.size)
+		err := DecodeFixed(c.input, out)
+		require.Equal(t, c.err, err, c.input)
+		if err == nil {
+			require.Equal(t, c.want, out, c.input)
+		} else {
+			require.Equal(t, make([]byte, c.size), out, "out modified on error: %s", c.input)
+		}
+	}
+}
+
+func TestUnmarshalFixedText(t *testing.T) {
+	out := make([]byte, 2)
+	require.NoError(t, UnmarshalFixedText("test", []byte("0x0102"), out))
+	require.Equal(t, []byte{1, 2}, out)
+	require.Equal(t, ErrSyntax, UnmarshalFixedText("test", []byte("0x03zz"), out))
+	require.Equal(t, []byte{1, 2}, out) // not modified on error
+	require.Equal(t, ErrMissingPrefix, UnmarshalFixedText("test", []byte("0304"), out))
+	require.Error(t, UnmarshalFixedText("test", []byte("0x03"), out))
+}
+
+func TestDecodeUint64(t *testing.T) {
+	for _, c := range []struct {
+		input string
+		want  uint64
+		err   error
+	}{
+		{"0x0", 0, nil},
+		{"0x1", 1, nil},
+		{"0xFf", 255, nil},
+		{"0xffffffffffffffff", 1<<64 - 1, nil},
+		{"0x10000000000000000", 0, ErrUint64Range},
+		{"1", 0, ErrMissingPrefix},
+		{"0x", 0, ErrEmptyNumber},
+		{"0x01", 0, ErrLeadingZero},
+		{"0x1g", 0, ErrSyntax},
+	} {
+		v, err := DecodeUint64(c.input)
+		require.Equal(t, c.err, err, c.input)
+		require.Equal(t, c.want, v, c.input)
+	}
+}
+
+func TestDecodeUint256(t *testing.T) {
+	for _, c := range []struct {
+		input string
+		err   error
+	}{
+		{"0x0", nil},
+		{"0x1", nil},
+		{"0x123456789abcdef0123456789abcdef", nil},
+		{"0xffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff", nil},
+		{"0x10000000000000000000000000000000000000000000000000000000000000000", ErrUint256Range},
+		{"", ErrMissingPrefix},
+		{"0x", ErrEmptyNumber},
+		{"0x00", ErrLeadingZero},
+		{"0x-1", ErrSyntax},
+	} {
+		var v uint256.Int
+		err := DecodeUint256(c.input, &v)
+		require.Equal(t, c.err, err, c.input)
+		if err == nil {
+			require.Equal(t, c.input, v.Hex(), c.input)
+		}
+	}
+}
+
+func TestDecodeNoAllocs(t *testing.T) {
+	var out [32]byte
+	var v uint256.Int
+	allocs := testing.AllocsPerRun(100, func() {
+		_ = DecodeFixed("0x0102030405060708091011121314151617181920212223242526272829303132", out[:])
+		_ = DecodeFixed("0x01", out[:])
+		_, _ = DecodeUint64("0x1234")
+		_ = DecodeUint256("0x123456789abcdef0123456789abcdef", &v)
+		_ = DecodeUint256("0x00", &v)
+	})
+	require.Zero(t, allocs)
+}
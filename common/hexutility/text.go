@@ -17,7 +17,6 @@
 package hexutility
 
 import (
-	"encoding/hex"
 	"fmt"
 )
 
@@ -36,14 +35,7 @@ func UnmarshalFixedText(typname string, input, out []byte) error {
 	if len(raw)/2 != len(out) {
 		return fmt.Errorf("hex string has length %d, want %d for %s", len(raw), len(out)*2, typname)
 	}
-	// Pre-verify syntax before modifying out.
-	for _, b := range raw {
-		if decodeNibble(b) == badNibble {
-			return ErrSyntax
-		}
-	}
-	_, err = hex.Decode(out, raw)
-	return err
+	return decodeFixed(raw, out) // verifies syntax before modifying out
 }
 
 func checkText(input []byte, wantPrefix bool) ([]byte, error) {
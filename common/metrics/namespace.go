@@ -0,0 +1,167 @@
+/*
+   Copyright 2023 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package metrics
+
+import (
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	vm "github.com/VictoriaMetrics/metrics"
+	"go.uber.org/atomic"
+)
+
+// Namespace - metrics of one subsystem (kv, txpool, ...). Each namespace has own VictoriaMetrics Set (not default global one):
+// metrics are created lazily - at first update when namespace is enabled. Disabled namespace is not exported
+// by global vm.WritePrometheus and updates of it's metrics are no-op.
+// Namespaces are enabled by default: embedding app can disable them at any time - before or after subsystem is initialized.
+//
+// Metric names must be unique across namespaces.
+type Namespace struct {
+	name    string
+	set     *vm.Set
+	enabled atomic.Bool
+}
+
+var (
+	namespaces     = map[string]*Namespace{}
+	namespacesLock sync.Mutex
+)
+
+// GetOrCreateNamespace - returns registered namespace `name` or registers new one
+func GetOrCreateNamespace(name string) *Namespace {
+	namespacesLock.Lock()
+	defer namespacesLock.Unlock()
+	if ns, ok := namespaces[name]; ok {
+		return ns
+	}
+	ns := &Namespace{name: name, set: vm.NewSet()}
+	ns.enabled.Store(true)
+	vm.RegisterSet(ns.set)
+	namespaces[name] = ns
+	return ns
+}
+
+// Namespaces - all registered namespaces, sorted by name
+func Namespaces() []*Namespace {
+	namespacesLock.Lock()
+	defer namespacesLock.Unlock()
+	res := make([]*Namespace, 0, len(namespaces))
+	for _, ns := range namespaces {
+		res = append(res, ns)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].name < res[j].name })
+	return res
+}
+
+func (ns *Namespace) Name() string  { return ns.name }
+func (ns *Namespace) Enabled() bool { return ns.enabled.Load() }
+
+func (ns *Namespace) Enable() {
+	if ns.enabled.CAS(false, true) {
+		vm.RegisterSet(ns.set)
+	}
+}
+
+// Disable - stops export of namespace metrics and makes their updates no-op. Values are kept until Enable.
+func (ns *Namespace) Disable() {
+	if ns.enabled.CAS(true, false) {
+		vm.UnregisterSet(ns.set)
+	}
+}
+
+// WritePrometheus - writes metrics of namespace (even disabled) in Prometheus format
+func (ns *Namespace) WritePrometheus(w io.Writer) { ns.set.WritePrometheus(w) }
+
+// Counter - declares counter. It's created in namespace set at first update.
+func (ns *Namespace) Counter(name string) *Counter { return &Counter{ns: ns, name: name} }
+
+// Summary - declares summary. It's created in namespace set at first update.
+func (ns *Namespace) Summary(name string) *Summary { return &Summary{ns: ns, name: name} }
+
+type Counter struct {
+	ns   *Namespace
+	name string
+	once sync.Once
+	c    *vm.Counter
+}
+
+// get - nil if namespace is disabled
+func (c *Counter) get() *vm.Counter {
+	if !c.ns.Enabled() {
+		return nil
+	}
+	c.once.Do(func() { c.c = c.ns.set.GetOrCreateCounter(c.name) })
+	return c.c
+}
+
+func (c *Counter) Inc() {
+	if m := c.get(); m != nil {
+		m.Inc()
+	}
+}
+func (c *Counter) Dec() {
+	if m := c.get(); m != nil {
+		m.Dec()
+	}
+}
+func (c *Counter) Add(n int) {
+	if m := c.get(); m != nil {
+		m.Add(n)
+	}
+}
+func (c *Counter) Set(n uint64) {
+	if m := c.get(); m != nil {
+		m.Set(n)
+	}
+}
+
+// Get - 0 if namespace is disabled
+func (c *Counter) Get() uint64 {
+	if m := c.get(); m != nil {
+		return m.Get()
+	}
+	return 0
+}
+
+type Summary struct {
+	ns   *Namespace
+	name string
+	once sync.Once
+	s    *vm.Summary
+}
+
+// get - nil if namespace is disabled
+func (s *Summary) get() *vm.Summary {
+	if !s.ns.Enabled() {
+		return nil
+	}
+	s.once.Do(func() { s.s = s.ns.set.GetOrCreateSummary(s.name) })
+	return s.s
+}
+
+func (s *Summary) Update(v float64) {
+	if m := s.get(); m != nil {
+		m.Update(v)
+	}
+}
+func (s *Summary) UpdateDuration(startTime time.Time) {
+	if m := s.get(); m != nil {
+		m.UpdateDuration(startTime)
+	}
+}
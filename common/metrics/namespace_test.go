@@ -0,0 +1,52 @@
+/*
+   Copyright 2023 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package metrics
+
+import (
+	"bytes"
+	"testing"
+
+	vm "github.com/VictoriaMetrics/metrics"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNamespace(t *testing.T) {
+	ns := GetOrCreateNamespace("test_ns")
+	require.Same(t, ns, GetOrCreateNamespace("test_ns"))
+	c := ns.Counter(`test_ns_counter`)
+
+	exported := func() string {
+		var buf bytes.Buffer
+		vm.WritePrometheus(&buf, false)
+		return buf.String()
+	}
+	require.NotContains(t, exported(), "test_ns_counter") // lazy
+
+	c.Add(2)
+	require.Equal(t, uint64(2), c.Get())
+	require.Contains(t, exported(), "test_ns_counter 2")
+
+	ns.Disable()
+	c.Inc()
+	require.Equal(t, uint64(0), c.Get())
+	require.NotContains(t, exported(), "test_ns_counter")
+
+	ns.Enable()
+	c.Inc()
+	require.Equal(t, uint64(3), c.Get())
+	require.Contains(t, exported(), "test_ns_counter 3")
+}
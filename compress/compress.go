@@ -71,6 +71,7 @@ type Compressor struct {
 	Ratio            CompressionRatio
 	lvl              log.Lvl
 	trace            bool
+	metadata         []byte // see SetMetadata
 }
 
 func NewCompressor(ctx context.Context, logPrefix, outputFile, tmpDir string, minPatternScore uint64, workers int, lvl log.Lvl) (*Compressor, error) {
@@ -189,6 +190,11 @@ func (c *Compressor) Compress() error {
 		return err
 	}
 
+	if c.metadata != nil {
+		if err := AppendMetadata(c.tmpOutFilePath, c.metadata); err != nil {
+			return err
+		}
+	}
 	if err := os.Rename(c.tmpOutFilePath, c.outputFile); err != nil {
 		return fmt.Errorf("renaming: %w", err)
 	}
@@ -166,3 +166,56 @@ func TestCompressDict1(t *testing.T) {
 		t.Errorf("result file hash changed, %d", cs)
 	}
 }
+
+func TestWriteUncompressed(t *testing.T) {
+	var words [][]byte
+	for i := 0; i < 1000; i++ {
+		switch i % 4 {
+		case 0:
+			words = append(words, nil)
+		case 1:
+			words = append(words, []byte(fmt.Sprintf("word %d", i)))
+		default:
+			words = append(words, make([]byte, i%300))
+		}
+	}
+	source := func(yield func(word []byte) error) error {
+		for _, w := range words {
+			if err := yield(w); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	meta := []byte("meta")
+
+	for _, workers := range []int{1, 2} {
+		tmpDir := t.TempDir()
+		expectFile, file := filepath.Join(tmpDir, "expect"), filepath.Join(tmpDir, "streamed")
+		c, err := NewCompressor(context.Background(), t.Name(), expectFile, tmpDir, 100, workers, log.LvlDebug)
+		require.NoError(t, err)
+		c.SetMetadata(meta)
+		for _, w := range words {
+			require.NoError(t, c.AddUncompressedWord(w))
+		}
+		require.NoError(t, c.Compress())
+		c.Close()
+
+		count, err := WriteUncompressed(file, meta, source)
+		require.NoError(t, err)
+		require.Equal(t, len(words), count)
+		expect, err := os.ReadFile(expectFile)
+		require.NoError(t, err)
+		got, err := os.ReadFile(file)
+		require.NoError(t, err)
+		require.Equal(t, expect, got)
+	}
+
+	// source must be repeatable
+	calls := 0
+	_, err := WriteUncompressed(filepath.Join(t.TempDir(), "f"), nil, func(yield func(word []byte) error) error {
+		calls++
+		return yield(make([]byte, calls))
+	})
+	require.Error(t, err)
+}
@@ -0,0 +1,110 @@
+/*
+   Copyright 2023 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package compress
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/ledgerwatch/erigon-lib/etl"
+)
+
+// ErrConcatIncompatible - files have different dictionaries, their words can't be concatenated without re-compression
+var ErrConcatIncompatible = errors.New("files have different dictionaries")
+
+// Concat - creates `dst` with words of `srcs` (in order of srcs) without re-compression.
+//
+// Words are encoded by patterns and positions dictionaries, so segments of words can be stitched only if all
+// srcs have same dictionaries (produced from shared dictionary, or files of uncompressed words with same lengths):
+// then segments are copied as is and only header is rebuilt. Otherwise returns ErrConcatIncompatible (and doesn't create
+// `dst`) - caller must fallback to Compressor. Files without words are compatible with any file.
+//
+// Useful for trivial merges - where key ranges of files don't interleave.
+func Concat(dst string, srcs ...string) error {
+	ds := make([]*Decompressor, 0, len(srcs))
+	defer func() {
+		for _, d := range ds {
+			d.Close()
+		}
+	}()
+	var dict []byte // patterns and positions dictionaries
+	var wordsCount, emptyWordsCount uint64
+	for _, src := range srcs {
+		d, err := NewDecompressor(src)
+		if err != nil {
+			return err
+		}
+		ds = append(ds, d)
+		wordsCount += d.wordsCount
+		emptyWordsCount += d.emptyWordsCount
+		if d.wordsCount == 0 {
+			continue
+		}
+		if dict == nil {
+			dict = d.dictionaries()
+			continue
+		}
+		if !bytes.Equal(dict, d.dictionaries()) {
+			return fmt.Errorf("concat %s: %w", d.FileName(), ErrConcatIncompatible)
+		}
+	}
+	if dict == nil { // all files are empty
+		dict = make([]byte, 16) // sizes of empty dictionaries
+	}
+
+	tmpPath := dst + ".tmp"
+	defer os.Remove(tmpPath)
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", tmpPath, err)
+	}
+	defer f.Close()
+	w := bufio.NewWriterSize(f, 8*etl.BufIOSize)
+	var numBuf [16]byte
+	binary.BigEndian.PutUint64(numBuf[:8], wordsCount)
+	binary.BigEndian.PutUint64(numBuf[8:], emptyWordsCount)
+	if _, err = w.Write(numBuf[:]); err != nil {
+		return err
+	}
+	if _, err = w.Write(dict); err != nil {
+		return err
+	}
+	for _, d := range ds {
+		if _, err = w.Write(d.data[d.wordsStart:]); err != nil {
+			return err
+		}
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, dst)
+}
+
+// dictionaries - raw patterns and positions dictionaries (with their sizes) from file header
+func (d *Decompressor) dictionaries() []byte {
+	return d.data[16:d.wordsStart]
+}
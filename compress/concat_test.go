@@ -0,0 +1,85 @@
+/*
+   Copyright 2023 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package compress
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/ledgerwatch/log/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func compressUncompressedWords(t *testing.T, file string, from, to uint64, wordLen int) {
+	t.Helper()
+	c, err := NewCompressor(context.Background(), t.Name(), file, t.TempDir(), 1, 2, log.LvlDebug)
+	require.NoError(t, err)
+	defer c.Close()
+	for i := from; i < to; i++ {
+		w := make([]byte, wordLen)
+		binary.BigEndian.PutUint64(w[wordLen-8:], i)
+		require.NoError(t, c.AddUncompressedWord(w))
+	}
+	require.NoError(t, c.Compress())
+}
+
+func TestConcat(t *testing.T) {
+	dir := t.TempDir()
+
+	// same dictionary: file itself
+	d := prepareLoremDict(t)
+	src := d.FilePath()
+	d.Close()
+	dst := filepath.Join(dir, "lorem2")
+	require.NoError(t, Concat(dst, src, src))
+	d, err := NewDecompressor(dst)
+	require.NoError(t, err)
+	require.Equal(t, 2*len(loremStrings), d.Count())
+	g := d.MakeGetter()
+	var word []byte
+	for i := 0; g.HasNext(); i++ {
+		word, _ = g.Next(word[:0])
+		k := i % len(loremStrings)
+		require.Equal(t, fmt.Sprintf("%s %d", loremStrings[k], k), string(word))
+	}
+	d.Close()
+
+	// uncompressed words of same length
+	f1, f2 := filepath.Join(dir, "1"), filepath.Join(dir, "2")
+	compressUncompressedWords(t, f1, 0, 10, 8)
+	compressUncompressedWords(t, f2, 10, 25, 8)
+	dst = filepath.Join(dir, "12")
+	require.NoError(t, Concat(dst, f1, f2))
+	d, err = NewDecompressor(dst)
+	require.NoError(t, err)
+	require.Equal(t, 25, d.Count())
+	g = d.MakeGetter()
+	for i := uint64(0); g.HasNext(); i++ {
+		word, _ = g.NextUncompressed()
+		require.Equal(t, i, binary.BigEndian.Uint64(word))
+	}
+	d.Close()
+
+	// different dictionaries
+	f3 := filepath.Join(dir, "3")
+	compressUncompressedWords(t, f3, 25, 30, 16)
+	require.ErrorIs(t, Concat(filepath.Join(dir, "13"), f1, f3), ErrConcatIncompatible)
+	require.NoFileExists(t, filepath.Join(dir, "13"))
+}
@@ -23,6 +23,7 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/ledgerwatch/erigon-lib/common/dbg"
@@ -98,7 +99,9 @@ type posTable struct {
 
 // Decompressor provides access to the superstrings in a file produced by a compressor
 type Decompressor struct {
-	f               *os.File
+	f               *os.File // nil after ReleaseFile
+	fLock           sync.Mutex
+	closed          bool
 	mmapHandle2     *[mmap.MaxMapSize]byte // mmap handle for windows (this is used to close mmap)
 	dict            *patternTable
 	posDict         *posTable
@@ -106,6 +109,8 @@ type Decompressor struct {
 	mmapHandle1     []byte // mmap handle for unix (this is used to close mmap)
 	data            []byte // slice of correct size for the decompressor to work with
 	wordsStart      uint64 // Offset of whether the superstrings actually start
+	metadata        []byte // see Compressor.SetMetadata
+	checksum        uint32
 	size            int64
 	modTime         time.Time
 	wordsCount      uint64
@@ -171,7 +176,11 @@ func NewDecompressor(compressedFile string) (*Decompressor, error) {
 	}
 
 	// read patterns from file
-	d.data = d.mmapHandle1[:d.size]
+	dataLen, err := d.readMetadata(d.mmapHandle1[:d.size])
+	if err != nil {
+		return nil, err
+	}
+	d.data = d.mmapHandle1[:dataLen]
 	d.wordsCount = binary.BigEndian.Uint64(d.data[:8])
 	d.emptyWordsCount = binary.BigEndian.Uint64(d.data[8:16])
 	dictSize := binary.BigEndian.Uint64(d.data[16:24])
@@ -338,15 +347,56 @@ func (d *Decompressor) ModTime() time.Time {
 }
 
 func (d *Decompressor) Close() error {
+	d.fLock.Lock()
+	defer d.fLock.Unlock()
+	d.closed = true
 	if err := mmap.Munmap(d.mmapHandle1, d.mmapHandle2); err != nil {
 		return err
 	}
-	if err := d.f.Close(); err != nil {
-		return err
+	if d.f != nil {
+		if err := d.f.Close(); err != nil {
+			return err
+		}
+		d.f = nil
 	}
 	return nil
 }
 
+// ReleaseFile - closes file descriptor, but keeps file mapped: readers (getters) are not affected.
+// Allows to keep many files open without holding descriptor for each of them, see ReopenFile.
+func (d *Decompressor) ReleaseFile() error {
+	d.fLock.Lock()
+	defer d.fLock.Unlock()
+	if d.f == nil {
+		return nil
+	}
+	err := d.f.Close()
+	d.f = nil
+	return err
+}
+
+// ReopenFile - opens file descriptor released by ReleaseFile. Returns false if descriptor is open or decompressor closed.
+func (d *Decompressor) ReopenFile() (bool, error) {
+	d.fLock.Lock()
+	defer d.fLock.Unlock()
+	if d.f != nil || d.closed {
+		return false, nil
+	}
+	f, err := os.Open(d.compressedFile)
+	if err != nil {
+		return false, err
+	}
+	d.f = f
+	return true, nil
+}
+
+// Closed - Close was called
+func (d *Decompressor) Closed() bool {
+	d.fLock.Lock()
+	defer d.fLock.Unlock()
+	return d.closed
+}
+
 func (d *Decompressor) FilePath() string { return d.compressedFile }
 func (d *Decompressor) FileName() string {
 	_, fName := filepath.Split(d.compressedFile)
@@ -638,6 +688,13 @@ func (g *Getter) Skip() uint64 {
 	return g.dataP
 }
 
+// SkipValue - for files of key-value pairs (keys and values alternate, like .kv): skips value of key which was
+// checked by Match/MatchPrefix or read by Next, returns offset of next key. Value is not decompressed: only positions
+// of patterns are decoded, uncovered bytes are jumped over. Works for compressed and uncompressed words.
+func (g *Getter) SkipValue() uint64 {
+	return g.Skip()
+}
+
 func (g *Getter) SkipUncompressed() uint64 {
 	wordLen := g.nextPos(true)
 	wordLen-- // because when create huffman tree we do ++ , because 0 is terminator
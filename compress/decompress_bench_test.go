@@ -17,6 +17,7 @@
 package compress
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"testing"
@@ -51,6 +52,15 @@ func BenchmarkDecompressSkip(b *testing.B) {
 	}
 }
 
+func BenchmarkDecompressForEachParallel(b *testing.B) {
+	t := new(testing.T)
+	d := prepareDict(t)
+	defer d.Close()
+	for i := 0; i < b.N; i++ {
+		_ = d.ForEachParallel(context.Background(), 4, func(uint64, []byte) error { return nil })
+	}
+}
+
 func BenchmarkDecompressMatch(b *testing.B) {
 	t := new(testing.T)
 	d := prepareDict(t)
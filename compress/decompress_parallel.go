@@ -0,0 +1,88 @@
+/*
+   Copyright 2023 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package compress
+
+import (
+	"context"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// parallelSegmentSize - ForEachParallel splits words to segments of at least this amount of compressed bytes
+var parallelSegmentSize = uint64(1 * 1024 * 1024)
+
+// wordsSegment - `words` words starting at offset `from`, first of them has number `firstWord`
+type wordsSegment struct {
+	firstWord uint64
+	words     uint64
+	from      uint64
+}
+
+// ForEachParallel - calls fn for each word of file, words are decoded by `workers` goroutines.
+// fn is called concurrently and not in order of words: wordNum - is number of word in file.
+// `word` is valid only until fn returns.
+//
+// Words can be decoded only from their start (huffman codes are not self-synchronizing), so one goroutine
+// finds starts of segments by Skip (which doesn't copy words) and workers decode segments by Next.
+func (d *Decompressor) ForEachParallel(ctx context.Context, workers int, fn func(wordNum uint64, word []byte) error) error {
+	if workers < 1 {
+		workers = 1
+	}
+	g, ctx := errgroup.WithContext(ctx)
+	segments := make(chan wordsSegment, workers*2)
+	g.Go(func() error {
+		defer close(segments)
+		getter := d.MakeGetter()
+		var seg wordsSegment
+		for getter.HasNext() {
+			offset := getter.Skip()
+			seg.words++
+			if offset-seg.from < parallelSegmentSize && getter.HasNext() {
+				continue
+			}
+			select {
+			case segments <- seg:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			seg = wordsSegment{firstWord: seg.firstWord + seg.words, from: offset}
+		}
+		return nil
+	})
+	for i := 0; i < workers; i++ {
+		g.Go(func() error {
+			getter := d.MakeGetter()
+			var buf []byte
+			for seg := range segments {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				default:
+				}
+				getter.Reset(seg.from)
+				for j := uint64(0); j < seg.words; j++ {
+					buf, _ = getter.Next(buf[:0])
+					if err := fn(seg.firstWord+j, buf); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		})
+	}
+	return g.Wait()
+}
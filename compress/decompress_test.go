@@ -23,6 +23,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 
 	"github.com/ledgerwatch/log/v3"
@@ -74,6 +75,120 @@ func TestDecompressSkip(t *testing.T) {
 	}
 }
 
+func TestDecompressSkipValue(t *testing.T) {
+	tmpDir := t.TempDir()
+	file := filepath.Join(tmpDir, "compressed")
+	c, err := NewCompressor(context.Background(), t.Name(), file, tmpDir, 1, 2, log.LvlDebug)
+	require.NoError(t, err)
+	defer c.Close()
+	for i, w := range loremStrings { // pairs: key - word with index, value - word itself (compressed or not)
+		require.NoError(t, c.AddWord([]byte(fmt.Sprintf("%d %s", i, w))))
+		if i%2 == 0 {
+			require.NoError(t, c.AddWord([]byte(w)))
+		} else {
+			require.NoError(t, c.AddUncompressedWord([]byte(w)))
+		}
+	}
+	require.NoError(t, c.Compress())
+	d, err := NewDecompressor(file)
+	require.NoError(t, err)
+	defer d.Close()
+
+	// keys "1 ..", "10 ..", "11 .." ...: check only keys, skip values
+	g := d.MakeGetter()
+	var keys []string
+	for g.HasNext() {
+		if g.MatchPrefix([]byte("1")) {
+			key, _ := g.Next(nil)
+			keys = append(keys, string(key))
+		} else {
+			g.Skip()
+		}
+		g.SkipValue()
+	}
+	var expected []string
+	for i, w := range loremStrings {
+		if strings.HasPrefix(fmt.Sprintf("%d", i), "1") {
+			expected = append(expected, fmt.Sprintf("%d %s", i, w))
+		}
+	}
+	require.Equal(t, expected, keys)
+}
+
+func TestDecompressMetadata(t *testing.T) {
+	tmpDir := t.TempDir()
+	file := filepath.Join(tmpDir, "compressed")
+	c, err := NewCompressor(context.Background(), t.Name(), file, tmpDir, 1, 2, log.LvlDebug)
+	require.NoError(t, err)
+	defer c.Close()
+	for _, w := range loremStrings {
+		require.NoError(t, c.AddWord([]byte(w)))
+	}
+	c.SetMetadata([]byte("meta"))
+	require.NoError(t, c.Compress())
+
+	d, err := NewDecompressor(file)
+	require.NoError(t, err)
+	require.Equal(t, "meta", string(d.Metadata()))
+	require.NoError(t, d.VerifyChecksum())
+	g := d.MakeGetter()
+	var words []string
+	for g.HasNext() {
+		w, _ := g.Next(nil)
+		words = append(words, string(w))
+	}
+	require.Equal(t, loremStrings, words)
+	d.Close()
+
+	// corrupted file
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	data[len(data)/2]++
+	require.NoError(t, os.WriteFile(file, data, 0644))
+	d, err = NewDecompressor(file)
+	require.NoError(t, err)
+	require.ErrorIs(t, d.VerifyChecksum(), ErrChecksumMismatch)
+	d.Close()
+
+	// file without metadata
+	d = prepareLoremDict(t)
+	defer d.Close()
+	require.Nil(t, d.Metadata())
+	require.NoError(t, d.VerifyChecksum())
+	require.NoError(t, AppendMetadata(d.FilePath(), []byte("later")))
+	d2, err := NewDecompressor(d.FilePath())
+	require.NoError(t, err)
+	defer d2.Close()
+	require.Equal(t, "later", string(d2.Metadata()))
+	require.NoError(t, d2.VerifyChecksum())
+	require.Equal(t, d.Count(), d2.Count())
+}
+
+func TestDecompressReleaseFile(t *testing.T) {
+	d := prepareLoremDict(t)
+	require.NoError(t, d.ReleaseFile())
+	require.NoError(t, d.ReleaseFile())
+	// mapping is alive without descriptor
+	g := d.MakeGetter()
+	for i := 0; g.HasNext(); i++ {
+		word, _ := g.Next(nil)
+		require.Equal(t, fmt.Sprintf("%s %d", loremStrings[i], i), string(word))
+	}
+	reopened, err := d.ReopenFile()
+	require.NoError(t, err)
+	require.True(t, reopened)
+	reopened, err = d.ReopenFile()
+	require.NoError(t, err)
+	require.False(t, reopened)
+
+	require.NoError(t, d.ReleaseFile())
+	require.NoError(t, d.Close())
+	require.True(t, d.Closed())
+	reopened, err = d.ReopenFile()
+	require.NoError(t, err)
+	require.False(t, reopened)
+}
+
 func TestDecompressMatchOK(t *testing.T) {
 	d := prepareLoremDict(t)
 	defer d.Close()
@@ -234,3 +349,35 @@ func TestDecompressTorrent(t *testing.T) {
 		require.NotZero(t, sz)
 	}
 }
+
+func TestDecompressForEachParallel(t *testing.T) {
+	d := prepareLoremDict(t)
+	defer d.Close()
+	defer func(v uint64) { parallelSegmentSize = v }(parallelSegmentSize)
+	parallelSegmentSize = 16 // many segments
+
+	for _, workers := range []int{1, 3} {
+		words := make([]string, d.Count())
+		var lock sync.Mutex
+		err := d.ForEachParallel(context.Background(), workers, func(wordNum uint64, word []byte) error {
+			lock.Lock()
+			defer lock.Unlock()
+			require.Empty(t, words[wordNum])
+			words[wordNum] = string(word)
+			return nil
+		})
+		require.NoError(t, err)
+		for i, w := range loremStrings {
+			require.Equal(t, fmt.Sprintf("%s %d", w, i), words[i])
+		}
+	}
+
+	stopErr := fmt.Errorf("stop")
+	err := d.ForEachParallel(context.Background(), 2, func(wordNum uint64, word []byte) error {
+		if wordNum == 10 {
+			return stopErr
+		}
+		return nil
+	})
+	require.ErrorIs(t, err, stopErr)
+}
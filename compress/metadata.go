@@ -0,0 +1,106 @@
+/*
+   Copyright 2023 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package compress
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// Optional metadata block at the end of file (footer):
+//
+//	[metadata][crc32c of file before crc:4][len(metadata):4][metadataMagic:8]
+//
+// Content of metadata is opaque for this package. Files without footer (written before) are readable as before:
+// their Metadata is nil. Footer is not part of words data - Getter doesn't see it. Versions before footer support
+// see it as part of last word: caller decides when it's safe to write it (SetMetadata is off by default).
+var metadataMagic = []byte("erigmeta")
+
+const metadataFooterSize = 4 + 4 + 8
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// SetMetadata - `meta` is written as footer of file by Compress
+func (c *Compressor) SetMetadata(meta []byte) {
+	c.metadata = meta
+}
+
+// AppendMetadata - adds metadata footer to compressed file which doesn't have it (for example, created by Concat)
+func AppendMetadata(path string, meta []byte) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := crc32.New(crc32cTable)
+	if _, err = io.Copy(h, f); err != nil {
+		return fmt.Errorf("checksum %s: %w", path, err)
+	}
+	h.Write(meta)
+	footer := make([]byte, len(meta)+metadataFooterSize)
+	copy(footer, meta)
+	binary.BigEndian.PutUint32(footer[len(meta):], h.Sum32())
+	binary.BigEndian.PutUint32(footer[len(meta)+4:], uint32(len(meta)))
+	copy(footer[len(meta)+8:], metadataMagic)
+	if _, err = f.Write(footer); err != nil {
+		return fmt.Errorf("append metadata %s: %w", path, err)
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// readMetadata - returns length of file without footer
+func (d *Decompressor) readMetadata(file []byte) (dataLen int64, err error) {
+	size := int64(len(file))
+	if size < 32+metadataFooterSize || !bytes.Equal(file[size-8:], metadataMagic) {
+		return size, nil
+	}
+	metaLen := int64(binary.BigEndian.Uint32(file[size-12 : size-8]))
+	if metaLen > size-32-metadataFooterSize {
+		return 0, fmt.Errorf("metadata is invalid: len=%d", metaLen)
+	}
+	dataLen = size - metadataFooterSize - metaLen
+	d.metadata = file[dataLen : dataLen+metaLen]
+	d.checksum = binary.BigEndian.Uint32(file[size-16 : size-12])
+	return dataLen, nil
+}
+
+// Metadata - content of footer, nil if file doesn't have it. Valid until Close.
+func (d *Decompressor) Metadata() []byte { return d.metadata }
+
+// VerifyChecksum - reads whole file. Files without metadata have no checksum - nothing to verify.
+func (d *Decompressor) VerifyChecksum() error {
+	if d.metadata == nil {
+		return nil
+	}
+	h := crc32.New(crc32cTable)
+	h.Write(d.data)
+	h.Write(d.metadata)
+	if sum := h.Sum32(); sum != d.checksum {
+		return fmt.Errorf("%w: %s, expected %x, got %x", ErrChecksumMismatch, d.FileName(), d.checksum, sum)
+	}
+	return nil
+}
@@ -565,83 +565,10 @@ func reducedict(ctx context.Context, trace bool, logPrefix, segmentFilePath stri
 		//fmt.Printf("[comp] depth=%d, code=[%b], codeLen=%d pattern=[%x]\n", p.depth, p.code, p.codeBits, p.word)
 	}
 
-	var positionList PositionList
-	pos2code := make(map[uint64]*Position)
-	for pos, uses := range posMap {
-		p := &Position{pos: pos, uses: uses, code: pos, codeBits: 0}
-		positionList = append(positionList, p)
-		pos2code[pos] = p
-	}
-	slices.SortFunc(positionList, positionListLess)
-	i = 0
-	// Build Huffman tree for codes
-	var posHeap PositionHeap
-	heap.Init(&posHeap)
-	tieBreaker = uint64(0)
-	for posHeap.Len()+(positionList.Len()-i) > 1 {
-		// New node
-		h := &PositionHuff{
-			tieBreaker: tieBreaker,
-		}
-		if posHeap.Len() > 0 && (i >= positionList.Len() || posHeap[0].uses < positionList[i].uses) {
-			// Take h0 from the heap
-			h.h0 = heap.Pop(&posHeap).(*PositionHuff)
-			h.h0.AddZero()
-			h.uses += h.h0.uses
-		} else {
-			// Take p0 from the list
-			h.p0 = positionList[i]
-			h.p0.code = 0
-			h.p0.codeBits = 1
-			h.uses += h.p0.uses
-			i++
-		}
-		if posHeap.Len() > 0 && (i >= positionList.Len() || posHeap[0].uses < positionList[i].uses) {
-			// Take h1 from the heap
-			h.h1 = heap.Pop(&posHeap).(*PositionHuff)
-			h.h1.AddOne()
-			h.uses += h.h1.uses
-		} else {
-			// Take p1 from the list
-			h.p1 = positionList[i]
-			h.p1.code = 1
-			h.p1.codeBits = 1
-			h.uses += h.p1.uses
-			i++
-		}
-		tieBreaker++
-		heap.Push(&posHeap, h)
-	}
-	if posHeap.Len() > 0 {
-		posRoot := heap.Pop(&posHeap).(*PositionHuff)
-		posRoot.SetDepth(0)
-	}
-	// Calculate the size of pos dictionary
-	var posSize uint64
-	for _, p := range positionList {
-		ns := binary.PutUvarint(numBuf[:], uint64(p.depth)) // Length of the position's depth
-		n := binary.PutUvarint(numBuf[:], p.pos)
-		posSize += uint64(ns + n)
-	}
-	// First, output dictionary size
-	binary.BigEndian.PutUint64(numBuf[:], posSize) // Dictionary size
-	if _, err = cw.Write(numBuf[:8]); err != nil {
+	positionList, pos2code, posSize, err := writePositionsDict(cw, posMap)
+	if err != nil {
 		return err
 	}
-	//fmt.Printf("posSize = %d\n", posSize)
-	// Write all the positions
-	slices.SortFunc(positionList, positionListLess)
-	for _, p := range positionList {
-		ns := binary.PutUvarint(numBuf[:], uint64(p.depth))
-		if _, err = cw.Write(numBuf[:ns]); err != nil {
-			return err
-		}
-		n := binary.PutUvarint(numBuf[:], p.pos)
-		if _, err = cw.Write(numBuf[:n]); err != nil {
-			return err
-		}
-		//fmt.Printf("[comp] depth=%d, code=[%b], codeLen=%d pos=%d\n", p.depth, p.code, p.codeBits, p.pos)
-	}
 	log.Log(lvl, fmt.Sprintf("[%s] Positional dictionary", logPrefix), "positionList.len", positionList.Len(), "posSize", common.ByteCount(posSize))
 	// Re-encode all the words with the use of optimised (via Huffman coding) dictionaries
 	wc := 0
@@ -738,6 +665,87 @@ func reducedict(ctx context.Context, trace bool, logPrefix, segmentFilePath stri
 	return nil
 }
 
+// writePositionsDict - builds Huffman codes of positions by their uses in `posMap` and writes positions dictionary
+func writePositionsDict(w io.Writer, posMap map[uint64]uint64) (positionList PositionList, pos2code map[uint64]*Position, posSize uint64, err error) {
+	var numBuf [binary.MaxVarintLen64]byte
+	pos2code = make(map[uint64]*Position)
+	for pos, uses := range posMap {
+		p := &Position{pos: pos, uses: uses, code: pos, codeBits: 0}
+		positionList = append(positionList, p)
+		pos2code[pos] = p
+	}
+	slices.SortFunc(positionList, positionListLess)
+	i := 0
+	// Build Huffman tree for codes
+	var posHeap PositionHeap
+	heap.Init(&posHeap)
+	tieBreaker := uint64(0)
+	for posHeap.Len()+(positionList.Len()-i) > 1 {
+		// New node
+		h := &PositionHuff{
+			tieBreaker: tieBreaker,
+		}
+		if posHeap.Len() > 0 && (i >= positionList.Len() || posHeap[0].uses < positionList[i].uses) {
+			// Take h0 from the heap
+			h.h0 = heap.Pop(&posHeap).(*PositionHuff)
+			h.h0.AddZero()
+			h.uses += h.h0.uses
+		} else {
+			// Take p0 from the list
+			h.p0 = positionList[i]
+			h.p0.code = 0
+			h.p0.codeBits = 1
+			h.uses += h.p0.uses
+			i++
+		}
+		if posHeap.Len() > 0 && (i >= positionList.Len() || posHeap[0].uses < positionList[i].uses) {
+			// Take h1 from the heap
+			h.h1 = heap.Pop(&posHeap).(*PositionHuff)
+			h.h1.AddOne()
+			h.uses += h.h1.uses
+		} else {
+			// Take p1 from the list
+			h.p1 = positionList[i]
+			h.p1.code = 1
+			h.p1.codeBits = 1
+			h.uses += h.p1.uses
+			i++
+		}
+		tieBreaker++
+		heap.Push(&posHeap, h)
+	}
+	if posHeap.Len() > 0 {
+		posRoot := heap.Pop(&posHeap).(*PositionHuff)
+		posRoot.SetDepth(0)
+	}
+	// Calculate the size of pos dictionary
+	for _, p := range positionList {
+		ns := binary.PutUvarint(numBuf[:], uint64(p.depth)) // Length of the position's depth
+		n := binary.PutUvarint(numBuf[:], p.pos)
+		posSize += uint64(ns + n)
+	}
+	// First, output dictionary size
+	binary.BigEndian.PutUint64(numBuf[:], posSize) // Dictionary size
+	if _, err = w.Write(numBuf[:8]); err != nil {
+		return nil, nil, 0, err
+	}
+	//fmt.Printf("posSize = %d\n", posSize)
+	// Write all the positions
+	slices.SortFunc(positionList, positionListLess)
+	for _, p := range positionList {
+		ns := binary.PutUvarint(numBuf[:], uint64(p.depth))
+		if _, err = w.Write(numBuf[:ns]); err != nil {
+			return nil, nil, 0, err
+		}
+		n := binary.PutUvarint(numBuf[:], p.pos)
+		if _, err = w.Write(numBuf[:n]); err != nil {
+			return nil, nil, 0, err
+		}
+		//fmt.Printf("[comp] depth=%d, code=[%b], codeLen=%d pos=%d\n", p.depth, p.code, p.codeBits, p.pos)
+	}
+	return positionList, pos2code, posSize, nil
+}
+
 // processSuperstring is the worker that processes one superstring and puts results
 // into the collector, using lock to mutual exclusion. At the end (when the input channel is closed),
 // it notifies the waitgroup before exiting, so that the caller known when all work is done
@@ -0,0 +1,118 @@
+/*
+   Copyright 2023 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package compress
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"os"
+
+	"github.com/ledgerwatch/erigon-lib/etl"
+)
+
+// WordsSource - calls `yield` for each word, in order. Must produce same words each time it's called.
+type WordsSource func(yield func(word []byte) error) error
+
+// WriteUncompressed - writes file of words added without compression (as Compressor.AddUncompressedWord) directly
+// into `outputFile`, skipping intermediate .idt file in tmpdir and intermediate file of reducedict. Output is
+// identical to Compressor's one. Positions dictionary must be written before words - so `words` is walked twice:
+// to count lengths of words and to write them. For sorted sources (db cursors) walking twice is cheaper than
+// writing and reading all words via temporary files.
+func WriteUncompressed(outputFile string, metadata []byte, words WordsSource) (count int, err error) {
+	var wordsCount, emptyWordsCount uint64
+	posMap := map[uint64]uint64{}
+	if err = words(func(word []byte) error {
+		wordsCount++
+		if len(word) == 0 {
+			emptyWordsCount++
+		}
+		posMap[uint64(len(word))+1]++
+		posMap[0]++
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+
+	tmpOutFilePath := outputFile + ".tmp"
+	defer os.Remove(tmpOutFilePath)
+	cf, err := os.Create(tmpOutFilePath)
+	if err != nil {
+		return 0, err
+	}
+	defer cf.Close()
+	cw := bufio.NewWriterSize(cf, 2*etl.BufIOSize)
+	var numBuf [8]byte
+	for _, v := range []uint64{wordsCount, emptyWordsCount, 0 /* patterns dictionary size */} {
+		binary.BigEndian.PutUint64(numBuf[:], v)
+		if _, err = cw.Write(numBuf[:]); err != nil {
+			return 0, err
+		}
+	}
+	_, pos2code, _, err := writePositionsDict(cw, posMap)
+	if err != nil {
+		return 0, err
+	}
+
+	hc := HuffmanCoder{w: cw}
+	var written uint64
+	if err = words(func(word []byte) error {
+		written++
+		if written > wordsCount {
+			return fmt.Errorf("WriteUncompressed: words source produced more than %d words", wordsCount)
+		}
+		posCode := pos2code[uint64(len(word))+1]
+		if posCode == nil {
+			return fmt.Errorf("WriteUncompressed: words source produced different words, len %d", len(word))
+		}
+		if err := hc.encode(posCode.code, posCode.codeBits); err != nil {
+			return err
+		}
+		if len(word) > 0 {
+			// terminating position: no patterns in word
+			posCode = pos2code[0]
+			if err := hc.encode(posCode.code, posCode.codeBits); err != nil {
+				return err
+			}
+		}
+		if err := hc.flush(); err != nil {
+			return err
+		}
+		_, err := cw.Write(word)
+		return err
+	}); err != nil {
+		return 0, err
+	}
+	if written != wordsCount {
+		return 0, fmt.Errorf("WriteUncompressed: words source produced %d words, expected %d", written, wordsCount)
+	}
+	if err = cw.Flush(); err != nil {
+		return 0, err
+	}
+	if err = cf.Close(); err != nil {
+		return 0, err
+	}
+	if metadata != nil {
+		if err = AppendMetadata(tmpOutFilePath, metadata); err != nil {
+			return 0, err
+		}
+	}
+	if err = os.Rename(tmpOutFilePath, outputFile); err != nil {
+		return 0, fmt.Errorf("renaming: %w", err)
+	}
+	return int(wordsCount), nil
+}
@@ -0,0 +1,108 @@
+/*
+   Copyright 2022 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package downloader
+
+import (
+	"github.com/RoaringBitmap/roaring"
+	"github.com/anacrolix/torrent"
+)
+
+// FileAvailability - piece-level completion map of one file (every file is a separate torrent).
+// Aggregator can start indexing completed prefix of append-only formats (.seg, .v, .ef)
+// before whole torrent finished.
+type FileAvailability struct {
+	Name        string
+	Length      int64 // file size in bytes
+	PieceLength int64
+	PiecesTotal int
+	Completed   *roaring.Bitmap // indices of downloaded and verified pieces
+}
+
+func (a *FileAvailability) Complete() bool {
+	return a.PiecesTotal > 0 && int(a.Completed.GetCardinality()) == a.PiecesTotal
+}
+
+// CompletedPieces - amount of pieces at the beginning of file which are all completed
+func (a *FileAvailability) CompletedPieces() int {
+	n := 0
+	it := a.Completed.Iterator()
+	for it.HasNext() {
+		if it.Next() != uint32(n) {
+			break
+		}
+		n++
+	}
+	return n
+}
+
+// CompletedPrefix - amount of bytes at the beginning of file which are available for reading
+func (a *FileAvailability) CompletedPrefix() int64 {
+	prefix := int64(a.CompletedPieces()) * a.PieceLength
+	if prefix > a.Length {
+		return a.Length
+	}
+	return prefix
+}
+
+// FileAvailability - returns false if file is unknown or has no metadata yet
+func (d *Downloader) FileAvailability(name string) (*FileAvailability, bool) {
+	for _, t := range d.Torrent().Torrents() {
+		if t.Name() != name {
+			continue
+		}
+		return fileAvailability(t)
+	}
+	return nil, false
+}
+
+// FilesAvailability - availability of all files which already have metadata
+func (d *Downloader) FilesAvailability() []*FileAvailability {
+	torrents := d.Torrent().Torrents()
+	res := make([]*FileAvailability, 0, len(torrents))
+	for _, t := range torrents {
+		a, ok := fileAvailability(t)
+		if !ok {
+			continue
+		}
+		res = append(res, a)
+	}
+	return res
+}
+
+func fileAvailability(t *torrent.Torrent) (*FileAvailability, bool) {
+	select {
+	case <-t.GotInfo():
+	default:
+		return nil, false
+	}
+	a := &FileAvailability{
+		Name:        t.Name(),
+		Length:      t.Length(),
+		PieceLength: t.Info().PieceLength,
+		PiecesTotal: t.NumPieces(),
+		Completed:   roaring.New(),
+	}
+	var from uint64
+	for _, run := range t.PieceStateRuns() {
+		to := from + uint64(run.Length)
+		if run.Complete {
+			a.Completed.AddRange(from, to)
+		}
+		from = to
+	}
+	return a, true
+}
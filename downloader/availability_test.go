@@ -0,0 +1,41 @@
+/*
+   Copyright 2022 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package downloader
+
+import (
+	"testing"
+
+	"github.com/RoaringBitmap/roaring"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileAvailability(t *testing.T) {
+	a := &FileAvailability{Length: 250, PieceLength: 100, PiecesTotal: 3, Completed: roaring.New()}
+	require.False(t, a.Complete())
+	require.Equal(t, int64(0), a.CompletedPrefix())
+
+	a.Completed.Add(1)
+	require.Equal(t, int64(0), a.CompletedPrefix())
+
+	a.Completed.Add(0)
+	require.Equal(t, 2, a.CompletedPieces())
+	require.Equal(t, int64(200), a.CompletedPrefix())
+
+	a.Completed.Add(2)
+	require.True(t, a.Complete())
+	require.Equal(t, int64(250), a.CompletedPrefix())
+}
@@ -52,6 +52,12 @@ type Downloader struct {
 	stats     AggStats
 
 	folder storage.ClientImplCloser
+
+	seedingLock      sync.RWMutex
+	seeding          SeedingPolicy          // see SetSeedingPolicy
+	uploadDisallowed map[metainfo.Hash]bool // torrents stopped by seeding policy
+
+	manifestLock sync.Mutex // see AddNewSeedableFiles
 }
 
 type AggStats struct {
@@ -109,6 +115,8 @@ func New(cfg *downloadercfg.Cfg) (*Downloader, error) {
 		clientLock:        &sync.RWMutex{},
 
 		statsLock: &sync.RWMutex{},
+
+		uploadDisallowed: map[metainfo.Hash]bool{},
 	}
 	if err := d.addSegments(); err != nil {
 		return nil, err
@@ -396,6 +404,7 @@ func MainLoop(ctx context.Context, d *Downloader, silent bool) {
 			return
 		case <-statEvery.C:
 			d.ReCalcStats(statInterval)
+			d.applySeedingPolicy(time.Now())
 
 		case <-logEvery.C:
 			if silent {
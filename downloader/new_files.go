@@ -0,0 +1,136 @@
+/*
+   Copyright 2023 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package downloader
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/ledgerwatch/erigon-lib/common/dir"
+	"github.com/ledgerwatch/log/v3"
+)
+
+// ManifestFileName - files seeded by this node: one "<name> <info hash>" line per file, sorted by name.
+// Names are relative to SnapDir. Updated by AddNewSeedableFiles.
+const ManifestFileName = "manifest.txt"
+
+// AddNewSeedableFiles - from file creation to distribution: for each of `names` (relative to SnapDir, for example
+// "history/accounts.0-32.v" - frozen files of aggregator, see state.AggregatorV3.SetOnFrozenFiles) builds .torrent
+// file, adds it to torrent client (file is complete - it's seeded, subject of SeedingPolicy) and records it in manifest.
+func (d *Downloader) AddNewSeedableFiles(ctx context.Context, names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	added := make(map[string]metainfo.Hash, len(names))
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		fPath := filepath.Join(d.SnapDir(), name)
+		if !dir.FileExist(fPath) {
+			return fmt.Errorf("AddNewSeedableFiles: %s: file not found", name)
+		}
+		if err := buildTorrentIfNeed(name, d.SnapDir()); err != nil {
+			return fmt.Errorf("AddNewSeedableFiles: %s: %w", name, err)
+		}
+		t, err := AddTorrentFile(fPath+".torrent", d.torrentClient)
+		if err != nil {
+			return fmt.Errorf("AddNewSeedableFiles: %s: %w", name, err)
+		}
+		added[name] = t.InfoHash()
+	}
+	d.manifestLock.Lock()
+	defer d.manifestLock.Unlock()
+	manifest, err := ReadManifest(d.SnapDir())
+	if err != nil {
+		return err
+	}
+	for name, hash := range added {
+		manifest[name] = hash
+	}
+	if err := writeManifest(d.SnapDir(), manifest); err != nil {
+		return err
+	}
+	d.applySeedingPolicy(time.Now())
+	log.Info("[snapshots] new seedable files", "files", len(added), "manifest", len(manifest))
+	return nil
+}
+
+// ReadManifest - name -> info hash, see ManifestFileName. Empty if there is no manifest yet.
+func ReadManifest(snapDir string) (map[string]metainfo.Hash, error) {
+	res := map[string]metainfo.Hash{}
+	f, err := os.Open(filepath.Join(snapDir, ManifestFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return res, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected <name> <info hash>", ManifestFileName, line)
+		}
+		var hash metainfo.Hash
+		if err := hash.FromHexString(fields[1]); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", ManifestFileName, line, err)
+		}
+		res[fields[0]] = hash
+	}
+	return res, sc.Err()
+}
+
+// writeManifest - atomically: readers never see partially written manifest
+func writeManifest(snapDir string, manifest map[string]metainfo.Hash) error {
+	names := make([]string, 0, len(manifest))
+	for name := range manifest {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var sb strings.Builder
+	for _, name := range names {
+		hash := manifest[name]
+		fmt.Fprintf(&sb, "%s %s\n", name, hash.HexString())
+	}
+	fPath := filepath.Join(snapDir, ManifestFileName)
+	f, err := os.Create(fPath + ".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err = f.WriteString(sb.String()); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(fPath+".tmp", fPath)
+}
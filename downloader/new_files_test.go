@@ -0,0 +1,50 @@
+/*
+   Copyright 2023 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package downloader
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManifest(t *testing.T) {
+	dir := t.TempDir()
+	m, err := ReadManifest(dir)
+	require.NoError(t, err)
+	require.Empty(t, m)
+
+	m = map[string]metainfo.Hash{
+		"history/accounts.32-64.v": metainfo.HashBytes([]byte("2")),
+		"history/accounts.0-32.v":  metainfo.HashBytes([]byte("1")),
+	}
+	require.NoError(t, writeManifest(dir, m))
+	content, err := os.ReadFile(filepath.Join(dir, ManifestFileName))
+	require.NoError(t, err)
+	require.Equal(t, "history/accounts.0-32.v "+m["history/accounts.0-32.v"].HexString()+"\n"+
+		"history/accounts.32-64.v "+m["history/accounts.32-64.v"].HexString()+"\n", string(content))
+	read, err := ReadManifest(dir)
+	require.NoError(t, err)
+	require.Equal(t, m, read)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ManifestFileName), []byte("history/accounts.0-32.v\n"), 0644))
+	_, err = ReadManifest(dir)
+	require.Error(t, err)
+}
@@ -0,0 +1,160 @@
+/*
+   Copyright 2023 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package downloader
+
+import (
+	"time"
+
+	"github.com/anacrolix/torrent"
+)
+
+// SeedingPolicy - which files are seeded and how much. Zero value - seed all files without limits (default).
+// Policy is re-applied periodically by MainLoop and immediately by SetSeedingPolicy.
+type SeedingPolicy struct {
+	// Filter - returns false for files which must not be seeded after download. nil - seed all files
+	Filter func(name string) bool
+	// MaxRatio - stop seeding file when it uploaded MaxRatio*(file size) bytes. 0 - unlimited.
+	// Uploaded bytes are counted since start of process.
+	MaxRatio float64
+	// QuietHours - nothing is uploaded (even parts of not yet downloaded files) in this intervals
+	QuietHours []QuietHours
+}
+
+// QuietHours - daily interval [From, To) in local time, as offsets from midnight. Can cross midnight: From > To.
+type QuietHours struct {
+	From, To time.Duration
+}
+
+func (q QuietHours) Contains(t time.Time) bool {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	offset := t.Sub(midnight)
+	if q.From <= q.To {
+		return q.From <= offset && offset < q.To
+	}
+	return offset >= q.From || offset < q.To
+}
+
+func (p *SeedingPolicy) quiet(now time.Time) bool {
+	for _, q := range p.QuietHours {
+		if q.Contains(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowUpload - `complete` files are seeded: they are subject of Filter and MaxRatio
+func (p *SeedingPolicy) allowUpload(name string, complete bool, length, uploaded int64, now time.Time) bool {
+	if p.quiet(now) {
+		return false
+	}
+	if !complete {
+		return true
+	}
+	if p.Filter != nil && !p.Filter(name) {
+		return false
+	}
+	if p.MaxRatio > 0 && length > 0 && float64(uploaded)/float64(length) >= p.MaxRatio {
+		return false
+	}
+	return true
+}
+
+// TorrentStats - stats of one file (every file is a separate torrent)
+type TorrentStats struct {
+	Name                           string
+	Length, BytesCompleted         int64 // 0 if file has no metadata yet
+	BytesUploaded, BytesDownloaded int64 // since start of process
+	Ratio                          float64
+	Peers                          int
+	Complete                       bool
+	Seeding                        bool // file is complete and SeedingPolicy allows it's upload now
+}
+
+func (d *Downloader) SetSeedingPolicy(p SeedingPolicy) {
+	d.seedingLock.Lock()
+	d.seeding = p
+	d.seedingLock.Unlock()
+	d.applySeedingPolicy(time.Now())
+}
+
+func (d *Downloader) SeedingPolicy() SeedingPolicy {
+	d.seedingLock.RLock()
+	defer d.seedingLock.RUnlock()
+	return d.seeding
+}
+
+func (d *Downloader) applySeedingPolicy(now time.Time) {
+	torrents := d.Torrent().Torrents()
+	d.seedingLock.Lock()
+	defer d.seedingLock.Unlock()
+	for _, t := range torrents {
+		st := t.Stats()
+		allow := d.torrentAllowUpload(t, st.BytesWrittenData.Int64(), now)
+		if allow == !d.uploadDisallowed[t.InfoHash()] {
+			continue
+		}
+		if allow {
+			t.AllowDataUpload()
+			delete(d.uploadDisallowed, t.InfoHash())
+		} else {
+			t.DisallowDataUpload()
+			d.uploadDisallowed[t.InfoHash()] = true
+		}
+	}
+}
+
+// torrentAllowUpload - under seedingLock
+func (d *Downloader) torrentAllowUpload(t *torrent.Torrent, uploaded int64, now time.Time) bool {
+	var length int64
+	select {
+	case <-t.GotInfo():
+		length = t.Length()
+	default:
+	}
+	return d.seeding.allowUpload(t.Name(), t.Complete.Bool(), length, uploaded, now)
+}
+
+// TorrentStats - per-file stats, see also Stats
+func (d *Downloader) TorrentStats() []TorrentStats {
+	torrents := d.Torrent().Torrents()
+	now := time.Now()
+	res := make([]TorrentStats, 0, len(torrents))
+	d.seedingLock.RLock()
+	defer d.seedingLock.RUnlock()
+	for _, t := range torrents {
+		st := t.Stats()
+		s := TorrentStats{
+			Name:            t.Name(),
+			BytesUploaded:   st.BytesWrittenData.Int64(),
+			BytesDownloaded: st.BytesReadUsefulIntendedData.Int64(),
+			Peers:           st.ActivePeers,
+			Complete:        t.Complete.Bool(),
+		}
+		select {
+		case <-t.GotInfo():
+			s.Length, s.BytesCompleted = t.Length(), t.BytesCompleted()
+		default:
+		}
+		if s.Length > 0 {
+			s.Ratio = float64(s.BytesUploaded) / float64(s.Length)
+		}
+		s.Seeding = s.Complete && d.torrentAllowUpload(t, s.BytesUploaded, now)
+		res = append(res, s)
+	}
+	return res
+}
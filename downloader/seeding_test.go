@@ -0,0 +1,50 @@
+/*
+   Copyright 2022 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package downloader
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeedingPolicy(t *testing.T) {
+	at := func(h, m int) time.Time { return time.Date(2023, 1, 1, h, m, 0, 0, time.UTC) }
+	night := QuietHours{From: 23 * time.Hour, To: 6 * time.Hour}
+	require.True(t, night.Contains(at(23, 30)))
+	require.True(t, night.Contains(at(5, 59)))
+	require.False(t, night.Contains(at(6, 0)))
+	lunch := QuietHours{From: 12 * time.Hour, To: 13 * time.Hour}
+	require.True(t, lunch.Contains(at(12, 0)))
+	require.False(t, lunch.Contains(at(13, 0)))
+
+	var p SeedingPolicy
+	require.True(t, p.allowUpload("v1-000000-000500-headers.seg", true, 100, 1_000, at(12, 0)))
+
+	p = SeedingPolicy{
+		Filter:     func(name string) bool { return strings.HasSuffix(name, ".seg") },
+		MaxRatio:   2,
+		QuietHours: []QuietHours{night},
+	}
+	require.True(t, p.allowUpload("v1-000000-000500-headers.seg", true, 100, 199, at(12, 0)))
+	require.False(t, p.allowUpload("v1-000000-000500-headers.seg", true, 100, 200, at(12, 0)))
+	require.False(t, p.allowUpload("v1-000000-000500-headers.idx", true, 100, 0, at(12, 0)))
+	require.True(t, p.allowUpload("v1-000000-000500-headers.idx", false, 100, 1_000, at(12, 0))) // downloading
+	require.False(t, p.allowUpload("v1-000000-000500-headers.seg", false, 100, 0, at(1, 0)))
+}
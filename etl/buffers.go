@@ -36,6 +36,12 @@ const (
 	// SortableOldestAppearedBuffer - buffer that keeps only the oldest entries.
 	// if first v1 was added under key K, then v2; only v1 will stay
 	SortableOldestAppearedBuffer
+	// SortableNewestAppearedBuffer - buffer that keeps only the newest entries.
+	// if first v1 was added under key K, then v2; only v2 will stay
+	SortableNewestAppearedBuffer
+	// SortableMergeAppendBuffer - like SortableAppendBuffer, but also values of key from different flushed files are
+	// concatenated on load: loadFunc sees each key once, with values in order of Collect
+	SortableMergeAppendBuffer
 
 	//BufIOSize - 128 pages | default is 1 page | increasing over `64 * 4096` doesn't show speedup on SSD/NVMe, but show speedup in cloud drives
 	BufIOSize = 128 * 4096
@@ -53,6 +59,16 @@ type Buffer interface {
 	CheckFlushSize() bool
 }
 
+// CompareFunc - order of keys in buffers, files and on load. nil means bytes.Compare
+type CompareFunc func(a, b []byte) int
+
+func compareKeys(cmp CompareFunc, a, b []byte) int {
+	if cmp == nil {
+		return bytes.Compare(a, b)
+	}
+	return cmp(a, b)
+}
+
 type sortableBufferEntry struct {
 	key   []byte
 	value []byte
@@ -62,6 +78,8 @@ var (
 	_ Buffer = &sortableBuffer{}
 	_ Buffer = &appendSortableBuffer{}
 	_ Buffer = &oldestEntrySortableBuffer{}
+	_ Buffer = &newestEntrySortableBuffer{}
+	_ Buffer = &mergeAppendSortableBuffer{}
 )
 
 func NewSortableBuffer(bufferOptimalSize datasize.ByteSize) *sortableBuffer {
@@ -75,6 +93,7 @@ type sortableBuffer struct {
 	lens        []int
 	data        []byte
 	optimalSize int
+	cmp         CompareFunc
 }
 
 // Put adds key and value to the buffer. These slices will not be accessed later,
@@ -104,7 +123,7 @@ func (b *sortableBuffer) Less(i, j int) bool {
 	i2, j2 := i*2, j*2
 	ki := b.data[b.offsets[i2] : b.offsets[i2]+b.lens[i2]]
 	kj := b.data[b.offsets[j2] : b.offsets[j2]+b.lens[j2]]
-	return bytes.Compare(ki, kj) < 0
+	return compareKeys(b.cmp, ki, kj) < 0
 }
 
 func (b *sortableBuffer) Swap(i, j int) {
@@ -172,6 +191,7 @@ type appendSortableBuffer struct {
 	sortedBuf   []sortableBufferEntry
 	size        int
 	optimalSize int
+	cmp         CompareFunc
 }
 
 func (b *appendSortableBuffer) Put(k, v []byte) {
@@ -199,7 +219,7 @@ func (b *appendSortableBuffer) Sort() {
 }
 
 func (b *appendSortableBuffer) Less(i, j int) bool {
-	return bytes.Compare(b.sortedBuf[i].key, b.sortedBuf[j].key) < 0
+	return compareKeys(b.cmp, b.sortedBuf[i].key, b.sortedBuf[j].key) < 0
 }
 
 func (b *appendSortableBuffer) Swap(i, j int) {
@@ -256,6 +276,7 @@ type oldestEntrySortableBuffer struct {
 	sortedBuf   []sortableBufferEntry
 	size        int
 	optimalSize int
+	cmp         CompareFunc
 }
 
 func (b *oldestEntrySortableBuffer) Put(k, v []byte) {
@@ -285,7 +306,7 @@ func (b *oldestEntrySortableBuffer) Sort() {
 }
 
 func (b *oldestEntrySortableBuffer) Less(i, j int) bool {
-	return bytes.Compare(b.sortedBuf[i].key, b.sortedBuf[j].key) < 0
+	return compareKeys(b.cmp, b.sortedBuf[i].key, b.sortedBuf[j].key) < 0
 }
 
 func (b *oldestEntrySortableBuffer) Swap(i, j int) {
@@ -328,6 +349,37 @@ func (b *oldestEntrySortableBuffer) CheckFlushSize() bool {
 	return b.size >= b.optimalSize
 }
 
+func NewNewestEntryBuffer(bufferOptimalSize datasize.ByteSize) *newestEntrySortableBuffer {
+	return &newestEntrySortableBuffer{oldestEntrySortableBuffer{
+		entries:     make(map[string][]byte),
+		size:        0,
+		optimalSize: int(bufferOptimalSize.Bytes()),
+	}}
+}
+
+// newestEntrySortableBuffer - same as oldestEntrySortableBuffer, only Put overwrites
+type newestEntrySortableBuffer struct {
+	oldestEntrySortableBuffer
+}
+
+func (b *newestEntrySortableBuffer) Put(k, v []byte) {
+	if old, ok := b.entries[string(k)]; ok {
+		b.size += len(v) - len(old)
+	} else {
+		b.size += len(k)*2 + len(v)
+	}
+	b.entries[string(k)] = common.Copy(v)
+}
+
+func NewMergeAppendBuffer(bufferOptimalSize datasize.ByteSize) *mergeAppendSortableBuffer {
+	return &mergeAppendSortableBuffer{*NewAppendBuffer(bufferOptimalSize)}
+}
+
+// mergeAppendSortableBuffer - same as appendSortableBuffer, different type only to merge files on load
+type mergeAppendSortableBuffer struct {
+	appendSortableBuffer
+}
+
 func getBufferByType(tp int, size datasize.ByteSize) Buffer {
 	switch tp {
 	case SortableSliceBuffer:
@@ -336,11 +388,32 @@ func getBufferByType(tp int, size datasize.ByteSize) Buffer {
 		return NewAppendBuffer(size)
 	case SortableOldestAppearedBuffer:
 		return NewOldestEntryBuffer(size)
+	case SortableNewestAppearedBuffer:
+		return NewNewestEntryBuffer(size)
+	case SortableMergeAppendBuffer:
+		return NewMergeAppendBuffer(size)
 	default:
 		panic("unknown buffer type " + strconv.Itoa(tp))
 	}
 }
 
+func setBufferComparator(b Buffer, cmp CompareFunc) {
+	switch b := b.(type) {
+	case *sortableBuffer:
+		b.cmp = cmp
+	case *appendSortableBuffer:
+		b.cmp = cmp
+	case *oldestEntrySortableBuffer:
+		b.cmp = cmp
+	case *newestEntrySortableBuffer:
+		b.cmp = cmp
+	case *mergeAppendSortableBuffer:
+		b.cmp = cmp
+	default:
+		panic(fmt.Sprintf("unknown buffer type: %T ", b))
+	}
+}
+
 func getTypeByBuffer(b Buffer) int {
 	switch b.(type) {
 	case *sortableBuffer:
@@ -349,6 +422,10 @@ func getTypeByBuffer(b Buffer) int {
 		return SortableAppendBuffer
 	case *oldestEntrySortableBuffer:
 		return SortableOldestAppearedBuffer
+	case *newestEntrySortableBuffer:
+		return SortableNewestAppearedBuffer
+	case *mergeAppendSortableBuffer:
+		return SortableMergeAppendBuffer
 	default:
 		panic(fmt.Sprintf("unknown buffer type: %T ", b))
 	}
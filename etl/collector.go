@@ -47,6 +47,10 @@ type Collector struct {
 	bufType         int
 	allFlushed      bool
 	autoClean       bool
+	cmp             CompareFunc // nil for bytes order
+	progress        Progress
+	onProgress      func(Progress)    // see SetProgress
+	metrics         *collectorMetrics // see SetMetrics
 }
 
 // NewCollectorFromFiles creates collector from existing files (left over from previous unsuccessful loading)
@@ -100,6 +104,10 @@ func NewCollector(logPrefix, tmpdir string, sortableBuffer Buffer) *Collector {
 		} else {
 			doFsync := !c.autoClean /* is critical collector */
 			provider, err = FlushToDisk(logPrefix, sortableBuffer, tmpdir, doFsync, c.logLvl)
+			if err == nil && provider != nil {
+				c.progress.SpillFiles++
+				c.reportProgress()
+			}
 		}
 		if err != nil {
 			return err
@@ -123,11 +131,31 @@ func NewCollector(logPrefix, tmpdir string, sortableBuffer Buffer) *Collector {
 }
 
 func (c *Collector) Collect(k, v []byte) error {
+	c.progress.Collected++
+	c.progress.CollectedBytes += uint64(len(k) + len(v))
 	return c.extractNextFunc(k, k, v)
 }
 
 func (c *Collector) LogLvl(v log.Lvl) { c.logLvl = v }
 
+// FlushToDisk - writes buffered entries to temp file: after it all collected entries are on disk
+func (c *Collector) FlushToDisk() error {
+	if c.allFlushed {
+		return nil
+	}
+	return c.flushBuffer(nil, false)
+}
+
+// Detach - closes temp files, but doesn't remove them: NewCollectorFromFiles can load them later
+func (c *Collector) Detach() {
+	for _, p := range c.dataProviders {
+		if fp, ok := p.(*fileDataProvider); ok {
+			_ = fp.file.Close()
+		}
+	}
+	c.dataProviders = nil
+}
+
 func (c *Collector) Load(db kv.RwTx, toBucket string, loadFunc LoadFunc, args TransformArgs) error {
 	defer func() {
 		if c.autoClean {
@@ -139,7 +167,14 @@ func (c *Collector) Load(db kv.RwTx, toBucket string, loadFunc LoadFunc, args Tr
 			return e
 		}
 	}
-	if err := loadFilesIntoBucket(c.logPrefix, db, toBucket, c.bufType, c.dataProviders, loadFunc, args); err != nil {
+	var onLoaded func(loaded uint64)
+	if c.onProgress != nil || c.metrics != nil {
+		onLoaded = func(loaded uint64) {
+			c.progress.Loaded = loaded
+			c.reportProgress()
+		}
+	}
+	if err := loadFilesIntoBucket(c.logPrefix, db, toBucket, c.bufType, c.dataProviders, loadFunc, c.cmp, args, onLoaded); err != nil {
 		return fmt.Errorf("loadIntoTable %s: %w", toBucket, err)
 	}
 	return nil
@@ -162,9 +197,11 @@ func (c *Collector) Close() {
 // for the next item, which is then added back to the heap.
 // The subsequent iterations pop the heap again and load up the provider associated with it to get the next element after processing LoadFunc.
 // this continues until all providers have reached their EOF.
-func loadFilesIntoBucket(logPrefix string, db kv.RwTx, bucket string, bufType int, providers []dataProvider, loadFunc LoadFunc, args TransformArgs) error {
+//
+// onLoaded - may be nil, called with amount of popped elements every progressLoadEvery elements and at the end.
+func loadFilesIntoBucket(logPrefix string, db kv.RwTx, bucket string, bufType int, providers []dataProvider, loadFunc LoadFunc, cmp CompareFunc, args TransformArgs, onLoaded func(loaded uint64)) error {
 
-	h := &Heap{}
+	h := &Heap{cmp: cmp}
 	heap.Init(h)
 	for i, provider := range providers {
 		if key, value, err := provider.Next(nil, nil); err == nil {
@@ -179,21 +216,22 @@ func loadFilesIntoBucket(logPrefix string, db kv.RwTx, bucket string, bufType in
 	var c kv.RwCursor
 
 	currentTable := &currentTableReader{db, bucket}
-	haveSortingGuaranties := isIdentityLoadFunc(loadFunc) // user-defined loadFunc may change ordering
-	var lastKey []byte
+	// maxK, maxV - last key/value of table: keys > maxK can be appended, no matter what order loadFunc produces
+	var maxK, maxV []byte
+	var tableIsEmpty bool
 	if bucket != "" { // passing empty bucket name is valid case for etl when DB modification is not expected
 		var err error
 		c, err = db.RwCursor(bucket)
 		if err != nil {
 			return err
 		}
-		var errLast error
-		lastKey, _, errLast = c.Last()
-		if errLast != nil {
-			return errLast
+		lastK, lastV, err := c.Last()
+		if err != nil {
+			return err
 		}
+		tableIsEmpty = lastK == nil
+		maxK, maxV = common.Copy(lastK), common.Copy(lastV)
 	}
-	var canUseAppend bool
 	isDupSort := kv.ChaindataTablesCfg[bucket].Flags&kv.DupSort != 0 && !kv.ChaindataTablesCfg[bucket].AutoDupSortKeysConversion
 
 	logEvery := time.NewTicker(30 * time.Second)
@@ -202,10 +240,6 @@ func loadFilesIntoBucket(logPrefix string, db kv.RwTx, bucket string, bufType in
 	i := 0
 	var prevK []byte
 	loadNextFunc := func(originalK, k, v []byte) error {
-		if i == 0 {
-			isEndOfBucket := lastKey == nil || bytes.Compare(lastKey, k) == -1
-			canUseAppend = haveSortingGuaranties && isEndOfBucket
-		}
 		i++
 
 		// SortableOldestAppearedBuffer must guarantee that only 1 oldest value of key will appear
@@ -233,33 +267,48 @@ func loadFilesIntoBucket(logPrefix string, db kv.RwTx, bucket string, bufType in
 			log.Info(fmt.Sprintf("[%s] ETL [2/2] Loading", logPrefix), logArs...)
 		}
 
-		if canUseAppend && len(v) == 0 {
-			return nil // nothing to delete after end of bucket
+		cmpMax := 1
+		if !tableIsEmpty {
+			cmpMax = bytes.Compare(k, maxK)
 		}
 		if len(v) == 0 {
+			if cmpMax > 0 {
+				return nil // nothing to delete after end of bucket
+			}
 			if err := c.Delete(k); err != nil {
 				return err
 			}
+			if cmpMax == 0 { // max is unknown now, but keys > deleted one are still at end of bucket
+				maxV = maxV[:0]
+			}
 			return nil
 		}
-		if canUseAppend {
-			if isDupSort {
-				if err := c.(kv.RwCursorDupSort).AppendDup(k, v); err != nil {
-					return fmt.Errorf("%s: bucket: %s, appendDup: k=%x, %w", logPrefix, bucket, k, err)
-				}
-			} else {
-				if err := c.Append(k, v); err != nil {
-					return fmt.Errorf("%s: bucket: %s, append: k=%x, v=%x, %w", logPrefix, bucket, k, v, err)
-				}
+		canUseAppend := cmpMax > 0 || (isDupSort && cmpMax == 0 && bytes.Compare(v, maxV) > 0)
+		if !canUseAppend {
+			if args.EnforceAppend {
+				return fmt.Errorf("%s: bucket: %s, %w: k=%x, last=%x", logPrefix, bucket, ErrNotAppendable, k, maxK)
+			}
+			if err := c.Put(k, v); err != nil {
+				return fmt.Errorf("%s: put: k=%x, %w", logPrefix, k, err)
 			}
-
 			return nil
 		}
-		if err := c.Put(k, v); err != nil {
-			return fmt.Errorf("%s: put: k=%x, %w", logPrefix, k, err)
+		if isDupSort {
+			if err := c.(kv.RwCursorDupSort).AppendDup(k, v); err != nil {
+				return fmt.Errorf("%s: bucket: %s, appendDup: k=%x, %w", logPrefix, bucket, k, err)
+			}
+		} else {
+			if err := c.Append(k, v); err != nil {
+				return fmt.Errorf("%s: bucket: %s, append: k=%x, v=%x, %w", logPrefix, bucket, k, v, err)
+			}
 		}
+		tableIsEmpty = false
+		maxK, maxV = append(maxK[:0], k...), append(maxV[:0], v...)
 		return nil
 	}
+	var popped []HeapElem // elements of same key, see SortableNewestAppearedBuffer
+	var merged []byte
+	var loaded uint64
 	// Main loading loop
 	for h.Len() > 0 {
 		if err := common.Stopped(args.Quit); err != nil {
@@ -267,19 +316,47 @@ func loadFilesIntoBucket(logPrefix string, db kv.RwTx, bucket string, bufType in
 		}
 
 		element := (heap.Pop(h)).(HeapElem)
-		provider := providers[element.TimeIdx]
-		err := loadFunc(element.Key, element.Value, currentTable, loadNextFunc)
-		if err != nil {
+		k, v := element.Key, element.Value
+		popped = append(popped[:0], element)
+		if bufType == SortableNewestAppearedBuffer || bufType == SortableMergeAppendBuffer {
+			// each flushed file has key only once, but files may overlap: combine values of key from all files.
+			// heap pops same keys in order of files - older first
+			merged = append(merged[:0], v...)
+			for h.Len() > 0 && compareKeys(cmp, h.elems[0].Key, k) == 0 {
+				same := (heap.Pop(h)).(HeapElem)
+				popped = append(popped, same)
+				if bufType == SortableNewestAppearedBuffer {
+					v = same.Value
+				} else {
+					merged = append(merged, same.Value...)
+				}
+			}
+			if bufType == SortableMergeAppendBuffer {
+				v = merged
+			}
+		}
+		if err := loadFunc(k, v, currentTable, loadNextFunc); err != nil {
 			return err
 		}
-		if element.Key, element.Value, err = provider.Next(element.Key[:0], element.Value[:0]); err == nil {
-			heap.Push(h, element)
-		} else if !errors.Is(err, io.EOF) {
-			return fmt.Errorf("%s: error while reading next element from disk: %w", logPrefix, err)
+		loaded += uint64(len(popped))
+		if onLoaded != nil && loaded%progressLoadEvery < uint64(len(popped)) {
+			onLoaded(loaded)
+		}
+		for _, element := range popped {
+			var err error
+			provider := providers[element.TimeIdx]
+			if element.Key, element.Value, err = provider.Next(element.Key[:0], element.Value[:0]); err == nil {
+				heap.Push(h, element)
+			} else if !errors.Is(err, io.EOF) {
+				return fmt.Errorf("%s: error while reading next element from disk: %w", logPrefix, err)
+			}
 		}
 	}
 
 	log.Trace(fmt.Sprintf("[%s] ETL Load done", logPrefix), "bucket", bucket, "records", i)
+	if onLoaded != nil {
+		onLoaded(loaded)
+	}
 
 	return nil
 }
@@ -0,0 +1,88 @@
+/*
+   Copyright 2023 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package etl
+
+import (
+	"encoding/binary"
+
+	"github.com/ledgerwatch/erigon-lib/kv"
+	"github.com/ledgerwatch/log/v3"
+)
+
+// Codec - encoding of keys/values of CollectorFunc in buffers and temp files
+type Codec[T any] struct {
+	Encode func(buf []byte, v T) []byte // Encode - appends encoded v to buf
+	Decode func(data []byte) T          // Decode - `data` is valid only until return: must copy if keeps it
+}
+
+var BytesCodec = Codec[[]byte]{
+	Encode: func(buf []byte, v []byte) []byte { return append(buf, v...) },
+	Decode: func(data []byte) []byte { return data },
+}
+
+var Uint64Codec = Codec[uint64]{
+	Encode: func(buf []byte, v uint64) []byte {
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], v)
+		return append(buf, b[:]...)
+	},
+	Decode: func(data []byte) uint64 { return binary.BigEndian.Uint64(data) },
+}
+
+// LoadFuncTyped - LoadFunc of CollectorFunc. `next` takes encoded key/value - in form they are written to table.
+type LoadFuncTyped[K, V any] func(k K, v V, table CurrentTableReader, next LoadNextFunc) error
+
+// CollectorFunc - Collector of typed keys/values, sorted by `cmp` instead of bytes order of encoded keys.
+// For example: keys (addr, txNum) can be collected in txNum-major order without re-encoding them as txNum+addr.
+//
+// Load into table uses Put (not Append): table has bytes order of keys.
+type CollectorFunc[K, V any] struct {
+	c          *Collector
+	key        Codec[K]
+	val        Codec[V]
+	kBuf, vBuf []byte
+}
+
+// NewCollectorFunc - `cmp` may be nil: then keys are sorted by bytes order of their encoding
+func NewCollectorFunc[K, V any](logPrefix, tmpdir string, sortableBuffer Buffer, cmp func(a, b K) int, key Codec[K], val Codec[V]) *CollectorFunc[K, V] {
+	c := NewCollector(logPrefix, tmpdir, sortableBuffer)
+	if cmp != nil {
+		c.cmp = func(a, b []byte) int { return cmp(key.Decode(a), key.Decode(b)) }
+		setBufferComparator(sortableBuffer, c.cmp)
+	}
+	return &CollectorFunc[K, V]{c: c, key: key, val: val}
+}
+
+func (c *CollectorFunc[K, V]) Collect(k K, v V) error {
+	c.kBuf = c.key.Encode(c.kBuf[:0], k)
+	c.vBuf = c.val.Encode(c.vBuf[:0], v)
+	return c.c.Collect(c.kBuf, c.vBuf)
+}
+
+func (c *CollectorFunc[K, V]) LogLvl(v log.Lvl) { c.c.LogLvl(v) }
+
+// Load - loadFunc == nil means load entries as they are
+func (c *CollectorFunc[K, V]) Load(db kv.RwTx, toBucket string, loadFunc LoadFuncTyped[K, V], args TransformArgs) error {
+	if loadFunc == nil {
+		return c.c.Load(db, toBucket, IdentityLoadFunc, args)
+	}
+	return c.c.Load(db, toBucket, func(k, v []byte, table CurrentTableReader, next LoadNextFunc) error {
+		return loadFunc(c.key.Decode(k), c.val.Decode(v), table, next)
+	}, args)
+}
+
+func (c *CollectorFunc[K, V]) Close() { c.c.Close() }
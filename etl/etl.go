@@ -18,8 +18,8 @@ package etl
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
-	"reflect"
 	"time"
 
 	"github.com/c2h5oh/datasize"
@@ -71,8 +71,12 @@ type TransformArgs struct {
 	ExtractEndKey   []byte
 	BufferType      int
 	BufferSize      int
+	// EnforceAppend - Load returns ErrNotAppendable instead of Put of key which can't be appended to end of table
+	EnforceAppend bool
 }
 
+var ErrNotAppendable = errors.New("key can't be appended")
+
 func Transform(
 	logPrefix string,
 	db kv.RwTx,
@@ -166,7 +170,3 @@ func (s *currentTableReader) Get(key []byte) ([]byte, error) {
 var IdentityLoadFunc LoadFunc = func(k []byte, value []byte, _ CurrentTableReader, next LoadNextFunc) error {
 	return next(k, k, value)
 }
-
-func isIdentityLoadFunc(f LoadFunc) bool {
-	return f == nil || reflect.ValueOf(IdentityLoadFunc).Pointer() == reflect.ValueOf(f).Pointer()
-}
@@ -25,6 +25,8 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/c2h5oh/datasize"
+	"github.com/ledgerwatch/erigon-lib/common"
 	"github.com/ledgerwatch/erigon-lib/kv"
 	"github.com/ledgerwatch/erigon-lib/kv/memdb"
 	"github.com/stretchr/testify/assert"
@@ -361,3 +363,154 @@ func compareBucketsDouble(t *testing.T, db kv.Tx, b1, b2 string) {
 	assert.NoError(t, err)
 	assert.Equal(t, b1Map, b2Map)
 }
+
+func TestCollectorFunc(t *testing.T) {
+	type addrTx struct {
+		addr  byte
+		txNum uint64
+	}
+	key := Codec[addrTx]{
+		Encode: func(buf []byte, k addrTx) []byte { return Uint64Codec.Encode(append(buf, k.addr), k.txNum) },
+		Decode: func(data []byte) addrTx { return addrTx{addr: data[0], txNum: Uint64Codec.Decode(data[1:])} },
+	}
+	txNumMajor := func(a, b addrTx) int {
+		if a.txNum != b.txNum {
+			if a.txNum < b.txNum {
+				return -1
+			}
+			return 1
+		}
+		return int(a.addr) - int(b.addr)
+	}
+
+	for _, buf := range []Buffer{NewSortableBuffer(64), NewSortableBuffer(BufferOptimalSize), NewAppendBuffer(64)} {
+		collector := NewCollectorFunc[addrTx, uint64](t.Name(), t.TempDir(), buf, txNumMajor, key, Uint64Codec)
+		for addr := byte(3); addr > 0; addr-- {
+			for txNum := uint64(0); txNum < 5; txNum++ {
+				assert.NoError(t, collector.Collect(addrTx{addr, 10 - txNum}, txNum))
+			}
+		}
+
+		var got []addrTx
+		err := collector.Load(nil, "", func(k addrTx, v uint64, _ CurrentTableReader, _ LoadNextFunc) error {
+			assert.Equal(t, 10-k.txNum, v)
+			got = append(got, k)
+			return nil
+		}, TransformArgs{})
+		assert.NoError(t, err)
+		assert.Equal(t, 15, len(got))
+		for i := 1; i < len(got); i++ {
+			assert.Negative(t, txNumMajor(got[i-1], got[i]), "%T: %v, %v", buf, got[i-1], got[i])
+		}
+	}
+
+	// loading into table: by Put, table keeps bytes order
+	_, tx := memdb.NewTestTx(t)
+	table := kv.ChaindataTables[0]
+	collector := NewCollectorFunc[addrTx, []byte](t.Name(), t.TempDir(), NewSortableBuffer(64), txNumMajor, key, BytesCodec)
+	assert.NoError(t, collector.Collect(addrTx{2, 1}, []byte{1}))
+	assert.NoError(t, collector.Collect(addrTx{1, 2}, []byte{2}))
+	assert.NoError(t, collector.Collect(addrTx{3, 0}, []byte{3}))
+	assert.NoError(t, collector.Load(tx, table, nil, TransformArgs{}))
+	var loaded [][]byte
+	assert.NoError(t, tx.ForEach(table, nil, func(k, v []byte) error {
+		loaded = append(loaded, v)
+		return nil
+	}))
+	assert.Equal(t, [][]byte{{2}, {1}, {3}}, loaded)
+}
+
+func TestLoadAppendWhenSorted(t *testing.T) {
+	_, tx := memdb.NewTestTx(t)
+	table := kv.HashedAccounts
+	assert.NoError(t, tx.Put(table, []byte{5}, []byte{5}))
+
+	// keys before last key of table are Put, keys after it - appended
+	collector := NewCollector(t.Name(), t.TempDir(), NewSortableBuffer(BufferOptimalSize))
+	for _, k := range []byte{7, 3, 5, 9, 8} {
+		assert.NoError(t, collector.Collect([]byte{k}, []byte{k + 1}))
+	}
+	assert.NoError(t, collector.Load(tx, table, IdentityLoadFunc, TransformArgs{}))
+	var got [][]byte
+	assert.NoError(t, tx.ForEach(table, nil, func(k, v []byte) error {
+		got = append(got, append(common.Copy(k), v...))
+		return nil
+	}))
+	assert.Equal(t, [][]byte{{3, 4}, {5, 6}, {7, 8}, {8, 9}, {9, 10}}, got)
+
+	// loadFunc may produce keys in any order
+	collector = NewCollector(t.Name(), t.TempDir(), NewSortableBuffer(BufferOptimalSize))
+	assert.NoError(t, collector.Collect([]byte{10}, []byte{1}))
+	assert.NoError(t, collector.Collect([]byte{11}, []byte{1}))
+	assert.NoError(t, collector.Load(tx, table, func(k, v []byte, _ CurrentTableReader, next LoadNextFunc) error {
+		return next(k, []byte{20 - k[0]}, v) // 10, 9
+	}, TransformArgs{}))
+	v, err := tx.GetOne(table, []byte{9})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{1}, v)
+
+	// EnforceAppend
+	collector = NewCollector(t.Name(), t.TempDir(), NewSortableBuffer(BufferOptimalSize))
+	assert.NoError(t, collector.Collect([]byte{1}, []byte{1}))
+	err = collector.Load(tx, table, IdentityLoadFunc, TransformArgs{EnforceAppend: true})
+	assert.ErrorIs(t, err, ErrNotAppendable)
+
+	// DupSort: values of last key appended if bigger than last value
+	assert.NoError(t, tx.Put(kv.AccountChangeSet, []byte{1}, []byte{5}))
+	collector = NewCollector(t.Name(), t.TempDir(), NewSortableBuffer(BufferOptimalSize))
+	for _, v := range []byte{6, 7, 3} {
+		assert.NoError(t, collector.Collect([]byte{1}, []byte{v}))
+	}
+	assert.NoError(t, collector.Collect([]byte{2}, []byte{1}))
+	assert.NoError(t, collector.Load(tx, kv.AccountChangeSet, IdentityLoadFunc, TransformArgs{}))
+	got = nil
+	assert.NoError(t, tx.ForEach(kv.AccountChangeSet, nil, func(k, v []byte) error {
+		got = append(got, append(common.Copy(k), v...))
+		return nil
+	}))
+	assert.Equal(t, [][]byte{{1, 3}, {1, 5}, {1, 6}, {1, 7}, {2, 1}}, got)
+}
+
+func TestDedupBuffers(t *testing.T) {
+	_, tx := memdb.NewTestTx(t)
+	collect := func(buf Buffer) (got [][]byte) {
+		collector := NewCollector(t.Name(), t.TempDir(), buf)
+		for _, kv := range [][2]byte{{1, 1}, {2, 1}, {1, 2}, {3, 1}, {1, 3}, {2, 2}} {
+			assert.NoError(t, collector.Collect([]byte{kv[0]}, []byte{kv[1]}))
+		}
+		assert.NoError(t, collector.Load(tx, "", func(k, v []byte, _ CurrentTableReader, _ LoadNextFunc) error {
+			got = append(got, append(common.Copy(k), v...))
+			return nil
+		}, TransformArgs{}))
+		return got
+	}
+	for _, size := range []datasize.ByteSize{1, BufferOptimalSize} { // flush after each Collect, and RAM only
+		assert.Equal(t, [][]byte{{1, 3}, {2, 2}, {3, 1}}, collect(NewNewestEntryBuffer(size)), size)
+		assert.Equal(t, [][]byte{{1, 1, 2, 3}, {2, 1, 2}, {3, 1}}, collect(NewMergeAppendBuffer(size)), size)
+	}
+	assert.Equal(t, SortableNewestAppearedBuffer, getTypeByBuffer(getBufferByType(SortableNewestAppearedBuffer, 1)))
+	assert.Equal(t, SortableMergeAppendBuffer, getTypeByBuffer(getBufferByType(SortableMergeAppendBuffer, 1)))
+}
+
+func TestCollectorProgress(t *testing.T) {
+	_, tx := memdb.NewTestTx(t)
+	var reports []Progress
+	collector := NewCollector(t.Name(), t.TempDir(), NewSortableBuffer(1)) // spill after each Collect
+	collector.SetProgress(func(p Progress) { reports = append(reports, p) })
+	collector.SetMetrics(t.Name())
+	for _, k := range []byte{3, 1, 2} {
+		assert.NoError(t, collector.Collect([]byte{k}, []byte{k, k}))
+	}
+	assert.Equal(t, []Progress{
+		{Collected: 1, CollectedBytes: 3, SpillFiles: 1},
+		{Collected: 2, CollectedBytes: 6, SpillFiles: 2},
+		{Collected: 3, CollectedBytes: 9, SpillFiles: 3},
+	}, reports)
+	assert.NoError(t, collector.Load(tx, kv.HashedAccounts, IdentityLoadFunc, TransformArgs{}))
+	assert.Equal(t, Progress{Collected: 3, CollectedBytes: 9, SpillFiles: 3, Loaded: 3}, reports[len(reports)-1])
+
+	var metrics bytes.Buffer
+	etlMetrics.WritePrometheus(&metrics)
+	assert.Contains(t, metrics.String(), fmt.Sprintf("etl_spill_files{collector=\"%s\"} 3\n", t.Name()))
+	assert.Contains(t, metrics.String(), fmt.Sprintf("etl_loaded{collector=\"%s\"} 3\n", t.Name()))
+}
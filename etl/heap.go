@@ -16,10 +16,6 @@
 
 package etl
 
-import (
-	"bytes"
-)
-
 type HeapElem struct {
 	Key     []byte
 	Value   []byte
@@ -28,6 +24,7 @@ type HeapElem struct {
 
 type Heap struct {
 	elems []HeapElem
+	cmp   CompareFunc
 }
 
 func (h Heap) Len() int {
@@ -35,7 +32,7 @@ func (h Heap) Len() int {
 }
 
 func (h Heap) Less(i, j int) bool {
-	if c := bytes.Compare(h.elems[i].Key, h.elems[j].Key); c != 0 {
+	if c := compareKeys(h.cmp, h.elems[i].Key, h.elems[j].Key); c != 0 {
 		return c < 0
 	}
 	return h.elems[i].TimeIdx < h.elems[j].TimeIdx
@@ -16,9 +16,71 @@
 
 package etl
 
+import (
+	"fmt"
+
+	"github.com/ledgerwatch/erigon-lib/common/metrics"
+)
+
 func ProgressFromKey(k []byte) int {
 	if len(k) < 1 {
 		return 0
 	}
 	return int(float64(k[0]>>4) * 3.3)
 }
+
+// Progress - counters of Collector since creation, see Collector.SetProgress
+type Progress struct {
+	Collected      uint64 // entries passed to Collect
+	CollectedBytes uint64 // keys and values passed to Collect
+	SpillFiles     int    // buffers flushed to temp files
+	Loaded         uint64 // entries passed to loadFunc by Load, Collected is upper bound (repeated keys are combined)
+}
+
+// progressLoadEvery - Load reports progress once per this amount of entries
+const progressLoadEvery = 64 * 1024
+
+var etlMetrics = metrics.GetOrCreateNamespace("etl")
+
+// collectorMetrics - counters of all collectors of one name, updated by deltas at reports of progress: Collect
+// doesn't touch them
+type collectorMetrics struct {
+	collected, collectedBytes, spillFiles, loaded *metrics.Counter
+	reported                                      Progress
+}
+
+func newCollectorMetrics(name string) *collectorMetrics {
+	return &collectorMetrics{
+		collected:      etlMetrics.Counter(fmt.Sprintf(`etl_collected{collector="%s"}`, name)),
+		collectedBytes: etlMetrics.Counter(fmt.Sprintf(`etl_collected_bytes{collector="%s"}`, name)),
+		spillFiles:     etlMetrics.Counter(fmt.Sprintf(`etl_spill_files{collector="%s"}`, name)),
+		loaded:         etlMetrics.Counter(fmt.Sprintf(`etl_loaded{collector="%s"}`, name)),
+	}
+}
+
+func (m *collectorMetrics) update(p Progress) {
+	m.collected.Add(int(p.Collected - m.reported.Collected))
+	m.collectedBytes.Add(int(p.CollectedBytes - m.reported.CollectedBytes))
+	m.spillFiles.Add(p.SpillFiles - m.reported.SpillFiles)
+	m.loaded.Add(int(p.Loaded - m.reported.Loaded))
+	m.reported = p
+}
+
+// SetProgress - `f` is called after each spill of buffer to temp file, during Load (every 64K entries) and at the
+// end of Load. Called by goroutine of Collect/Load.
+func (c *Collector) SetProgress(f func(Progress)) { c.onProgress = f }
+
+// SetMetrics - counters etl_collected, etl_collected_bytes, etl_spill_files, etl_loaded with label collector=`name`
+// (namespace "etl" of common/metrics). Updated at same points as SetProgress hook.
+func (c *Collector) SetMetrics(name string) { c.metrics = newCollectorMetrics(name) }
+
+func (c *Collector) Progress() Progress { return c.progress }
+
+func (c *Collector) reportProgress() {
+	if c.metrics != nil {
+		c.metrics.update(c.progress)
+	}
+	if c.onProgress != nil {
+		c.onProgress(c.progress)
+	}
+}
@@ -17,10 +17,15 @@
 package bitmapdb_test
 
 import (
+	"encoding/binary"
+	"math"
 	"testing"
 
 	"github.com/RoaringBitmap/roaring"
+	"github.com/RoaringBitmap/roaring/roaring64"
+	"github.com/ledgerwatch/erigon-lib/kv"
 	"github.com/ledgerwatch/erigon-lib/kv/bitmapdb"
+	"github.com/ledgerwatch/erigon-lib/kv/memdb"
 	"github.com/stretchr/testify/require"
 )
 
@@ -69,3 +74,50 @@ func TestCutLeft(t *testing.T) {
 	require.True(t, lft == nil)
 	require.True(t, bm.GetCardinality() == 0)
 }
+
+func TestShards64(t *testing.T) {
+	_, tx := memdb.NewTestTx(t)
+	key := []byte("addr")
+	const shardSize = 16
+
+	bm := roaring64.New()
+	bm.AddRange(0, 100)
+	require.NoError(t, bitmapdb.PutShards64(tx, kv.AccountsHistory, key, bm, shardSize))
+	require.True(t, bm.IsEmpty())
+	bm = roaring64.BitmapOf(3, 200)
+	require.NoError(t, bitmapdb.PutShards64(tx, kv.AccountsHistory, key, bm, shardSize))
+
+	shards := 0
+	require.NoError(t, tx.ForPrefix(kv.AccountsHistory, key, func(k, v []byte) error {
+		shards++
+		shardTo := binary.BigEndian.Uint64(k[len(key):])
+		require.Equal(t, uint64(shardSize-1), shardTo%shardSize)
+		return nil
+	}))
+	require.Equal(t, 8, shards) // 7 shards of [0, 100) and shard of 200
+
+	all, err := bitmapdb.Get64(tx, kv.AccountsHistory, key, 0, math.MaxUint64)
+	require.NoError(t, err)
+	require.Equal(t, uint64(101), all.GetCardinality())
+
+	require.NoError(t, bitmapdb.PruneShards64(tx, kv.AccountsHistory, key, 5, 40, shardSize))
+	all, err = bitmapdb.Get64(tx, kv.AccountsHistory, key, 0, math.MaxUint64)
+	require.NoError(t, err)
+	require.Equal(t, uint64(101-35), all.GetCardinality())
+	require.False(t, all.Contains(5))
+	require.False(t, all.Contains(39))
+	require.True(t, all.Contains(4))
+	require.True(t, all.Contains(40))
+	v, err := tx.GetOne(kv.AccountsHistory, bitmapdb.ShardKey64(nil, key, 20, shardSize))
+	require.NoError(t, err)
+	require.Nil(t, v) // shard [16, 32) fully pruned
+
+	require.NoError(t, bitmapdb.TrimShards64(tx, kv.AccountsHistory, key, 50, shardSize))
+	all, err = bitmapdb.Get64(tx, kv.AccountsHistory, key, 0, math.MaxUint64)
+	require.NoError(t, err)
+	require.Equal(t, uint64(5+10), all.GetCardinality())
+	require.Equal(t, uint64(49), all.Maximum())
+
+	require.Equal(t, uint64(100), bitmapdb.LimitTo(10, 100, 0))
+	require.Equal(t, uint64(15), bitmapdb.LimitTo(10, 100, 5))
+}
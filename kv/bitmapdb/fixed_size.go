@@ -72,6 +72,8 @@ func OpenFixedSizeBitmaps(indexFile string, bitsPerBitmap int) (*FixedSizeBitmap
 	return idx, nil
 }
 
+func (bm *FixedSizeBitmaps) FilePath() string { return bm.indexFile }
+
 func (bm *FixedSizeBitmaps) Close() {
 	if bm.m != nil {
 		_ = bm.m.Unmap()
@@ -0,0 +1,188 @@
+/*
+   Copyright 2023 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package bitmapdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/RoaringBitmap/roaring/roaring64"
+	libcommon "github.com/ledgerwatch/erigon-lib/common"
+	"github.com/ledgerwatch/erigon-lib/common/cmp"
+	"github.com/ledgerwatch/erigon-lib/kv"
+)
+
+// Fixed-size shards of 64-bit bitmaps (of txNums): shard N contains txNums [N*shardSize, (N+1)*shardSize) and stored
+// with key `key + bigEndian(last txNum of shard)`. Unlike chunks of WalkChunkWithKeys64 (limited by size in bytes) -
+// shard of any txNum is known without reading DB, then adding/trimming/pruning of txNum range touches only shards of this range.
+// Key suffix is max txNum of shard (as in chunks) - Get64 can read shards.
+
+// ShardTo - last txNum of shard which contains txNum
+func ShardTo(txNum, shardSize uint64) uint64 {
+	shardFrom := txNum - txNum%shardSize
+	if shardFrom > math.MaxUint64-(shardSize-1) {
+		return math.MaxUint64
+	}
+	return shardFrom + shardSize - 1
+}
+
+// ShardKey64 - appends to buf key of shard which contains txNum
+func ShardKey64(buf, key []byte, txNum, shardSize uint64) []byte {
+	var numBuf [8]byte
+	binary.BigEndian.PutUint64(numBuf[:], ShardTo(txNum, shardSize))
+	buf = append(buf, key...)
+	return append(buf, numBuf[:]...)
+}
+
+// CutShard64 - cut from bitmap first shard: removing it from `bm`
+// returns nil on zero cardinality
+func CutShard64(bm *roaring64.Bitmap, shardSize uint64) (shardTo uint64, shard *roaring64.Bitmap) {
+	if bm.IsEmpty() {
+		return 0, nil
+	}
+	from := bm.Minimum()
+	shardTo = ShardTo(from, shardSize)
+	if shardTo >= bm.Maximum() {
+		shard = bm.Clone()
+		bm.Clear()
+		shard.RunOptimize()
+		return shardTo, shard
+	}
+	shard = roaring64.New()
+	shard.AddRange(from, shardTo+1)
+	shard.And(bm)
+	bm.RemoveRange(from, shardTo+1)
+	shard.RunOptimize()
+	return shardTo, shard
+}
+
+// WalkShards64 - cuts `bm` to shards (bm is empty after it)
+func WalkShards64(bm *roaring64.Bitmap, shardSize uint64, f func(shardTo uint64, shard *roaring64.Bitmap) error) error {
+	for !bm.IsEmpty() {
+		if err := f(CutShard64(bm, shardSize)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func WalkShardsWithKeys64(k []byte, bm *roaring64.Bitmap, shardSize uint64, f func(shardKey []byte, shard *roaring64.Bitmap) error) error {
+	return WalkShards64(bm, shardSize, func(shardTo uint64, shard *roaring64.Bitmap) error {
+		shardKey := make([]byte, len(k)+8)
+		copy(shardKey, k)
+		binary.BigEndian.PutUint64(shardKey[len(k):], shardTo)
+		return f(shardKey, shard)
+	})
+}
+
+// PutShards64 - adds `bm` to existing shards of `key` (bm is empty after it)
+func PutShards64(tx kv.RwTx, bucket string, key []byte, bm *roaring64.Bitmap, shardSize uint64) error {
+	buf := bytes.NewBuffer(nil)
+	return WalkShardsWithKeys64(key, bm, shardSize, func(shardKey []byte, shard *roaring64.Bitmap) error {
+		v, err := tx.GetOne(bucket, shardKey)
+		if err != nil {
+			return err
+		}
+		if len(v) > 0 {
+			existing := NewBitmap64()
+			defer ReturnToPool64(existing)
+			if _, err := existing.ReadFrom(bytes.NewReader(v)); err != nil {
+				return fmt.Errorf("read shard %x: %w", shardKey, err)
+			}
+			shard.Or(existing)
+			shard.RunOptimize()
+		}
+		buf.Reset()
+		if _, err := shard.WriteTo(buf); err != nil {
+			return err
+		}
+		return tx.Put(bucket, shardKey, libcommon.Copy(buf.Bytes()))
+	})
+}
+
+// PruneShards64 - removes [from, to) txNums from shards of `key`. Reads only shards partially overlapping with range,
+// shards fully inside of range and shards which become empty are deleted.
+// !Important: [from, to)
+func PruneShards64(tx kv.RwTx, bucket string, key []byte, from, to, shardSize uint64) error {
+	if from >= to {
+		return nil
+	}
+	c, err := tx.RwCursor(bucket)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	buf := bytes.NewBuffer(nil)
+	for k, v, err := c.Seek(ShardKey64(nil, key, from, shardSize)); k != nil; k, v, err = c.Next() {
+		if err != nil {
+			return err
+		}
+		if len(k) != len(key)+8 || !bytes.HasPrefix(k, key) {
+			break
+		}
+		shardTo := binary.BigEndian.Uint64(k[len(key):])
+		shardFrom := shardTo - shardTo%shardSize
+		if shardFrom >= to {
+			break
+		}
+		if shardFrom >= from && shardTo < to {
+			if err = c.DeleteCurrent(); err != nil {
+				return err
+			}
+			continue
+		}
+		bm := NewBitmap64()
+		defer ReturnToPool64(bm)
+		if _, err = bm.ReadFrom(bytes.NewReader(v)); err != nil {
+			return fmt.Errorf("read shard %x: %w", k, err)
+		}
+		bm.RemoveRange(cmp.Max(from, shardFrom), cmp.Min(to, shardTo)) // RemoveRange can't include MaxUint64
+		if to > shardTo {
+			bm.Remove(shardTo)
+		}
+		if bm.IsEmpty() {
+			if err = c.DeleteCurrent(); err != nil {
+				return err
+			}
+			continue
+		}
+		bm.RunOptimize()
+		buf.Reset()
+		if _, err = bm.WriteTo(buf); err != nil {
+			return err
+		}
+		if err = c.Put(libcommon.Copy(k), libcommon.Copy(buf.Bytes())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TrimShards64 - removes txNums >= `to` from shards of `key` (unwind)
+func TrimShards64(tx kv.RwTx, bucket string, key []byte, to, shardSize uint64) error {
+	return PruneShards64(tx, bucket, key, to, math.MaxUint64, shardSize)
+}
+
+// LimitTo - end of range [from, to) which contains not more than `limit` txNums. limit=0 and limit=MaxUint64 mean no limit.
+func LimitTo(from, to, limit uint64) uint64 {
+	if limit == math.MaxUint64 || limit == 0 {
+		return to
+	}
+	return cmp.Min(to, from+limit)
+}
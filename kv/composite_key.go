@@ -0,0 +1,105 @@
+/*
+   Copyright 2023 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package kv
+
+import "fmt"
+
+// ToDupSort - transformation which cursors of tables with AutoDupSortKeysConversion do before write:
+// key of len DupFromLen broken into DupSort key/value
+//
+//	v = append(k[DupToLen:], v...)
+//	k = k[:DupToLen]
+//
+// other keys returned as-is. dupV reuses spare capacity of `k` - as cursors do on hot path, caller must not use `k`
+// after it.
+func (c TableCfgItem) ToDupSort(k, v []byte) (dupK, dupV []byte) {
+	if !c.AutoDupSortKeysConversion || len(k) != c.DupFromLen {
+		return k, v
+	}
+	return k[:c.DupToLen], append(k[c.DupToLen:], v...)
+}
+
+// FromDupSort - opposite of ToDupSort, transformation which cursors of tables with AutoDupSortKeysConversion do after read.
+// k is appended to `dupK` - reuses it's spare capacity if any (db-owned keys have none, then it's a copy).
+func (c TableCfgItem) FromDupSort(dupK, dupV []byte) (k, v []byte) {
+	if !c.AutoDupSortKeysConversion || len(dupK) != c.DupToLen {
+		return dupK, dupV
+	}
+	keyPart := c.DupFromLen - c.DupToLen
+	return append(dupK, dupV[:keyPart]...), dupV[keyPart:]
+}
+
+// CompositeKey - builds key from parts. For tables with AutoDupSortKeysConversion (PlainState, HashedStorage, ...)
+// key of len DupFromLen (for example: address+incarnation+location) will be stored as DupSort key/value,
+// app code must not do this split by hands. See `TableCfgItem`.
+func CompositeKey(parts ...[]byte) []byte {
+	var n int
+	for _, p := range parts {
+		n += len(p)
+	}
+	k := make([]byte, 0, n)
+	for _, p := range parts {
+		k = append(k, p...)
+	}
+	return k
+}
+
+// SplitCompositeKey - returns DupSort key and sub-key of key built by CompositeKey.
+// For keys which are not composite sub-key is nil.
+func SplitCompositeKey(table string, k []byte) (key, subKey []byte) {
+	cfg := tableCfgItem(table)
+	if !cfg.AutoDupSortKeysConversion || len(k) != cfg.DupFromLen {
+		return k, nil
+	}
+	return k[:cfg.DupToLen], k[cfg.DupToLen:]
+}
+
+// GetComposite - reads value by key built from parts
+func GetComposite(tx Getter, table string, parts ...[]byte) ([]byte, error) {
+	k, err := checkedCompositeKey(table, parts)
+	if err != nil {
+		return nil, err
+	}
+	return tx.GetOne(table, k)
+}
+
+// PutComposite - writes value by key built from parts
+func PutComposite(tx Putter, table string, v []byte, parts ...[]byte) error {
+	k, err := checkedCompositeKey(table, parts)
+	if err != nil {
+		return err
+	}
+	return tx.Put(table, k, v)
+}
+
+// checkedCompositeKey - keys of tables with AutoDupSortKeysConversion can be of len DupFromLen or shorter than DupToLen
+func checkedCompositeKey(table string, parts [][]byte) ([]byte, error) {
+	k := CompositeKey(parts...)
+	if cfg := tableCfgItem(table); cfg.AutoDupSortKeysConversion && len(k) != cfg.DupFromLen && len(k) >= cfg.DupToLen {
+		return nil, fmt.Errorf("composite key of table %s, can have keys of len==%d and len<%d. key: %x,%d", table, cfg.DupFromLen, cfg.DupToLen, k, len(k))
+	}
+	return k, nil
+}
+
+func tableCfgItem(table string) TableCfgItem {
+	for _, cfg := range []TableCfg{ChaindataTablesCfg, TxpoolTablesCfg, SentryTablesCfg, DownloaderTablesCfg, ReconTablesCfg} {
+		if item, ok := cfg[table]; ok {
+			return item
+		}
+	}
+	return TableCfgItem{}
+}
@@ -0,0 +1,102 @@
+/*
+   Copyright 2023 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package kv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ledgerwatch/erigon-lib/common/dir"
+)
+
+// HealthStatus - result of RoDB.HealthCheck. Can be serialized as response of readiness probe.
+type HealthStatus struct {
+	ReadLatency  time.Duration `json:"readLatency"`  // read tx: begin, read, rollback
+	WriteLatency time.Duration `json:"writeLatency"` // write tx to temporary table: begin, put, commit. 0 - not checked (read-only DB)
+	FreeSpace    uint64        `json:"freeSpace"`    // bytes available on filesystem of DB. 0 - unknown (remote DB)
+	Error        string        `json:"error,omitempty"`
+}
+
+// HealthMinFreeSpace - HealthCheck fails with ErrLowFreeSpace if less space is available
+const HealthMinFreeSpace = 256 * 1024 * 1024
+
+var ErrLowFreeSpace = errors.New("low free space")
+
+// HealthCheck - shared implementation of RoDB.HealthCheck. `path` - DB's dir, "" - free space is not checked.
+// Write is checked if `db` is RwDB and not read-only - on temporary table, so doesn't leave any data.
+func HealthCheck(ctx context.Context, db RoDB, path string) (st HealthStatus, err error) {
+	defer func() {
+		if err != nil {
+			st.Error = err.Error()
+		}
+	}()
+
+	start := time.Now()
+	if err = db.View(ctx, func(tx Tx) error {
+		table := healthCheckTable(db)
+		if table == "" {
+			return nil
+		}
+		_, err := tx.GetOne(table, []byte{0})
+		return err
+	}); err != nil {
+		return st, fmt.Errorf("health check read: %w", err)
+	}
+	st.ReadLatency = time.Since(start)
+
+	if rwDB, ok := db.(RwDB); ok && !db.ReadOnly() {
+		start = time.Now()
+		if err = rwDB.Update(ctx, func(tx RwTx) error {
+			name, err := tx.CreateTemporaryBucket("health")
+			if err != nil {
+				return err
+			}
+			return tx.Put(name, []byte{0}, []byte{0})
+		}); err != nil {
+			return st, fmt.Errorf("health check write: %w", err)
+		}
+		st.WriteLatency = time.Since(start)
+	}
+
+	if path != "" {
+		if st.FreeSpace, err = dir.FreeSpace(path); err != nil {
+			return st, fmt.Errorf("health check free space: %w", err)
+		}
+		if st.FreeSpace < HealthMinFreeSpace {
+			return st, fmt.Errorf("%w: %d bytes available in %s", ErrLowFreeSpace, st.FreeSpace, path)
+		}
+	}
+	return st, nil
+}
+
+// healthCheckTable - any not deprecated table of DB. "" - DB has no tables
+func healthCheckTable(db RoDB) string {
+	var names []string
+	for name, cfg := range db.AllBuckets() {
+		if !cfg.IsDeprecated {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	sort.Strings(names)
+	return names[0]
+}
@@ -17,7 +17,9 @@
 package kv
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
 	"fmt"
 	"os"
 	"time"
@@ -163,6 +165,26 @@ func ReadAhead(ctx context.Context, db RoDB, progress *atomic.Bool, table string
 	}()
 }
 
+// CtxCheckInterval - how often (in entries) ForEachCtx/ForPrefixCtx check cancellation of ctx
+const CtxCheckInterval = 1024
+
+// WalkerWithCtx - wraps walker of ForEach/ForPrefix: it returns ctx.Err() once ctx is cancelled. For implementations
+// of Getter.ForEachCtx/ForPrefixCtx.
+func WalkerWithCtx(ctx context.Context, walker func(k, v []byte) error) func(k, v []byte) error {
+	var i uint64
+	return func(k, v []byte) error {
+		if i%CtxCheckInterval == 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			default:
+			}
+		}
+		i++
+		return walker(k, v)
+	}
+}
+
 // FirstKey - candidate on move to kv.Tx interface
 func FirstKey(tx Tx, table string) ([]byte, error) {
 	c, err := tx.Cursor(table)
@@ -205,3 +227,51 @@ func NextSubtree(in []byte) ([]byte, bool) {
 	}
 	return nil, false
 }
+
+// SeekMultiExact - SeekMulti by SeekExact of each key, for cursors which can't do better
+func SeekMultiExact(c Cursor, keys [][]byte) ([][]byte, error) {
+	vals := make([][]byte, len(keys))
+	for i, key := range keys {
+		if i > 0 && bytes.Compare(keys[i-1], key) > 0 {
+			return nil, fmt.Errorf("SeekMulti: %w, %x > %x", ErrKeysNotSorted, keys[i-1], key)
+		}
+		_, v, err := c.SeekExact(key)
+		if err != nil {
+			return nil, err
+		}
+		vals[i] = v
+	}
+	return vals, nil
+}
+
+// SeekToRatioByKeys - SeekToRatio by interpolation between first and last keys of table: precise for uniformly
+// distributed keys (hashes, addresses), rough otherwise. For cursors which can't do better.
+func SeekToRatioByKeys(c Cursor, f float64) ([]byte, []byte, error) {
+	first, v, err := c.First()
+	if err != nil || first == nil || !(f > 0) { // !(f > 0) - also NaN
+		return first, v, err
+	}
+	first = common.Copy(first)
+	last, v, err := c.Last()
+	if err != nil || f >= 1 {
+		return last, v, err
+	}
+	return c.Seek(InterpolateKey(first, last, f))
+}
+
+// InterpolateKey - key approximately `f` (0..1) of the way from `from` to `to` (from <= to). Keys are treated as
+// big-endian numbers: only 8 bytes after common prefix are taken into account.
+func InterpolateKey(from, to []byte, f float64) []byte {
+	p := 0
+	for p < len(from) && p < len(to) && from[p] == to[p] {
+		p++
+	}
+	var a, b [8]byte
+	copy(a[:], from[p:])
+	copy(b[:], to[p:])
+	lo, hi := binary.BigEndian.Uint64(a[:]), binary.BigEndian.Uint64(b[:])
+	res := make([]byte, p+8)
+	copy(res, from[:p])
+	binary.BigEndian.PutUint64(res[p:], lo+uint64(f*float64(hi-lo)))
+	return res
+}
@@ -20,7 +20,7 @@ import (
 	"context"
 	"errors"
 
-	"github.com/VictoriaMetrics/metrics"
+	"github.com/ledgerwatch/erigon-lib/common/metrics"
 	"github.com/ledgerwatch/erigon-lib/kv/iter"
 	"github.com/ledgerwatch/erigon-lib/kv/order"
 )
@@ -55,63 +55,65 @@ var (
 	ErrAttemptToDeleteNonDeprecatedBucket = errors.New("only buckets from dbutils.ChaindataDeprecatedTables can be deleted")
 	ErrUnknownBucket                      = errors.New("unknown bucket. add it to dbutils.ChaindataTables")
 
-	DbSize    = metrics.NewCounter(`db_size`)    //nolint
-	TxLimit   = metrics.NewCounter(`tx_limit`)   //nolint
-	TxSpill   = metrics.NewCounter(`tx_spill`)   //nolint
-	TxUnspill = metrics.NewCounter(`tx_unspill`) //nolint
-	TxDirty   = metrics.NewCounter(`tx_dirty`)   //nolint
-
-	DbCommitPreparation = metrics.GetOrCreateSummary(`db_commit_seconds{phase="preparation"}`)   //nolint
-	DbGCWallClock       = metrics.GetOrCreateSummary(`db_commit_seconds{phase="gc_wall_clock"}`) //nolint
-	DbGCCpuTime         = metrics.GetOrCreateSummary(`db_commit_seconds{phase="gc_cpu_time"}`)   //nolint
-	DbCommitAudit       = metrics.GetOrCreateSummary(`db_commit_seconds{phase="audit"}`)         //nolint
-	DbCommitWrite       = metrics.GetOrCreateSummary(`db_commit_seconds{phase="write"}`)         //nolint
-	DbCommitSync        = metrics.GetOrCreateSummary(`db_commit_seconds{phase="sync"}`)          //nolint
-	DbCommitEnding      = metrics.GetOrCreateSummary(`db_commit_seconds{phase="ending"}`)        //nolint
-	DbCommitTotal       = metrics.GetOrCreateSummary(`db_commit_seconds{phase="total"}`)         //nolint
-
-	DbPgopsNewly    = metrics.NewCounter(`db_pgops{phase="newly"}`)    //nolint
-	DbPgopsCow      = metrics.NewCounter(`db_pgops{phase="cow"}`)      //nolint
-	DbPgopsClone    = metrics.NewCounter(`db_pgops{phase="clone"}`)    //nolint
-	DbPgopsSplit    = metrics.NewCounter(`db_pgops{phase="split"}`)    //nolint
-	DbPgopsMerge    = metrics.NewCounter(`db_pgops{phase="merge"}`)    //nolint
-	DbPgopsSpill    = metrics.NewCounter(`db_pgops{phase="spill"}`)    //nolint
-	DbPgopsUnspill  = metrics.NewCounter(`db_pgops{phase="unspill"}`)  //nolint
-	DbPgopsWops     = metrics.NewCounter(`db_pgops{phase="wops"}`)     //nolint
-	DbPgopsPrefault = metrics.NewCounter(`db_pgops{phase="prefault"}`) //nolint
-	DbPgopsMinicore = metrics.NewCounter(`db_pgops{phase="minicore"}`) //nolint
-	DbPgopsMsync    = metrics.NewCounter(`db_pgops{phase="msync"}`)    //nolint
-	DbPgopsFsync    = metrics.NewCounter(`db_pgops{phase="fsync"}`)    //nolint
-	DbMiLastPgNo    = metrics.NewCounter(`db_mi_last_pgno`)            //nolint
-
-	DbGcWorkRtime    = metrics.GetOrCreateSummary(`db_gc_seconds{phase="work_rtime"}`) //nolint
-	DbGcWorkRsteps   = metrics.NewCounter(`db_gc{phase="work_rsteps"}`)                //nolint
-	DbGcWorkRxpages  = metrics.NewCounter(`db_gc{phase="work_rxpages"}`)               //nolint
-	DbGcSelfRtime    = metrics.GetOrCreateSummary(`db_gc_seconds{phase="self_rtime"}`) //nolint
-	DbGcSelfXtime    = metrics.GetOrCreateSummary(`db_gc_seconds{phase="self_xtime"}`) //nolint
-	DbGcWorkXtime    = metrics.GetOrCreateSummary(`db_gc_seconds{phase="work_xtime"}`) //nolint
-	DbGcSelfRsteps   = metrics.NewCounter(`db_gc{phase="self_rsteps"}`)                //nolint
-	DbGcWloops       = metrics.NewCounter(`db_gc{phase="wloop"}`)                      //nolint
-	DbGcCoalescences = metrics.NewCounter(`db_gc{phase="coalescences"}`)               //nolint
-	DbGcWipes        = metrics.NewCounter(`db_gc{phase="wipes"}`)                      //nolint
-	DbGcFlushes      = metrics.NewCounter(`db_gc{phase="flushes"}`)                    //nolint
-	DbGcKicks        = metrics.NewCounter(`db_gc{phase="kicks"}`)                      //nolint
-	DbGcWorkMajflt   = metrics.NewCounter(`db_gc{phase="work_majflt"}`)                //nolint
-	DbGcSelfMajflt   = metrics.NewCounter(`db_gc{phase="self_majflt"}`)                //nolint
-	DbGcWorkCounter  = metrics.NewCounter(`db_gc{phase="work_counter"}`)               //nolint
-	DbGcSelfCounter  = metrics.NewCounter(`db_gc{phase="self_counter"}`)               //nolint
-	DbGcSelfXpages   = metrics.NewCounter(`db_gc{phase="self_xpages"}`)                //nolint
-
-	//DbGcWorkPnlMergeTime   = metrics.GetOrCreateSummary(`db_gc_pnl_seconds{phase="work_merge_time"}`) //nolint
-	//DbGcWorkPnlMergeVolume = metrics.NewCounter(`db_gc_pnl{phase="work_merge_volume"}`)               //nolint
-	//DbGcWorkPnlMergeCalls  = metrics.NewCounter(`db_gc{phase="work_merge_calls"}`)                    //nolint
-	//DbGcSelfPnlMergeTime   = metrics.GetOrCreateSummary(`db_gc_pnl_seconds{phase="slef_merge_time"}`) //nolint
-	//DbGcSelfPnlMergeVolume = metrics.NewCounter(`db_gc_pnl{phase="self_merge_volume"}`)               //nolint
-	//DbGcSelfPnlMergeCalls  = metrics.NewCounter(`db_gc_pnl{phase="slef_merge_calls"}`)                //nolint
-
-	GcLeafMetric     = metrics.NewCounter(`db_gc_leaf`)     //nolint
-	GcOverflowMetric = metrics.NewCounter(`db_gc_overflow`) //nolint
-	GcPagesMetric    = metrics.NewCounter(`db_gc_pages`)    //nolint
+	kvMetrics = metrics.GetOrCreateNamespace("kv")
+
+	DbSize    = kvMetrics.Counter(`db_size`)    //nolint
+	TxLimit   = kvMetrics.Counter(`tx_limit`)   //nolint
+	TxSpill   = kvMetrics.Counter(`tx_spill`)   //nolint
+	TxUnspill = kvMetrics.Counter(`tx_unspill`) //nolint
+	TxDirty   = kvMetrics.Counter(`tx_dirty`)   //nolint
+
+	DbCommitPreparation = kvMetrics.Summary(`db_commit_seconds{phase="preparation"}`)   //nolint
+	DbGCWallClock       = kvMetrics.Summary(`db_commit_seconds{phase="gc_wall_clock"}`) //nolint
+	DbGCCpuTime         = kvMetrics.Summary(`db_commit_seconds{phase="gc_cpu_time"}`)   //nolint
+	DbCommitAudit       = kvMetrics.Summary(`db_commit_seconds{phase="audit"}`)         //nolint
+	DbCommitWrite       = kvMetrics.Summary(`db_commit_seconds{phase="write"}`)         //nolint
+	DbCommitSync        = kvMetrics.Summary(`db_commit_seconds{phase="sync"}`)          //nolint
+	DbCommitEnding      = kvMetrics.Summary(`db_commit_seconds{phase="ending"}`)        //nolint
+	DbCommitTotal       = kvMetrics.Summary(`db_commit_seconds{phase="total"}`)         //nolint
+
+	DbPgopsNewly    = kvMetrics.Counter(`db_pgops{phase="newly"}`)    //nolint
+	DbPgopsCow      = kvMetrics.Counter(`db_pgops{phase="cow"}`)      //nolint
+	DbPgopsClone    = kvMetrics.Counter(`db_pgops{phase="clone"}`)    //nolint
+	DbPgopsSplit    = kvMetrics.Counter(`db_pgops{phase="split"}`)    //nolint
+	DbPgopsMerge    = kvMetrics.Counter(`db_pgops{phase="merge"}`)    //nolint
+	DbPgopsSpill    = kvMetrics.Counter(`db_pgops{phase="spill"}`)    //nolint
+	DbPgopsUnspill  = kvMetrics.Counter(`db_pgops{phase="unspill"}`)  //nolint
+	DbPgopsWops     = kvMetrics.Counter(`db_pgops{phase="wops"}`)     //nolint
+	DbPgopsPrefault = kvMetrics.Counter(`db_pgops{phase="prefault"}`) //nolint
+	DbPgopsMinicore = kvMetrics.Counter(`db_pgops{phase="minicore"}`) //nolint
+	DbPgopsMsync    = kvMetrics.Counter(`db_pgops{phase="msync"}`)    //nolint
+	DbPgopsFsync    = kvMetrics.Counter(`db_pgops{phase="fsync"}`)    //nolint
+	DbMiLastPgNo    = kvMetrics.Counter(`db_mi_last_pgno`)            //nolint
+
+	DbGcWorkRtime    = kvMetrics.Summary(`db_gc_seconds{phase="work_rtime"}`) //nolint
+	DbGcWorkRsteps   = kvMetrics.Counter(`db_gc{phase="work_rsteps"}`)        //nolint
+	DbGcWorkRxpages  = kvMetrics.Counter(`db_gc{phase="work_rxpages"}`)       //nolint
+	DbGcSelfRtime    = kvMetrics.Summary(`db_gc_seconds{phase="self_rtime"}`) //nolint
+	DbGcSelfXtime    = kvMetrics.Summary(`db_gc_seconds{phase="self_xtime"}`) //nolint
+	DbGcWorkXtime    = kvMetrics.Summary(`db_gc_seconds{phase="work_xtime"}`) //nolint
+	DbGcSelfRsteps   = kvMetrics.Counter(`db_gc{phase="self_rsteps"}`)        //nolint
+	DbGcWloops       = kvMetrics.Counter(`db_gc{phase="wloop"}`)              //nolint
+	DbGcCoalescences = kvMetrics.Counter(`db_gc{phase="coalescences"}`)       //nolint
+	DbGcWipes        = kvMetrics.Counter(`db_gc{phase="wipes"}`)              //nolint
+	DbGcFlushes      = kvMetrics.Counter(`db_gc{phase="flushes"}`)            //nolint
+	DbGcKicks        = kvMetrics.Counter(`db_gc{phase="kicks"}`)              //nolint
+	DbGcWorkMajflt   = kvMetrics.Counter(`db_gc{phase="work_majflt"}`)        //nolint
+	DbGcSelfMajflt   = kvMetrics.Counter(`db_gc{phase="self_majflt"}`)        //nolint
+	DbGcWorkCounter  = kvMetrics.Counter(`db_gc{phase="work_counter"}`)       //nolint
+	DbGcSelfCounter  = kvMetrics.Counter(`db_gc{phase="self_counter"}`)       //nolint
+	DbGcSelfXpages   = kvMetrics.Counter(`db_gc{phase="self_xpages"}`)        //nolint
+
+	//DbGcWorkPnlMergeTime   = kvMetrics.Summary(`db_gc_pnl_seconds{phase="work_merge_time"}`) //nolint
+	//DbGcWorkPnlMergeVolume = kvMetrics.Counter(`db_gc_pnl{phase="work_merge_volume"}`)               //nolint
+	//DbGcWorkPnlMergeCalls  = kvMetrics.Counter(`db_gc{phase="work_merge_calls"}`)                    //nolint
+	//DbGcSelfPnlMergeTime   = kvMetrics.Summary(`db_gc_pnl_seconds{phase="slef_merge_time"}`) //nolint
+	//DbGcSelfPnlMergeVolume = kvMetrics.Counter(`db_gc_pnl{phase="self_merge_volume"}`)               //nolint
+	//DbGcSelfPnlMergeCalls  = kvMetrics.Counter(`db_gc_pnl{phase="slef_merge_calls"}`)                //nolint
+
+	GcLeafMetric     = kvMetrics.Counter(`db_gc_leaf`)     //nolint
+	GcOverflowMetric = kvMetrics.Counter(`db_gc_overflow`) //nolint
+	GcPagesMetric    = kvMetrics.Counter(`db_gc_pages`)    //nolint
 
 )
 
@@ -168,6 +170,11 @@ type Getter interface {
 	ForEach(table string, fromPrefix []byte, walker func(k, v []byte) error) error
 	ForPrefix(table string, prefix []byte, walker func(k, v []byte) error) error
 	ForAmount(table string, prefix []byte, amount uint32, walker func(k, v []byte) error) error
+
+	// ForEachCtx, ForPrefixCtx - like ForEach/ForPrefix, but return ctx.Err() after cancellation of ctx (it's checked
+	// every CtxCheckInterval entries) - then long walks (full table scans) don't block shutdown.
+	ForEachCtx(ctx context.Context, table string, fromPrefix []byte, walker func(k, v []byte) error) error
+	ForPrefixCtx(ctx context.Context, table string, prefix []byte, walker func(k, v []byte) error) error
 }
 
 // Putter wraps the database write operations.
@@ -208,6 +215,10 @@ type RoDB interface {
 	BeginRo(ctx context.Context) (Tx, error)
 	AllBuckets() TableCfg
 	PageSize() uint64
+
+	// HealthCheck - read tx, tiny write tx on temporary table (if DB is writable) and free space check. Returns
+	// status also with error: orchestration readiness probes can report latencies of failed check.
+	HealthCheck(ctx context.Context) (HealthStatus, error)
 }
 
 // RwDB low-level database interface - main target is - to provide common abstraction over top of MDBX and RemoteKV.
@@ -282,6 +293,8 @@ type StatelessWriteTx interface {
 		}
 		// use id
 	*/
+	// Allocation is persisted by same commit as other writes of this transaction - see crash-consistency
+	// contract and namespaced sequences in `sequence.go`
 	IncrementSequence(table string, amount uint64) (uint64, error)
 	Append(table string, k, v []byte) error
 	AppendDup(table string, k, v []byte) error
@@ -309,7 +322,7 @@ type Tx interface {
 	// Otherwise - object of interface Cursor created
 	//
 	// Cursor, also provides a grain of magic - it can use a declarative configuration - and automatically break
-	// long keys into DupSort key/values. See docs for `bucket.go:TableCfgItem`
+	// long keys into DupSort key/values. See docs for `tables.go:TableCfgItem` and helpers `kv.CompositeKey`, `kv.SplitCompositeKey`
 	Cursor(table string) (Cursor, error)
 	CursorDupSort(table string) (CursorDupSort, error) // CursorDupSort - can be used if bucket has mdbx.DupSort flag
 
@@ -358,6 +371,29 @@ type RwTx interface {
 	RwCursor(table string) (RwCursor, error)
 	RwCursorDupSort(table string) (RwCursorDupSort, error)
 
+	// PutReserve - inserts or updates entry with value of `size` bytes and returns memory of this value:
+	// caller assembles value in place - without extra copy. Returned slice is valid only until next write of
+	// this transaction. Not supported by DupSort tables.
+	PutReserve(table string, k []byte, size int) ([]byte, error)
+	// PutV - Put of value which is concatenation of `parts` (vectored write): value is assembled in db memory
+	// if table supports PutReserve
+	PutV(table string, k []byte, parts ...[]byte) error
+
+	// DeleteRange - deletes all entries of keys in [from, to) (all values of key of DupSort table), nil `from`/`to` -
+	// unbounded. Same work as Delete of each key found by cursor, except `from == nil && to == nil` - it's ClearBucket.
+	DeleteRange(table string, from, to []byte) error
+
+	// CreateTemporaryBucket - creates table with unique name (starting with `prefix`), visible only in this transaction.
+	// It's dropped automatically on Commit/Rollback - ETL loads and unwind staging can use it without polluting tables namespace.
+	CreateTemporaryBucket(prefix string) (name string, err error)
+
+	// Savepoint - marks current state of transaction: RollbackTo(sp) undoes all writes done after it (for example
+	// partially applied block), ReleaseSavepoint(sp) keeps them. Savepoints are nested: rollback/release of sp also
+	// ends savepoints created after sp. Each of these methods closes open cursors of transaction (as Commit does).
+	Savepoint() (Savepoint, error)
+	RollbackTo(sp Savepoint) error
+	ReleaseSavepoint(sp Savepoint) error
+
 	// CollectMetrics - does collect all DB-related and Tx-related metrics
 	// this method exists only in RwTx to avoid concurrency
 	CollectMetrics()
@@ -395,6 +431,14 @@ type Cursor interface {
 	Last() ([]byte, []byte, error)                // Last - position at last key and last possible value
 	Current() ([]byte, []byte, error)             // Current - return key/data at current cursor position
 
+	// SeekMulti - values of exact matching `keys` (nil if key not found). Keys must be sorted: cursor walks b-tree once,
+	// neighbouring keys reached without search from root. Cursor position after call is undefined.
+	SeekMulti(keys [][]byte) ([][]byte, error)
+
+	// SeekToRatio - position approximately `f` (0..1) of the way through table - for cheap random sampling.
+	// Returns nil key if table is empty.
+	SeekToRatio(f float64) ([]byte, []byte, error)
+
 	Count() (uint64, error) // Count - fast way to calculate amount of keys in bucket. It counts all keys even if Prefix was set.
 
 	Close()
@@ -444,6 +488,12 @@ type RwCursorDupSort interface {
 
 var ErrNotSupported = errors.New("not supported")
 
+// Savepoint - see RwTx.Savepoint. Depth of nesting: 1 - first savepoint of transaction.
+type Savepoint int
+
+var ErrSavepointNotFound = errors.New("savepoint not found")
+var ErrKeysNotSorted = errors.New("keys are not sorted")
+
 // ---- Temporal part
 type (
 	Domain      string
@@ -470,7 +520,32 @@ type TemporalTx interface {
 	IndexRange(name InvertedIdx, k []byte, fromTs, toTs int, asc order.By, limit int) (timestamps iter.U64, err error)
 }
 
+// BlockTag - symbolic block number, same as in JSON-RPC
+type BlockTag string
+
+const (
+	EarliestBlock  BlockTag = "earliest"
+	LatestBlock    BlockTag = "latest"
+	SafeBlock      BlockTag = "safe"
+	FinalizedBlock BlockTag = "finalized"
+)
+
+var ErrBlockTagNotFound = errors.New("block of tag not found")
+
+// HeadProvider - resolves BlockTag to block number. Usually implemented by fork-choice of execution client.
+// See `rawdbv3.ResolveTs` - BlockTag to timestamp (txNum) of DomainGet/HistoryGet.
+// ok=false - if tag has no block yet (for example: no finalized block before The Merge)
+type HeadProvider interface {
+	BlockNumByTag(tx Tx, tag BlockTag) (blockNum uint64, ok bool, err error)
+}
+
 type TemporalRwDB interface {
 	RwDB
 	TemporalRoDb
+
+	// SetRetention - history of `domain` older than `keepTs` timestamps (txNums) before latest one may be pruned:
+	// HistoryGet/DomainGet/IndexRange with older `ts` may return nothing. keepTs=0 - keep full history (default).
+	// Pruning is done by implementation (in background or on next writes), remote implementations forward
+	// policy to server. Returns ErrNotSupported if implementation can't prune history of `domain`.
+	SetRetention(domain Domain, keepTs uint64) error
 }
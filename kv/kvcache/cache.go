@@ -25,13 +25,14 @@ import (
 	"sync"
 	"time"
 
-	"github.com/VictoriaMetrics/metrics"
 	"github.com/c2h5oh/datasize"
 	btree2 "github.com/tidwall/btree"
 	"go.uber.org/atomic"
 	"golang.org/x/crypto/sha3"
 
 	"github.com/ledgerwatch/erigon-lib/common"
+	"github.com/ledgerwatch/erigon-lib/common/length"
+	"github.com/ledgerwatch/erigon-lib/common/metrics"
 	"github.com/ledgerwatch/erigon-lib/gointerfaces"
 	"github.com/ledgerwatch/erigon-lib/gointerfaces/remote"
 	"github.com/ledgerwatch/erigon-lib/kv"
@@ -51,6 +52,10 @@ type Cache interface {
 	// View - returns CacheView consistent with givent kv.Tx
 	View(ctx context.Context, tx kv.Tx) (CacheView, error)
 	OnNewBlock(sc *remote.StateChangeBatch)
+	// OnStateChanges - new state version, of which only plain keys of changed accounts and storage are known
+	OnStateChanges(stateVersionID uint64, keys [][]byte)
+	// Pin - view is not affected by eviction until unpin is called, see Coherent.Pin
+	Pin(view CacheView) (unpin func())
 	Len() int
 	ValidateCurrentRoot(ctx context.Context, tx kv.Tx) (*CacheValidationResult, error)
 }
@@ -101,6 +106,9 @@ type CacheView interface {
 type Coherent struct {
 	hasher               hash.Hash
 	codeEvictLen         *metrics.Counter
+	bytes                *metrics.Counter
+	codeBytes            *metrics.Counter
+	pinnedViews          *metrics.Counter
 	codeKeys             *metrics.Counter
 	keys                 *metrics.Counter
 	evict                *metrics.Counter
@@ -115,7 +123,9 @@ type Coherent struct {
 	miss                 *metrics.Counter
 	cfg                  CoherentConfig
 	latestStateVersionID uint64
+	latestAggTxNum       uint64 // see AggTxNum
 	lock                 sync.Mutex
+	pinned               int          // amount of pinned views
 	waitExceededCount    atomic.Int32 // used as a circuit breaker to stop the cache waiting for new blocks
 }
 
@@ -138,10 +148,19 @@ type CoherentView struct {
 	tx             kv.Tx
 	cache          *Coherent
 	stateVersionID uint64
+	pinned         *CoherentRoot // see Coherent.Pin
 }
 
-func (c *CoherentView) Get(k []byte) ([]byte, error) { return c.cache.Get(k, c.tx, c.stateVersionID) }
+func (c *CoherentView) Get(k []byte) ([]byte, error) {
+	if c.pinned != nil {
+		return c.cache.getPinned(k, c.tx, c.pinned, false)
+	}
+	return c.cache.Get(k, c.tx, c.stateVersionID)
+}
 func (c *CoherentView) GetCode(k []byte) ([]byte, error) {
+	if c.pinned != nil {
+		return c.cache.getPinned(k, c.tx, c.pinned, true)
+	}
 	return c.cache.GetCode(k, c.tx, c.stateVersionID)
 }
 
@@ -178,21 +197,25 @@ func New(cfg CoherentConfig) *Coherent {
 		panic("empty config passed")
 	}
 
+	ns := metrics.GetOrCreateNamespace("kv")
 	return &Coherent{
 		roots:        map[uint64]*CoherentRoot{},
 		stateEvict:   &ThreadSafeEvictionList{l: NewList()},
 		codeEvict:    &ThreadSafeEvictionList{l: NewList()},
 		hasher:       sha3.NewLegacyKeccak256(),
 		cfg:          cfg,
-		miss:         metrics.GetOrCreateCounter(fmt.Sprintf(`cache_total{result="miss",name="%s"}`, cfg.MetricsLabel)),
-		hits:         metrics.GetOrCreateCounter(fmt.Sprintf(`cache_total{result="hit",name="%s"}`, cfg.MetricsLabel)),
-		timeout:      metrics.GetOrCreateCounter(fmt.Sprintf(`cache_timeout_total{name="%s"}`, cfg.MetricsLabel)),
-		keys:         metrics.GetOrCreateCounter(fmt.Sprintf(`cache_keys_total{name="%s"}`, cfg.MetricsLabel)),
-		evict:        metrics.GetOrCreateCounter(fmt.Sprintf(`cache_list_total{name="%s"}`, cfg.MetricsLabel)),
-		codeMiss:     metrics.GetOrCreateCounter(fmt.Sprintf(`cache_code_total{result="miss",name="%s"}`, cfg.MetricsLabel)),
-		codeHits:     metrics.GetOrCreateCounter(fmt.Sprintf(`cache_code_total{result="hit",name="%s"}`, cfg.MetricsLabel)),
-		codeKeys:     metrics.GetOrCreateCounter(fmt.Sprintf(`cache_code_keys_total{name="%s"}`, cfg.MetricsLabel)),
-		codeEvictLen: metrics.GetOrCreateCounter(fmt.Sprintf(`cache_code_list_total{name="%s"}`, cfg.MetricsLabel)),
+		miss:         ns.Counter(fmt.Sprintf(`cache_total{result="miss",name="%s"}`, cfg.MetricsLabel)),
+		hits:         ns.Counter(fmt.Sprintf(`cache_total{result="hit",name="%s"}`, cfg.MetricsLabel)),
+		timeout:      ns.Counter(fmt.Sprintf(`cache_timeout_total{name="%s"}`, cfg.MetricsLabel)),
+		keys:         ns.Counter(fmt.Sprintf(`cache_keys_total{name="%s"}`, cfg.MetricsLabel)),
+		evict:        ns.Counter(fmt.Sprintf(`cache_list_total{name="%s"}`, cfg.MetricsLabel)),
+		codeMiss:     ns.Counter(fmt.Sprintf(`cache_code_total{result="miss",name="%s"}`, cfg.MetricsLabel)),
+		codeHits:     ns.Counter(fmt.Sprintf(`cache_code_total{result="hit",name="%s"}`, cfg.MetricsLabel)),
+		codeKeys:     ns.Counter(fmt.Sprintf(`cache_code_keys_total{name="%s"}`, cfg.MetricsLabel)),
+		codeEvictLen: ns.Counter(fmt.Sprintf(`cache_code_list_total{name="%s"}`, cfg.MetricsLabel)),
+		bytes:        ns.Counter(fmt.Sprintf(`cache_size_bytes{name="%s"}`, cfg.MetricsLabel)),
+		codeBytes:    ns.Counter(fmt.Sprintf(`cache_code_size_bytes{name="%s"}`, cfg.MetricsLabel)),
+		pinnedViews:  ns.Counter(fmt.Sprintf(`cache_pinned_views{name="%s"}`, cfg.MetricsLabel)),
 	}
 }
 
@@ -260,11 +283,20 @@ func (c *Coherent) advanceRoot(stateVersionID uint64) (r *CoherentRoot) {
 	c.latestStateVersionID = stateVersionID
 	c.latestStateView = r
 
-	c.keys.Set(uint64(c.latestStateView.cache.Len()))
-	c.codeKeys.Set(uint64(c.latestStateView.codeCache.Len()))
+	c.updateSizeMetrics()
+	return r
+}
+
+// updateSizeMetrics - sizes of latest view
+func (c *Coherent) updateSizeMetrics() {
+	if c.latestStateView != nil {
+		c.keys.Set(uint64(c.latestStateView.cache.Len()))
+		c.codeKeys.Set(uint64(c.latestStateView.codeCache.Len()))
+	}
 	c.evict.Set(uint64(c.stateEvict.Len()))
 	c.codeEvictLen.Set(uint64(c.codeEvict.Len()))
-	return r
+	c.bytes.Set(uint64(c.stateEvict.Size()))
+	c.codeBytes.Set(uint64(c.codeEvict.Size()))
 }
 
 func (c *Coherent) OnNewBlock(stateChanges *remote.StateChangeBatch) {
@@ -325,6 +357,58 @@ func (c *Coherent) OnNewBlock(stateChanges *remote.StateChangeBatch) {
 	//log.Info("on new block handled", "viewID", stateChanges.StateVersionID)
 }
 
+// AggTxNum - aggTxNum of latest OnStateChangeBatch: changes of txNums < AggTxNum are flushed to db by aggregator,
+// history reads below it are consistent with latest view. 0 - unknown
+func (c *Coherent) AggTxNum() uint64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.latestAggTxNum
+}
+
+// OnStateChangeBatch - implements state.StateChangesBatchListener (together with OnStateChanges): tracks AggTxNum.
+// aggTxNum decreases on unwind
+func (c *Coherent) OnStateChangeBatch(stateVersionID, aggTxNum uint64, flushedEntities []string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.latestAggTxNum = aggTxNum
+}
+
+// OnStateChanges - like OnNewBlock, but values are unknown: changed keys are dropped from new view (and read from
+// db on next access), rest of keys stay cached across views. Keys are plain keys of accounts (addr) and storage:
+// addr+incarnation+location or addr+location - which drops location of all incarnations.
+func (c *Coherent) OnStateChanges(stateVersionID uint64, keys [][]byte) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.waitExceededCount.Store(0) // reset the circuit breaker
+	r := c.advanceRoot(stateVersionID)
+	for _, k := range keys {
+		if len(k) != length.Addr+length.Hash {
+			c.remove(k, r, stateVersionID)
+			continue
+		}
+		c.remove(k, r, stateVersionID) // cached by addr+location itself
+		addr, loc := k[:length.Addr], k[length.Addr:]
+		var incarnations []*Element
+		r.cache.Ascend(&Element{K: addr}, func(e *Element) bool {
+			if !bytes.HasPrefix(e.K, addr) {
+				return false
+			}
+			if len(e.K) == length.Addr+length.Incarnation+length.Hash && bytes.Equal(e.K[length.Addr+length.Incarnation:], loc) {
+				incarnations = append(incarnations, e)
+			}
+			return true
+		})
+		for _, e := range incarnations {
+			c.remove(e.K, r, stateVersionID)
+		}
+	}
+
+	switched := r.readyChanClosed.CAS(false, true)
+	if switched {
+		close(r.ready) //broadcast
+	}
+}
+
 func (c *Coherent) View(ctx context.Context, tx kv.Tx) (CacheView, error) {
 	idBytes, err := tx.GetOne(kv.Sequence, kv.PlainStateVersion)
 	if err != nil {
@@ -434,6 +518,61 @@ func (c *Coherent) GetCode(k []byte, tx kv.Tx, id uint64) ([]byte, error) {
 	v = c.addCode(common.Copy(k), common.Copy(v), r, id).V
 	return v, nil
 }
+
+// Pin - view keeps working on snapshot of its cache taken at pin time: eviction caused by other requests (by size
+// or of views older than KeepViews) doesn't affect it, values read by pinned view are kept in snapshot until unpin.
+// For long-running requests (trace replays). Snapshot is not bounded by CacheSize - unpin must be called.
+func (c *Coherent) Pin(view CacheView) (unpin func()) {
+	v, ok := view.(*CoherentView)
+	if !ok || v.pinned != nil {
+		return func() {}
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	snapshot := &CoherentRoot{cache: btree2.NewBTreeG[*Element](Less), codeCache: btree2.NewBTreeG[*Element](Less)}
+	if r, ok := c.roots[v.stateVersionID]; ok && r.cache != nil {
+		snapshot.cache, snapshot.codeCache = r.cache.Copy(), r.codeCache.Copy()
+	}
+	v.pinned = snapshot
+	c.pinned++
+	c.pinnedViews.Set(uint64(c.pinned))
+	return func() {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		if v.pinned == nil {
+			return
+		}
+		v.pinned = nil
+		c.pinned--
+		c.pinnedViews.Set(uint64(c.pinned))
+	}
+}
+
+func (c *Coherent) getPinned(k []byte, tx kv.Tx, r *CoherentRoot, code bool) ([]byte, error) {
+	cache, table, hits, miss := r.cache, kv.PlainState, c.hits, c.miss
+	if code {
+		cache, table, hits, miss = r.codeCache, kv.Code, c.codeHits, c.codeMiss
+	}
+	c.lock.Lock()
+	it, _ := cache.Get(&Element{K: k})
+	c.lock.Unlock()
+	if it != nil {
+		hits.Inc()
+		return it.V, nil
+	}
+	miss.Inc()
+
+	v, err := tx.GetOne(table, k)
+	if err != nil {
+		return nil, err
+	}
+	it = &Element{K: common.Copy(k), V: common.Copy(v)}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	cache.Set(it)
+	return it.V, nil
+}
+
 func (c *Coherent) removeOldest(r *CoherentRoot) {
 	e := c.stateEvict.Oldest()
 	if e != nil {
@@ -464,9 +603,18 @@ func (c *Coherent) add(k, v []byte, r *CoherentRoot, id uint64) *Element {
 	for c.stateEvict.Size() > int(c.cfg.CacheSize.Bytes()) {
 		c.removeOldest(r)
 	}
+	c.updateSizeMetrics()
 
 	return it
 }
+func (c *Coherent) remove(k []byte, r *CoherentRoot, id uint64) {
+	removed, ok := r.cache.Delete(&Element{K: k})
+	if !ok || c.latestStateVersionID != id {
+		return
+	}
+	c.stateEvict.Remove(removed)
+	c.updateSizeMetrics()
+}
 func (c *Coherent) addCode(k, v []byte, r *CoherentRoot, id uint64) *Element {
 	it := &Element{K: k, V: v}
 	replaced, _ := r.codeCache.Set(it)
@@ -482,6 +630,7 @@ func (c *Coherent) addCode(k, v []byte, r *CoherentRoot, id uint64) *Element {
 	for c.codeEvict.Size() > int(c.cfg.CodeCacheSize.Bytes()) {
 		c.removeOldestCode(r)
 	}
+	c.updateSizeMetrics()
 
 	return it
 }
@@ -607,6 +756,39 @@ func (c *Coherent) clearCaches(r *CoherentRoot) {
 	r.codeCache.Clear()
 }
 
+// TableStats - of kv.PlainState or kv.Code part of cache. Keys and Bytes are of latest view, Hits and Misses are
+// shared by caches with same CoherentConfig.MetricsLabel.
+type TableStats struct {
+	Keys, Bytes  int
+	Hits, Misses uint64
+}
+
+func (s TableStats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+type CacheStats struct {
+	State, Code TableStats
+	PinnedViews int
+}
+
+func (c *Coherent) Stats() CacheStats {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	s := CacheStats{
+		State:       TableStats{Bytes: c.stateEvict.Size(), Hits: c.hits.Get(), Misses: c.miss.Get()},
+		Code:        TableStats{Bytes: c.codeEvict.Size(), Hits: c.codeHits.Get(), Misses: c.codeMiss.Get()},
+		PinnedViews: c.pinned,
+	}
+	if c.latestStateView != nil {
+		s.State.Keys, s.Code.Keys = c.latestStateView.cache.Len(), c.latestStateView.codeCache.Len()
+	}
+	return s
+}
+
 type Stat struct {
 	BlockNum  uint64
 	BlockHash [32]byte
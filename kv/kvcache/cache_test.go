@@ -372,3 +372,129 @@ func TestCode(t *testing.T) {
 		return nil
 	})
 }
+
+func TestAggTxNum(t *testing.T) {
+	c := New(DefaultCoherentConfig)
+	require.Zero(t, c.AggTxNum())
+	c.OnStateChangeBatch(1, 10, []string{"accounts"})
+	require.Equal(t, uint64(10), c.AggTxNum())
+	c.OnStateChangeBatch(1, 7, []string{"accounts"}) // unwind, same state version
+	require.Equal(t, uint64(7), c.AggTxNum())
+}
+
+func TestOnStateChanges(t *testing.T) {
+	require, ctx := require.New(t), context.Background()
+	cfg := DefaultCoherentConfig
+	cfg.NewBlockWait = 0
+	c := New(cfg)
+	db := memdb.NewTestDB(t)
+	k1, k2 := [20]byte{1}, [20]byte{2}
+	loc := [32]byte{3}
+	storageKey := make([]byte, 20+8+32)
+	copy(storageKey, k2[:])
+	binary.BigEndian.PutUint64(storageKey[20:], 1)
+	copy(storageKey[28:], loc[:])
+
+	put := func(version uint64, kvs ...[]byte) {
+		require.NoError(db.Update(ctx, func(tx kv.RwTx) error {
+			for i := 0; i < len(kvs); i += 2 {
+				if err := tx.Put(kv.PlainState, kvs[i], kvs[i+1]); err != nil {
+					return err
+				}
+			}
+			var versionID [8]byte
+			binary.BigEndian.PutUint64(versionID[:], version)
+			return tx.Put(kv.Sequence, kv.PlainStateVersion, versionID[:])
+		}))
+	}
+	get := func(k []byte) (v []byte) {
+		require.NoError(db.View(ctx, func(tx kv.Tx) error {
+			view, err := c.View(ctx, tx)
+			if err != nil {
+				return err
+			}
+			v, err = view.Get(k)
+			return err
+		}))
+		return v
+	}
+
+	storageKeyNoInc := append(common.Copy(k2[:]), loc[:]...) // addr+location
+	put(1, k1[:], []byte{1}, k2[:], []byte{1}, storageKey, []byte{1})
+	c.OnStateChanges(1, nil)
+	require.Equal([]byte{1}, get(k1[:]))
+	require.Equal([]byte{1}, get(k2[:]))
+	require.Equal([]byte{1}, get(storageKey))
+	c.lock.Lock()
+	c.add(storageKeyNoInc, []byte{1}, c.roots[1], 1) // PlainState has no such keys, cached by readers of other state layout
+	c.lock.Unlock()
+	require.Equal(4, c.Len())
+
+	// k1 and storage changed and notified (storage - by key without incarnation), k2 changed without notification
+	put(2, k1[:], []byte{2}, k2[:], []byte{2}, storageKey, []byte{2})
+	c.OnStateChanges(2, [][]byte{k1[:], storageKeyNoInc})
+	require.Equal(1, c.Len()) // addr+location key dropped too
+	require.Equal(c.Len(), c.stateEvict.Len())
+	require.Equal([]byte{2}, get(k1[:]))
+	require.Equal([]byte{1}, get(k2[:])) // stayed in cache across views
+	require.Equal([]byte{2}, get(storageKey))
+}
+
+func TestPin(t *testing.T) {
+	require, ctx := require.New(t), context.Background()
+	cfg := DefaultCoherentConfig
+	cfg.NewBlockWait = 0
+	cfg.KeepViews = 2
+	cfg.MetricsLabel = "test_pin"
+	cfg.CacheSize = 21 // one key
+	c := New(cfg)
+	db := memdb.NewTestDB(t)
+	k1, k2 := [20]byte{1}, [20]byte{2}
+
+	setVersion := func(tx kv.RwTx, version uint64) {
+		var versionID [8]byte
+		binary.BigEndian.PutUint64(versionID[:], version)
+		require.NoError(tx.Put(kv.Sequence, kv.PlainStateVersion, versionID[:]))
+	}
+	require.NoError(db.Update(ctx, func(tx kv.RwTx) error {
+		setVersion(tx, 1)
+		require.NoError(tx.Put(kv.PlainState, k1[:], []byte{1}))
+		return tx.Put(kv.PlainState, k2[:], []byte{1})
+	}))
+	c.OnStateChanges(1, nil)
+
+	tx, err := db.BeginRo(ctx)
+	require.NoError(err)
+	defer tx.Rollback()
+	view, err := c.View(ctx, tx)
+	require.NoError(err)
+	v, err := view.Get(k1[:])
+	require.NoError(err)
+	require.Equal([]byte{1}, v)
+	unpin := c.Pin(view)
+	require.Equal(1, c.Stats().PinnedViews)
+
+	// other requests and new views: k1 is evicted from latest view, view 1 is dropped
+	for version := uint64(2); version < 5; version++ {
+		c.OnStateChanges(version, [][]byte{k1[:]})
+	}
+	_, err = c.Get(k1[:], tx, 1)
+	require.Error(err) // too old view
+	_, err = view.Get(k2[:])
+	require.NoError(err)
+	v, err = view.Get(k1[:])
+	require.NoError(err)
+	require.Equal([]byte{1}, v)
+
+	before := c.Stats().State
+	_, err = view.Get(k2[:]) // kept by snapshot of pinned view
+	require.NoError(err)
+	after := c.Stats().State
+	require.Equal(before.Hits+1, after.Hits)
+	require.Equal(before.Misses, after.Misses)
+	require.Greater(after.HitRate(), 0.)
+
+	unpin()
+	unpin()
+	require.Equal(0, c.Stats().PinnedViews)
+}
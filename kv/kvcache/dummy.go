@@ -33,6 +33,8 @@ func (c *DummyCache) View(_ context.Context, tx kv.Tx) (CacheView, error) {
 	return &DummyView{cache: c, tx: tx}, nil
 }
 func (c *DummyCache) OnNewBlock(sc *remote.StateChangeBatch) {}
+func (c *DummyCache) OnStateChanges(uint64, [][]byte)        {}
+func (c *DummyCache) Pin(CacheView) func()                   { return func() {} }
 func (c *DummyCache) Evict() int                             { return 0 }
 func (c *DummyCache) Len() int                               { return 0 }
 func (c *DummyCache) Get(k []byte, tx kv.Tx, id uint64) ([]byte, error) {
@@ -0,0 +1,381 @@
+/*
+   Copyright 2022 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package kvtests - conformance suite of kv.RwDB/kv.RoDB implementations: iteration order, dupsort semantics,
+// range boundaries, sequences. New implementations (and new methods of kv.Tx) must pass it, pattern:
+//
+//	func TestConformance(t *testing.T) {
+//		kvtests.Run(t, func(t *testing.T) (kv.RwDB, kv.RoDB) {
+//			db := memdb.NewTestDB(t)
+//			return db, db
+//		})
+//	}
+package kvtests
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ledgerwatch/erigon-lib/kv"
+	"github.com/ledgerwatch/erigon-lib/kv/iter"
+)
+
+// Tables used by suite, db must be opened with kv.ChaindataTablesCfg
+const (
+	Table        = kv.HeaderNumber     // no flags
+	DupSortTable = kv.AccountChangeSet // kv.DupSort
+)
+
+// Setup - fresh db for each case: `writeDB` fills fixtures, `readDB` is checked. Same db for embedded
+// implementations, for remote ones - `writeDB` is db behind server.
+type Setup func(t *testing.T) (writeDB kv.RwDB, readDB kv.RoDB)
+
+func Run(t *testing.T, setup Setup) {
+	t.Run("Order", func(t *testing.T) { testOrder(t, setup) })
+	t.Run("DupSort", func(t *testing.T) { testDupSort(t, setup) })
+	t.Run("Range", func(t *testing.T) { testRange(t, setup) })
+	t.Run("RangeDupSort", func(t *testing.T) { testRangeDupSort(t, setup) })
+	t.Run("Sequence", func(t *testing.T) { testSequence(t, setup) })
+	t.Run("DeleteRange", func(t *testing.T) { testDeleteRange(t, setup) })
+}
+
+type pair struct{ k, v string }
+
+// orderedKeys - lexicographic order: shorter key is before its extensions, bytes are unsigned
+var orderedKeys = []string{"\x00", "\x00\x00", "\x00\x01", "\x01", "\x01\xff", "\x7f", "\x80", "\xff", "\xff\x00", "\xff\xff"}
+
+func fill(t *testing.T, db kv.RwDB, table string, pairs []pair) {
+	t.Helper()
+	require.NoError(t, db.Update(context.Background(), func(tx kv.RwTx) error {
+		for i := len(pairs) - 1; i >= 0; i-- { // reverse order: implementation must sort
+			if err := tx.Put(table, []byte(pairs[i].k), []byte(pairs[i].v)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+}
+
+func view(t *testing.T, db kv.RoDB, f func(tx kv.Tx)) {
+	t.Helper()
+	require.NoError(t, db.View(context.Background(), func(tx kv.Tx) error {
+		f(tx)
+		return nil
+	}))
+}
+
+func collect(t *testing.T, it iter.KV, err error) (res []pair) {
+	t.Helper()
+	require.NoError(t, err)
+	for it.HasNext() {
+		k, v, err := it.Next()
+		require.NoError(t, err)
+		res = append(res, pair{string(k), string(v)})
+	}
+	return res
+}
+
+func reversed(pairs []pair) []pair {
+	res := make([]pair, len(pairs))
+	for i := range pairs {
+		res[len(pairs)-1-i] = pairs[i]
+	}
+	return res
+}
+
+func orderedPairs() []pair {
+	pairs := make([]pair, len(orderedKeys))
+	for i, k := range orderedKeys {
+		pairs[i] = pair{k, "v" + k}
+	}
+	return pairs
+}
+
+func testOrder(t *testing.T, setup Setup) {
+	writeDB, readDB := setup(t)
+	pairs := orderedPairs()
+	fill(t, writeDB, Table, pairs)
+
+	view(t, readDB, func(tx kv.Tx) {
+		c, err := tx.Cursor(Table)
+		require.NoError(t, err)
+		defer c.Close()
+		var res []pair
+		for k, v, err := c.First(); k != nil; k, v, err = c.Next() {
+			require.NoError(t, err)
+			res = append(res, pair{string(k), string(v)})
+		}
+		require.Equal(t, pairs, res, "First/Next")
+
+		res = res[:0]
+		for k, v, err := c.Last(); k != nil; k, v, err = c.Prev() {
+			require.NoError(t, err)
+			res = append(res, pair{string(k), string(v)})
+		}
+		require.Equal(t, reversed(pairs), res, "Last/Prev")
+
+		res = res[:0]
+		require.NoError(t, tx.ForEach(Table, nil, func(k, v []byte) error {
+			res = append(res, pair{string(k), string(v)})
+			return nil
+		}))
+		require.Equal(t, pairs, res, "ForEach")
+
+		k, v, err := c.Seek([]byte("\x01\x00")) // between keys
+		require.NoError(t, err)
+		require.Equal(t, "\x01\xff", string(k))
+		require.Equal(t, "v\x01\xff", string(v))
+		k, _, err = c.SeekExact([]byte("\x01\x00"))
+		require.NoError(t, err)
+		require.Nil(t, k)
+		k, _, err = c.Seek([]byte("\xff\xff\x00")) // after last
+		require.NoError(t, err)
+		require.Nil(t, k)
+
+		cnt, err := c.Count()
+		require.NoError(t, err)
+		require.Equal(t, uint64(len(pairs)), cnt)
+
+		v, err = tx.GetOne(Table, []byte("\x7f"))
+		require.NoError(t, err)
+		require.Equal(t, "v\x7f", string(v))
+		has, err := tx.Has(Table, []byte("\x7e"))
+		require.NoError(t, err)
+		require.False(t, has)
+	})
+}
+
+// dupSortPairs - values of key are sorted too
+var dupSortPairs = []pair{
+	{"\x01", "\x00"}, {"\x01", "\x01"}, {"\x01", "\x01\x00"}, {"\x01", "\xff"},
+	{"\x02", "\x05"},
+	{"\x03", "\x01"}, {"\x03", "\x02"},
+}
+
+func testDupSort(t *testing.T, setup Setup) {
+	writeDB, readDB := setup(t)
+	fill(t, writeDB, DupSortTable, dupSortPairs)
+	fill(t, writeDB, DupSortTable, dupSortPairs[:2]) // duplicates of same pair are not stored
+
+	view(t, readDB, func(tx kv.Tx) {
+		c, err := tx.CursorDupSort(DupSortTable)
+		require.NoError(t, err)
+		defer c.Close()
+
+		var res []pair
+		for k, v, err := c.First(); k != nil; k, v, err = c.Next() {
+			require.NoError(t, err)
+			res = append(res, pair{string(k), string(v)})
+		}
+		require.Equal(t, dupSortPairs, res, "Next visits all values")
+
+		res = res[:0]
+		for k, v, err := c.Last(); k != nil; k, v, err = c.Prev() {
+			require.NoError(t, err)
+			res = append(res, pair{string(k), string(v)})
+		}
+		require.Equal(t, reversed(dupSortPairs), res, "Prev visits all values")
+
+		var keys []string
+		for k, _, err := c.First(); k != nil; k, _, err = c.NextNoDup() {
+			require.NoError(t, err)
+			keys = append(keys, string(k))
+		}
+		require.Equal(t, []string{"\x01", "\x02", "\x03"}, keys)
+
+		k, v, err := c.SeekExact([]byte("\x01"))
+		require.NoError(t, err)
+		require.Equal(t, "\x01", string(k))
+		require.Equal(t, "\x00", string(v)) // first value
+		v, err = c.LastDup()
+		require.NoError(t, err)
+		require.Equal(t, "\xff", string(v))
+		k, v, err = c.PrevDup()
+		require.NoError(t, err)
+		require.Equal(t, "\x01", string(k))
+		require.Equal(t, "\x01\x00", string(v))
+		k, v, err = c.NextDup()
+		require.NoError(t, err)
+		require.Equal(t, "\x01", string(k))
+		require.Equal(t, "\xff", string(v))
+		k, _, err = c.NextDup() // last value of key
+		require.NoError(t, err)
+		require.Nil(t, k)
+
+		v, err = c.SeekBothRange([]byte("\x01"), []byte("\x02"))
+		require.NoError(t, err)
+		require.Equal(t, "\xff", string(v))
+		v, err = c.SeekBothRange([]byte("\x02"), []byte("\x06")) // after last value of key
+		require.NoError(t, err)
+		require.Nil(t, v)
+		k, v, err = c.SeekBothExact([]byte("\x03"), []byte("\x02"))
+		require.NoError(t, err)
+		require.Equal(t, "\x03", string(k))
+		require.Equal(t, "\x02", string(v))
+		k, _, err = c.SeekBothExact([]byte("\x03"), []byte("\x03"))
+		require.NoError(t, err)
+		require.Nil(t, k)
+
+		v, err = tx.GetOne(DupSortTable, []byte("\x03"))
+		require.NoError(t, err)
+		require.Equal(t, "\x01", string(v)) // first value
+		cnt, err := c.Count()               // counts values
+		require.NoError(t, err)
+		require.Equal(t, uint64(len(dupSortPairs)), cnt)
+	})
+}
+
+func testRange(t *testing.T, setup Setup) {
+	writeDB, readDB := setup(t)
+	pairs := orderedPairs()
+	fill(t, writeDB, Table, pairs)
+
+	// [from, to) of orderedKeys
+	between := func(from, to string) []pair {
+		var res []pair
+		for _, p := range pairs {
+			if (from == "" || p.k >= from) && (to == "" || p.k < to) {
+				res = append(res, p)
+			}
+		}
+		return res
+	}
+	b := func(s string) []byte {
+		if s == "" {
+			return nil
+		}
+		return []byte(s)
+	}
+	view(t, readDB, func(tx kv.Tx) {
+		for _, r := range []struct{ from, to string }{
+			{"", ""}, {"\x00", "\x01"}, {"\x00\x01", "\xff"}, {"\x01", ""}, {"", "\x7f"}, {"\x01\x00", "\x7f\x00"}, {"\xff\xff", ""},
+		} {
+			it, err := tx.Range(Table, b(r.from), b(r.to))
+			require.Equal(t, between(r.from, r.to), collect(t, it, err), "Range %x-%x", r.from, r.to)
+			it, err = tx.RangeAscend(Table, b(r.from), b(r.to), 2)
+			expect := between(r.from, r.to)
+			if len(expect) > 2 {
+				expect = expect[:2]
+			}
+			require.Equal(t, expect, collect(t, it, err), "RangeAscend %x-%x", r.from, r.to)
+		}
+
+		// descend: from inclusive, to exclusive, from > to
+		it, err := tx.RangeDescend(Table, []byte("\x7f"), []byte("\x00\x01"), -1)
+		require.Equal(t, reversed(between("\x00\x01\x00", "\x7f\x00")), collect(t, it, err))
+		it, err = tx.RangeDescend(Table, []byte("\x7e"), nil, -1) // no such key - starts from previous
+		require.Equal(t, reversed(between("", "\x7e")), collect(t, it, err))
+		it, err = tx.RangeDescend(Table, nil, []byte("\xff"), 2)
+		require.Equal(t, reversed(between("\xff\x00", ""))[:2], collect(t, it, err))
+
+		it, err = tx.Prefix(Table, []byte("\x00"))
+		require.Equal(t, between("\x00", "\x01"), collect(t, it, err))
+		it, err = tx.Prefix(Table, []byte("\xff"))
+		require.Equal(t, between("\xff", ""), collect(t, it, err))
+
+		var res []pair
+		require.NoError(t, tx.ForPrefix(Table, []byte("\x01"), func(k, v []byte) error {
+			res = append(res, pair{string(k), string(v)})
+			return nil
+		}))
+		require.Equal(t, between("\x01", "\x02"), res)
+		res = res[:0]
+		require.NoError(t, tx.ForAmount(Table, []byte("\x01"), 3, func(k, v []byte) error {
+			res = append(res, pair{string(k), string(v)})
+			return nil
+		}))
+		require.Equal(t, between("\x01", "\x80"), res)
+	})
+}
+
+func testRangeDupSort(t *testing.T, setup Setup) {
+	writeDB, readDB := setup(t)
+	fill(t, writeDB, DupSortTable, dupSortPairs)
+
+	view(t, readDB, func(tx kv.Tx) {
+		it, err := tx.Range(DupSortTable, nil, nil)
+		require.Equal(t, dupSortPairs, collect(t, it, err), "all values of each key")
+		it, err = tx.Range(DupSortTable, []byte("\x02"), []byte("\x03"))
+		require.Equal(t, dupSortPairs[4:5], collect(t, it, err))
+		it, err = tx.RangeAscend(DupSortTable, []byte("\x01"), nil, 3)
+		require.Equal(t, dupSortPairs[:3], collect(t, it, err), "limit counts values")
+		it, err = tx.Prefix(DupSortTable, []byte("\x03"))
+		require.Equal(t, dupSortPairs[5:], collect(t, it, err))
+	})
+}
+
+func testSequence(t *testing.T, setup Setup) {
+	writeDB, readDB := setup(t)
+	ctx := context.Background()
+	require.NoError(t, writeDB.Update(ctx, func(tx kv.RwTx) error {
+		v, err := tx.ReadSequence(Table)
+		require.NoError(t, err)
+		require.Zero(t, v)
+		v, err = tx.IncrementSequence(Table, 5) // returns value before increment
+		require.NoError(t, err)
+		require.Zero(t, v)
+		v, err = tx.IncrementSequence(Table, 1)
+		require.NoError(t, err)
+		require.Equal(t, uint64(5), v)
+		v, err = tx.ReadSequence(Table)
+		require.NoError(t, err)
+		require.Equal(t, uint64(6), v)
+		v, err = tx.ReadSequence(DupSortTable) // sequences are per table
+		require.NoError(t, err)
+		require.Zero(t, v)
+		return nil
+	}))
+
+	tx, err := writeDB.BeginRw(ctx)
+	require.NoError(t, err)
+	_, err = tx.IncrementSequence(Table, 10)
+	require.NoError(t, err)
+	tx.Rollback() // rolled back with tx
+
+	view(t, readDB, func(tx kv.Tx) {
+		v, err := tx.ReadSequence(Table)
+		require.NoError(t, err)
+		require.Equal(t, uint64(6), v)
+	})
+}
+
+func testDeleteRange(t *testing.T, setup Setup) {
+	writeDB, readDB := setup(t)
+	pairs := orderedPairs()
+	fill(t, writeDB, Table, pairs)
+	fill(t, writeDB, DupSortTable, dupSortPairs)
+
+	require.NoError(t, writeDB.Update(context.Background(), func(tx kv.RwTx) error {
+		require.NoError(t, tx.DeleteRange(Table, []byte("\x00\x01"), []byte("\x7f")))
+		require.NoError(t, tx.DeleteRange(Table, []byte("\xff\x00"), nil))
+		return tx.DeleteRange(DupSortTable, nil, []byte("\x03"))
+	}))
+
+	view(t, readDB, func(tx kv.Tx) {
+		var expect []pair
+		for _, p := range pairs {
+			if !(p.k >= "\x00\x01" && p.k < "\x7f") && p.k < "\xff\x00" {
+				expect = append(expect, p)
+			}
+		}
+		it, err := tx.Range(Table, nil, nil)
+		require.Equal(t, expect, collect(t, it, err))
+		it, err = tx.Range(DupSortTable, nil, nil)
+		require.Equal(t, dupSortPairs[5:], collect(t, it, err))
+	})
+}
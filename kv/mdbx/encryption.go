@@ -0,0 +1,271 @@
+/*
+   Copyright 2023 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package mdbx
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/ledgerwatch/erigon-lib/common"
+	"github.com/ledgerwatch/erigon-lib/kv"
+	"github.com/torquem-ch/mdbx-go/mdbx"
+)
+
+var (
+	ErrDecrypt        = errors.New("can't decrypt value")
+	ErrUnknownKeyID   = errors.New("unknown encryption key id")
+	ErrActiveKeyUsed  = errors.New("encryption key is active")
+	ErrEncryptedDB    = errors.New("db is encrypted: open it with MdbxOpts.Encryption")
+	ErrNotEncryptedDB = errors.New("db has not encrypted values: encryption can be enabled only for new db")
+)
+
+// Encryption - AES-GCM encryption of values of non-DupSort tables, see MdbxOpts.Encryption. It's NOT encryption of
+// db file: mdbx has no hooks for encryption of pages - then values are encrypted on their way in and out of db.
+// In plain text stay: keys, all values of DupSort tables (order of their values is part of db semantic), sizes of
+// values and b-tree structure. Use disk encryption if keys or DupSort tables are sensitive.
+// Table name and key are authenticated - value can't be moved to another key.
+//
+// Encrypted value: [keyID:1][nonce:12][ciphertext+tag:len(value)+16]. Many keys can be registered - for decryption of
+// values written before rotation. New values are encrypted by active key, old ones are re-encrypted by ReencryptTable.
+type Encryption struct {
+	lock   sync.RWMutex
+	keys   map[uint8]cipher.AEAD
+	active uint8
+}
+
+// NewEncryption - `key` of 16, 24 or 32 bytes (AES-128, AES-192, AES-256) becomes active key with id `keyID`
+func NewEncryption(keyID uint8, key []byte) (*Encryption, error) {
+	e := &Encryption{keys: map[uint8]cipher.AEAD{}}
+	if err := e.Rotate(keyID, key); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// AddKey - registers key which is used only for decryption of existing values
+func (e *Encryption) AddKey(keyID uint8, key []byte) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return fmt.Errorf("encryption key %d: %w", keyID, err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return fmt.Errorf("encryption key %d: %w", keyID, err)
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.keys[keyID] = aead
+	return nil
+}
+
+// Rotate - registers key and makes it active: new writes are encrypted by it
+func (e *Encryption) Rotate(keyID uint8, key []byte) error {
+	if err := e.AddKey(keyID, key); err != nil {
+		return err
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.active = keyID
+	return nil
+}
+
+// RemoveKey - values encrypted by this key become unreadable: call after ReencryptTable of all tables
+func (e *Encryption) RemoveKey(keyID uint8) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if keyID == e.active {
+		return fmt.Errorf("%w: %d", ErrActiveKeyUsed, keyID)
+	}
+	delete(e.keys, keyID)
+	return nil
+}
+
+func (e *Encryption) ActiveKeyID() uint8 {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	return e.active
+}
+
+func encryptionAdditionalData(table string, k []byte) []byte {
+	ad := make([]byte, 0, len(table)+1+len(k))
+	ad = append(ad, table...)
+	ad = append(ad, 0)
+	return append(ad, k...)
+}
+
+func (e *Encryption) encrypt(table string, k, v []byte) ([]byte, error) {
+	e.lock.RLock()
+	keyID, aead := e.active, e.keys[e.active]
+	e.lock.RUnlock()
+	out := make([]byte, 1+aead.NonceSize(), 1+aead.NonceSize()+len(v)+aead.Overhead())
+	out[0] = keyID
+	if _, err := rand.Read(out[1:]); err != nil {
+		return nil, err
+	}
+	return aead.Seal(out, out[1:], v, encryptionAdditionalData(table, k)), nil
+}
+
+func (e *Encryption) decrypt(table string, k, v []byte) ([]byte, error) {
+	if len(v) < 1 {
+		return nil, fmt.Errorf("%w: table %s, key %x: too short", ErrDecrypt, table, k)
+	}
+	e.lock.RLock()
+	aead, ok := e.keys[v[0]]
+	e.lock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: table %s, key %x: %d", ErrUnknownKeyID, table, k, v[0])
+	}
+	if len(v) < 1+aead.NonceSize()+aead.Overhead() {
+		return nil, fmt.Errorf("%w: table %s, key %x: too short", ErrDecrypt, table, k)
+	}
+	nonce, ciphertext := v[1:1+aead.NonceSize()], v[1+aead.NonceSize():]
+	plain, err := aead.Open(make([]byte, 0, len(ciphertext)-aead.Overhead()), nonce, ciphertext, encryptionAdditionalData(table, k))
+	if err != nil {
+		return nil, fmt.Errorf("%w: table %s, key %x: %s", ErrDecrypt, table, k, err)
+	}
+	return plain, nil
+}
+
+// encryptionTable - marker of encrypted db: Open refuses encrypted db without Encryption (ErrEncryptedDB) and
+// db with not encrypted values with Encryption (ErrNotEncryptedDB). Marker is encrypted check-value: wrong keys are
+// detected by Open. Table is not in TableCfg - it's read before tables of db.
+const encryptionTable = "DbEncryption"
+
+var (
+	encryptionMarkerKey = []byte("marker")
+	encryptionMarker    = []byte("mdbx values encryption v1")
+)
+
+func (db *MdbxKV) checkEncryption() error {
+	e := db.opts.encryption
+	var marker []byte
+	var hasPlain bool
+	if err := db.env.View(func(txn *mdbx.Txn) error {
+		dbi, err := txn.OpenDBI(encryptionTable, 0, nil, nil)
+		if err == nil {
+			if marker, err = txn.Get(dbi, encryptionMarkerKey); err != nil && !mdbx.IsNotFound(err) {
+				return err
+			}
+			marker = common.Copy(marker)
+		} else if !mdbx.IsNotFound(err) {
+			return err
+		}
+		if marker != nil || e == nil {
+			return nil
+		}
+		for name, cfg := range db.buckets {
+			if !e.encrypted(cfg) || cfg.DBI == NonExistingDBI {
+				continue
+			}
+			st, err := txn.StatDBI(mdbx.DBI(cfg.DBI))
+			if err != nil {
+				return fmt.Errorf("table: %s, %w", name, err)
+			}
+			if st.Entries > 0 {
+				hasPlain = true
+				return nil
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	switch {
+	case marker != nil && e == nil:
+		return ErrEncryptedDB
+	case marker == nil && e == nil:
+		return nil
+	case marker == nil && hasPlain:
+		return ErrNotEncryptedDB
+	case marker != nil:
+		plain, err := e.decrypt(encryptionTable, encryptionMarkerKey, marker)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(plain, encryptionMarker) {
+			return fmt.Errorf("%w: table %s: unexpected marker", ErrDecrypt, encryptionTable)
+		}
+		if marker[0] == e.ActiveKeyID() {
+			return nil
+		}
+	}
+	if db.ReadOnly() {
+		return nil
+	}
+	// new db or rotated key: marker is (re-)encrypted by active key - old keys can be removed
+	v, err := e.encrypt(encryptionTable, encryptionMarkerKey, encryptionMarker)
+	if err != nil {
+		return err
+	}
+	return db.env.Update(func(txn *mdbx.Txn) error {
+		dbi, err := txn.OpenDBI(encryptionTable, mdbx.Create, nil, nil)
+		if err != nil {
+			return err
+		}
+		return txn.Put(dbi, encryptionMarkerKey, v, 0)
+	})
+}
+
+// encrypted - table values are encrypted
+func (e *Encryption) encrypted(cfg kv.TableCfgItem) bool {
+	return e != nil && cfg.Flags&kv.DupSort == 0 && !cfg.AutoDupSortKeysConversion
+}
+
+// ReencryptTable - re-encrypts by active key values which were encrypted by other keys. Returns amount of re-encrypted
+// values. After all tables are re-encrypted - old keys can be removed.
+func (tx *MdbxTx) ReencryptTable(table string) (reencrypted uint64, err error) {
+	e := tx.db.opts.encryption
+	if !e.encrypted(tx.bucketCfg(table)) {
+		return 0, nil
+	}
+	c, err := tx.stdCursor(table)
+	if err != nil {
+		return 0, err
+	}
+	defer c.Close()
+	raw := c.(*MdbxCursor).c
+	active := e.ActiveKeyID()
+	for k, v, err := raw.Get(nil, nil, mdbx.First); ; k, v, err = raw.Get(nil, nil, mdbx.Next) {
+		if err != nil {
+			if mdbx.IsNotFound(err) {
+				return reencrypted, nil
+			}
+			return reencrypted, err
+		}
+		if len(v) > 0 && v[0] == active {
+			continue
+		}
+		k = common.Copy(k)
+		plain, err := e.decrypt(table, k, v)
+		if err != nil {
+			return reencrypted, err
+		}
+		if v, err = e.encrypt(table, k, plain); err != nil {
+			return reencrypted, err
+		}
+		if err = raw.Put(k, v, mdbx.Current); err != nil {
+			return reencrypted, fmt.Errorf("table: %s, %w", table, err)
+		}
+		reencrypted++
+	}
+}
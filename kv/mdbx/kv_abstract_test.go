@@ -26,6 +26,7 @@ import (
 	"github.com/ledgerwatch/erigon-lib/gointerfaces"
 	"github.com/ledgerwatch/erigon-lib/gointerfaces/remote"
 	"github.com/ledgerwatch/erigon-lib/kv"
+	"github.com/ledgerwatch/erigon-lib/kv/kvtests"
 	"github.com/ledgerwatch/erigon-lib/kv/mdbx"
 	"github.com/ledgerwatch/erigon-lib/kv/memdb"
 	"github.com/ledgerwatch/erigon-lib/kv/remotedb"
@@ -330,6 +331,26 @@ func TestRemoteKvRange(t *testing.T) {
 	require.NoError(err)
 }
 
+func TestConformance(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fix me on win please")
+	}
+	t.Run("mdbx", func(t *testing.T) {
+		kvtests.Run(t, func(t *testing.T) (kv.RwDB, kv.RoDB) {
+			db := memdb.NewTestDB(t)
+			return db, db
+		})
+	})
+	t.Run("remote", func(t *testing.T) {
+		kvtests.Run(t, func(t *testing.T) (kv.RwDB, kv.RoDB) {
+			writeDBs, readDBs := setupDatabases(t, log.New(), func(defaultBuckets kv.TableCfg) kv.TableCfg {
+				return defaultBuckets
+			})
+			return writeDBs[1], readDBs[2]
+		})
+	})
+}
+
 func setupDatabases(t *testing.T, logger log.Logger, f mdbx.TableCfgFunc) (writeDBs []kv.RwDB, readDBs []kv.RwDB) {
 	t.Helper()
 	ctx := context.Background()
@@ -631,3 +652,117 @@ func testMultiCursor(t *testing.T, db kv.RwDB, bucket1, bucket2 string) {
 //		})
 //	}
 //}
+
+func TestSeekMulti(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fix me on win please")
+	}
+
+	table, dupTable := kv.ChaindataTables[0], kv.ChaindataTables[1]
+	writeDBs, readDBs := setupDatabases(t, log.New(), func(defaultBuckets kv.TableCfg) kv.TableCfg {
+		return map[string]kv.TableCfgItem{
+			table:    {},
+			dupTable: {Flags: kv.DupSort},
+		}
+	})
+	ctx := context.Background()
+
+	key := func(i int) []byte { return []byte{byte(i >> 8), byte(i)} }
+	for _, db := range writeDBs {
+		require.NoError(t, db.Update(ctx, func(tx kv.RwTx) error {
+			for i := 0; i < 1000; i += 2 { // only even keys
+				require.NoError(t, tx.Append(table, key(i), key(i+1)))
+				require.NoError(t, tx.Put(dupTable, key(i), key(i+2)))
+				require.NoError(t, tx.Put(dupTable, key(i), key(i+1)))
+			}
+			return nil
+		}))
+	}
+
+	var keys [][]byte
+	for i := 0; i < 700; i++ { // dense part - then far jumps, duplicates and keys after last
+		keys = append(keys, key(i))
+	}
+	keys = append(keys, key(800), key(800), key(998), key(999), key(1500), key(2000))
+
+	for _, db := range readDBs {
+		require.NoError(t, db.View(ctx, func(tx kv.Tx) error {
+			for _, tbl := range []string{table, dupTable} {
+				c, err := tx.Cursor(tbl)
+				require.NoError(t, err)
+				defer c.Close()
+				vals, err := c.SeekMulti(keys)
+				require.NoError(t, err)
+				require.Equal(t, len(keys), len(vals))
+				for i, k := range keys {
+					n := int(k[0])<<8 | int(k[1])
+					if n%2 == 1 || n >= 1000 {
+						require.Nil(t, vals[i], "%x", k)
+						continue
+					}
+					require.Equal(t, key(n+1), vals[i], "%s %x", tbl, k)
+				}
+
+				_, err = c.SeekMulti([][]byte{key(2), key(1)})
+				require.ErrorIs(t, err, kv.ErrKeysNotSorted)
+			}
+			return nil
+		}))
+	}
+}
+
+func TestRemoteKvReadCache(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fix me on win please")
+	}
+	ctx, writeDB := context.Background(), memdb.NewTestDB(t)
+	grpcServer, conn := grpc.NewServer(), bufconn.Listen(1024*1024)
+	go func() {
+		remote.RegisterKVServer(grpcServer, remotedbserver.NewKvServer(ctx, writeDB, nil, nil))
+		if err := grpcServer.Serve(conn); err != nil {
+			log.Error("private RPC server fail", "err", err)
+		}
+	}()
+	defer grpcServer.Stop()
+
+	cc, err := grpc.Dial("", grpc.WithInsecure(), grpc.WithContextDialer(func(ctx context.Context, url string) (net.Conn, error) { return conn.Dial() }))
+	require.NoError(t, err)
+	db, err := remotedb.NewRemote(gointerfaces.VersionFromProto(remotedbserver.KvServiceAPIVersion), log.New(), remote.NewKVClient(cc)).WithReadCache(16).Open()
+	require.NoError(t, err)
+
+	require := require.New(t)
+	put := func(v byte) {
+		require.NoError(writeDB.Update(ctx, func(tx kv.RwTx) error {
+			return tx.Put(kv.HeaderNumber, []byte{1}, []byte{v})
+		}))
+	}
+	get := func(tx kv.Tx, k byte) []byte {
+		v, err := tx.GetOne(kv.HeaderNumber, []byte{k})
+		require.NoError(err)
+		return v
+	}
+	put(1)
+
+	oldTx, err := db.BeginRo(ctx)
+	require.NoError(err)
+	defer oldTx.Rollback()
+	require.Equal([]byte{1}, get(oldTx, 1))
+	require.Nil(get(oldTx, 2))
+	require.Equal([]byte{1}, get(oldTx, 1))
+	require.Nil(get(oldTx, 2))
+	hits, misses := db.ReadCacheStats()
+	require.Equal(uint64(2), hits)
+	require.Equal(uint64(2), misses)
+
+	// new view purges cache, old view doesn't read it anymore
+	put(2)
+	require.NoError(db.View(ctx, func(tx kv.Tx) error {
+		require.Equal([]byte{2}, get(tx, 1))
+		require.Equal([]byte{2}, get(tx, 1))
+		return nil
+	}))
+	require.Equal([]byte{1}, get(oldTx, 1))
+	hits, misses = db.ReadCacheStats()
+	require.Equal(uint64(3), hits)
+	require.Equal(uint64(3), misses)
+}
@@ -30,6 +30,7 @@ import (
 
 	"github.com/c2h5oh/datasize"
 	stack2 "github.com/go-stack/stack"
+	"github.com/ledgerwatch/erigon-lib/common"
 	"github.com/ledgerwatch/erigon-lib/common/dbg"
 	"github.com/ledgerwatch/erigon-lib/kv"
 	"github.com/ledgerwatch/erigon-lib/kv/iter"
@@ -65,6 +66,8 @@ type MdbxOpts struct {
 	verbosity      kv.DBVerbosityLvl
 	label          kv.Label // marker to distinct db instances - one process may open many databases. for example to collect metrics of only 1 database
 	inMem          bool
+	replication    *kv.ReplicationStream
+	encryption     *Encryption
 }
 
 func NewMDBX(log log.Logger) MdbxOpts {
@@ -181,6 +184,19 @@ func (opts MdbxOpts) WriteMergeThreshold(v uint64) MdbxOpts {
 	return opts
 }
 
+// Replication - write-sets of committed RwTx are published to `s`. PutReserve is not supported (PutV still works).
+func (opts MdbxOpts) Replication(s *kv.ReplicationStream) MdbxOpts {
+	opts.replication = s
+	return opts
+}
+
+// Encryption - values of non-DupSort tables are encrypted at rest (not keys, not DupSort tables - see Encryption).
+// Must be set at creation of db and for all opens of it: Open returns ErrEncryptedDB or ErrNotEncryptedDB otherwise.
+func (opts MdbxOpts) Encryption(e *Encryption) MdbxOpts {
+	opts.encryption = e
+	return opts
+}
+
 func (opts MdbxOpts) WithTableCfg(f TableCfgFunc) MdbxOpts {
 	opts.bucketsCfg = f
 	return opts
@@ -359,6 +375,10 @@ func (opts MdbxOpts) Open() (kv.RwDB, error) {
 	}); err != nil {
 		return nil, err
 	}
+	if err := db.checkEncryption(); err != nil {
+		env.Close()
+		return nil, fmt.Errorf("%w, path: %s", err, opts.path)
+	}
 
 	if !opts.inMem {
 		if staleReaders, err := db.env.ReaderCheck(); err != nil {
@@ -388,11 +408,18 @@ type MdbxKV struct {
 	opts         MdbxOpts
 	txSize       uint64
 	closed       atomic.Bool
+	tmpBucketID  atomic.Uint64 // suffix of names of temporary buckets
+	viewsLock    sync.Mutex
+	views        map[uint64]*pinnedView // ViewID => read-only txns kept by PinView
 }
 
 func (db *MdbxKV) PageSize() uint64 { return db.opts.pageSize }
 func (db *MdbxKV) ReadOnly() bool   { return db.opts.HasFlag(mdbx.Readonly) }
 
+func (db *MdbxKV) HealthCheck(ctx context.Context) (kv.HealthStatus, error) {
+	return kv.HealthCheck(ctx, db, db.opts.path)
+}
+
 // openDBIs - first trying to open existing DBI's in RO transaction
 // otherwise re-try by RW transaction
 // it allow open DB from another process - even if main process holding long RW transaction
@@ -528,6 +555,17 @@ type MdbxTx struct {
 	readOnly         bool
 	cursorID         uint64
 	ctx              context.Context
+	tmpBuckets       map[string]kv.TableCfgItem // see CreateTemporaryBucket
+	writeSet         []kv.ReplicationEntry      // see MdbxOpts.Replication
+	savepoints       []mdbxSavepoint            // see Savepoint
+	pinned           bool                       // txn of view pinned by PinView: returned to view on Rollback
+}
+
+// mdbxSavepoint - parent of nested txn and state of MdbxTx which is not stored in db
+type mdbxSavepoint struct {
+	parent      *mdbx.Txn
+	tmpBuckets  map[string]kv.TableCfgItem
+	writeSetLen int
 }
 
 type MdbxCursor struct {
@@ -537,6 +575,7 @@ type MdbxCursor struct {
 	bucketCfg  kv.TableCfgItem
 	dbi        mdbx.DBI
 	id         uint64
+	enc        *Encryption // nil - values of table are not encrypted
 }
 
 func (db *MdbxKV) Env() *mdbx.Env {
@@ -710,6 +749,47 @@ func (tx *MdbxTx) CreateBucket(name string) error {
 	return nil
 }
 
+// CreateTemporaryBucket - config of temporary buckets stored in tx (not in db.buckets): they are invisible for other
+// transactions and db.buckets is read concurrently by them.
+func (tx *MdbxTx) CreateTemporaryBucket(prefix string) (string, error) {
+	if tx.readOnly {
+		return "", fmt.Errorf("create temporary bucket: %s, read-only tx", prefix)
+	}
+	name := fmt.Sprintf("%s_tmp%d", prefix, tx.db.tmpBucketID.Inc())
+	if _, err := tx.tx.OpenDBI(name, 0, nil, nil); err == nil {
+		return "", fmt.Errorf("create temporary bucket: %s already exists", name)
+	} else if !mdbx.IsNotFound(err) {
+		return "", fmt.Errorf("create temporary bucket: %s, %w", name, err)
+	}
+	dbi, err := tx.tx.OpenDBI(name, mdbx.Create, nil, nil)
+	if err != nil {
+		return "", fmt.Errorf("create temporary bucket: %s, %w", name, err)
+	}
+	if tx.tmpBuckets == nil {
+		tx.tmpBuckets = map[string]kv.TableCfgItem{}
+	}
+	tx.tmpBuckets[name] = kv.TableCfgItem{DBI: kv.DBI(dbi)}
+	return name, nil
+}
+
+// dropTemporaryBuckets - before commit. On rollback they disappear with all other changes of tx.
+func (tx *MdbxTx) dropTemporaryBuckets() error {
+	for name, cfg := range tx.tmpBuckets {
+		if err := tx.tx.Drop(mdbx.DBI(cfg.DBI), true); err != nil {
+			return fmt.Errorf("drop temporary bucket: %s, %w", name, err)
+		}
+	}
+	tx.tmpBuckets = nil
+	return nil
+}
+
+func (tx *MdbxTx) bucketCfg(name string) kv.TableCfgItem {
+	if cfg, ok := tx.tmpBuckets[name]; ok {
+		return cfg
+	}
+	return tx.db.buckets[name]
+}
+
 func (tx *MdbxTx) dropEvenIfBucketIsNotDeprecated(name string) error {
 	dbi := tx.db.buckets[name].DBI
 	// if bucket was not open on db start, then it's may be deprecated
@@ -728,6 +808,7 @@ func (tx *MdbxTx) dropEvenIfBucketIsNotDeprecated(name string) error {
 	if err := tx.tx.Drop(mdbx.DBI(dbi), true); err != nil {
 		return err
 	}
+	tx.replicate(name, kv.ReplicationClearTable, nil, nil)
 	cnfCopy := tx.db.buckets[name]
 	cnfCopy.DBI = NonExistingDBI
 	tx.db.buckets[name] = cnfCopy
@@ -735,11 +816,15 @@ func (tx *MdbxTx) dropEvenIfBucketIsNotDeprecated(name string) error {
 }
 
 func (tx *MdbxTx) ClearBucket(bucket string) error {
-	dbi := tx.db.buckets[bucket].DBI
+	dbi := tx.bucketCfg(bucket).DBI
 	if dbi == NonExistingDBI {
 		return nil
 	}
-	return tx.tx.Drop(mdbx.DBI(dbi), false)
+	if err := tx.tx.Drop(mdbx.DBI(dbi), false); err != nil {
+		return err
+	}
+	tx.replicate(bucket, kv.ReplicationClearTable, nil, nil)
+	return nil
 }
 
 func (tx *MdbxTx) DropBucket(bucket string) error {
@@ -751,16 +836,90 @@ func (tx *MdbxTx) DropBucket(bucket string) error {
 }
 
 func (tx *MdbxTx) ExistsBucket(bucket string) (bool, error) {
+	if _, ok := tx.tmpBuckets[bucket]; ok {
+		return true, nil
+	}
 	if cfg, ok := tx.db.buckets[bucket]; ok {
 		return cfg.DBI != NonExistingDBI, nil
 	}
 	return false, nil
 }
 
+// Savepoint - implemented by nested txn: writes go to child txn until RollbackTo (abort of child) or
+// ReleaseSavepoint (commit of child into parent). Not supported with WriteMap. Changes of db.buckets (CreateBucket,
+// DropBucket) are not undone by RollbackTo.
+func (tx *MdbxTx) Savepoint() (kv.Savepoint, error) {
+	if tx.readOnly {
+		return 0, fmt.Errorf("savepoint: read-only tx")
+	}
+	tx.closeCursors()
+	child, err := tx.db.env.BeginTxn(tx.tx, 0)
+	if err != nil {
+		return 0, fmt.Errorf("savepoint: %w", err)
+	}
+	sp := mdbxSavepoint{parent: tx.tx, writeSetLen: len(tx.writeSet)}
+	if tx.tmpBuckets != nil {
+		sp.tmpBuckets = make(map[string]kv.TableCfgItem, len(tx.tmpBuckets))
+		for name, cfg := range tx.tmpBuckets {
+			sp.tmpBuckets[name] = cfg
+		}
+	}
+	tx.savepoints = append(tx.savepoints, sp)
+	tx.tx = child
+	return kv.Savepoint(len(tx.savepoints)), nil
+}
+
+func (tx *MdbxTx) RollbackTo(sp kv.Savepoint) error {
+	if sp < 1 || int(sp) > len(tx.savepoints) {
+		return fmt.Errorf("rollback to %d: %w", sp, kv.ErrSavepointNotFound)
+	}
+	tx.closeCursors()
+	for len(tx.savepoints) >= int(sp) {
+		last := tx.savepoints[len(tx.savepoints)-1]
+		tx.tx.Abort()
+		tx.tx = last.parent
+		tx.tmpBuckets = last.tmpBuckets
+		tx.writeSet = tx.writeSet[:last.writeSetLen]
+		tx.savepoints = tx.savepoints[:len(tx.savepoints)-1]
+	}
+	return nil
+}
+
+func (tx *MdbxTx) ReleaseSavepoint(sp kv.Savepoint) error {
+	if sp < 1 || int(sp) > len(tx.savepoints) {
+		return fmt.Errorf("release savepoint %d: %w", sp, kv.ErrSavepointNotFound)
+	}
+	tx.closeCursors()
+	for len(tx.savepoints) >= int(sp) {
+		last := tx.savepoints[len(tx.savepoints)-1]
+		if _, err := tx.tx.Commit(); err != nil {
+			// mdbx aborts txn on failed commit
+			tx.tx = last.parent
+			tx.tmpBuckets = last.tmpBuckets
+			tx.writeSet = tx.writeSet[:last.writeSetLen]
+			tx.savepoints = tx.savepoints[:len(tx.savepoints)-1]
+			return fmt.Errorf("release savepoint %d: %w", len(tx.savepoints)+1, err)
+		}
+		tx.tx = last.parent
+		tx.savepoints = tx.savepoints[:len(tx.savepoints)-1]
+	}
+	return nil
+}
+
 func (tx *MdbxTx) Commit() error {
 	if tx.tx == nil {
 		return nil
 	}
+	if tx.pinned {
+		tx.Rollback()
+		return nil
+	}
+	if len(tx.savepoints) > 0 {
+		if err := tx.ReleaseSavepoint(1); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
 	defer func() {
 		tx.tx = nil
 		tx.db.wg.Done()
@@ -771,6 +930,10 @@ func (tx *MdbxTx) Commit() error {
 		}
 	}()
 	tx.closeCursors()
+	if err := tx.dropTemporaryBuckets(); err != nil {
+		tx.tx.Abort()
+		return err
+	}
 
 	//slowTx := 10 * time.Second
 	//if debug.SlowCommit() > 0 {
@@ -782,10 +945,15 @@ func (tx *MdbxTx) Commit() error {
 	//}
 	tx.CollectMetrics()
 
+	viewID := tx.tx.ID()
 	latency, err := tx.tx.Commit()
 	if err != nil {
 		return err
 	}
+	if tx.db.opts.replication != nil {
+		tx.db.opts.replication.Publish(viewID, tx.writeSet)
+		tx.writeSet = nil
+	}
 
 	if tx.db.opts.label == kv.ChainDB {
 		kv.DbCommitPreparation.Update(latency.Preparation.Seconds())
@@ -811,6 +979,13 @@ func (tx *MdbxTx) Rollback() {
 	if tx.tx == nil {
 		return
 	}
+	if tx.pinned {
+		tx.closeCursors()
+		if tx.db.parkTxn(tx.tx) {
+			tx.tx = nil
+			return
+		}
+	}
 	defer func() {
 		tx.tx = nil
 		tx.db.wg.Done()
@@ -821,10 +996,28 @@ func (tx *MdbxTx) Rollback() {
 		}
 	}()
 	tx.closeCursors()
+	for i := len(tx.savepoints) - 1; i >= 0; i-- {
+		tx.tx.Abort()
+		tx.tx = tx.savepoints[i].parent
+	}
+	tx.savepoints = nil
+	tx.tmpBuckets = nil
+	tx.writeSet = nil
 	//tx.printDebugInfo()
 	tx.tx.Abort()
 }
 
+// replicate - records write, if replication is enabled. Writes to temporary tables are not replicated.
+func (tx *MdbxTx) replicate(table string, op kv.ReplicationOp, k, v []byte) {
+	if tx.db.opts.replication == nil {
+		return
+	}
+	if _, ok := tx.tmpBuckets[table]; ok {
+		return
+	}
+	tx.writeSet = append(tx.writeSet, kv.ReplicationEntry{Table: table, Op: op, K: common.Copy(k), V: common.Copy(v)})
+}
+
 func (tx *MdbxTx) SpaceDirty() (uint64, uint64, error) {
 	txInfo, err := tx.tx.Info(true)
 	if err != nil {
@@ -897,6 +1090,47 @@ func (tx *MdbxTx) Put(table string, k, v []byte) error {
 	return c.Put(k, v)
 }
 
+func (tx *MdbxTx) PutReserve(table string, k []byte, size int) ([]byte, error) {
+	if b := tx.bucketCfg(table); b.Flags&kv.DupSort != 0 || b.AutoDupSortKeysConversion {
+		return nil, fmt.Errorf("table: %s, PutReserve is not supported by DupSort tables", table)
+	}
+	c, err := tx.statelessCursor(table)
+	if err != nil {
+		return nil, err
+	}
+	return c.(*MdbxCursor).PutReserve(k, size)
+}
+
+func (tx *MdbxTx) PutV(table string, k []byte, parts ...[]byte) error {
+	if b := tx.bucketCfg(table); b.Flags&kv.DupSort != 0 || b.AutoDupSortKeysConversion || tx.db.opts.replication != nil || tx.db.opts.encryption.encrypted(b) {
+		return tx.Put(table, k, concatParts(parts))
+	}
+	size := 0
+	for _, p := range parts {
+		size += len(p)
+	}
+	v, err := tx.PutReserve(table, k, size)
+	if err != nil {
+		return err
+	}
+	for _, p := range parts {
+		v = v[copy(v, p):]
+	}
+	return nil
+}
+
+func concatParts(parts [][]byte) []byte {
+	size := 0
+	for _, p := range parts {
+		size += len(p)
+	}
+	v := make([]byte, 0, size)
+	for _, p := range parts {
+		v = append(v, p...)
+	}
+	return v
+}
+
 func (tx *MdbxTx) Delete(table string, k []byte) error {
 	c, err := tx.statelessCursor(table)
 	if err != nil {
@@ -905,6 +1139,51 @@ func (tx *MdbxTx) Delete(table string, k []byte) error {
 	return c.Delete(k)
 }
 
+// DeleteRange - deletes entries of keys in [from, to), from == nil - from first key, to == nil - till end of table.
+// MDBX has no range delete: entries are deleted one by one by cursor, cost is same as cursor loop with DeleteCurrent
+// in caller's code. Only whole table (from == nil && to == nil) is dropped by MDBX itself (ClearBucket).
+func (tx *MdbxTx) DeleteRange(table string, from, to []byte) error {
+	if from == nil && to == nil {
+		return tx.ClearBucket(table)
+	}
+	if b := tx.bucketCfg(table); b.Flags&kv.DupSort != 0 && !b.AutoDupSortKeysConversion {
+		c, err := tx.RwCursorDupSort(table)
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+		for k, _, err := c.Seek(from); k != nil; k, _, err = c.NextNoDup() {
+			if err != nil {
+				return err
+			}
+			if to != nil && bytes.Compare(k, to) >= 0 {
+				break
+			}
+			if err = c.DeleteCurrentDuplicates(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	c, err := tx.RwCursor(table)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	for k, _, err := c.Seek(from); k != nil; k, _, err = c.Next() {
+		if err != nil {
+			return err
+		}
+		if to != nil && bytes.Compare(k, to) >= 0 {
+			break
+		}
+		if err = c.DeleteCurrent(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (tx *MdbxTx) GetOne(bucket string, k []byte) ([]byte, error) {
 	c, err := tx.statelessCursor(bucket)
 	if err != nil {
@@ -998,7 +1277,7 @@ func (tx *MdbxTx) BucketStat(name string) (*mdbx.Stat, error) {
 	if name == "root" {
 		return tx.tx.StatDBI(mdbx.DBI(1))
 	}
-	st, err := tx.tx.StatDBI(mdbx.DBI(tx.db.buckets[name].DBI))
+	st, err := tx.tx.StatDBI(mdbx.DBI(tx.bucketCfg(name).DBI))
 	if err != nil {
 		return nil, fmt.Errorf("bucket: %s, %w", name, err)
 	}
@@ -1035,7 +1314,7 @@ func (tx *MdbxTx) Reset() (err error) {
 }
 
 func (tx *MdbxTx) RwCursor(bucket string) (kv.RwCursor, error) {
-	b := tx.db.buckets[bucket]
+	b := tx.bucketCfg(bucket)
 	if b.AutoDupSortKeysConversion {
 		return tx.stdCursor(bucket)
 	}
@@ -1052,8 +1331,11 @@ func (tx *MdbxTx) Cursor(bucket string) (kv.Cursor, error) {
 }
 
 func (tx *MdbxTx) stdCursor(bucket string) (kv.RwCursor, error) {
-	b := tx.db.buckets[bucket]
-	c := &MdbxCursor{bucketName: bucket, tx: tx, bucketCfg: b, dbi: mdbx.DBI(tx.db.buckets[bucket].DBI), id: tx.cursorID}
+	b := tx.bucketCfg(bucket)
+	c := &MdbxCursor{bucketName: bucket, tx: tx, bucketCfg: b, dbi: mdbx.DBI(b.DBI), id: tx.cursorID}
+	if tx.db.opts.encryption.encrypted(b) {
+		c.enc = tx.db.opts.encryption
+	}
 	tx.cursorID++
 
 	var err error
@@ -1083,30 +1365,52 @@ func (tx *MdbxTx) CursorDupSort(bucket string) (kv.CursorDupSort, error) {
 }
 
 // methods here help to see better pprof picture
-func (c *MdbxCursor) set(k []byte) ([]byte, []byte, error) { return c.c.Get(k, nil, mdbx.Set) }
-func (c *MdbxCursor) getCurrent() ([]byte, []byte, error)  { return c.c.Get(nil, nil, mdbx.GetCurrent) }
-func (c *MdbxCursor) first() ([]byte, []byte, error)       { return c.c.Get(nil, nil, mdbx.First) }
-func (c *MdbxCursor) next() ([]byte, []byte, error)        { return c.c.Get(nil, nil, mdbx.Next) }
+func (c *MdbxCursor) set(k []byte) ([]byte, []byte, error) { return c.get(k, mdbx.Set) }
+func (c *MdbxCursor) getCurrent() ([]byte, []byte, error)  { return c.get(nil, mdbx.GetCurrent) }
+func (c *MdbxCursor) first() ([]byte, []byte, error)       { return c.get(nil, mdbx.First) }
+func (c *MdbxCursor) next() ([]byte, []byte, error)        { return c.get(nil, mdbx.Next) }
 func (c *MdbxCursor) nextDup() ([]byte, []byte, error)     { return c.c.Get(nil, nil, mdbx.NextDup) }
-func (c *MdbxCursor) nextNoDup() ([]byte, []byte, error)   { return c.c.Get(nil, nil, mdbx.NextNoDup) }
-func (c *MdbxCursor) prev() ([]byte, []byte, error)        { return c.c.Get(nil, nil, mdbx.Prev) }
+func (c *MdbxCursor) nextNoDup() ([]byte, []byte, error)   { return c.get(nil, mdbx.NextNoDup) }
+func (c *MdbxCursor) prev() ([]byte, []byte, error)        { return c.get(nil, mdbx.Prev) }
 func (c *MdbxCursor) prevDup() ([]byte, []byte, error)     { return c.c.Get(nil, nil, mdbx.PrevDup) }
-func (c *MdbxCursor) prevNoDup() ([]byte, []byte, error)   { return c.c.Get(nil, nil, mdbx.PrevNoDup) }
-func (c *MdbxCursor) last() ([]byte, []byte, error)        { return c.c.Get(nil, nil, mdbx.Last) }
+func (c *MdbxCursor) prevNoDup() ([]byte, []byte, error)   { return c.get(nil, mdbx.PrevNoDup) }
+func (c *MdbxCursor) last() ([]byte, []byte, error)        { return c.get(nil, mdbx.Last) }
 func (c *MdbxCursor) delCurrent() error                    { return c.c.Del(mdbx.Current) }
 func (c *MdbxCursor) delAllDupData() error                 { return c.c.Del(mdbx.AllDups) }
-func (c *MdbxCursor) put(k, v []byte) error                { return c.c.Put(k, v, 0) }
-func (c *MdbxCursor) putCurrent(k, v []byte) error         { return c.c.Put(k, v, mdbx.Current) }
-func (c *MdbxCursor) putNoOverwrite(k, v []byte) error     { return c.c.Put(k, v, mdbx.NoOverwrite) }
+func (c *MdbxCursor) put(k, v []byte) error                { return c.putFlags(k, v, 0) }
+func (c *MdbxCursor) putCurrent(k, v []byte) error         { return c.putFlags(k, v, mdbx.Current) }
+func (c *MdbxCursor) putNoOverwrite(k, v []byte) error     { return c.putFlags(k, v, mdbx.NoOverwrite) }
 func (c *MdbxCursor) putNoDupData(k, v []byte) error       { return c.c.Put(k, v, mdbx.NoDupData) }
-func (c *MdbxCursor) append(k, v []byte) error             { return c.c.Put(k, v, mdbx.Append) }
+func (c *MdbxCursor) append(k, v []byte) error             { return c.putFlags(k, v, mdbx.Append) }
 func (c *MdbxCursor) appendDup(k, v []byte) error          { return c.c.Put(k, v, mdbx.AppendDup) }
+
+// get - positioning of cursor without value, value is decrypted if table is encrypted
+func (c *MdbxCursor) get(k []byte, op uint) ([]byte, []byte, error) {
+	k, v, err := c.c.Get(k, nil, op)
+	if err != nil || c.enc == nil {
+		return k, v, err
+	}
+	if v, err = c.enc.decrypt(c.bucketName, k, v); err != nil {
+		return nil, nil, err
+	}
+	return k, v, nil
+}
+
+func (c *MdbxCursor) putFlags(k, v []byte, flags uint) error {
+	if c.enc != nil {
+		var err error
+		if v, err = c.enc.encrypt(c.bucketName, k, v); err != nil {
+			return err
+		}
+	}
+	return c.c.Put(k, v, flags)
+}
 func (c *MdbxCursor) getBoth(k, v []byte) ([]byte, error) {
 	_, v, err := c.c.Get(k, v, mdbx.GetBoth)
 	return v, err
 }
 func (c *MdbxCursor) setRange(k []byte) ([]byte, []byte, error) {
-	return c.c.Get(k, nil, mdbx.SetRange)
+	return c.get(k, mdbx.SetRange)
 }
 func (c *MdbxCursor) getBothRange(k, v []byte) ([]byte, error) {
 	_, v, err := c.c.Get(k, v, mdbx.GetBothRange)
@@ -1129,6 +1433,25 @@ func (c *MdbxCursor) Count() (uint64, error) {
 	return st.Entries, nil
 }
 
+// seekToRatioExactLimit - tables with less entries are walked to exact position by SeekToRatio
+const seekToRatioExactLimit = 1024
+
+// SeekToRatio - small tables (by b-tree stats) are walked to exact position, big ones - see kv.SeekToRatioByKeys
+func (c *MdbxCursor) SeekToRatio(f float64) ([]byte, []byte, error) {
+	st, err := c.tx.tx.StatDBI(c.dbi)
+	if err != nil {
+		return nil, nil, err
+	}
+	if st.Entries > seekToRatioExactLimit || !(f > 0) || f >= 1 {
+		return kv.SeekToRatioByKeys(c, f)
+	}
+	k, v, err := c.First()
+	for n := uint64(f * float64(st.Entries)); n > 0 && k != nil && err == nil; n-- {
+		k, v, err = c.Next()
+	}
+	return k, v, err
+}
+
 func (c *MdbxCursor) First() ([]byte, []byte, error) {
 	return c.Seek(nil)
 }
@@ -1143,13 +1466,7 @@ func (c *MdbxCursor) Last() ([]byte, []byte, error) {
 		return []byte{}, nil, err
 	}
 
-	b := c.bucketCfg
-	if b.AutoDupSortKeysConversion && len(k) == b.DupToLen {
-		keyPart := b.DupFromLen - b.DupToLen
-		k = append(k, v[:keyPart]...)
-		v = v[keyPart:]
-	}
-
+	k, v = c.bucketCfg.FromDupSort(k, v)
 	return k, v, nil
 }
 
@@ -1243,13 +1560,7 @@ func (c *MdbxCursor) Next() (k, v []byte, err error) {
 		return []byte{}, nil, fmt.Errorf("failed MdbxKV cursor.Next(): %w", err)
 	}
 
-	b := c.bucketCfg
-	if b.AutoDupSortKeysConversion && len(k) == b.DupToLen {
-		keyPart := b.DupFromLen - b.DupToLen
-		k = append(k, v[:keyPart]...)
-		v = v[keyPart:]
-	}
-
+	k, v = c.bucketCfg.FromDupSort(k, v)
 	return k, v, nil
 }
 
@@ -1262,13 +1573,7 @@ func (c *MdbxCursor) Prev() (k, v []byte, err error) {
 		return []byte{}, nil, fmt.Errorf("failed MdbxKV cursor.Prev(): %w", err)
 	}
 
-	b := c.bucketCfg
-	if b.AutoDupSortKeysConversion && len(k) == b.DupToLen {
-		keyPart := b.DupFromLen - b.DupToLen
-		k = append(k, v[:keyPart]...)
-		v = v[keyPart:]
-	}
-
+	k, v = c.bucketCfg.FromDupSort(k, v)
 	return k, v, nil
 }
 
@@ -1282,19 +1587,59 @@ func (c *MdbxCursor) Current() ([]byte, []byte, error) {
 		return []byte{}, nil, err
 	}
 
-	b := c.bucketCfg
-	if b.AutoDupSortKeysConversion && len(k) == b.DupToLen {
-		keyPart := b.DupFromLen - b.DupToLen
-		k = append(k, v[:keyPart]...)
-		v = v[keyPart:]
-	}
-
+	k, v = c.bucketCfg.FromDupSort(k, v)
 	return k, v, nil
 }
 
+// seekMultiNextSteps - SeekMulti reaches next key by NextNoDup if it's in few steps from current position,
+// otherwise does Seek
+const seekMultiNextSteps = 4
+
+// SeekMulti - values of DupSort tables are first values of keys.
+// Values are valid until end of tx (or until any write for RwTx).
+func (c *MdbxCursor) SeekMulti(keys [][]byte) ([][]byte, error) {
+	if c.bucketCfg.AutoDupSortKeysConversion {
+		return kv.SeekMultiExact(c, keys)
+	}
+	vals := make([][]byte, len(keys))
+	var k, v []byte
+	var err error
+	positioned := false
+	for i, key := range keys {
+		if i > 0 && bytes.Compare(keys[i-1], key) > 0 {
+			return nil, fmt.Errorf("SeekMulti: %w, bucket: %s, %x > %x", kv.ErrKeysNotSorted, c.bucketName, keys[i-1], key)
+		}
+		for step := 0; positioned && step < seekMultiNextSteps && bytes.Compare(k, key) < 0; step++ {
+			if k, v, err = c.nextNoDup(); err != nil {
+				if mdbx.IsNotFound(err) {
+					return vals, nil // end of table: rest of keys not found
+				}
+				return nil, fmt.Errorf("SeekMulti: %w, bucket: %s", err, c.bucketName)
+			}
+		}
+		if !positioned || bytes.Compare(k, key) < 0 {
+			if k, v, err = c.setRange(key); err != nil {
+				if mdbx.IsNotFound(err) {
+					return vals, nil
+				}
+				return nil, fmt.Errorf("SeekMulti: %w, bucket: %s, key: %x", err, c.bucketName, key)
+			}
+			positioned = true
+		}
+		if bytes.Equal(k, key) {
+			vals[i] = v
+		}
+	}
+	return vals, nil
+}
+
 func (c *MdbxCursor) Delete(k []byte) error {
 	if c.bucketCfg.AutoDupSortKeysConversion {
-		return c.deleteDupSort(k)
+		if err := c.deleteDupSort(k); err != nil {
+			return err
+		}
+		c.tx.replicate(c.bucketName, kv.ReplicationDelete, k, nil)
+		return nil
 	}
 
 	_, _, err := c.set(k)
@@ -1306,9 +1651,15 @@ func (c *MdbxCursor) Delete(k []byte) error {
 	}
 
 	if c.bucketCfg.Flags&mdbx.DupSort != 0 {
-		return c.delAllDupData()
+		err = c.delAllDupData()
+	} else {
+		err = c.delCurrent()
 	}
-	return c.delCurrent()
+	if err != nil {
+		return err
+	}
+	c.tx.replicate(c.bucketName, kv.ReplicationDelete, k, nil)
+	return nil
 }
 
 // DeleteCurrent This function deletes the key/data pair to which the cursor refers.
@@ -1317,7 +1668,27 @@ func (c *MdbxCursor) Delete(k []byte) error {
 // Both MDB_NEXT and MDB_GET_CURRENT will return the same record after
 // this operation.
 func (c *MdbxCursor) DeleteCurrent() error {
-	return c.delCurrent()
+	if c.tx.db.opts.replication == nil {
+		return c.delCurrent()
+	}
+	k, v, err := c.getCurrent()
+	if err != nil {
+		return err
+	}
+	k, v = common.Copy(k), common.Copy(v)
+	if err := c.delCurrent(); err != nil {
+		return err
+	}
+	switch b := c.bucketCfg; {
+	case b.AutoDupSortKeysConversion:
+		k, _ = b.FromDupSort(k, v)
+		c.tx.replicate(c.bucketName, kv.ReplicationDelete, k, nil)
+	case b.Flags&mdbx.DupSort != 0:
+		c.tx.replicate(c.bucketName, kv.ReplicationDeleteExact, k, v)
+	default:
+		c.tx.replicate(c.bucketName, kv.ReplicationDelete, k, nil)
+	}
+	return nil
 }
 
 func (c *MdbxCursor) deleteDupSort(key []byte) error {
@@ -1357,7 +1728,29 @@ func (c *MdbxCursor) PutNoOverwrite(key []byte, value []byte) error {
 		panic("not implemented")
 	}
 
-	return c.putNoOverwrite(key, value)
+	if err := c.putNoOverwrite(key, value); err != nil {
+		return err
+	}
+	c.tx.replicate(c.bucketName, kv.ReplicationPut, key, value)
+	return nil
+}
+
+// PutReserve - see kv.RwTx.PutReserve
+func (c *MdbxCursor) PutReserve(key []byte, size int) ([]byte, error) {
+	if c.bucketCfg.Flags&kv.DupSort != 0 || c.bucketCfg.AutoDupSortKeysConversion {
+		return nil, fmt.Errorf("table: %s, PutReserve is not supported by DupSort tables", c.bucketName)
+	}
+	if c.tx.db.opts.replication != nil {
+		return nil, fmt.Errorf("table: %s, PutReserve is not supported with replication", c.bucketName)
+	}
+	if c.enc != nil {
+		return nil, fmt.Errorf("table: %s, PutReserve is not supported by encrypted tables", c.bucketName)
+	}
+	v, err := c.c.PutReserve(key, size, 0)
+	if err != nil {
+		return nil, fmt.Errorf("table: %s, err: %w", c.bucketName, err)
+	}
+	return v, nil
 }
 
 func (c *MdbxCursor) Put(key []byte, value []byte) error {
@@ -1366,11 +1759,13 @@ func (c *MdbxCursor) Put(key []byte, value []byte) error {
 		if err := c.putDupSort(key, value); err != nil {
 			return err
 		}
+		c.tx.replicate(c.bucketName, kv.ReplicationPut, key, value)
 		return nil
 	}
 	if err := c.put(key, value); err != nil {
 		return fmt.Errorf("table: %s, err: %w", c.bucketName, err)
 	}
+	c.tx.replicate(c.bucketName, kv.ReplicationPut, key, value)
 	return nil
 }
 
@@ -1450,27 +1845,27 @@ func (c *MdbxCursor) Append(k []byte, v []byte) error {
 		return fmt.Errorf("mdbx doesn't support empty keys. bucket: %s", c.bucketName)
 	}
 	b := c.bucketCfg
+	origK, origV := k, v
 	if b.AutoDupSortKeysConversion {
 		from, to := b.DupFromLen, b.DupToLen
 		if len(k) != from && len(k) >= to {
 			return fmt.Errorf("append dupsort bucket: %s, can have keys of len==%d and len<%d. key: %x,%d", c.bucketName, from, to, k, len(k))
 		}
 
-		if len(k) == from {
-			v = append(k[to:], v...)
-			k = k[:to]
-		}
+		k, v = b.ToDupSort(k, v)
 	}
 
 	if b.Flags&mdbx.DupSort != 0 {
 		if err := c.appendDup(k, v); err != nil {
 			return fmt.Errorf("bucket: %s, %w", c.bucketName, err)
 		}
+		c.tx.replicate(c.bucketName, kv.ReplicationPut, origK, origV)
 		return nil
 	}
 	if err := c.append(k, v); err != nil {
 		return fmt.Errorf("bucket: %s, %w", c.bucketName, err)
 	}
+	c.tx.replicate(c.bucketName, kv.ReplicationPut, origK, origV)
 	return nil
 }
 
@@ -1499,7 +1894,11 @@ func (c *MdbxDupSortCursor) DeleteExact(k1, k2 []byte) error {
 		}
 		return err
 	}
-	return c.delCurrent()
+	if err := c.delCurrent(); err != nil {
+		return err
+	}
+	c.tx.replicate(c.bucketName, kv.ReplicationDeleteExact, k1, k2)
+	return nil
 }
 
 func (c *MdbxDupSortCursor) SeekBothExact(key, value []byte) ([]byte, []byte, error) {
@@ -1596,6 +1995,7 @@ func (c *MdbxDupSortCursor) Append(k []byte, v []byte) error {
 	if err := c.c.Put(k, v, mdbx.Append|mdbx.AppendDup); err != nil {
 		return fmt.Errorf("in Append: bucket=%s, %w", c.bucketName, err)
 	}
+	c.tx.replicate(c.bucketName, kv.ReplicationPut, k, v)
 	return nil
 }
 
@@ -1603,6 +2003,7 @@ func (c *MdbxDupSortCursor) AppendDup(k []byte, v []byte) error {
 	if err := c.appendDup(k, v); err != nil {
 		return fmt.Errorf("in AppendDup: bucket=%s, %w", c.bucketName, err)
 	}
+	c.tx.replicate(c.bucketName, kv.ReplicationPut, k, v)
 	return nil
 }
 
@@ -1610,15 +2011,24 @@ func (c *MdbxDupSortCursor) PutNoDupData(key, value []byte) error {
 	if err := c.putNoDupData(key, value); err != nil {
 		return fmt.Errorf("in PutNoDupData: %w", err)
 	}
-
+	c.tx.replicate(c.bucketName, kv.ReplicationPut, key, value)
 	return nil
 }
 
 // DeleteCurrentDuplicates - delete all of the data items for the current key.
 func (c *MdbxDupSortCursor) DeleteCurrentDuplicates() error {
+	var k []byte
+	if c.tx.db.opts.replication != nil {
+		var err error
+		if k, _, err = c.getCurrent(); err != nil {
+			return fmt.Errorf("in DeleteCurrentDuplicates: %w", err)
+		}
+		k = common.Copy(k)
+	}
 	if err := c.delAllDupData(); err != nil {
 		return fmt.Errorf("in DeleteCurrentDuplicates: %w", err)
 	}
+	c.tx.replicate(c.bucketName, kv.ReplicationDelete, k, nil)
 	return nil
 }
 
@@ -1660,6 +2070,14 @@ func (tx *MdbxTx) ForEach(bucket string, fromPrefix []byte, walker func(k, v []b
 	return nil
 }
 
+func (tx *MdbxTx) ForEachCtx(ctx context.Context, bucket string, fromPrefix []byte, walker func(k, v []byte) error) error {
+	return tx.ForEach(bucket, fromPrefix, kv.WalkerWithCtx(ctx, walker))
+}
+
+func (tx *MdbxTx) ForPrefixCtx(ctx context.Context, bucket string, prefix []byte, walker func(k, v []byte) error) error {
+	return tx.ForPrefix(bucket, prefix, kv.WalkerWithCtx(ctx, walker))
+}
+
 func (tx *MdbxTx) ForPrefix(bucket string, prefix []byte, walker func(k, v []byte) error) error {
 	c, err := tx.Cursor(bucket)
 	if err != nil {
@@ -78,6 +78,10 @@ func (t *TemporaryMdbx) PageSize() uint64 {
 	return t.db.PageSize()
 }
 
+func (t *TemporaryMdbx) HealthCheck(ctx context.Context) (kv.HealthStatus, error) {
+	return t.db.HealthCheck(ctx)
+}
+
 func (t *TemporaryMdbx) Close() {
 	t.db.Close()
 	os.RemoveAll(t.path)
@@ -17,13 +17,20 @@
 package mdbx
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/ledgerwatch/erigon-lib/kv"
 	"github.com/ledgerwatch/log/v3"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/torquem-ch/mdbx-go/mdbx"
 )
 
 func BaseCase(t *testing.T) (kv.RwDB, kv.RwTx, kv.RwCursorDupSort) {
@@ -189,6 +196,64 @@ func TestPutGet(t *testing.T) {
 	require.Nil(t, v)
 }
 
+func TestPutReserve(t *testing.T) {
+	_, tx, _ := BaseCase(t)
+	table := "Plain"
+	require.NoError(t, tx.CreateBucket(table))
+
+	v, err := tx.PutReserve(table, []byte("key1"), 4)
+	require.NoError(t, err)
+	copy(v, "abcd")
+	require.NoError(t, tx.PutV(table, []byte("key2"), []byte("ab"), nil, []byte("cde")))
+	require.NoError(t, tx.PutV(table, []byte("key3")))
+
+	for k, expect := range map[string]string{"key1": "abcd", "key2": "abcde", "key3": ""} {
+		v, err = tx.GetOne(table, []byte(k))
+		require.NoError(t, err)
+		require.Equal(t, expect, string(v))
+	}
+
+	// DupSort table: PutV falls back to Put
+	_, err = tx.PutReserve("Table", []byte("key1"), 4)
+	require.Error(t, err)
+	require.NoError(t, tx.PutV("Table", []byte("key2"), []byte("value"), []byte("2.1")))
+	v, err = tx.GetOne("Table", []byte("key2"))
+	require.NoError(t, err)
+	require.Equal(t, "value2.1", string(v))
+}
+
+func TestSeekToRatio(t *testing.T) {
+	_, tx, _ := BaseCase(t)
+	table := "Plain"
+	require.NoError(t, tx.CreateBucket(table))
+	c, err := tx.RwCursor(table)
+	require.NoError(t, err)
+	defer c.Close()
+
+	k, _, err := c.SeekToRatio(0.5)
+	require.NoError(t, err)
+	require.Nil(t, k)
+
+	key := make([]byte, 8)
+	put := func(from, to uint64) {
+		for i := from; i < to; i++ {
+			binary.BigEndian.PutUint64(key, i<<48)
+			require.NoError(t, c.Put(key, key))
+		}
+	}
+	put(0, 100)
+	k, _, err = c.SeekToRatio(0.5) // small table - exact
+	require.NoError(t, err)
+	require.Equal(t, uint64(50), binary.BigEndian.Uint64(k)>>48)
+
+	put(100, 10_000)
+	for _, f := range []float64{0, 0.25, 0.5, 0.9, 1} {
+		k, _, err = c.SeekToRatio(f)
+		require.NoError(t, err)
+		require.InDelta(t, f*9999, float64(binary.BigEndian.Uint64(k)>>48), 1)
+	}
+}
+
 func TestIncrementRead(t *testing.T) {
 	_, tx, _ := BaseCase(t)
 
@@ -319,6 +384,35 @@ func TestForPrefix(t *testing.T) {
 	require.Nil(t, keys2)
 }
 
+func TestForEachCtx(t *testing.T) {
+	_, tx, c := BaseCase(t)
+
+	table := "Table"
+
+	var keys []string
+	err := tx.ForEachCtx(context.Background(), table, []byte("key2"), func(k, v []byte) error {
+		keys = append(keys, string(k))
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"key3", "key3"}, keys)
+
+	for i := 0; i < 2*kv.CtxCheckInterval; i++ {
+		require.NoError(t, c.Put([]byte(fmt.Sprintf("key5.%04d", i)), []byte("value")))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	count := 0
+	err = tx.ForPrefixCtx(ctx, table, []byte("key5"), func(k, v []byte) error {
+		count++
+		if count == 10 {
+			cancel()
+		}
+		return nil
+	})
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, kv.CtxCheckInterval, count)
+}
+
 func TestAppendFirstLast(t *testing.T) {
 	_, tx, c := BaseCase(t)
 
@@ -571,6 +665,57 @@ func TestDupDelete(t *testing.T) {
 	assert.Zero(t, count)
 }
 
+func TestDeleteRange(t *testing.T) {
+	_, tx, c := BaseCase(t)
+	table := "Table"
+	require.NoError(t, c.Put([]byte("key2"), []byte("value2.1")))
+	require.NoError(t, c.Put([]byte("key2"), []byte("value2.2")))
+	require.NoError(t, c.Put([]byte("key4"), []byte("value4.1")))
+	left := func(table string) (keys, values []string) {
+		require.NoError(t, tx.ForEach(table, nil, func(k, v []byte) error {
+			keys, values = append(keys, string(k)), append(values, string(v))
+			return nil
+		}))
+		return keys, values
+	}
+
+	require.NoError(t, tx.DeleteRange(table, []byte("key2"), []byte("key4")))
+	keys, values := left(table)
+	require.Equal(t, []string{"key1", "key1", "key4"}, keys)
+	require.Equal(t, []string{"value1.1", "value1.3", "value4.1"}, values)
+
+	require.NoError(t, tx.DeleteRange(table, nil, []byte("key2")))
+	keys, _ = left(table)
+	require.Equal(t, []string{"key4"}, keys)
+
+	require.NoError(t, tx.DeleteRange(table, []byte("key4"), nil))
+	keys, _ = left(table)
+	require.Empty(t, keys)
+
+	// not DupSort table
+	for i := byte(0); i < 10; i++ {
+		require.NoError(t, tx.Put(kv.Sequence, []byte{i}, []byte{i}))
+	}
+	require.NoError(t, tx.DeleteRange(kv.Sequence, []byte{3}, []byte{7}))
+	keys, _ = left(kv.Sequence)
+	require.Equal(t, []string{"\x00", "\x01", "\x02", "\x07", "\x08", "\x09"}, keys)
+}
+
+func TestDeleteRangeAutoConversion(t *testing.T) {
+	db, tx, c := baseAutoConversion(t)
+	defer db.Close()
+	defer tx.Rollback()
+	defer c.Close()
+
+	require.NoError(t, tx.DeleteRange(kv.PlainState, []byte("A..........................._______________________________A"), []byte("C")))
+	var left []string
+	require.NoError(t, tx.ForEach(kv.PlainState, nil, func(k, v []byte) error {
+		left = append(left, string(k))
+		return nil
+	}))
+	require.Equal(t, []string{"A", "C", "D..........................._______________________________A", "D..........................._______________________________C"}, left)
+}
+
 func baseAutoConversion(t *testing.T) (kv.RwDB, kv.RwTx, kv.RwCursor) {
 	t.Helper()
 	path := t.TempDir()
@@ -699,3 +844,486 @@ func TestAutoConversionSeekBothRange(t *testing.T) {
 	require.NoError(t, err)
 	assert.Nil(t, v)
 }
+
+func TestCompositeKey(t *testing.T) {
+	logger := log.New()
+	db := NewMDBX(logger).InMem(t.TempDir()).MustOpen()
+	t.Cleanup(db.Close)
+	tx, err := db.BeginRw(context.Background())
+	require.NoError(t, err)
+	defer tx.Rollback()
+
+	addr, inc, loc := make([]byte, 20), make([]byte, 8), make([]byte, 32)
+	addr[0], inc[7], loc[31] = 1, 1, 2
+	require.NoError(t, kv.PutComposite(tx, kv.PlainState, []byte{3}, addr, inc, loc))
+	v, err := kv.GetComposite(tx, kv.PlainState, addr, inc, loc)
+	require.NoError(t, err)
+	require.Equal(t, []byte{3}, v)
+	require.Error(t, kv.PutComposite(tx, kv.PlainState, []byte{3}, addr, inc))
+
+	// stored as DupSort key/value
+	c, err := tx.CursorDupSort(kv.PlainState)
+	require.NoError(t, err)
+	defer c.Close()
+	dupV, err := c.SeekBothRange(kv.CompositeKey(addr, inc), loc)
+	require.NoError(t, err)
+	key, subKey := kv.SplitCompositeKey(kv.PlainState, kv.CompositeKey(addr, inc, loc))
+	dupK, expectV := kv.ChaindataTablesCfg[kv.PlainState].ToDupSort(append(key, subKey...), []byte{3})
+	require.Equal(t, expectV, dupV)
+	require.Equal(t, 28, len(dupK))
+
+	k, v := kv.ChaindataTablesCfg[kv.PlainState].FromDupSort(dupK, dupV)
+	require.Equal(t, kv.CompositeKey(addr, inc, loc), k)
+	require.Equal(t, []byte{3}, v)
+}
+
+func TestTemporaryBucket(t *testing.T) {
+	db, tx, _ := BaseCase(t)
+	ctx := context.Background()
+
+	name, err := tx.CreateTemporaryBucket("etl")
+	require.NoError(t, err)
+	name2, err := tx.CreateTemporaryBucket("etl")
+	require.NoError(t, err)
+	require.NotEqual(t, name, name2)
+
+	require.NoError(t, tx.Put(name, []byte("k1"), []byte("v1")))
+	require.NoError(t, tx.Append(name, []byte("k2"), []byte("v2")))
+	v, err := tx.GetOne(name, []byte("k2"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("v2"), v)
+	exists, err := tx.ExistsBucket(name)
+	require.NoError(t, err)
+	require.True(t, exists)
+	_, ok := db.AllBuckets()[name]
+	require.False(t, ok) // not visible in db-wide config
+	require.NoError(t, tx.Commit())
+
+	// dropped on commit, but other changes of tx are committed
+	require.NoError(t, db.View(ctx, func(tx kv.Tx) error {
+		buckets, err := tx.(kv.BucketMigrator).ListBuckets()
+		require.NoError(t, err)
+		require.NotContains(t, buckets, name)
+		require.NotContains(t, buckets, name2)
+		v, err := tx.GetOne("Table", []byte("key1"))
+		require.NoError(t, err)
+		require.Equal(t, []byte("value1.1"), v)
+		return nil
+	}))
+
+	// disappears on rollback
+	rwTx, err := db.BeginRw(ctx)
+	require.NoError(t, err)
+	name, err = rwTx.CreateTemporaryBucket("unwind")
+	require.NoError(t, err)
+	require.NoError(t, rwTx.Put(name, []byte("k1"), []byte("v1")))
+	rwTx.Rollback()
+	require.NoError(t, db.View(ctx, func(tx kv.Tx) error {
+		buckets, err := tx.(kv.BucketMigrator).ListBuckets()
+		require.NoError(t, err)
+		require.NotContains(t, buckets, name)
+		return nil
+	}))
+}
+
+func TestSavepoint(t *testing.T) {
+	db, tx, _ := BaseCase(t)
+	ctx := context.Background()
+	get := func(tx kv.Getter, k string) string {
+		v, err := tx.GetOne("Table", []byte(k))
+		require.NoError(t, err)
+		return string(v)
+	}
+
+	sp, err := tx.Savepoint()
+	require.NoError(t, err)
+	require.NoError(t, tx.Put("Table", []byte("key2"), []byte("value2.1")))
+	require.NoError(t, tx.Delete("Table", []byte("key1")))
+	name, err := tx.CreateTemporaryBucket("unwind")
+	require.NoError(t, err)
+
+	sp2, err := tx.Savepoint()
+	require.NoError(t, err)
+	require.NoError(t, tx.Put("Table", []byte("key4"), []byte("value4.1")))
+	require.Equal(t, "value4.1", get(tx, "key4"))
+
+	// rollback to outer savepoint ends inner one too
+	require.NoError(t, tx.RollbackTo(sp))
+	require.ErrorIs(t, tx.RollbackTo(sp2), kv.ErrSavepointNotFound)
+	require.Equal(t, "value1.1", get(tx, "key1"))
+	require.Equal(t, "", get(tx, "key2"))
+	require.Equal(t, "", get(tx, "key4"))
+	exists, err := tx.ExistsBucket(name)
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	// released savepoint keeps writes
+	sp, err = tx.Savepoint()
+	require.NoError(t, err)
+	require.NoError(t, tx.Put("Table", []byte("key5"), []byte("value5.1")))
+	require.NoError(t, tx.ReleaseSavepoint(sp))
+	require.Equal(t, "value5.1", get(tx, "key5"))
+
+	// active savepoint is committed with tx
+	_, err = tx.Savepoint()
+	require.NoError(t, err)
+	require.NoError(t, tx.Put("Table", []byte("key6"), []byte("value6.1")))
+	c, err := tx.Cursor("Table")
+	require.NoError(t, err)
+	k, _, err := c.First()
+	require.NoError(t, err)
+	require.Equal(t, "key1", string(k))
+	require.NoError(t, tx.Commit())
+
+	require.NoError(t, db.View(ctx, func(tx kv.Tx) error {
+		require.Equal(t, "value1.1", get(tx, "key1"))
+		require.Equal(t, "value5.1", get(tx, "key5"))
+		require.Equal(t, "value6.1", get(tx, "key6"))
+		return nil
+	}))
+
+	// rollback of tx with active savepoints
+	rwTx, err := db.BeginRw(ctx)
+	require.NoError(t, err)
+	_, err = rwTx.Savepoint()
+	require.NoError(t, err)
+	require.NoError(t, rwTx.Put("Table", []byte("key7"), []byte("value7.1")))
+	rwTx.Rollback()
+	require.NoError(t, db.View(ctx, func(tx kv.Tx) error {
+		require.Equal(t, "", get(tx, "key7"))
+		return nil
+	}))
+}
+
+func TestHealthCheck(t *testing.T) {
+	db, tx, _ := BaseCase(t)
+	require.NoError(t, tx.Commit())
+	ctx := context.Background()
+
+	st, err := db.HealthCheck(ctx)
+	if errors.Is(err, kv.ErrLowFreeSpace) { // small disk of CI - other checks are done anyway
+		require.Less(t, st.FreeSpace, uint64(kv.HealthMinFreeSpace))
+		require.NotEmpty(t, st.Error)
+	} else {
+		require.NoError(t, err)
+		require.Empty(t, st.Error)
+		require.GreaterOrEqual(t, st.FreeSpace, uint64(kv.HealthMinFreeSpace))
+	}
+	require.Greater(t, st.ReadLatency, time.Duration(0))
+	require.Greater(t, st.WriteLatency, time.Duration(0))
+
+	// temporary table of write check doesn't stay in DB
+	require.NoError(t, db.View(ctx, func(tx kv.Tx) error {
+		buckets, err := tx.(kv.BucketMigrator).ListBuckets()
+		require.NoError(t, err)
+		for _, name := range buckets {
+			require.NotContains(t, name, "health")
+		}
+		return nil
+	}))
+}
+
+func TestReplication(t *testing.T) {
+	ctx := context.Background()
+	logger := log.New()
+	tables := func(defaultBuckets kv.TableCfg) kv.TableCfg {
+		return kv.TableCfg{
+			"Plain":     kv.TableCfgItem{},
+			"Dup":       kv.TableCfgItem{Flags: kv.DupSort},
+			kv.Sequence: kv.TableCfgItem{},
+		}
+	}
+	stream := kv.NewReplicationStream()
+	db := NewMDBX(logger).InMem(t.TempDir()).WithTableCfg(tables).Replication(stream).MustOpen()
+	t.Cleanup(db.Close)
+	replica := NewMDBX(logger).InMem(t.TempDir()).WithTableCfg(tables).MustOpen()
+	t.Cleanup(replica.Close)
+
+	consumer := stream.Subscribe(16)
+	slow := stream.Subscribe(1)
+
+	require.NoError(t, db.Update(ctx, func(tx kv.RwTx) error {
+		require.NoError(t, tx.Put("Plain", []byte("a"), []byte("1")))
+		require.NoError(t, tx.Put("Plain", []byte("b"), []byte("2")))
+		require.NoError(t, tx.PutV("Plain", []byte("c"), []byte("3"), []byte("4")))
+		_, err := tx.PutReserve("Plain", []byte("d"), 1)
+		require.Error(t, err)
+		require.NoError(t, tx.Put("Dup", []byte("k"), []byte("v1")))
+		require.NoError(t, tx.Put("Dup", []byte("k"), []byte("v2")))
+		require.NoError(t, tx.Put("Dup", []byte("k"), []byte("v3")))
+		_, err = tx.IncrementSequence("Plain", 5)
+		require.NoError(t, err)
+		tmp, err := tx.CreateTemporaryBucket("tmp")
+		require.NoError(t, err)
+		return tx.Put(tmp, []byte("x"), []byte("y"))
+	}))
+	require.NoError(t, db.View(ctx, func(tx kv.Tx) error { return nil })) // read-only txs are not published
+	require.NoError(t, db.Update(ctx, func(tx kv.RwTx) error {
+		require.NoError(t, tx.Delete("Plain", []byte("a")))
+		c, err := tx.RwCursorDupSort("Dup")
+		require.NoError(t, err)
+		defer c.Close()
+		require.NoError(t, c.DeleteExact([]byte("k"), []byte("v1")))
+		_, _, err = c.SeekBothExact([]byte("k"), []byte("v2"))
+		require.NoError(t, err)
+		return c.DeleteCurrent()
+	}))
+	require.EqualValues(t, 2, stream.Seq())
+
+	for seq := uint64(1); seq <= 2; seq++ {
+		txn, err := consumer.Recv(ctx)
+		require.NoError(t, err)
+		require.Equal(t, seq, txn.Seq)
+		require.NoError(t, replica.Update(ctx, func(tx kv.RwTx) error { return kv.ApplyReplicationTx(tx, txn) }))
+	}
+
+	dump := func(db kv.RoDB) (res []string) {
+		require.NoError(t, db.View(ctx, func(tx kv.Tx) error {
+			for _, table := range []string{"Plain", "Dup", kv.Sequence} {
+				if err := tx.ForEach(table, nil, func(k, v []byte) error {
+					res = append(res, table+":"+string(k)+"="+string(v))
+					return nil
+				}); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+		return res
+	}
+	require.Equal(t, []string{"Plain:b=2", "Plain:c=34", "Dup:k=v3"}, dump(replica)[:3])
+	require.Equal(t, dump(db), dump(replica))
+
+	// slow consumer was dropped on 2-nd write-set, but still receives buffered one
+	txn, err := slow.Recv(ctx)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, txn.Seq)
+	_, err = slow.Recv(ctx)
+	require.ErrorIs(t, err, kv.ErrReplicationLag)
+
+	consumer.Close()
+	_, err = consumer.Recv(ctx)
+	require.ErrorIs(t, err, kv.ErrReplicationClosed)
+}
+
+func TestEncryption(t *testing.T) {
+	ctx := context.Background()
+	path := t.TempDir()
+	tables := func(defaultBuckets kv.TableCfg) kv.TableCfg {
+		return kv.TableCfg{
+			"Plain":     kv.TableCfgItem{},
+			"Dup":       kv.TableCfgItem{Flags: kv.DupSort},
+			kv.Sequence: kv.TableCfgItem{},
+		}
+	}
+	key1, key2 := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32)
+	open := func(e *Encryption) kv.RwDB {
+		return NewMDBX(log.New()).Path(path).WithTableCfg(tables).Encryption(e).MustOpen()
+	}
+	openErr := func(path string, e *Encryption) error {
+		db, err := NewMDBX(log.New()).Path(path).WithTableCfg(tables).Encryption(e).Open()
+		if err == nil {
+			db.Close()
+		}
+		return err
+	}
+	read := func(db kv.RoDB) (res []string, err error) {
+		err = db.View(ctx, func(tx kv.Tx) error {
+			for _, table := range []string{"Plain", "Dup"} {
+				if err := tx.ForEach(table, nil, func(k, v []byte) error {
+					res = append(res, fmt.Sprintf("%s:%x=%s", table, k, v))
+					return nil
+				}); err != nil {
+					return err
+				}
+			}
+			seq, err := tx.ReadSequence("Plain")
+			res = append(res, fmt.Sprintf("seq=%d", seq))
+			return err
+		})
+		return res, err
+	}
+	expected := []string{"Plain:01=secret", "Plain:02=secret value", "Dup:6b=v1", "Dup:6b=v2", "seq=7"}
+
+	enc, err := NewEncryption(1, key1)
+	require.NoError(t, err)
+	db := open(enc)
+	require.NoError(t, db.Update(ctx, func(tx kv.RwTx) error {
+		require.NoError(t, tx.Put("Plain", []byte{1}, []byte("secret")))
+		require.NoError(t, tx.PutV("Plain", []byte{2}, []byte("secret"), []byte(" value")))
+		_, err := tx.PutReserve("Plain", []byte{3}, 1)
+		require.Error(t, err)
+		require.NoError(t, tx.Put("Dup", []byte("k"), []byte("v1")))
+		require.NoError(t, tx.Put("Dup", []byte("k"), []byte("v2")))
+		_, err = tx.IncrementSequence("Plain", 7)
+		return err
+	}))
+	res, err := read(db)
+	require.NoError(t, err)
+	require.Equal(t, expected, res)
+	db.Close()
+
+	// values are not stored in plain text
+	env, err := mdbx.NewEnv()
+	require.NoError(t, err)
+	require.NoError(t, env.SetOption(mdbx.OptMaxDB, 200))
+	require.NoError(t, env.Open(path, mdbx.Readonly|mdbx.Accede, 0664))
+	require.NoError(t, env.View(func(txn *mdbx.Txn) error {
+		for table, expect := range map[string]func(v []byte){
+			"Plain": func(v []byte) { require.NotContains(t, string(v), "secret") },
+			"Dup":   func(v []byte) { require.Equal(t, "v1", string(v)) },
+		} {
+			dbi, err := txn.OpenDBI(table, 0, nil, nil)
+			require.NoError(t, err)
+			c, err := txn.OpenCursor(dbi)
+			require.NoError(t, err)
+			_, v, err := c.Get(nil, nil, mdbx.First)
+			require.NoError(t, err)
+			expect(v)
+			c.Close()
+		}
+		return nil
+	}))
+	env.Close()
+
+	// encrypted db is not opened without encryption or by wrong key
+	require.ErrorIs(t, openErr(path, nil), ErrEncryptedDB)
+	wrong, err := NewEncryption(1, key2)
+	require.NoError(t, err)
+	require.ErrorIs(t, openErr(path, wrong), ErrDecrypt)
+	// and encryption is not enabled for db with plain text values
+	plainPath := t.TempDir()
+	require.NoError(t, openErr(plainPath, nil))
+	plainDB := NewMDBX(log.New()).Path(plainPath).WithTableCfg(tables).MustOpen()
+	require.NoError(t, plainDB.Update(ctx, func(tx kv.RwTx) error { return tx.Put("Plain", []byte{1}, []byte("secret")) }))
+	plainDB.Close()
+	require.ErrorIs(t, openErr(plainPath, enc), ErrNotEncryptedDB)
+
+	// rotation: old values are readable by old key, re-encryption allows to remove it
+	enc, err = NewEncryption(1, key1)
+	require.NoError(t, err)
+	require.NoError(t, enc.Rotate(2, key2))
+	require.ErrorIs(t, enc.RemoveKey(2), ErrActiveKeyUsed)
+	db = open(enc)
+	defer db.Close()
+	res, err = read(db)
+	require.NoError(t, err)
+	require.Equal(t, expected, res)
+	require.NoError(t, db.Update(ctx, func(tx kv.RwTx) error {
+		n, err := tx.(*MdbxTx).ReencryptTable("Plain")
+		require.EqualValues(t, 2, n)
+		require.NoError(t, err)
+		n, err = tx.(*MdbxTx).ReencryptTable(kv.Sequence)
+		require.EqualValues(t, 1, n)
+		return err
+	}))
+	require.NoError(t, enc.RemoveKey(1))
+	res, err = read(db)
+	require.NoError(t, err)
+	require.Equal(t, expected, res)
+}
+
+func TestBeginRoAt(t *testing.T) {
+	db, tx, _ := BaseCase(t)
+	ctx := context.Background()
+	require.NoError(t, tx.Commit())
+
+	viewID, unpin, err := kv.PinView(ctx, db, 2)
+	require.NoError(t, err)
+	defer unpin()
+
+	// view is not latest anymore
+	require.NoError(t, db.Update(ctx, func(tx kv.RwTx) error {
+		return tx.Put("Table", []byte("key9"), []byte("value9.1"))
+	}))
+
+	// several goroutines, each with own Tx on same view
+	errs := make(chan error, 8)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tx, err := kv.BeginRoAt(ctx, db, viewID)
+			if errors.Is(err, kv.ErrViewGone) { // both pinned Tx are in use
+				return
+			}
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer tx.Rollback()
+			if tx.ViewID() != viewID {
+				errs <- fmt.Errorf("unexpected view: %d", tx.ViewID())
+				return
+			}
+			v, err := tx.GetOne("Table", []byte("key9"))
+			if err != nil {
+				errs <- err
+				return
+			}
+			if v != nil {
+				errs <- fmt.Errorf("unexpected value: %s", v)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	// Tx returned to pin on Rollback
+	for i := 0; i < 3; i++ {
+		require.NoError(t, kv.ViewAt(ctx, db, viewID, func(tx kv.Tx) error {
+			v, err := tx.GetOne("Table", []byte("key9"))
+			require.NoError(t, err)
+			require.Nil(t, v)
+			return nil
+		}))
+	}
+
+	unpin()
+	_, err = kv.BeginRoAt(ctx, db, viewID)
+	require.ErrorIs(t, err, kv.ErrViewGone)
+
+	// not pinned latest view
+	latest, err := db.BeginRo(ctx)
+	require.NoError(t, err)
+	defer latest.Rollback()
+	require.NoError(t, kv.ViewAt(ctx, db, latest.ViewID(), func(tx kv.Tx) error {
+		v, err := tx.GetOne("Table", []byte("key9"))
+		require.NoError(t, err)
+		require.Equal(t, []byte("value9.1"), v)
+		return nil
+	}))
+}
+
+func TestRestrictTx(t *testing.T) {
+	db, tx, _ := BaseCase(t)
+	ctx := context.Background()
+	require.NoError(t, tx.Commit())
+
+	access := kv.TableAccess{Read: []string{kv.Sequence}, Write: []string{"Table"}}
+	require.NoError(t, kv.UpdateRestricted(ctx, db, access, func(tx kv.RwTx) error {
+		require.NoError(t, tx.Put("Table", []byte("key5"), []byte("value5.1")))
+		_, err := tx.ReadSequence(kv.Sequence)
+		require.NoError(t, err)
+
+		require.ErrorIs(t, tx.Put(kv.Sequence, []byte("k"), []byte("v")), kv.ErrTableNotAllowed)
+		_, err = tx.RwCursor(kv.Sequence)
+		require.ErrorIs(t, err, kv.ErrTableNotAllowed)
+		require.ErrorIs(t, tx.ClearBucket(kv.Sequence), kv.ErrTableNotAllowed)
+		_, err = tx.GetOne(kv.PlainState, []byte("k"))
+		require.ErrorIs(t, err, kv.ErrTableNotAllowed)
+		return nil
+	}))
+
+	tx, err := kv.BeginRwRestricted(ctx, db, kv.TableAccess{})
+	require.NoError(t, err)
+	defer tx.Rollback()
+	v, err := tx.GetOne("Table", []byte("key5")) // nil Read - all tables are readable
+	require.NoError(t, err)
+	require.Equal(t, []byte("value5.1"), v)
+	require.ErrorIs(t, tx.Delete("Table", []byte("key5")), kv.ErrTableNotAllowed)
+}
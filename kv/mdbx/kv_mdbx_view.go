@@ -0,0 +1,142 @@
+/*
+   Copyright 2023 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package mdbx
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/torquem-ch/mdbx-go/mdbx"
+
+	"github.com/ledgerwatch/erigon-lib/kv"
+)
+
+var _ kv.ViewPinner = (*MdbxKV)(nil)
+
+// pinnedView - read-only txns on one snapshot, opened in advance by PinView: MDBX can't begin txn on older snapshot.
+// Txns are not bound to OS thread (mdbx-go always opens env with NoTLS), so can be handed to any goroutine.
+// Each txn - parked or handed out by BeginRoAt - holds own slot of roTxsLimiter and db.wg.
+type pinnedView struct {
+	pins   int         // PinView calls not unpinned yet
+	parked []*mdbx.Txn // ready for BeginRoAt
+}
+
+// PinView - opens `n` read-only txns on latest snapshot. `n` must be less than limit of concurrent read txns
+// (see RoTxsLimiter), otherwise it waits for other readers to finish or for `ctx` to be done.
+func (db *MdbxKV) PinView(ctx context.Context, n int) (viewID uint64, unpin func(), err error) {
+	if n <= 0 {
+		return 0, nil, fmt.Errorf("PinView: n=%d", n)
+	}
+	txs := make([]*MdbxTx, 0, n)
+	defer func() {
+		for _, tx := range txs {
+			tx.Rollback()
+		}
+	}()
+	for len(txs) < n {
+		tx, err := db.BeginRo(ctx)
+		if err != nil {
+			return 0, nil, err
+		}
+		if len(txs) > 0 && tx.ViewID() != txs[0].ViewID() { // commit between opens - start over on new snapshot
+			for _, t := range txs {
+				t.Rollback()
+			}
+			txs = txs[:0]
+		}
+		txs = append(txs, tx.(*MdbxTx))
+	}
+
+	viewID = txs[0].ViewID()
+	db.viewsLock.Lock()
+	if db.views == nil {
+		db.views = map[uint64]*pinnedView{}
+	}
+	v, ok := db.views[viewID]
+	if !ok {
+		v = &pinnedView{}
+		db.views[viewID] = v
+	}
+	v.pins++
+	for _, tx := range txs {
+		v.parked = append(v.parked, tx.tx)
+		tx.tx = nil // ownership of txn, limiter slot and wg moved to view
+	}
+	db.viewsLock.Unlock()
+	txs = nil
+
+	var once sync.Once
+	return viewID, func() { once.Do(func() { db.unpinView(viewID) }) }, nil
+}
+
+func (db *MdbxKV) unpinView(viewID uint64) {
+	var parked []*mdbx.Txn
+	db.viewsLock.Lock()
+	if v := db.views[viewID]; v != nil {
+		v.pins--
+		if v.pins == 0 {
+			delete(db.views, viewID)
+			parked = v.parked
+		}
+	}
+	db.viewsLock.Unlock()
+	for _, txn := range parked {
+		txn.Abort()
+		db.wg.Done()
+		db.roTxsLimiter.Release(1)
+	}
+}
+
+// parkTxn - returns txn of pinned view back to view. false - view is unpinned, txn must be aborted
+func (db *MdbxKV) parkTxn(txn *mdbx.Txn) bool {
+	db.viewsLock.Lock()
+	defer db.viewsLock.Unlock()
+	v, ok := db.views[txn.ID()]
+	if !ok {
+		return false
+	}
+	v.parked = append(v.parked, txn)
+	return true
+}
+
+// BeginRoAt - hands out txn parked by PinView. If there are no such txns (view is not pinned, or all its txns are
+// in use) - opens new txn, which is fine while `viewID` is latest commit.
+func (db *MdbxKV) BeginRoAt(ctx context.Context, viewID uint64) (kv.Tx, error) {
+	if db.closed.Load() {
+		return nil, fmt.Errorf("db closed")
+	}
+	db.viewsLock.Lock()
+	if v, ok := db.views[viewID]; ok && len(v.parked) > 0 {
+		txn := v.parked[len(v.parked)-1]
+		v.parked = v.parked[:len(v.parked)-1]
+		db.viewsLock.Unlock()
+		return &MdbxTx{ctx: ctx, db: db, tx: txn, readOnly: true, pinned: true}, nil
+	}
+	db.viewsLock.Unlock()
+
+	tx, err := db.BeginRo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if tx.ViewID() != viewID {
+		latest := tx.ViewID()
+		tx.Rollback()
+		return nil, fmt.Errorf("%w: %d, latest: %d", kv.ErrViewGone, viewID, latest)
+	}
+	return tx, nil
+}
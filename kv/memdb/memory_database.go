@@ -82,7 +82,7 @@ func NewTestDownloaderDB(tb testing.TB) kv.RwDB {
 
 func NewTestSentrylDB(tb testing.TB) kv.RwDB {
 	tb.Helper()
-	db := NewPoolDB()
+	db := NewSentryDB()
 	tb.Cleanup(db.Close)
 	return db
 }
@@ -16,10 +16,12 @@ package memdb
 import (
 	"bytes"
 	"context"
+	"fmt"
 
 	"github.com/ledgerwatch/erigon-lib/kv/iter"
 	"github.com/ledgerwatch/log/v3"
 
+	"github.com/ledgerwatch/erigon-lib/common"
 	"github.com/ledgerwatch/erigon-lib/kv"
 	"github.com/ledgerwatch/erigon-lib/kv/mdbx"
 )
@@ -31,6 +33,15 @@ type MemoryMutation struct {
 	clearedTables    map[string]struct{}
 	db               kv.Tx
 	statelessCursors map[string]kv.RwCursor
+	tmpBuckets       map[string]struct{} // exist only in memTx, see CreateTemporaryBucket
+	savepoints       []memorySavepoint   // see Savepoint
+}
+
+// memorySavepoint - state of overlay which is not stored in memTx
+type memorySavepoint struct {
+	deletedEntries map[string]map[string]struct{}
+	clearedTables  map[string]struct{}
+	tmpBuckets     map[string]struct{}
 }
 
 // NewMemoryBatch - starts in-mem batch
@@ -176,11 +187,22 @@ func (m *MemoryMutation) Put(table string, k, v []byte) error {
 	return m.memTx.Put(table, k, v)
 }
 
+func (m *MemoryMutation) PutReserve(table string, k []byte, size int) ([]byte, error) {
+	return m.memTx.PutReserve(table, k, size)
+}
+
+func (m *MemoryMutation) PutV(table string, k []byte, parts ...[]byte) error {
+	return m.memTx.PutV(table, k, parts...)
+}
+
 func (m *MemoryMutation) Append(table string, key []byte, value []byte) error {
 	return m.memTx.Append(table, key, value)
 }
 
 func (m *MemoryMutation) AppendDup(table string, key []byte, value []byte) error {
+	if m.isTemporary(table) {
+		return m.memTx.AppendDup(table, key, value)
+	}
 	c, err := m.statelessCursor(table)
 	if err != nil {
 		return err
@@ -232,6 +254,14 @@ func (m *MemoryMutation) RangeDescend(table string, fromPrefix, toPrefix []byte,
 	panic("please implement me")
 }
 
+func (m *MemoryMutation) ForEachCtx(ctx context.Context, bucket string, fromPrefix []byte, walker func(k, v []byte) error) error {
+	return m.ForEach(bucket, fromPrefix, kv.WalkerWithCtx(ctx, walker))
+}
+
+func (m *MemoryMutation) ForPrefixCtx(ctx context.Context, bucket string, prefix []byte, walker func(k, v []byte) error) error {
+	return m.ForPrefix(bucket, prefix, kv.WalkerWithCtx(ctx, walker))
+}
+
 func (m *MemoryMutation) ForPrefix(bucket string, prefix []byte, walker func(k, v []byte) error) error {
 	c, err := m.Cursor(bucket)
 	if err != nil {
@@ -254,6 +284,9 @@ func (m *MemoryMutation) ForPrefix(bucket string, prefix []byte, walker func(k,
 }
 
 func (m *MemoryMutation) Delete(table string, k []byte) error {
+	if m.isTemporary(table) {
+		return m.memTx.Delete(table, k)
+	}
 	if _, ok := m.deletedEntries[table]; !ok {
 		m.deletedEntries[table] = make(map[string]struct{})
 	}
@@ -261,8 +294,41 @@ func (m *MemoryMutation) Delete(table string, k []byte) error {
 	return m.memTx.Delete(table, k)
 }
 
+// DeleteRange - keys are collected first: cursor of MemoryMutation merges db and memory, it is not stable under Delete
+func (m *MemoryMutation) DeleteRange(table string, from, to []byte) error {
+	c, err := m.Cursor(table)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	var keys [][]byte
+	for k, _, err := c.Seek(from); k != nil; k, _, err = c.Next() {
+		if err != nil {
+			return err
+		}
+		if to != nil && bytes.Compare(k, to) >= 0 {
+			break
+		}
+		if len(keys) == 0 || !bytes.Equal(keys[len(keys)-1], k) {
+			keys = append(keys, common.Copy(k))
+		}
+	}
+	for _, k := range keys {
+		if err := m.Delete(table, k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (m *MemoryMutation) Commit() error {
 	m.statelessCursors = nil
+	for name := range m.tmpBuckets {
+		if err := m.memTx.ClearBucket(name); err != nil {
+			return err
+		}
+	}
+	m.tmpBuckets = nil
 	return nil
 }
 
@@ -293,6 +359,9 @@ func (m *MemoryMutation) ListBuckets() ([]string, error) {
 }
 
 func (m *MemoryMutation) ClearBucket(bucket string) error {
+	if m.isTemporary(bucket) {
+		return m.memTx.ClearBucket(bucket)
+	}
 	m.clearedTables[bucket] = struct{}{}
 	return m.memTx.ClearBucket(bucket)
 }
@@ -304,6 +373,77 @@ func (m *MemoryMutation) CreateBucket(bucket string) error {
 	return m.memTx.CreateBucket(bucket)
 }
 
+// CreateTemporaryBucket - table lives only in in-memory part: Flush doesn't write it to `tx`
+func (m *MemoryMutation) CreateTemporaryBucket(prefix string) (string, error) {
+	name, err := m.memTx.CreateTemporaryBucket(prefix)
+	if err != nil {
+		return "", err
+	}
+	if m.tmpBuckets == nil {
+		m.tmpBuckets = map[string]struct{}{}
+	}
+	m.tmpBuckets[name] = struct{}{}
+	return name, nil
+}
+
+// Savepoint - savepoint of memTx and copy of deleted entries/tables, which are not stored in memTx
+func (m *MemoryMutation) Savepoint() (kv.Savepoint, error) {
+	if _, err := m.memTx.Savepoint(); err != nil {
+		return 0, err
+	}
+	m.statelessCursors = nil
+	sp := memorySavepoint{
+		deletedEntries: make(map[string]map[string]struct{}, len(m.deletedEntries)),
+		clearedTables:  make(map[string]struct{}, len(m.clearedTables)),
+		tmpBuckets:     make(map[string]struct{}, len(m.tmpBuckets)),
+	}
+	for table, keys := range m.deletedEntries {
+		sp.deletedEntries[table] = make(map[string]struct{}, len(keys))
+		for k := range keys {
+			sp.deletedEntries[table][k] = struct{}{}
+		}
+	}
+	for table := range m.clearedTables {
+		sp.clearedTables[table] = struct{}{}
+	}
+	for table := range m.tmpBuckets {
+		sp.tmpBuckets[table] = struct{}{}
+	}
+	m.savepoints = append(m.savepoints, sp)
+	return kv.Savepoint(len(m.savepoints)), nil
+}
+
+func (m *MemoryMutation) RollbackTo(sp kv.Savepoint) error {
+	if sp < 1 || int(sp) > len(m.savepoints) {
+		return fmt.Errorf("rollback to %d: %w", sp, kv.ErrSavepointNotFound)
+	}
+	if err := m.memTx.RollbackTo(sp); err != nil {
+		return err
+	}
+	m.statelessCursors = nil
+	saved := m.savepoints[sp-1]
+	m.deletedEntries, m.clearedTables, m.tmpBuckets = saved.deletedEntries, saved.clearedTables, saved.tmpBuckets
+	m.savepoints = m.savepoints[:sp-1]
+	return nil
+}
+
+func (m *MemoryMutation) ReleaseSavepoint(sp kv.Savepoint) error {
+	if sp < 1 || int(sp) > len(m.savepoints) {
+		return fmt.Errorf("release savepoint %d: %w", sp, kv.ErrSavepointNotFound)
+	}
+	if err := m.memTx.ReleaseSavepoint(sp); err != nil {
+		return err
+	}
+	m.statelessCursors = nil
+	m.savepoints = m.savepoints[:sp-1]
+	return nil
+}
+
+func (m *MemoryMutation) isTemporary(table string) bool {
+	_, ok := m.tmpBuckets[table]
+	return ok
+}
+
 func (m *MemoryMutation) Flush(tx kv.RwTx) error {
 	// Obtain buckets touched.
 	buckets, err := m.memTx.ListBuckets()
@@ -326,6 +466,9 @@ func (m *MemoryMutation) Flush(tx kv.RwTx) error {
 	}
 	// Iterate over each bucket and apply changes accordingly.
 	for _, bucket := range buckets {
+		if m.isTemporary(bucket) {
+			continue
+		}
 		if isTablePurelyDupsort(bucket) {
 			cbucket, err := m.memTx.CursorDupSort(bucket)
 			if err != nil {
@@ -376,6 +519,9 @@ func isTablePurelyDupsort(bucket string) bool {
 
 // Cursor creates a new cursor (the real fun begins here)
 func (m *MemoryMutation) makeCursor(bucket string) (kv.RwCursorDupSort, error) {
+	if m.isTemporary(bucket) {
+		return m.memTx.RwCursorDupSort(bucket)
+	}
 	c := &memoryMutationCursor{}
 	// We can filter duplicates in dup sorted table
 	c.table = bucket
@@ -284,6 +284,10 @@ func (m *memoryMutationCursor) Seek(seek []byte) ([]byte, []byte, error) {
 }
 
 // Seek move pointer to a key at a certain position.
+func (m *memoryMutationCursor) SeekMulti(keys [][]byte) ([][]byte, error) {
+	return kv.SeekMultiExact(m, keys)
+}
+
 func (m *memoryMutationCursor) SeekExact(seek []byte) ([]byte, []byte, error) {
 	memKey, memValue, err := m.memCursor.SeekExact(seek)
 	if err != nil || m.isTableCleared() {
@@ -464,6 +468,10 @@ func (m *memoryMutationCursor) Close() {
 	}
 }
 
+func (m *memoryMutationCursor) SeekToRatio(f float64) ([]byte, []byte, error) {
+	return kv.SeekToRatioByKeys(m, f)
+}
+
 func (m *memoryMutationCursor) Count() (uint64, error) {
 	panic("Not implemented")
 }
@@ -369,6 +369,49 @@ func TestIncReadSequence(t *testing.T) {
 	require.Equal(t, val, uint64(12))
 }
 
+func TestNamespacedSequence(t *testing.T) {
+	_, rwTx := NewTestTx(t)
+
+	_, err := rwTx.IncrementSequence(kv.HashedAccounts, 5)
+	require.NoError(t, err)
+
+	batch := NewMemoryBatch(rwTx, "")
+	defer batch.Close()
+
+	id, err := kv.IncrementNamespacedSequence(batch, kv.HashedAccounts, []byte("a"), 3)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), id)
+	id, err = kv.IncrementNamespacedSequence(batch, kv.HashedAccounts, []byte("a"), 2)
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), id)
+	id, err = kv.IncrementNamespacedSequence(batch, kv.HashedAccounts, []byte("b"), 1)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), id)
+
+	next, err := kv.ReadNamespacedSequence(batch, kv.HashedAccounts, []byte("a"))
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), next)
+
+	// table-global sequence is independent
+	next, err = batch.ReadSequence(kv.HashedAccounts)
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), next)
+	_, err = batch.IncrementSequence(kv.HashedAccounts, 1)
+	require.NoError(t, err)
+	next, err = kv.ReadNamespacedSequence(batch, kv.HashedAccounts, []byte("b"))
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), next)
+
+	// not flushed allocations are not visible in parent tx
+	next, err = kv.ReadNamespacedSequence(rwTx, kv.HashedAccounts, []byte("a"))
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), next)
+	require.NoError(t, batch.Flush(rwTx))
+	next, err = kv.ReadNamespacedSequence(rwTx, kv.HashedAccounts, []byte("a"))
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), next)
+}
+
 func initializeDbDupSort(rwTx kv.RwTx) {
 	rwTx.Put(kv.AccountChangeSet, []byte("key1"), []byte("value1.1"))
 	rwTx.Put(kv.AccountChangeSet, []byte("key3"), []byte("value3.1"))
@@ -664,3 +707,61 @@ func TestAutoConversionSeekBothRange(t *testing.T) {
 	require.NoError(t, err)
 	assert.Nil(t, v)
 }
+
+func TestTemporaryBucket(t *testing.T) {
+	_, rwTx := NewTestTx(t)
+
+	initializeDbNonDupSort(rwTx)
+
+	batch := NewMemoryBatch(rwTx, "")
+	name, err := batch.CreateTemporaryBucket("etl")
+	require.NoError(t, err)
+	require.NoError(t, batch.Put(name, []byte("AAAA"), []byte("value")))
+	require.NoError(t, batch.Delete(name, []byte("AAAA")))
+	require.NoError(t, batch.Put(name, []byte("BAAA"), []byte("value1")))
+	val, err := batch.GetOne(name, []byte("BAAA"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("value1"), val)
+
+	require.NoError(t, batch.Flush(rwTx))
+	exist, err := rwTx.ExistsBucket(name)
+	require.NoError(t, err)
+	require.False(t, exist)
+}
+
+func TestSavepoint(t *testing.T) {
+	_, rwTx := NewTestTx(t)
+
+	initializeDbNonDupSort(rwTx)
+
+	batch := NewMemoryBatch(rwTx, "")
+	defer batch.Close()
+	require.NoError(t, batch.Put(kv.HashedAccounts, []byte("AAAA"), []byte("value5")))
+
+	sp, err := batch.Savepoint()
+	require.NoError(t, err)
+	require.NoError(t, batch.Put(kv.HashedAccounts, []byte("AAAA"), []byte("value6")))
+	require.NoError(t, batch.Delete(kv.HashedAccounts, []byte("CAAA")))
+	require.NoError(t, batch.ClearBucket(kv.HashedAccounts))
+
+	require.NoError(t, batch.RollbackTo(sp))
+	require.ErrorIs(t, batch.RollbackTo(sp), kv.ErrSavepointNotFound)
+	val, err := batch.GetOne(kv.HashedAccounts, []byte("AAAA"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("value5"), val)
+	val, err = batch.GetOne(kv.HashedAccounts, []byte("CAAA"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("value1"), val)
+
+	sp, err = batch.Savepoint()
+	require.NoError(t, err)
+	require.NoError(t, batch.Delete(kv.HashedAccounts, []byte("CAAA")))
+	require.NoError(t, batch.ReleaseSavepoint(sp))
+	require.NoError(t, batch.Flush(rwTx))
+	val, err = rwTx.GetOne(kv.HashedAccounts, []byte("CAAA"))
+	require.NoError(t, err)
+	require.Nil(t, val)
+	val, err = rwTx.GetOne(kv.HashedAccounts, []byte("AAAA"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("value5"), val)
+}
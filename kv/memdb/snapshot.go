@@ -0,0 +1,121 @@
+/*
+   Copyright 2023 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package memdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"github.com/ledgerwatch/erigon-lib/kv"
+)
+
+// Snapshot - content of all tables visible by `tx`, for tests which checkpoint DB state and branch from it by
+// Restore (without copying of directories). Deterministic: same content gives same bytes - can be compared.
+// Format: for each non-empty table in order of names: name, amount of pairs, pairs - all as uvarint-prefixed bytes.
+func Snapshot(tx kv.Tx) ([]byte, error) {
+	tables, err := listTables(tx)
+	if err != nil {
+		return nil, err
+	}
+	var res, pairs []byte
+	var numBuf [binary.MaxVarintLen64]byte
+	put := func(buf, b []byte) []byte {
+		n := binary.PutUvarint(numBuf[:], uint64(len(b)))
+		return append(append(buf, numBuf[:n]...), b...)
+	}
+	for _, table := range tables {
+		pairs = pairs[:0]
+		var count uint64
+		if err := tx.ForEach(table, nil, func(k, v []byte) error {
+			pairs = put(put(pairs, k), v)
+			count++
+			return nil
+		}); err != nil {
+			return nil, fmt.Errorf("snapshot %s: %w", table, err)
+		}
+		if count == 0 {
+			continue
+		}
+		res = put(res, []byte(table))
+		n := binary.PutUvarint(numBuf[:], count)
+		res = append(res, numBuf[:n]...)
+		res = append(res, pairs...)
+	}
+	return res, nil
+}
+
+// Restore - replaces content of all tables by `data` of Snapshot. Tables absent in `data` become empty.
+func Restore(tx kv.RwTx, data []byte) error {
+	tables, err := listTables(tx)
+	if err != nil {
+		return err
+	}
+	for _, table := range tables {
+		if err := tx.ClearBucket(table); err != nil {
+			return fmt.Errorf("restore %s: %w", table, err)
+		}
+	}
+	pos := 0
+	next := func() ([]byte, error) {
+		l, n := binary.Uvarint(data[pos:])
+		if n <= 0 || uint64(len(data)-pos-n) < l {
+			return nil, fmt.Errorf("restore: corrupted snapshot at %d", pos)
+		}
+		pos += n + int(l)
+		return data[pos-int(l) : pos], nil
+	}
+	for pos < len(data) {
+		table, err := next()
+		if err != nil {
+			return err
+		}
+		count, n := binary.Uvarint(data[pos:])
+		if n <= 0 {
+			return fmt.Errorf("restore %s: corrupted snapshot at %d", table, pos)
+		}
+		pos += n
+		for ; count > 0; count-- {
+			k, err := next()
+			if err != nil {
+				return err
+			}
+			v, err := next()
+			if err != nil {
+				return err
+			}
+			// pairs are sorted (dups of dupsort table too), Put of dupsort table adds dup
+			if err = tx.Put(string(table), k, v); err != nil {
+				return fmt.Errorf("restore %s: %w", table, err)
+			}
+		}
+	}
+	return nil
+}
+
+func listTables(tx kv.Tx) ([]string, error) {
+	bm, ok := tx.(kv.BucketMigrator)
+	if !ok {
+		return nil, fmt.Errorf("%T doesn't support listing of tables", tx)
+	}
+	tables, err := bm.ListBuckets()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(tables)
+	return tables, nil
+}
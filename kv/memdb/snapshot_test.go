@@ -0,0 +1,69 @@
+/*
+   Copyright 2023 Erigon contributors
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+       http://www.apache.org/licenses/LICENSE-2.0
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package memdb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ledgerwatch/erigon-lib/kv"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	_, rwTx := NewTestTx(t)
+	initializeDbNonDupSort(rwTx)
+	require.NoError(t, rwTx.Put(kv.AccountChangeSet, []byte("key1"), []byte("value1.1")))
+	require.NoError(t, rwTx.Put(kv.AccountChangeSet, []byte("key1"), []byte("value1.2")))
+	require.NoError(t, rwTx.Put(kv.AccountChangeSet, []byte("key3"), []byte("value3")))
+
+	snap, err := Snapshot(rwTx)
+	require.NoError(t, err)
+
+	// branch: change, delete and add records
+	require.NoError(t, rwTx.Put(kv.HashedAccounts, []byte("AAAA"), []byte("changed")))
+	require.NoError(t, rwTx.Delete(kv.HashedAccounts, []byte("CAAA")))
+	require.NoError(t, rwTx.Put(kv.AccountChangeSet, []byte("key1"), []byte("value1.3")))
+	require.NoError(t, rwTx.Put(kv.Code, []byte("code"), []byte("value")))
+	branch, err := Snapshot(rwTx)
+	require.NoError(t, err)
+	require.NotEqual(t, snap, branch)
+
+	// same content of another db gives same bytes
+	_, otherTx := NewTestTx(t)
+	require.NoError(t, Restore(otherTx, snap))
+	otherSnap, err := Snapshot(otherTx)
+	require.NoError(t, err)
+	require.Equal(t, snap, otherSnap)
+
+	require.NoError(t, Restore(rwTx, snap))
+	restored, err := Snapshot(rwTx)
+	require.NoError(t, err)
+	require.Equal(t, snap, restored)
+
+	v, err := rwTx.GetOne(kv.HashedAccounts, []byte("AAAA"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("value"), v)
+	has, err := rwTx.Has(kv.Code, []byte("code"))
+	require.NoError(t, err)
+	require.False(t, has)
+	var dups []string
+	require.NoError(t, rwTx.ForPrefix(kv.AccountChangeSet, []byte("key1"), func(k, v []byte) error {
+		dups = append(dups, string(v))
+		return nil
+	}))
+	require.Equal(t, []string{"value1.1", "value1.2"}, dups)
+
+	require.Error(t, Restore(rwTx, snap[:len(snap)-1]))
+}
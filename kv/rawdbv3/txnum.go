@@ -200,3 +200,54 @@ func SecondKey(tx kv.Tx, table string) ([]byte, error) {
 	}
 	return k, nil
 }
+
+// ResolveTs - timestamp (txNum) for DomainGet/HistoryGet of `kv.TemporalTx`: tag -> blockNum (by head provider) -> txNum.
+// Returns first txNum of next block: timestamp of state after execution of tag's block.
+func ResolveTs(tx kv.Tx, heads kv.HeadProvider, tag kv.BlockTag) (txNum uint64, err error) {
+	if heads == nil {
+		return 0, fmt.Errorf("resolve %s: head provider not registered: %w", tag, kv.ErrNotSupported)
+	}
+	blockNum, ok, err := heads.BlockNumByTag(tx, tag)
+	if err != nil {
+		return 0, fmt.Errorf("resolve %s: %w", tag, err)
+	}
+	if !ok {
+		return 0, fmt.Errorf("resolve %s: %w", tag, kv.ErrBlockTagNotFound)
+	}
+	return TxNums.Min(tx, blockNum+1)
+}
+
+// ForkchoiceHeads - HeadProvider which reads heads persisted in DB: latest from `kv.HeadBlockKey`,
+// safe and finalized from last Engine API forkchoice (`kv.LastForkchoice`)
+type ForkchoiceHeads struct{}
+
+func (ForkchoiceHeads) BlockNumByTag(tx kv.Tx, tag kv.BlockTag) (blockNum uint64, ok bool, err error) {
+	var table, key string
+	switch tag {
+	case kv.EarliestBlock:
+		return 0, true, nil
+	case kv.LatestBlock:
+		table, key = kv.HeadBlockKey, kv.HeadBlockKey
+	case kv.SafeBlock:
+		table, key = kv.LastForkchoice, "safeBlockHash"
+	case kv.FinalizedBlock:
+		table, key = kv.LastForkchoice, "finalizedBlockHash"
+	default:
+		return 0, false, fmt.Errorf("unknown block tag: %s", tag)
+	}
+	hash, err := tx.GetOne(table, []byte(key))
+	if err != nil {
+		return 0, false, err
+	}
+	if len(hash) == 0 {
+		return 0, false, nil
+	}
+	num, err := tx.GetOne(kv.HeaderNumber, hash)
+	if err != nil {
+		return 0, false, err
+	}
+	if len(num) != 8 {
+		return 0, false, nil
+	}
+	return binary.BigEndian.Uint64(num), true, nil
+}
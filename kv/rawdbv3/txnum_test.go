@@ -0,0 +1,43 @@
+package rawdbv3_test
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/ledgerwatch/erigon-lib/kv"
+	"github.com/ledgerwatch/erigon-lib/kv/memdb"
+	"github.com/ledgerwatch/erigon-lib/kv/rawdbv3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveTs(t *testing.T) {
+	_, tx := memdb.NewTestTx(t)
+	require := require.New(t)
+
+	// block N has 10 txs: [N*10, N*10+9]
+	for blockNum := uint64(0); blockNum < 5; blockNum++ {
+		require.NoError(rawdbv3.TxNums.Append(tx, blockNum, blockNum*10+9))
+		hash := []byte{byte(blockNum)}
+		var num [8]byte
+		binary.BigEndian.PutUint64(num[:], blockNum)
+		require.NoError(tx.Put(kv.HeaderNumber, hash, num[:]))
+	}
+	require.NoError(tx.Put(kv.HeadBlockKey, []byte(kv.HeadBlockKey), []byte{4}))
+	require.NoError(tx.Put(kv.LastForkchoice, []byte("safeBlockHash"), []byte{3}))
+
+	heads := rawdbv3.ForkchoiceHeads{}
+	ts, err := rawdbv3.ResolveTs(tx, heads, kv.LatestBlock)
+	require.NoError(err)
+	require.Equal(uint64(50), ts)
+	ts, err = rawdbv3.ResolveTs(tx, heads, kv.SafeBlock)
+	require.NoError(err)
+	require.Equal(uint64(40), ts)
+	ts, err = rawdbv3.ResolveTs(tx, heads, kv.EarliestBlock)
+	require.NoError(err)
+	require.Equal(uint64(10), ts)
+
+	_, err = rawdbv3.ResolveTs(tx, heads, kv.FinalizedBlock)
+	require.ErrorIs(err, kv.ErrBlockTagNotFound)
+	_, err = rawdbv3.ResolveTs(tx, nil, kv.LatestBlock)
+	require.ErrorIs(err, kv.ErrNotSupported)
+}
@@ -44,6 +44,7 @@ type remoteOpts struct {
 	bucketsCfg  mdbx.TableCfgFunc
 	DialAddress string
 	version     gointerfaces.Version
+	readCache   int
 }
 
 type RemoteKV struct {
@@ -52,8 +53,12 @@ type RemoteKV struct {
 	buckets      kv.TableCfg
 	roTxsLimiter *semaphore.Weighted
 	opts         remoteOpts
+	readCache    *readCache // nil - disabled, see WithReadCache
 }
 
+var _ kv.TemporalTx = &remoteTx{}
+var _ kv.TemporalRoDb = &RemoteKV{}
+
 type remoteTx struct {
 	stream             remote.KV_TxClient
 	ctx                context.Context
@@ -88,6 +93,13 @@ func (opts remoteOpts) WithBucketsConfig(f mdbx.TableCfgFunc) remoteOpts {
 	return opts
 }
 
+// WithReadCache - LRU cache of `size` GetOne results, shared by all txs of latest view. Helps to readers of hot keys
+// (chain config, latest header fields). 0 - disabled (default).
+func (opts remoteOpts) WithReadCache(size int) remoteOpts {
+	opts.readCache = size
+	return opts
+}
+
 func (opts remoteOpts) Open() (*RemoteKV, error) {
 	targetSemCount := int64(runtime.GOMAXPROCS(-1)) - 1
 	if targetSemCount <= 1 {
@@ -105,6 +117,12 @@ func (opts remoteOpts) Open() (*RemoteKV, error) {
 	for name, cfg := range customBuckets { // copy map to avoid changing global variable
 		db.buckets[name] = cfg
 	}
+	if opts.readCache > 0 {
+		var err error
+		if db.readCache, err = newReadCache(opts.readCache); err != nil {
+			return nil, err
+		}
+	}
 
 	return db, nil
 }
@@ -128,6 +146,11 @@ func (db *RemoteKV) PageSize() uint64        { panic("not implemented") }
 func (db *RemoteKV) ReadOnly() bool          { return true }
 func (db *RemoteKV) AllBuckets() kv.TableCfg { return db.buckets }
 
+// HealthCheck - only read tx: writes and disk are checked by server
+func (db *RemoteKV) HealthCheck(ctx context.Context) (kv.HealthStatus, error) {
+	return kv.HealthCheck(ctx, db, "")
+}
+
 func (db *RemoteKV) EnsureVersionCompatibility() bool {
 	versionReply, err := db.remoteKV.Version(context.Background(), &emptypb.Empty{}, grpc.WaitForReady(true))
 	if err != nil {
@@ -175,9 +198,30 @@ func (db *RemoteKV) BeginRo(ctx context.Context) (txn kv.Tx, err error) {
 		streamCancelFn()
 		return nil, err
 	}
+	if db.readCache != nil {
+		db.readCache.onView(msg.ViewID)
+	}
 	return &remoteTx{ctx: ctx, db: db, stream: stream, streamCancelFn: streamCancelFn, viewID: msg.ViewID, id: msg.TxID}, nil
 }
 
+func (db *RemoteKV) BeginTemporalRo(ctx context.Context) (kv.TemporalTx, error) {
+	tx, err := db.BeginRo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return tx.(kv.TemporalTx), nil
+}
+
+func (db *RemoteKV) ViewTemporal(ctx context.Context, f func(tx kv.TemporalTx) error) (err error) {
+	tx, err := db.BeginTemporalRo(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	return f(tx)
+}
+
 func (db *RemoteKV) BeginRw(ctx context.Context) (kv.RwTx, error) {
 	return nil, fmt.Errorf("remote db provider doesn't support .BeginRw method")
 }
@@ -204,11 +248,19 @@ func (db *RemoteKV) UpdateAsync(ctx context.Context, f func(tx kv.RwTx) error) (
 
 func (tx *remoteTx) ViewID() uint64  { return tx.viewID }
 func (tx *remoteTx) CollectMetrics() {}
+
 func (tx *remoteTx) IncrementSequence(bucket string, amount uint64) (uint64, error) {
 	panic("not implemented yet")
 }
 func (tx *remoteTx) ReadSequence(bucket string) (uint64, error) {
-	panic("not implemented yet")
+	v, err := tx.GetOne(kv.Sequence, []byte(bucket))
+	if err != nil {
+		return 0, err
+	}
+	if len(v) == 0 {
+		return 0, nil
+	}
+	return binary.BigEndian.Uint64(v), nil
 }
 func (tx *remoteTx) Append(bucket string, k, v []byte) error    { panic("no write methods") }
 func (tx *remoteTx) AppendDup(bucket string, k, v []byte) error { panic("no write methods") }
@@ -262,6 +314,14 @@ func (tx *remoteTx) ForEach(bucket string, fromPrefix []byte, walker func(k, v [
 	return nil
 }
 
+func (tx *remoteTx) ForEachCtx(ctx context.Context, bucket string, fromPrefix []byte, walker func(k, v []byte) error) error {
+	return tx.ForEach(bucket, fromPrefix, kv.WalkerWithCtx(ctx, walker))
+}
+
+func (tx *remoteTx) ForPrefixCtx(ctx context.Context, bucket string, prefix []byte, walker func(k, v []byte) error) error {
+	return tx.ForPrefix(bucket, prefix, kv.WalkerWithCtx(ctx, walker))
+}
+
 func (tx *remoteTx) ForPrefix(bucket string, prefix []byte, walker func(k, v []byte) error) error {
 	it, err := tx.Prefix(bucket, prefix)
 	if err != nil {
@@ -303,12 +363,23 @@ func (tx *remoteTx) ForAmount(bucket string, fromPrefix []byte, amount uint32, w
 }
 
 func (tx *remoteTx) GetOne(bucket string, k []byte) (val []byte, err error) {
+	if tx.db.readCache != nil {
+		if v, ok := tx.db.readCache.get(tx.viewID, bucket, k); ok {
+			return v, nil
+		}
+	}
 	c, err := tx.statelessCursor(bucket)
 	if err != nil {
 		return nil, err
 	}
 	_, val, err = c.SeekExact(k)
-	return val, err
+	if err != nil {
+		return nil, err
+	}
+	if tx.db.readCache != nil {
+		tx.db.readCache.put(tx.viewID, bucket, k, val)
+	}
+	return val, nil
 }
 
 func (tx *remoteTx) Has(bucket string, k []byte) (bool, error) {
@@ -327,6 +398,41 @@ func (c *remoteCursor) SeekExact(k []byte) (key, val []byte, err error) {
 	return c.seekExact(k)
 }
 
+// remoteSeekMultiBatch - amount of in-flight requests of SeekMulti. Bounded: server doesn't read next request until
+// client reads response
+const remoteSeekMultiBatch = 256
+
+// SeekMulti - pipelined SeekExact's: one round-trip per batch of keys instead of one per key
+func (c *remoteCursor) SeekMulti(keys [][]byte) ([][]byte, error) {
+	for i := 1; i < len(keys); i++ { // before any Send: stream must not have responses nobody reads
+		if bytes.Compare(keys[i-1], keys[i]) > 0 {
+			return nil, fmt.Errorf("SeekMulti: %w, %x > %x", kv.ErrKeysNotSorted, keys[i-1], keys[i])
+		}
+	}
+	vals := make([][]byte, len(keys))
+	for from := 0; from < len(keys); from += remoteSeekMultiBatch {
+		to := from + remoteSeekMultiBatch
+		if to > len(keys) {
+			to = len(keys)
+		}
+		for i := from; i < to; i++ {
+			if err := c.stream.Send(&remote.Cursor{Cursor: c.id, Op: remote.Op_SEEK_EXACT, K: keys[i]}); err != nil {
+				return nil, err
+			}
+		}
+		for i := from; i < to; i++ {
+			pair, err := c.stream.Recv()
+			if err != nil {
+				return nil, err
+			}
+			if pair.K != nil {
+				vals[i] = pair.V
+			}
+		}
+	}
+	return vals, nil
+}
+
 func (c *remoteCursor) Prev() ([]byte, []byte, error) {
 	return c.prev()
 }
@@ -351,6 +457,10 @@ func (c *remoteCursor) PutNoOverwrite(k []byte, v []byte) error { panic("not sup
 func (c *remoteCursor) Append(k []byte, v []byte) error         { panic("not supported") }
 func (c *remoteCursor) Delete(k []byte) error                   { panic("not supported") }
 func (c *remoteCursor) DeleteCurrent() error                    { panic("not supported") }
+func (c *remoteCursor) SeekToRatio(f float64) ([]byte, []byte, error) {
+	return kv.SeekToRatioByKeys(c, f)
+}
+
 func (c *remoteCursor) Count() (uint64, error) {
 	if err := c.stream.Send(&remote.Cursor{Cursor: c.id, Op: remote.Op_COUNT}); err != nil {
 		return 0, err
@@ -619,6 +729,14 @@ func (c *remoteCursorDupSort) PrevNoDup() ([]byte, []byte, error) { return c.pre
 func (c *remoteCursorDupSort) LastDup() ([]byte, error)           { return c.lastDup() }
 
 // Temporal Methods
+func (tx *remoteTx) DomainGet(name kv.Domain, k, k2 []byte, ts uint64) (v []byte, ok bool, err error) {
+	reply, err := tx.db.remoteKV.DomainGet(tx.ctx, &remote.DomainGetReq{TxId: tx.id, Table: string(name), K: k, K2: k2, Ts: ts})
+	if err != nil {
+		return nil, false, err
+	}
+	return reply.V, reply.Ok, nil
+}
+
 func (tx *remoteTx) HistoryGet(name kv.History, k []byte, ts uint64) (v []byte, ok bool, err error) {
 	reply, err := tx.db.remoteKV.HistoryGet(tx.ctx, &remote.HistoryGetReq{TxId: tx.id, Table: string(name), K: k, Ts: ts})
 	if err != nil {
@@ -627,9 +745,9 @@ func (tx *remoteTx) HistoryGet(name kv.History, k []byte, ts uint64) (v []byte,
 	return reply.V, reply.Ok, nil
 }
 
-func (tx *remoteTx) IndexRange(name kv.InvertedIdx, k []byte, fromTs, toTs, limit int) (timestamps iter.U64, err error) {
+func (tx *remoteTx) IndexRange(name kv.InvertedIdx, k []byte, fromTs, toTs int, asc order.By, limit int) (timestamps iter.U64, err error) {
 	return iter.PaginateU64(func(pageToken string) (arr []uint64, nextPageToken string, err error) {
-		req := &remote.IndexRangeReq{TxId: tx.id, Table: string(name), K: k, FromTs: int64(fromTs), ToTs: int64(toTs), Limit: int64(limit)}
+		req := &remote.IndexRangeReq{TxId: tx.id, Table: string(name), K: k, FromTs: int64(fromTs), ToTs: int64(toTs), OrderAscend: bool(asc), Limit: int64(limit), PageToken: pageToken}
 		reply, err := tx.db.remoteKV.IndexRange(tx.ctx, req)
 		if err != nil {
 			return nil, "", err
@@ -0,0 +1,93 @@
+/*
+   Copyright 2023 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package remotedb
+
+import (
+	"sync"
+
+	"github.com/hashicorp/golang-lru/simplelru"
+)
+
+// readCache - results of GetOne of latest view: (viewID, table, key) => value. Server's viewID changes after each
+// commit - then cache is purged. Txs of older views (still opened) don't read/write cache.
+type readCache struct {
+	lock   sync.Mutex
+	viewID uint64
+	lru    *simplelru.LRU
+
+	hits, misses uint64
+}
+
+type readCacheKey struct {
+	table, key string
+}
+
+type readCacheValue struct {
+	v []byte // nil - key not found
+}
+
+func newReadCache(size int) (*readCache, error) {
+	lru, err := simplelru.NewLRU(size, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &readCache{lru: lru}, nil
+}
+
+// onView - called when tx of `viewID` is opened. Cache only moves forward: tx of older view can't purge it.
+func (c *readCache) onView(viewID uint64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if viewID > c.viewID {
+		c.viewID = viewID
+		c.lru.Purge()
+	}
+}
+
+func (c *readCache) get(viewID uint64, table string, key []byte) ([]byte, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if viewID != c.viewID {
+		return nil, false
+	}
+	v, ok := c.lru.Get(readCacheKey{table: table, key: string(key)})
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	return v.(readCacheValue).v, true
+}
+
+func (c *readCache) put(viewID uint64, table string, key, v []byte) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if viewID != c.viewID {
+		return
+	}
+	c.lru.Add(readCacheKey{table: table, key: string(key)}, readCacheValue{v: v})
+}
+
+// ReadCacheStats - hits/misses of read cache (see WithReadCache) since Open
+func (db *RemoteKV) ReadCacheStats() (hits, misses uint64) {
+	if db.readCache == nil {
+		return 0, 0
+	}
+	db.readCache.lock.Lock()
+	defer db.readCache.lock.Unlock()
+	return db.readCache.hits, db.readCache.misses
+}
@@ -0,0 +1,184 @@
+/*
+   Copyright 2023 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package kv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ReplicationOp - kind of write in ReplicationEntry
+type ReplicationOp uint8
+
+const (
+	ReplicationPut         ReplicationOp = iota // Put/Append of K,V. In DupSort tables - adds V to values of K
+	ReplicationDelete                           // Delete of K. In DupSort tables - of all values of K
+	ReplicationDeleteExact                      // Delete of one value V of K in DupSort table
+	ReplicationClearTable                       // all entries of Table deleted
+)
+
+func (op ReplicationOp) String() string {
+	switch op {
+	case ReplicationPut:
+		return "put"
+	case ReplicationDelete:
+		return "delete"
+	case ReplicationDeleteExact:
+		return "delete_exact"
+	case ReplicationClearTable:
+		return "clear_table"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(op))
+	}
+}
+
+// ReplicationEntry - one logical write: K,V are in same form as passed to RwTx/RwCursor methods
+type ReplicationEntry struct {
+	Table string
+	Op    ReplicationOp
+	K, V  []byte
+}
+
+// ReplicationTx - write-set of one committed RwTx, entries are in order of writes. Shared by all consumers - read-only.
+type ReplicationTx struct {
+	Seq     uint64 // number of tx in stream, starts from 1, no gaps - then consumer can detect lost txs
+	ViewID  uint64 // ViewID of committed tx
+	Entries []ReplicationEntry
+}
+
+var (
+	ErrReplicationLag    = errors.New("replication consumer is too slow, write-sets were dropped")
+	ErrReplicationClosed = errors.New("replication consumer closed")
+)
+
+// ReplicationStream - opt-in (see mdbx.MdbxOpts.Replication) ordered stream of write-sets of committed RwTx.
+// Write-sets are published by commit - under exclusive write lock of DB, then order of stream is order of commits.
+// Txs without writes are not published. Writes to temporary tables are not replicated.
+//
+// Commit never waits for consumers: consumer which doesn't keep up (it's buffer is full) is dropped - Recv returns
+// ErrReplicationLag and consumer must re-sync from snapshot of DB.
+type ReplicationStream struct {
+	lock      sync.Mutex
+	seq       uint64
+	consumers map[*ReplicationConsumer]struct{}
+}
+
+func NewReplicationStream() *ReplicationStream {
+	return &ReplicationStream{consumers: map[*ReplicationConsumer]struct{}{}}
+}
+
+// Subscribe - consumer receives write-sets committed after this call. `bufSize` - how many write-sets consumer can lag.
+func (s *ReplicationStream) Subscribe(bufSize int) *ReplicationConsumer {
+	if bufSize <= 0 {
+		bufSize = 1
+	}
+	c := &ReplicationConsumer{s: s, ch: make(chan *ReplicationTx, bufSize)}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.consumers[c] = struct{}{}
+	return c
+}
+
+// Seq - Seq of last published write-set
+func (s *ReplicationStream) Seq() uint64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.seq
+}
+
+// Publish - called by DB after successful commit of RwTx with `viewID`. Takes ownership of `entries`.
+func (s *ReplicationStream) Publish(viewID uint64, entries []ReplicationEntry) {
+	if len(entries) == 0 {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.seq++
+	txn := &ReplicationTx{Seq: s.seq, ViewID: viewID, Entries: entries}
+	for c := range s.consumers {
+		select {
+		case c.ch <- txn:
+		default:
+			s.drop(c, ErrReplicationLag)
+		}
+	}
+}
+
+// drop - under lock
+func (s *ReplicationStream) drop(c *ReplicationConsumer, err error) {
+	if _, ok := s.consumers[c]; !ok {
+		return
+	}
+	delete(s.consumers, c)
+	c.err = err
+	close(c.ch)
+}
+
+type ReplicationConsumer struct {
+	s   *ReplicationStream
+	ch  chan *ReplicationTx
+	err error // set before close of ch
+}
+
+// Recv - next write-set. After error consumer is unsubscribed.
+func (c *ReplicationConsumer) Recv(ctx context.Context) (*ReplicationTx, error) {
+	select {
+	case txn, ok := <-c.ch:
+		if !ok {
+			return nil, c.err
+		}
+		return txn, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Close - unsubscribe. Write-sets which are already buffered still can be received.
+func (c *ReplicationConsumer) Close() {
+	c.s.lock.Lock()
+	defer c.s.lock.Unlock()
+	c.s.drop(c, ErrReplicationClosed)
+}
+
+// ApplyReplicationTx - replays write-set on `tx` - for example of replica which has same tables config
+func ApplyReplicationTx(tx RwTx, txn *ReplicationTx) error {
+	for _, e := range txn.Entries {
+		var err error
+		switch e.Op {
+		case ReplicationPut:
+			err = tx.Put(e.Table, e.K, e.V)
+		case ReplicationDelete:
+			err = tx.Delete(e.Table, e.K)
+		case ReplicationDeleteExact:
+			var c RwCursorDupSort
+			if c, err = tx.RwCursorDupSort(e.Table); err == nil {
+				err = c.DeleteExact(e.K, e.V)
+				c.Close()
+			}
+		case ReplicationClearTable:
+			err = tx.ClearBucket(e.Table)
+		default:
+			err = fmt.Errorf("unknown op %s", e.Op)
+		}
+		if err != nil {
+			return fmt.Errorf("apply replication tx %d: table %s, %s %x: %w", txn.Seq, e.Table, e.Op, e.K, err)
+		}
+	}
+	return nil
+}
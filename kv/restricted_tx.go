@@ -0,0 +1,278 @@
+/*
+   Copyright 2023 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package kv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ledgerwatch/erigon-lib/kv/iter"
+)
+
+// ErrTableNotAllowed - table is not in TableAccess of restricted Tx, see RestrictTx
+var ErrTableNotAllowed = errors.New("table is not allowed")
+
+// TableAccess - allowlists of restricted Tx. Subsystems which share DB handle (txpool in tests or embedded setups)
+// can't accidentally write into tables of others (chaindata).
+type TableAccess struct {
+	Read  []string // tables which can be read, in addition to Write. nil - all tables
+	Write []string // tables which can be written (and read)
+}
+
+// RestrictTx - wraps `tx`: access to tables which are not in `access` fails with ErrTableNotAllowed. Cursor's
+// methods are not checked: cursor can be opened only on allowed table.
+// Temporary tables created by tx are writable.
+func RestrictTx(tx RwTx, access TableAccess) RwTx {
+	r := &restrictedTx{RwTx: tx, write: map[string]struct{}{}}
+	if access.Read != nil {
+		r.read = map[string]struct{}{}
+		for _, table := range access.Read {
+			r.read[table] = struct{}{}
+		}
+	}
+	for _, table := range access.Write {
+		r.write[table] = struct{}{}
+	}
+	return r
+}
+
+// BeginRwRestricted - db.BeginRw wrapped by RestrictTx
+func BeginRwRestricted(ctx context.Context, db RwDB, access TableAccess) (RwTx, error) {
+	tx, err := db.BeginRw(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return RestrictTx(tx, access), nil
+}
+
+// UpdateRestricted - db.Update on Tx wrapped by RestrictTx
+func UpdateRestricted(ctx context.Context, db RwDB, access TableAccess, f func(tx RwTx) error) error {
+	return db.Update(ctx, func(tx RwTx) error {
+		return f(RestrictTx(tx, access))
+	})
+}
+
+type restrictedTx struct {
+	RwTx
+	read  map[string]struct{} // nil - all tables
+	write map[string]struct{}
+}
+
+func (tx *restrictedTx) canRead(table string) error {
+	if tx.read == nil {
+		return nil
+	}
+	if _, ok := tx.read[table]; ok {
+		return nil
+	}
+	if _, ok := tx.write[table]; ok {
+		return nil
+	}
+	return fmt.Errorf("%w: read of %s", ErrTableNotAllowed, table)
+}
+
+func (tx *restrictedTx) canWrite(table string) error {
+	if _, ok := tx.write[table]; ok {
+		return nil
+	}
+	return fmt.Errorf("%w: write of %s", ErrTableNotAllowed, table)
+}
+
+func (tx *restrictedTx) Has(table string, key []byte) (bool, error) {
+	if err := tx.canRead(table); err != nil {
+		return false, err
+	}
+	return tx.RwTx.Has(table, key)
+}
+func (tx *restrictedTx) GetOne(table string, key []byte) ([]byte, error) {
+	if err := tx.canRead(table); err != nil {
+		return nil, err
+	}
+	return tx.RwTx.GetOne(table, key)
+}
+func (tx *restrictedTx) ForEach(table string, fromPrefix []byte, walker func(k, v []byte) error) error {
+	if err := tx.canRead(table); err != nil {
+		return err
+	}
+	return tx.RwTx.ForEach(table, fromPrefix, walker)
+}
+func (tx *restrictedTx) ForPrefix(table string, prefix []byte, walker func(k, v []byte) error) error {
+	if err := tx.canRead(table); err != nil {
+		return err
+	}
+	return tx.RwTx.ForPrefix(table, prefix, walker)
+}
+func (tx *restrictedTx) ForAmount(table string, prefix []byte, amount uint32, walker func(k, v []byte) error) error {
+	if err := tx.canRead(table); err != nil {
+		return err
+	}
+	return tx.RwTx.ForAmount(table, prefix, amount, walker)
+}
+func (tx *restrictedTx) ForEachCtx(ctx context.Context, table string, fromPrefix []byte, walker func(k, v []byte) error) error {
+	if err := tx.canRead(table); err != nil {
+		return err
+	}
+	return tx.RwTx.ForEachCtx(ctx, table, fromPrefix, walker)
+}
+func (tx *restrictedTx) ForPrefixCtx(ctx context.Context, table string, prefix []byte, walker func(k, v []byte) error) error {
+	if err := tx.canRead(table); err != nil {
+		return err
+	}
+	return tx.RwTx.ForPrefixCtx(ctx, table, prefix, walker)
+}
+func (tx *restrictedTx) ReadSequence(table string) (uint64, error) {
+	if err := tx.canRead(table); err != nil {
+		return 0, err
+	}
+	return tx.RwTx.ReadSequence(table)
+}
+func (tx *restrictedTx) BucketSize(table string) (uint64, error) {
+	if err := tx.canRead(table); err != nil {
+		return 0, err
+	}
+	return tx.RwTx.BucketSize(table)
+}
+func (tx *restrictedTx) Cursor(table string) (Cursor, error) {
+	if err := tx.canRead(table); err != nil {
+		return nil, err
+	}
+	return tx.RwTx.Cursor(table)
+}
+func (tx *restrictedTx) CursorDupSort(table string) (CursorDupSort, error) {
+	if err := tx.canRead(table); err != nil {
+		return nil, err
+	}
+	return tx.RwTx.CursorDupSort(table)
+}
+func (tx *restrictedTx) Range(table string, fromPrefix, toPrefix []byte) (iter.KV, error) {
+	if err := tx.canRead(table); err != nil {
+		return nil, err
+	}
+	return tx.RwTx.Range(table, fromPrefix, toPrefix)
+}
+func (tx *restrictedTx) RangeAscend(table string, fromPrefix, toPrefix []byte, limit int) (iter.KV, error) {
+	if err := tx.canRead(table); err != nil {
+		return nil, err
+	}
+	return tx.RwTx.RangeAscend(table, fromPrefix, toPrefix, limit)
+}
+func (tx *restrictedTx) RangeDescend(table string, fromPrefix, toPrefix []byte, limit int) (iter.KV, error) {
+	if err := tx.canRead(table); err != nil {
+		return nil, err
+	}
+	return tx.RwTx.RangeDescend(table, fromPrefix, toPrefix, limit)
+}
+func (tx *restrictedTx) Prefix(table string, prefix []byte) (iter.KV, error) {
+	if err := tx.canRead(table); err != nil {
+		return nil, err
+	}
+	return tx.RwTx.Prefix(table, prefix)
+}
+func (tx *restrictedTx) ExistsBucket(table string) (bool, error) {
+	if err := tx.canRead(table); err != nil {
+		return false, err
+	}
+	return tx.RwTx.ExistsBucket(table)
+}
+
+func (tx *restrictedTx) Put(table string, k, v []byte) error {
+	if err := tx.canWrite(table); err != nil {
+		return err
+	}
+	return tx.RwTx.Put(table, k, v)
+}
+func (tx *restrictedTx) Delete(table string, k []byte) error {
+	if err := tx.canWrite(table); err != nil {
+		return err
+	}
+	return tx.RwTx.Delete(table, k)
+}
+func (tx *restrictedTx) DeleteRange(table string, from, to []byte) error {
+	if err := tx.canWrite(table); err != nil {
+		return err
+	}
+	return tx.RwTx.DeleteRange(table, from, to)
+}
+func (tx *restrictedTx) IncrementSequence(table string, amount uint64) (uint64, error) {
+	if err := tx.canWrite(table); err != nil {
+		return 0, err
+	}
+	return tx.RwTx.IncrementSequence(table, amount)
+}
+func (tx *restrictedTx) Append(table string, k, v []byte) error {
+	if err := tx.canWrite(table); err != nil {
+		return err
+	}
+	return tx.RwTx.Append(table, k, v)
+}
+func (tx *restrictedTx) AppendDup(table string, k, v []byte) error {
+	if err := tx.canWrite(table); err != nil {
+		return err
+	}
+	return tx.RwTx.AppendDup(table, k, v)
+}
+func (tx *restrictedTx) PutReserve(table string, k []byte, size int) ([]byte, error) {
+	if err := tx.canWrite(table); err != nil {
+		return nil, err
+	}
+	return tx.RwTx.PutReserve(table, k, size)
+}
+func (tx *restrictedTx) PutV(table string, k []byte, parts ...[]byte) error {
+	if err := tx.canWrite(table); err != nil {
+		return err
+	}
+	return tx.RwTx.PutV(table, k, parts...)
+}
+func (tx *restrictedTx) RwCursor(table string) (RwCursor, error) {
+	if err := tx.canWrite(table); err != nil {
+		return nil, err
+	}
+	return tx.RwTx.RwCursor(table)
+}
+func (tx *restrictedTx) RwCursorDupSort(table string) (RwCursorDupSort, error) {
+	if err := tx.canWrite(table); err != nil {
+		return nil, err
+	}
+	return tx.RwTx.RwCursorDupSort(table)
+}
+func (tx *restrictedTx) CreateTemporaryBucket(prefix string) (string, error) {
+	name, err := tx.RwTx.CreateTemporaryBucket(prefix)
+	if err != nil {
+		return name, err
+	}
+	tx.write[name] = struct{}{}
+	return name, nil
+}
+func (tx *restrictedTx) DropBucket(table string) error {
+	if err := tx.canWrite(table); err != nil {
+		return err
+	}
+	return tx.RwTx.DropBucket(table)
+}
+func (tx *restrictedTx) CreateBucket(table string) error {
+	if err := tx.canWrite(table); err != nil {
+		return err
+	}
+	return tx.RwTx.CreateBucket(table)
+}
+func (tx *restrictedTx) ClearBucket(table string) error {
+	if err := tx.canWrite(table); err != nil {
+		return err
+	}
+	return tx.RwTx.ClearBucket(table)
+}
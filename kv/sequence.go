@@ -0,0 +1,77 @@
+/*
+   Copyright 2023 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package kv
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Namespaced sequences - many independent sequences for one table (for example: per-file piece IDs, per-shard txNums).
+// Stored in `Sequence` table next to table-global sequences, by key: table + 0x00 + namespace.
+//
+// Crash-consistency contract (same for table-global sequences):
+//   - allocation is a regular write of RwTx: it's persisted by same commit as data which uses allocated IDs,
+//     and disappears on Rollback (or crash before commit) - then same IDs will be allocated again.
+//   - mdbx has 1 writer at a time - so IDs allocated by committed transactions are gap-free and unique,
+//     if app doesn't skip IDs it got.
+
+func sequenceKey(table string, namespace []byte) []byte {
+	k := make([]byte, 0, len(table)+1+len(namespace))
+	k = append(k, table...)
+	k = append(k, 0)
+	return append(k, namespace...)
+}
+
+// IncrementNamespacedSequence - like `IncrementSequence`, but sequence is per (table, namespace).
+// Returns first allocated ID, allocated range: [id, id+amount)
+func IncrementNamespacedSequence(tx StatelessRwTx, table string, namespace []byte, amount uint64) (uint64, error) {
+	k := sequenceKey(table, namespace)
+	current, err := readSequence(tx, k)
+	if err != nil {
+		return 0, fmt.Errorf("IncrementNamespacedSequence: %s, %x: %w", table, namespace, err)
+	}
+	var v [8]byte
+	binary.BigEndian.PutUint64(v[:], current+amount)
+	if err = tx.Put(Sequence, k, v[:]); err != nil {
+		return 0, fmt.Errorf("IncrementNamespacedSequence: %s, %x: %w", table, namespace, err)
+	}
+	return current, nil
+}
+
+// ReadNamespacedSequence - next ID which will be allocated by IncrementNamespacedSequence
+func ReadNamespacedSequence(tx Getter, table string, namespace []byte) (uint64, error) {
+	current, err := readSequence(tx, sequenceKey(table, namespace))
+	if err != nil {
+		return 0, fmt.Errorf("ReadNamespacedSequence: %s, %x: %w", table, namespace, err)
+	}
+	return current, nil
+}
+
+func readSequence(tx Getter, k []byte) (uint64, error) {
+	v, err := tx.GetOne(Sequence, k)
+	if err != nil {
+		return 0, err
+	}
+	if len(v) == 0 {
+		return 0, nil
+	}
+	if len(v) != 8 {
+		return 0, fmt.Errorf("unexpected sequence value len: %d", len(v))
+	}
+	return binary.BigEndian.Uint64(v), nil
+}
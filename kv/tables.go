@@ -405,6 +405,8 @@ const (
 	TracesToKeys   = "TracesToKeys"
 	TracesToIdx    = "TracesToIdx"
 
+	HistoryBlockBoundaries = "HistoryBlockBoundaries" // block_num_u64 -> first_tx_num_u64 + last_tx_num_u64
+
 	Snapshots = "Snapshots" // name -> hash
 
 	RAccountKeys = "RAccountKeys"
@@ -574,6 +576,8 @@ var ChaindataTables = []string{
 	TracesToKeys,
 	TracesToIdx,
 
+	HistoryBlockBoundaries,
+
 	Snapshots,
 	MaxTxNum,
 
@@ -602,12 +606,18 @@ const (
 	PoolInfo               = "PoolInfo"               // option_key -> option_value
 )
 
+const (
+	PeerScores = "PeerScores" // peer_id_64bytes -> headers_u64+bodies_u64+txs_u64+penalties_u64+last_seen_u64
+)
+
 var TxPoolTables = []string{
 	RecentLocalTransaction,
 	PoolTransaction,
 	PoolInfo,
 }
-var SentryTables = []string{}
+var SentryTables = []string{
+	PeerScores,
+}
 var DownloaderTables = []string{
 	BittorrentCompletion,
 	BittorrentInfo,
@@ -0,0 +1,72 @@
+/*
+   Copyright 2023 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package kv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrViewGone - requested ViewID can't be opened anymore: it's not pinned (see PinView) and DB has newer commits
+var ErrViewGone = errors.New("view is not available")
+
+// ViewPinner - RoDB which can reopen read-only Tx on snapshot of older commit. MDBX can't start new Tx on older
+// snapshot - it's possible only by Tx opened while that snapshot was latest, so such Tx are opened in advance
+// by PinView and handed out by BeginRoAt.
+type ViewPinner interface {
+	// PinView - opens `n` read-only Tx on latest snapshot and keeps them for BeginRoAt with returned `viewID`.
+	// Tx returned to pin on Rollback, so `n` - amount of concurrent readers. `unpin` closes Tx kept by pin.
+	PinView(ctx context.Context, n int) (viewID uint64, unpin func(), err error)
+	// BeginRoAt - read-only Tx which sees same data as Tx with ViewID `viewID`
+	BeginRoAt(ctx context.Context, viewID uint64) (Tx, error)
+}
+
+// PinView - see ViewPinner.PinView. For handlers which spread one logical read (rpc batch) across several
+// goroutines - each needs own Tx, because Tx is not thread-safe:
+//
+//	viewID, unpin, err := kv.PinView(ctx, db, len(batch))
+//	defer unpin()
+//	// in each goroutine
+//	err = kv.ViewAt(ctx, db, viewID, func(tx kv.Tx) error { ... })
+func PinView(ctx context.Context, db RoDB, n int) (viewID uint64, unpin func(), err error) {
+	p, ok := db.(ViewPinner)
+	if !ok {
+		return 0, nil, fmt.Errorf("%T doesn't support PinView", db)
+	}
+	return p.PinView(ctx, n)
+}
+
+// BeginRoAt - opens read-only Tx which sees same data as Tx with ViewID `viewID`: one of Tx pinned by PinView,
+// or new Tx if `viewID` is still latest commit. Otherwise returns ErrViewGone.
+func BeginRoAt(ctx context.Context, db RoDB, viewID uint64) (Tx, error) {
+	p, ok := db.(ViewPinner)
+	if !ok {
+		return nil, fmt.Errorf("%T doesn't support BeginRoAt", db)
+	}
+	return p.BeginRoAt(ctx, viewID)
+}
+
+// ViewAt - same as RoDB.View, but on Tx opened by BeginRoAt
+func ViewAt(ctx context.Context, db RoDB, viewID uint64, f func(tx Tx) error) error {
+	tx, err := BeginRoAt(ctx, db, viewID)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	return f(tx)
+}
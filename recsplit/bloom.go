@@ -0,0 +1,98 @@
+/*
+   Copyright 2023 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package recsplit
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Bloom filter of index keys: perfect hash function maps any key to some offset, then caller reads data file and
+// compares keys. Filter answers "key is not in index" without reading data file. It's built from fingerprints
+// (low 64 bits of key's hash) by Build, probes are derived by double hashing of fingerprint halves.
+//
+// Format (after offsets of enums): length of bits in bytes (uint64), amount of probes (byte), bits.
+
+const (
+	featureEnums byte = 1 << iota
+	featureBloom
+)
+
+type bloomFilter struct {
+	bits   []byte
+	probes uint8
+}
+
+func newBloomFilter(keys uint64, bitsPerKey int) *bloomFilter {
+	size := (keys*uint64(bitsPerKey) + 63) / 64 * 8
+	if size == 0 {
+		size = 8
+	}
+	probes := int(math.Round(float64(bitsPerKey) * math.Ln2))
+	if probes < 1 {
+		probes = 1
+	} else if probes > 30 {
+		probes = 30
+	}
+	return &bloomFilter{bits: make([]byte, size), probes: uint8(probes)}
+}
+
+func (b *bloomFilter) add(fingerprint uint64) {
+	m := uint64(len(b.bits)) * 8
+	h1, h2 := fingerprint&0xFFFFFFFF, fingerprint>>32|1
+	for i := uint64(0); i < uint64(b.probes); i++ {
+		bit := (h1 + i*h2) % m
+		b.bits[bit>>3] |= 1 << (bit & 7)
+	}
+}
+
+func (b *bloomFilter) mayContain(fingerprint uint64) bool {
+	m := uint64(len(b.bits)) * 8
+	h1, h2 := fingerprint&0xFFFFFFFF, fingerprint>>32|1
+	for i := uint64(0); i < uint64(b.probes); i++ {
+		bit := (h1 + i*h2) % m
+		if b.bits[bit>>3]&(1<<(bit&7)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *bloomFilter) write(w io.Writer) error {
+	var hdr [9]byte
+	binary.BigEndian.PutUint64(hdr[:], uint64(len(b.bits)))
+	hdr[8] = b.probes
+	if _, err := w.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(b.bits)
+	return err
+}
+
+// readBloomFilter - `data` is not copied (mmap of index file)
+func readBloomFilter(data []byte) (*bloomFilter, int, error) {
+	if len(data) < 9 {
+		return nil, 0, fmt.Errorf("bloom filter header is truncated")
+	}
+	size := binary.BigEndian.Uint64(data)
+	if size == 0 || uint64(len(data)-9) < size {
+		return nil, 0, fmt.Errorf("bloom filter of %d bytes is truncated", size)
+	}
+	return &bloomFilter{bits: data[9 : 9+size], probes: data[8]}, 9 + int(size), nil
+}
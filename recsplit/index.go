@@ -24,6 +24,7 @@ import (
 	"math/bits"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 	"unsafe"
 
@@ -32,10 +33,15 @@ import (
 	"github.com/ledgerwatch/erigon-lib/recsplit/eliasfano32"
 )
 
+// recFingerprintsFlag - high bit of "bytes per record" byte of header: records have fingerprints
+const recFingerprintsFlag byte = 0x80
+
 // Index implements index lookup from the file created by the RecSplit
 type Index struct {
 	offsetEf           *eliasfano32.EliasFano
-	f                  *os.File
+	f                  *os.File // nil after ReleaseFile
+	fLock              sync.Mutex
+	closed             bool
 	mmapHandle2        *[mmap.MaxMapSize]byte // mmap handle for windows (this is used to close mmap)
 	indexFile          string
 	grData             []uint64
@@ -51,12 +57,15 @@ type Index struct {
 	bucketCount        uint64 // Number of buckets
 	keyCount           uint64
 	recMask            uint64
-	bytesPerRec        int
+	bytesPerRec        int  // of offset
+	recSize            int  // bytesPerRec + fingerprint
+	fingerprints       bool // see RecSplitArgs.Fingerprints
 	salt               uint32
 	leafSize           uint16 // Leaf size for recursive split algorithms
 	secondaryAggrBound uint16 // The lower bound for secondary key aggregation (computed from leadSize)
 	primaryAggrBound   uint16 // The lower bound for primary key aggregation (computed from leafSize)
 	enums              bool
+	bloom              *bloomFilter // nil if index has no filter, see RecSplitArgs.BloomBitsPerKey
 }
 
 func MustOpen(indexFile string) *Index {
@@ -89,9 +98,14 @@ func OpenIndex(indexFile string) (*Index, error) {
 	// Read number of keys and bytes per record
 	idx.baseDataID = binary.BigEndian.Uint64(idx.data[:8])
 	idx.keyCount = binary.BigEndian.Uint64(idx.data[8:16])
-	idx.bytesPerRec = int(idx.data[16])
+	idx.fingerprints = idx.data[16]&recFingerprintsFlag != 0
+	idx.bytesPerRec = int(idx.data[16] &^ recFingerprintsFlag)
+	idx.recSize = idx.bytesPerRec
+	if idx.fingerprints {
+		idx.recSize += 8
+	}
 	idx.recMask = (uint64(1) << (8 * idx.bytesPerRec)) - 1
-	offset := 16 + 1 + int(idx.keyCount)*idx.bytesPerRec
+	offset := 16 + 1 + int(idx.keyCount)*idx.recSize
 
 	if offset < 0 {
 		return nil, fmt.Errorf("offset is: %d which is below zero, the file: %s is broken", offset, indexFile)
@@ -121,13 +135,21 @@ func OpenIndex(indexFile string) (*Index, error) {
 		idx.startSeed[i] = binary.BigEndian.Uint64(idx.data[offset:])
 		offset += 8
 	}
-	idx.enums = idx.data[offset] != 0
+	features := idx.data[offset]
+	idx.enums = features&featureEnums != 0
 	offset++
 	if idx.enums {
 		var size int
 		idx.offsetEf, size = eliasfano32.ReadEliasFano(idx.data[offset:])
 		offset += size
 	}
+	if features&featureBloom != 0 {
+		var size int
+		if idx.bloom, size, err = readBloomFilter(idx.data[offset:]); err != nil {
+			return nil, fmt.Errorf("%s: %w", indexFile, err)
+		}
+		offset += size
+	}
 	// Size of golomb rice params
 	golombParamSize := binary.BigEndian.Uint16(idx.data[offset:])
 	offset += 4
@@ -164,15 +186,55 @@ func (idx *Index) Close() error {
 	if idx == nil {
 		return nil
 	}
+	idx.fLock.Lock()
+	defer idx.fLock.Unlock()
+	idx.closed = true
 	if err := mmap.Munmap(idx.mmapHandle1, idx.mmapHandle2); err != nil {
 		return err
 	}
-	if err := idx.f.Close(); err != nil {
-		return err
+	if idx.f != nil {
+		if err := idx.f.Close(); err != nil {
+			return err
+		}
+		idx.f = nil
 	}
 	return nil
 }
 
+// ReleaseFile - closes file descriptor, but keeps file mapped: readers are not affected. See ReopenFile.
+func (idx *Index) ReleaseFile() error {
+	idx.fLock.Lock()
+	defer idx.fLock.Unlock()
+	if idx.f == nil {
+		return nil
+	}
+	err := idx.f.Close()
+	idx.f = nil
+	return err
+}
+
+// ReopenFile - opens file descriptor released by ReleaseFile. Returns false if descriptor is open or index closed.
+func (idx *Index) ReopenFile() (bool, error) {
+	idx.fLock.Lock()
+	defer idx.fLock.Unlock()
+	if idx.f != nil || idx.closed {
+		return false, nil
+	}
+	f, err := os.Open(idx.indexFile)
+	if err != nil {
+		return false, err
+	}
+	idx.f = f
+	return true, nil
+}
+
+// Closed - Close was called
+func (idx *Index) Closed() bool {
+	idx.fLock.Lock()
+	defer idx.fLock.Unlock()
+	return idx.closed
+}
+
 func (idx *Index) skipBits(m uint16) int {
 	return int(idx.golombRice[m] & 0xffff)
 }
@@ -188,6 +250,12 @@ func (idx *Index) golombParam(m uint16) int {
 	return int(idx.golombRice[m] >> 27)
 }
 
+// HasBloom - index has bloom filter of keys, see IndexReader.TryLookup
+func (idx *Index) HasBloom() bool { return idx.bloom != nil }
+
+// HasFingerprints - records of index have fingerprints of keys, see IndexReader.TryLookup
+func (idx *Index) HasFingerprints() bool { return idx.fingerprints }
+
 func (idx *Index) Empty() bool {
 	return idx.keyCount == 0
 }
@@ -205,6 +273,11 @@ func (idx *Index) Lookup(bucketHash, fingerprint uint64) uint64 {
 	if idx.keyCount == 1 {
 		return 0
 	}
+	return idx.recOffset(idx.lookupRec(bucketHash, fingerprint))
+}
+
+// lookupRec - number of record of key, keyCount must be > 1
+func (idx *Index) lookupRec(bucketHash, fingerprint uint64) int {
 	var gr GolombRiceReader
 	gr.data = idx.grData
 
@@ -257,9 +330,30 @@ func (idx *Index) Lookup(bucketHash, fingerprint uint64) uint64 {
 		level++
 	}
 	b := gr.ReadNext(idx.golombParam(m))
-	rec := int(cumKeys) + int(remap16(remix(fingerprint+idx.startSeed[level]+b), m))
-	pos := 1 + 8 + idx.bytesPerRec*(rec+1)
+	return int(cumKeys) + int(remap16(remix(fingerprint+idx.startSeed[level]+b), m))
+}
 
+// LookupChecked - like Lookup, but false if index has fingerprints (see RecSplitArgs.Fingerprints) and key of
+// record doesn't have `fingerprint` - key is not in index
+func (idx *Index) LookupChecked(bucketHash, fingerprint uint64) (uint64, bool) {
+	if idx.keyCount == 0 {
+		return 0, false
+	}
+	rec := 0
+	if idx.keyCount > 1 {
+		rec = idx.lookupRec(bucketHash, fingerprint)
+	}
+	if idx.fingerprints && binary.BigEndian.Uint64(idx.data[17+idx.recSize*rec:]) != fingerprint {
+		return 0, false
+	}
+	if idx.keyCount == 1 {
+		return 0, true
+	}
+	return idx.recOffset(rec), true
+}
+
+func (idx *Index) recOffset(rec int) uint64 {
+	pos := 1 + 8 + idx.recSize*(rec+1)
 	return binary.BigEndian.Uint64(idx.data[pos:]) & idx.recMask
 }
 
@@ -272,11 +366,8 @@ func (idx *Index) OrdinalLookup(i uint64) uint64 {
 
 func (idx *Index) ExtractOffsets() map[uint64]uint64 {
 	m := map[uint64]uint64{}
-	pos := 1 + 8 + idx.bytesPerRec
-	for rec := uint64(0); rec < idx.keyCount; rec++ {
-		offset := binary.BigEndian.Uint64(idx.data[pos:]) & idx.recMask
-		m[offset] = 0
-		pos += idx.bytesPerRec
+	for rec := 0; rec < int(idx.keyCount); rec++ {
+		m[idx.recOffset(rec)] = 0
 	}
 	return m
 }
@@ -303,20 +394,22 @@ func (idx *Index) RewriteWithOffsets(w *bufio.Writer, m map[uint64]uint64) error
 		return fmt.Errorf("write number of keys: %w", err)
 	}
 	// Write number of bytes per index record
-	if err := w.WriteByte(byte(bytesPerRec)); err != nil {
+	if err := w.WriteByte(byte(bytesPerRec) | (idx.data[16] & recFingerprintsFlag)); err != nil {
 		return fmt.Errorf("write bytes per record: %w", err)
 	}
-	pos := 1 + 8 + idx.bytesPerRec
-	for rec := uint64(0); rec < idx.keyCount; rec++ {
-		offset := binary.BigEndian.Uint64(idx.data[pos:]) & idx.recMask
-		pos += idx.bytesPerRec
-		binary.BigEndian.PutUint64(numBuf[:], m[offset])
+	for rec := 0; rec < int(idx.keyCount); rec++ {
+		if idx.fingerprints {
+			if _, err := w.Write(idx.data[17+idx.recSize*rec : 17+idx.recSize*rec+8]); err != nil {
+				return err
+			}
+		}
+		binary.BigEndian.PutUint64(numBuf[:], m[idx.recOffset(rec)])
 		if _, err := w.Write(numBuf[8-bytesPerRec:]); err != nil {
 			return err
 		}
 	}
 	// Write the rest as it is (TODO - wrong for indices with enums)
-	if _, err := w.Write(idx.data[16+1+int(idx.keyCount)*idx.bytesPerRec:]); err != nil {
+	if _, err := w.Write(idx.data[16+1+int(idx.keyCount)*idx.recSize:]); err != nil {
 		return err
 	}
 	return nil
@@ -63,6 +63,30 @@ func (r *IndexReader) Lookup(key []byte) uint64 {
 	return 0
 }
 
+// TryLookup - like Lookup, but false if bloom filter or fingerprint of record (see RecSplitArgs.Fingerprints) says
+// that `key` is not in index: then caller doesn't need to read and compare key at offset. True doesn't mean that key
+// exists (no filter, or false positive).
+func (r *IndexReader) TryLookup(key []byte) (uint64, bool) {
+	bucketHash, fingerprint := r.sum(key)
+	return r.tryLookup(bucketHash, fingerprint)
+}
+
+// TryLookup2 - TryLookup of key1+key2
+func (r *IndexReader) TryLookup2(key1, key2 []byte) (uint64, bool) {
+	bucketHash, fingerprint := r.sum2(key1, key2)
+	return r.tryLookup(bucketHash, fingerprint)
+}
+
+func (r *IndexReader) tryLookup(bucketHash, fingerprint uint64) (uint64, bool) {
+	if r.index == nil {
+		return 0, true
+	}
+	if r.index.bloom != nil && !r.index.bloom.mayContain(fingerprint) {
+		return 0, false
+	}
+	return r.index.LookupChecked(bucketHash, fingerprint)
+}
+
 func (r *IndexReader) Lookup2(key1, key2 []byte) uint64 {
 	bucketHash, fingerprint := r.sum2(key1, key2)
 	if r.index != nil {
@@ -71,6 +95,31 @@ func (r *IndexReader) Lookup2(key1, key2 []byte) uint64 {
 	return 0
 }
 
+// LookupMany - Lookup of each key. Hashes all keys under 1 lock acquisition and hasher setup, then looks them up
+// without lock - for tight loops over many keys of same file
+func (r *IndexReader) LookupMany(keys [][]byte) []uint64 {
+	res := make([]uint64, len(keys))
+	if r.index == nil || len(keys) == 0 {
+		return res
+	}
+	fingerprints := make([]uint64, len(keys))
+	r.mu.Lock()
+	for i, key := range keys {
+		r.hasher.Reset()
+		r.hasher.Write(key) //nolint:errcheck
+		res[i], fingerprints[i] = r.hasher.Sum128()
+	}
+	r.mu.Unlock()
+	for i := range res {
+		res[i] = r.index.Lookup(res[i], fingerprints[i])
+	}
+	return res
+}
+
 func (r *IndexReader) Empty() bool {
 	return r.index.Empty()
 }
+
+func (r *IndexReader) KeyCount() uint64 {
+	return r.index.KeyCount()
+}
@@ -106,6 +106,12 @@ type RecSplit struct {
 	enums              bool // Whether to build two level index with perfect hash table pointing to enumeration and enumeration pointing to offsets
 	built              bool // Flag indicating that the hash function has been built and no more keys can be added
 	trace              bool
+	resumable          bool // see RecSplitArgs.Resumable
+	statePersisted     bool // all keys are on disk and resume state is written - Build can be resumed
+	done               bool // index file is written
+	bloomBitsPerKey    int  // see RecSplitArgs.BloomBitsPerKey
+	fingerprints       bool // see RecSplitArgs.Fingerprints
+	bloom              *bloomFilter
 }
 
 type RecSplitArgs struct {
@@ -123,6 +129,17 @@ type RecSplitArgs struct {
 	EtlBufLimit datasize.ByteSize
 	Salt        uint32 // Hash seed (salt) for the hash function used for allocating the initial buckets - need to be generated randomly
 	LeafSize    uint16
+
+	// Resumable - added keys are kept in TmpDir until Build succeed: if Build is interrupted (by crash or error),
+	// it can be continued by ResumeBuild without adding keys again
+	Resumable bool
+
+	// BloomBitsPerKey - embed bloom filter of keys into index (see IndexReader.TryLookup), 0 - no filter.
+	// 10 bits per key give ~1% of false positives
+	BloomBitsPerKey int
+	// Fingerprints - each record keeps 8-byte fingerprint of it's key next to offset: TryLookup rejects keys which
+	// are not in index (perfect hash function maps them to record of other key) without read of data file
+	Fingerprints bool
 }
 
 // NewRecSplit creates a new RecSplit instance with given number of keys and given bucket size
@@ -155,13 +172,21 @@ func NewRecSplit(args RecSplitArgs) (*RecSplit, error) {
 	if rs.etlBufLimit == 0 {
 		rs.etlBufLimit = etl.BufferOptimalSize
 	}
-	rs.bucketCollector = etl.NewCollector(RecSplitLogPrefix+" "+fname, rs.tmpDir, etl.NewSortableBuffer(rs.etlBufLimit))
-	rs.bucketCollector.LogLvl(log.LvlDebug)
 	rs.enums = args.Enums
-	if args.Enums {
-		rs.offsetCollector = etl.NewCollector(RecSplitLogPrefix+" "+fname, rs.tmpDir, etl.NewSortableBuffer(rs.etlBufLimit))
-		rs.offsetCollector.LogLvl(log.LvlDebug)
+	rs.bloomBitsPerKey = args.BloomBitsPerKey
+	rs.fingerprints = args.Fingerprints
+	rs.resumable = args.Resumable
+	if rs.resumable {
+		if rs.tmpDir == "" {
+			return nil, fmt.Errorf("resumable RecSplit requires TmpDir: %s", rs.indexFile)
+		}
+		if !rs.hasResumeState() { // leftovers of interrupted adding of keys
+			if err := os.RemoveAll(rs.resumeDir()); err != nil {
+				return nil, err
+			}
+		}
 	}
+	rs.newCollectors()
 	rs.currentBucket = make([]uint64, 0, args.BucketSize)
 	rs.currentBucketOffs = make([]uint64, 0, args.BucketSize)
 	rs.maxOffset = 0
@@ -182,16 +207,39 @@ func NewRecSplit(args RecSplitArgs) (*RecSplit, error) {
 	return rs, nil
 }
 
+func (rs *RecSplit) newCollectors() {
+	newCollector := etl.NewCollector
+	bucketsDir, offsetsDir := rs.tmpDir, rs.tmpDir
+	if rs.resumable {
+		newCollector = etl.NewCriticalCollector // fsync and keep files on failed Load
+		bucketsDir, offsetsDir = filepath.Join(rs.resumeDir(), "buckets"), filepath.Join(rs.resumeDir(), "offsets")
+	}
+	rs.bucketCollector = newCollector(RecSplitLogPrefix+" "+rs.indexFileName, bucketsDir, etl.NewSortableBuffer(rs.etlBufLimit))
+	rs.bucketCollector.LogLvl(log.LvlDebug)
+	if rs.enums {
+		rs.offsetCollector = newCollector(RecSplitLogPrefix+" "+rs.indexFileName, offsetsDir, etl.NewSortableBuffer(rs.etlBufLimit))
+		rs.offsetCollector.LogLvl(log.LvlDebug)
+	}
+}
+
+// closeCollector - keeps files of collector if Build can be resumed
+func (rs *RecSplit) closeCollector(c *etl.Collector) {
+	if c == nil {
+		return
+	}
+	if rs.resumable && rs.statePersisted && !rs.done && !rs.collision {
+		c.Detach()
+		return
+	}
+	c.Close()
+}
+
 func (rs *RecSplit) Close() {
 	if rs.indexF != nil {
 		rs.indexF.Close()
 	}
-	if rs.bucketCollector != nil {
-		rs.bucketCollector.Close()
-	}
-	if rs.offsetCollector != nil {
-		rs.offsetCollector.Close()
-	}
+	rs.closeCollector(rs.bucketCollector)
+	rs.closeCollector(rs.offsetCollector)
 }
 
 func (rs *RecSplit) LogLvl(lvl log.Lvl) { rs.lvl = lvl }
@@ -226,11 +274,14 @@ func (rs *RecSplit) ResetNextSalt() {
 	if rs.bucketCollector != nil {
 		rs.bucketCollector.Close()
 	}
-	rs.bucketCollector = etl.NewCollector(RecSplitLogPrefix+" "+rs.indexFileName, rs.tmpDir, etl.NewSortableBuffer(rs.etlBufLimit))
 	if rs.offsetCollector != nil {
 		rs.offsetCollector.Close()
-		rs.offsetCollector = etl.NewCollector(RecSplitLogPrefix+" "+rs.indexFileName, rs.tmpDir, etl.NewSortableBuffer(rs.etlBufLimit))
 	}
+	if rs.resumable {
+		_ = os.RemoveAll(rs.resumeDir())
+		rs.statePersisted = false
+	}
+	rs.newCollectors()
 	rs.currentBucket = rs.currentBucket[:0]
 	rs.currentBucketOffs = rs.currentBucketOffs[:0]
 	rs.maxOffset = 0
@@ -314,6 +365,11 @@ func (rs *RecSplit) AddKey(key []byte, offset uint64) error {
 	if rs.built {
 		return fmt.Errorf("cannot add keys after perfect hash function had been built")
 	}
+	if rs.keysAdded == 0 && rs.resumable && rs.hasResumeState() { // started from scratch instead of ResumeBuild
+		if err := os.RemoveAll(rs.resumeDir()); err != nil {
+			return err
+		}
+	}
 	rs.hasher.Reset()
 	rs.hasher.Write(key) //nolint:errcheck
 	hi, lo := rs.hasher.Sum128()
@@ -381,9 +437,8 @@ func (rs *RecSplit) recsplitCurrentBucket() error {
 			fmt.Printf("recsplitBucket(%d, %d, bitsize = %d)\n", rs.currentBucketIdx, len(rs.currentBucket), rs.gr.bitCount-bitPos)
 		}
 	} else {
-		for _, offset := range rs.currentBucketOffs {
-			binary.BigEndian.PutUint64(rs.numBuf[:], offset)
-			if _, err := rs.indexW.Write(rs.numBuf[8-rs.bytesPerRec:]); err != nil {
+		for i, offset := range rs.currentBucketOffs {
+			if err := rs.writeRecord(rs.currentBucket[i], offset); err != nil {
 				return err
 			}
 		}
@@ -429,10 +484,10 @@ func (rs *RecSplit) recsplit(level int, bucket []uint64, offsets []uint64, unary
 		for i := uint16(0); i < m; i++ {
 			j := remap16(remix(bucket[i]+salt), m)
 			rs.offsetBuffer[j] = offsets[i]
+			rs.buffer[j] = bucket[i] // not used by upper levels: they copy it to `bucket` before recursion
 		}
-		for _, offset := range rs.offsetBuffer[:m] {
-			binary.BigEndian.PutUint64(rs.numBuf[:], offset)
-			if _, err := rs.indexW.Write(rs.numBuf[8-rs.bytesPerRec:]); err != nil {
+		for j, offset := range rs.offsetBuffer[:m] {
+			if err := rs.writeRecord(rs.buffer[j], offset); err != nil {
 				return nil, err
 			}
 		}
@@ -493,8 +548,7 @@ func (rs *RecSplit) recsplit(level int, bucket []uint64, offsets []uint64, unary
 				return nil, err
 			}
 		} else if m-i == 1 {
-			binary.BigEndian.PutUint64(rs.numBuf[:], offsets[i])
-			if _, err := rs.indexW.Write(rs.numBuf[8-rs.bytesPerRec:]); err != nil {
+			if err := rs.writeRecord(bucket[i], offsets[i]); err != nil {
 				return nil, err
 			}
 		}
@@ -502,6 +556,19 @@ func (rs *RecSplit) recsplit(level int, bucket []uint64, offsets []uint64, unary
 	return unary, nil
 }
 
+// writeRecord - record of key with `fingerprint` (low 64 bits of it's hash): fingerprint (if enabled), then offset
+func (rs *RecSplit) writeRecord(fingerprint, offset uint64) error {
+	if rs.fingerprints {
+		binary.BigEndian.PutUint64(rs.numBuf[:], fingerprint)
+		if _, err := rs.indexW.Write(rs.numBuf[:]); err != nil {
+			return err
+		}
+	}
+	binary.BigEndian.PutUint64(rs.numBuf[:], offset)
+	_, err := rs.indexW.Write(rs.numBuf[8-rs.bytesPerRec:])
+	return err
+}
+
 // loadFuncBucket is required to satisfy the type etl.LoadFunc type, to use with collector.Load
 func (rs *RecSplit) loadFuncBucket(k, v []byte, _ etl.CurrentTableReader, _ etl.LoadNextFunc) error {
 	// k is the BigEndian encoding of the bucket number, and the v is the key that is assigned into that bucket
@@ -516,6 +583,9 @@ func (rs *RecSplit) loadFuncBucket(k, v []byte, _ etl.CurrentTableReader, _ etl.
 	}
 	rs.currentBucket = append(rs.currentBucket, binary.BigEndian.Uint64(k[8:]))
 	rs.currentBucketOffs = append(rs.currentBucketOffs, binary.BigEndian.Uint64(v))
+	if rs.bloom != nil {
+		rs.bloom.add(binary.BigEndian.Uint64(k[8:]))
+	}
 	return nil
 }
 
@@ -537,6 +607,11 @@ func (rs *RecSplit) Build() error {
 		return fmt.Errorf("expected keys %d, got %d", rs.keyExpectedCount, rs.keysAdded)
 	}
 	var err error
+	if rs.resumable && !rs.statePersisted {
+		if err = rs.persistResumeState(); err != nil {
+			return err
+		}
+	}
 	if rs.indexF, err = os.Create(tmpIdxFilePath); err != nil {
 		return fmt.Errorf("create index file %s: %w", rs.indexFile, err)
 	}
@@ -557,12 +632,19 @@ func (rs *RecSplit) Build() error {
 	}
 	// Write number of bytes per index record
 	rs.bytesPerRec = (bits.Len64(rs.maxOffset) + 7) / 8
-	if err = rs.indexW.WriteByte(byte(rs.bytesPerRec)); err != nil {
+	bytesPerRec := byte(rs.bytesPerRec)
+	if rs.fingerprints {
+		bytesPerRec |= recFingerprintsFlag
+	}
+	if err = rs.indexW.WriteByte(bytesPerRec); err != nil {
 		return fmt.Errorf("write bytes per record: %w", err)
 	}
 
 	rs.currentBucketIdx = math.MaxUint64 // To make sure 0 bucket is detected
-	defer rs.bucketCollector.Close()
+	if rs.bloomBitsPerKey > 0 {
+		rs.bloom = newBloomFilter(rs.keysAdded, rs.bloomBitsPerKey)
+	}
+	defer rs.closeCollector(rs.bucketCollector)
 	log.Log(rs.lvl, "[index] calculating", "file", rs.indexFileName)
 	if err := rs.bucketCollector.Load(nil, "", rs.loadFuncBucket, etl.TransformArgs{}); err != nil {
 		return err
@@ -577,15 +659,19 @@ func (rs *RecSplit) Build() error {
 		rs.indexW.Flush()
 		rs.indexF.Seek(0, 0)
 		b, _ := io.ReadAll(rs.indexF)
-		if len(b) != 9+int(rs.keysAdded)*rs.bytesPerRec {
-			panic(fmt.Errorf("expected: %d, got: %d; rs.keysAdded=%d, rs.bytesPerRec=%d, %s", 9+int(rs.keysAdded)*rs.bytesPerRec, len(b), rs.keysAdded, rs.bytesPerRec, rs.indexFile))
+		recSize := rs.bytesPerRec
+		if rs.fingerprints {
+			recSize += 8
+		}
+		if len(b) != 17+int(rs.keysAdded)*recSize {
+			panic(fmt.Errorf("expected: %d, got: %d; rs.keysAdded=%d, recSize=%d, %s", 17+int(rs.keysAdded)*recSize, len(b), rs.keysAdded, recSize, rs.indexFile))
 		}
 	}
 
 	log.Log(rs.lvl, "[index] write", "file", rs.indexFileName)
 	if rs.enums {
 		rs.offsetEf = eliasfano32.NewEliasFano(rs.keysAdded, rs.maxOffset)
-		defer rs.offsetCollector.Close()
+		defer rs.closeCollector(rs.offsetCollector)
 		if err := rs.offsetCollector.Load(nil, "", rs.loadFuncOffset, etl.TransformArgs{}); err != nil {
 			return err
 		}
@@ -625,14 +711,16 @@ func (rs *RecSplit) Build() error {
 		}
 	}
 
+	// features byte: files without bloom filter are same as before it was introduced (0 or 1 - enums)
+	var features byte
 	if rs.enums {
-		if err := rs.indexW.WriteByte(1); err != nil {
-			return fmt.Errorf("writing enums = true: %w", err)
-		}
-	} else {
-		if err := rs.indexW.WriteByte(0); err != nil {
-			return fmt.Errorf("writing enums = true: %w", err)
-		}
+		features |= featureEnums
+	}
+	if rs.bloom != nil {
+		features |= featureBloom
+	}
+	if err := rs.indexW.WriteByte(features); err != nil {
+		return fmt.Errorf("writing features: %w", err)
 	}
 	if rs.enums {
 		// Write out elias fano for offsets
@@ -640,6 +728,11 @@ func (rs *RecSplit) Build() error {
 			return fmt.Errorf("writing elias fano for offsets: %w", err)
 		}
 	}
+	if rs.bloom != nil {
+		if err := rs.bloom.write(rs.indexW); err != nil {
+			return fmt.Errorf("writing bloom filter: %w", err)
+		}
+	}
 	// Write out the size of golomb rice params
 	binary.BigEndian.PutUint16(rs.numBuf[:], uint16(len(rs.golombRice)))
 	if _, err := rs.indexW.Write(rs.numBuf[:4]); err != nil {
@@ -658,6 +751,10 @@ func (rs *RecSplit) Build() error {
 	_ = rs.indexF.Sync()
 	_ = rs.indexF.Close()
 	_ = os.Rename(tmpIdxFilePath, rs.indexFile)
+	rs.done = true
+	if rs.resumable {
+		_ = os.RemoveAll(rs.resumeDir())
+	}
 	return nil
 }
 
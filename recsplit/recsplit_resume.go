@@ -0,0 +1,162 @@
+/*
+   Copyright 2023 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package recsplit
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/ledgerwatch/erigon-lib/etl"
+	"github.com/ledgerwatch/log/v3"
+	"github.com/spaolacci/murmur3"
+)
+
+// resumeStateSize - keyExpectedCount, bucketSize, leafSize, enums, baseDataID, salt, keysAdded, maxOffset, minDelta, prevOffset
+const resumeStateSize = 8 + 8 + 2 + 1 + 8 + 4 + 8 + 8 + 8 + 8
+
+// resumeDir - bucket assignments (and offsets) of added keys, and state of RecSplit - to continue interrupted Build
+func (rs *RecSplit) resumeDir() string {
+	return filepath.Join(rs.tmpDir, rs.indexFileName+".resume")
+}
+
+func (rs *RecSplit) resumeStatePath() string { return filepath.Join(rs.resumeDir(), "state") }
+
+func (rs *RecSplit) hasResumeState() bool {
+	_, err := os.Stat(rs.resumeStatePath())
+	return err == nil
+}
+
+func (rs *RecSplit) encodeResumeState() []byte {
+	b := make([]byte, resumeStateSize)
+	binary.BigEndian.PutUint64(b[0:], rs.keyExpectedCount)
+	binary.BigEndian.PutUint64(b[8:], uint64(rs.bucketSize))
+	binary.BigEndian.PutUint16(b[16:], rs.leafSize)
+	if rs.enums {
+		b[18] = 1
+	}
+	binary.BigEndian.PutUint64(b[19:], rs.baseDataID)
+	binary.BigEndian.PutUint32(b[27:], rs.salt)
+	binary.BigEndian.PutUint64(b[31:], rs.keysAdded)
+	binary.BigEndian.PutUint64(b[39:], rs.maxOffset)
+	binary.BigEndian.PutUint64(b[47:], rs.minDelta)
+	binary.BigEndian.PutUint64(b[55:], rs.prevOffset)
+	return b
+}
+
+// persistResumeState - flushes collected keys to files and writes state next to them. Files are fsynced before state:
+// existence of state means all keys are on disk.
+func (rs *RecSplit) persistResumeState() error {
+	if err := rs.bucketCollector.FlushToDisk(); err != nil {
+		return err
+	}
+	if rs.offsetCollector != nil {
+		if err := rs.offsetCollector.FlushToDisk(); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(rs.resumeDir(), 0755); err != nil {
+		return err
+	}
+	statePath := rs.resumeStatePath()
+	tmpPath := statePath + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err = f.Write(rs.encodeResumeState()); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmpPath, statePath); err != nil {
+		return err
+	}
+	rs.statePersisted = true
+	return nil
+}
+
+// ResumeBuild - continues Build interrupted by crash or error, without adding keys again. RecSplit must be created
+// with same args (except Salt) as interrupted one and without added keys.
+// Returns false if there is nothing to resume (or state doesn't match args - then it's removed): keys must be added from scratch.
+func (rs *RecSplit) ResumeBuild() (bool, error) {
+	if !rs.resumable {
+		return false, fmt.Errorf("ResumeBuild of not resumable RecSplit: %s", rs.indexFile)
+	}
+	if rs.keysAdded > 0 {
+		return false, fmt.Errorf("ResumeBuild after AddKey: %s", rs.indexFile)
+	}
+	state, err := os.ReadFile(rs.resumeStatePath())
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	if len(state) != resumeStateSize || binary.BigEndian.Uint64(state[0:]) != rs.keyExpectedCount ||
+		binary.BigEndian.Uint64(state[8:]) != uint64(rs.bucketSize) || binary.BigEndian.Uint16(state[16:]) != rs.leafSize ||
+		(state[18] == 1) != rs.enums || binary.BigEndian.Uint64(state[19:]) != rs.baseDataID {
+		log.Warn("[index] resume state doesn't match args, removing it", "file", rs.indexFileName)
+		return false, os.RemoveAll(rs.resumeDir())
+	}
+
+	bucketCollector, err := etl.NewCollectorFromFiles(RecSplitLogPrefix+" "+rs.indexFileName, filepath.Join(rs.resumeDir(), "buckets"))
+	if err != nil {
+		return false, err
+	}
+	var offsetCollector *etl.Collector
+	if rs.enums {
+		if offsetCollector, err = etl.NewCollectorFromFiles(RecSplitLogPrefix+" "+rs.indexFileName, filepath.Join(rs.resumeDir(), "offsets")); err != nil {
+			if bucketCollector != nil {
+				bucketCollector.Detach()
+			}
+			return false, err
+		}
+	}
+	rs.salt = binary.BigEndian.Uint32(state[27:])
+	rs.hasher = murmur3.New128WithSeed(rs.salt)
+	rs.keysAdded = binary.BigEndian.Uint64(state[31:])
+	rs.maxOffset = binary.BigEndian.Uint64(state[39:])
+	rs.minDelta = binary.BigEndian.Uint64(state[47:])
+	rs.prevOffset = binary.BigEndian.Uint64(state[55:])
+
+	// collectors created by NewRecSplit are empty
+	rs.bucketCollector.Close()
+	rs.bucketCollector = bucketCollector
+	if rs.bucketCollector == nil { // no keys
+		rs.bucketCollector = etl.NewCriticalCollector(RecSplitLogPrefix+" "+rs.indexFileName, filepath.Join(rs.resumeDir(), "buckets"), etl.NewSortableBuffer(rs.etlBufLimit))
+	}
+	rs.bucketCollector.LogLvl(log.LvlDebug)
+	if rs.enums {
+		rs.offsetCollector.Close()
+		rs.offsetCollector = offsetCollector
+		if rs.offsetCollector == nil {
+			rs.offsetCollector = etl.NewCriticalCollector(RecSplitLogPrefix+" "+rs.indexFileName, filepath.Join(rs.resumeDir(), "offsets"), etl.NewSortableBuffer(rs.etlBufLimit))
+		}
+		rs.offsetCollector.LogLvl(log.LvlDebug)
+	}
+	rs.statePersisted = true
+	log.Log(rs.lvl, "[index] resuming build", "file", rs.indexFileName, "keys", rs.keysAdded)
+	return true, rs.Build()
+}
@@ -17,9 +17,13 @@
 package recsplit
 
 import (
+	"bufio"
 	"fmt"
+	"os"
 	"path/filepath"
 	"testing"
+
+	"github.com/stretchr/testify/require"
 )
 
 func TestRecSplit2(t *testing.T) {
@@ -125,6 +129,16 @@ func TestIndexLookup(t *testing.T) {
 			t.Errorf("expected offset: %d, looked up: %d", i*17, offset)
 		}
 	}
+
+	keys := make([][]byte, 100)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("key %d", i))
+	}
+	for i, offset := range NewIndexReader(idx).LookupMany(keys) {
+		if offset != uint64(i*17) {
+			t.Errorf("LookupMany: expected offset: %d, looked up: %d", i*17, offset)
+		}
+	}
 }
 
 func TestTwoLayerIndex(t *testing.T) {
@@ -165,3 +179,190 @@ func TestTwoLayerIndex(t *testing.T) {
 		}
 	}
 }
+
+func TestIndexBloom(t *testing.T) {
+	tmpDir := t.TempDir()
+	indexFile := filepath.Join(tmpDir, "index")
+	rs, err := NewRecSplit(RecSplitArgs{
+		KeyCount:        1000,
+		BucketSize:      10,
+		Salt:            0,
+		TmpDir:          tmpDir,
+		IndexFile:       indexFile,
+		LeafSize:        8,
+		Enums:           true,
+		BloomBitsPerKey: 10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1000; i++ {
+		if err = rs.AddKey([]byte(fmt.Sprintf("key %d", i)), uint64(i*17)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rs.Build(); err != nil {
+		t.Fatal(err)
+	}
+
+	idx := MustOpen(indexFile)
+	defer idx.Close()
+	if !idx.HasBloom() {
+		t.Fatal("expected bloom filter")
+	}
+	reader := NewIndexReader(idx)
+	for i := 0; i < 1000; i++ {
+		e, ok := reader.TryLookup([]byte(fmt.Sprintf("key %d", i)))
+		if !ok {
+			t.Fatalf("key %d: false negative", i)
+		}
+		if offset := idx.OrdinalLookup(e); offset != uint64(i*17) {
+			t.Errorf("expected offset: %d, looked up: %d", i*17, offset)
+		}
+	}
+	var falsePositives int
+	for i := 0; i < 10_000; i++ {
+		if _, ok := reader.TryLookup([]byte(fmt.Sprintf("absent %d", i))); ok {
+			falsePositives++
+		}
+	}
+	if falsePositives > 300 {
+		t.Errorf("too many false positives: %d of 10000", falsePositives)
+	}
+}
+
+func TestIndexFingerprints(t *testing.T) {
+	tmpDir := t.TempDir()
+	indexFile := filepath.Join(tmpDir, "index")
+	rs, err := NewRecSplit(RecSplitArgs{
+		KeyCount:     1000,
+		BucketSize:   10,
+		Salt:         0,
+		TmpDir:       tmpDir,
+		IndexFile:    indexFile,
+		LeafSize:     8,
+		Fingerprints: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1000; i++ {
+		if err = rs.AddKey([]byte(fmt.Sprintf("key %d", i)), uint64(i*17)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rs.Build(); err != nil {
+		t.Fatal(err)
+	}
+
+	idx := MustOpen(indexFile)
+	defer idx.Close()
+	reader := NewIndexReader(idx)
+	for i := 0; i < 1000; i++ {
+		key := []byte(fmt.Sprintf("key %d", i))
+		offset, ok := reader.TryLookup(key)
+		if !ok {
+			t.Fatalf("key %d: false negative", i)
+		}
+		if offset != uint64(i*17) {
+			t.Errorf("expected offset: %d, looked up: %d", i*17, offset)
+		}
+		if offset = reader.Lookup(key); offset != uint64(i*17) {
+			t.Errorf("expected offset: %d, looked up: %d", i*17, offset)
+		}
+	}
+	for i := 0; i < 10_000; i++ {
+		if _, ok := reader.TryLookup([]byte(fmt.Sprintf("absent %d", i))); ok {
+			t.Errorf("absent key %d: fingerprint matched", i)
+		}
+	}
+
+	// fingerprints survive rewrite of offsets
+	offsets := idx.ExtractOffsets()
+	for i := 0; i < 1000; i++ {
+		offsets[uint64(i*17)] = uint64(i * 3965)
+	}
+	reindexFile := filepath.Join(tmpDir, "reindex")
+	f, err := os.Create(reindexFile)
+	require.NoError(t, err)
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	require.NoError(t, idx.RewriteWithOffsets(w, offsets))
+	require.NoError(t, w.Flush())
+	require.NoError(t, f.Close())
+	reidx := MustOpen(reindexFile)
+	defer reidx.Close()
+	reader = NewIndexReader(reidx)
+	for i := 0; i < 1000; i++ {
+		offset, ok := reader.TryLookup([]byte(fmt.Sprintf("key %d", i)))
+		require.True(t, ok)
+		require.Equal(t, uint64(i*3965), offset)
+	}
+	_, ok := reader.TryLookup([]byte("absent"))
+	require.False(t, ok)
+}
+
+func TestResumeBuild(t *testing.T) {
+	tmpDir := t.TempDir()
+	indexDir := filepath.Join(tmpDir, "idx") // doesn't exist yet - first Build fails after keys are persisted
+	args := RecSplitArgs{
+		KeyCount:    100,
+		BucketSize:  10,
+		Salt:        0,
+		TmpDir:      tmpDir,
+		IndexFile:   filepath.Join(indexDir, "index"),
+		LeafSize:    8,
+		Enums:       true,
+		Resumable:   true,
+		EtlBufLimit: 256, // several temp files
+	}
+	rs, err := NewRecSplit(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resumed, err := rs.ResumeBuild(); err != nil || resumed {
+		t.Fatalf("nothing to resume expected: %t, %v", resumed, err)
+	}
+	for i := 0; i < 100; i++ {
+		if err = rs.AddKey([]byte(fmt.Sprintf("key %d", i)), uint64(i*17)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = rs.Build(); err == nil {
+		t.Fatal("test is expected to fail, index dir doesn't exist")
+	}
+	rs.Close()
+	if err = os.MkdirAll(indexDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	rs, err = NewRecSplit(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs.Close()
+	resumed, err := rs.ResumeBuild()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resumed {
+		t.Fatal("expected to resume build")
+	}
+	if _, err = os.Stat(rs.resumeDir()); !os.IsNotExist(err) {
+		t.Errorf("resume dir must be removed after build: %v", err)
+	}
+
+	idx := MustOpen(args.IndexFile)
+	defer idx.Close()
+	for i := 0; i < 100; i++ {
+		reader := NewIndexReader(idx)
+		e := reader.Lookup([]byte(fmt.Sprintf("key %d", i)))
+		if e != uint64(i) {
+			t.Errorf("expected enumeration: %d, lookup up: %d", i, e)
+		}
+		offset := idx.OrdinalLookup(e)
+		if offset != uint64(i*17) {
+			t.Errorf("expected offset: %d, looked up: %d", i*17, offset)
+		}
+	}
+}
@@ -19,6 +19,8 @@ package rlp
 import (
 	"encoding/binary"
 	"math/bits"
+
+	"github.com/holiman/uint256"
 )
 
 // General design:
@@ -135,6 +137,17 @@ func EncodeU64(i uint64, to []byte) int {
 	return 1 + l
 }
 
+// EncodeU256 - zero-allocation encoding of uint256, `to` buffer must be at least U256Len(z) long
+func EncodeU256(z *uint256.Int, to []byte) int {
+	if z.IsUint64() {
+		return EncodeU64(z.Uint64(), to)
+	}
+	l := (z.BitLen() + 7) / 8
+	to[0] = 128 + byte(l)
+	z.WriteToSlice(to[1 : 1+l])
+	return 1 + l
+}
+
 func StringLen(sLen int) int {
 	switch {
 	case sLen > 56:
@@ -185,6 +198,14 @@ func EncodeHash(h, to []byte) int {
 	return 33
 }
 
+// EncodeAddress assumes that `to` buffer is already 21bytes long
+func EncodeAddress(a, to []byte) int {
+	_ = to[20] // early bounds check to guarantee safety of writes below
+	to[0] = 128 + 20
+	copy(to[1:21], a[:20])
+	return 21
+}
+
 func EncodeHashes(hashes []byte, encodeBuf []byte) int {
 	pos := 0
 	hashesLen := len(hashes) / 32 * 33
@@ -236,3 +236,38 @@ func ParseHash(payload []byte, pos int, hashbuf []byte) (int, error) {
 }
 
 const ParseHashErrorPrefix = "parse hash payload"
+
+func ParseAddress(payload []byte, pos int, addrbuf []byte) (int, error) {
+	pos, err := StringOfLen(payload, pos, 20)
+	if err != nil {
+		return 0, fmt.Errorf("%s: address len: %w", ParseAddressErrorPrefix, err)
+	}
+	copy(addrbuf, payload[pos:pos+20])
+	return pos + 20, nil
+}
+
+const ParseAddressErrorPrefix = "parse address payload"
+
+// ListItems - visits items of list at given position without allocations. Visitor receives position and len of
+// item's data (without prefix). Returns position right after the list.
+func ListItems(payload []byte, pos int, visit func(dataPos, dataLen int, isList bool) error) (int, error) {
+	listPos, listLen, err := List(payload, pos)
+	if err != nil {
+		return 0, err
+	}
+	end := listPos + listLen
+	for p := listPos; p < end; {
+		dataPos, dataLen, isList, err := Prefix(payload, p)
+		if err != nil {
+			return 0, err
+		}
+		if dataPos+dataLen > end {
+			return 0, fmt.Errorf("%w: list item exceeds list boundary", ErrParse)
+		}
+		if err = visit(dataPos, dataLen, isList); err != nil {
+			return 0, err
+		}
+		p = dataPos + dataLen
+	}
+	return end, nil
+}
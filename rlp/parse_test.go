@@ -6,6 +6,7 @@ import (
 
 	"github.com/holiman/uint256"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/ledgerwatch/erigon-lib/common/hexutility"
 )
@@ -90,3 +91,44 @@ func TestPrimitives(t *testing.T) {
 		})
 	}
 }
+
+func TestEncodeU256(t *testing.T) {
+	for _, tt := range parseU256Tests {
+		if tt.expectErr != nil {
+			continue
+		}
+		buf := make([]byte, 33)
+		n := EncodeU256(tt.expectRes, buf)
+		require.Equal(t, U256Len(tt.expectRes), n)
+		res := new(uint256.Int)
+		pos, err := U256(buf[:n], 0, res)
+		require.NoError(t, err)
+		require.Equal(t, n, pos)
+		require.Equal(t, tt.expectRes, res)
+	}
+}
+
+func TestListItems(t *testing.T) {
+	addr := hexutility.MustDecodeHex("0102030405060708090a0b0c0d0e0f1011121314")
+	buf := make([]byte, 64)
+	pos := EncodeListPrefix(21+1+3, buf)
+	pos += EncodeAddress(addr, buf[pos:])
+	pos += EncodeU64(7, buf[pos:])
+	pos += EncodeU64(1024, buf[pos:])
+
+	var items []int
+	end, err := ListItems(buf[:pos], 0, func(dataPos, dataLen int, isList bool) error {
+		require.False(t, isList)
+		items = append(items, dataLen)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, pos, end)
+	require.Equal(t, []int{20, 1, 2}, items)
+
+	parsed := make([]byte, 20)
+	p, err := ParseAddress(buf, 1, parsed)
+	require.NoError(t, err)
+	require.Equal(t, 22, p)
+	require.Equal(t, addr, parsed)
+}
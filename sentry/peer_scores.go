@@ -0,0 +1,248 @@
+/*
+   Copyright 2022 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sentry
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ledgerwatch/erigon-lib/gointerfaces"
+	"github.com/ledgerwatch/erigon-lib/kv"
+	"github.com/ledgerwatch/erigon-lib/types"
+)
+
+// PeerEvent - kind of peer usefulness (or harm) reported to PeerScorer
+type PeerEvent uint8
+
+const (
+	ValidHeaders PeerEvent = iota // peer delivered headers which passed validation
+	ValidBodies                   // peer delivered bodies which passed validation
+	ValidTxs                      // peer delivered txs accepted to parsing
+	Penalty                       // peer delivered invalid data or broke protocol
+)
+
+func (e PeerEvent) String() string {
+	switch e {
+	case ValidHeaders:
+		return "headers"
+	case ValidBodies:
+		return "bodies"
+	case ValidTxs:
+		return "txs"
+	case Penalty:
+		return "penalty"
+	default:
+		return fmt.Sprintf("unknown(%d)", e)
+	}
+}
+
+// PeerScorer - library components (txpool fetch, etc...) report peer usefulness here,
+// embedding node implements it or uses PeerScores
+type PeerScorer interface {
+	Record(peerID types.PeerID, event PeerEvent, amount uint64)
+}
+
+// PeerScore - counters of events reported about peer. Survive restarts by PeerScores.Flush
+type PeerScore struct {
+	Headers, Bodies, Txs, Penalties uint64
+	LastSeen                        uint64 // unix seconds of last event
+}
+
+// Weights of events in PeerScore.Value
+const (
+	TxsPerPoint   = 16
+	PenaltyPoints = 100
+)
+
+// Value - single number to compare peers. Negative value is good reason to ban peer
+func (s PeerScore) Value() int64 {
+	return int64(s.Headers+s.Bodies+s.Txs/TxsPerPoint) - int64(s.Penalties*PenaltyPoints)
+}
+
+const peerScoreSize = 5 * 8
+
+func (s PeerScore) encode(buf []byte) []byte {
+	buf = append(buf[:0], make([]byte, peerScoreSize)...)
+	binary.BigEndian.PutUint64(buf, s.Headers)
+	binary.BigEndian.PutUint64(buf[8:], s.Bodies)
+	binary.BigEndian.PutUint64(buf[16:], s.Txs)
+	binary.BigEndian.PutUint64(buf[24:], s.Penalties)
+	binary.BigEndian.PutUint64(buf[32:], s.LastSeen)
+	return buf
+}
+
+func decodePeerScore(v []byte) (PeerScore, error) {
+	if len(v) != peerScoreSize {
+		return PeerScore{}, fmt.Errorf("unexpected peer score size: %d", len(v))
+	}
+	return PeerScore{
+		Headers:   binary.BigEndian.Uint64(v),
+		Bodies:    binary.BigEndian.Uint64(v[8:]),
+		Txs:       binary.BigEndian.Uint64(v[16:]),
+		Penalties: binary.BigEndian.Uint64(v[24:]),
+		LastSeen:  binary.BigEndian.Uint64(v[32:]),
+	}, nil
+}
+
+// ScoredPeer - result of PeerScores queries
+type ScoredPeer struct {
+	ID    [64]byte
+	Score PeerScore
+}
+
+// PeerScores - thread-safe PeerScorer which keeps scores in memory and persists them into kv.PeerScores table of SentryDB.
+// Embedding node makes ban/priority decisions by Get/Best/Worst.
+type PeerScores struct {
+	db     kv.RwDB
+	lock   sync.RWMutex
+	scores map[[64]byte]PeerScore
+	dirty  map[[64]byte]struct{}
+	now    func() time.Time
+}
+
+var _ PeerScorer = (*PeerScores)(nil)
+
+// NewPeerScores - loads scores persisted by previous Flush
+func NewPeerScores(ctx context.Context, db kv.RwDB) (*PeerScores, error) {
+	s := &PeerScores{db: db, scores: map[[64]byte]PeerScore{}, dirty: map[[64]byte]struct{}{}, now: time.Now}
+	if err := db.View(ctx, func(tx kv.Tx) error {
+		return tx.ForEach(kv.PeerScores, nil, func(k, v []byte) error {
+			if len(k) != 64 {
+				return fmt.Errorf("unexpected peer id size: %d", len(k))
+			}
+			score, err := decodePeerScore(v)
+			if err != nil {
+				return err
+			}
+			s.scores[*(*[64]byte)(k)] = score
+			return nil
+		})
+	}); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *PeerScores) Record(peerID types.PeerID, event PeerEvent, amount uint64) {
+	id := gointerfaces.ConvertH512ToHash(peerID)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	score := s.scores[id]
+	switch event {
+	case ValidHeaders:
+		score.Headers += amount
+	case ValidBodies:
+		score.Bodies += amount
+	case ValidTxs:
+		score.Txs += amount
+	case Penalty:
+		score.Penalties += amount
+	default:
+		return
+	}
+	score.LastSeen = uint64(s.now().Unix())
+	s.scores[id] = score
+	s.dirty[id] = struct{}{}
+}
+
+func (s *PeerScores) Get(peerID types.PeerID) (PeerScore, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	score, ok := s.scores[gointerfaces.ConvertH512ToHash(peerID)]
+	return score, ok
+}
+
+// Best - n peers with highest Value, for prioritisation of requests
+func (s *PeerScores) Best(n int) []ScoredPeer {
+	res := s.sorted()
+	if n < len(res) {
+		res = res[:n]
+	}
+	return res
+}
+
+// Worst - peers with Value below threshold, worst first. Candidates for ban.
+func (s *PeerScores) Worst(threshold int64) []ScoredPeer {
+	res := s.sorted()
+	i := sort.Search(len(res), func(i int) bool { return res[i].Score.Value() < threshold })
+	res = res[i:]
+	for l, r := 0, len(res)-1; l < r; l, r = l+1, r-1 {
+		res[l], res[r] = res[r], res[l]
+	}
+	return res
+}
+
+// sorted - by Value descending
+func (s *PeerScores) sorted() []ScoredPeer {
+	s.lock.RLock()
+	res := make([]ScoredPeer, 0, len(s.scores))
+	for id, score := range s.scores {
+		res = append(res, ScoredPeer{ID: id, Score: score})
+	}
+	s.lock.RUnlock()
+	sort.Slice(res, func(i, j int) bool {
+		vi, vj := res[i].Score.Value(), res[j].Score.Value()
+		if vi != vj {
+			return vi > vj
+		}
+		return string(res[i].ID[:]) < string(res[j].ID[:])
+	})
+	return res
+}
+
+// Forget - drops score of peer, for example after ban expiration
+func (s *PeerScores) Forget(peerID types.PeerID) {
+	id := gointerfaces.ConvertH512ToHash(peerID)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.scores, id)
+	s.dirty[id] = struct{}{}
+}
+
+// Flush - persists scores changed since previous Flush
+func (s *PeerScores) Flush(ctx context.Context) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(s.dirty) == 0 {
+		return nil
+	}
+	if err := s.db.Update(ctx, func(tx kv.RwTx) error {
+		var buf []byte
+		for id := range s.dirty {
+			score, ok := s.scores[id]
+			if !ok {
+				if err := tx.Delete(kv.PeerScores, id[:]); err != nil {
+					return err
+				}
+				continue
+			}
+			buf = score.encode(buf)
+			if err := tx.Put(kv.PeerScores, id[:], buf); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	s.dirty = map[[64]byte]struct{}{}
+	return nil
+}
@@ -0,0 +1,67 @@
+/*
+   Copyright 2022 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sentry
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ledgerwatch/erigon-lib/gointerfaces"
+	"github.com/ledgerwatch/erigon-lib/kv/memdb"
+)
+
+func TestPeerScores(t *testing.T) {
+	ctx := context.Background()
+	db := memdb.NewTestSentrylDB(t)
+	s, err := NewPeerScores(ctx, db)
+	require.NoError(t, err)
+
+	good, bad, txs := gointerfaces.ConvertHashToH512([64]byte{1}), gointerfaces.ConvertHashToH512([64]byte{2}), gointerfaces.ConvertHashToH512([64]byte{3})
+	s.Record(good, ValidHeaders, 10)
+	s.Record(good, ValidBodies, 5)
+	s.Record(bad, ValidHeaders, 10)
+	s.Record(bad, Penalty, 1)
+	s.Record(txs, ValidTxs, 160)
+
+	score, ok := s.Get(good)
+	require.True(t, ok)
+	require.Equal(t, int64(15), score.Value())
+	require.NotZero(t, score.LastSeen)
+	_, ok = s.Get(gointerfaces.ConvertHashToH512([64]byte{4}))
+	require.False(t, ok)
+
+	best := s.Best(2)
+	require.Len(t, best, 2)
+	require.Equal(t, [64]byte{1}, best[0].ID)
+	require.Equal(t, [64]byte{3}, best[1].ID)
+	worst := s.Worst(0)
+	require.Len(t, worst, 1)
+	require.Equal(t, [64]byte{2}, worst[0].ID)
+	require.Equal(t, int64(10-PenaltyPoints), worst[0].Score.Value())
+
+	// scores survive restart, forgotten peers are deleted
+	s.Forget(txs)
+	require.NoError(t, s.Flush(ctx))
+	s2, err := NewPeerScores(ctx, db)
+	require.NoError(t, err)
+	require.Len(t, s2.Best(10), 2)
+	score2, ok := s2.Get(good)
+	require.True(t, ok)
+	require.Equal(t, score, score2)
+}
@@ -32,6 +32,8 @@ import (
 	"github.com/ledgerwatch/log/v3"
 
 	"github.com/ledgerwatch/erigon-lib/commitment"
+	"github.com/ledgerwatch/erigon-lib/common"
+	"github.com/ledgerwatch/erigon-lib/common/datadir"
 	"github.com/ledgerwatch/erigon-lib/common/length"
 	"github.com/ledgerwatch/erigon-lib/kv"
 )
@@ -53,6 +55,7 @@ type Aggregator struct {
 	txNum           uint64
 	seekTxNum       uint64
 	blockNum        uint64
+	blockHash       []byte
 	commitFn        func(txNum uint64) error
 	rwTx            kv.RwTx
 	stats           FilesStats
@@ -60,6 +63,11 @@ type Aggregator struct {
 	defaultCtx      *AggregatorContext
 }
 
+// NewAggregatorFromDirs - files in dirs.SnapHistory, temporary files in dirs.Tmp
+func NewAggregatorFromDirs(dirs datadir.Dirs, aggregationStep uint64) (*Aggregator, error) {
+	return NewAggregator(dirs.SnapHistory, dirs.Tmp, aggregationStep)
+}
+
 func NewAggregator(
 	dir, tmpdir string,
 	aggregationStep uint64,
@@ -108,7 +116,7 @@ func NewAggregator(
 	closeAgg = false
 
 	a.defaultCtx = a.MakeContext()
-	a.commitment.patriciaTrie.ResetFns(a.defaultCtx.branchFn, a.defaultCtx.accountFn, a.defaultCtx.storageFn)
+	a.commitment.ResetFns(a.defaultCtx.branchFn, a.defaultCtx.accountFn, a.defaultCtx.storageFn)
 	return a, nil
 }
 
@@ -187,6 +195,15 @@ func (a *Aggregator) SetTxNum(txNum uint64) {
 // todo useless
 func (a *Aggregator) SetBlockNum(bn uint64) { a.blockNum = bn }
 
+// SetBlockHash - hash of current block, stored together with commitment state
+func (a *Aggregator) SetBlockHash(h []byte) { a.blockHash = common.Copy(h) }
+
+// CommitmentBlock - block of commitment state: set by SetBlockNum/SetBlockHash or restored by SeekCommitment.
+// After restart caller can compare it with canonical chain to detect state which doesn't belong to it.
+func (a *Aggregator) CommitmentBlock() (blockNum uint64, blockHash []byte) {
+	return a.blockNum, a.blockHash
+}
+
 func (a *Aggregator) SetWorkers(i int) {
 	a.accounts.workers = i
 	a.storage.workers = i
@@ -202,6 +219,16 @@ func (a *Aggregator) SetCommitmentMode(mode CommitmentMode) {
 	a.commitment.mode = mode
 }
 
+// SetCommitmentCompressValues - see DomainCommitted.SetCompressValues
+func (a *Aggregator) SetCommitmentCompressValues(v bool) {
+	a.commitment.SetCompressValues(v)
+}
+
+// SetCommitmentBatchSize - see DomainCommitted.SetBatchSize
+func (a *Aggregator) SetCommitmentBatchSize(n int) {
+	a.commitment.SetBatchSize(n)
+}
+
 func (a *Aggregator) EndTxNumMinimax() uint64 {
 	min := a.accounts.endTxNumMinimax()
 	if txNum := a.storage.endTxNumMinimax(); txNum < min {
@@ -230,13 +257,15 @@ func (a *Aggregator) EndTxNumMinimax() uint64 {
 
 func (a *Aggregator) SeekCommitment() (txNum uint64, err error) {
 	filesTxNum := a.EndTxNumMinimax()
-	txNum, err = a.commitment.SeekCommitment(a.aggregationStep, filesTxNum)
+	latest, err := a.commitment.seekCommitment(a.aggregationStep, filesTxNum)
 	if err != nil {
 		return 0, err
 	}
-	if txNum == 0 {
-		return
+	if latest == nil || latest.txNum == 0 {
+		return 0, nil
 	}
+	txNum = latest.txNum
+	a.blockNum, a.blockHash = latest.blockNum, latest.blockHash
 	a.seekTxNum = txNum + 1
 	return txNum + 1, nil
 }
@@ -812,7 +841,7 @@ func (a *Aggregator) ComputeCommitment(saveStateAfter, trace bool) (rootHash []b
 	}
 
 	if saveStateAfter {
-		if err := a.commitment.storeCommitmentState(a.blockNum, a.txNum); err != nil {
+		if err := a.commitment.storeCommitmentState(a.blockNum, a.blockHash, a.txNum, rootHash); err != nil {
 			return nil, err
 		}
 	}
@@ -0,0 +1,125 @@
+/*
+   Copyright 2023 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package state
+
+import (
+	"encoding/binary"
+	"fmt"
+	"runtime"
+	"strings"
+
+	"github.com/ledgerwatch/log/v3"
+
+	common2 "github.com/ledgerwatch/erigon-lib/common"
+	"github.com/ledgerwatch/erigon-lib/common/dbg"
+	"github.com/ledgerwatch/erigon-lib/kv"
+)
+
+// AggStatus - snapshot of AggregatorV3 state for diagnostics (serializable), see Status
+type AggStatus struct {
+	MaxTxNum    uint64 `json:"maxTxNum"`    // end of files, see EndTxNumMinimax
+	MaxBlockNum uint64 `json:"maxBlockNum"` // block of MaxTxNum
+
+	// FirstHistoryIdxBlockInDB - block of first txNum of accounts history index in DB (0 if DB has no history)
+	FirstHistoryIdxBlockInDB uint64 `json:"firstHistoryIdxBlockInDB"`
+
+	Files map[string][]FileStatus `json:"files"` // entity (accounts, storage, code, logaddrs, ...) -> inverted index files
+
+	Steps              []StepIOStatus `json:"steps"`              // last built steps, oldest first
+	MergeAmplification float64        `json:"mergeAmplification"` // see StepIOStatus.MergeAmplification
+
+	Alloc uint64 `json:"alloc"` // runtime.MemStats
+	Sys   uint64 `json:"sys"`
+}
+
+// FileStatus - one file of entity
+type FileStatus struct {
+	FromStep   uint64 `json:"fromStep"`
+	ToStep     uint64 `json:"toStep"`
+	ToBlockNum uint64 `json:"toBlockNum"` // block of end txNum of file
+	Indexed    bool   `json:"indexed"`
+}
+
+// Status - `tx2block` converts txNum to blockNum
+func (a *AggregatorV3) Status(tx kv.Tx, tx2block func(endTxNumMinimax uint64) uint64) (AggStatus, error) {
+	s := AggStatus{MaxTxNum: a.maxTxNum.Load(), Files: map[string][]FileStatus{}}
+	if s.MaxTxNum > 0 {
+		s.MaxBlockNum = tx2block(s.MaxTxNum)
+	}
+	for _, ii := range []*InvertedIndex{a.accounts.InvertedIndex, a.storage.InvertedIndex, a.code.InvertedIndex, a.logAddrs, a.logTopics, a.tracesFrom, a.tracesTo} {
+		files := make([]FileStatus, 0, ii.files.Len())
+		ii.files.Ascend(func(it *filesItem) bool {
+			files = append(files, FileStatus{
+				FromStep:   it.startTxNum / a.aggregationStep,
+				ToStep:     it.endTxNum / a.aggregationStep,
+				ToBlockNum: tx2block(it.endTxNum),
+				Indexed:    it.index != nil,
+			})
+			return true
+		})
+		s.Files[ii.filenameBase] = files
+	}
+	s.Steps, s.MergeAmplification = a.io.report()
+
+	c, err := tx.CursorDupSort(a.accounts.InvertedIndex.indexTable)
+	if err != nil {
+		return s, fmt.Errorf("Status: %w", err)
+	}
+	defer c.Close()
+	_, v, err := c.First()
+	if err != nil {
+		return s, fmt.Errorf("Status: %w", err)
+	}
+	if len(v) != 0 {
+		s.FirstHistoryIdxBlockInDB = tx2block(binary.BigEndian.Uint64(v))
+	}
+
+	var m runtime.MemStats
+	dbg.ReadMemStats(&m)
+	s.Alloc, s.Sys = m.Alloc, m.Sys
+	return s, nil
+}
+
+// logArgs - key-value pairs for log.Info
+func (s AggStatus) logArgs() []interface{} {
+	accounts := s.Files["accounts"]
+	str := make([]string, 0, len(accounts))
+	for _, f := range accounts {
+		str = append(str, fmt.Sprintf("%d=%dK", f.ToStep, f.ToBlockNum/1_000))
+	}
+	return []interface{}{
+		"blocks", fmt.Sprintf("%dk", (s.MaxBlockNum+1)/1000),
+		"txs", fmt.Sprintf("%dm", s.MaxTxNum/1_000_000),
+		"txNum2blockNum", strings.Join(str, ","),
+		"first_history_idx_in_db", s.FirstHistoryIdxBlockInDB,
+		"merge_amplification", fmt.Sprintf("%.2f", s.MergeAmplification),
+		"alloc", common2.ByteCount(s.Alloc), "sys", common2.ByteCount(s.Sys),
+	}
+}
+
+// LogStats - logs Status
+func (a *AggregatorV3) LogStats(tx kv.Tx, tx2block func(endTxNumMinimax uint64) uint64) error {
+	if a.maxTxNum.Load() == 0 {
+		return nil
+	}
+	s, err := a.Status(tx, tx2block)
+	if err != nil {
+		return err
+	}
+	log.Info("[Snapshots] History Stat", s.logArgs()...)
+	return nil
+}
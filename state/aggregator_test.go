@@ -1,9 +1,11 @@
 package state
 
 import (
+	"bytes"
 	"context"
 	"encoding/binary"
 	"fmt"
+	"math"
 	"math/rand"
 	"os"
 	"path/filepath"
@@ -13,7 +15,9 @@ import (
 	"github.com/holiman/uint256"
 	"github.com/ledgerwatch/log/v3"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/sha3"
 
+	"github.com/ledgerwatch/erigon-lib/commitment"
 	"github.com/ledgerwatch/erigon-lib/common/length"
 	"github.com/ledgerwatch/erigon-lib/kv"
 	"github.com/ledgerwatch/erigon-lib/kv/mdbx"
@@ -420,3 +424,365 @@ func Test_EncodeCommitmentState(t *testing.T) {
 	require.EqualValues(t, cs.txNum, dec.txNum)
 	require.EqualValues(t, cs.trieState, dec.trieState)
 }
+
+func Test_DecodeCommitmentStateVersions(t *testing.T) {
+	trieState := []byte{1, 2, 3, 4, 5}
+
+	// v0 - no version header
+	v0 := make([]byte, 18, 18+len(trieState)+2)
+	binary.BigEndian.PutUint64(v0[:8], 100)
+	binary.BigEndian.PutUint64(v0[8:16], 7)
+	binary.BigEndian.PutUint16(v0[16:18], uint16(len(trieState)))
+	v0 = append(v0, trieState...)
+
+	var dec commitmentState
+	require.NoError(t, dec.Decode(v0))
+	require.EqualValues(t, 100, dec.txNum)
+	require.EqualValues(t, 7, dec.blockNum)
+	require.EqualValues(t, trieState, dec.trieState)
+	require.Zero(t, dec.parts)
+	require.Nil(t, dec.rootHash)
+
+	require.NoError(t, dec.Decode(append(v0, 0, 3)))
+	require.EqualValues(t, 3, dec.parts)
+
+	// latest version
+	cs := commitmentState{txNum: 100, blockNum: 7, trieState: trieState, parts: 2,
+		blockHash: bytes.Repeat([]byte{0xbb}, length.Hash), rootHash: bytes.Repeat([]byte{0xaa}, length.Hash)}
+	enc, err := cs.Encode()
+	require.NoError(t, err)
+	require.EqualValues(t, commitmentStateVersionMark, enc[0])
+	require.NoError(t, dec.Decode(enc))
+	require.EqualValues(t, cs, dec)
+
+	enc[1] = commitmentStateVersion + 1
+	require.Error(t, dec.Decode(enc))
+}
+
+func TestAggregator_MultiPartCommitmentState(t *testing.T) {
+	defer func(size int) { commitmentStatePartSize = size }(commitmentStatePartSize)
+	commitmentStatePartSize = 64
+
+	aggStep := uint64(16)
+	_, db, agg := testDbAndAggregator(t, 0, aggStep)
+	tx, err := db.BeginRw(context.Background())
+	require.NoError(t, err)
+	defer tx.Rollback()
+	agg.SetTx(tx)
+	defer agg.StartWrites().FinishWrites()
+
+	rnd := rand.New(rand.NewSource(0))
+	txs := uint64(20)
+	for txNum := uint64(1); txNum <= txs; txNum++ {
+		agg.SetTxNum(txNum)
+		addr := make([]byte, length.Addr)
+		_, err = rnd.Read(addr)
+		require.NoError(t, err)
+		err = agg.UpdateAccountData(addr, EncodeAccountBytes(txNum, uint256.NewInt(txNum), nil, 0))
+		require.NoError(t, err)
+	}
+	rootHash, err := agg.ComputeCommitment(true, false)
+	require.NoError(t, err)
+	expected, err := agg.commitment.patriciaTrie.EncodeCurrentState(nil)
+	require.NoError(t, err)
+	require.Greater(t, len(expected), 2*commitmentStatePartSize)
+
+	agg.commitment.patriciaTrie.Reset()
+	latestTx, err := agg.commitment.SeekCommitment(aggStep, 2*aggStep)
+	require.NoError(t, err)
+	require.EqualValues(t, txs, latestTx)
+
+	restored, err := agg.commitment.patriciaTrie.EncodeCurrentState(nil)
+	require.NoError(t, err)
+	require.Equal(t, expected, restored)
+	restoredHash, err := agg.commitment.patriciaTrie.RootHash()
+	require.NoError(t, err)
+	require.Equal(t, rootHash, restoredHash)
+
+	// undecodable state is an error, not absence of state
+	var stepbuf [2]byte
+	binary.BigEndian.PutUint16(stepbuf[:], uint16(txs/aggStep))
+	agg.SetTxNum(txs)
+	require.NoError(t, agg.commitment.Put(keyCommitmentState, stepbuf[:], []byte{commitmentStateVersionMark, commitmentStateVersion + 1}))
+	_, err = agg.commitment.SeekCommitment(aggStep, 2*aggStep)
+	require.ErrorContains(t, err, "commitment state of step 1")
+}
+
+func TestAggregator_StateDelta(t *testing.T) {
+	ctx := context.Background()
+	write := func(agg *Aggregator, db kv.RwDB, fromTxNum, toTxNum uint64) {
+		tx, err := db.BeginRw(ctx)
+		require.NoError(t, err)
+		defer tx.Rollback()
+		agg.SetTx(tx)
+		defer agg.StartWrites().FinishWrites()
+		for txNum := fromTxNum; txNum < toTxNum; txNum++ {
+			agg.SetTxNum(txNum)
+			addr := make([]byte, length.Addr)
+			addr[0] = byte(txNum % 7)
+			v := make([]byte, 8)
+			binary.BigEndian.PutUint64(v, txNum)
+			require.NoError(t, agg.UpdateAccountData(addr, v))
+			require.NoError(t, agg.WriteAccountStorage(addr, make([]byte, length.Hash), v))
+			if txNum%5 == 0 {
+				require.NoError(t, agg.UpdateAccountCode(addr, nil)) // deletion
+			} else {
+				require.NoError(t, agg.UpdateAccountCode(addr, v))
+			}
+			require.NoError(t, agg.UpdateCommitmentData(addr[:1], v))
+		}
+		require.NoError(t, agg.Flush(ctx))
+		require.NoError(t, tx.Commit())
+	}
+
+	_, db1, agg1 := testDbAndAggregator(t, 0, 100)
+	_, db2, agg2 := testDbAndAggregator(t, 0, 100)
+	write(agg1, db1, 1, 30)
+	write(agg2, db2, 1, 10)
+
+	roTx1, err := db1.BeginRo(ctx)
+	require.NoError(t, err)
+	defer roTx1.Rollback()
+	ac1 := agg1.MakeContext()
+	delta, err := ac1.StateDelta(10, 30, roTx1)
+	require.NoError(t, err)
+	require.Len(t, delta.Accounts, 7)
+	require.Len(t, delta.Commitment, 7)
+
+	var buf bytes.Buffer
+	n, err := delta.WriteTo(&buf)
+	require.NoError(t, err)
+	require.EqualValues(t, buf.Len(), n)
+	delta, err = ReadStateDelta(&buf)
+	require.NoError(t, err)
+	_, err = ReadStateDelta(bytes.NewReader([]byte("garbage")))
+	require.ErrorIs(t, err, ErrStateDeltaFormat)
+
+	tx2, err := db2.BeginRw(ctx)
+	require.NoError(t, err)
+	defer tx2.Rollback()
+	agg2.SetTx(tx2)
+	agg2.StartWrites()
+	require.NoError(t, agg2.ApplyStateDelta(delta))
+	require.NoError(t, agg2.Flush(ctx))
+	agg2.FinishWrites()
+
+	ac2 := agg2.MakeContext()
+	for i := 0; i < 7; i++ {
+		addr := make([]byte, length.Addr)
+		addr[0] = byte(i)
+		v1, err := ac1.ReadAccountData(addr, roTx1)
+		require.NoError(t, err)
+		v2, err := ac2.ReadAccountData(addr, tx2)
+		require.NoError(t, err)
+		require.Equal(t, v1, v2)
+
+		v1, err = ac1.ReadAccountStorage(addr, make([]byte, length.Hash), roTx1)
+		require.NoError(t, err)
+		v2, err = ac2.ReadAccountStorage(addr, make([]byte, length.Hash), tx2)
+		require.NoError(t, err)
+		require.Equal(t, v1, v2)
+
+		v1, err = ac1.ReadAccountCode(addr, roTx1)
+		require.NoError(t, err)
+		v2, err = ac2.ReadAccountCode(addr, tx2)
+		require.NoError(t, err)
+		require.Equal(t, v1, v2)
+
+		v1, err = ac1.ReadCommitment(addr[:1], roTx1)
+		require.NoError(t, err)
+		v2, err = ac2.ReadCommitment(addr[:1], tx2)
+		require.NoError(t, err)
+		require.Equal(t, v1, v2)
+	}
+}
+
+func TestAggregator_GetProof(t *testing.T) {
+	ctx := context.Background()
+	aggStep := uint64(16)
+	_, db, agg := testDbAndAggregator(t, 0, aggStep)
+	tx, err := db.BeginRw(ctx)
+	require.NoError(t, err)
+	defer func() {
+		if tx != nil {
+			tx.Rollback()
+		}
+	}()
+	agg.SetTx(tx)
+	agg.StartWrites()
+
+	addrs := make([][]byte, 5) // last one is never written
+	for i := range addrs {
+		addrs[i] = make([]byte, length.Addr)
+		addrs[i][0] = byte(i + 1)
+	}
+	locs := make([][]byte, 4) // last one is never written
+	for i := range locs {
+		locs[i] = make([]byte, length.Hash)
+		locs[i][length.Hash-1] = byte(i + 1)
+	}
+	roots := map[uint64][]byte{} // first txNum of block -> state root before it
+	for txNum := uint64(1); txNum <= 4*aggStep; txNum++ {
+		agg.SetTxNum(txNum)
+		addr := addrs[txNum%4]
+		require.NoError(t, agg.UpdateAccountData(addr, EncodeAccountBytes(txNum, uint256.NewInt(txNum*100), nil, 0)))
+		if txNum%4 != 0 { // one account without storage
+			v := make([]byte, 8)
+			binary.BigEndian.PutUint64(v, txNum)
+			require.NoError(t, agg.WriteAccountStorage(addr, locs[txNum%3], v))
+		}
+		if txNum%4 == 0 {
+			root, err := agg.ComputeCommitment(true, false)
+			require.NoError(t, err)
+			roots[txNum+1] = root
+		}
+		require.NoError(t, agg.FinishTx())
+	}
+	require.NoError(t, agg.Flush(ctx))
+	agg.FinishWrites()
+	require.NoError(t, tx.Commit())
+	tx = nil
+
+	roTx, err := db.BeginRo(ctx)
+	require.NoError(t, err)
+	defer roTx.Rollback()
+	ac := agg.MakeContext()
+	keccak := sha3.NewLegacyKeccak256()
+	hashOf := func(node []byte) []byte {
+		keccak.Reset()
+		keccak.Write(node)
+		return keccak.Sum(nil)
+	}
+	checkNodes := func(root []byte, nodes [][]byte) {
+		require.NotEmpty(t, nodes)
+		require.Equal(t, root, hashOf(nodes[0]))
+		for i := 1; i < len(nodes); i++ {
+			require.True(t, bytes.Contains(nodes[i-1], hashOf(nodes[i])), "node %d is not referenced by parent", i)
+		}
+	}
+	for txNum, root := range roots {
+		for i, addr := range addrs {
+			p, err := ac.GetProof(addr, locs, txNum, roTx)
+			require.NoError(t, err)
+			checkNodes(root, p.AccountProof)
+			enc, err := ac.ReadAccountDataBeforeTxNum(addr, txNum, roTx)
+			require.NoError(t, err)
+			if i == len(addrs)-1 || len(enc) == 0 {
+				require.Zero(t, p.Nonce)
+				require.Equal(t, commitment.EmptyRootHash, p.StorageHash)
+			} else {
+				nonce, balance, _ := DecodeAccountBytes(enc)
+				require.Equal(t, nonce, p.Nonce)
+				require.Equal(t, balance, &p.Balance)
+			}
+			require.Len(t, p.StorageProof, len(locs))
+			for j, sp := range p.StorageProof {
+				v, err := ac.ReadAccountStorageBeforeTxNum(addr, locs[j], txNum, roTx)
+				require.NoError(t, err)
+				require.Equal(t, len(v) > 0, sp.Value != nil, "txNum %d, addr %x, loc %x", txNum, addr, locs[j])
+				require.Equal(t, string(v), string(sp.Value))
+				if bytes.Equal(p.StorageHash, commitment.EmptyRootHash) {
+					require.Empty(t, sp.Proof)
+				} else {
+					checkNodes(p.StorageHash, sp.Proof)
+				}
+			}
+		}
+	}
+}
+
+func TestAggregator_CommitmentBatches(t *testing.T) {
+	ctx := context.Background()
+	aggStep := uint64(16)
+	run := func(mode CommitmentMode, batchSize int) (roots [][]byte) {
+		_, db, agg := testDbAndAggregator(t, 0, aggStep)
+		agg.SetCommitmentMode(mode)
+		agg.SetCommitmentBatchSize(batchSize)
+		tx, err := db.BeginRw(ctx)
+		require.NoError(t, err)
+		defer tx.Rollback()
+		agg.SetTx(tx)
+		agg.StartWrites()
+		defer agg.FinishWrites()
+
+		rnd := rand.New(rand.NewSource(42))
+		for txNum := uint64(1); txNum <= 3*aggStep; txNum++ {
+			agg.SetTxNum(txNum)
+			for i := 0; i < 2; i++ {
+				addr := make([]byte, length.Addr)
+				addr[0] = byte(rnd.Intn(64))
+				switch rnd.Intn(5) {
+				case 0:
+					require.NoError(t, agg.UpdateAccountData(addr, EncodeAccountBytes(txNum, uint256.NewInt(1), nil, 0)))
+				case 1:
+					loc := make([]byte, length.Hash)
+					loc[0] = byte(rnd.Intn(8))
+					require.NoError(t, agg.WriteAccountStorage(addr, loc, []byte{byte(rnd.Intn(256)) | 1}))
+				default:
+					require.NoError(t, agg.UpdateAccountData(addr, EncodeAccountBytes(txNum, uint256.NewInt(rnd.Uint64()), nil, 0)))
+				}
+			}
+			if txNum%4 == 0 {
+				root, err := agg.ComputeCommitment(true, false)
+				require.NoError(t, err)
+				roots = append(roots, root)
+			}
+			require.NoError(t, agg.FinishTx())
+		}
+		return roots
+	}
+	for _, mode := range []CommitmentMode{CommitmentModeDirect, CommitmentModeUpdate} {
+		expect := run(mode, 0)
+		for _, batchSize := range []int{1, 3, 17} {
+			require.Equal(t, expect, run(mode, batchSize), "mode %d, batch size %d", mode, batchSize)
+		}
+	}
+}
+
+func TestAggregator_CommitmentCompressValues(t *testing.T) {
+	ctx := context.Background()
+	aggStep := uint64(16)
+	// compression is enabled at txNum `compressFrom`: files built before it have uncompressed values and are merged
+	// with compressed ones
+	run := func(compressFrom uint64) (roots [][]byte) {
+		_, db, agg := testDbAndAggregator(t, 0, aggStep)
+		tx, err := db.BeginRw(ctx)
+		require.NoError(t, err)
+		defer tx.Rollback()
+		agg.SetTx(tx)
+		agg.StartWrites()
+		defer agg.FinishWrites()
+
+		rnd := rand.New(rand.NewSource(42))
+		for txNum := uint64(1); txNum <= 4*aggStep; txNum++ {
+			if txNum == compressFrom {
+				agg.SetCommitmentCompressValues(true)
+			}
+			agg.SetTxNum(txNum)
+			for i := 0; i < 2; i++ {
+				addr := make([]byte, length.Addr)
+				addr[0] = byte(rnd.Intn(64))
+				switch rnd.Intn(5) {
+				case 0:
+					require.NoError(t, agg.UpdateAccountData(addr, EncodeAccountBytes(txNum, uint256.NewInt(1), nil, 0)))
+				case 1:
+					loc := make([]byte, length.Hash)
+					loc[0] = byte(rnd.Intn(8))
+					require.NoError(t, agg.WriteAccountStorage(addr, loc, []byte{byte(rnd.Intn(256)) | 1}))
+				default:
+					require.NoError(t, agg.UpdateAccountData(addr, EncodeAccountBytes(txNum, uint256.NewInt(rnd.Uint64()), nil, 0)))
+				}
+			}
+			if txNum%4 == 0 {
+				root, err := agg.ComputeCommitment(true, false)
+				require.NoError(t, err)
+				roots = append(roots, root)
+			}
+			require.NoError(t, agg.FinishTx())
+		}
+		return roots
+	}
+	expect := run(math.MaxUint64)
+	require.Equal(t, expect, run(1), "compressed from start")
+	require.Equal(t, expect, run(2*aggStep+1), "compressed after files were built")
+}
@@ -22,11 +22,11 @@ import (
 	"errors"
 	"fmt"
 	math2 "math"
-	"runtime"
-	"strings"
+	"sync"
 	"time"
 
 	"github.com/RoaringBitmap/roaring/roaring64"
+	"github.com/ledgerwatch/erigon-lib/common/datadir"
 	"github.com/ledgerwatch/erigon-lib/common/dbg"
 	"github.com/ledgerwatch/erigon-lib/kv/order"
 	"github.com/ledgerwatch/log/v3"
@@ -34,8 +34,8 @@ import (
 	"golang.org/x/sync/errgroup"
 	"golang.org/x/sync/semaphore"
 
-	common2 "github.com/ledgerwatch/erigon-lib/common"
 	"github.com/ledgerwatch/erigon-lib/common/cmp"
+	"github.com/ledgerwatch/erigon-lib/compress"
 	"github.com/ledgerwatch/erigon-lib/etl"
 	"github.com/ledgerwatch/erigon-lib/kv"
 	"github.com/ledgerwatch/erigon-lib/kv/bitmapdb"
@@ -52,51 +52,181 @@ type AggregatorV3 struct {
 	logTopics        *InvertedIndex
 	tracesFrom       *InvertedIndex
 	accounts         *History
+	commitment       *History // branch node updates, kept only in DB - see AddCommitmentUpdates
 	logPrefix        string
 	dir              string
 	tmpdir           string
+	fs               FS     // see SetFS
+	chain            string // encoded ChainIdentity, see NewAggregatorV3ForChain
+	role             AggRole
+	writerLock       *dirLock // see LockDir
+	readersLock      *dirLock
 	txNum            atomic.Uint64
 	aggregationStep  uint64
 	keepInDB         uint64
 	maxTxNum         atomic.Uint64
+	generation       atomic.Uint64 // bumped by Unwind: existing contexts may serve unwound data, see StaleContextError
+	prunedTo         atomic.Uint64 // DB history of txNums < prunedTo may be deleted by Prune, see StaleContextError
+
+	// boundaries of current block, see BeginBlock/EndBlock
+	blockNum       uint64
+	blockFromTxNum uint64
+	inBlock        bool
+
+	readStats *readStats          // see WithLabel
+	io        *writeAmplification // see StepIOStatus
+	lanes     *readLanes          // see WithLane, SetBackgroundMaxYield
+	fds       *fdBudget           // see SetFDLimit
+
+	collateWorkers int                  // see SetCollateWorkers
+	deferIndices   bool                 // see SetDeferIndices
+	freezeTiers    []uint64             // see SetFreezeTiers
+	onFrozenFiles  func(names []string) // see SetOnFrozenFiles
+
+	changesListener StateChangesListener // see SetStateChangesListener
+	changes         *changedKeys
+	changesPending  *pendingStateChanges // written by Flush/Unwind, delivered by NotifyStateChanges
+
+	commitmentListener CommitmentUpdatesListener // see SetCommitmentUpdatesListener
+
+	writers     map[*AggregatorWriter]struct{} // see NewWriter
+	writersLock sync.Mutex
+
+	// filesLock - integration of new/merged files (write) vs MakeContext/Close of contexts (read), see filesItem.refcount
+	filesLock sync.RWMutex
 
 	working                atomic.Bool
 	workingMerge           atomic.Bool
 	workingOptionalIndices atomic.Bool
 	warmupWorking          atomic.Bool
+	strict                 atomic.Bool   // see SetStrict
+	strictTxNum            atomic.Uint64 // last txNum passed to SetTxNum or target of Unwind
+	indexOnly              [3]bool       // accounts, storage, code - see SetHistoryIndexOnly
+	writeBufferLimit       atomic.Uint64 // see SetWriteBufferLimit
+	writeBufferAutoFlush   atomic.Bool
+	minFreeSpace           atomic.Uint64                     // see SetMinFreeSpace
+	freeSpace              func(path string) (uint64, error) // nil - dir.FreeSpace
 	ctx                    context.Context
 	ctxCancel              context.CancelFunc
 }
 
 func NewAggregatorV3(ctx context.Context, dir, tmpdir string, aggregationStep uint64, db kv.RoDB) (*AggregatorV3, error) {
+	return NewAggregatorV3ForChain(ctx, dir, tmpdir, aggregationStep, db, ChainIdentity{})
+}
+
+// NewAggregatorV3FromDirs - files in dirs.SnapHistory, temporary files in dirs.Tmp. See datadir.Open.
+func NewAggregatorV3FromDirs(ctx context.Context, dirs datadir.Dirs, aggregationStep uint64, db kv.RoDB) (*AggregatorV3, error) {
+	return NewAggregatorV3(ctx, dirs.SnapHistory, dirs.Tmp, aggregationStep, db)
+}
+
+// NewAggregatorV3ForChain - files of aggregator are bound to `chain`: ReopenFiles refuses files of other chains (with
+// ErrFileMetaMismatch) and new files carry `chain` in their FileMeta (if enabled, see EnableFileMeta). Zero ChainIdentity -
+// not bound, as NewAggregatorV3.
+func NewAggregatorV3ForChain(ctx context.Context, dir, tmpdir string, aggregationStep uint64, db kv.RoDB, chain ChainIdentity) (*AggregatorV3, error) {
 	ctx, ctxCancel := context.WithCancel(ctx)
-	a := &AggregatorV3{ctx: ctx, ctxCancel: ctxCancel, dir: dir, tmpdir: tmpdir, aggregationStep: aggregationStep, backgroundResult: &BackgroundResult{}, db: db, keepInDB: 2 * aggregationStep}
+	a := &AggregatorV3{ctx: ctx, ctxCancel: ctxCancel, dir: dir, tmpdir: tmpdir, aggregationStep: aggregationStep, backgroundResult: &BackgroundResult{}, db: db, keepInDB: 2 * aggregationStep, readStats: newReadStats(), io: &writeAmplification{}, lanes: newReadLanes(interactiveReadSlots, backgroundMaxYield), fs: OsFS{}, chain: chain.encode()}
+	a.strict.Store(dbg.StrictState())
 	return a, nil
 }
 
+func (a *AggregatorV3) Chain() ChainIdentity {
+	c, _ := parseChainIdentity(a.chain)
+	return c
+}
+
+// LockDir - takes advisory locks of files dir for `role` (see AggRole), returns ErrDirLocked if dir already has writer.
+// Must be called before ReopenFiles. Without it aggregator works as writer which is not coordinated with other instances.
+// Locks are released by Close.
+func (a *AggregatorV3) LockDir(role AggRole) (err error) {
+	if a.readersLock != nil {
+		return fmt.Errorf("LockDir: already locked as %s", a.role)
+	}
+	readersLock, err := openDirLock(a.dir, readersLockFile)
+	if err != nil {
+		return fmt.Errorf("LockDir: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			readersLock.Close()
+		}
+	}()
+	if role == RoleWriter {
+		writerLock, err := openDirLock(a.dir, writerLockFile)
+		if err != nil {
+			return fmt.Errorf("LockDir: %w", err)
+		}
+		ok, err := writerLock.tryLock()
+		if err != nil || !ok {
+			writerLock.Close()
+		}
+		if err != nil {
+			return fmt.Errorf("LockDir: %w", err)
+		}
+		if !ok {
+			return fmt.Errorf("LockDir %s: %w", a.dir, ErrDirLocked)
+		}
+		a.writerLock = writerLock
+	}
+	a.role, a.readersLock = role, readersLock
+	return nil
+}
+
+func (a *AggregatorV3) Role() AggRole { return a.role }
+
+// checkWritable - error if operation `op` changes files (or db) but aggregator is in shared-read mode
+func (a *AggregatorV3) checkWritable(op string) error {
+	if a.role == RoleReader {
+		return fmt.Errorf("%s: %w", op, ErrReadOnly)
+	}
+	return nil
+}
+
 func (a *AggregatorV3) ReopenFiles() error {
+	if a.role == RoleReader {
+		// writer can't delete files while we scan and open them
+		if err := a.readersLock.lock(false); err != nil {
+			return fmt.Errorf("ReopenFiles: %w", err)
+		}
+		defer a.readersLock.unlock()
+	}
 	dir := a.dir
 	aggregationStep := a.aggregationStep
 	var err error
-	if a.accounts, err = NewHistory(dir, a.tmpdir, aggregationStep, "accounts", kv.AccountHistoryKeys, kv.AccountIdx, kv.AccountHistoryVals, kv.AccountSettings, false /* compressVals */, nil); err != nil {
+	if a.accounts, err = newHistory(a.fs, a.chain, dir, a.tmpdir, aggregationStep, "accounts", kv.AccountHistoryKeys, kv.AccountIdx, kv.AccountHistoryVals, kv.AccountSettings, false /* compressVals */, nil); err != nil {
+		return fmt.Errorf("ReopenFiles: %w", err)
+	}
+	if a.storage, err = newHistory(a.fs, a.chain, dir, a.tmpdir, aggregationStep, "storage", kv.StorageHistoryKeys, kv.StorageIdx, kv.StorageHistoryVals, kv.StorageSettings, false /* compressVals */, nil); err != nil {
 		return fmt.Errorf("ReopenFiles: %w", err)
 	}
-	if a.storage, err = NewHistory(dir, a.tmpdir, aggregationStep, "storage", kv.StorageHistoryKeys, kv.StorageIdx, kv.StorageHistoryVals, kv.StorageSettings, false /* compressVals */, nil); err != nil {
+	if a.code, err = newHistory(a.fs, a.chain, dir, a.tmpdir, aggregationStep, "code", kv.CodeHistoryKeys, kv.CodeIdx, kv.CodeHistoryVals, kv.CodeSettings, true /* compressVals */, nil); err != nil {
 		return fmt.Errorf("ReopenFiles: %w", err)
 	}
-	if a.code, err = NewHistory(dir, a.tmpdir, aggregationStep, "code", kv.CodeHistoryKeys, kv.CodeIdx, kv.CodeHistoryVals, kv.CodeSettings, true /* compressVals */, nil); err != nil {
+	if a.commitment, err = newHistory(a.fs, a.chain, dir, a.tmpdir, aggregationStep, "commitment", kv.CommitmentHistoryKeys, kv.CommitmentIdx, kv.CommitmentHistoryVals, kv.CommitmentSettings, false /* compressVals */, nil); err != nil {
 		return fmt.Errorf("ReopenFiles: %w", err)
 	}
-	if a.logAddrs, err = NewInvertedIndex(dir, a.tmpdir, aggregationStep, "logaddrs", kv.LogAddressKeys, kv.LogAddressIdx, false, nil); err != nil {
+	if a.logAddrs, err = newInvertedIndex(a.fs, a.chain, dir, a.tmpdir, aggregationStep, "logaddrs", kv.LogAddressKeys, kv.LogAddressIdx, false, nil); err != nil {
 		return fmt.Errorf("ReopenFiles: %w", err)
 	}
-	if a.logTopics, err = NewInvertedIndex(dir, a.tmpdir, aggregationStep, "logtopics", kv.LogTopicsKeys, kv.LogTopicsIdx, false, nil); err != nil {
+	if a.logTopics, err = newInvertedIndex(a.fs, a.chain, dir, a.tmpdir, aggregationStep, "logtopics", kv.LogTopicsKeys, kv.LogTopicsIdx, false, nil); err != nil {
 		return fmt.Errorf("ReopenFiles: %w", err)
 	}
-	if a.tracesFrom, err = NewInvertedIndex(dir, a.tmpdir, aggregationStep, "tracesfrom", kv.TracesFromKeys, kv.TracesFromIdx, false, nil); err != nil {
+	if a.tracesFrom, err = newInvertedIndex(a.fs, a.chain, dir, a.tmpdir, aggregationStep, "tracesfrom", kv.TracesFromKeys, kv.TracesFromIdx, false, nil); err != nil {
 		return fmt.Errorf("ReopenFiles: %w", err)
 	}
-	if a.tracesTo, err = NewInvertedIndex(dir, a.tmpdir, aggregationStep, "tracesto", kv.TracesToKeys, kv.TracesToIdx, false, nil); err != nil {
+	if a.tracesTo, err = newInvertedIndex(a.fs, a.chain, dir, a.tmpdir, aggregationStep, "tracesto", kv.TracesToKeys, kv.TracesToIdx, false, nil); err != nil {
+		return fmt.Errorf("ReopenFiles: %w", err)
+	}
+	if err = a.applyIndexOnly(); err != nil {
+		return fmt.Errorf("ReopenFiles: %w", err)
+	}
+	a.applyReadLanes()
+	a.applyFDBudget()
+	a.applyCollateWorkers()
+	a.applyDeferIndices()
+	if err = a.applyFileMeta(); err != nil {
+		return fmt.Errorf("ReopenFiles: %w", err)
+	}
+	if err = a.applyFreezeTiers(); err != nil {
 		return fmt.Errorf("ReopenFiles: %w", err)
 	}
 	a.recalcMaxTxNum()
@@ -106,6 +236,24 @@ func (a *AggregatorV3) ReopenFiles() error {
 func (a *AggregatorV3) Close() {
 	a.ctxCancel()
 	a.closeFiles()
+	a.writerLock.Close()
+	a.readersLock.Close()
+}
+
+// SetCollateWorkers - amount of goroutines (each with own read tx) which collate one inverted index on build of
+// files, default 1. Useful on large steps.
+func (a *AggregatorV3) SetCollateWorkers(i int) {
+	a.collateWorkers = i
+	if a.accounts == nil { // files are not opened yet
+		return
+	}
+	a.applyCollateWorkers()
+}
+
+func (a *AggregatorV3) applyCollateWorkers() {
+	for _, ii := range []*InvertedIndex{a.logAddrs, a.logTopics, a.tracesFrom, a.tracesTo} {
+		ii.collateWorkers = a.collateWorkers
+	}
 }
 
 func (a *AggregatorV3) SetWorkers(i int) {
@@ -139,6 +287,9 @@ func (a *AggregatorV3) closeFiles() {
 	if a.code != nil {
 		a.code.Close()
 	}
+	if a.commitment != nil {
+		a.commitment.Close()
+	}
 	if a.logAddrs != nil {
 		a.logAddrs.Close()
 	}
@@ -154,7 +305,7 @@ func (a *AggregatorV3) closeFiles() {
 }
 
 func (a *AggregatorV3) BuildOptionalMissedIndices(ctx context.Context) {
-	if a.workingOptionalIndices.Load() {
+	if a.role == RoleReader || a.workingOptionalIndices.Load() {
 		return
 	}
 	a.workingOptionalIndices.Store(true)
@@ -176,8 +327,11 @@ func (a *AggregatorV3) BuildOptionalMissedIndices(ctx context.Context) {
 }
 
 func (a *AggregatorV3) BuildMissedIndices(ctx context.Context, sem *semaphore.Weighted) error {
+	if err := a.checkWritable("BuildMissedIndices"); err != nil {
+		return err
+	}
 	if err := a.storage.localityIndex.BuildMissedIndices(ctx, a.storage.InvertedIndex); err != nil {
-		panic(err)
+		return err
 	}
 	if err := a.accounts.localityIndex.BuildMissedIndices(ctx, a.accounts.InvertedIndex); err != nil {
 		return err
@@ -221,6 +375,7 @@ func (a *AggregatorV3) SetTx(tx kv.RwTx) {
 	a.accounts.SetTx(tx)
 	a.storage.SetTx(tx)
 	a.code.SetTx(tx)
+	a.commitment.SetTx(tx)
 	a.logAddrs.SetTx(tx)
 	a.logTopics.SetTx(tx)
 	a.tracesFrom.SetTx(tx)
@@ -228,16 +383,81 @@ func (a *AggregatorV3) SetTx(tx kv.RwTx) {
 }
 
 func (a *AggregatorV3) SetTxNum(txNum uint64) {
+	a.checkTxNum(txNum)
 	a.txNum.Store(txNum)
+	if a.inBlock && txNum < a.blockFromTxNum {
+		a.blockFromTxNum = txNum
+	}
 	a.accounts.SetTxNum(txNum)
 	a.storage.SetTxNum(txNum)
 	a.code.SetTxNum(txNum)
+	a.commitment.SetTxNum(txNum)
 	a.logAddrs.SetTxNum(txNum)
 	a.logTopics.SetTxNum(txNum)
 	a.tracesFrom.SetTxNum(txNum)
 	a.tracesTo.SetTxNum(txNum)
 }
 
+// BeginBlock - marks start of block in history streams: all txNums passed to SetTxNum until EndBlock belong to this block
+func (a *AggregatorV3) BeginBlock(blockNum uint64) {
+	a.blockNum, a.blockFromTxNum, a.inBlock = blockNum, math2.MaxUint64, true
+}
+
+// EndBlock - persists boundaries of current block in same RwTx with history writes.
+// Allows Unwind to block boundary (see UnwindToBlock) and extract per-block changesets
+func (a *AggregatorV3) EndBlock() error {
+	if !a.inBlock {
+		return fmt.Errorf("EndBlock: called without BeginBlock")
+	}
+	a.inBlock = false
+	toTxNum := a.txNum.Load()
+	fromTxNum := a.blockFromTxNum
+	if fromTxNum > toTxNum { // block without SetTxNum calls - empty range right after previous block
+		fromTxNum = toTxNum + 1
+	}
+	var k [8]byte
+	var v [16]byte
+	binary.BigEndian.PutUint64(k[:], a.blockNum)
+	binary.BigEndian.PutUint64(v[:8], fromTxNum)
+	binary.BigEndian.PutUint64(v[8:], toTxNum)
+	return a.rwTx.Put(kv.HistoryBlockBoundaries, k[:], v[:])
+}
+
+// BlockTxNums - [fromTxNum, toTxNum] range of block, recorded by EndBlock. Block without txs has fromTxNum == toTxNum+1
+func (a *AggregatorV3) BlockTxNums(tx kv.Getter, blockNum uint64) (fromTxNum, toTxNum uint64, ok bool, err error) {
+	var k [8]byte
+	binary.BigEndian.PutUint64(k[:], blockNum)
+	v, err := tx.GetOne(kv.HistoryBlockBoundaries, k[:])
+	if err != nil {
+		return 0, 0, false, err
+	}
+	if len(v) != 16 {
+		return 0, 0, false, nil
+	}
+	return binary.BigEndian.Uint64(v[:8]), binary.BigEndian.Uint64(v[8:]), true, nil
+}
+
+// unwindBlockBoundaries - removes boundaries of blocks which have txNums >= txUnwindTo
+func (a *AggregatorV3) unwindBlockBoundaries(txUnwindTo uint64) error {
+	c, err := a.rwTx.RwCursor(kv.HistoryBlockBoundaries)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	for k, v, err := c.Last(); k != nil; k, v, err = c.Prev() {
+		if err != nil {
+			return err
+		}
+		if binary.BigEndian.Uint64(v[8:]) < txUnwindTo {
+			break
+		}
+		if err = c.DeleteCurrent(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 type AggV3Collation struct {
 	logAddrs   map[string]*roaring64.Bitmap
 	logTopics  map[string]*roaring64.Bitmap
@@ -288,7 +508,7 @@ func (a *AggregatorV3) buildFiles(ctx context.Context, step uint64, txFrom, txTo
 	//	defer wg.Done()
 	var err error
 	if err = db.View(ctx, func(tx kv.Tx) error {
-		ac.accounts, err = a.accounts.collate(step, txFrom, txTo, tx, logEvery)
+		ac.accounts, err = a.accounts.collate(ctx, step, txFrom, txTo, tx, logEvery)
 		return err
 	}); err != nil {
 		return sf, err
@@ -305,7 +525,7 @@ func (a *AggregatorV3) buildFiles(ctx context.Context, step uint64, txFrom, txTo
 	//	defer wg.Done()
 	//	var err error
 	if err = db.View(ctx, func(tx kv.Tx) error {
-		ac.storage, err = a.storage.collate(step, txFrom, txTo, tx, logEvery)
+		ac.storage, err = a.storage.collate(ctx, step, txFrom, txTo, tx, logEvery)
 		return err
 	}); err != nil {
 		return sf, err
@@ -321,7 +541,7 @@ func (a *AggregatorV3) buildFiles(ctx context.Context, step uint64, txFrom, txTo
 	//	defer wg.Done()
 	//	var err error
 	if err = db.View(ctx, func(tx kv.Tx) error {
-		ac.code, err = a.code.collate(step, txFrom, txTo, tx, logEvery)
+		ac.code, err = a.code.collate(ctx, step, txFrom, txTo, tx, logEvery)
 		return err
 	}); err != nil {
 		return sf, err
@@ -336,10 +556,7 @@ func (a *AggregatorV3) buildFiles(ctx context.Context, step uint64, txFrom, txTo
 	//go func() {
 	//	defer wg.Done()
 	//	var err error
-	if err = db.View(ctx, func(tx kv.Tx) error {
-		ac.logAddrs, err = a.logAddrs.collate(ctx, txFrom, txTo, tx, logEvery)
-		return err
-	}); err != nil {
+	if ac.logAddrs, err = a.logAddrs.collateSharded(ctx, txFrom, txTo, db, logEvery); err != nil {
 		return sf, err
 		//errCh <- err
 	}
@@ -352,10 +569,7 @@ func (a *AggregatorV3) buildFiles(ctx context.Context, step uint64, txFrom, txTo
 	//go func() {
 	//	defer wg.Done()
 	//	var err error
-	if err = db.View(ctx, func(tx kv.Tx) error {
-		ac.logTopics, err = a.logTopics.collate(ctx, txFrom, txTo, tx, logEvery)
-		return err
-	}); err != nil {
+	if ac.logTopics, err = a.logTopics.collateSharded(ctx, txFrom, txTo, db, logEvery); err != nil {
 		return sf, err
 		//errCh <- err
 	}
@@ -368,10 +582,7 @@ func (a *AggregatorV3) buildFiles(ctx context.Context, step uint64, txFrom, txTo
 	//go func() {
 	//	defer wg.Done()
 	//	var err error
-	if err = db.View(ctx, func(tx kv.Tx) error {
-		ac.tracesFrom, err = a.tracesFrom.collate(ctx, txFrom, txTo, tx, logEvery)
-		return err
-	}); err != nil {
+	if ac.tracesFrom, err = a.tracesFrom.collateSharded(ctx, txFrom, txTo, db, logEvery); err != nil {
 		return sf, err
 		//errCh <- err
 	}
@@ -384,10 +595,7 @@ func (a *AggregatorV3) buildFiles(ctx context.Context, step uint64, txFrom, txTo
 	//go func() {
 	//	defer wg.Done()
 	//	var err error
-	if err = db.View(ctx, func(tx kv.Tx) error {
-		ac.tracesTo, err = a.tracesTo.collate(ctx, txFrom, txTo, tx, logEvery)
-		return err
-	}); err != nil {
+	if ac.tracesTo, err = a.tracesTo.collateSharded(ctx, txFrom, txTo, db, logEvery); err != nil {
 		return sf, err
 		//errCh <- err
 	}
@@ -434,6 +642,9 @@ func (sf Agg22StaticFiles) Close() {
 }
 
 func (a *AggregatorV3) BuildFiles(ctx context.Context, db kv.RoDB) (err error) {
+	if err := a.checkWritable("BuildFiles"); err != nil {
+		return err
+	}
 	if (a.txNum.Load() + 1) <= a.maxTxNum.Load()+a.aggregationStep+a.keepInDB { // Leave one step worth in the DB
 		return nil
 	}
@@ -445,6 +656,9 @@ func (a *AggregatorV3) BuildFiles(ctx context.Context, db kv.RoDB) (err error) {
 	step := a.EndTxNumMinimax() / a.aggregationStep
 	for ; step < lastIdInDB(db, a.accounts.indexKeysTable)/a.aggregationStep; step++ {
 		if err := a.buildFilesInBackground(ctx, step, db); err != nil {
+			if errors.Is(err, ErrLowFreeSpace) {
+				return err
+			}
 			if !errors.Is(err, context.Canceled) {
 				log.Warn("buildFilesInBackground", "err", err)
 			}
@@ -456,7 +670,11 @@ func (a *AggregatorV3) BuildFiles(ctx context.Context, db kv.RoDB) (err error) {
 
 func (a *AggregatorV3) buildFilesInBackground(ctx context.Context, step uint64, db kv.RoDB) (err error) {
 	closeAll := true
+	if err := a.checkFreeSpace("BuildFiles", a.estimateBuildSize()); err != nil {
+		return err
+	}
 	log.Info("[snapshots] history build", "step", fmt.Sprintf("%d-%d", step, step+1))
+	dbReadBefore, compressedBefore := a.buildIOTotals()
 	sf, err := a.buildFiles(ctx, step, step*a.aggregationStep, (step+1)*a.aggregationStep, db)
 	if err != nil {
 		return err
@@ -467,6 +685,9 @@ func (a *AggregatorV3) buildFilesInBackground(ctx context.Context, step uint64,
 		}
 	}()
 	a.integrateFiles(sf, step*a.aggregationStep, (step+1)*a.aggregationStep)
+	dbRead, compressed := a.buildIOTotals()
+	filesBytes := a.filesSize(step*a.aggregationStep, (step+1)*a.aggregationStep)
+	a.io.stepBuilt(StepIOStatus{Step: step, DBReadBytes: dbRead - dbReadBefore, TmpWrittenBytes: compressed - compressedBefore + filesBytes, FilesBytes: filesBytes})
 
 	closeAll = false
 	return nil
@@ -474,13 +695,17 @@ func (a *AggregatorV3) buildFilesInBackground(ctx context.Context, step uint64,
 
 func (a *AggregatorV3) mergeLoopStep(ctx context.Context, workers int) (somethingDone bool, err error) {
 	closeAll := true
-	maxSpan := a.aggregationStep * StepsInBiggestFile
-	r := a.findMergeRange(a.maxTxNum.Load(), maxSpan)
+	maxSpan := a.aggregationStep * a.stepsInBiggestFile()
+	r := a.findMergeRange(a.endIndexedTxNum(), maxSpan)
 	if !r.any() {
 		return false, nil
 	}
 
 	outs := a.staticFilesInRange(r)
+	// before defer: selected files are still used by aggregator if merge is refused
+	if err = a.checkFreeSpace("MergeLoop", estimateMergeSize(a.planMerges(r, outs))); err != nil {
+		return false, err
+	}
 	defer func() {
 		if closeAll {
 			outs.Close()
@@ -496,6 +721,7 @@ func (a *AggregatorV3) mergeLoopStep(ctx context.Context, workers int) (somethin
 		}
 	}()
 	a.integrateMergedFiles(outs, in)
+	a.io.merged(filesItemsSize(in.accountsIdx, in.accountsHist, in.storageIdx, in.storageHist, in.codeIdx, in.codeHist, in.logAddrs, in.logTopics, in.tracesFrom, in.tracesTo))
 	if err = a.deleteFiles(outs); err != nil {
 		return true, err
 	}
@@ -503,6 +729,9 @@ func (a *AggregatorV3) mergeLoopStep(ctx context.Context, workers int) (somethin
 	return true, nil
 }
 func (a *AggregatorV3) MergeLoop(ctx context.Context, workers int) error {
+	if err := a.checkWritable("MergeLoop"); err != nil {
+		return err
+	}
 	for {
 		somethingMerged, err := a.mergeLoopStep(ctx, workers)
 		if err != nil {
@@ -515,6 +744,7 @@ func (a *AggregatorV3) MergeLoop(ctx context.Context, workers int) error {
 }
 
 func (a *AggregatorV3) integrateFiles(sf Agg22StaticFiles, txNumFrom, txNumTo uint64) {
+	a.filesLock.Lock()
 	a.accounts.integrateFiles(sf.accounts, txNumFrom, txNumTo)
 	a.storage.integrateFiles(sf.storage, txNumFrom, txNumTo)
 	a.code.integrateFiles(sf.code, txNumFrom, txNumTo)
@@ -522,22 +752,49 @@ func (a *AggregatorV3) integrateFiles(sf Agg22StaticFiles, txNumFrom, txNumTo ui
 	a.logTopics.integrateFiles(sf.logTopics, txNumFrom, txNumTo)
 	a.tracesFrom.integrateFiles(sf.tracesFrom, txNumFrom, txNumTo)
 	a.tracesTo.integrateFiles(sf.tracesTo, txNumFrom, txNumTo)
+	a.filesLock.Unlock()
 	a.recalcMaxTxNum()
+	a.checkFiles()
+	if a.onFrozenFiles != nil && a.isFrozen(txNumFrom, txNumTo) {
+		a.notifyFrozen(sf.accounts.historyDecomp, sf.accounts.efHistoryDecomp, sf.storage.historyDecomp, sf.storage.efHistoryDecomp,
+			sf.code.historyDecomp, sf.code.efHistoryDecomp, sf.logAddrs.decomp, sf.logTopics.decomp, sf.tracesFrom.decomp, sf.tracesTo.decomp)
+	}
 }
 
 func (a *AggregatorV3) Unwind(ctx context.Context, txUnwindTo uint64, stateLoad etl.LoadFunc) error {
+	if filesEnd := a.maxTxNum.Load(); txUnwindTo < filesEnd {
+		// history before end of files is pruned from DB, files are not unwindable
+		return fmt.Errorf("Unwind to txNum %d, files end at %d: %w", txUnwindTo, filesEnd, ErrBehindPrune)
+	}
+	// contexts created before and during unwind are stale
+	a.generation.Inc()
+	defer a.generation.Inc()
+	a.strictTxNum.Store(txUnwindTo)
+	unwoundEntities, err := a.unwoundEntities(txUnwindTo)
+	if err != nil {
+		return err
+	}
 	stateChanges := etl.NewCollector(a.logPrefix, a.tmpdir, etl.NewOldestEntryBuffer(etl.BufferOptimalSize))
 	defer stateChanges.Close()
+	var unwound *changedKeys
+	if a.changesListener != nil {
+		unwound = newChangedKeys()
+	}
 	if err := a.accounts.pruneF(txUnwindTo, math2.MaxUint64, func(_ uint64, k, v []byte) error {
+		unwound.add(k, nil)
 		return stateChanges.Collect(k, v)
 	}); err != nil {
 		return err
 	}
 	if err := a.storage.pruneF(txUnwindTo, math2.MaxUint64, func(_ uint64, k, v []byte) error {
+		unwound.add(k, nil)
 		return stateChanges.Collect(k, v)
 	}); err != nil {
 		return err
 	}
+	if err := a.unwindCommitment(txUnwindTo); err != nil {
+		return err
+	}
 
 	if err := stateChanges.Load(a.rwTx, kv.PlainState, stateLoad, etl.TransformArgs{Quit: ctx.Done()}); err != nil {
 		return err
@@ -556,7 +813,22 @@ func (a *AggregatorV3) Unwind(ctx context.Context, txUnwindTo uint64, stateLoad
 	if err := a.tracesTo.prune(ctx, txUnwindTo, math2.MaxUint64, math2.MaxUint64, logEvery); err != nil {
 		return err
 	}
-	return nil
+	if err := a.unwindBlockBoundaries(txUnwindTo); err != nil {
+		return err
+	}
+	return a.stageStateChanges(a.rwTx, unwound.take(), txUnwindTo, unwoundEntities)
+}
+
+// UnwindToBlock - unwinds history to the end of given block (block itself stays). Uses boundaries recorded by EndBlock
+func (a *AggregatorV3) UnwindToBlock(ctx context.Context, blockNum uint64, stateLoad etl.LoadFunc) error {
+	_, toTxNum, ok, err := a.BlockTxNums(a.rwTx, blockNum)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("UnwindToBlock: boundaries of block %d not found", blockNum)
+	}
+	return a.Unwind(ctx, toTxNum+1, stateLoad)
 }
 
 func (a *AggregatorV3) Warmup(ctx context.Context, txFrom, limit uint64) {
@@ -606,6 +878,7 @@ func (a *AggregatorV3) DiscardHistory() *AggregatorV3 {
 	a.accounts.DiscardHistory(a.tmpdir)
 	a.storage.DiscardHistory(a.tmpdir)
 	a.code.DiscardHistory(a.tmpdir)
+	a.commitment.StartWrites(a.tmpdir) // needed by Unwind
 	a.logAddrs.DiscardHistory(a.tmpdir)
 	a.logTopics.DiscardHistory(a.tmpdir)
 	a.tracesFrom.DiscardHistory(a.tmpdir)
@@ -613,11 +886,58 @@ func (a *AggregatorV3) DiscardHistory() *AggregatorV3 {
 	return a
 }
 
+// DiscardHistoryValues - keeps all inverted indices (tx lookup, logs/traces filters, `IterateChanged`), but
+// drops previous values of accounts/storage/code - for nodes which don't need historical state. Read*NoState of
+// txNums written in this mode return ErrHistoryValuesDiscarded (not empty value).
+// Pattern: `defer agg.DiscardHistoryValues().FinishWrites()`
+func (a *AggregatorV3) DiscardHistoryValues() *AggregatorV3 {
+	a.accounts.DiscardHistoryValues(a.tmpdir)
+	a.storage.DiscardHistoryValues(a.tmpdir)
+	a.code.DiscardHistoryValues(a.tmpdir)
+	a.commitment.StartWrites(a.tmpdir) // needed by Unwind
+	a.logAddrs.StartWrites(a.tmpdir)
+	a.logTopics.StartWrites(a.tmpdir)
+	a.tracesFrom.StartWrites(a.tmpdir)
+	a.tracesTo.StartWrites(a.tmpdir)
+	return a
+}
+
+// SetReadPending - makes not flushed history writes of accounts/storage/code visible to
+// AggregatorV3Context.Read*NoStateWithRecent, see History.SetReadPending
+func (a *AggregatorV3) SetReadPending(v bool) {
+	a.accounts.SetReadPending(v)
+	a.storage.SetReadPending(v)
+	a.code.SetReadPending(v)
+	a.commitment.SetReadPending(v)
+}
+
+// SetHistoryIndexOnly - selected histories build only inverted index files (.ef/.efi) - middle ground between
+// archive node and DiscardHistory: `*HistoryIterateChanged`, `*HistoyIdxIterator` answer when keys were changed, but
+// Read*NoState of ranges without values return ErrHistoryIndexOnly. See History.SetIndexOnly.
+// Better be called before ReopenFiles, setting is kept by next ReopenFiles.
+func (a *AggregatorV3) SetHistoryIndexOnly(accounts, storage, code bool) error {
+	a.indexOnly = [3]bool{accounts, storage, code}
+	if a.accounts == nil { // files are not opened yet
+		return nil
+	}
+	return a.applyIndexOnly()
+}
+
+func (a *AggregatorV3) applyIndexOnly() error {
+	for i, h := range []*History{a.accounts, a.storage, a.code} {
+		if err := h.SetIndexOnly(a.indexOnly[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // StartWrites - pattern: `defer agg.StartWrites().FinishWrites()`
 func (a *AggregatorV3) StartWrites() *AggregatorV3 {
 	a.accounts.StartWrites(a.tmpdir)
 	a.storage.StartWrites(a.tmpdir)
 	a.code.StartWrites(a.tmpdir)
+	a.commitment.StartWrites(a.tmpdir)
 	a.logAddrs.StartWrites(a.tmpdir)
 	a.logTopics.StartWrites(a.tmpdir)
 	a.tracesFrom.StartWrites(a.tmpdir)
@@ -628,6 +948,7 @@ func (a *AggregatorV3) FinishWrites() {
 	a.accounts.FinishWrites()
 	a.storage.FinishWrites()
 	a.code.FinishWrites()
+	a.commitment.FinishWrites()
 	a.logAddrs.FinishWrites()
 	a.logTopics.FinishWrites()
 	a.tracesFrom.FinishWrites()
@@ -643,21 +964,27 @@ func (a *AggregatorV3) Flush(ctx context.Context, tx kv.RwTx) error {
 		a.accounts.Rotate(),
 		a.storage.Rotate(),
 		a.code.Rotate(),
+		a.commitment.Rotate(),
 		a.logAddrs.Rotate(),
 		a.logTopics.Rotate(),
 		a.tracesFrom.Rotate(),
 		a.tracesTo.Rotate(),
 	}
 	defer func(t time.Time) { log.Debug("[snapshots] history flush", "took", time.Since(t)) }(time.Now())
+	var flushed flushedEntities
 	for _, f := range flushers {
+		flushed.add(f)
 		if err := f.Flush(ctx, tx); err != nil {
 			return err
 		}
 	}
-	return nil
+	if err := a.flushWriters(ctx, tx, &flushed); err != nil {
+		return err
+	}
+	return a.stageStateChanges(tx, a.changes.take(), a.txNum.Load()+1, flushed)
 }
 
-func (a *AggregatorV3) CanPrune(tx kv.Tx) bool { return a.CanPruneFrom(tx) < a.maxTxNum.Load() }
+func (a *AggregatorV3) CanPrune(tx kv.Tx) bool { return a.CanPruneFrom(tx) < a.endIndexedTxNum() }
 func (a *AggregatorV3) CanPruneFrom(tx kv.Tx) uint64 {
 	fst, _ := kv.FirstKey(tx, kv.TracesToKeys)
 	fst2, _ := kv.FirstKey(tx, kv.StorageHistoryKeys)
@@ -680,15 +1007,19 @@ func (a *AggregatorV3) PruneWithTiemout(ctx context.Context, timeout time.Durati
 }
 
 func (a *AggregatorV3) Prune(ctx context.Context, limit uint64) error {
+	if err := a.checkWritable("Prune"); err != nil {
+		return err
+	}
 	//ctx, cancel := context.WithCancel(ctx)
 	//defer cancel()
 	//go func() {
 	//	a.Warmup(ctx, 0, cmp.Max(a.aggregationStep, limit)) // warmup is asyn and moving faster than data deletion
 	//}()
-	return a.prune(ctx, 0, a.maxTxNum.Load(), limit)
+	return a.prune(ctx, 0, a.endIndexedTxNum(), limit) // data of not indexed files is still read from DB
 }
 
 func (a *AggregatorV3) prune(ctx context.Context, txFrom, txTo, limit uint64) error {
+	a.checkPrune(txFrom, txTo)
 	logEvery := time.NewTicker(30 * time.Second)
 	defer logEvery.Stop()
 	if err := a.accounts.prune(ctx, txFrom, txTo, limit, logEvery); err != nil {
@@ -700,6 +1031,9 @@ func (a *AggregatorV3) prune(ctx context.Context, txFrom, txTo, limit uint64) er
 	if err := a.code.prune(ctx, txFrom, txTo, limit, logEvery); err != nil {
 		return err
 	}
+	if err := a.commitment.prune(ctx, txFrom, txTo, limit, logEvery); err != nil {
+		return err
+	}
 	if err := a.logAddrs.prune(ctx, txFrom, txTo, limit, logEvery); err != nil {
 		return err
 	}
@@ -712,44 +1046,10 @@ func (a *AggregatorV3) prune(ctx context.Context, txFrom, txTo, limit uint64) er
 	if err := a.tracesTo.prune(ctx, txFrom, txTo, limit, logEvery); err != nil {
 		return err
 	}
-	return nil
-}
-
-func (a *AggregatorV3) LogStats(tx kv.Tx, tx2block func(endTxNumMinimax uint64) uint64) {
-	if a.maxTxNum.Load() == 0 {
-		return
+	if txTo > a.prunedTo.Load() {
+		a.prunedTo.Store(txTo)
 	}
-	histBlockNumProgress := tx2block(a.maxTxNum.Load())
-	str := make([]string, 0, a.accounts.InvertedIndex.files.Len())
-	a.accounts.InvertedIndex.files.Ascend(func(it *filesItem) bool {
-		bn := tx2block(it.endTxNum)
-		str = append(str, fmt.Sprintf("%d=%dK", it.endTxNum/a.aggregationStep, bn/1_000))
-		return true
-	})
-
-	c, err := tx.CursorDupSort(a.accounts.InvertedIndex.indexTable)
-	if err != nil {
-		// TODO pass error properly around
-		panic(err)
-	}
-	_, v, err := c.First()
-	if err != nil {
-		// TODO pass error properly around
-		panic(err)
-	}
-	var firstHistoryIndexBlockInDB uint64
-	if len(v) != 0 {
-		firstHistoryIndexBlockInDB = tx2block(binary.BigEndian.Uint64(v))
-	}
-
-	var m runtime.MemStats
-	dbg.ReadMemStats(&m)
-	log.Info("[Snapshots] History Stat",
-		"blocks", fmt.Sprintf("%dk", (histBlockNumProgress+1)/1000),
-		"txs", fmt.Sprintf("%dm", a.maxTxNum.Load()/1_000_000),
-		"txNum2blockNum", strings.Join(str, ","),
-		"first_history_idx_in_db", firstHistoryIndexBlockInDB,
-		"alloc", common2.ByteCount(m.Alloc), "sys", common2.ByteCount(m.Sys))
+	return nil
 }
 
 func (a *AggregatorV3) EndTxNumMinimax() uint64 { return a.maxTxNum.Load() }
@@ -985,7 +1285,9 @@ func (a *AggregatorV3) mergeFiles(ctx context.Context, files SelectedStaticFiles
 	return mf, lastError
 }
 
+// integrateMergedFiles - merged-away files stay open while contexts use them, see filesItem.refcount
 func (a *AggregatorV3) integrateMergedFiles(outs SelectedStaticFilesV3, in MergedFilesV3) {
+	a.filesLock.Lock()
 	a.accounts.integrateMergedFiles(outs.accountsIdx, outs.accountsHist, in.accountsIdx, in.accountsHist)
 	a.storage.integrateMergedFiles(outs.storageIdx, outs.storageHist, in.storageIdx, in.storageHist)
 	a.code.integrateMergedFiles(outs.codeIdx, outs.codeHist, in.codeIdx, in.codeHist)
@@ -993,9 +1295,28 @@ func (a *AggregatorV3) integrateMergedFiles(outs SelectedStaticFilesV3, in Merge
 	a.logTopics.integrateMergedFiles(outs.logTopics, in.logTopics)
 	a.tracesFrom.integrateMergedFiles(outs.tracesFrom, in.tracesFrom)
 	a.tracesTo.integrateMergedFiles(outs.tracesTo, in.tracesTo)
+	a.filesLock.Unlock()
+	a.checkFiles()
+	if a.onFrozenFiles != nil {
+		var frozen []*compress.Decompressor
+		for _, item := range []*filesItem{in.accountsHist, in.accountsIdx, in.storageHist, in.storageIdx, in.codeHist, in.codeIdx,
+			in.logAddrs, in.logTopics, in.tracesFrom, in.tracesTo} {
+			if item != nil && a.isFrozen(item.startTxNum, item.endTxNum) {
+				frozen = append(frozen, item.decompressor)
+			}
+		}
+		a.notifyFrozen(frozen...)
+	}
 }
 
 func (a *AggregatorV3) deleteFiles(outs SelectedStaticFilesV3) error {
+	if a.readersLock != nil {
+		// wait for readers which are opening files now
+		if err := a.readersLock.lock(true); err != nil {
+			return err
+		}
+		defer a.readersLock.unlock()
+	}
 	if err := a.accounts.deleteFiles(outs.accountsIdx, outs.accountsHist); err != nil {
 		return err
 	}
@@ -1025,6 +1346,9 @@ func (a *AggregatorV3) deleteFiles(outs SelectedStaticFilesV3) error {
 func (a *AggregatorV3) KeepInDB(v uint64) { a.keepInDB = v }
 
 func (a *AggregatorV3) BuildFilesInBackground(db kv.RoDB) error {
+	if err := a.checkWritable("BuildFilesInBackground"); err != nil {
+		return err
+	}
 	if (a.txNum.Load() + 1) <= a.maxTxNum.Load()+a.aggregationStep+a.keepInDB { // Leave one step worth in the DB
 		return nil
 	}
@@ -1066,6 +1390,11 @@ func (a *AggregatorV3) BuildFilesInBackground(db kv.RoDB) error {
 		a.workingMerge.Store(true)
 		go func() {
 			defer a.workingMerge.Store(false)
+			if a.deferIndices {
+				if err := a.BuildDeferredIndices(a.ctx); err != nil {
+					log.Warn("BuildDeferredIndices", "err", err)
+				}
+			}
 			if err := a.MergeLoop(a.ctx, 1); err != nil {
 				log.Warn("merge", "err", err)
 			}
@@ -1084,14 +1413,16 @@ func (a *AggregatorV3) AddAccountPrev(addr []byte, prev []byte) error {
 	if err := a.accounts.AddPrevValue(addr, nil, prev); err != nil {
 		return err
 	}
-	return nil
+	a.changes.add(addr, nil)
+	return a.checkWriteBuffer()
 }
 
 func (a *AggregatorV3) AddStoragePrev(addr []byte, loc []byte, prev []byte) error {
 	if err := a.storage.AddPrevValue(addr, loc, prev); err != nil {
 		return err
 	}
-	return nil
+	a.changes.add(addr, loc)
+	return a.checkWriteBuffer()
 }
 
 // AddCodePrev - addr+inc => code
@@ -1099,23 +1430,51 @@ func (a *AggregatorV3) AddCodePrev(addr []byte, prev []byte) error {
 	if err := a.code.AddPrevValue(addr, nil, prev); err != nil {
 		return err
 	}
-	return nil
+	return a.checkWriteBuffer()
 }
 
 func (a *AggregatorV3) AddTraceFrom(addr []byte) error {
-	return a.tracesFrom.Add(addr)
+	if err := a.tracesFrom.Add(addr); err != nil {
+		return err
+	}
+	return a.checkWriteBuffer()
 }
 
 func (a *AggregatorV3) AddTraceTo(addr []byte) error {
-	return a.tracesTo.Add(addr)
+	if err := a.tracesTo.Add(addr); err != nil {
+		return err
+	}
+	return a.checkWriteBuffer()
 }
 
 func (a *AggregatorV3) AddLogAddr(addr []byte) error {
-	return a.logAddrs.Add(addr)
+	if err := a.logAddrs.Add(addr); err != nil {
+		return err
+	}
+	return a.checkWriteBuffer()
 }
 
 func (a *AggregatorV3) AddLogTopic(topic []byte) error {
-	return a.logTopics.Add(topic)
+	if err := a.logTopics.Add(topic); err != nil {
+		return err
+	}
+	return a.checkWriteBuffer()
+}
+
+// AddLogAddrs - addresses of all logs of current tx, see InvertedIndex.AddBatch
+func (a *AggregatorV3) AddLogAddrs(addrs [][]byte) error {
+	if err := a.logAddrs.AddBatch(addrs); err != nil {
+		return err
+	}
+	return a.checkWriteBuffer()
+}
+
+// AddLogTopics - topics of all logs of current tx, see InvertedIndex.AddBatch
+func (a *AggregatorV3) AddLogTopics(topics [][]byte) error {
+	if err := a.logTopics.AddBatch(topics); err != nil {
+		return err
+	}
+	return a.checkWriteBuffer()
 }
 
 // DisableReadAhead - usage: `defer d.EnableReadAhead().DisableReadAhead()`. Please don't use this funcs without `defer` to avoid leak.
@@ -1161,44 +1520,77 @@ func (a *AggregatorV3) EnableMadvNormal() *AggregatorV3 {
 
 // -- range
 func (ac *AggregatorV3Context) LogAddrIterator(addr []byte, startTxNum, endTxNum int, asc order.By, limit int, roTx kv.Tx) (*InvertedIterator, error) {
+	if err := ac.checkGeneration(); err != nil {
+		return nil, err
+	}
 	return ac.logAddrs.IterateRange(addr, startTxNum, endTxNum, asc, limit, roTx)
 }
 
 func (ac *AggregatorV3Context) LogTopicIterator(topic []byte, startTxNum, endTxNum int, asc order.By, limit int, roTx kv.Tx) (*InvertedIterator, error) {
+	if err := ac.checkGeneration(); err != nil {
+		return nil, err
+	}
 	return ac.logTopics.IterateRange(topic, startTxNum, endTxNum, asc, limit, roTx)
 }
 
 func (ac *AggregatorV3Context) TraceFromIterator(addr []byte, startTxNum, endTxNum int, asc order.By, limit int, roTx kv.Tx) (*InvertedIterator, error) {
+	if err := ac.checkGeneration(); err != nil {
+		return nil, err
+	}
 	return ac.tracesFrom.IterateRange(addr, startTxNum, endTxNum, asc, limit, roTx)
 }
 
 func (ac *AggregatorV3Context) TraceToIterator(addr []byte, startTxNum, endTxNum int, asc order.By, limit int, roTx kv.Tx) (*InvertedIterator, error) {
+	if err := ac.checkGeneration(); err != nil {
+		return nil, err
+	}
 	return ac.tracesTo.IterateRange(addr, startTxNum, endTxNum, asc, limit, roTx)
 }
 func (ac *AggregatorV3Context) AccountHistoyIdxIterator(addr []byte, startTxNum, endTxNum int, asc order.By, limit int, roTx kv.Tx) (*InvertedIterator, error) {
+	if err := ac.checkGeneration(); err != nil {
+		return nil, err
+	}
 	//TODO: don't create new context by MakeContext
-	return ac.accounts.h.InvertedIndex.MakeContext().IterateRange(addr, startTxNum, endTxNum, asc, limit, roTx)
+	return ac.accounts.indexContext().IterateRange(addr, startTxNum, endTxNum, asc, limit, roTx)
 }
 func (ac *AggregatorV3Context) StorageHistoyIdxIterator(addr []byte, startTxNum, endTxNum int, asc order.By, limit int, roTx kv.Tx) (*InvertedIterator, error) {
+	if err := ac.checkGeneration(); err != nil {
+		return nil, err
+	}
 	//TODO: don't create new context by MakeContext
-	return ac.storage.h.InvertedIndex.MakeContext().IterateRange(addr, startTxNum, endTxNum, asc, limit, roTx)
+	return ac.storage.indexContext().IterateRange(addr, startTxNum, endTxNum, asc, limit, roTx)
 }
 func (ac *AggregatorV3Context) CodeHistoyIdxIterator(addr []byte, startTxNum, endTxNum int, asc order.By, limit int, roTx kv.Tx) (*InvertedIterator, error) {
+	if err := ac.checkGeneration(); err != nil {
+		return nil, err
+	}
 	//TODO: don't create new context by MakeContext
-	return ac.code.h.InvertedIndex.MakeContext().IterateRange(addr, startTxNum, endTxNum, asc, limit, roTx)
+	return ac.code.indexContext().IterateRange(addr, startTxNum, endTxNum, asc, limit, roTx)
 }
 
 // -- range end
 
 func (ac *AggregatorV3Context) ReadAccountDataNoStateWithRecent(addr []byte, txNum uint64) ([]byte, bool, error) {
+	if err := ac.checkGeneration(); err != nil {
+		return nil, false, err
+	}
+	defer ac.enterRead()()
 	return ac.accounts.GetNoStateWithRecent(addr, txNum, ac.tx)
 }
 
 func (ac *AggregatorV3Context) ReadAccountDataNoState(addr []byte, txNum uint64) ([]byte, bool, error) {
+	if err := ac.checkGeneration(); err != nil {
+		return nil, false, err
+	}
+	defer ac.enterRead()()
 	return ac.accounts.GetNoState(addr, txNum)
 }
 
 func (ac *AggregatorV3Context) ReadAccountStorageNoStateWithRecent(addr []byte, loc []byte, txNum uint64) ([]byte, bool, error) {
+	if err := ac.checkGeneration(); err != nil {
+		return nil, false, err
+	}
+	defer ac.enterRead()()
 	if cap(ac.keyBuf) < len(addr)+len(loc) {
 		ac.keyBuf = make([]byte, len(addr)+len(loc))
 	} else if len(ac.keyBuf) != len(addr)+len(loc) {
@@ -1209,10 +1601,18 @@ func (ac *AggregatorV3Context) ReadAccountStorageNoStateWithRecent(addr []byte,
 	return ac.storage.GetNoStateWithRecent(ac.keyBuf, txNum, ac.tx)
 }
 func (ac *AggregatorV3Context) ReadAccountStorageNoStateWithRecent2(key []byte, txNum uint64) ([]byte, bool, error) {
+	if err := ac.checkGeneration(); err != nil {
+		return nil, false, err
+	}
+	defer ac.enterRead()()
 	return ac.storage.GetNoStateWithRecent(key, txNum, ac.tx)
 }
 
 func (ac *AggregatorV3Context) ReadAccountStorageNoState(addr []byte, loc []byte, txNum uint64) ([]byte, bool, error) {
+	if err := ac.checkGeneration(); err != nil {
+		return nil, false, err
+	}
+	defer ac.enterRead()()
 	if cap(ac.keyBuf) < len(addr)+len(loc) {
 		ac.keyBuf = make([]byte, len(addr)+len(loc))
 	} else if len(ac.keyBuf) != len(addr)+len(loc) {
@@ -1224,13 +1624,25 @@ func (ac *AggregatorV3Context) ReadAccountStorageNoState(addr []byte, loc []byte
 }
 
 func (ac *AggregatorV3Context) ReadAccountCodeNoStateWithRecent(addr []byte, txNum uint64) ([]byte, bool, error) {
+	if err := ac.checkGeneration(); err != nil {
+		return nil, false, err
+	}
+	defer ac.enterRead()()
 	return ac.code.GetNoStateWithRecent(addr, txNum, ac.tx)
 }
 func (ac *AggregatorV3Context) ReadAccountCodeNoState(addr []byte, txNum uint64) ([]byte, bool, error) {
+	if err := ac.checkGeneration(); err != nil {
+		return nil, false, err
+	}
+	defer ac.enterRead()()
 	return ac.code.GetNoState(addr, txNum)
 }
 
 func (ac *AggregatorV3Context) ReadAccountCodeSizeNoStateWithRecent(addr []byte, txNum uint64) (int, bool, error) {
+	if err := ac.checkGeneration(); err != nil {
+		return 0, false, err
+	}
+	defer ac.enterRead()()
 	code, noState, err := ac.code.GetNoStateWithRecent(addr, txNum, ac.tx)
 	if err != nil {
 		return 0, false, err
@@ -1238,6 +1650,10 @@ func (ac *AggregatorV3Context) ReadAccountCodeSizeNoStateWithRecent(addr []byte,
 	return len(code), noState, nil
 }
 func (ac *AggregatorV3Context) ReadAccountCodeSizeNoState(addr []byte, txNum uint64) (int, bool, error) {
+	if err := ac.checkGeneration(); err != nil {
+		return 0, false, err
+	}
+	defer ac.enterRead()()
 	code, noState, err := ac.code.GetNoState(addr, txNum)
 	if err != nil {
 		return 0, false, err
@@ -1253,6 +1669,36 @@ func (ac *AggregatorV3Context) StorageHistoryIterateChanged(startTxNum, endTxNum
 	return ac.storage.IterateChanged(startTxNum, endTxNum, roTx)
 }
 
+// AccountHistoryIterateChangedInBlock - account changes made by given block, block boundaries recorded by EndBlock
+func (ac *AggregatorV3Context) AccountHistoryIterateChangedInBlock(blockNum uint64, roTx kv.Tx) (*HistoryIterator1, error) {
+	if err := ac.checkGeneration(); err != nil {
+		return nil, err
+	}
+	fromTxNum, toTxNum, ok, err := ac.a.BlockTxNums(roTx, blockNum)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("boundaries of block %d not found", blockNum)
+	}
+	return ac.accounts.IterateChanged(fromTxNum, toTxNum+1, roTx), nil
+}
+
+// StorageHistoryIterateChangedInBlock - storage changes made by given block, block boundaries recorded by EndBlock
+func (ac *AggregatorV3Context) StorageHistoryIterateChangedInBlock(blockNum uint64, roTx kv.Tx) (*HistoryIterator1, error) {
+	if err := ac.checkGeneration(); err != nil {
+		return nil, err
+	}
+	fromTxNum, toTxNum, ok, err := ac.a.BlockTxNums(roTx, blockNum)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("boundaries of block %d not found", blockNum)
+	}
+	return ac.storage.IterateChanged(fromTxNum, toTxNum+1, roTx), nil
+}
+
 func (ac *AggregatorV3Context) AccountHistoricalStateRange(startTxNum uint64, from, to []byte, amount int, roTx kv.Tx) *WalkAsOfIter {
 	return ac.accounts.WalkAsOf(startTxNum, from, to, roTx, amount)
 }
@@ -1283,27 +1729,110 @@ type AggregatorV3Context struct {
 	accounts   *HistoryContext
 	storage    *HistoryContext
 	code       *HistoryContext
+	commitment *HistoryContext
 	logAddrs   *InvertedIndexContext
 	logTopics  *InvertedIndexContext
 	tracesFrom *InvertedIndexContext
 	tracesTo   *InvertedIndexContext
 	keyBuf     []byte
+	generation uint64       // generation of aggregator at MakeContext
+	filesEnd   uint64       // context reads history of txNums < filesEnd from files, rest from DB
+	files      []*filesItem // referenced by context, see filesItem.refcount
+	lane       ReadLane
 }
 
-func (a *AggregatorV3) MakeContext() *AggregatorV3Context {
-	return &AggregatorV3Context{
+func (a *AggregatorV3) MakeContext(opts ...ContextOption) *AggregatorV3Context {
+	var o contextOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	a.filesLock.RLock()
+	defer a.filesLock.RUnlock()
+	ac := &AggregatorV3Context{
 		a:          a,
+		files:      a.refFiles(),
+		generation: a.generation.Load(),
+		filesEnd:   a.maxTxNum.Load(), // before files of contexts: they end not before it
+		lane:       o.lane,
 		accounts:   a.accounts.MakeContext(),
 		storage:    a.storage.MakeContext(),
 		code:       a.code.MakeContext(),
+		commitment: a.commitment.MakeContext(),
 		logAddrs:   a.logAddrs.MakeContext(),
 		logTopics:  a.logTopics.MakeContext(),
 		tracesFrom: a.tracesFrom.MakeContext(),
 		tracesTo:   a.tracesTo.MakeContext(),
 	}
+	if o.label != "" {
+		a.setReadCounters(ac, o.label)
+	}
+	return ac
+}
+
+// StaleContextError - aggregator changed after context was created, so that context may return wrong data: unwind
+// happened (context may serve data beyond unwind point) or Prune deleted DB history which context reads from DB,
+// because its files end before it. Build and merge of files don't make contexts stale: context keeps its files open.
+// Caller must create new context by MakeContext.
+type StaleContextError struct {
+	Generation, Current uint64
+	FilesEnd, PrunedTo  uint64
+}
+
+func (e *StaleContextError) Error() string {
+	if e.Generation != e.Current {
+		return fmt.Sprintf("stale AggregatorV3Context: generation %d, current %d", e.Generation, e.Current)
+	}
+	return fmt.Sprintf("stale AggregatorV3Context: files end at txNum %d, DB is pruned to %d", e.FilesEnd, e.PrunedTo)
+}
+func (e *StaleContextError) Unwrap() error { return ErrContextStale }
+
+// Stale - true if context must be re-created, see StaleContextError
+func (ac *AggregatorV3Context) Stale() bool { return ac.checkGeneration() != nil }
+
+func (ac *AggregatorV3Context) checkGeneration() error {
+	current, prunedTo := ac.a.generation.Load(), ac.a.prunedTo.Load()
+	if current != ac.generation || prunedTo > ac.filesEnd {
+		return &StaleContextError{Generation: ac.generation, Current: current, FilesEnd: ac.filesEnd, PrunedTo: prunedTo}
+	}
+	return nil
+}
+
+// refFiles - all files of aggregator, with incremented refcount. Under filesLock
+func (a *AggregatorV3) refFiles() (items []*filesItem) {
+	ref := func(item *filesItem) bool {
+		item.ref()
+		items = append(items, item)
+		return true
+	}
+	for _, h := range []*History{a.accounts, a.storage, a.code, a.commitment} {
+		h.files.Ascend(ref)
+		h.InvertedIndex.files.Ascend(ref)
+	}
+	for _, ii := range []*InvertedIndex{a.logAddrs, a.logTopics, a.tracesFrom, a.tracesTo} {
+		ii.files.Ascend(ref)
+	}
+	return items
+}
+
+func (ac *AggregatorV3Context) SetTx(tx kv.Tx) {
+	ac.tx = tx
+	ac.accounts.SetTx(tx)
+	ac.storage.SetTx(tx)
+	ac.code.SetTx(tx)
+}
+
+// Close - releases files of context: files merged after MakeContext are closed by last context which uses them
+func (ac *AggregatorV3Context) Close() {
+	if ac.files == nil {
+		return
+	}
+	ac.a.filesLock.RLock()
+	defer ac.a.filesLock.RUnlock()
+	for _, item := range ac.files {
+		item.unref()
+	}
+	ac.files = nil
 }
-func (ac *AggregatorV3Context) SetTx(tx kv.Tx) { ac.tx = tx }
-func (ac *AggregatorV3Context) Close()         {}
 
 // BackgroundResult - used only indicate that some work is done
 // no much reason to pass exact results by this object, just get latest state when need
@@ -0,0 +1,107 @@
+/*
+   Copyright 2022 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package state
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/ledgerwatch/log/v3"
+
+	"github.com/ledgerwatch/erigon-lib/common"
+	"github.com/ledgerwatch/erigon-lib/common/length"
+	"github.com/ledgerwatch/erigon-lib/kv"
+)
+
+// CrossCheckMismatch - key of PlainState for which History has changes after current txNum: means latest value
+// reconstructed from history (value before first change after txNum) is not value from PlainState.
+type CrossCheckMismatch struct {
+	Key          []byte // PlainState key
+	PlainValue   []byte
+	HistoryValue []byte
+}
+
+type CrossCheckReport struct {
+	TxNum      uint64 // state checked as of this txNum
+	Checked    int
+	Mismatches []CrossCheckMismatch
+}
+
+func (r CrossCheckReport) String() string {
+	return fmt.Sprintf("txNum=%d, checked=%d, mismatches=%d", r.TxNum, r.Checked, len(r.Mismatches))
+}
+
+// CrossCheck - post-sync sanity check: samples keys of PlainState (accounts and storage) and checks that
+// History agrees with PlainState as of current txNum (last value passed to SetTxNum): history must have no changes
+// of key after txNum. Catches partial unwinds/prunes and desync of state and history.
+// sampleRate in (0, 1] - share of keys to check, 1 - check all keys. Reads go through LaneBackground.
+func (a *AggregatorV3) CrossCheck(ctx context.Context, tx kv.Tx, sampleRate float64) (CrossCheckReport, error) {
+	report := CrossCheckReport{TxNum: a.txNum.Load() + 1}
+	if sampleRate <= 0 || sampleRate > 1 {
+		return report, fmt.Errorf("CrossCheck: sampleRate must be in (0, 1], got %f", sampleRate)
+	}
+	ac := a.MakeContext(WithLane(LaneBackground))
+	defer ac.Close()
+	ac.SetTx(tx)
+
+	logEvery := time.NewTicker(30 * time.Second)
+	defer logEvery.Stop()
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano())) // nolint: gosec
+
+	storageKeyLen := length.Addr + length.Incarnation + length.Hash
+	if err := tx.ForEach(kv.PlainState, nil, func(k, v []byte) error {
+		if sampleRate < 1 && rnd.Float64() >= sampleRate {
+			return nil
+		}
+		var histV []byte
+		var changed bool
+		var err error
+		switch len(k) {
+		case length.Addr:
+			histV, changed, err = ac.ReadAccountDataNoStateWithRecent(k, report.TxNum)
+		case storageKeyLen:
+			histV, changed, err = ac.ReadAccountStorageNoStateWithRecent(k[:length.Addr], k[length.Addr+length.Incarnation:], report.TxNum)
+		default:
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		report.Checked++
+		if changed {
+			report.Mismatches = append(report.Mismatches, CrossCheckMismatch{
+				Key:          common.Copy(k),
+				PlainValue:   common.Copy(v),
+				HistoryValue: common.Copy(histV),
+			})
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-logEvery.C:
+			log.Info("[snapshots] cross-check", "key", fmt.Sprintf("%x", k), "checked", report.Checked, "mismatches", len(report.Mismatches))
+		default:
+		}
+		return nil
+	}); err != nil {
+		return report, fmt.Errorf("CrossCheck: %w", err)
+	}
+	return report, nil
+}
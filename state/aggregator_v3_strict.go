@@ -0,0 +1,121 @@
+/*
+   Copyright 2022 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package state
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/btree"
+)
+
+// Strict mode - runtime checks of invariants. Violation means corruption or bug in caller, so it panics right
+// where it happened - with dump of related state - instead of wrong answers at query time.
+// Enabled by `SetStrict(true)` or env STRICT_STATE=true. Checks:
+//   - txNum passed to SetTxNum is monotonic (per AggregatorWriter too). Only Unwind can move it back.
+//   - files of each entity are sorted and non-overlapping after integration of built or merged files
+//   - prune never deletes data which is not in files yet (txTo <= maxTxNum)
+
+func (a *AggregatorV3) SetStrict(v bool) { a.strict.Store(v) }
+func (a *AggregatorV3) Strict() bool     { return a.strict.Load() }
+
+func strictViolation(format string, args ...any) {
+	panic("[strict] invariant violated: " + fmt.Sprintf(format, args...))
+}
+
+func (a *AggregatorV3) checkTxNum(txNum uint64) {
+	if !a.strict.Load() {
+		return
+	}
+	if prev := a.strictTxNum.Load(); txNum < prev {
+		strictViolation("txNum is not monotonic: %d after %d", txNum, prev)
+	}
+	a.strictTxNum.Store(txNum)
+}
+
+func (w *AggregatorWriter) checkTxNum(txNum uint64) {
+	if !w.a.strict.Load() {
+		return
+	}
+	if w.hasTxNum && txNum < w.lastTxNum {
+		strictViolation("txNum of writer is not monotonic: %d after %d", txNum, w.lastTxNum)
+	}
+	w.lastTxNum, w.hasTxNum = txNum, true
+}
+
+func (a *AggregatorV3) checkPrune(txFrom, txTo uint64) {
+	if !a.strict.Load() {
+		return
+	}
+	if maxTxNum := a.maxTxNum.Load(); txTo > maxTxNum {
+		strictViolation("prune of [%d, %d) exceeds maxTxNum=%d (data not in files yet). files:\n%s", txFrom, txTo, maxTxNum, a.dumpFiles())
+	}
+}
+
+func (a *AggregatorV3) checkFiles() {
+	if !a.strict.Load() {
+		return
+	}
+	for _, h := range []*History{a.accounts, a.storage, a.code} {
+		if err := checkFilesOrder(h.filenameBase+".v", h.files); err != nil {
+			strictViolation("%s. files:\n%s", err, a.dumpFiles())
+		}
+	}
+	for _, ii := range []*InvertedIndex{a.accounts.InvertedIndex, a.storage.InvertedIndex, a.code.InvertedIndex, a.logAddrs, a.logTopics, a.tracesFrom, a.tracesTo} {
+		if err := checkFilesOrder(ii.filenameBase+".ef", ii.files); err != nil {
+			strictViolation("%s. files:\n%s", err, a.dumpFiles())
+		}
+	}
+}
+
+func checkFilesOrder(name string, files *btree.BTreeG[*filesItem]) (err error) {
+	var prev *filesItem
+	files.Ascend(func(item *filesItem) bool {
+		if item.startTxNum >= item.endTxNum {
+			err = fmt.Errorf("%s: empty range %d-%d", name, item.startTxNum, item.endTxNum)
+			return false
+		}
+		if prev != nil && item.startTxNum < prev.endTxNum {
+			err = fmt.Errorf("%s: overlapping files %d-%d and %d-%d", name, prev.startTxNum, prev.endTxNum, item.startTxNum, item.endTxNum)
+			return false
+		}
+		prev = item
+		return true
+	})
+	return err
+}
+
+func (a *AggregatorV3) dumpFiles() string {
+	var sb strings.Builder
+	dump := func(name string, files *btree.BTreeG[*filesItem]) {
+		sb.WriteString(name)
+		sb.WriteString(":")
+		files.Ascend(func(item *filesItem) bool {
+			fmt.Fprintf(&sb, " %d-%d", item.startTxNum/a.aggregationStep, item.endTxNum/a.aggregationStep)
+			return true
+		})
+		sb.WriteString("\n")
+	}
+	for _, h := range []*History{a.accounts, a.storage, a.code} {
+		dump(h.filenameBase+".ef", h.InvertedIndex.files)
+		dump(h.filenameBase+".v", h.files)
+	}
+	for _, ii := range []*InvertedIndex{a.logAddrs, a.logTopics, a.tracesFrom, a.tracesTo} {
+		dump(ii.filenameBase+".ef", ii.files)
+	}
+	return sb.String()
+}
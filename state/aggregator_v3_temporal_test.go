@@ -0,0 +1,136 @@
+package state
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/ledgerwatch/log/v3"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/ledgerwatch/erigon-lib/gointerfaces"
+	"github.com/ledgerwatch/erigon-lib/gointerfaces/remote"
+	"github.com/ledgerwatch/erigon-lib/kv"
+	"github.com/ledgerwatch/erigon-lib/kv/iter"
+	"github.com/ledgerwatch/erigon-lib/kv/order"
+	"github.com/ledgerwatch/erigon-lib/kv/remotedb"
+	"github.com/ledgerwatch/erigon-lib/kv/remotedbserver"
+	"github.com/ledgerwatch/erigon-lib/state/temporaltests"
+)
+
+// testTemporalDB - minimal embedded kv.TemporalRoDb over AggregatorV3: accounts domain/history/index
+type testTemporalDB struct {
+	kv.RwDB
+	agg *AggregatorV3
+}
+
+var testTemporalNames = temporaltests.Names{Domain: "accounts", History: "accounts", Index: "accounts"}
+
+func (db *testTemporalDB) BeginRo(ctx context.Context) (kv.Tx, error) { return db.BeginTemporalRo(ctx) }
+func (db *testTemporalDB) View(ctx context.Context, f func(tx kv.Tx) error) error {
+	return db.ViewTemporal(ctx, func(tx kv.TemporalTx) error { return f(tx) })
+}
+func (db *testTemporalDB) BeginTemporalRo(ctx context.Context) (kv.TemporalTx, error) {
+	tx, err := db.RwDB.BeginRo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ac := db.agg.MakeContext()
+	ac.SetTx(tx)
+	return &testTemporalTx{Tx: tx, ac: ac}, nil
+}
+func (db *testTemporalDB) ViewTemporal(ctx context.Context, f func(tx kv.TemporalTx) error) error {
+	tx, err := db.BeginTemporalRo(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	return f(tx)
+}
+
+type testTemporalTx struct {
+	kv.Tx
+	ac *AggregatorV3Context
+}
+
+func (tx *testTemporalTx) HistoryGet(name kv.History, k []byte, ts uint64) ([]byte, bool, error) {
+	if name != testTemporalNames.History {
+		return nil, false, kv.ErrNotSupported
+	}
+	return tx.ac.ReadAccountDataNoStateWithRecent(k, ts)
+}
+func (tx *testTemporalTx) DomainGet(name kv.Domain, k, k2 []byte, ts uint64) ([]byte, bool, error) {
+	if name != testTemporalNames.Domain {
+		return nil, false, kv.ErrNotSupported
+	}
+	v, ok, err := tx.HistoryGet(testTemporalNames.History, k, ts)
+	if err != nil {
+		return nil, false, err
+	}
+	if !ok {
+		if v, err = tx.GetOne(kv.PlainState, k); err != nil {
+			return nil, false, err
+		}
+	}
+	return v, len(v) > 0, nil
+}
+func (tx *testTemporalTx) IndexRange(name kv.InvertedIdx, k []byte, fromTs, toTs int, asc order.By, limit int) (iter.U64, error) {
+	if name != testTemporalNames.Index {
+		return nil, kv.ErrNotSupported
+	}
+	it, err := tx.ac.AccountHistoyIdxIterator(k, fromTs, toTs, asc, limit, tx.Tx)
+	if err != nil {
+		return nil, err
+	}
+	return it, nil
+}
+
+func testTemporalSetup(t *testing.T, changes []temporaltests.Change, latest map[string][]byte) *testTemporalDB {
+	t.Helper()
+	_, db, agg := testDbAndAggregatorV3(t, 4) // small step: history of suite is partially in files
+	ctx := context.Background()
+	require.NoError(t, db.Update(ctx, func(tx kv.RwTx) error {
+		agg.SetTx(tx)
+		defer agg.StartWrites().FinishWrites()
+		for _, c := range changes {
+			agg.SetTxNum(c.Ts)
+			if err := agg.AddAccountPrev(c.Key, c.Prev); err != nil {
+				return err
+			}
+		}
+		for k, v := range latest {
+			if err := tx.Put(kv.PlainState, []byte(k), v); err != nil {
+				return err
+			}
+		}
+		return agg.Flush(ctx, tx)
+	}))
+	agg.SetTxNum(changes[len(changes)-1].Ts + 4*agg.aggregationStep)
+	require.NoError(t, agg.BuildFiles(ctx, db))
+	require.NotZero(t, agg.EndTxNumMinimax())
+	return &testTemporalDB{RwDB: db, agg: agg}
+}
+
+func TestAggregatorV3_TemporalConformance(t *testing.T) {
+	t.Run("embedded", func(t *testing.T) {
+		temporaltests.Run(t, testTemporalNames, func(t *testing.T, changes []temporaltests.Change, latest map[string][]byte) kv.TemporalRoDb {
+			return testTemporalSetup(t, changes, latest)
+		})
+	})
+	t.Run("remote", func(t *testing.T) {
+		temporaltests.Run(t, testTemporalNames, func(t *testing.T, changes []temporaltests.Change, latest map[string][]byte) kv.TemporalRoDb {
+			ctx, db := context.Background(), testTemporalSetup(t, changes, latest)
+			grpcServer, conn := grpc.NewServer(), bufconn.Listen(1024*1024)
+			remote.RegisterKVServer(grpcServer, remotedbserver.NewKvServer(ctx, db, nil, nil))
+			go grpcServer.Serve(conn) //nolint:errcheck
+			t.Cleanup(grpcServer.Stop)
+			cc, err := grpc.Dial("", grpc.WithInsecure(), grpc.WithContextDialer(func(ctx context.Context, url string) (net.Conn, error) { return conn.Dial() }))
+			require.NoError(t, err)
+			rdb, err := remotedb.NewRemote(gointerfaces.VersionFromProto(remotedbserver.KvServiceAPIVersion), log.New(), remote.NewKVClient(cc)).Open()
+			require.NoError(t, err)
+			return rdb
+		})
+	})
+}
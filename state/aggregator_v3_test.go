@@ -0,0 +1,1311 @@
+package state
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/btree"
+	"github.com/ledgerwatch/log/v3"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/ledgerwatch/erigon-lib/commitment"
+	"github.com/ledgerwatch/erigon-lib/common"
+	"github.com/ledgerwatch/erigon-lib/compress"
+	"github.com/ledgerwatch/erigon-lib/etl"
+	"github.com/ledgerwatch/erigon-lib/kv"
+	"github.com/ledgerwatch/erigon-lib/kv/mdbx"
+	"github.com/ledgerwatch/erigon-lib/kv/order"
+)
+
+func testDbAndAggregatorV3(t *testing.T, aggStep uint64) (string, kv.RwDB, *AggregatorV3) {
+	t.Helper()
+	path := t.TempDir()
+	t.Cleanup(func() { os.RemoveAll(path) })
+	logger := log.New()
+	db := mdbx.NewMDBX(logger).InMem(filepath.Join(path, "db4")).WithTableCfg(func(defaultBuckets kv.TableCfg) kv.TableCfg {
+		return kv.ChaindataTablesCfg
+	}).MustOpen()
+	t.Cleanup(db.Close)
+	agg, err := NewAggregatorV3(context.Background(), path, filepath.Join(path, "e4tmp"), aggStep, db)
+	require.NoError(t, err)
+	require.NoError(t, agg.ReopenFiles())
+	t.Cleanup(agg.Close)
+	return path, db, agg
+}
+
+// fillAggregatorV3 - commits `txs` txs, each writes prev value of one of 5 accounts and it's log address
+func fillAggregatorV3(t *testing.T, db kv.RwDB, agg *AggregatorV3, txs uint64) {
+	t.Helper()
+	ctx := context.Background()
+	tx, err := db.BeginRw(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback()
+	agg.SetTx(tx)
+	agg.StartWrites()
+	addr := make([]byte, 20)
+	for txNum := uint64(0); txNum < txs; txNum++ {
+		agg.SetTxNum(txNum)
+		binary.BigEndian.PutUint64(addr, txNum%5)
+		require.NoError(t, agg.AddAccountPrev(addr, []byte{byte(txNum)}))
+		require.NoError(t, agg.AddLogAddr(addr))
+	}
+	require.NoError(t, agg.Flush(ctx, tx))
+	agg.FinishWrites()
+	require.NoError(t, tx.Commit())
+}
+
+func TestAggregatorV3_BlockBoundaries(t *testing.T) {
+	_, db, agg := testDbAndAggregatorV3(t, 16)
+	ctx := context.Background()
+
+	tx, err := db.BeginRw(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback()
+	agg.SetTx(tx)
+	defer agg.StartWrites().FinishWrites()
+
+	// 10 blocks, 3 txs in each
+	txNum := uint64(0)
+	addr := make([]byte, 20)
+	for blockNum := uint64(0); blockNum < 10; blockNum++ {
+		agg.BeginBlock(blockNum)
+		for i := 0; i < 3; i++ {
+			agg.SetTxNum(txNum)
+			binary.BigEndian.PutUint64(addr, txNum)
+			require.NoError(t, agg.AddAccountPrev(addr, []byte{1}))
+			txNum++
+		}
+		require.NoError(t, agg.EndBlock())
+	}
+	require.NoError(t, agg.Flush(ctx, tx))
+
+	from, to, ok, err := agg.BlockTxNums(tx, 5)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, uint64(15), from)
+	require.Equal(t, uint64(17), to)
+
+	// block without txs - empty range after previous block
+	agg.BeginBlock(10)
+	require.NoError(t, agg.EndBlock())
+	from, to, ok, err = agg.BlockTxNums(tx, 10)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, txNum, from)
+	require.Equal(t, txNum-1, to)
+
+	ac := agg.MakeContext()
+	it, err := ac.AccountHistoryIterateChangedInBlock(5, tx)
+	require.NoError(t, err)
+	changed := 0
+	for it.HasNext() {
+		_, _, err = it.Next()
+		require.NoError(t, err)
+		changed++
+	}
+	require.Equal(t, 3, changed)
+	it, err = ac.AccountHistoryIterateChangedInBlock(10, tx)
+	require.NoError(t, err)
+	require.False(t, it.HasNext())
+
+	require.NoError(t, agg.UnwindToBlock(ctx, 5, etl.IdentityLoadFunc))
+	_, _, ok, err = agg.BlockTxNums(tx, 5)
+	require.NoError(t, err)
+	require.True(t, ok)
+	_, _, ok, err = agg.BlockTxNums(tx, 6)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	require.Error(t, agg.EndBlock())
+}
+
+func TestAggregatorV3_PlanMerges(t *testing.T) {
+	aggStep := uint64(16)
+	_, db, agg := testDbAndAggregatorV3(t, aggStep)
+	ctx := context.Background()
+
+	fillAggregatorV3(t, db, agg, aggStep*6)
+
+	require.NoError(t, agg.BuildFiles(ctx, db))
+	require.Greater(t, agg.EndTxNumMinimax(), aggStep)
+
+	maxSpan := aggStep * StepsInBiggestFile
+	plans := agg.PlanMerges(maxSpan)
+	require.NotEmpty(t, plans)
+	for _, p := range plans {
+		require.Equal(t, uint64(0), p.StartStep)
+		require.Equal(t, uint64(2), p.EndStep)
+		require.NotEmpty(t, p.InputFiles)
+		require.Greater(t, p.InputSize, int64(0))
+		require.GreaterOrEqual(t, p.EstimatedReadBytes, p.InputSize)
+	}
+
+	// planning has no side effects
+	require.Equal(t, len(plans), len(agg.PlanMerges(maxSpan)))
+	require.NoError(t, agg.MergeLoop(ctx, 1))
+	require.Empty(t, agg.PlanMerges(maxSpan))
+}
+
+func TestAggregatorV3_MergeIO(t *testing.T) {
+	aggStep := uint64(16)
+	_, db, agg := testDbAndAggregatorV3(t, aggStep)
+	ctx := context.Background()
+	agg.SetMergeIO(NewMergeIO(64*1024*1024, true))
+
+	fillAggregatorV3(t, db, agg, aggStep*6)
+
+	require.NoError(t, agg.BuildFiles(ctx, db))
+	require.NoError(t, agg.MergeLoop(ctx, 1))
+	require.Empty(t, agg.PlanMerges(aggStep*StepsInBiggestFile))
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	require.NoError(t, (*MergeIO)(nil).throttle(cancelled, 1<<30))
+	require.Error(t, NewMergeIO(1, false).throttle(cancelled, 1<<30))
+}
+
+func TestAggregatorV3_ReadStats(t *testing.T) {
+	aggStep := uint64(16)
+	_, db, agg := testDbAndAggregatorV3(t, aggStep)
+	ctx := context.Background()
+
+	fillAggregatorV3(t, db, agg, aggStep*4)
+	addr := make([]byte, 20)
+	require.NoError(t, agg.BuildFiles(ctx, db))
+
+	roTx, err := db.BeginRo(ctx)
+	require.NoError(t, err)
+	defer roTx.Rollback()
+
+	binary.BigEndian.PutUint64(addr, 1)
+	ac := agg.MakeContext(WithLabel("eth_getLogs"))
+	it, err := ac.LogAddrIterator(addr, 0, int(aggStep), true, -1, roTx)
+	require.NoError(t, err)
+	require.NotEmpty(t, it.ToArray())
+
+	ac = agg.MakeContext(WithLabel("eth_getBalance"))
+	v, ok, err := ac.ReadAccountDataNoState(addr, 2)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, []byte{6}, v)
+
+	// not labeled - not accounted
+	_, _, err = agg.MakeContext().ReadAccountDataNoState(addr, 2)
+	require.NoError(t, err)
+
+	stats := agg.ReadStats()
+	require.Equal(t, 2, len(stats))
+	require.Greater(t, stats["eth_getLogs"]["logaddrs"].Lookups, uint64(0))
+	require.Greater(t, stats["eth_getLogs"]["logaddrs"].Bytes, uint64(0))
+	require.Zero(t, stats["eth_getLogs"]["accounts"].Lookups)
+	require.Greater(t, stats["eth_getBalance"]["accounts"].Lookups, uint64(0))
+	require.Greater(t, stats["eth_getBalance"]["accounts"].Bytes, uint64(0))
+	require.Zero(t, stats["eth_getBalance"]["logaddrs"].Lookups)
+}
+
+func TestAggregatorV3_ReadLanes(t *testing.T) {
+	lanes := newReadLanes(4, time.Second)
+	ctx := context.Background()
+
+	// no interactive reads - background doesn't wait
+	start := time.Now()
+	require.NoError(t, lanes.yield(ctx))
+	require.Less(t, time.Since(start), 100*time.Millisecond)
+
+	// background waits until interactive read is done
+	release := lanes.enter()
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		release()
+	}()
+	start = time.Now()
+	require.NoError(t, lanes.yield(ctx))
+	require.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+
+	// but not longer than maxYield
+	release = lanes.enter()
+	short := &readLanes{sem: lanes.sem, size: lanes.size, maxYield: 20 * time.Millisecond}
+	require.NoError(t, short.yield(ctx))
+	// and stops on cancel
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	require.ErrorIs(t, lanes.yield(cctx), context.Canceled)
+	release()
+
+	// nil lanes - no-op
+	var nilLanes *readLanes
+	nilLanes.enter()()
+	require.NoError(t, nilLanes.yield(ctx))
+	require.NoError(t, nilLanes.scan().yield(ctx))
+
+	// scan yields once per batch of rows, within total budget
+	release = lanes.enter()
+	scan := lanes.scan()
+	start = time.Now()
+	for i := 0; i < backgroundYieldRows-1; i++ {
+		require.NoError(t, scan.yield(ctx))
+	}
+	require.Less(t, time.Since(start), 100*time.Millisecond)
+	scan.budget = 20 * time.Millisecond
+	require.NoError(t, scan.yield(ctx))
+	require.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+	require.LessOrEqual(t, scan.budget, time.Duration(0))
+	start = time.Now()
+	for i := 0; i < 2*backgroundYieldRows; i++ {
+		require.NoError(t, scan.yield(ctx))
+	}
+	require.Less(t, time.Since(start), 100*time.Millisecond)
+	release()
+
+	_, _, agg := testDbAndAggregatorV3(t, 16)
+	addr := make([]byte, 20)
+	_, _, err := agg.MakeContext(WithLane(LaneBackground)).ReadAccountDataNoState(addr, 0)
+	require.NoError(t, err)
+	agg.SetBackgroundMaxYield(0)
+	require.Nil(t, agg.accounts.lanes)
+	require.Nil(t, agg.tracesTo.lanes)
+}
+
+func TestAggregatorV3_FDLimit(t *testing.T) {
+	aggStep := uint64(16)
+	_, db, agg := testDbAndAggregatorV3(t, aggStep)
+	ctx := context.Background()
+	agg.SetFDLimit(4)
+
+	fillAggregatorV3(t, db, agg, aggStep*4)
+	addr := make([]byte, 20)
+	require.NoError(t, agg.BuildFiles(ctx, db))
+
+	stats := agg.FDStats()
+	require.Equal(t, 4, stats.Open)
+	require.Greater(t, stats.Released, uint64(0))
+
+	// reads are not affected by released descriptors
+	binary.BigEndian.PutUint64(addr, 1)
+	v, ok, err := agg.MakeContext().ReadAccountDataNoState(addr, 2)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, []byte{6}, v)
+
+	// reopen of files reopens descriptors of released ones, but stays in limit
+	require.NoError(t, agg.accounts.openFiles())
+	stats = agg.FDStats()
+	require.Equal(t, 4, stats.Open)
+	require.Greater(t, stats.Reopened, uint64(0))
+
+	agg.SetFDLimit(0)
+	require.Zero(t, agg.FDStats().Open)
+}
+
+func TestAggregatorV3_Writers(t *testing.T) {
+	aggStep := uint64(16)
+	_, db, agg := testDbAndAggregatorV3(t, aggStep)
+	ctx := context.Background()
+
+	tx, err := db.BeginRw(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback()
+	agg.SetTx(tx)
+	defer agg.StartWrites().FinishWrites()
+
+	// 4 writers, each writes every 4-th txNum
+	const workers = 4
+	txs := aggStep * 2
+	g := errgroup.Group{}
+	for i := 0; i < workers; i++ {
+		w := agg.NewWriter()
+		defer w.Close()
+		i := i
+		g.Go(func() error {
+			addr := make([]byte, 20)
+			for txNum := uint64(i); txNum < txs; txNum += workers {
+				w.SetTxNum(txNum)
+				binary.BigEndian.PutUint64(addr, txNum)
+				if err := w.AddAccountPrev(addr, []byte{byte(txNum)}); err != nil {
+					return err
+				}
+				if err := w.AddLogAddr(addr); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	require.NoError(t, g.Wait())
+	agg.SetTxNum(txs - 1)
+	require.NoError(t, agg.Flush(ctx, tx))
+
+	ac := agg.MakeContext()
+	ac.SetTx(tx)
+	addr := make([]byte, 20)
+	for txNum := uint64(0); txNum < txs; txNum++ {
+		binary.BigEndian.PutUint64(addr, txNum)
+		v, ok, err := ac.ReadAccountDataNoStateWithRecent(addr, txNum)
+		require.NoError(t, err)
+		require.True(t, ok, txNum)
+		require.Equal(t, []byte{byte(txNum)}, v)
+
+		it, err := ac.LogAddrIterator(addr, 0, int(txs), true, -1, tx)
+		require.NoError(t, err)
+		require.Equal(t, []uint64{txNum}, it.ToArray())
+	}
+}
+
+func TestAggregatorV3_Strict(t *testing.T) {
+	aggStep := uint64(16)
+	_, db, agg := testDbAndAggregatorV3(t, aggStep)
+	agg.SetStrict(true)
+	ctx := context.Background()
+
+	tx, err := db.BeginRw(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback()
+	agg.SetTx(tx)
+	defer agg.StartWrites().FinishWrites()
+
+	agg.SetTxNum(5)
+	agg.SetTxNum(5)
+	require.Panics(t, func() { agg.SetTxNum(4) })
+	require.NoError(t, agg.Unwind(ctx, 3, etl.IdentityLoadFunc))
+	agg.SetTxNum(3)
+
+	w := agg.NewWriter()
+	defer w.Close()
+	w.SetTxNum(10)
+	require.Panics(t, func() { w.SetTxNum(9) })
+
+	require.Panics(t, func() { _ = agg.prune(ctx, 0, aggStep, 1) })
+
+	files := btree.NewG[*filesItem](32, filesItemLess)
+	files.ReplaceOrInsert(&filesItem{startTxNum: 0, endTxNum: 16})
+	files.ReplaceOrInsert(&filesItem{startTxNum: 16, endTxNum: 32})
+	require.NoError(t, checkFilesOrder("test", files))
+	files.ReplaceOrInsert(&filesItem{startTxNum: 8, endTxNum: 48})
+	require.Error(t, checkFilesOrder("test", files))
+}
+
+func TestAggregatorV3_DiscardHistoryValues(t *testing.T) {
+	_, db, agg := testDbAndAggregatorV3(t, 16)
+	ctx := context.Background()
+
+	tx, err := db.BeginRw(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback()
+	agg.SetTx(tx)
+	agg.DiscardHistoryValues()
+
+	addr := make([]byte, 20)
+	for txNum := uint64(0); txNum < 10; txNum++ {
+		agg.SetTxNum(txNum)
+		binary.BigEndian.PutUint64(addr, txNum%2)
+		require.NoError(t, agg.AddAccountPrev(addr, []byte{byte(txNum)}))
+		require.NoError(t, agg.AddLogAddr(addr))
+	}
+	require.NoError(t, agg.Flush(ctx, tx))
+	agg.FinishWrites()
+
+	k, err := kv.FirstKey(tx, kv.AccountHistoryVals)
+	require.NoError(t, err)
+	require.Nil(t, k)
+
+	// values of txNums after discarded range are kept
+	defer agg.StartWrites().FinishWrites()
+	for txNum := uint64(10); txNum < 12; txNum++ {
+		agg.SetTxNum(txNum)
+		binary.BigEndian.PutUint64(addr, txNum%2)
+		require.NoError(t, agg.AddAccountPrev(addr, []byte{byte(txNum)}))
+	}
+	require.NoError(t, agg.Flush(ctx, tx))
+
+	ac := agg.MakeContext()
+	ac.SetTx(tx)
+	binary.BigEndian.PutUint64(addr, 1)
+	it, err := ac.AccountHistoyIdxIterator(addr, 0, 10, true, -1, tx)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{1, 3, 5, 7, 9}, it.ToArray())
+	it, err = ac.LogAddrIterator(addr, 0, 10, true, -1, tx)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{1, 3, 5, 7, 9}, it.ToArray())
+
+	_, _, err = ac.ReadAccountDataNoStateWithRecent(addr, 2)
+	require.ErrorIs(t, err, ErrHistoryValuesDiscarded)
+	v, ok, err := ac.ReadAccountDataNoStateWithRecent(addr, 10)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, []byte{11}, v)
+
+	// ranges are persisted: read after restart
+	agg.accounts.discarded = discardedRanges{}
+	_, _, err = ac.ReadAccountDataNoStateWithRecent(addr, 2)
+	require.ErrorIs(t, err, ErrHistoryValuesDiscarded)
+}
+
+func TestAggregatorV3_CrossCheck(t *testing.T) {
+	_, db, agg := testDbAndAggregatorV3(t, 16)
+	ctx := context.Background()
+
+	tx, err := db.BeginRw(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback()
+	agg.SetTx(tx)
+	defer agg.StartWrites().FinishWrites()
+
+	// account N changed at txNum N
+	for txNum := uint64(0); txNum < 10; txNum++ {
+		addr := make([]byte, 20)
+		binary.BigEndian.PutUint64(addr, txNum)
+		agg.SetTxNum(txNum)
+		require.NoError(t, agg.AddAccountPrev(addr, nil))
+		require.NoError(t, tx.Put(kv.PlainState, addr, []byte{byte(txNum)}))
+	}
+	require.NoError(t, agg.Flush(ctx, tx))
+
+	report, err := agg.CrossCheck(ctx, tx, 1)
+	require.NoError(t, err)
+	require.Equal(t, 10, report.Checked)
+	require.Empty(t, report.Mismatches)
+
+	// state of txNum=4, but history has changes of 5..9
+	agg.SetTxNum(4)
+	report, err = agg.CrossCheck(ctx, tx, 1)
+	require.NoError(t, err)
+	require.Equal(t, 5, len(report.Mismatches))
+	require.Equal(t, []byte{5}, report.Mismatches[0].PlainValue)
+
+	_, err = agg.CrossCheck(ctx, tx, 0)
+	require.Error(t, err)
+}
+
+// recordingFS - OsFS which remembers opened files
+type recordingFS struct {
+	OsFS
+	mu     sync.Mutex
+	opened map[string]struct{}
+}
+
+func (fsys *recordingFS) Open(path string) (string, error) {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+	fsys.opened[filepath.Base(path)] = struct{}{}
+	return fsys.OsFS.Open(path)
+}
+
+func TestAggregatorV3_FS(t *testing.T) {
+	aggStep := uint64(16)
+	path, db, agg := testDbAndAggregatorV3(t, aggStep)
+	ctx := context.Background()
+
+	fillAggregatorV3(t, db, agg, aggStep*4)
+	require.NoError(t, agg.BuildFiles(ctx, db))
+	agg.Close()
+
+	fsys := &recordingFS{opened: map[string]struct{}{}}
+	agg, err := NewAggregatorV3(ctx, path, filepath.Join(path, "e4tmp"), aggStep, db)
+	require.NoError(t, err)
+	defer agg.Close()
+	agg.SetFS(fsys)
+	require.NoError(t, agg.ReopenFiles())
+
+	for _, name := range []string{"accounts.0-1.v", "accounts.0-1.vi", "accounts.0-1.ef", "accounts.0-1.efi", "logaddrs.0-1.ef", "logaddrs.0-1.efi"} {
+		require.Contains(t, fsys.opened, name)
+	}
+}
+
+func TestAggregatorV3_ReadThroughFS(t *testing.T) {
+	aggStep := uint64(16)
+	storePath, db, agg := testDbAndAggregatorV3(t, aggStep)
+	ctx := context.Background()
+
+	tx, err := db.BeginRw(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback()
+	agg.SetTx(tx)
+	agg.StartWrites()
+	addr := make([]byte, 20)
+	for txNum := uint64(0); txNum < aggStep*4; txNum++ {
+		agg.SetTxNum(txNum)
+		binary.BigEndian.PutUint64(addr, txNum%5)
+		require.NoError(t, agg.AddAccountPrev(addr, []byte{byte(txNum)}))
+	}
+	require.NoError(t, agg.Flush(ctx, tx))
+	agg.FinishWrites()
+	require.NoError(t, tx.Commit())
+	require.NoError(t, agg.BuildFiles(ctx, db))
+	agg.Close()
+
+	// FileMeta is not enabled by default
+	d, err := compress.NewDecompressor(filepath.Join(storePath, "accounts.0-1.v"))
+	require.NoError(t, err)
+	require.Nil(t, d.Metadata())
+	d.Close()
+
+	for _, budget := range []int64{1 << 30, 1} {
+		path := t.TempDir()
+		fsys := NewReadThroughFS(ctx, DirObjectStore(storePath), budget)
+		thin, err := NewAggregatorV3(ctx, path, filepath.Join(path, "e4tmp"), aggStep, db)
+		require.NoError(t, err)
+		thin.SetFS(fsys)
+		require.NoError(t, thin.ReopenFiles())
+
+		binary.BigEndian.PutUint64(addr, 1)
+		v, ok, err := thin.MakeContext().ReadAccountDataNoState(addr, 2)
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, []byte{6}, v)
+
+		// open files are not evicted and counted by budget
+		require.FileExists(t, filepath.Join(path, "accounts.0-1.v"))
+		require.Greater(t, fsys.CachedBytes(), int64(1))
+		thin.Close()
+
+		if budget > 1 {
+			require.FileExists(t, filepath.Join(path, "accounts.0-1.v"))
+		} else {
+			require.NoFileExists(t, filepath.Join(path, "accounts.0-1.v"))
+			require.Equal(t, int64(0), fsys.CachedBytes())
+		}
+	}
+}
+
+// countingStore - DirObjectStore which counts Get calls
+type countingStore struct {
+	DirObjectStore
+	gets atomic.Int32
+}
+
+func (s *countingStore) Get(ctx context.Context, name string, w io.Writer) error {
+	s.gets.Inc()
+	return s.DirObjectStore.Get(ctx, name, w)
+}
+
+func TestReadThroughFS(t *testing.T) {
+	ctx := context.Background()
+	storePath, path := t.TempDir(), t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(storePath, "a.v"), make([]byte, 100), 0644))
+	store := &countingStore{DirObjectStore: DirObjectStore(storePath)}
+	fsys := NewReadThroughFS(ctx, store, 1)
+
+	entries, err := fsys.ReadDir(path)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(entries))
+	require.False(t, fsys.Exists(filepath.Join(path, "a.v")))
+
+	// concurrent Open of same file wait for one fetch
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := fsys.Open(filepath.Join(path, "a.v"))
+			require.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, int32(1), store.gets.Load())
+	require.True(t, fsys.Exists(filepath.Join(path, "a.v")))
+	require.Equal(t, int64(100), fsys.CachedBytes())
+
+	for i := 0; i < 7; i++ {
+		fsys.Close(filepath.Join(path, "a.v"))
+	}
+	require.FileExists(t, filepath.Join(path, "a.v"))
+	fsys.Close(filepath.Join(path, "a.v"))
+	require.NoFileExists(t, filepath.Join(path, "a.v"))
+	require.Equal(t, int64(0), fsys.CachedBytes())
+
+	// file added to store after List
+	_, err = fsys.Open(filepath.Join(path, "b.v"))
+	require.ErrorIs(t, err, fs.ErrNotExist)
+	require.NoError(t, os.WriteFile(filepath.Join(storePath, "b.v"), make([]byte, 100), 0644))
+	fsys.listedAt = time.Time{}
+	_, err = fsys.Open(filepath.Join(path, "b.v"))
+	require.NoError(t, err)
+}
+
+func TestAggregatorV3_LockDir(t *testing.T) {
+	path, db, _ := testDbAndAggregatorV3(t, 16)
+	ctx := context.Background()
+	open := func(role AggRole) (*AggregatorV3, error) {
+		agg, err := NewAggregatorV3(ctx, path, filepath.Join(path, "e4tmp"), 16, db)
+		require.NoError(t, err)
+		if err = agg.LockDir(role); err != nil {
+			agg.Close()
+			return nil, err
+		}
+		require.NoError(t, agg.ReopenFiles())
+		t.Cleanup(agg.Close)
+		return agg, nil
+	}
+
+	writer, err := open(RoleWriter)
+	require.NoError(t, err)
+	_, err = open(RoleWriter)
+	require.ErrorIs(t, err, ErrDirLocked)
+
+	reader, err := open(RoleReader)
+	require.NoError(t, err)
+	_, err = open(RoleReader)
+	require.NoError(t, err)
+	require.ErrorIs(t, reader.BuildFiles(ctx, db), ErrReadOnly)
+	require.ErrorIs(t, reader.MergeLoop(ctx, 1), ErrReadOnly)
+	require.ErrorIs(t, reader.Prune(ctx, 1), ErrReadOnly)
+	require.NoError(t, writer.MergeLoop(ctx, 1))
+
+	writer.Close()
+	_, err = open(RoleWriter)
+	require.NoError(t, err)
+}
+
+func TestAggregatorV3_StaleContext(t *testing.T) {
+	_, db, agg := testDbAndAggregatorV3(t, 16)
+	ctx := context.Background()
+
+	tx, err := db.BeginRw(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback()
+	agg.SetTx(tx)
+	defer agg.StartWrites().FinishWrites()
+
+	addr := make([]byte, 20)
+	for txNum := uint64(0); txNum < 10; txNum++ {
+		agg.SetTxNum(txNum)
+		require.NoError(t, agg.AddAccountPrev(addr, []byte{byte(txNum)}))
+	}
+	require.NoError(t, agg.Flush(ctx, tx))
+
+	ac := agg.MakeContext()
+	ac.SetTx(tx)
+	v, ok, err := ac.ReadAccountDataNoStateWithRecent(addr, 5)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, []byte{5}, v)
+
+	require.NoError(t, agg.Unwind(ctx, 3, etl.IdentityLoadFunc))
+	require.True(t, ac.Stale())
+	_, _, err = ac.ReadAccountDataNoStateWithRecent(addr, 5)
+	var staleErr *StaleContextError
+	require.ErrorAs(t, err, &staleErr)
+	require.ErrorIs(t, err, ErrContextStale)
+
+	ac = agg.MakeContext()
+	ac.SetTx(tx)
+	require.False(t, ac.Stale())
+	_, ok, err = ac.ReadAccountDataNoStateWithRecent(addr, 5)
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func TestAggregatorV3_ContextAcrossBuildAndMerge(t *testing.T) {
+	aggStep := uint64(16)
+	_, db, agg := testDbAndAggregatorV3(t, aggStep)
+	ctx := context.Background()
+
+	addr := make([]byte, 20)
+	write := func(from, to uint64) {
+		tx, err := db.BeginRw(ctx)
+		require.NoError(t, err)
+		defer tx.Rollback()
+		agg.SetTx(tx)
+		defer agg.StartWrites().FinishWrites()
+		for txNum := from; txNum < to; txNum++ {
+			agg.SetTxNum(txNum)
+			require.NoError(t, agg.AddAccountPrev(addr, []byte{byte(txNum)}))
+		}
+		require.NoError(t, agg.Flush(ctx, tx))
+		require.NoError(t, tx.Commit())
+	}
+	write(0, aggStep*4)
+	require.NoError(t, agg.BuildFiles(ctx, db))
+
+	roTx, err := db.BeginRo(ctx)
+	require.NoError(t, err)
+	defer roTx.Rollback()
+	ac := agg.MakeContext()
+	defer ac.Close()
+	ac.SetTx(roTx)
+	read := func(txNum uint64) {
+		v, ok, err := ac.ReadAccountDataNoStateWithRecent(addr, txNum)
+		require.NoError(t, err, txNum)
+		require.True(t, ok, txNum)
+		require.Equal(t, []byte{byte(txNum)}, v, txNum)
+	}
+	read(5)  // files
+	read(40) // db
+
+	write(aggStep*4, aggStep*8)
+	require.NoError(t, agg.BuildFiles(ctx, db))
+	require.NoError(t, agg.MergeLoop(ctx, 1))
+	require.False(t, ac.Stale())
+	read(5)
+	read(40)
+
+	// prune deletes DB history, which context reads from DB
+	tx, err := db.BeginRw(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback()
+	agg.SetTx(tx)
+	require.NoError(t, agg.Prune(ctx, math.MaxUint64))
+	require.True(t, ac.Stale())
+	_, _, err = ac.ReadAccountDataNoStateWithRecent(addr, 40)
+	require.ErrorIs(t, err, ErrContextStale)
+
+	ac = agg.MakeContext()
+	defer ac.Close()
+	ac.SetTx(tx)
+	require.False(t, ac.Stale())
+	read(40)
+}
+
+func TestAggregatorV3_TypedErrors(t *testing.T) {
+	path, db, agg := testDbAndAggregatorV3(t, 16)
+	ctx := context.Background()
+
+	tx, err := db.BeginRw(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback()
+	agg.SetTx(tx)
+
+	agg.maxTxNum.Store(32) // as if files of 2 steps are built
+	err = agg.Unwind(ctx, 20, etl.IdentityLoadFunc)
+	require.ErrorIs(t, err, ErrBehindPrune)
+	require.NoError(t, agg.Unwind(ctx, 32, etl.IdentityLoadFunc))
+
+	require.NoError(t, os.WriteFile(filepath.Join(path, "logaddrs.0-1.ef"), []byte("not a compressed file"), 0644))
+	err = agg.ReopenFiles()
+	require.ErrorIs(t, err, ErrFileCorrupted)
+	require.Contains(t, err.Error(), "logaddrs.0-1.ef")
+}
+
+func TestAggregatorV3_Status(t *testing.T) {
+	_, db, agg := testDbAndAggregatorV3(t, 16)
+	ctx := context.Background()
+
+	tx, err := db.BeginRw(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback()
+	agg.SetTx(tx)
+	defer agg.StartWrites().FinishWrites()
+
+	addr := make([]byte, 20)
+	for txNum := uint64(25); txNum < 30; txNum++ {
+		agg.SetTxNum(txNum)
+		require.NoError(t, agg.AddAccountPrev(addr, []byte{byte(txNum)}))
+	}
+	require.NoError(t, agg.Flush(ctx, tx))
+
+	tx2block := func(txNum uint64) uint64 { return txNum / 10 }
+	s, err := agg.Status(tx, tx2block)
+	require.NoError(t, err)
+	require.Zero(t, s.MaxTxNum)
+	require.Equal(t, uint64(2), s.FirstHistoryIdxBlockInDB)
+	require.Len(t, s.Files, 7)
+	require.Empty(t, s.Files["accounts"])
+	require.NotZero(t, s.Sys)
+
+	js, err := json.Marshal(s)
+	require.NoError(t, err)
+	var s2 AggStatus
+	require.NoError(t, json.Unmarshal(js, &s2))
+	require.Equal(t, s.FirstHistoryIdxBlockInDB, s2.FirstHistoryIdxBlockInDB)
+	require.NoError(t, agg.LogStats(tx, tx2block))
+}
+
+func TestAggregatorV3_StepIO(t *testing.T) {
+	aggStep := uint64(16)
+	_, db, agg := testDbAndAggregatorV3(t, aggStep)
+	ctx := context.Background()
+
+	fillAggregatorV3(t, db, agg, aggStep*6)
+	require.NoError(t, agg.BuildFiles(ctx, db))
+
+	roTx, err := db.BeginRo(ctx)
+	require.NoError(t, err)
+	defer roTx.Rollback()
+	tx2block := func(txNum uint64) uint64 { return txNum }
+	s, err := agg.Status(roTx, tx2block)
+	require.NoError(t, err)
+	require.Equal(t, int(agg.EndTxNumMinimax()/aggStep), len(s.Steps))
+	for i, step := range s.Steps {
+		require.Equal(t, uint64(i), step.Step)
+		require.NotZero(t, step.DBReadBytes)
+		require.NotZero(t, step.FilesBytes)
+		require.Greater(t, step.TmpWrittenBytes, step.FilesBytes)
+		require.Equal(t, 1.0, step.MergeAmplification) // nothing merged yet
+	}
+	require.Equal(t, 1.0, s.MergeAmplification)
+
+	require.NoError(t, agg.MergeLoop(ctx, 1))
+	s, err = agg.Status(roTx, tx2block)
+	require.NoError(t, err)
+	require.Greater(t, s.MergeAmplification, 1.0)
+	require.Equal(t, 1.0, s.Steps[len(s.Steps)-1].MergeAmplification) // value at build time
+}
+
+func TestAggregatorV3_FreezeTiers(t *testing.T) {
+	aggStep := uint64(16)
+	_, db, agg := testDbAndAggregatorV3(t, aggStep)
+	ctx := context.Background()
+	require.Error(t, agg.SetFreezeTiers(8, 2))
+	require.Error(t, agg.SetFreezeTiers(3))
+	require.NoError(t, agg.SetFreezeTiers(2, 8))
+	require.Equal(t, []uint64{2, 8}, agg.FreezeTiers())
+	var frozen []string
+	agg.SetOnFrozenFiles(func(names []string) { frozen = append(frozen, names...) })
+
+	fillAggregatorV3(t, db, agg, aggStep*18)
+	addr := make([]byte, 20)
+	require.NoError(t, agg.BuildFiles(ctx, db))
+	require.NoError(t, agg.MergeLoop(ctx, 1))
+
+	var spans []uint64
+	agg.accounts.files.Ascend(func(item *filesItem) bool {
+		spans = append(spans, (item.endTxNum-item.startTxNum)/aggStep)
+		return true
+	})
+	require.Equal(t, []uint64{8, 8, 1}, spans)
+	require.Contains(t, frozen, "accounts.0-2.v")
+	require.Contains(t, frozen, "accounts.0-8.ef")
+	require.Contains(t, frozen, "logaddrs.8-16.ef")
+	require.NotContains(t, frozen, "accounts.0-1.v")
+	require.NotContains(t, frozen, "accounts.0-4.v")
+	require.NotContains(t, frozen, "accounts.0-8.vi")
+
+	binary.BigEndian.PutUint64(addr, 1)
+	v, ok, err := agg.MakeContext().ReadAccountDataNoState(addr, 2)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, []byte{6}, v)
+}
+
+func TestAggregatorV3_WriteBuffer(t *testing.T) {
+	_, db, agg := testDbAndAggregatorV3(t, 16)
+	ctx := context.Background()
+
+	tx, err := db.BeginRw(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback()
+	agg.SetTx(tx)
+	defer agg.StartWrites().FinishWrites()
+	w := agg.NewWriter()
+	defer w.Close()
+
+	addr, val := make([]byte, 20), make([]byte, 100)
+	agg.SetTxNum(1)
+	require.NoError(t, agg.AddAccountPrev(addr, val))
+	require.NoError(t, agg.AddLogAddr(addr))
+	w.SetTxNum(2)
+	require.NoError(t, w.AddAccountPrev(addr, val))
+	bytes := agg.WriteBufferBytes()
+	require.Equal(t, 2*(8+100+2*8+20+20+8), int(bytes["accounts"])) // vals + index of main wal and of writer
+	require.Equal(t, 2*8+20+20, int(bytes["logaddrs"]))
+	require.Zero(t, bytes["storage"])
+
+	agg.SetWriteBufferLimit(300, false)
+	agg.SetTxNum(3)
+	require.NoError(t, agg.AddAccountPrev(addr, val))
+	require.True(t, agg.WriteBufferFull())
+	require.NoError(t, w.AddLogTopic(addr))
+	require.True(t, w.WriteBufferFull())
+	require.NotZero(t, agg.WriteBufferBytes()["logtopics"])
+
+	agg.SetWriteBufferLimit(300, true)
+	require.NoError(t, agg.AddAccountPrev(addr, val)) // flushed
+	for _, n := range agg.WriteBufferBytes() {
+		require.Zero(t, n)
+	}
+	require.NoError(t, agg.AddAccountPrev(addr, val))
+	require.NotZero(t, agg.WriteBufferBytes()["accounts"])
+	require.NoError(t, agg.Flush(ctx, tx))
+	require.Zero(t, agg.WriteBufferBytes()["accounts"])
+}
+
+func TestAggregatorV3_WALProgress(t *testing.T) {
+	_, db, agg := testDbAndAggregatorV3(t, 16)
+	ctx := context.Background()
+
+	tx, err := db.BeginRw(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback()
+	agg.SetTx(tx)
+	loaded := map[string]uint64{}
+	agg.SetWALProgress(func(table string, p etl.Progress) { loaded[table] = p.Loaded })
+	defer agg.StartWrites().FinishWrites()
+
+	addr, val := make([]byte, 20), make([]byte, 100)
+	for txNum := uint64(1); txNum <= 3; txNum++ {
+		agg.SetTxNum(txNum)
+		require.NoError(t, agg.AddAccountPrev(addr, val))
+	}
+	require.NoError(t, agg.Flush(ctx, tx))
+	require.Equal(t, uint64(3), loaded[kv.AccountHistoryKeys])
+	require.Equal(t, uint64(3), loaded[kv.AccountIdx])
+	require.Equal(t, uint64(3), loaded[kv.AccountHistoryVals])
+	require.Zero(t, loaded[kv.StorageHistoryKeys])
+}
+
+func TestAggregatorV3_FileMeta(t *testing.T) {
+	aggStep := uint64(16)
+	path, db, _ := testDbAndAggregatorV3(t, aggStep)
+	ctx := context.Background()
+	mainnet := ChainIdentity{ChainID: 1, Genesis: common.HexToHash("0xd4e56740f876aef8c010b86a40d5f56745a118d0906a34e69aec8c0db1cb8fa3")}
+	goerli := ChainIdentity{ChainID: 5, Genesis: common.HexToHash("0xbf7e331f7f7c1dd2e05159666b3bf8bc7a8a3a9eb1d518969eab529dd9b88c1a")}
+	devnet := ChainIdentity{ChainID: 1, Genesis: common.HexToHash("0x01")}
+	open := func(chain ChainIdentity) (*AggregatorV3, error) {
+		agg, err := NewAggregatorV3ForChain(ctx, path, filepath.Join(path, "e4tmp"), aggStep, db, chain)
+		require.NoError(t, err)
+		require.NoError(t, agg.EnableFileMeta())
+		if err = agg.ReopenFiles(); err != nil {
+			agg.Close()
+			return nil, err
+		}
+		return agg, nil
+	}
+
+	agg, err := open(mainnet)
+	require.NoError(t, err)
+	require.Equal(t, mainnet, agg.Chain())
+	fillAggregatorV3(t, db, agg, aggStep*4)
+	require.NoError(t, agg.BuildFiles(ctx, db))
+	agg.Close()
+
+	for _, name := range []string{"accounts.0-1.v", "accounts.0-1.ef", "logaddrs.0-1.ef"} {
+		d, err := compress.NewDecompressor(filepath.Join(path, name))
+		require.NoError(t, err)
+		m, err := DecodeFileMeta(d.Metadata())
+		require.NoError(t, err)
+		require.NoError(t, d.VerifyChecksum())
+		d.Close()
+		require.Equal(t, name, fmt.Sprintf("%s.%d-%d.%s", m.Entity, m.StartTxNum/aggStep, m.EndTxNum/aggStep, m.Ext))
+		chain, err := parseChainIdentity(m.Chain)
+		require.NoError(t, err)
+		require.Equal(t, mainnet, chain)
+		require.EqualValues(t, FileMetaVersion, m.Version)
+	}
+
+	agg, err = open(mainnet)
+	require.NoError(t, err)
+	agg.Close()
+	agg, err = open(ChainIdentity{}) // not bound
+	require.NoError(t, err)
+	agg.Close()
+	_, err = open(goerli)
+	require.ErrorIs(t, err, ErrFileMetaMismatch)
+	_, err = open(devnet) // same chain id, other genesis
+	require.ErrorIs(t, err, ErrFileMetaMismatch)
+}
+
+type testStateChangesListener struct {
+	versions []uint64
+	keys     [][][]byte
+}
+
+func (l *testStateChangesListener) OnStateChanges(stateVersionID uint64, keys [][]byte) {
+	l.versions = append(l.versions, stateVersionID)
+	l.keys = append(l.keys, keys)
+}
+
+func TestAggregatorV3_StateChangesListener(t *testing.T) {
+	aggStep := uint64(16)
+	_, db, agg := testDbAndAggregatorV3(t, aggStep)
+	ctx := context.Background()
+	listener := &testStateChangesListener{}
+	agg.SetStateChangesListener(listener)
+
+	addr1, addr2, loc := make([]byte, 20), make([]byte, 20), make([]byte, 32)
+	addr1[0], addr2[0], loc[0] = 1, 2, 3
+	update := func(commit bool, f func(tx kv.RwTx)) {
+		tx, err := db.BeginRw(ctx)
+		require.NoError(t, err)
+		defer tx.Rollback()
+		agg.SetTx(tx)
+		agg.StartWrites()
+		defer agg.FinishWrites()
+		f(tx)
+		if !commit {
+			tx.Rollback()
+			agg.DiscardStateChanges()
+			return
+		}
+		require.NoError(t, tx.Commit())
+		agg.NotifyStateChanges()
+	}
+	firstBlock := func(tx kv.RwTx) {
+		agg.SetTxNum(1)
+		require.NoError(t, agg.AddAccountPrev(addr1, nil))
+		require.NoError(t, agg.AddStoragePrev(addr2, loc, nil))
+		require.NoError(t, agg.AddLogAddr(addr2)) // not a state change
+		w := agg.NewWriter()
+		defer w.Close()
+		w.SetTxNum(2)
+		require.NoError(t, w.AddAccountPrev(addr2, nil))
+		require.NoError(t, agg.Flush(ctx, tx))
+		require.NoError(t, agg.Flush(ctx, tx)) // nothing changed - no notification
+		require.Empty(t, listener.versions)    // not committed yet
+	}
+
+	update(false, firstBlock) // rolled back - never delivered
+	require.Empty(t, listener.versions)
+	update(true, firstBlock)
+	require.Equal(t, []uint64{1}, listener.versions) // version of rolled back tx is reused
+
+	update(true, func(tx kv.RwTx) {
+		agg.SetTxNum(5)
+		require.NoError(t, agg.AddAccountPrev(addr1, []byte{1}))
+		require.NoError(t, agg.Flush(ctx, tx))
+		require.NoError(t, agg.Unwind(ctx, 3, etl.IdentityLoadFunc))
+	})
+
+	require.Equal(t, []uint64{1, 2, 3}, listener.versions)
+	require.Equal(t, [][]byte{addr1, addr2, append(common.Copy(addr2), loc...)}, listener.keys[0])
+	require.Equal(t, [][]byte{addr1}, listener.keys[1])
+	require.Equal(t, [][]byte{addr1}, listener.keys[2]) // unwound
+	require.NoError(t, db.View(ctx, func(tx kv.Tx) error {
+		v, err := tx.GetOne(kv.Sequence, kv.PlainStateVersion)
+		require.NoError(t, err)
+		require.Equal(t, uint64(3), binary.BigEndian.Uint64(v))
+		return nil
+	}))
+}
+
+type testStateChangesBatch struct {
+	version, aggTxNum uint64
+	entities          []string
+}
+
+type testStateChangesBatchListener struct {
+	testStateChangesListener
+	batches []testStateChangesBatch
+}
+
+func (l *testStateChangesBatchListener) OnStateChangeBatch(stateVersionID, aggTxNum uint64, flushedEntities []string) {
+	l.batches = append(l.batches, testStateChangesBatch{stateVersionID, aggTxNum, flushedEntities})
+}
+
+func TestAggregatorV3_StateChangesBatchListener(t *testing.T) {
+	aggStep := uint64(16)
+	_, db, agg := testDbAndAggregatorV3(t, aggStep)
+	ctx := context.Background()
+	listener := &testStateChangesBatchListener{}
+	agg.SetStateChangesListener(listener)
+
+	tx, err := db.BeginRw(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback()
+	agg.SetTx(tx)
+	agg.StartWrites()
+	defer agg.FinishWrites()
+
+	addr, loc := make([]byte, 20), make([]byte, 32)
+	agg.SetTxNum(1)
+	require.NoError(t, agg.AddAccountPrev(addr, nil))
+	w := agg.NewWriter()
+	defer w.Close()
+	w.SetTxNum(1)
+	require.NoError(t, w.AddStoragePrev(addr, loc, nil))
+	require.NoError(t, agg.Flush(ctx, tx))
+	agg.SetTxNum(4)
+	require.NoError(t, agg.AddLogAddr(addr)) // not a state change - version stays, txNum advances
+	require.NoError(t, agg.Flush(ctx, tx))
+	require.NoError(t, agg.Flush(ctx, tx)) // nothing flushed
+	require.NoError(t, agg.Unwind(ctx, 3, etl.IdentityLoadFunc))
+	require.Empty(t, listener.batches)
+	agg.NotifyStateChanges()
+
+	require.Equal(t, []uint64{1}, listener.versions)
+	require.Equal(t, []testStateChangesBatch{
+		{1, 2, []string{"accounts", "storage"}},
+		{1, 5, []string{"logaddrs"}},
+		{1, 5, nil},
+		{1, 3, []string{"logaddrs"}}, // unwound
+	}, listener.batches)
+}
+
+type testCommitmentListener struct {
+	txNums   []uint64
+	updates  []map[string]commitment.BranchData
+	unwindTo uint64
+	branches map[string][]byte
+}
+
+func (l *testCommitmentListener) OnCommitmentUpdates(txNum uint64, updates map[string]commitment.BranchData) {
+	l.txNums = append(l.txNums, txNum)
+	l.updates = append(l.updates, updates)
+}
+
+func (l *testCommitmentListener) OnCommitmentUnwind(txUnwindTo uint64, branches map[string][]byte) error {
+	l.unwindTo, l.branches = txUnwindTo, branches
+	return nil
+}
+
+func TestAggregatorV3_CommitmentHistory(t *testing.T) {
+	aggStep := uint64(16)
+	_, db, agg := testDbAndAggregatorV3(t, aggStep)
+	ctx := context.Background()
+	listener := &testCommitmentListener{}
+	agg.SetCommitmentUpdatesListener(listener)
+
+	tx, err := db.BeginRw(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback()
+	agg.SetTx(tx)
+	agg.StartWrites()
+	defer agg.FinishWrites()
+
+	// branches storage of caller
+	branches := map[string][]byte{}
+	prev := func(prefix []byte) ([]byte, error) { return branches[string(prefix)], nil }
+	compute := func(txNum uint64, updates map[string]commitment.BranchData) {
+		agg.SetTxNum(txNum)
+		require.NoError(t, agg.AddCommitmentUpdates(updates, prev))
+		for prefix, branch := range updates {
+			branches[prefix] = branch
+		}
+	}
+	compute(1, map[string]commitment.BranchData{"\x01": {1}, "\x02": {2}})
+	compute(4, map[string]commitment.BranchData{"\x01": {3}})
+	compute(6, map[string]commitment.BranchData{"\x01": {4}, "\x03": {5}})
+	require.NoError(t, agg.Flush(ctx, tx))
+
+	require.Equal(t, []uint64{1, 4, 6}, listener.txNums)
+	require.Equal(t, commitment.BranchData{3}, listener.updates[1]["\x01"])
+
+	ac := agg.MakeContext()
+	ac.SetTx(tx)
+	v, ok, err := ac.ReadCommitmentBranchNoStateWithRecent([]byte{1}, 2)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, []byte{1}, v)
+	v, ok, err = ac.ReadCommitmentBranchNoStateWithRecent([]byte{3}, 5)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Empty(t, v) // didn't exist
+	_, ok, err = ac.ReadCommitmentBranchNoStateWithRecent([]byte{2}, 5)
+	require.NoError(t, err)
+	require.False(t, ok) // not changed since
+
+	it, err := ac.CommitmentHistoyIdxIterator([]byte{1}, 0, -1, order.Asc, -1, tx)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{1, 4, 6}, it.ToArray())
+
+	require.NoError(t, agg.Unwind(ctx, 3, etl.IdentityLoadFunc))
+	require.Equal(t, uint64(3), listener.unwindTo)
+	require.Equal(t, []byte{1}, listener.branches["\x01"])
+	require.Empty(t, listener.branches["\x03"])
+	require.Len(t, listener.branches, 2)
+}
+
+func TestAggregatorV3_MinFreeSpace(t *testing.T) {
+	aggStep := uint64(16)
+	_, db, agg := testDbAndAggregatorV3(t, aggStep)
+	ctx := context.Background()
+
+	fillAggregatorV3(t, db, agg, aggStep*6)
+
+	free := uint64(1000)
+	agg.freeSpace = func(string) (uint64, error) { return free, nil }
+	agg.SetMinFreeSpace(1001)
+	err := agg.BuildFiles(ctx, db)
+	var lowSpace *LowFreeSpaceError
+	require.ErrorAs(t, err, &lowSpace)
+	require.ErrorIs(t, err, ErrLowFreeSpace)
+	require.Equal(t, uint64(0), agg.EndTxNumMinimax())
+	require.Empty(t, agg.Files())
+
+	// first step is built, then estimate of next steps is size of first step
+	agg.SetMinFreeSpace(1)
+	free = 0
+	agg.freeSpace = func(string) (uint64, error) {
+		if agg.EndTxNumMinimax() == 0 {
+			return 1000, nil
+		}
+		return free, nil
+	}
+	require.ErrorIs(t, agg.BuildFiles(ctx, db), ErrLowFreeSpace)
+	require.Equal(t, aggStep, agg.EndTxNumMinimax())
+	estimated := agg.estimateBuildSize()
+	require.Greater(t, estimated, uint64(0))
+
+	free = estimated + 1
+	require.NoError(t, agg.BuildFiles(ctx, db))
+	require.Greater(t, agg.EndTxNumMinimax(), aggStep)
+	plans := agg.PlanMerges(aggStep * StepsInBiggestFile)
+	require.NotEmpty(t, plans)
+
+	free = estimateMergeSize(plans)
+	require.ErrorIs(t, agg.MergeLoop(ctx, 1), ErrLowFreeSpace)
+	require.Equal(t, len(plans), len(agg.PlanMerges(aggStep*StepsInBiggestFile))) // files are untouched
+	agg.SetMinFreeSpace(0)
+	require.NoError(t, agg.MergeLoop(ctx, 1))
+	require.Empty(t, agg.PlanMerges(aggStep*StepsInBiggestFile))
+}
+
+func TestAggregatorV3_DeferIndices(t *testing.T) {
+	aggStep := uint64(16)
+	_, db, agg := testDbAndAggregatorV3(t, aggStep)
+	ctx := context.Background()
+	agg.SetDeferIndices(true)
+
+	fillAggregatorV3(t, db, agg, aggStep*6)
+	addr := make([]byte, 20)
+	require.NoError(t, agg.BuildFiles(ctx, db))
+
+	countFiles := func(ext string) int {
+		matches, err := filepath.Glob(filepath.Join(agg.dir, "*."+ext))
+		require.NoError(t, err)
+		return len(matches)
+	}
+	require.Greater(t, agg.EndTxNumMinimax(), uint64(0))
+	require.Greater(t, countFiles("ef"), 0)
+	require.Greater(t, countFiles("v"), 0)
+	require.Zero(t, countFiles("efi"))
+	require.Zero(t, countFiles("vi"))
+	require.Zero(t, agg.endIndexedTxNum())
+	require.Empty(t, agg.PlanMerges(aggStep*StepsInBiggestFile))
+
+	// not indexed files are not visible and their data stays in DB
+	binary.BigEndian.PutUint64(addr, 1)
+	_, ok, err := agg.MakeContext().ReadAccountDataNoState(addr, 2)
+	require.NoError(t, err)
+	require.False(t, ok)
+	firstInDB := func() uint64 {
+		tx, err := db.BeginRw(ctx)
+		require.NoError(t, err)
+		defer tx.Rollback()
+		agg.SetTx(tx)
+		require.NoError(t, agg.Prune(ctx, math.MaxUint64))
+		require.NoError(t, tx.Commit())
+		roTx, err := db.BeginRo(ctx)
+		require.NoError(t, err)
+		defer roTx.Rollback()
+		k, err := kv.FirstKey(roTx, kv.AccountHistoryKeys)
+		require.NoError(t, err)
+		return binary.BigEndian.Uint64(k)
+	}
+	require.Zero(t, firstInDB())
+
+	require.NoError(t, agg.BuildDeferredIndices(ctx))
+	require.Equal(t, countFiles("ef"), countFiles("efi"))
+	require.Equal(t, countFiles("v"), countFiles("vi"))
+	require.Equal(t, agg.EndTxNumMinimax(), agg.endIndexedTxNum())
+	v, ok, err := agg.MakeContext().ReadAccountDataNoState(addr, 2)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, []byte{6}, v)
+	require.Equal(t, agg.endIndexedTxNum(), firstInDB())
+
+	require.NotEmpty(t, agg.PlanMerges(aggStep*StepsInBiggestFile))
+	require.NoError(t, agg.MergeLoop(ctx, 1))
+	require.Empty(t, agg.PlanMerges(aggStep*StepsInBiggestFile))
+}
@@ -0,0 +1,203 @@
+/*
+   Copyright 2022 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package state
+
+import (
+	"context"
+	"encoding/binary"
+	"sync"
+
+	"github.com/ledgerwatch/erigon-lib/kv"
+)
+
+// AggregatorWriter - handle for 1 writer (for example: 1 worker of parallel execution). Has own txNum and own buffers,
+// so writers don't serialize on `AggregatorV3.SetTxNum`. Buffers of all open writers are flushed by `AggregatorV3.Flush`.
+// Each handle must be used by 1 goroutine, but it's safe to call `AggregatorV3.Flush` concurrently with writes.
+//
+// Pattern:
+//
+//	w := agg.NewWriter()
+//	defer w.Close()
+//	w.SetTxNum(txNum)
+//	w.AddAccountPrev(addr, prev)
+type AggregatorWriter struct {
+	a          *AggregatorV3
+	txNumBytes [8]byte
+	lastTxNum  uint64 // for strict mode
+	hasTxNum   bool
+
+	lock                                      sync.RWMutex // read-lock for writes, write-lock for rotate
+	accounts, storage, code                   historyWriter
+	logAddrs, logTopics, tracesFrom, tracesTo *invertedIndexWAL
+}
+
+type historyWriter struct {
+	vals *historyWAL
+	idx  *invertedIndexWAL
+}
+
+func newHistoryWriter(h *History, tmpdir string) historyWriter {
+	return historyWriter{vals: h.newWriter(tmpdir, true, false), idx: h.InvertedIndex.newWriter(tmpdir, true, false)}
+}
+
+func (w historyWriter) addPrevValue(txNumBytes, key1, key2, original []byte) error {
+	historyKey, err := w.vals.addPrevValue(key1, key2, original)
+	if err != nil || historyKey == nil {
+		return err
+	}
+	return w.idx.add(txNumBytes, historyKey, historyKey[:len(key1)+len(key2)])
+}
+
+func (w historyWriter) rotate(h *History) (historyFlusher, historyWriter) {
+	return historyFlusher{w.vals, w.idx}, newHistoryWriter(h, w.vals.tmpdir)
+}
+
+func (w historyWriter) close() {
+	w.vals.close()
+	w.idx.close()
+}
+
+// NewWriter - must be called after `StartWrites`. Writer must be closed after last `Flush`: not flushed writes are lost.
+func (a *AggregatorV3) NewWriter() *AggregatorWriter {
+	w := &AggregatorWriter{a: a}
+	w.accounts = newHistoryWriter(a.accounts, a.tmpdir)
+	w.storage = newHistoryWriter(a.storage, a.tmpdir)
+	w.code = newHistoryWriter(a.code, a.tmpdir)
+	w.logAddrs = a.logAddrs.newWriter(a.tmpdir, true, false)
+	w.logTopics = a.logTopics.newWriter(a.tmpdir, true, false)
+	w.tracesFrom = a.tracesFrom.newWriter(a.tmpdir, true, false)
+	w.tracesTo = a.tracesTo.newWriter(a.tmpdir, true, false)
+
+	a.writersLock.Lock()
+	defer a.writersLock.Unlock()
+	if a.writers == nil {
+		a.writers = map[*AggregatorWriter]struct{}{}
+	}
+	a.writers[w] = struct{}{}
+	return w
+}
+
+// SetTxNum - txNum of next writes of this writer. Doesn't change progress of aggregator:
+// coordinator of writers still calls `AggregatorV3.SetTxNum` when txNum is done by all writers.
+func (w *AggregatorWriter) SetTxNum(txNum uint64) {
+	w.checkTxNum(txNum)
+	binary.BigEndian.PutUint64(w.txNumBytes[:], txNum)
+}
+
+func (w *AggregatorWriter) AddAccountPrev(addr []byte, prev []byte) error {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+	if err := w.accounts.addPrevValue(w.txNumBytes[:], addr, nil, prev); err != nil {
+		return err
+	}
+	w.a.changes.add(addr, nil)
+	return nil
+}
+
+func (w *AggregatorWriter) AddStoragePrev(addr []byte, loc []byte, prev []byte) error {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+	if err := w.storage.addPrevValue(w.txNumBytes[:], addr, loc, prev); err != nil {
+		return err
+	}
+	w.a.changes.add(addr, loc)
+	return nil
+}
+
+// AddCodePrev - addr+inc => code
+func (w *AggregatorWriter) AddCodePrev(addr []byte, prev []byte) error {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+	return w.code.addPrevValue(w.txNumBytes[:], addr, nil, prev)
+}
+
+func (w *AggregatorWriter) AddTraceFrom(addr []byte) error {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+	return w.tracesFrom.add(w.txNumBytes[:], addr, addr)
+}
+
+func (w *AggregatorWriter) AddTraceTo(addr []byte) error {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+	return w.tracesTo.add(w.txNumBytes[:], addr, addr)
+}
+
+func (w *AggregatorWriter) AddLogAddr(addr []byte) error {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+	return w.logAddrs.add(w.txNumBytes[:], addr, addr)
+}
+
+func (w *AggregatorWriter) AddLogTopic(topic []byte) error {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+	return w.logTopics.add(w.txNumBytes[:], topic, topic)
+}
+
+// rotate - returns buffers with writes done so far, writer continues with empty buffers
+func (w *AggregatorWriter) rotate() []flusher {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	var accounts, storage, code historyFlusher
+	accounts, w.accounts = w.accounts.rotate(w.a.accounts)
+	storage, w.storage = w.storage.rotate(w.a.storage)
+	code, w.code = w.code.rotate(w.a.code)
+	flushers := []flusher{accounts, storage, code, w.logAddrs, w.logTopics, w.tracesFrom, w.tracesTo}
+	w.logAddrs = w.a.logAddrs.newWriter(w.logAddrs.tmpdir, true, false)
+	w.logTopics = w.a.logTopics.newWriter(w.logTopics.tmpdir, true, false)
+	w.tracesFrom = w.a.tracesFrom.newWriter(w.tracesFrom.tmpdir, true, false)
+	w.tracesTo = w.a.tracesTo.newWriter(w.tracesTo.tmpdir, true, false)
+	return flushers
+}
+
+// Close - unregisters writer and drops not flushed writes
+func (w *AggregatorWriter) Close() {
+	w.a.writersLock.Lock()
+	delete(w.a.writers, w)
+	w.a.writersLock.Unlock()
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.accounts.close()
+	w.storage.close()
+	w.code.close()
+	w.logAddrs.close()
+	w.logTopics.close()
+	w.tracesFrom.close()
+	w.tracesTo.close()
+}
+
+// flushWriters - part of `AggregatorV3.Flush`
+func (a *AggregatorV3) flushWriters(ctx context.Context, tx kv.RwTx, flushed *flushedEntities) error {
+	a.writersLock.Lock()
+	writers := make([]*AggregatorWriter, 0, len(a.writers))
+	for w := range a.writers {
+		writers = append(writers, w)
+	}
+	a.writersLock.Unlock()
+
+	for _, w := range writers {
+		for _, f := range w.rotate() {
+			flushed.add(f)
+			if err := f.Flush(ctx, tx); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+/*
+   Copyright 2023 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package state
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/RoaringBitmap/roaring/roaring64"
+	"github.com/ledgerwatch/log/v3"
+
+	"github.com/ledgerwatch/erigon-lib/kv/bitmapdb"
+	"github.com/ledgerwatch/erigon-lib/recsplit/eliasfano32"
+)
+
+// BackfillSource - yields keys of entity for all txNums of [txFrom, txTo), in any order. Keys are copied.
+// Sources: replay of blocks (done by caller - this library doesn't execute blocks), or files of other entity - see
+// InvertedIndexSource.
+type BackfillSource func(ctx context.Context, txFrom, txTo uint64, add func(txNum uint64, key []byte) error) error
+
+// BackfillProgress - passed to progress callback of AggregatorV3.Backfill after each step
+type BackfillProgress struct {
+	Entity  string
+	Step    uint64 // step just built or skipped
+	Done    uint64 // steps built or skipped, including Step
+	Total   uint64
+	Skipped bool // Step already has files (built by previous run of Backfill or by aggregator)
+}
+
+// Backfill - catch-up indexer: builds files of newly enabled inverted index `entity` ("logaddrs", "logtopics",
+// "tracesfrom" or "tracesto") for steps before `toTxNum` (rounded down to step) from `src`, without resync.
+// Each step is published atomically, so Backfill is resumable: steps which already have files are skipped - after
+// interruption just call it again. Data of steps after `toTxNum` must be written to DB as usual (Add).
+// Entity without files holds back EndTxNumMinimax of aggregator - it moves forward as steps are backfilled.
+func (a *AggregatorV3) Backfill(ctx context.Context, entity string, toTxNum uint64, src BackfillSource, progress func(BackfillProgress)) error {
+	if err := a.checkWritable("Backfill"); err != nil {
+		return err
+	}
+	var ii *InvertedIndex
+	for _, candidate := range []*InvertedIndex{a.logAddrs, a.logTopics, a.tracesFrom, a.tracesTo} {
+		if candidate.filenameBase == entity {
+			ii = candidate
+		}
+	}
+	if ii == nil {
+		return fmt.Errorf("Backfill: %q is not an inverted index of aggregator", entity)
+	}
+	if !a.working.CompareAndSwap(false, true) {
+		return fmt.Errorf("Backfill %s: files build is in progress", entity)
+	}
+	defer a.working.Store(false)
+	if !a.workingMerge.CompareAndSwap(false, true) {
+		return fmt.Errorf("Backfill %s: merge is in progress", entity)
+	}
+	defer a.workingMerge.Store(false)
+
+	return ii.backfill(ctx, toTxNum, src, &a.filesLock, func(p BackfillProgress) {
+		if !p.Skipped {
+			a.recalcMaxTxNum()
+			a.checkFiles()
+		}
+		if progress != nil {
+			progress(p)
+		}
+	})
+}
+
+// backfill - built steps are integrated under filesLock - same as integrateFiles
+func (ii *InvertedIndex) backfill(ctx context.Context, toTxNum uint64, src BackfillSource, filesLock *sync.RWMutex, progress func(BackfillProgress)) error {
+	toStep := toTxNum / ii.aggregationStep
+	for step := uint64(0); step < toStep; step++ {
+		p := BackfillProgress{Entity: ii.filenameBase, Step: step, Done: step + 1, Total: toStep}
+		txFrom, txTo := step*ii.aggregationStep, (step+1)*ii.aggregationStep
+		filesLock.RLock()
+		p.Skipped = ii.hasFilesOf(txFrom, txTo)
+		filesLock.RUnlock()
+		if !p.Skipped {
+			if err := ii.backfillStep(ctx, step, src, filesLock); err != nil {
+				return fmt.Errorf("backfill %s step %d: %w", ii.filenameBase, step, err)
+			}
+			log.Info("[snapshots] backfill", "name", ii.filenameBase, "step", fmt.Sprintf("%d-%d", step, step+1), "progress", fmt.Sprintf("%d/%d", p.Done, p.Total))
+		}
+		progress(p)
+	}
+	return nil
+}
+
+func (ii *InvertedIndex) backfillStep(ctx context.Context, step uint64, src BackfillSource, filesLock *sync.RWMutex) error {
+	txFrom, txTo := step*ii.aggregationStep, (step+1)*ii.aggregationStep
+	bitmaps := map[string]*roaring64.Bitmap{}
+	defer func() {
+		for _, bitmap := range bitmaps {
+			bitmapdb.ReturnToPool64(bitmap)
+		}
+	}()
+	if err := src(ctx, txFrom, txTo, func(txNum uint64, key []byte) error {
+		if txNum < txFrom || txNum >= txTo {
+			return fmt.Errorf("txNum %d out of range [%d, %d)", txNum, txFrom, txTo)
+		}
+		bitmap, ok := bitmaps[string(key)]
+		if !ok {
+			bitmap = bitmapdb.NewBitmap64()
+			bitmaps[string(key)] = bitmap
+		}
+		bitmap.Add(txNum)
+		return nil
+	}); err != nil {
+		return err
+	}
+	sf, err := ii.buildFiles(ctx, step, bitmaps)
+	if err != nil {
+		return err
+	}
+	filesLock.Lock()
+	ii.integrateFiles(sf, txFrom, txTo)
+	filesLock.Unlock()
+	return nil
+}
+
+// hasFilesOf - [txFrom, txTo) is covered by one file (step file or merged one)
+func (ii *InvertedIndex) hasFilesOf(txFrom, txTo uint64) (found bool) {
+	ii.files.Ascend(func(item *filesItem) bool {
+		found = item.startTxNum <= txFrom && item.endTxNum >= txTo
+		return !found
+	})
+	return found
+}
+
+// InvertedIndexSource - BackfillSource which reads keys from files of existing inverted index (for example: storage
+// index gives addresses of storage changes by `mapKey` which cuts location off). `mapKey` may be nil - keys as is.
+// Range of backfill must be covered by files of `ic`, `ic` must not be closed until Backfill is done.
+func InvertedIndexSource(ic *InvertedIndexContext, mapKey func(key []byte) []byte) BackfillSource {
+	return func(ctx context.Context, txFrom, txTo uint64, add func(txNum uint64, key []byte) error) (err error) {
+		ic.files.Ascend(func(item ctxItem) bool {
+			if item.endTxNum <= txFrom || item.startTxNum >= txTo {
+				return true
+			}
+			if err = ctx.Err(); err != nil {
+				return false
+			}
+			g := item.getter
+			g.Reset(0)
+			for g.HasNext() {
+				key, _ := g.NextUncompressed()
+				efBytes, _ := g.NextUncompressed()
+				if mapKey != nil {
+					key = mapKey(key)
+				}
+				ef, _ := eliasfano32.ReadEliasFano(efBytes)
+				for it := ef.Iterator(); it.HasNext(); {
+					txNum, _ := it.Next()
+					if txNum >= txTo {
+						break
+					}
+					if txNum < txFrom {
+						continue
+					}
+					if err = add(txNum, key); err != nil {
+						return false
+					}
+				}
+			}
+			return true
+		})
+		return err
+	}
+}
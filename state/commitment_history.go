@@ -0,0 +1,123 @@
+/*
+   Copyright 2022 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package state
+
+import (
+	math2 "math"
+	"sort"
+
+	"github.com/ledgerwatch/erigon-lib/commitment"
+	"github.com/ledgerwatch/erigon-lib/common"
+	"github.com/ledgerwatch/erigon-lib/kv"
+	"github.com/ledgerwatch/erigon-lib/kv/order"
+)
+
+// Commitment history - previous values of branch nodes (prefix => BranchData) changed by ComputeCommitment, per txNum.
+// Kept only in DB: no files are built for it, it's pruned together with other histories by Prune. So it covers
+// exactly unwindable range: Unwind restores branches by it (see CommitmentUnwindListener) and
+// HexPatriciaHashed.RollbackTo can be fed by it.
+
+// CommitmentUpdatesListener - notified about branch node updates recorded by AggregatorV3.AddCommitmentUpdates,
+// for external provers which follow commitment deltas. Called synchronously, `updates` must not be modified.
+type CommitmentUpdatesListener interface {
+	OnCommitmentUpdates(txNum uint64, updates map[string]commitment.BranchData)
+}
+
+// CommitmentUnwindListener - optional extension of CommitmentUpdatesListener: on Unwind receives branches as they
+// were at `txUnwindTo` (prefix => branch, empty branch - didn't exist) - to write them back to storage of branches.
+type CommitmentUnwindListener interface {
+	OnCommitmentUnwind(txUnwindTo uint64, branches map[string][]byte) error
+}
+
+// SetCommitmentUpdatesListener - nil - no notifications (default)
+func (a *AggregatorV3) SetCommitmentUpdatesListener(l CommitmentUpdatesListener) {
+	a.commitmentListener = l
+}
+
+// AddCommitmentUpdates - records branchNodeUpdates of ComputeCommitment at current txNum (see SetTxNum).
+// `prev` returns branch of prefix before update (nil - didn't exist).
+func (a *AggregatorV3) AddCommitmentUpdates(updates map[string]commitment.BranchData, prev func(prefix []byte) ([]byte, error)) error {
+	prefixes := make([]string, 0, len(updates))
+	for prefix := range updates {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	for _, prefix := range prefixes {
+		prevBranch, err := prev([]byte(prefix))
+		if err != nil {
+			return err
+		}
+		if err = a.commitment.AddPrevValue([]byte(prefix), nil, prevBranch); err != nil {
+			return err
+		}
+	}
+	if a.commitmentListener != nil && len(updates) > 0 {
+		a.commitmentListener.OnCommitmentUpdates(a.txNum.Load(), updates)
+	}
+	return a.checkWriteBuffer()
+}
+
+// AddCommitmentPrev - prefix => branch before update at current txNum, for callers which track previous values
+func (a *AggregatorV3) AddCommitmentPrev(prefix []byte, prev []byte) error {
+	if err := a.commitment.AddPrevValue(prefix, nil, prev); err != nil {
+		return err
+	}
+	return a.checkWriteBuffer()
+}
+
+// unwindCommitment - deletes commitment history of txNums >= txUnwindTo and passes oldest deleted values
+// (branches at txUnwindTo) to CommitmentUnwindListener
+func (a *AggregatorV3) unwindCommitment(txUnwindTo uint64) error {
+	unwindListener, _ := a.commitmentListener.(CommitmentUnwindListener)
+	var branches map[string][]byte
+	if unwindListener != nil {
+		branches = map[string][]byte{}
+	}
+	if err := a.commitment.pruneF(txUnwindTo, math2.MaxUint64, func(_ uint64, k, v []byte) error {
+		if branches == nil {
+			return nil
+		}
+		if _, ok := branches[string(k)]; !ok { // txNums are ascending - first value is oldest
+			branches[string(k)] = common.Copy(v)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	if unwindListener == nil {
+		return nil
+	}
+	return unwindListener.OnCommitmentUnwind(txUnwindTo, branches)
+}
+
+func (a *AggregatorV3) Commitment() *History { return a.commitment }
+
+// ReadCommitmentBranchNoStateWithRecent - branch of prefix as it was before txNum (false - not changed since txNum)
+func (ac *AggregatorV3Context) ReadCommitmentBranchNoStateWithRecent(prefix []byte, txNum uint64) ([]byte, bool, error) {
+	if err := ac.checkGeneration(); err != nil {
+		return nil, false, err
+	}
+	defer ac.enterRead()()
+	return ac.commitment.GetNoStateWithRecent(prefix, txNum, ac.tx)
+}
+
+func (ac *AggregatorV3Context) CommitmentHistoyIdxIterator(prefix []byte, startTxNum, endTxNum int, asc order.By, limit int, roTx kv.Tx) (*InvertedIterator, error) {
+	if err := ac.checkGeneration(); err != nil {
+		return nil, err
+	}
+	return ac.commitment.indexContext().IterateRange(prefix, startTxNum, endTxNum, asc, limit, roTx)
+}
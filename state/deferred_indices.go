@@ -0,0 +1,212 @@
+/*
+   Copyright 2023 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package state
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/ledgerwatch/erigon-lib/common/cmp"
+	"github.com/ledgerwatch/erigon-lib/recsplit"
+	"github.com/ledgerwatch/log/v3"
+)
+
+// SetDeferIndices - build of step files produces only data files (.ef/.v), their .efi/.vi are built later by
+// BuildDeferredIndices (BuildFilesInBackground runs it before merge). Shortens the critical path of staying close to
+// chain tip on slow disks. Files without index are not visible to contexts and are not merged, and their data is not
+// pruned from DB - until indexed.
+func (a *AggregatorV3) SetDeferIndices(v bool) {
+	a.deferIndices = v
+	if a.accounts == nil { // files are not opened yet
+		return
+	}
+	a.applyDeferIndices()
+}
+
+func (a *AggregatorV3) applyDeferIndices() {
+	for _, ii := range []*InvertedIndex{a.accounts.InvertedIndex, a.storage.InvertedIndex, a.code.InvertedIndex, a.logAddrs, a.logTopics, a.tracesFrom, a.tracesTo} {
+		ii.deferIndices = a.deferIndices
+	}
+}
+
+// endIndexedTxNum - end of files which are indexed (visible to contexts) in all entities. DB data above it is not
+// pruned and files above it are not merged.
+func (a *AggregatorV3) endIndexedTxNum() uint64 {
+	min := a.maxTxNum.Load()
+	for _, h := range []*History{a.accounts, a.storage, a.code} {
+		min = cmp.Min(min, h.endIndexedTxNumMinimax())
+	}
+	for _, ii := range []*InvertedIndex{a.logAddrs, a.logTopics, a.tracesFrom, a.tracesTo} {
+		min = cmp.Min(min, ii.endIndexedTxNumMinimax())
+	}
+	return min
+}
+
+// BuildDeferredIndices - builds indices of files produced in SetDeferIndices mode. Priorities: state histories first
+// (accounts, storage, code - they serve reads at chain tip), then logs and traces; oldest files first - they are
+// the first to be merged and pruned from DB.
+func (a *AggregatorV3) BuildDeferredIndices(ctx context.Context) error {
+	if err := a.checkWritable("BuildDeferredIndices"); err != nil {
+		return err
+	}
+	var built int
+	defer func() { a.afterDeferredIndices(built) }()
+	for _, h := range []*History{a.accounts, a.storage, a.code} {
+		n, err := h.buildDeferredIndices(ctx, &a.filesLock)
+		built += n
+		if err != nil {
+			return fmt.Errorf("BuildDeferredIndices: %w", err)
+		}
+	}
+	for _, ii := range []*InvertedIndex{a.logAddrs, a.logTopics, a.tracesFrom, a.tracesTo} {
+		n, err := ii.buildDeferredIndices(ctx, &a.filesLock)
+		built += n
+		if err != nil {
+			return fmt.Errorf("BuildDeferredIndices: %w", err)
+		}
+	}
+	return nil
+}
+
+// afterDeferredIndices - locality indices are not started from here: their background build walks files which
+// MergeLoop changes. BuildFilesInBackground builds them after merge, standalone callers use BuildMissedIndices
+func (a *AggregatorV3) afterDeferredIndices(built int) {
+	if built == 0 {
+		return
+	}
+	a.checkFiles()
+}
+
+// buildDeferredIndices - builds .efi of files which don't have it, oldest first. Indexed files are swapped in
+// under filesLock - same as integrateFiles
+func (ii *InvertedIndex) buildDeferredIndices(ctx context.Context, filesLock *sync.RWMutex) (built int, err error) {
+	filesLock.RLock()
+	missed := ii.notIndexedFiles()
+	filesLock.RUnlock()
+	for _, item := range missed {
+		if err = ctx.Err(); err != nil {
+			return built, err
+		}
+		indexed, err := ii.buildDeferredIdx(ctx, item)
+		if err != nil {
+			return built, err
+		}
+		filesLock.Lock()
+		ii.files.ReplaceOrInsert(indexed)
+		ii.fds.touch(indexed)
+		filesLock.Unlock()
+		built++
+	}
+	return built, nil
+}
+
+func (ii *InvertedIndex) notIndexedFiles() (l []*filesItem) {
+	ii.files.Ascend(func(item *filesItem) bool {
+		if item.index == nil {
+			l = append(l, item)
+		}
+		return true
+	})
+	return l
+}
+
+// buildDeferredIdx - builds .efi of `item` in staging dir, publishes it and returns indexed replacement of `item`
+func (ii *InvertedIndex) buildDeferredIdx(ctx context.Context, item *filesItem) (*filesItem, error) {
+	fromStep, toStep := item.startTxNum/ii.aggregationStep, item.endTxNum/ii.aggregationStep
+	fName := fmt.Sprintf("%s.%d-%d.efi", ii.filenameBase, fromStep, toStep)
+	idxPath, err := ii.stagingPath(fName)
+	if err != nil {
+		return nil, err
+	}
+	log.Debug("[snapshots] build deferred idx", "file", fName)
+	index, err := buildIndex(ctx, item.decompressor, idxPath, ii.tmpdir, item.decompressor.Count()/2, false /* values */)
+	if err != nil {
+		return nil, fmt.Errorf("build %s efi: %w", ii.filenameBase, err)
+	}
+	if err = publishFiles(ii.dir, nil, []**recsplit.Index{&index}); err != nil {
+		if index != nil {
+			index.Close()
+		}
+		return nil, fmt.Errorf("publish %s files: %w", ii.filenameBase, err)
+	}
+	return &filesItem{startTxNum: item.startTxNum, endTxNum: item.endTxNum, decompressor: item.decompressor, index: index}, nil
+}
+
+// buildDeferredIndices - builds .efi, then .vi of files which don't have them, oldest first
+func (h *History) buildDeferredIndices(ctx context.Context, filesLock *sync.RWMutex) (built int, err error) {
+	if built, err = h.InvertedIndex.buildDeferredIndices(ctx, filesLock); err != nil {
+		return built, err
+	}
+	type pair struct{ item, iiItem *filesItem }
+	var missed []pair
+	filesLock.RLock()
+	h.files.Ascend(func(item *filesItem) bool {
+		if item.index == nil {
+			iiItem, _ := h.InvertedIndex.files.Get(&filesItem{startTxNum: item.startTxNum, endTxNum: item.endTxNum})
+			missed = append(missed, pair{item, iiItem})
+		}
+		return true
+	})
+	filesLock.RUnlock()
+	for _, p := range missed {
+		if err = ctx.Err(); err != nil {
+			return built, err
+		}
+		indexed, err := h.buildDeferredVi(p.item, p.iiItem)
+		if err != nil {
+			return built, err
+		}
+		filesLock.Lock()
+		h.files.ReplaceOrInsert(indexed)
+		h.fds.touch(indexed)
+		filesLock.Unlock()
+		built++
+	}
+	return built, nil
+}
+
+func (h *History) buildDeferredVi(item, iiItem *filesItem) (*filesItem, error) {
+	if iiItem == nil {
+		return nil, fmt.Errorf("build %s vi: no .ef file for %d-%d", h.filenameBase, item.startTxNum/h.aggregationStep, item.endTxNum/h.aggregationStep)
+	}
+	fromStep, toStep := item.startTxNum/h.aggregationStep, item.endTxNum/h.aggregationStep
+	fName := fmt.Sprintf("%s.%d-%d.vi", h.filenameBase, fromStep, toStep)
+	idxPath, err := h.stagingPath(fName)
+	if err != nil {
+		return nil, err
+	}
+	log.Debug("[snapshots] build deferred idx", "file", fName)
+	count, err := iterateForVi(item, iiItem, h.compressVals, func(v []byte) error { return nil })
+	if err != nil {
+		return nil, err
+	}
+	if err = buildVi(item, iiItem, idxPath, h.tmpdir, count, false /* values */, h.compressVals); err != nil {
+		return nil, fmt.Errorf("build %s vi: %w", h.filenameBase, err)
+	}
+	index, err := recsplit.OpenIndex(idxPath)
+	if err != nil {
+		return nil, fmt.Errorf("open %s vi: %w", h.filenameBase, err)
+	}
+	if err = publishFiles(h.dir, nil, []**recsplit.Index{&index}); err != nil {
+		if index != nil {
+			index.Close()
+		}
+		return nil, fmt.Errorf("publish %s files: %w", h.filenameBase, err)
+	}
+	return &filesItem{startTxNum: item.startTxNum, endTxNum: item.endTxNum, decompressor: item.decompressor, index: index}, nil
+}
@@ -0,0 +1,90 @@
+/*
+   Copyright 2023 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package state
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Several AggregatorV3 instances (processes) may work with one files dir (for example erigon and rpcdaemon).
+// Advisory locks (flock) in dir coordinate them:
+//   - writer.lock - held exclusively by the only writer for its whole life: 2 writers would double-build/merge same files
+//   - readers.lock - held shared by readers while they scan/open files, and exclusively by writer while it deletes
+//     merged files: reader never sees half-deleted set of files. New files are published by rename - see publishFiles.
+// Locks are advisory: instances which didn't call LockDir are not coordinated.
+
+// AggRole - role of AggregatorV3 in files dir, see LockDir
+type AggRole int
+
+const (
+	RoleWriter AggRole = iota // builds, merges, indexes and deletes files. Only one writer per dir.
+	RoleReader                // shared-read mode: only opens files built by writer
+)
+
+func (r AggRole) String() string {
+	switch r {
+	case RoleWriter:
+		return "writer"
+	case RoleReader:
+		return "reader"
+	default:
+		return fmt.Sprintf("unknown role %d", int(r))
+	}
+}
+
+var (
+	ErrDirLocked = errors.New("files dir is locked by other writer")
+	ErrReadOnly  = errors.New("aggregator is in shared-read mode")
+)
+
+const (
+	writerLockFile  = "writer.lock"
+	readersLockFile = "readers.lock"
+)
+
+type dirLock struct {
+	f *os.File
+}
+
+func openDirLock(dir, name string) (*dirLock, error) {
+	f, err := os.OpenFile(filepath.Join(dir, name), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &dirLock{f: f}, nil
+}
+
+// tryLock - exclusive lock without waiting, false if it's held by other process
+func (l *dirLock) tryLock() (bool, error) { return lockFile(l.f, true, false) }
+
+// lock - waits for lock: exclusive or shared
+func (l *dirLock) lock(exclusive bool) error {
+	_, err := lockFile(l.f, exclusive, true)
+	return err
+}
+
+func (l *dirLock) unlock() error { return unlockFile(l.f) }
+
+func (l *dirLock) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.f.Close() // releases locks
+}
@@ -21,10 +21,10 @@ import (
 	"container/heap"
 	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io/fs"
 	"math"
-	"os"
 	"path/filepath"
 	"regexp"
 	"strconv"
@@ -37,7 +37,7 @@ import (
 	"golang.org/x/sync/semaphore"
 
 	"github.com/ledgerwatch/erigon-lib/common"
-	"github.com/ledgerwatch/erigon-lib/common/dir"
+	"github.com/ledgerwatch/erigon-lib/common/dbg"
 	"github.com/ledgerwatch/erigon-lib/compress"
 	"github.com/ledgerwatch/erigon-lib/kv"
 	"github.com/ledgerwatch/erigon-lib/recsplit"
@@ -54,6 +54,49 @@ type filesItem struct {
 	index        *recsplit.Index
 	startTxNum   uint64
 	endTxNum     uint64
+
+	// refcount - amount of AggregatorV3Context using files (atomic), canDelete - files are merged into bigger one,
+	// last context closes them. canDelete is changed under AggregatorV3.filesLock
+	refcount  int32
+	canDelete bool
+
+	fsys FS // files were opened by it, nil - files were built locally
+}
+
+// closeFilesIfUnused - closes files of item not used by any AggregatorV3Context, files of used item are closed by last
+// context (see AggregatorV3Context.Close)
+func (i *filesItem) closeFilesIfUnused() {
+	if atomic.LoadInt32(&i.refcount) == 0 {
+		i.closeFiles()
+	}
+}
+
+func (i *filesItem) ref() { atomic.AddInt32(&i.refcount, 1) }
+
+// unref - context doesn't use files anymore: last context closes files of merged item
+func (i *filesItem) unref() {
+	if atomic.AddInt32(&i.refcount, -1) == 0 && i.canDelete {
+		i.closeFiles()
+	}
+}
+
+func (i *filesItem) closeDecompressor() {
+	if i.decompressor != nil {
+		i.decompressor.Close()
+		if i.fsys != nil {
+			i.fsys.Close(i.decompressor.FilePath())
+		}
+	}
+}
+
+func (i *filesItem) closeFiles() {
+	i.closeDecompressor()
+	if i.index != nil {
+		i.index.Close()
+		if i.fsys != nil {
+			i.fsys.Close(i.index.FilePath())
+		}
+	}
 }
 
 func (i *filesItem) isSubsetOf(j *filesItem) bool {
@@ -95,6 +138,7 @@ type Domain struct {
 	stats       DomainStats
 	prefixLen   int // Number of bytes in the keys that can be used for prefix iteration
 	mergesCount uint64
+	compressKV  bool // values of .kv files are compressed (History.compressVals is about .v files), see DomainCommitted.SetCompressValues
 }
 
 func NewDomain(
@@ -120,7 +164,7 @@ func NewDomain(
 	if d.History, err = NewHistory(dir, tmpdir, aggregationStep, filenameBase, indexKeysTable, indexTable, historyValsTable, settingsTable, compressVals, []string{"kv"}); err != nil {
 		return nil, err
 	}
-	files, err := os.ReadDir(dir)
+	files, err := d.fsys().ReadDir(dir)
 	if err != nil {
 		return nil, err
 	}
@@ -209,26 +253,31 @@ func (d *Domain) openFiles() error {
 
 	invalidFileItems := make([]*filesItem, 0)
 	d.files.Ascend(func(item *filesItem) bool {
-		if item.decompressor != nil {
-			item.decompressor.Close()
-		}
+		item.closeDecompressor()
+		item.fsys = d.fsys()
 		fromStep, toStep := item.startTxNum/d.aggregationStep, item.endTxNum/d.aggregationStep
 		datPath := filepath.Join(d.dir, fmt.Sprintf("%s.%d-%d.kv", d.filenameBase, fromStep, toStep))
-		if !dir.FileExist(datPath) {
-			invalidFileItems = append(invalidFileItems, item)
-			return true
+		if item.decompressor, err = openDecompressor(d.fsys(), datPath); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				invalidFileItems = append(invalidFileItems, item)
+				err = nil
+				return true
+			}
+			return false
 		}
-		if item.decompressor, err = compress.NewDecompressor(datPath); err != nil {
+		if err = d.checkFileMeta(item.decompressor, "kv", item.startTxNum, item.endTxNum); err != nil {
 			return false
 		}
 
 		if item.index == nil {
 			idxPath := filepath.Join(d.dir, fmt.Sprintf("%s.%d-%d.kvi", d.filenameBase, fromStep, toStep))
-			if dir.FileExist(idxPath) {
-				if item.index, err = recsplit.OpenIndex(idxPath); err != nil {
+			if item.index, err = openIndex(d.fsys(), idxPath); err != nil {
+				if !errors.Is(err, fs.ErrNotExist) {
 					log.Debug("InvertedIndex.openFiles: %w, %s", err, idxPath)
 					return false
 				}
+				err = nil
+			} else {
 				totalKeys += item.index.KeyCount()
 			}
 		}
@@ -240,17 +289,13 @@ func (d *Domain) openFiles() error {
 	for _, item := range invalidFileItems {
 		d.files.Delete(item)
 	}
+	d.fds.touchFiles(d.files)
 	return nil
 }
 
 func (d *Domain) closeFiles() {
 	d.files.Ascend(func(item *filesItem) bool {
-		if item.decompressor != nil {
-			item.decompressor.Close()
-		}
-		if item.index != nil {
-			item.index.Close()
-		}
+		item.closeFiles()
 		return true
 	})
 }
@@ -597,7 +642,6 @@ func (dc *DomainContext) IteratePrefix(prefix []byte, it func(k, v []byte)) erro
 // Collation is the set of compressors created after aggregation
 type Collation struct {
 	valuesComp   *compress.Compressor
-	historyComp  *compress.Compressor
 	indexBitmaps map[string]*roaring64.Bitmap
 	valuesPath   string
 	historyPath  string
@@ -609,16 +653,13 @@ func (c Collation) Close() {
 	if c.valuesComp != nil {
 		c.valuesComp.Close()
 	}
-	if c.historyComp != nil {
-		c.historyComp.Close()
-	}
 }
 
 // collate gathers domain changes over the specified step, using read-only transaction,
 // and returns compressors, elias fano, and bitmaps
 // [txFrom; txTo)
 func (d *Domain) collate(ctx context.Context, step, txFrom, txTo uint64, roTx kv.Tx, logEvery *time.Ticker) (Collation, error) {
-	hCollation, err := d.History.collate(step, txFrom, txTo, roTx, logEvery)
+	hCollation, err := d.History.collate(ctx, step, txFrom, txTo, roTx, logEvery)
 	if err != nil {
 		return Collation{}, err
 	}
@@ -632,10 +673,14 @@ func (d *Domain) collate(ctx context.Context, step, txFrom, txTo uint64, roTx kv
 			}
 		}
 	}()
-	valuesPath := filepath.Join(d.dir, fmt.Sprintf("%s.%d-%d.kv", d.filenameBase, step, step+1))
+	valuesPath, err := d.stagingPath(fmt.Sprintf("%s.%d-%d.kv", d.filenameBase, step, step+1))
+	if err != nil {
+		return Collation{}, err
+	}
 	if valuesComp, err = compress.NewCompressor(context.Background(), "collate values", valuesPath, d.tmpdir, compress.MinPatternScore, 1, log.LvlDebug); err != nil {
 		return Collation{}, fmt.Errorf("create %s values compressor: %w", d.filenameBase, err)
 	}
+	valuesComp.SetMetadata(d.fileMeta("kv", step*d.aggregationStep, (step+1)*d.aggregationStep))
 	keysCursor, err := roTx.CursorDupSort(d.keysTable)
 	if err != nil {
 		return Collation{}, fmt.Errorf("create %s keys cursor: %w", d.filenameBase, err)
@@ -653,6 +698,7 @@ func (d *Domain) collate(ctx context.Context, step, txFrom, txTo uint64, roTx kv
 	if err != nil {
 		return Collation{}, fmt.Errorf("failed to obtain keys count for domain %q", d.filenameBase)
 	}
+	lanes := d.lanes.scan()
 	for k, _, err = keysCursor.First(); err == nil && k != nil; k, _, err = keysCursor.NextNoDup() {
 		pos++
 		select {
@@ -662,9 +708,12 @@ func (d *Domain) collate(ctx context.Context, step, txFrom, txTo uint64, roTx kv
 				"progress", fmt.Sprintf("%.2f%%", float64(pos)/float64(totalKeys)*100))
 		case <-ctx.Done():
 			log.Warn("[snapshots] collate domain cancelled", "name", d.filenameBase, "err", ctx.Err())
-			return Collation{}, err
+			return Collation{}, ctx.Err()
 		default:
 		}
+		if err = lanes.yield(ctx); err != nil {
+			return Collation{}, err
+		}
 
 		if v, err = keysCursor.LastDup(); err != nil {
 			return Collation{}, fmt.Errorf("find last %s key for aggregation step k=[%x]: %w", d.filenameBase, k, err)
@@ -692,7 +741,12 @@ func (d *Domain) collate(ctx context.Context, step, txFrom, txTo uint64, roTx kv
 				return Collation{}, fmt.Errorf("add %s values key [%x]: %w", d.filenameBase, k, err)
 			}
 			valuesCount++ // Only counting keys, not values
-			if err = valuesComp.AddUncompressedWord(v); err != nil {
+			if d.compressKV {
+				err = valuesComp.AddWord(v)
+			} else {
+				err = valuesComp.AddUncompressedWord(v)
+			}
+			if err != nil {
 				return Collation{}, fmt.Errorf("add %s values val [%x]=>[%x]: %w", d.filenameBase, k, v, err)
 			}
 		}
@@ -706,7 +760,6 @@ func (d *Domain) collate(ctx context.Context, step, txFrom, txTo uint64, roTx kv
 		valuesComp:   valuesComp,
 		valuesCount:  int(valuesCount),
 		historyPath:  hCollation.historyPath,
-		historyComp:  hCollation.historyComp,
 		historyCount: hCollation.historyCount,
 		indexBitmaps: hCollation.indexBitmaps,
 	}, nil
@@ -747,7 +800,6 @@ func (sf StaticFiles) Close() {
 func (d *Domain) buildFiles(ctx context.Context, step uint64, collation Collation) (StaticFiles, error) {
 	hStaticFiles, err := d.History.buildFiles(ctx, step, HistoryCollation{
 		historyPath:  collation.historyPath,
-		historyComp:  collation.historyComp,
 		historyCount: collation.historyCount,
 		indexBitmaps: collation.indexBitmaps,
 	})
@@ -772,7 +824,10 @@ func (d *Domain) buildFiles(ctx context.Context, step uint64, collation Collatio
 			}
 		}
 	}()
-	valuesIdxPath := filepath.Join(d.dir, fmt.Sprintf("%s.%d-%d.kvi", d.filenameBase, step, step+1))
+	valuesIdxPath, err := d.stagingPath(fmt.Sprintf("%s.%d-%d.kvi", d.filenameBase, step, step+1))
+	if err != nil {
+		return StaticFiles{}, err
+	}
 	if err = valuesComp.Compress(); err != nil {
 		return StaticFiles{}, fmt.Errorf("compress %s values: %w", d.filenameBase, err)
 	}
@@ -784,6 +839,9 @@ func (d *Domain) buildFiles(ctx context.Context, step uint64, collation Collatio
 	if valuesIdx, err = buildIndex(ctx, valuesDecomp, valuesIdxPath, d.tmpdir, collation.valuesCount, false); err != nil {
 		return StaticFiles{}, fmt.Errorf("build %s values idx: %w", d.filenameBase, err)
 	}
+	if err = publishFiles(d.dir, []**compress.Decompressor{&valuesDecomp}, []**recsplit.Index{&valuesIdx}); err != nil {
+		return StaticFiles{}, fmt.Errorf("publish %s values: %w", d.filenameBase, err)
+	}
 	closeComp = false
 	return StaticFiles{
 		valuesDecomp:    valuesDecomp,
@@ -798,7 +856,7 @@ func (d *Domain) buildFiles(ctx context.Context, step uint64, collation Collatio
 func (d *Domain) missedIdxFiles() (l []*filesItem) {
 	d.files.Ascend(func(item *filesItem) bool { // don't run slow logic while iterating on btree
 		fromStep, toStep := item.startTxNum/d.aggregationStep, item.endTxNum/d.aggregationStep
-		if !dir.FileExist(filepath.Join(d.dir, fmt.Sprintf("%s.%d-%d.kvi", d.filenameBase, fromStep, toStep))) {
+		if item.index == nil && !d.fsys().Exists(filepath.Join(d.dir, fmt.Sprintf("%s.%d-%d.kvi", d.filenameBase, fromStep, toStep))) {
 			l = append(l, item)
 		}
 		return true
@@ -822,12 +880,14 @@ func buildIndex(ctx context.Context, d *compress.Decompressor, idxPath, tmpdir s
 	var rs *recsplit.RecSplit
 	var err error
 	if rs, err = recsplit.NewRecSplit(recsplit.RecSplitArgs{
-		KeyCount:   count,
-		Enums:      false,
-		BucketSize: 2000,
-		LeafSize:   8,
-		TmpDir:     tmpdir,
-		IndexFile:  idxPath,
+		KeyCount:        count,
+		Enums:           false,
+		BucketSize:      2000,
+		LeafSize:        8,
+		TmpDir:          tmpdir,
+		IndexFile:       idxPath,
+		BloomBitsPerKey: dbg.IndexBloomBitsPerKey(),
+		Fingerprints:    dbg.IndexFingerprints(),
 	}); err != nil {
 		return nil, fmt.Errorf("create recsplit: %w", err)
 	}
@@ -883,12 +943,14 @@ func (d *Domain) integrateFiles(sf StaticFiles, txNumFrom, txNumTo uint64) {
 		efHistoryDecomp: sf.efHistoryDecomp,
 		efHistoryIdx:    sf.efHistoryIdx,
 	}, txNumFrom, txNumTo)
-	d.files.ReplaceOrInsert(&filesItem{
+	item := &filesItem{
 		startTxNum:   txNumFrom,
 		endTxNum:     txNumTo,
 		decompressor: sf.valuesDecomp,
 		index:        sf.valuesIdx,
-	})
+	}
+	d.files.ReplaceOrInsert(item)
+	d.fds.touch(item)
 }
 
 // [txFrom; txTo)
@@ -1041,7 +1103,10 @@ func (dc *DomainContext) readFromFiles(filekey []byte, fromTxNum uint64) ([]byte
 		if item.reader.Empty() {
 			return true
 		}
-		offset := item.reader.Lookup(filekey)
+		offset, ok := item.reader.TryLookup(filekey)
+		if !ok {
+			return true
+		}
 		g := item.getter
 		g.Reset(offset)
 		if g.HasNext() {
@@ -1074,7 +1139,13 @@ func (dc *DomainContext) historyBeforeTxNum(key []byte, txNum uint64, roTx kv.Tx
 	})
 	dc.hc.indexFiles.AscendGreaterOrEqual(search, func(item ctxItem) bool {
 		anyItem = true
-		offset := item.reader.Lookup(key)
+		if item.reader.Empty() {
+			return true
+		}
+		offset, ok := item.reader.TryLookup(key)
+		if !ok {
+			return true
+		}
 		g := item.getter
 		g.Reset(offset)
 		if k, _ := g.NextUncompressed(); bytes.Equal(k, key) {
@@ -1103,16 +1174,15 @@ func (dc *DomainContext) historyBeforeTxNum(key []byte, txNum uint64, roTx kv.Tx
 				if item.reader.Empty() {
 					return true
 				}
-				offset := item.reader.Lookup(key)
+				offset, ok := item.reader.TryLookup(key)
+				if !ok {
+					return true
+				}
 				g := item.getter
 				g.Reset(offset)
 				if g.HasNext() {
 					if k, _ := g.NextUncompressed(); bytes.Equal(k, key) {
-						if dc.d.compressVals {
-							val, _ = g.Next(nil)
-						} else {
-							val, _ = g.NextUncompressed()
-						}
+						val, _ = g.Next(nil) // reads both compressed and uncompressed words: .kv files of both kinds may exist
 						return false
 					}
 				}
@@ -1167,7 +1237,10 @@ func (dc *DomainContext) historyBeforeTxNum(key []byte, txNum uint64, roTx kv.Tx
 	if !ok {
 		return nil, false, fmt.Errorf("no %s file found for [%x]", dc.d.filenameBase, key)
 	}
-	offset := historyItem.reader.Lookup2(txKey[:], key)
+	offset, ok := historyItem.reader.TryLookup2(txKey[:], key)
+	if !ok {
+		return nil, false, fmt.Errorf("%w: %s has no value of key=%x, txNum=%d", ErrFileCorrupted, historyItem.getter.FileName(), key, foundTxNum)
+	}
 	g := historyItem.getter
 	g.Reset(offset)
 	if dc.d.compressVals {
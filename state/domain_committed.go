@@ -23,6 +23,8 @@ import (
 	"encoding/binary"
 	"fmt"
 	"hash"
+	"io"
+	"math"
 	"path/filepath"
 
 	"github.com/google/btree"
@@ -31,8 +33,10 @@ import (
 
 	"github.com/ledgerwatch/erigon-lib/commitment"
 	"github.com/ledgerwatch/erigon-lib/common"
+	"github.com/ledgerwatch/erigon-lib/common/cmp"
 	"github.com/ledgerwatch/erigon-lib/common/length"
 	"github.com/ledgerwatch/erigon-lib/compress"
+	"github.com/ledgerwatch/erigon-lib/kv"
 	"github.com/ledgerwatch/erigon-lib/recsplit"
 )
 
@@ -56,6 +60,10 @@ type DomainCommitted struct {
 	patriciaTrie *commitment.HexPatriciaHashed
 	keyReplaceFn ValueMerger // defines logic performed with stored values during files merge
 	branchMerger *commitment.BranchMerger
+
+	batchSize     int                                 // see SetBatchSize
+	branchFn      func(prefix []byte) ([]byte, error) // reads stored branches, see ResetFns
+	batchBranches map[string]commitment.BranchData    // branches updated by previous batches of current ComputeCommitment
 }
 
 func NewCommittedDomain(d *Domain, mode CommitmentMode) *DomainCommitted {
@@ -73,6 +81,34 @@ func (d *DomainCommitted) SetKeyReplacer(vm ValueMerger) { d.keyReplaceFn = vm }
 
 func (d *DomainCommitted) SetCommitmentMode(m CommitmentMode) { d.mode = m }
 
+// SetCompressValues - values of .kv files (branches) are compressed: commitment is the biggest domain. Existing
+// files with uncompressed values stay readable and are rewritten compressed (and canonical, see
+// commitment.BranchData.Canonicalize) by merges.
+func (d *DomainCommitted) SetCompressValues(v bool) { d.compressKV = v }
+
+// SetBatchSize - ComputeCommitment of more than `n` touched keys is split into batches of `n` keys: trie is folded
+// up to root after each batch and branches updated by batch are visible to next ones. Bounds amount of keys processed
+// by one pass of trie. Root hash is same as without batching. 0 - no batching (default).
+func (d *DomainCommitted) SetBatchSize(n int) { d.batchSize = n }
+
+// ResetFns - sets data accessing functions of trie. Branches are read through DomainCommitted: in batched
+// ComputeCommitment they may be updated by previous batch, but not stored yet.
+func (d *DomainCommitted) ResetFns(
+	branchFn func(prefix []byte) ([]byte, error),
+	accountFn func(plainKey []byte, cell *commitment.Cell) error,
+	storageFn func(plainKey []byte, cell *commitment.Cell) error,
+) {
+	d.branchFn = branchFn
+	d.patriciaTrie.ResetFns(d.readBranch, accountFn, storageFn)
+}
+
+func (d *DomainCommitted) readBranch(prefix []byte) ([]byte, error) {
+	if branch, ok := d.batchBranches[string(prefix)]; ok {
+		return branch[2:], nil // as branchFn - skip touchMap
+	}
+	return d.branchFn(prefix)
+}
+
 // TouchPlainKey marks plainKey as updated and applies different fn for different key types
 // (different behaviour for Code, Account and Storage key modifications).
 func (d *DomainCommitted) TouchPlainKey(key, val []byte, fn func(c *CommitmentItem, val []byte)) {
@@ -170,17 +206,21 @@ func (d *DomainCommitted) TouchedKeyList() ([][]byte, [][]byte, []commitment.Upd
 
 // TODO(awskii): let trie define hashing function
 func (d *DomainCommitted) hashAndNibblizeKey(key []byte) []byte {
+	return hashAndNibblizeKey(d.keccak, key)
+}
+
+func hashAndNibblizeKey(keccak hash.Hash, key []byte) []byte {
 	hashedKey := make([]byte, length.Hash)
 
-	d.keccak.Reset()
-	d.keccak.Write(key[:length.Addr])
-	copy(hashedKey[:length.Hash], d.keccak.Sum(nil))
+	keccak.Reset()
+	keccak.Write(key[:length.Addr])
+	copy(hashedKey[:length.Hash], keccak.Sum(nil))
 
 	if len(key[length.Addr:]) > 0 {
 		hashedKey = append(hashedKey, make([]byte, length.Hash)...)
-		d.keccak.Reset()
-		d.keccak.Write(key[length.Addr:])
-		copy(hashedKey[length.Hash:], d.keccak.Sum(nil))
+		keccak.Reset()
+		keccak.Write(key[length.Addr:])
+		copy(hashedKey[length.Hash:], keccak.Sum(nil))
 	}
 
 	nibblized := make([]byte, len(hashedKey)*2)
@@ -191,26 +231,110 @@ func (d *DomainCommitted) hashAndNibblizeKey(key []byte) []byte {
 	return nibblized
 }
 
-func (d *DomainCommitted) storeCommitmentState(blockNum, txNum uint64) error {
-	state, err := d.patriciaTrie.EncodeCurrentState(nil)
-	if err != nil {
+func (d *DomainCommitted) storeCommitmentState(blockNum uint64, blockHash []byte, txNum uint64, rootHash []byte) error {
+	w := &commitmentStateWriter{d: d}
+	binary.BigEndian.PutUint16(w.stepbuf[:], uint16(txNum/d.aggregationStep))
+	if err := d.patriciaTrie.EncodeCurrentStateTo(w); err != nil {
 		return err
 	}
-	cs := &commitmentState{txNum: txNum, trieState: state, blockNum: blockNum}
+	if err := w.flushPart(); err != nil {
+		return err
+	}
+	cs := &commitmentState{txNum: txNum, trieState: w.head, blockNum: blockNum, parts: w.parts, rootHash: rootHash, blockHash: blockHash}
 	encoded, err := cs.Encode()
 	if err != nil {
 		return err
 	}
-
-	var stepbuf [2]byte
-	step := uint16(txNum / d.aggregationStep)
-	binary.BigEndian.PutUint16(stepbuf[:], step)
-	if err = d.Domain.Put(keyCommitmentState, stepbuf[:], encoded); err != nil {
+	if err = d.Domain.Put(keyCommitmentState, w.stepbuf[:], encoded); err != nil {
 		return err
 	}
 	return nil
 }
 
+// commitmentStatePartSize - trie state is stored as multi-part value: head value under `state|step` key keeps
+// commitmentState header with first part of trie state, other parts are stored under `state|step|partNum` keys.
+// Allows to stream trie state of any size without materializing it in one buffer.
+var commitmentStatePartSize = 4096
+
+func commitmentStatePartKey(stepbuf []byte, part uint16) []byte {
+	k := make([]byte, len(stepbuf)+2)
+	copy(k, stepbuf)
+	binary.BigEndian.PutUint16(k[len(stepbuf):], part)
+	return k
+}
+
+// commitmentStateWriter - splits encoded trie state into parts and puts them to domain as soon as they are full.
+// First part is kept in `head` - it's stored together with commitmentState header after all parts are written.
+type commitmentStateWriter struct {
+	d       *DomainCommitted
+	stepbuf [2]byte
+	head    []byte
+	buf     []byte
+	parts   uint16
+}
+
+func (w *commitmentStateWriter) Write(p []byte) (n int, err error) {
+	n = len(p)
+	for len(p) > 0 {
+		if len(w.head) < commitmentStatePartSize {
+			k := cmp.Min(commitmentStatePartSize-len(w.head), len(p))
+			w.head, p = append(w.head, p[:k]...), p[k:]
+			continue
+		}
+		k := cmp.Min(commitmentStatePartSize-len(w.buf), len(p))
+		w.buf, p = append(w.buf, p[:k]...), p[k:]
+		if len(w.buf) == commitmentStatePartSize {
+			if err = w.flushPart(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return n, nil
+}
+
+func (w *commitmentStateWriter) flushPart() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	if w.parts == math.MaxUint16 {
+		return fmt.Errorf("commitment state is too big: more than %d parts", math.MaxUint16)
+	}
+	w.parts++
+	if err := w.d.Domain.Put(keyCommitmentState, commitmentStatePartKey(w.stepbuf[:], w.parts), w.buf); err != nil {
+		return fmt.Errorf("put commitment state part %d: %w", w.parts, err)
+	}
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// commitmentStateReader - reads trie state parts one by one, in pair with commitmentStateWriter
+type commitmentStateReader struct {
+	ctx     *DomainContext
+	tx      kv.Tx
+	stepbuf []byte
+	parts   uint16
+	next    uint16
+	cur     bytes.Reader
+}
+
+func (r *commitmentStateReader) Read(p []byte) (int, error) {
+	for r.cur.Len() == 0 {
+		if r.next >= r.parts {
+			return 0, io.EOF
+		}
+		r.next++
+		v, err := r.ctx.Get(keyCommitmentState, commitmentStatePartKey(r.stepbuf, r.next), r.tx)
+		if err != nil {
+			return 0, fmt.Errorf("get commitment state part %d: %w", r.next, err)
+		}
+		if len(v) == 0 {
+			return 0, fmt.Errorf("commitment state part %d of %d not found", r.next, r.parts)
+		}
+		r.cur.Reset(v)
+	}
+	return r.cur.Read(p)
+}
+
 // nolint
 func (d *DomainCommitted) replaceKeyWithReference(fullKey, shortKey []byte, typeAS string, list ...*filesItem) bool {
 	numBuf := [2]byte{}
@@ -275,11 +399,14 @@ func (d *DomainCommitted) lookupShortenedKey(shortKey, fullKey []byte, typAS str
 
 // commitmentValTransform parses the value of the commitment record to extract references
 // to accounts and storage items, then looks them up in the new, merged files, and replaces them with
-// the updated references
-func (d *DomainCommitted) commitmentValTransform(files *SelectedStaticFiles, merged *MergedFiles, val commitment.BranchData) ([]byte, error) {
+// the updated references. Records of commitment state (and its parts) are not branches - kept as is.
+func (d *DomainCommitted) commitmentValTransform(files *SelectedStaticFiles, merged *MergedFiles, key []byte, val commitment.BranchData) ([]byte, error) {
 	if len(val) == 0 {
 		return nil, nil
 	}
+	if bytes.HasPrefix(key, keyCommitmentState) {
+		return val, nil
+	}
 	accountPlainKeys, storagePlainKeys, err := val.ExtractPlainKeys()
 	if err != nil {
 		return nil, err
@@ -322,7 +449,7 @@ func (d *DomainCommitted) commitmentValTransform(files *SelectedStaticFiles, mer
 	if err != nil {
 		return nil, err
 	}
-	return transValBuf, nil
+	return transValBuf.Canonicalize(nil) // branches written by older versions may carry redundant hashes
 }
 
 func (d *DomainCommitted) mergeFiles(ctx context.Context, oldFiles SelectedStaticFiles, mergedFiles MergedFiles, r DomainRanges, workers int) (valuesIn, indexIn, historyIn *filesItem, err error) {
@@ -382,6 +509,7 @@ func (d *DomainCommitted) mergeFiles(ctx context.Context, oldFiles SelectedStati
 		if comp, err = compress.NewCompressor(context.Background(), "merge", datPath, d.dir, compress.MinPatternScore, workers, log.LvlDebug); err != nil {
 			return nil, nil, nil, fmt.Errorf("merge %s history compressor: %w", d.filenameBase, err)
 		}
+		comp.SetMetadata(d.fileMeta("kv", r.valuesStartTxNum, r.valuesEndTxNum))
 		var cp CursorHeap
 		heap.Init(&cp)
 		for _, item := range valuesFiles {
@@ -389,12 +517,7 @@ func (d *DomainCommitted) mergeFiles(ctx context.Context, oldFiles SelectedStati
 			g.Reset(0)
 			if g.HasNext() {
 				key, _ := g.NextUncompressed()
-				var val []byte
-				if d.compressVals {
-					val, _ = g.Next(nil)
-				} else {
-					val, _ = g.NextUncompressed()
-				}
+				val, _ := g.Next(nil) // files written before SetCompressValues have uncompressed values
 				if d.trace {
 					fmt.Printf("merge: read value '%x'\n", key)
 				}
@@ -423,11 +546,7 @@ func (d *DomainCommitted) mergeFiles(ctx context.Context, oldFiles SelectedStati
 				ci1 := cp[0]
 				if ci1.dg.HasNext() {
 					ci1.key, _ = ci1.dg.NextUncompressed()
-					if d.compressVals {
-						ci1.val, _ = ci1.dg.Next(ci1.val[:0])
-					} else {
-						ci1.val, _ = ci1.dg.NextUncompressed()
-					}
+					ci1.val, _ = ci1.dg.Next(ci1.val[:0])
 					heap.Fix(&cp, 0)
 				} else {
 					heap.Pop(&cp)
@@ -451,11 +570,11 @@ func (d *DomainCommitted) mergeFiles(ctx context.Context, oldFiles SelectedStati
 						fmt.Printf("merge: multi-way key %x, total keys %d\n", keyBuf, keyCount)
 					}
 
-					valBuf, err = d.commitmentValTransform(&oldFiles, &mergedFiles, valBuf)
+					valBuf, err = d.commitmentValTransform(&oldFiles, &mergedFiles, keyBuf, valBuf)
 					if err != nil {
 						return nil, nil, nil, fmt.Errorf("merge: valTransform [%x] %w", valBuf, err)
 					}
-					if d.compressVals {
+					if d.compressVals || d.compressKV {
 						if err = comp.AddWord(valBuf); err != nil {
 							return nil, nil, nil, err
 						}
@@ -475,11 +594,11 @@ func (d *DomainCommitted) mergeFiles(ctx context.Context, oldFiles SelectedStati
 			}
 			keyCount++ // Only counting keys, not values
 			//fmt.Printf("last heap key %x\n", keyBuf)
-			valBuf, err = d.commitmentValTransform(&oldFiles, &mergedFiles, valBuf)
+			valBuf, err = d.commitmentValTransform(&oldFiles, &mergedFiles, keyBuf, valBuf)
 			if err != nil {
 				return nil, nil, nil, fmt.Errorf("merge: 2valTransform [%x] %w", valBuf, err)
 			}
-			if d.compressVals {
+			if d.compressVals || d.compressKV {
 				if err = comp.AddWord(valBuf); err != nil {
 					return nil, nil, nil, err
 				}
@@ -520,6 +639,9 @@ func (d *DomainCommitted) ComputeCommitment(trace bool) (rootHash []byte, branch
 	// data accessing functions should be set once before
 	d.patriciaTrie.Reset()
 	d.patriciaTrie.SetTrace(trace)
+	if d.batchSize > 0 && len(touchedKeys) > d.batchSize {
+		return d.computeCommitmentBatched(touchedKeys, hashedKeys, updates)
+	}
 
 	switch d.mode {
 	case CommitmentModeDirect:
@@ -538,13 +660,70 @@ func (d *DomainCommitted) ComputeCommitment(trace bool) (rootHash []byte, branch
 	return rootHash, branchNodeUpdates, err
 }
 
+// computeCommitmentBatched - keys are sorted by hashed key, so each batch is continuation of previous one. Branch
+// updates of batches are merged in batchBranches - returned updates are same as of single pass.
+func (d *DomainCommitted) computeCommitmentBatched(touchedKeys, hashedKeys [][]byte, updates []commitment.Update) (rootHash []byte, branchNodeUpdates map[string]commitment.BranchData, err error) {
+	d.batchBranches = map[string]commitment.BranchData{}
+	defer func() { d.batchBranches = nil }()
+
+	for from := 0; from < len(touchedKeys); from += d.batchSize {
+		to := cmp.Min(from+d.batchSize, len(touchedKeys))
+		var batchUpdates map[string]commitment.BranchData
+		switch d.mode {
+		case CommitmentModeDirect:
+			_, batchUpdates, err = d.patriciaTrie.ReviewKeys(touchedKeys[from:to], hashedKeys[from:to])
+		case CommitmentModeUpdate:
+			_, batchUpdates, err = d.patriciaTrie.ProcessUpdates(touchedKeys[from:to], hashedKeys[from:to], updates[from:to])
+		default:
+			return nil, nil, fmt.Errorf("invalid commitment mode: %d", d.mode)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		for prefix, update := range batchUpdates {
+			prev, ok := d.batchBranches[prefix]
+			if !ok {
+				stored, err := d.branchFn([]byte(prefix))
+				if err != nil {
+					return nil, nil, err
+				}
+				if stored != nil {
+					// branchFn returns afterMap and cells of all present children: touchMap is same as afterMap
+					prev = make(commitment.BranchData, 2+len(stored))
+					copy(prev, stored[:2])
+					copy(prev[2:], stored)
+				}
+			}
+			merged, err := d.branchMerger.Merge(prev, update)
+			if err != nil {
+				return nil, nil, err
+			}
+			d.batchBranches[prefix] = common.Copy(merged)
+		}
+	}
+	if rootHash, err = d.patriciaTrie.RootHash(); err != nil {
+		return nil, nil, err
+	}
+	return rootHash, d.batchBranches, nil
+}
+
 var keyCommitmentState = []byte("state")
 
 // SeekCommitment searches for last encoded state from DomainCommitted
 // and if state found, sets it up to current domain
 func (d *DomainCommitted) SeekCommitment(aggStep, sinceTx uint64) (uint64, error) {
+	latest, err := d.seekCommitment(aggStep, sinceTx)
+	if err != nil || latest == nil {
+		return 0, err
+	}
+	return latest.txNum, nil
+}
+
+// seekCommitment - returns restored state or nil if no state found
+func (d *DomainCommitted) seekCommitment(aggStep, sinceTx uint64) (*commitmentState, error) {
 	var (
-		latestState []byte
+		latest      *commitmentState
+		latestStep  [2]byte
 		stepbuf     [2]byte
 		step        uint16 = uint16(sinceTx/aggStep) - 1
 		latestTxNum uint64 = sinceTx - 1
@@ -557,40 +736,79 @@ func (d *DomainCommitted) SeekCommitment(aggStep, sinceTx uint64) (uint64, error
 
 		s, err := ctx.Get(keyCommitmentState, stepbuf[:], d.tx)
 		if err != nil {
-			return 0, err
+			return nil, err
 		}
-		if len(s) < 8 {
+		if len(s) == 0 {
 			break
 		}
-		v := binary.BigEndian.Uint64(s)
-		if v == latestTxNum && len(latestState) != 0 {
+		cs := &commitmentState{}
+		if err := cs.Decode(s); err != nil {
+			return nil, fmt.Errorf("commitment state of step %d: %w", step, err)
+		}
+		if cs.txNum == latestTxNum && latest != nil {
 			break
 		}
-		latestTxNum, latestState = v, s
+		latestTxNum, latest, latestStep = cs.txNum, cs, stepbuf
 		lookupTxN := latestTxNum + aggStep // - 1
 		step = uint16(latestTxNum/aggStep) + 1
 		d.SetTxNum(lookupTxN)
 	}
-
-	var latest commitmentState
-	if err := latest.Decode(latestState); err != nil {
-		return 0, nil
+	if latest == nil {
+		return nil, nil
 	}
 
-	if err := d.patriciaTrie.SetState(latest.trieState); err != nil {
-		return 0, err
+	var r io.Reader = bytes.NewReader(latest.trieState)
+	if latest.parts > 0 {
+		r = io.MultiReader(r, &commitmentStateReader{ctx: ctx, tx: d.tx, stepbuf: latestStep[:], parts: latest.parts})
 	}
-	return latest.txNum, nil
+	if err := d.patriciaTrie.SetStateFrom(r); err != nil {
+		return nil, err
+	}
+	if len(latest.rootHash) > 0 {
+		rootHash, err := d.patriciaTrie.RootHash()
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(rootHash, latest.rootHash) {
+			return nil, fmt.Errorf("commitment state of txNum=%d block=%d is corrupted: root hash %x, expected %x", latest.txNum, latest.blockNum, rootHash, latest.rootHash)
+		}
+	}
+	return latest, nil
 }
 
+const (
+	// commitmentStateVersionMark - first byte of versioned commitment state. Version 0 has no header and starts
+	// with big-endian txNum, so its first byte can't be 0xff in practice.
+	commitmentStateVersionMark byte = 0xff
+	commitmentStateVersion     byte = 1
+)
+
 type commitmentState struct {
 	txNum     uint64
 	blockNum  uint64
-	trieState []byte
+	blockHash []byte // since v1
+	rootHash  []byte // since v1, checked after state restore
+	trieState []byte // first part of trie state
+	parts     uint16 // amount of trie state parts stored under separated keys, see commitmentStatePartSize
 }
 
+// Decode - decodes any known version of commitment state:
+//   - v0: txNum(8) | blockNum(8) | trieStateLen(2) | trieState | [parts(2)]
+//   - v1: 0xff | 1 | txNum(8) | blockNum(8) | blockHashLen(1) | blockHash | rootHashLen(1) | rootHash | parts(2) | trieStateLen(2) | trieState
 func (cs *commitmentState) Decode(buf []byte) error {
-	if len(buf) < 10 {
+	if len(buf) >= 2 && buf[0] == commitmentStateVersionMark {
+		switch buf[1] {
+		case 1:
+			return cs.decodeV1(buf[2:])
+		default:
+			return fmt.Errorf("unsupported commitment state version: %d", buf[1])
+		}
+	}
+	return cs.decodeV0(buf)
+}
+
+func (cs *commitmentState) decodeV0(buf []byte) error {
+	if len(buf) < 18 {
 		return fmt.Errorf("ivalid commitment state buffer size")
 	}
 	pos := 0
@@ -600,26 +818,71 @@ func (cs *commitmentState) Decode(buf []byte) error {
 	pos += 8
 	cs.trieState = make([]byte, binary.BigEndian.Uint16(buf[pos:pos+2]))
 	pos += 2
-	if len(cs.trieState) == 0 && len(buf) == 10 {
-		return nil
+	if len(buf) < pos+len(cs.trieState) {
+		return fmt.Errorf("ivalid commitment state buffer size")
 	}
 	copy(cs.trieState, buf[pos:pos+len(cs.trieState)])
+	pos += len(cs.trieState)
+	// parts are optional: state written as single value has no parts count
+	cs.parts = 0
+	if len(buf) >= pos+2 {
+		cs.parts = binary.BigEndian.Uint16(buf[pos : pos+2])
+	}
+	cs.blockHash, cs.rootHash = nil, nil
 	return nil
 }
 
-func (cs *commitmentState) Encode() ([]byte, error) {
-	buf := bytes.NewBuffer(nil)
-	var v [18]byte
-	binary.BigEndian.PutUint64(v[:], cs.txNum)
-	binary.BigEndian.PutUint64(v[8:16], cs.blockNum)
-	binary.BigEndian.PutUint16(v[16:18], uint16(len(cs.trieState)))
-	if _, err := buf.Write(v[:]); err != nil {
-		return nil, err
+func (cs *commitmentState) decodeV1(buf []byte) error {
+	errSize := fmt.Errorf("ivalid commitment state v1 buffer size")
+	if len(buf) < 17 {
+		return errSize
 	}
-	if _, err := buf.Write(cs.trieState); err != nil {
-		return nil, err
+	pos := 0
+	cs.txNum = binary.BigEndian.Uint64(buf[pos : pos+8])
+	pos += 8
+	cs.blockNum = binary.BigEndian.Uint64(buf[pos : pos+8])
+	pos += 8
+	for _, h := range []*[]byte{&cs.blockHash, &cs.rootHash} {
+		if len(buf) < pos+1 || len(buf) < pos+1+int(buf[pos]) {
+			return errSize
+		}
+		*h = common.Copy(buf[pos+1 : pos+1+int(buf[pos])])
+		pos += 1 + int(buf[pos])
+	}
+	if len(buf) < pos+4 {
+		return errSize
+	}
+	cs.parts = binary.BigEndian.Uint16(buf[pos : pos+2])
+	pos += 2
+	size := int(binary.BigEndian.Uint16(buf[pos : pos+2]))
+	pos += 2
+	if len(buf) < pos+size {
+		return errSize
 	}
-	return buf.Bytes(), nil
+	cs.trieState = common.Copy(buf[pos : pos+size])
+	return nil
+}
+
+// Encode - encodes commitment state with latest version
+func (cs *commitmentState) Encode() ([]byte, error) {
+	if len(cs.blockHash) > 255 || len(cs.rootHash) > 255 || len(cs.trieState) > math.MaxUint16 {
+		return nil, fmt.Errorf("commitment state field is too long")
+	}
+	var v [18]byte
+	v[0], v[1] = commitmentStateVersionMark, commitmentStateVersion
+	binary.BigEndian.PutUint64(v[2:10], cs.txNum)
+	binary.BigEndian.PutUint64(v[10:18], cs.blockNum)
+	buf := make([]byte, 0, len(v)+2+len(cs.blockHash)+len(cs.rootHash)+4+len(cs.trieState))
+	buf = append(buf, v[:]...)
+	buf = append(buf, byte(len(cs.blockHash)))
+	buf = append(buf, cs.blockHash...)
+	buf = append(buf, byte(len(cs.rootHash)))
+	buf = append(buf, cs.rootHash...)
+	binary.BigEndian.PutUint16(v[:2], cs.parts)
+	binary.BigEndian.PutUint16(v[2:4], uint16(len(cs.trieState)))
+	buf = append(buf, v[:4]...)
+	buf = append(buf, cs.trieState...)
+	return buf, nil
 }
 
 func decodeU64(from []byte) uint64 {
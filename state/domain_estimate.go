@@ -0,0 +1,107 @@
+/*
+   Copyright 2023 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package state
+
+import (
+	"bytes"
+	"math"
+
+	"github.com/ledgerwatch/erigon-lib/kv"
+)
+
+// PrefixEstimate - approximate amount and size of keys with prefix.
+// Key present in several files (and DB) is counted several times.
+type PrefixEstimate struct {
+	Keys  uint64
+	Bytes uint64 // keys and values, compressed size for files
+	// Exact - false if some source was estimated (prefix length is not prefixLen of domain) or
+	// its scan was stopped by estimatePrefixScanLimit (then values are lower bound)
+	Exact bool
+}
+
+// estimatePrefixScanLimit - max keys visited per file (and in DB) by EstimatePrefix
+const estimatePrefixScanLimit = 1024
+
+// EstimatePrefix - cost of IteratePrefix without iteration: for RPC limits, merge planning.
+// If len(prefix) == prefixLen of domain - files are scanned from position of prefix in .kvi (files without prefix are
+// skipped by 1 lookup), otherwise - estimated by .kvi key count and file size assuming uniform distribution of keys.
+func (dc *DomainContext) EstimatePrefix(prefix []byte, roTx kv.Tx) (PrefixEstimate, error) {
+	res := PrefixEstimate{Exact: true}
+	indexed := dc.d.prefixLen > 0 && len(prefix) == dc.d.prefixLen
+	share := math.Pow(256, -float64(len(prefix))) // of uniformly distributed keys
+	dc.files.Ascend(func(item ctxItem) bool {
+		if item.reader.Empty() {
+			return true
+		}
+		if !indexed {
+			res.Exact = false
+			res.Keys += uint64(math.Ceil(float64(item.reader.KeyCount()) * share))
+			res.Bytes += uint64(math.Ceil(float64(item.getter.Size()) * share))
+			return true
+		}
+		g := item.getter
+		g.Reset(item.reader.Lookup(prefix))
+		if !g.HasNext() {
+			return true
+		}
+		if keyMatch, _ := g.Match(prefix); !keyMatch {
+			return true
+		}
+		offset := g.SkipValue() // value of prefix
+		for i := 0; g.HasNext(); i++ {
+			if i == estimatePrefixScanLimit {
+				res.Exact = false
+				break
+			}
+			if !g.MatchPrefix(prefix) {
+				break
+			}
+			g.Skip()
+			next := g.SkipValue()
+			res.Keys++
+			res.Bytes += next - offset
+			offset = next
+		}
+		return true
+	})
+
+	keysCursor, err := roTx.CursorDupSort(dc.d.keysTable)
+	if err != nil {
+		return res, err
+	}
+	defer keysCursor.Close()
+	var keySuffix []byte
+	k, v, err := keysCursor.Seek(prefix)
+	for i := 0; err == nil && k != nil && bytes.HasPrefix(k, prefix); i++ {
+		if i == estimatePrefixScanLimit {
+			res.Exact = false
+			break
+		}
+		keySuffix = append(append(keySuffix[:0], k...), v...)
+		val, err := roTx.GetOne(dc.d.valsTable, keySuffix)
+		if err != nil {
+			return res, err
+		}
+		res.Keys++
+		res.Bytes += uint64(len(k) + len(val))
+		k, v, err = keysCursor.NextNoDup()
+	}
+	if err != nil {
+		return res, err
+	}
+	return res, nil
+}
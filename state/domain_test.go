@@ -767,3 +767,53 @@ func TestScanStaticFilesD(t *testing.T) {
 	require.Equal(t, "0-4", found[0])
 	require.Equal(t, "4-5", found[1])
 }
+
+func TestDomainEstimatePrefix(t *testing.T) {
+	logEvery := time.NewTicker(30 * time.Second)
+	defer logEvery.Stop()
+	_, db, d := testDbAndDomain(t, 5 /* prefixLen */)
+	ctx := context.Background()
+	tx, err := db.BeginRw(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback()
+	d.SetTx(tx)
+	d.StartWrites("")
+	defer d.FinishWrites()
+
+	d.SetTxNum(2)
+	for _, k := range []string{"addr1loc1", "addr1loc2", "addr1loc3", "addr2loc1", "addr2loc2", "addr3loc1"} {
+		require.NoError(t, d.Put([]byte(k[:5]), []byte(k[5:]), []byte("value1")))
+	}
+	d.SetTxNum(2 + 16)
+	for _, k := range []string{"addr2loc1", "addr2loc2", "addr2loc3", "addr2loc4"} {
+		require.NoError(t, d.Put([]byte(k[:5]), []byte(k[5:]), []byte("value1")))
+	}
+	d.SetTxNum(2 + 16 + 16)
+	require.NoError(t, d.Put([]byte("addr2"), []byte("loc5"), []byte("value1")))
+	require.NoError(t, d.Rotate().Flush(ctx, tx))
+	for step := uint64(0); step < 2; step++ {
+		c, err := d.collate(ctx, step, step*d.aggregationStep, (step+1)*d.aggregationStep, tx, logEvery)
+		require.NoError(t, err)
+		sf, err := d.buildFiles(ctx, step, c)
+		require.NoError(t, err)
+		d.integrateFiles(sf, step*d.aggregationStep, (step+1)*d.aggregationStep)
+		require.NoError(t, d.prune(ctx, step, step*d.aggregationStep, (step+1)*d.aggregationStep, math.MaxUint64, logEvery))
+	}
+
+	dc := d.MakeContext()
+	e, err := dc.EstimatePrefix([]byte("addr2"), tx)
+	require.NoError(t, err)
+	require.True(t, e.Exact)
+	// step 0 file has no addr2 keys: they are overwritten in step 1. Latest values are also kept in db
+	require.Equal(t, uint64(4+5), e.Keys)
+	require.NotZero(t, e.Bytes)
+
+	e, err = dc.EstimatePrefix([]byte("addr9"), tx)
+	require.NoError(t, err)
+	require.Equal(t, PrefixEstimate{Exact: true}, e)
+
+	e, err = dc.EstimatePrefix([]byte("addr"), tx) // not prefixLen - estimated by files size
+	require.NoError(t, err)
+	require.False(t, e.Exact)
+	require.GreaterOrEqual(t, e.Keys, uint64(1)) // at least db key
+}
@@ -0,0 +1,53 @@
+/*
+   Copyright 2023 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package state
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors of public API, callers can branch on them by errors.Is
+var (
+	// ErrFileCorrupted - file can't be opened/read or files are inconsistent with each other. Usually fixed by
+	// removal of file (it will be re-downloaded or re-built)
+	ErrFileCorrupted = errors.New("file is corrupted or missing")
+	// ErrBehindPrune - requested txNum is before data available in DB (it was pruned or moved to files)
+	ErrBehindPrune = errors.New("requested txNum is behind prune")
+	// ErrContextStale - context must be re-created, see StaleContextError
+	ErrContextStale = errors.New("stale context")
+	// ErrFileMetaMismatch - metadata embedded into file doesn't match it's name or chain, see FileMeta
+	ErrFileMetaMismatch = errors.New("file metadata mismatch")
+	// ErrLowFreeSpace - build/merge of files refused to start, see LowFreeSpaceError
+	ErrLowFreeSpace = errors.New("low free space")
+)
+
+// fileCorruptedError - ErrFileCorrupted with path of file and original error
+type fileCorruptedError struct {
+	path string
+	err  error
+}
+
+func newFileCorruptedError(path string, err error) error {
+	return &fileCorruptedError{path: path, err: err}
+}
+
+func (e *fileCorruptedError) Error() string {
+	return fmt.Sprintf("%s: %s: %s", ErrFileCorrupted, e.path, e.err)
+}
+func (e *fileCorruptedError) Is(target error) bool { return target == ErrFileCorrupted }
+func (e *fileCorruptedError) Unwrap() error        { return e.err }
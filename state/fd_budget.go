@@ -0,0 +1,181 @@
+/*
+   Copyright 2022 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package state
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/google/btree"
+	"github.com/ledgerwatch/log/v3"
+	"go.uber.org/atomic"
+)
+
+// Each opened .kv/.v/.ef and their indices holds file descriptor - thousands of files of archive node can exhaust
+// ulimit. Files are mmapped and mapping doesn't need descriptor, so fdBudget keeps descriptors only of most recently
+// used files: when limit is exceeded, least recently used ones are released (see compress.Decompressor.ReleaseFile)
+// and reopened when file is used again (files are reopened, merged or integrated). Reads are not affected by release.
+
+var (
+	fdOpenMetric     = stateMetrics.Counter(`files_fd_open`)
+	fdReleasedMetric = stateMetrics.Counter(`files_fd_released_total`)
+	fdReopenedMetric = stateMetrics.Counter(`files_fd_reopened_total`)
+)
+
+// fdHandle - implemented by compress.Decompressor and recsplit.Index
+type fdHandle interface {
+	ReleaseFile() error
+	ReopenFile() (bool, error)
+	Closed() bool
+}
+
+// FDStats - counters of fdBudget, see AggregatorV3.FDStats
+type FDStats struct {
+	Open     int    // files which hold descriptor now
+	Released uint64 // descriptors released because of limit
+	Reopened uint64 // descriptors opened again on use of released file
+}
+
+// fdBudget - LRU of files which hold descriptor. nil-safe, nil means "unlimited"
+type fdBudget struct {
+	lock  sync.Mutex
+	limit int
+	lru   *list.List // of fdHandle, front - most recently used
+	elems map[fdHandle]*list.Element
+
+	released, reopened atomic.Uint64
+}
+
+func newFDBudget(limit int) *fdBudget {
+	return &fdBudget{limit: limit, lru: list.New(), elems: map[fdHandle]*list.Element{}}
+}
+
+// touch - files are used: reopen descriptors of them if were released, and release descriptors of least
+// recently used files if limit is exceeded
+func (b *fdBudget) touch(files ...*filesItem) {
+	if b == nil {
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for _, item := range files {
+		if item == nil {
+			continue
+		}
+		if item.decompressor != nil {
+			b.touchHandle(item.decompressor)
+		}
+		if item.index != nil {
+			b.touchHandle(item.index)
+		}
+	}
+	b.evict()
+}
+
+func (b *fdBudget) touchHandle(h fdHandle) {
+	if e, ok := b.elems[h]; ok {
+		b.lru.MoveToFront(e)
+		return
+	}
+	if h.Closed() {
+		return
+	}
+	reopened, err := h.ReopenFile()
+	if err != nil {
+		log.Warn("[snapshots] reopen file", "err", err)
+		return
+	}
+	if reopened {
+		b.reopened.Inc()
+		fdReopenedMetric.Inc()
+	}
+	b.elems[h] = b.lru.PushFront(h)
+}
+
+func (b *fdBudget) evict() {
+	// closed files already released their descriptors
+	for e := b.lru.Front(); e != nil; {
+		next := e.Next()
+		if h := e.Value.(fdHandle); h.Closed() {
+			b.lru.Remove(e)
+			delete(b.elems, h)
+		}
+		e = next
+	}
+	for b.lru.Len() > b.limit {
+		e := b.lru.Back()
+		h := e.Value.(fdHandle)
+		b.lru.Remove(e)
+		delete(b.elems, h)
+		if err := h.ReleaseFile(); err != nil {
+			log.Warn("[snapshots] release file", "err", err)
+		}
+		b.released.Inc()
+		fdReleasedMetric.Inc()
+	}
+	fdOpenMetric.Set(uint64(b.lru.Len()))
+}
+
+func (b *fdBudget) stats() FDStats {
+	if b == nil {
+		return FDStats{}
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return FDStats{Open: b.lru.Len(), Released: b.released.Load(), Reopened: b.reopened.Load()}
+}
+
+func (b *fdBudget) touchFiles(files *btree.BTreeG[*filesItem]) {
+	if b == nil {
+		return
+	}
+	var items []*filesItem
+	files.Ascend(func(item *filesItem) bool {
+		items = append(items, item)
+		return true
+	})
+	b.touch(items...)
+}
+
+// SetFDLimit - max amount of file descriptors held by files of all entities (.kv/.v/.ef and their indices),
+// 0 - unlimited (default).
+func (a *AggregatorV3) SetFDLimit(limit int) {
+	if limit <= 0 {
+		a.fds = nil
+	} else {
+		a.fds = newFDBudget(limit)
+	}
+	if a.accounts == nil { // files are not opened yet
+		return
+	}
+	a.applyFDBudget()
+}
+
+func (a *AggregatorV3) applyFDBudget() {
+	for _, h := range []*History{a.accounts, a.storage, a.code} {
+		h.fds = a.fds
+		a.fds.touchFiles(h.InvertedIndex.files)
+		a.fds.touchFiles(h.files)
+	}
+	for _, ii := range []*InvertedIndex{a.logAddrs, a.logTopics, a.tracesFrom, a.tracesTo} {
+		ii.fds = a.fds
+		a.fds.touchFiles(ii.files)
+	}
+}
+
+// FDStats - descriptors held by files now and amounts of released/reopened ones, see SetFDLimit
+func (a *AggregatorV3) FDStats() FDStats { return a.fds.stats() }
@@ -0,0 +1,233 @@
+/*
+   Copyright 2023 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package state
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ledgerwatch/erigon-lib/common"
+	"github.com/ledgerwatch/erigon-lib/compress"
+)
+
+// ChainIdentity - chain which files belong to. Chain id alone doesn't distinguish devnets and forks - then genesis hash
+// is part of identity.
+type ChainIdentity struct {
+	ChainID uint64
+	Genesis common.Hash
+}
+
+func (c ChainIdentity) IsZero() bool { return c == ChainIdentity{} }
+
+func (c ChainIdentity) String() string {
+	if c.IsZero() {
+		return "none"
+	}
+	return fmt.Sprintf("%d/%x", c.ChainID, c.Genesis)
+}
+
+// encode - as FileMeta.Chain, "" for zero identity
+func (c ChainIdentity) encode() string {
+	if c.IsZero() {
+		return ""
+	}
+	return c.String()
+}
+
+func parseChainIdentity(s string) (c ChainIdentity, err error) {
+	if s == "" {
+		return c, nil
+	}
+	id, genesis, ok := strings.Cut(s, "/")
+	if !ok {
+		return c, fmt.Errorf("chain identity %q: expected chainID/genesis", s)
+	}
+	if c.ChainID, err = strconv.ParseUint(id, 10, 64); err != nil {
+		return c, fmt.Errorf("chain identity %q: %w", s, err)
+	}
+	h, err := hex.DecodeString(genesis)
+	if err != nil || len(h) != len(c.Genesis) {
+		return c, fmt.Errorf("chain identity %q: invalid genesis hash", s)
+	}
+	copy(c.Genesis[:], h)
+	return c, nil
+}
+
+// FileMetaVersion - version of format of .kv/.v/.ef files written by this code
+const FileMetaVersion = 1
+
+// fileFormatFile - manifest of dir of files: FileMetaVersion of files written into dir, no manifest - files are written
+// without FileMeta.
+//
+// Compatibility: FileMeta is a footer of file, versions without FileMeta support read it as part of last word - so
+// files with it must not reach them (seeding, shared dirs). Footer is written only into dirs which opted-in by
+// AggregatorV3.EnableFileMeta. Files without footer are readable by all versions (readers detect footer by magic),
+// so opted-in dir may keep old files.
+const fileFormatFile = "files.format"
+
+func readFileFormat(dir string) (version uint8, err error) {
+	b, err := os.ReadFile(filepath.Join(dir, fileFormatFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.ParseUint(strings.TrimSpace(string(b)), 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", fileFormatFile, err)
+	}
+	if v > FileMetaVersion {
+		return 0, fmt.Errorf("%s: unsupported version %d", fileFormatFile, v)
+	}
+	return uint8(v), nil
+}
+
+// EnableFileMeta - files built after next ReopenFiles carry FileMeta. It's one-way: manifest is written into dir of
+// files, then dir must not be used by versions without FileMeta support. Off by default.
+func (a *AggregatorV3) EnableFileMeta() error {
+	if err := a.checkWritable("EnableFileMeta"); err != nil {
+		return err
+	}
+	f, err := os.Create(filepath.Join(a.dir, fileFormatFile))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err = f.WriteString(strconv.Itoa(FileMetaVersion)); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func (a *AggregatorV3) applyFileMeta() error {
+	version, err := readFileFormat(a.dir)
+	if err != nil {
+		return err
+	}
+	for _, ii := range []*InvertedIndex{a.accounts.InvertedIndex, a.storage.InvertedIndex, a.code.InvertedIndex,
+		a.commitment.InvertedIndex, a.logAddrs, a.logTopics, a.tracesFrom, a.tracesTo} {
+		ii.withFileMeta = version > 0
+	}
+	return nil
+}
+
+// FileMeta - self-describing metadata embedded into .kv/.v/.ef files (as footer, see compress.Compressor.SetMetadata).
+// Files are still identified by name, metadata is checked against name when file is opened: renamed or copied from
+// other chain's datadir files are refused. Files without metadata are accepted. Written only if enabled by manifest
+// of dir, see fileFormatFile.
+type FileMeta struct {
+	Version              uint8
+	Entity               string // filenameBase: accounts, storage, logaddrs, ...
+	Ext                  string // kv, v, ef
+	Chain                string // encoded ChainIdentity, "" - file is not bound to chain
+	StartTxNum, EndTxNum uint64
+}
+
+func (m FileMeta) String() string {
+	return fmt.Sprintf("v%d %s.%s chain=%q txNums=%d-%d", m.Version, m.Entity, m.Ext, m.Chain, m.StartTxNum, m.EndTxNum)
+}
+
+func (m FileMeta) Encode() []byte {
+	buf := make([]byte, 0, 1+3*binary.MaxVarintLen16+len(m.Entity)+len(m.Ext)+len(m.Chain)+2*binary.MaxVarintLen64)
+	var numBuf [binary.MaxVarintLen64]byte
+	putStr := func(s string) {
+		buf = append(buf, numBuf[:binary.PutUvarint(numBuf[:], uint64(len(s)))]...)
+		buf = append(buf, s...)
+	}
+	buf = append(buf, m.Version)
+	putStr(m.Entity)
+	putStr(m.Ext)
+	putStr(m.Chain)
+	buf = append(buf, numBuf[:binary.PutUvarint(numBuf[:], m.StartTxNum)]...)
+	buf = append(buf, numBuf[:binary.PutUvarint(numBuf[:], m.EndTxNum)]...)
+	return buf
+}
+
+func DecodeFileMeta(b []byte) (m FileMeta, err error) {
+	if len(b) < 1 {
+		return m, fmt.Errorf("file metadata: empty")
+	}
+	m.Version, b = b[0], b[1:]
+	if m.Version > FileMetaVersion {
+		return m, fmt.Errorf("file metadata: unsupported version %d", m.Version)
+	}
+	getNum := func() uint64 {
+		if err != nil {
+			return 0
+		}
+		v, n := binary.Uvarint(b)
+		if n <= 0 {
+			err = fmt.Errorf("file metadata: invalid varint")
+			return 0
+		}
+		b = b[n:]
+		return v
+	}
+	getStr := func() string {
+		l := getNum()
+		if err != nil {
+			return ""
+		}
+		if uint64(len(b)) < l {
+			err = fmt.Errorf("file metadata: too short")
+			return ""
+		}
+		s := string(b[:l])
+		b = b[l:]
+		return s
+	}
+	m.Entity, m.Ext, m.Chain = getStr(), getStr(), getStr()
+	m.StartTxNum, m.EndTxNum = getNum(), getNum()
+	return m, err
+}
+
+// fileMeta - metadata of file of `ii` (or of History/Domain which embed it) with extension `ext`, nil if disabled
+func (ii *InvertedIndex) fileMeta(ext string, startTxNum, endTxNum uint64) []byte {
+	if !ii.withFileMeta {
+		return nil
+	}
+	return FileMeta{Version: FileMetaVersion, Entity: ii.filenameBase, Ext: ext, Chain: ii.chain, StartTxNum: startTxNum, EndTxNum: endTxNum}.Encode()
+}
+
+// checkFileMeta - metadata of opened file must match it's name and chain of `ii`
+func (ii *InvertedIndex) checkFileMeta(d *compress.Decompressor, ext string, startTxNum, endTxNum uint64) error {
+	if d.Metadata() == nil {
+		return nil
+	}
+	m, err := DecodeFileMeta(d.Metadata())
+	if err != nil {
+		return newFileCorruptedError(d.FilePath(), err)
+	}
+	if m.Entity != ii.filenameBase || m.Ext != ext || m.StartTxNum != startTxNum || m.EndTxNum != endTxNum {
+		return fmt.Errorf("%w: %s: name doesn't match %s", ErrFileMetaMismatch, d.FileName(), m)
+	}
+	if m.Chain != "" && ii.chain != "" && m.Chain != ii.chain {
+		return fmt.Errorf("%w: %s: chain %q, expected %q", ErrFileMetaMismatch, d.FileName(), m.Chain, ii.chain)
+	}
+	return nil
+}
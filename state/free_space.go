@@ -0,0 +1,101 @@
+/*
+   Copyright 2023 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package state
+
+import (
+	"fmt"
+
+	"github.com/google/btree"
+
+	"github.com/ledgerwatch/erigon-lib/common/dir"
+)
+
+// Build and merge of files write outputs into files dir and ETL/compressor buffers into tmpdir. Running out of
+// space in the middle leaves partial files - so before start required space is estimated from sizes of inputs
+// and operation is refused with LowFreeSpaceError if free space after it would be below watermark.
+
+// LowFreeSpaceError - BuildFiles/MergeLoop didn't start, see SetMinFreeSpace
+type LowFreeSpaceError struct {
+	Op, Dir                    string
+	Free, Estimated, Watermark uint64
+}
+
+func (e *LowFreeSpaceError) Error() string {
+	return fmt.Sprintf("%s: %s: %d bytes free, estimated %d bytes needed plus watermark %d", e.Op, e.Dir, e.Free, e.Estimated, e.Watermark)
+}
+func (e *LowFreeSpaceError) Unwrap() error { return ErrLowFreeSpace }
+
+// SetMinFreeSpace - build/merge of files refuse to start if free space of files dir or tmpdir minus estimated
+// size of outputs is below `watermark`. 0 - check disabled (default).
+func (a *AggregatorV3) SetMinFreeSpace(watermark uint64) { a.minFreeSpace.Store(watermark) }
+
+// checkFreeSpace - `estimated` bytes will be written into files dir and same amount into tmpdir
+func (a *AggregatorV3) checkFreeSpace(op string, estimated uint64) error {
+	watermark := a.minFreeSpace.Load()
+	if watermark == 0 {
+		return nil
+	}
+	freeSpace := a.freeSpace
+	if freeSpace == nil {
+		freeSpace = dir.FreeSpace
+	}
+	for _, path := range []string{a.dir, a.tmpdir} {
+		free, err := freeSpace(path)
+		if err != nil {
+			return fmt.Errorf("%s: free space of %s: %w", op, path, err)
+		}
+		if free < estimated || free-estimated < watermark {
+			return &LowFreeSpaceError{Op: op, Dir: path, Free: free, Estimated: estimated, Watermark: watermark}
+		}
+	}
+	return nil
+}
+
+// estimateBuildSize - files of step are not known before build, so size of files of last built step is used
+func (a *AggregatorV3) estimateBuildSize() uint64 {
+	var size int64
+	last := func(files *btree.BTreeG[*filesItem]) {
+		files.Descend(func(item *filesItem) bool {
+			if item.endTxNum-item.startTxNum != a.aggregationStep {
+				return true
+			}
+			if item.decompressor != nil {
+				size += item.decompressor.Size()
+			}
+			if item.index != nil {
+				size += item.index.Size()
+			}
+			return false
+		})
+	}
+	for _, h := range []*History{a.accounts, a.storage, a.code} {
+		last(h.InvertedIndex.files)
+		last(h.files)
+	}
+	for _, ii := range []*InvertedIndex{a.logAddrs, a.logTopics, a.tracesFrom, a.tracesTo} {
+		last(ii.files)
+	}
+	return uint64(size)
+}
+
+func estimateMergeSize(plans []MergePlan) uint64 {
+	var size int64
+	for _, p := range plans {
+		size += p.EstimatedWrittenBytes
+	}
+	return uint64(size)
+}
@@ -0,0 +1,137 @@
+/*
+   Copyright 2023 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package state
+
+import (
+	"fmt"
+
+	"golang.org/x/exp/slices"
+
+	"github.com/ledgerwatch/erigon-lib/compress"
+)
+
+// Freeze tiers - sizes (in steps) of files which are not merged further, ascending powers of 2. Files grow by
+// binary merges (1+1 -> 2, 2+2 -> 4, ...) up to first tier, then only merges into next tier happen: with tiers
+// [32, 512] 16 files of 32 steps are merged into one file of 512 steps - without files of 64..256 steps, which
+// would be rewritten again. Biggest tier is StepsInBiggestFile of aggregator (LocalityIndex covers files of it).
+// Default - one tier of StepsInBiggestFile.
+
+// SetFreezeTiers - see Freeze tiers. Biggest tier of datadir must not change: .li files are built for it, after
+// change they must be deleted (they are rebuilt by BuildOptionalMissedIndices).
+func (a *AggregatorV3) SetFreezeTiers(tiers ...uint64) error {
+	if len(tiers) == 0 {
+		return fmt.Errorf("SetFreezeTiers: no tiers")
+	}
+	for i, t := range tiers {
+		if t == 0 || t&(t-1) != 0 {
+			return fmt.Errorf("SetFreezeTiers: tier %d is not power of 2", t)
+		}
+		if i > 0 && t <= tiers[i-1] {
+			return fmt.Errorf("SetFreezeTiers: tiers are not ascending: %v", tiers)
+		}
+	}
+	a.freezeTiers = slices.Clone(tiers)
+	if a.accounts == nil { // files are not opened yet
+		return nil
+	}
+	return a.applyFreezeTiers()
+}
+
+// SetStepsInBiggestFile - one freeze tier of `steps`
+func (a *AggregatorV3) SetStepsInBiggestFile(steps uint64) error { return a.SetFreezeTiers(steps) }
+
+// FreezeTiers - see SetFreezeTiers
+func (a *AggregatorV3) FreezeTiers() []uint64 {
+	if len(a.freezeTiers) == 0 {
+		return []uint64{StepsInBiggestFile}
+	}
+	return slices.Clone(a.freezeTiers)
+}
+
+func (a *AggregatorV3) stepsInBiggestFile() uint64 {
+	if len(a.freezeTiers) == 0 {
+		return StepsInBiggestFile
+	}
+	return a.freezeTiers[len(a.freezeTiers)-1]
+}
+
+func (a *AggregatorV3) applyFreezeTiers() error {
+	for _, ii := range []*InvertedIndex{a.accounts.InvertedIndex, a.storage.InvertedIndex, a.code.InvertedIndex, a.logAddrs, a.logTopics, a.tracesFrom, a.tracesTo} {
+		ii.freezeTiers = a.freezeTiers
+		if err := ii.localityIndex.setStepsInBiggestFile(a.stepsInBiggestFile()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetOnFrozenFiles - `f` receives names (without directory) of data files (.v, .ef) of freeze tiers size after they
+// became visible - built or merged. Such files are final (or merged only into bigger tier) - can be distributed, see
+// downloader.Downloader.AddNewSeedableFiles. Called by goroutine which built files: must not block for long.
+func (a *AggregatorV3) SetOnFrozenFiles(f func(names []string)) { a.onFrozenFiles = f }
+
+func (a *AggregatorV3) isFrozen(fromTxNum, toTxNum uint64) bool {
+	return slices.Contains(a.FreezeTiers(), (toTxNum-fromTxNum)/a.aggregationStep)
+}
+
+func (a *AggregatorV3) notifyFrozen(files ...*compress.Decompressor) {
+	names := make([]string, 0, len(files))
+	for _, f := range files {
+		if f != nil {
+			names = append(names, f.FileName())
+		}
+	}
+	if len(names) > 0 {
+		a.onFrozenFiles(names)
+	}
+}
+
+// mergeSpan - size (in steps) of biggest merge which ends at `endStep`: binary merges up to first of `tiers`, then
+// only merges into bigger tiers
+func mergeSpan(endStep uint64, tiers []uint64) uint64 {
+	span := endStep & -endStep // rightmost bit of endStep - size of maximally possible merge ending at endStep
+	if len(tiers) == 0 || span <= tiers[0] {
+		return span
+	}
+	for i := len(tiers) - 1; i >= 0; i-- {
+		if tiers[i] <= span {
+			return tiers[i]
+		}
+	}
+	return span
+}
+
+// setStepsInBiggestFile - files of LocalityIndex are reopened: their layout depends on it
+func (li *LocalityIndex) setStepsInBiggestFile(steps uint64) error {
+	if li == nil || li.stepsInBiggestFile() == steps {
+		return nil
+	}
+	li.biggestFileSteps = steps
+	if li.file == nil {
+		return nil
+	}
+	li.closeFiles()
+	li.file.index, li.bm, li.groups = nil, nil, nil
+	return li.openFiles()
+}
+
+func (li *LocalityIndex) stepsInBiggestFile() uint64 {
+	if li == nil || li.biggestFileSteps == 0 {
+		return StepsInBiggestFile
+	}
+	return li.biggestFileSteps
+}
@@ -0,0 +1,119 @@
+/*
+   Copyright 2023 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package state
+
+import (
+	"io/fs"
+	"os"
+
+	"github.com/ledgerwatch/erigon-lib/common/dir"
+	"github.com/ledgerwatch/erigon-lib/compress"
+	"github.com/ledgerwatch/erigon-lib/kv/bitmapdb"
+	"github.com/ledgerwatch/erigon-lib/recsplit"
+)
+
+// FS - read side of file system of state files (.v, .vi, .ef, .efi, .kv, .kvi, locality files): scan, open and delete.
+// Writes are not routed through it: new files are built (and staged, renamed) in local dir by `os` directly - FS sees
+// them by next ReadDir/Open. Set by AggregatorV3.SetFS, standalone NewDomain/NewHistory/NewInvertedIndex use OsFS.
+//
+// Readers mmap files, so file must have local copy before open: `Open` returns path of it (implementation may fetch file
+// from other storage first) and `Close` is called after unmap. ReadDir lists all files available for Open, Exists
+// reports only files which have local copy.
+type FS interface {
+	ReadDir(dir string) ([]fs.DirEntry, error)
+	// Exists - file has local copy
+	Exists(path string) bool
+	// Open - prepares file for reading and returns path of its local copy. Error is fs.ErrNotExist if file is unavailable
+	Open(path string) (localPath string, err error)
+	// Close - local copy of file opened by Open is not used (mmap-ed) anymore
+	Close(path string)
+	Remove(path string) error
+}
+
+// OsFS - local file system, default FS
+type OsFS struct{}
+
+func (OsFS) ReadDir(dir string) ([]fs.DirEntry, error) { return os.ReadDir(dir) }
+func (OsFS) Exists(path string) bool                   { return dir.FileExist(path) }
+func (OsFS) Close(path string)                         {}
+func (OsFS) Remove(path string) error                  { return os.Remove(path) }
+
+func (OsFS) Open(path string) (string, error) {
+	if !dir.FileExist(path) {
+		return "", &fs.PathError{Op: "open", Path: path, Err: fs.ErrNotExist}
+	}
+	return path, nil
+}
+
+func openDecompressor(fsys FS, path string) (*compress.Decompressor, error) {
+	localPath, err := fsys.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	d, err := compress.NewDecompressor(localPath)
+	if err != nil {
+		fsys.Close(path)
+		return nil, newFileCorruptedError(path, err)
+	}
+	return d, nil
+}
+
+func openIndex(fsys FS, path string) (*recsplit.Index, error) {
+	localPath, err := fsys.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	idx, err := recsplit.OpenIndex(localPath)
+	if err != nil {
+		fsys.Close(path)
+		return nil, newFileCorruptedError(path, err)
+	}
+	return idx, nil
+}
+
+func openBitmaps(fsys FS, path string, bitsPerBitmap int) (*bitmapdb.FixedSizeBitmaps, error) {
+	localPath, err := fsys.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	bm, err := bitmapdb.OpenFixedSizeBitmaps(localPath, bitsPerBitmap)
+	if err != nil {
+		fsys.Close(path)
+		return nil, err
+	}
+	return bm, nil
+}
+
+// SetFS - FS of files scanned, opened and deleted after next ReopenFiles. Files built by aggregator are written to
+// local dir regardless of it
+func (a *AggregatorV3) SetFS(fsys FS) { a.fs = fsys }
+
+// fsys - FS of files, OsFS if not set
+func (ii *InvertedIndex) fsys() FS {
+	if ii.fs == nil {
+		return OsFS{}
+	}
+	return ii.fs
+}
+
+// fsys - FS of files, OsFS if not set
+func (li *LocalityIndex) fsys() FS {
+	if li.fs == nil {
+		return OsFS{}
+	}
+	return li.fs
+}
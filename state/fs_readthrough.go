@@ -0,0 +1,325 @@
+/*
+   Copyright 2023 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package state
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ledgerwatch/erigon-lib/common/dir"
+	"github.com/ledgerwatch/log/v3"
+	"golang.org/x/sync/singleflight"
+)
+
+// ObjectStore - remote storage of frozen state files (S3-like). Files are addressed by name (without directory).
+type ObjectStore interface {
+	List(ctx context.Context) ([]ObjectInfo, error)
+	Get(ctx context.Context, name string, w io.Writer) error
+}
+
+type ObjectInfo struct {
+	Name string
+	Size int64
+}
+
+// ReadThroughFS - FS of "thin archive" node: cold frozen files live in ObjectStore, fetched to local dir on first open
+// and cached there. Fetched files are evicted in LRU order when total size of cache > budget. Files created locally
+// (by collate/merge) are never evicted.
+//
+// Budget counts fetched file until it's removed from disk: file opened (mmap-ed) by readers is not evicted until
+// `Close`. History/InvertedIndex open all files on start - so if all open files don't fit budget, cache stays bigger
+// than budget, and budget limits disk usage of files which are not open anymore (merged, replaced, closed aggregators).
+//
+// Fetch of file doesn't block other files: concurrent Open of same file wait for one fetch. List of ObjectStore
+// is refreshed by ReadDir and by Open of file which was not in store during last List.
+type ReadThroughFS struct {
+	ctx    context.Context
+	store  ObjectStore
+	budget int64 // bytes
+
+	fetching singleflight.Group // by file name; "" - List
+
+	lock     sync.Mutex
+	remote   map[string]ObjectInfo      // name -> info, as of last List
+	listedAt time.Time                  // of last List
+	cached   map[string]*readThroughObj // fetched files in local dir
+	cacheSz  int64
+	clock    uint64
+}
+
+// readThroughListInterval - Open of unknown file doesn't List store more often
+const readThroughListInterval = 30 * time.Second
+
+type readThroughObj struct {
+	path     string
+	size     int64
+	lastUsed uint64
+	refs     int // amount of Open without Close
+}
+
+func NewReadThroughFS(ctx context.Context, store ObjectStore, budgetBytes int64) *ReadThroughFS {
+	return &ReadThroughFS{ctx: ctx, store: store, budget: budgetBytes, cached: map[string]*readThroughObj{}}
+}
+
+// list - lists ObjectStore, concurrent calls wait for one List
+func (r *ReadThroughFS) list() (map[string]ObjectInfo, error) {
+	res, err, _ := r.fetching.Do("", func() (any, error) {
+		objs, err := r.store.List(r.ctx)
+		if err != nil {
+			return nil, fmt.Errorf("ReadThroughFS: list: %w", err)
+		}
+		remote := make(map[string]ObjectInfo, len(objs))
+		for _, o := range objs {
+			remote[o.Name] = o
+		}
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		r.remote, r.listedAt = remote, time.Now()
+		return remote, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.(map[string]ObjectInfo), nil
+}
+
+// ReadDir - files of local dir and files of ObjectStore. Local files which exist in store are adopted as cached.
+func (r *ReadThroughFS) ReadDir(dirPath string) ([]fs.DirEntry, error) {
+	remote, err := r.list()
+	if err != nil {
+		return nil, err
+	}
+	local, err := os.ReadDir(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	seen := make(map[string]struct{}, len(local))
+	res := make([]fs.DirEntry, 0, len(local)+len(remote))
+	for _, e := range local {
+		seen[e.Name()] = struct{}{}
+		res = append(res, e)
+		if o, ok := remote[e.Name()]; ok && e.Type().IsRegular() {
+			r.adopt(filepath.Join(dirPath, e.Name()), o.Size)
+		}
+	}
+	for name, o := range remote {
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		res = append(res, objectEntry{o})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name() < res[j].Name() })
+	return res, nil
+}
+
+// Exists - file has local copy. Files available only in ObjectStore are listed by ReadDir
+func (r *ReadThroughFS) Exists(path string) bool { return dir.FileExist(path) }
+
+// Open - fetches file from ObjectStore if it has no local copy. File can't be evicted until Close
+func (r *ReadThroughFS) Open(path string) (string, error) {
+	name := filepath.Base(path)
+	r.lock.Lock()
+	if dir.FileExist(path) {
+		if o, ok := r.remote[name]; ok {
+			r.adopt(path, o.Size).refs++
+		}
+		r.lock.Unlock()
+		return path, nil
+	}
+	o, ok := r.remote[name]
+	relist := !ok && time.Since(r.listedAt) > readThroughListInterval
+	r.lock.Unlock()
+
+	if relist {
+		remote, err := r.list()
+		if err != nil {
+			return "", err
+		}
+		o, ok = remote[name]
+	}
+	if !ok {
+		return "", &fs.PathError{Op: "open", Path: path, Err: fs.ErrNotExist}
+	}
+	if _, err, _ := r.fetching.Do(name, func() (any, error) { return nil, r.fetch(path, name) }); err != nil {
+		return "", err
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.adopt(path, o.Size).refs++
+	r.evict()
+	return path, nil
+}
+
+// Close - file can be evicted
+func (r *ReadThroughFS) Close(path string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	obj, ok := r.cached[filepath.Base(path)]
+	if !ok || obj.refs == 0 {
+		return
+	}
+	obj.refs--
+	r.evict()
+}
+
+func (r *ReadThroughFS) fetch(path, name string) error {
+	if dir.FileExist(path) { // fetched by previous Open
+		return nil
+	}
+	t := time.Now()
+	tmpPath := path + ".fetch.tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+	if err = r.store.Get(r.ctx, name, f); err != nil {
+		f.Close()
+		return fmt.Errorf("ReadThroughFS: fetch %s: %w", name, err)
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	log.Debug("[ReadThroughFS] fetched", "file", name, "took", time.Since(t))
+	return nil
+}
+
+// Remove - removes local copy only: ObjectStore is read-only for node
+func (r *ReadThroughFS) Remove(path string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	name := filepath.Base(path)
+	if obj, ok := r.cached[name]; ok {
+		r.cacheSz -= obj.size
+		delete(r.cached, name)
+	}
+	if _, ok := r.remote[name]; ok && !dir.FileExist(path) {
+		return nil
+	}
+	return os.Remove(path)
+}
+
+// CachedBytes - total size of files fetched from ObjectStore and still stored locally
+func (r *ReadThroughFS) CachedBytes() int64 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.cacheSz
+}
+
+// adopt - local copy of file of ObjectStore is cached, marks it as recently used
+func (r *ReadThroughFS) adopt(path string, size int64) *readThroughObj {
+	name := filepath.Base(path)
+	r.clock++
+	if obj, ok := r.cached[name]; ok {
+		obj.lastUsed = r.clock
+		return obj
+	}
+	obj := &readThroughObj{path: path, size: size, lastUsed: r.clock}
+	r.cached[name] = obj
+	r.cacheSz += size
+	return obj
+}
+
+// evict - removes least recently used fetched files which are not open until cache fits budget
+func (r *ReadThroughFS) evict() {
+	for r.cacheSz > r.budget {
+		var victim string
+		var minUsed uint64
+		for name, obj := range r.cached {
+			if obj.refs > 0 {
+				continue
+			}
+			if victim == "" || obj.lastUsed < minUsed {
+				victim, minUsed = name, obj.lastUsed
+			}
+		}
+		if victim == "" {
+			return
+		}
+		obj := r.cached[victim]
+		if err := os.Remove(obj.path); err != nil && !os.IsNotExist(err) {
+			log.Warn("[ReadThroughFS] evict", "file", victim, "err", err)
+		}
+		r.cacheSz -= obj.size
+		delete(r.cached, victim)
+	}
+}
+
+type objectEntry struct{ o ObjectInfo }
+
+func (e objectEntry) Name() string               { return e.o.Name }
+func (e objectEntry) IsDir() bool                { return false }
+func (e objectEntry) Type() fs.FileMode          { return 0 }
+func (e objectEntry) Info() (fs.FileInfo, error) { return objectFileInfo(e), nil }
+
+type objectFileInfo struct{ o ObjectInfo }
+
+func (i objectFileInfo) Name() string       { return i.o.Name }
+func (i objectFileInfo) Size() int64        { return i.o.Size }
+func (i objectFileInfo) Mode() fs.FileMode  { return 0444 }
+func (i objectFileInfo) ModTime() time.Time { return time.Time{} }
+func (i objectFileInfo) IsDir() bool        { return false }
+func (i objectFileInfo) Sys() any           { return nil }
+
+// DirObjectStore - ObjectStore backed by directory (network mount, tests)
+type DirObjectStore string
+
+func (d DirObjectStore) List(ctx context.Context) ([]ObjectInfo, error) {
+	entries, err := os.ReadDir(string(d))
+	if err != nil {
+		return nil, err
+	}
+	res := make([]ObjectInfo, 0, len(entries))
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, ObjectInfo{Name: e.Name(), Size: info.Size()})
+	}
+	return res, nil
+}
+
+func (d DirObjectStore) Get(ctx context.Context, name string, w io.Writer) error {
+	f, err := os.Open(filepath.Join(string(d), name))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
@@ -21,12 +21,13 @@ import (
 	"container/heap"
 	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io/fs"
 	"math"
-	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"sync"
 	"time"
@@ -35,13 +36,12 @@ import (
 	"github.com/google/btree"
 	"github.com/ledgerwatch/erigon-lib/common/dbg"
 	"github.com/ledgerwatch/log/v3"
+	"go.uber.org/atomic"
 	"golang.org/x/exp/slices"
 	"golang.org/x/sync/errgroup"
 	"golang.org/x/sync/semaphore"
 
 	"github.com/ledgerwatch/erigon-lib/common"
-	"github.com/ledgerwatch/erigon-lib/common/cmp"
-	"github.com/ledgerwatch/erigon-lib/common/dir"
 	"github.com/ledgerwatch/erigon-lib/compress"
 	"github.com/ledgerwatch/erigon-lib/etl"
 	"github.com/ledgerwatch/erigon-lib/kv"
@@ -62,8 +62,13 @@ type History struct {
 	workers          int
 	compressVals     bool
 
-	wal     *historyWAL
-	walLock sync.RWMutex
+	wal           *historyWAL
+	walLock       sync.RWMutex
+	readPending   atomic.Bool        // see SetReadPending
+	indexOnly     atomic.Bool        // see SetIndexOnly
+	readSources   readSourceCounters // see ReadSourceStats
+	autoIncrement atomic.Uint64      // id of last value in historyValsTable
+	discarded     discardedRanges    // see DiscardHistoryValues
 }
 
 func NewHistory(
@@ -76,6 +81,22 @@ func NewHistory(
 	settingsTable string,
 	compressVals bool,
 	integrityFileExtensions []string,
+) (*History, error) {
+	return newHistory(OsFS{}, "", dir, tmpdir, aggregationStep, filenameBase, indexKeysTable, indexTable, historyValsTable, settingsTable, compressVals, integrityFileExtensions)
+}
+
+func newHistory(
+	fsys FS,
+	chain string,
+	dir, tmpdir string,
+	aggregationStep uint64,
+	filenameBase string,
+	indexKeysTable string,
+	indexTable string,
+	historyValsTable string,
+	settingsTable string,
+	compressVals bool,
+	integrityFileExtensions []string,
 ) (*History, error) {
 	h := History{
 		files:            btree.NewG[*filesItem](32, filesItemLess),
@@ -85,11 +106,11 @@ func NewHistory(
 		workers:          1,
 	}
 	var err error
-	h.InvertedIndex, err = NewInvertedIndex(dir, tmpdir, aggregationStep, filenameBase, indexKeysTable, indexTable, true, append(integrityFileExtensions, "v"))
+	h.InvertedIndex, err = newInvertedIndex(fsys, chain, dir, tmpdir, aggregationStep, filenameBase, indexKeysTable, indexTable, true, append(integrityFileExtensions, "v"))
 	if err != nil {
 		return nil, fmt.Errorf("NewHistory: %s, %w", filenameBase, err)
 	}
-	files, err := os.ReadDir(dir)
+	files, err := h.fsys().ReadDir(dir)
 	if err != nil {
 		return nil, err
 	}
@@ -104,6 +125,10 @@ func NewHistory(
 func (h *History) scanStateFiles(files []fs.DirEntry, integrityFileExtensions []string) (uselessFiles []string) {
 	re := regexp.MustCompile("^" + h.filenameBase + ".([0-9]+)-([0-9]+).v$")
 	var err error
+	listed := make(map[string]struct{}, len(files)) // ReadDir of FS lists files available for Open, not only local
+	for _, f := range files {
+		listed[f.Name()] = struct{}{}
+	}
 Loop:
 	for _, f := range files {
 		if !f.Type().IsRegular() {
@@ -136,7 +161,7 @@ Loop:
 
 		for _, ext := range integrityFileExtensions {
 			requiredFile := fmt.Sprintf("%s.%d-%d.%s", h.filenameBase, startStep, endStep, ext)
-			if !dir.FileExist(filepath.Join(h.dir, requiredFile)) {
+			if _, ok := listed[requiredFile]; !ok {
 				log.Debug(fmt.Sprintf("[snapshots] skip %s because %s doesn't exists. %s", name, requiredFile, dbg.Stack()))
 				continue Loop
 			}
@@ -180,26 +205,31 @@ func (h *History) openFiles() error {
 
 	invalidFileItems := make([]*filesItem, 0)
 	h.files.Ascend(func(item *filesItem) bool {
-		if item.decompressor != nil {
-			item.decompressor.Close()
-		}
+		item.closeDecompressor()
+		item.fsys = h.fsys()
 		fromStep, toStep := item.startTxNum/h.aggregationStep, item.endTxNum/h.aggregationStep
 		datPath := filepath.Join(h.dir, fmt.Sprintf("%s.%d-%d.v", h.filenameBase, fromStep, toStep))
-		if !dir.FileExist(datPath) {
-			invalidFileItems = append(invalidFileItems, item)
-			return true
-		}
-		if item.decompressor, err = compress.NewDecompressor(datPath); err != nil {
+		if item.decompressor, err = openDecompressor(h.fsys(), datPath); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				invalidFileItems = append(invalidFileItems, item)
+				err = nil
+				return true
+			}
 			log.Debug("Hisrory.openFiles: %w, %s", err, datPath)
 			return false
 		}
+		if err = h.checkFileMeta(item.decompressor, "v", item.startTxNum, item.endTxNum); err != nil {
+			return false
+		}
 		if item.index == nil {
 			idxPath := filepath.Join(h.dir, fmt.Sprintf("%s.%d-%d.vi", h.filenameBase, fromStep, toStep))
-			if dir.FileExist(idxPath) {
-				if item.index, err = recsplit.OpenIndex(idxPath); err != nil {
+			if item.index, err = openIndex(h.fsys(), idxPath); err != nil {
+				if !errors.Is(err, fs.ErrNotExist) {
 					log.Debug(fmt.Errorf("Hisrory.openFiles: %w, %s", err, idxPath).Error())
 					return false
 				}
+				err = nil
+			} else {
 				totalKeys += item.index.KeyCount()
 			}
 		}
@@ -211,18 +241,13 @@ func (h *History) openFiles() error {
 	for _, item := range invalidFileItems {
 		h.files.Delete(item)
 	}
-
+	h.fds.touchFiles(h.files)
 	return nil
 }
 
 func (h *History) closeFiles() {
 	h.files.Ascend(func(item *filesItem) bool {
-		if item.decompressor != nil {
-			item.decompressor.Close()
-		}
-		if item.index != nil {
-			item.index.Close()
-		}
+		item.closeFiles()
 		return true
 	})
 }
@@ -247,7 +272,7 @@ func (h *History) Files() (res []string) {
 func (h *History) missedIdxFiles() (l []*filesItem) {
 	h.files.Ascend(func(item *filesItem) bool { // don't run slow logic while iterating on btree
 		fromStep, toStep := item.startTxNum/h.aggregationStep, item.endTxNum/h.aggregationStep
-		if !dir.FileExist(filepath.Join(h.dir, fmt.Sprintf("%s.%d-%d.vi", h.filenameBase, fromStep, toStep))) {
+		if item.index == nil && !h.fsys().Exists(filepath.Join(h.dir, fmt.Sprintf("%s.%d-%d.vi", h.filenameBase, fromStep, toStep))) {
 			l = append(l, item)
 		}
 		return true
@@ -354,13 +379,14 @@ func buildVi(historyItem, iiItem *filesItem, historyIdxPath, tmpdir string, coun
 	_, fName := filepath.Split(historyIdxPath)
 	log.Debug("[snapshots] build idx", "file", fName)
 	rs, err := recsplit.NewRecSplit(recsplit.RecSplitArgs{
-		KeyCount:    count,
-		Enums:       false,
-		BucketSize:  2000,
-		LeafSize:    8,
-		TmpDir:      tmpdir,
-		IndexFile:   historyIdxPath,
-		EtlBufLimit: etl.BufferOptimalSize / 2,
+		KeyCount:     count,
+		Enums:        false,
+		BucketSize:   2000,
+		LeafSize:     8,
+		TmpDir:       tmpdir,
+		IndexFile:    historyIdxPath,
+		EtlBufLimit:  etl.BufferOptimalSize / 2,
+		Fingerprints: dbg.IndexFingerprints(),
 	})
 	if err != nil {
 		return fmt.Errorf("create recsplit: %w", err)
@@ -415,23 +441,163 @@ func buildVi(historyItem, iiItem *filesItem, historyIdxPath, tmpdir string, coun
 
 func (h *History) AddPrevValue(key1, key2, original []byte) (err error) {
 	h.walLock.RLock() // read-lock for reading fielw `w` and writing into it, write-lock for setting new `w`
-	err = h.wal.addPrevValue(key1, key2, original)
+	historyKey, err := h.wal.addPrevValue(key1, key2, original)
 	h.walLock.RUnlock()
-	return err
+	if err != nil || historyKey == nil {
+		return err
+	}
+	return h.InvertedIndex.add(historyKey, historyKey[:len(key1)+len(key2)])
 }
 
 func (h *History) DiscardHistory(tmpdir string) {
 	h.InvertedIndex.StartWrites(tmpdir)
 	h.walLock.Lock()
 	defer h.walLock.Unlock()
+	h.loadAutoIncrement()
 	h.wal = h.newWriter(tmpdir, false, true)
 }
+
+// DiscardHistoryValues - keeps inverted index (which txNums changed which keys - enough for filters and
+// `IterateChanged`), but drops previous values. Ranges of such txNums are persisted in settingsTable on Flush,
+// reads of values of them return ErrHistoryValuesDiscarded.
+func (h *History) DiscardHistoryValues(tmpdir string) {
+	h.InvertedIndex.StartWrites(tmpdir)
+	h.walLock.Lock()
+	defer h.walLock.Unlock()
+	h.loadAutoIncrement()
+	h.wal = h.newWriter(tmpdir, true, false)
+	h.wal.discardVals = true
+}
+
+// ErrHistoryIndexOnly - value was requested from range of History which has only inverted index files, see SetIndexOnly
+var ErrHistoryIndexOnly = errors.New("history has no values files (index-only)")
+
+// ErrHistoryValuesDiscarded - value was requested of txNum written in DiscardHistoryValues mode
+var ErrHistoryValuesDiscarded = errors.New("history values were discarded")
+
+var historyDiscardedKey = []byte("DiscardedTxNums")
+
+// discardedRanges - sorted not overlapping [from, to) ranges of txNums written in DiscardHistoryValues mode.
+// Stored in settingsTable as pairs of big-endian uint64, loaded by first read or write which has tx.
+type discardedRanges struct {
+	lock   sync.RWMutex
+	loaded bool
+	ranges [][2]uint64
+}
+
+func (d *discardedRanges) load(tx kv.Getter, table string) error {
+	d.lock.RLock()
+	loaded := d.loaded
+	d.lock.RUnlock()
+	if loaded {
+		return nil
+	}
+	v, err := tx.GetOne(table, historyDiscardedKey)
+	if err != nil {
+		return err
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.loaded {
+		return nil
+	}
+	d.ranges = d.ranges[:0]
+	for ; len(v) >= 16; v = v[16:] {
+		d.ranges = append(d.ranges, [2]uint64{binary.BigEndian.Uint64(v), binary.BigEndian.Uint64(v[8:])})
+	}
+	d.loaded = true
+	return nil
+}
+
+// add - adds [from, to) and writes all ranges to settingsTable
+func (d *discardedRanges) add(tx kv.RwTx, table string, from, to uint64) error {
+	if err := d.load(tx, table); err != nil {
+		return err
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	ranges := append(d.ranges, [2]uint64{from, to})
+	slices.SortFunc(ranges, func(a, b [2]uint64) bool { return a[0] < b[0] })
+	merged := ranges[:1]
+	for _, r := range ranges[1:] {
+		if last := &merged[len(merged)-1]; r[0] <= last[1] {
+			if r[1] > last[1] {
+				last[1] = r[1]
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	d.ranges = merged
+	v := make([]byte, 16*len(merged))
+	for i, r := range merged {
+		binary.BigEndian.PutUint64(v[16*i:], r[0])
+		binary.BigEndian.PutUint64(v[16*i+8:], r[1])
+	}
+	return tx.Put(table, historyDiscardedKey, v)
+}
+
+func (d *discardedRanges) has(txNum uint64) bool {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	i := sort.Search(len(d.ranges), func(i int) bool { return d.ranges[i][1] > txNum })
+	return i < len(d.ranges) && d.ranges[i][0] <= txNum
+}
+
+// checkDiscarded - value of `key` changed at `txNum` was discarded. `tx` may be nil - then only already loaded
+// ranges are checked
+func (hc *HistoryContext) checkDiscarded(key []byte, txNum uint64, tx kv.Tx) error {
+	if tx != nil {
+		if err := hc.h.discarded.load(tx, hc.h.settingsTable); err != nil {
+			return err
+		}
+	}
+	if hc.h.discarded.has(txNum) {
+		return fmt.Errorf("%w: key=%x, txNum=%d, %s", ErrHistoryValuesDiscarded, key, txNum, hc.h.filenameBase)
+	}
+	return nil
+}
+
+// SetIndexOnly - build mode of light history nodes: new files of History are only inverted index (.ef/.efi - when key
+// was changed) without values (.v/.vi - what it was). Already built .v files are still readable, but not merged anymore.
+// Reads of values in index-only ranges of files return ErrHistoryIndexOnly, `IterateChanged` returns nil values.
+// Files of index-only ranges (.ef without .v) are opened only in this mode: must be called before MakeContext.
+func (h *History) SetIndexOnly(v bool) error {
+	h.indexOnly.Store(v)
+	if !v {
+		return nil
+	}
+	if err := h.InvertedIndex.reopenFolder(nil); err != nil {
+		return fmt.Errorf("SetIndexOnly: %s, %w", h.filenameBase, err)
+	}
+	return nil
+}
+func (h *History) IndexOnly() bool { return h.indexOnly.Load() }
+
 func (h *History) StartWrites(tmpdir string) {
 	h.InvertedIndex.StartWrites(tmpdir)
 	h.walLock.Lock()
 	defer h.walLock.Unlock()
+	h.loadAutoIncrement()
 	h.wal = h.newWriter(tmpdir, true, false)
 }
+
+// loadAutoIncrement - last allocated id of value in historyValsTable. Counter shared by all writers of this History
+func (h *History) loadAutoIncrement() {
+	val, err := h.tx.GetOne(h.settingsTable, historyValCountKey)
+	if err != nil {
+		panic(err)
+		//return err
+	}
+	var valNum uint64
+	if len(val) > 0 {
+		valNum = binary.BigEndian.Uint64(val)
+	}
+	h.autoIncrement.Store(valNum)
+	if err = h.discarded.load(h.tx, h.settingsTable); err != nil {
+		panic(err)
+	}
+}
 func (h *History) FinishWrites() {
 	h.InvertedIndex.FinishWrites()
 	h.walLock.Lock()
@@ -445,7 +611,7 @@ func (h *History) Rotate() historyFlusher {
 	defer h.walLock.Unlock()
 	w := h.wal
 	h.wal = h.newWriter(h.wal.tmpdir, h.wal.buffered, h.wal.discard)
-	h.wal.autoIncrement = w.autoIncrement
+	h.wal.discardVals = w.discardVals
 	return historyFlusher{w, h.InvertedIndex.Rotate()}
 }
 
@@ -454,6 +620,9 @@ type historyFlusher struct {
 	i *invertedIndexWAL
 }
 
+// entity - history writes values and index together: index of history has writes
+func (f historyFlusher) entity() (name string, written bool) { return f.i.entity() }
+
 func (f historyFlusher) Flush(ctx context.Context, tx kv.RwTx) error {
 	if err := f.i.Flush(ctx, tx); err != nil {
 		return err
@@ -470,15 +639,21 @@ type historyWAL struct {
 	tmpdir           string
 	autoIncrementBuf []byte
 	historyKey       []byte
-	autoIncrement    uint64
 	buffered         bool
 	discard          bool
+	discardVals      bool   // see DiscardHistoryValues
+	discardedFrom    uint64 // [discardedFrom, discardedTo) - txNums of discarded values, persisted by flush
+	discardedTo      uint64
+	pending          *pendingWrites // see History.SetReadPending
+	bytes            uint64         // accounted in h.InvertedIndex.walBytes
 }
 
 func (h *historyWAL) close() {
 	if h == nil { // allow dobule-close
 		return
 	}
+	h.h.InvertedIndex.walBytesSub(h.bytes)
+	h.bytes = 0
 	if h.historyVals != nil {
 		h.historyVals.Close()
 	}
@@ -493,21 +668,12 @@ func (h *History) newWriter(tmpdir string, buffered, discard bool) *historyWAL {
 		autoIncrementBuf: make([]byte, 8),
 		historyKey:       make([]byte, 0, 128),
 	}
-	if buffered {
-		w.historyVals = etl.NewCollector(h.historyValsTable, tmpdir, etl.NewSortableBuffer(WALCollectorRam))
-		w.historyVals.LogLvl(log.LvlTrace)
-	}
-
-	val, err := h.tx.GetOne(h.settingsTable, historyValCountKey)
-	if err != nil {
-		panic(err)
-		//return err
+	if h.readPending.Load() {
+		w.pending = newPendingWrites()
 	}
-	var valNum uint64
-	if len(val) > 0 {
-		valNum = binary.BigEndian.Uint64(val)
+	if buffered {
+		w.historyVals = h.newWALCollector(h.historyValsTable, tmpdir)
 	}
-	w.autoIncrement = valNum
 	return w
 }
 
@@ -515,20 +681,43 @@ func (h *historyWAL) flush(ctx context.Context, tx kv.RwTx) error {
 	if h.discard {
 		return nil
 	}
-	binary.BigEndian.PutUint64(h.autoIncrementBuf, h.autoIncrement)
+	// all ids allocated before this flush are <= current value of counter
+	binary.BigEndian.PutUint64(h.autoIncrementBuf, h.h.autoIncrement.Load())
 	if err := tx.Put(h.h.settingsTable, historyValCountKey, h.autoIncrementBuf); err != nil {
 		return err
 	}
 	if err := h.historyVals.Load(tx, h.h.historyValsTable, loadFunc, etl.TransformArgs{Quit: ctx.Done()}); err != nil {
 		return err
 	}
+	if h.discardedTo > h.discardedFrom {
+		if err := h.h.discarded.add(tx, h.h.settingsTable, h.discardedFrom, h.discardedTo); err != nil {
+			return err
+		}
+	}
 	h.close()
 	return nil
 }
 
-func (h *historyWAL) addPrevValue(key1, key2, original []byte) error {
+// addPrevValue - writes value and returns key1+key2+valueID which caller must add to inverted index.
+// nil - if nothing to add
+func (h *historyWAL) addPrevValue(key1, key2, original []byte) ([]byte, error) {
 	if h.discard {
-		return nil
+		return nil, nil
+	}
+	lk := len(key1) + len(key2)
+	historyKey := h.historyKey[:lk+8]
+	copy(historyKey, key1)
+	if len(key2) > 0 {
+		copy(historyKey[len(key1):], key2)
+	}
+	if h.discardVals {
+		original = nil
+		if h.discardedTo == h.discardedFrom || h.h.txNum < h.discardedFrom {
+			h.discardedFrom = h.h.txNum
+		}
+		if h.h.txNum >= h.discardedTo {
+			h.discardedTo = h.h.txNum + 1
+		}
 	}
 
 	/*
@@ -558,84 +747,62 @@ func (h *historyWAL) addPrevValue(key1, key2, original []byte) error {
 		}
 	*/
 
-	lk := len(key1) + len(key2)
-	historyKey := h.historyKey[:lk+8]
-	copy(historyKey, key1)
-	if len(key2) > 0 {
-		copy(historyKey[len(key1):], key2)
+	if h.pending != nil {
+		h.pending.add(historyKey[:lk], h.h.txNum, original)
 	}
+
 	if len(original) > 0 {
-		h.autoIncrement++
-		binary.BigEndian.PutUint64(historyKey[lk:], h.autoIncrement)
+		binary.BigEndian.PutUint64(historyKey[lk:], h.h.autoIncrement.Inc())
 		//if err := h.h.tx.Put(h.h.settingsTable, historyValCountKey, historyKey[lk:]); err != nil {
 		//	return err
 		//}
 
 		if h.buffered {
 			if err := h.historyVals.Collect(historyKey[lk:], original); err != nil {
-				return err
+				return nil, err
 			}
+			n := uint64(8 + len(original))
+			h.bytes += n
+			h.h.InvertedIndex.walBytesAdd(n)
 		} else {
 			if err := h.h.tx.Put(h.h.historyValsTable, historyKey[lk:], original); err != nil {
-				return err
+				return nil, err
 			}
 		}
 	} else {
 		binary.BigEndian.PutUint64(historyKey[lk:], 0)
 	}
-
-	if err := h.h.InvertedIndex.add(historyKey, historyKey[:lk]); err != nil {
-		return err
-	}
-	return nil
+	return historyKey, nil
 }
 
 type HistoryCollation struct {
-	historyComp  *compress.Compressor
 	indexBitmaps map[string]*roaring64.Bitmap
 	historyPath  string
 	historyCount int
 }
 
 func (c HistoryCollation) Close() {
-	if c.historyComp != nil {
-		c.historyComp.Close()
-	}
 	for _, b := range c.indexBitmaps {
 		bitmapdb.ReturnToPool64(b)
 	}
 }
 
-func (h *History) collate(step, txFrom, txTo uint64, roTx kv.Tx, logEvery *time.Ticker) (HistoryCollation, error) {
-	var historyComp *compress.Compressor
+func (h *History) collate(ctx context.Context, step, txFrom, txTo uint64, roTx kv.Tx, logEvery *time.Ticker) (HistoryCollation, error) {
 	var err error
-	closeComp := true
-	defer func() {
-		if closeComp {
-			if historyComp != nil {
-				historyComp.Close()
-			}
-		}
-	}()
-	historyPath := filepath.Join(h.dir, fmt.Sprintf("%s.%d-%d.v", h.filenameBase, step, step+1))
-	if historyComp, err = compress.NewCompressor(context.Background(), "collate history", historyPath, h.tmpdir, compress.MinPatternScore, h.workers, log.LvlTrace); err != nil {
-		return HistoryCollation{}, fmt.Errorf("create %s history compressor: %w", h.filenameBase, err)
-	}
-	keysCursor, err := roTx.CursorDupSort(h.indexKeysTable)
-	if err != nil {
-		return HistoryCollation{}, fmt.Errorf("create %s history cursor: %w", h.filenameBase, err)
-	}
-	defer keysCursor.Close()
+	indexOnly := h.indexOnly.Load()
 	indexBitmaps := map[string]*roaring64.Bitmap{}
 	var txKey [8]byte
 	binary.BigEndian.PutUint64(txKey[:], txFrom)
-	var val []byte
-	var k, v []byte
-	for k, v, err = keysCursor.Seek(txKey[:]); err == nil && k != nil; k, v, err = keysCursor.Next() {
+	lanes := h.lanes.scan()
+	if err = roTx.ForEachCtx(ctx, h.indexKeysTable, txKey[:], func(k, v []byte) error {
 		txNum := binary.BigEndian.Uint64(k)
 		if txNum >= txTo {
-			break
+			return errCollateDone
 		}
+		if err := lanes.yield(ctx); err != nil {
+			return err
+		}
+		h.buildIO.dbRead.Add(uint64(len(k) + len(v)))
 		var bitmap *roaring64.Bitmap
 		var ok bool
 		if bitmap, ok = indexBitmaps[string(v[:len(v)-8])]; !ok {
@@ -649,47 +816,69 @@ func (h *History) collate(step, txFrom, txTo uint64, roTx kv.Tx, logEvery *time.
 			bitmap.RunOptimize()
 		default:
 		}
+		return nil
+	}); err != nil && !errors.Is(err, errCollateDone) {
+		return HistoryCollation{}, fmt.Errorf("iterate over %s history keys: %w", h.filenameBase, err)
 	}
-	if err != nil {
-		return HistoryCollation{}, fmt.Errorf("iterate over %s history cursor: %w", h.filenameBase, err)
+	if indexOnly { // values are not collated: buildFiles builds only .ef/.efi
+		return HistoryCollation{indexBitmaps: indexBitmaps}, nil
 	}
 	keys := make([]string, 0, len(indexBitmaps))
 	for key := range indexBitmaps {
 		keys = append(keys, key)
 	}
 	slices.Sort(keys)
-	historyCount := 0
-	for _, key := range keys {
-		bitmap := indexBitmaps[key]
-		it := bitmap.Iterator()
-		for it.HasNext() {
-			txNum := it.Next()
-			binary.BigEndian.PutUint64(txKey[:], txNum)
-			v, err := keysCursor.SeekBothRange(txKey[:], []byte(key))
-			if err != nil {
-				return HistoryCollation{}, err
-			}
-			if !bytes.HasPrefix(v, []byte(key)) {
-				continue
+	historyPath, err := h.stagingPath(fmt.Sprintf("%s.%d-%d.v", h.filenameBase, step, step+1))
+	if err != nil {
+		return HistoryCollation{}, err
+	}
+	// values are read in order of keys - and streamed directly into .v file, without temporary files of Compressor
+	values := func(yield func(val []byte) error) error {
+		keysCursor, err := roTx.CursorDupSort(h.indexKeysTable)
+		if err != nil {
+			return fmt.Errorf("create %s history cursor: %w", h.filenameBase, err)
+		}
+		defer keysCursor.Close()
+		var txKey [8]byte
+		var val []byte
+		for _, key := range keys {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			default:
 			}
-			valNum := binary.BigEndian.Uint64(v[len(v)-8:])
-			if valNum == 0 {
-				val = nil
-			} else {
-				if val, err = roTx.GetOne(h.historyValsTable, v[len(v)-8:]); err != nil {
-					return HistoryCollation{}, fmt.Errorf("get %s history val [%x]=>%d: %w", h.filenameBase, k, valNum, err)
+			it := indexBitmaps[key].Iterator()
+			for it.HasNext() {
+				binary.BigEndian.PutUint64(txKey[:], it.Next())
+				v, err := keysCursor.SeekBothRange(txKey[:], []byte(key))
+				if err != nil {
+					return err
+				}
+				if !bytes.HasPrefix(v, []byte(key)) {
+					continue
+				}
+				valNum := binary.BigEndian.Uint64(v[len(v)-8:])
+				if valNum == 0 {
+					val = nil
+				} else {
+					if val, err = roTx.GetOne(h.historyValsTable, v[len(v)-8:]); err != nil {
+						return fmt.Errorf("get %s history val [%x]=>%d: %w", h.filenameBase, key, valNum, err)
+					}
+				}
+				h.buildIO.dbRead.Add(uint64(len(txKey) + len(v) + len(val)))
+				if err = yield(val); err != nil {
+					return fmt.Errorf("add %s history val [%x]=>[%x]: %w", h.filenameBase, key, val, err)
 				}
 			}
-			if err = historyComp.AddUncompressedWord(val); err != nil {
-				return HistoryCollation{}, fmt.Errorf("add %s history val [%x]=>[%x]: %w", h.filenameBase, k, val, err)
-			}
-			historyCount++
 		}
+		return nil
+	}
+	historyCount, err := compress.WriteUncompressed(historyPath, h.fileMeta("v", step*h.aggregationStep, (step+1)*h.aggregationStep), values)
+	if err != nil {
+		return HistoryCollation{}, fmt.Errorf("write %s history: %w", h.filenameBase, err)
 	}
-	closeComp = false
 	return HistoryCollation{
 		historyPath:  historyPath,
-		historyComp:  historyComp,
 		historyCount: historyCount,
 		indexBitmaps: indexBitmaps,
 	}, nil
@@ -720,7 +909,6 @@ func (sf HistoryFiles) Close() {
 // buildFiles performs potentially resource intensive operations of creating
 // static files and their indices
 func (h *History) buildFiles(ctx context.Context, step uint64, collation HistoryCollation) (HistoryFiles, error) {
-	historyComp := collation.historyComp
 	var historyDecomp, efHistoryDecomp *compress.Decompressor
 	var historyIdx, efHistoryIdx *recsplit.Index
 	var efHistoryComp *compress.Compressor
@@ -728,9 +916,6 @@ func (h *History) buildFiles(ctx context.Context, step uint64, collation History
 	closeComp := true
 	defer func() {
 		if closeComp {
-			if historyComp != nil {
-				historyComp.Close()
-			}
 			if historyDecomp != nil {
 				historyDecomp.Close()
 			}
@@ -751,22 +936,23 @@ func (h *History) buildFiles(ctx context.Context, step uint64, collation History
 			}
 		}
 	}()
-	historyIdxPath := filepath.Join(h.dir, fmt.Sprintf("%s.%d-%d.vi", h.filenameBase, step, step+1))
-	if err := historyComp.Compress(); err != nil {
-		return HistoryFiles{}, fmt.Errorf("compress %s history: %w", h.filenameBase, err)
-	}
-	historyComp.Close()
-	historyComp = nil
-	var err error
-	if historyDecomp, err = compress.NewDecompressor(collation.historyPath); err != nil {
-		return HistoryFiles{}, fmt.Errorf("open %s history decompressor: %w", h.filenameBase, err)
+	indexOnly := collation.historyPath == "" // see SetIndexOnly
+	if !indexOnly {                          // .v file is written by collate
+		var err error
+		if historyDecomp, err = compress.NewDecompressor(collation.historyPath); err != nil {
+			return HistoryFiles{}, fmt.Errorf("open %s history decompressor: %w", h.filenameBase, err)
+		}
 	}
 	// Build history ef
-	efHistoryPath := filepath.Join(h.dir, fmt.Sprintf("%s.%d-%d.ef", h.filenameBase, step, step+1))
+	efHistoryPath, err := h.stagingPath(fmt.Sprintf("%s.%d-%d.ef", h.filenameBase, step, step+1))
+	if err != nil {
+		return HistoryFiles{}, err
+	}
 	efHistoryComp, err = compress.NewCompressor(ctx, "ef history", efHistoryPath, h.tmpdir, compress.MinPatternScore, h.workers, log.LvlTrace)
 	if err != nil {
 		return HistoryFiles{}, fmt.Errorf("create %s ef history compressor: %w", h.filenameBase, err)
 	}
+	efHistoryComp.SetMetadata(h.fileMeta("ef", step*h.aggregationStep, (step+1)*h.aggregationStep))
 	var buf []byte
 	keys := make([]string, 0, len(collation.indexBitmaps))
 	for key := range collation.indexBitmaps {
@@ -789,6 +975,7 @@ func (h *History) buildFiles(ctx context.Context, step uint64, collation History
 		if err = efHistoryComp.AddUncompressedWord(buf); err != nil {
 			return HistoryFiles{}, fmt.Errorf("add %s ef history val: %w", h.filenameBase, err)
 		}
+		h.buildIO.compressed.Add(uint64(len(key) + len(buf)))
 	}
 	if err = efHistoryComp.Compress(); err != nil {
 		return HistoryFiles{}, fmt.Errorf("compress %s ef history: %w", h.filenameBase, err)
@@ -798,17 +985,39 @@ func (h *History) buildFiles(ctx context.Context, step uint64, collation History
 	if efHistoryDecomp, err = compress.NewDecompressor(efHistoryPath); err != nil {
 		return HistoryFiles{}, fmt.Errorf("open %s ef history decompressor: %w", h.filenameBase, err)
 	}
-	efHistoryIdxPath := filepath.Join(h.dir, fmt.Sprintf("%s.%d-%d.efi", h.filenameBase, step, step+1))
+	if h.deferIndices { // .efi and .vi are built by buildDeferredIdx
+		if err = publishFiles(h.dir, []**compress.Decompressor{&efHistoryDecomp, &historyDecomp}, nil); err != nil {
+			return HistoryFiles{}, fmt.Errorf("publish %s files: %w", h.filenameBase, err)
+		}
+		closeComp = false
+		return HistoryFiles{historyDecomp: historyDecomp, efHistoryDecomp: efHistoryDecomp}, nil
+	}
+	efHistoryIdxPath, err := h.stagingPath(fmt.Sprintf("%s.%d-%d.efi", h.filenameBase, step, step+1))
+	if err != nil {
+		return HistoryFiles{}, err
+	}
 	if efHistoryIdx, err = buildIndex(ctx, efHistoryDecomp, efHistoryIdxPath, h.tmpdir, len(keys), false /* values */); err != nil {
 		return HistoryFiles{}, fmt.Errorf("build %s ef history idx: %w", h.filenameBase, err)
 	}
+	if indexOnly {
+		if err = publishFiles(h.dir, []**compress.Decompressor{&efHistoryDecomp}, []**recsplit.Index{&efHistoryIdx}); err != nil {
+			return HistoryFiles{}, fmt.Errorf("publish %s files: %w", h.filenameBase, err)
+		}
+		closeComp = false
+		return HistoryFiles{efHistoryDecomp: efHistoryDecomp, efHistoryIdx: efHistoryIdx}, nil
+	}
+	historyIdxPath, err := h.stagingPath(fmt.Sprintf("%s.%d-%d.vi", h.filenameBase, step, step+1))
+	if err != nil {
+		return HistoryFiles{}, err
+	}
 	if rs, err = recsplit.NewRecSplit(recsplit.RecSplitArgs{
-		KeyCount:   collation.historyCount,
-		Enums:      false,
-		BucketSize: 2000,
-		LeafSize:   8,
-		TmpDir:     h.tmpdir,
-		IndexFile:  historyIdxPath,
+		KeyCount:     collation.historyCount,
+		Enums:        false,
+		BucketSize:   2000,
+		LeafSize:     8,
+		TmpDir:       h.tmpdir,
+		IndexFile:    historyIdxPath,
+		Fingerprints: dbg.IndexFingerprints(),
 	}); err != nil {
 		return HistoryFiles{}, fmt.Errorf("create recsplit: %w", err)
 	}
@@ -849,6 +1058,9 @@ func (h *History) buildFiles(ctx context.Context, step uint64, collation History
 	if historyIdx, err = recsplit.OpenIndex(historyIdxPath); err != nil {
 		return HistoryFiles{}, fmt.Errorf("open idx: %w", err)
 	}
+	if err = publishFiles(h.dir, []**compress.Decompressor{&efHistoryDecomp, &historyDecomp}, []**recsplit.Index{&efHistoryIdx, &historyIdx}); err != nil {
+		return HistoryFiles{}, fmt.Errorf("publish %s files: %w", h.filenameBase, err)
+	}
 	closeComp = false
 	return HistoryFiles{
 		historyDecomp:   historyDecomp,
@@ -863,12 +1075,17 @@ func (h *History) integrateFiles(sf HistoryFiles, txNumFrom, txNumTo uint64) {
 		decomp: sf.efHistoryDecomp,
 		index:  sf.efHistoryIdx,
 	}, txNumFrom, txNumTo)
-	h.files.ReplaceOrInsert(&filesItem{
+	if sf.historyDecomp == nil { // index-only
+		return
+	}
+	item := &filesItem{
 		startTxNum:   txNumFrom,
 		endTxNum:     txNumTo,
 		decompressor: sf.historyDecomp,
 		index:        sf.historyIdx,
-	})
+	}
+	h.files.ReplaceOrInsert(item)
+	h.fds.touch(item)
 }
 
 func (h *History) warmup(ctx context.Context, txFrom, limit uint64, tx kv.Tx) error {
@@ -939,9 +1156,7 @@ func (h *History) prune(ctx context.Context, txFrom, txTo, limit uint64, logEver
 		return nil
 	}
 	txFrom = binary.BigEndian.Uint64(k)
-	if limit != math.MaxUint64 && limit != 0 {
-		txTo = cmp.Min(txTo, txFrom+limit)
-	}
+	txTo = bitmapdb.LimitTo(txFrom, txTo, limit)
 	if txFrom >= txTo {
 		return nil
 	}
@@ -1063,9 +1278,13 @@ type HistoryContext struct {
 
 	lr    *recsplit.IndexReader
 	locBm *bitmapdb.FixedSizeBitmaps
+	warm  *warmLocality
 
 	tx    kv.Tx
 	trace bool
+	stats *readCounters
+
+	filesEndTxNum uint64 // see filesMayHave
 }
 
 func (h *History) MakeContext() *HistoryContext {
@@ -1087,6 +1306,7 @@ func (h *History) MakeContext() *HistoryContext {
 			getter:     item.decompressor.MakeGetter(),
 			reader:     recsplit.NewIndexReader(item.index),
 		})
+		hc.filesEndTxNum = item.endTxNum
 		return true
 	})
 	hc.historyFiles = btree.NewG[ctxItem](32, ctxItemLess)
@@ -1110,13 +1330,29 @@ func (h *History) MakeContext() *HistoryContext {
 	if hc.h.localityIndex != nil {
 		hc.lr = hc.h.localityIndex.NewIdxReader()
 		hc.locBm = hc.h.localityIndex.bm
+		hc.warm = hc.h.localityIndex.warmLocality()
 	}
 
 	return &hc
 }
 func (hc *HistoryContext) SetTx(tx kv.Tx) { hc.tx = tx }
 
+// indexContext - context of underlying InvertedIndex, reads of which accounted as reads of this History
+func (hc *HistoryContext) indexContext() *InvertedIndexContext {
+	ic := hc.h.InvertedIndex.MakeContext()
+	ic.stats = hc.stats
+	return ic
+}
+
 func (hc *HistoryContext) GetNoState(key []byte, txNum uint64) ([]byte, bool, error) {
+	return hc.getNoState(key, txNum, nil)
+}
+
+// getNoState - `src` (if not nil) is filled with location of found value, see GetNoStateDebug
+func (hc *HistoryContext) getNoState(key []byte, txNum uint64, src *ValueSource) ([]byte, bool, error) {
+	if !hc.filesMayHave(txNum) {
+		return nil, false, nil
+	}
 	exactStep1, exactStep2, lastIndexedTxNum, foundExactShard1, foundExactShard2 := hc.h.localityIndex.lookupIdxFiles(hc.lr, hc.locBm, key, txNum)
 
 	//fmt.Printf("GetNoState [%x] %d\n", key, txNum)
@@ -1128,10 +1364,17 @@ func (hc *HistoryContext) GetNoState(key []byte, txNum uint64) ([]byte, bool, er
 		if item.reader.Empty() {
 			return true
 		}
-		offset := item.reader.Lookup(key)
+		if !hc.warm.mayHave(item.startTxNum, item.endTxNum, key) {
+			return true
+		}
+		offset, ok := item.reader.TryLookup(key)
+		if !ok {
+			return true
+		}
 		g := item.getter
 		g.Reset(offset)
 		k, _ := g.NextUncompressed()
+		hc.stats.lookup(k)
 
 		if !bytes.Equal(k, key) {
 			//if bytes.Equal(key, hex.MustDecodeString("009ba32869045058a3f05d6f3dd2abb967e338f6")) {
@@ -1140,6 +1383,7 @@ func (hc *HistoryContext) GetNoState(key []byte, txNum uint64) ([]byte, bool, er
 			return true
 		}
 		eliasVal, _ := g.NextUncompressed()
+		hc.stats.read(eliasVal)
 		ef, _ := eliasfano32.ReadEliasFano(eliasVal)
 		n, ok := ef.Search(txNum)
 		if hc.trace {
@@ -1160,13 +1404,13 @@ func (hc *HistoryContext) GetNoState(key []byte, txNum uint64) ([]byte, bool, er
 	// -- LocaliyIndex opimization --
 	// check up to 2 exact files
 	if foundExactShard1 {
-		exactShard1, ok := hc.indexFiles.Get(ctxItem{startTxNum: exactStep1 * hc.h.aggregationStep, endTxNum: (exactStep1 + StepsInBiggestFile) * hc.h.aggregationStep})
+		exactShard1, ok := hc.indexFiles.Get(ctxItem{startTxNum: exactStep1 * hc.h.aggregationStep, endTxNum: (exactStep1 + hc.h.localityIndex.stepsInBiggestFile()) * hc.h.aggregationStep})
 		if ok {
 			findInFile(exactShard1)
 		}
 	}
 	if !found && foundExactShard2 {
-		exactShard2, ok := hc.indexFiles.Get(ctxItem{startTxNum: exactStep2 * hc.h.aggregationStep, endTxNum: (exactStep2 + StepsInBiggestFile) * hc.h.aggregationStep})
+		exactShard2, ok := hc.indexFiles.Get(ctxItem{startTxNum: exactStep2 * hc.h.aggregationStep, endTxNum: (exactStep2 + hc.h.localityIndex.stepsInBiggestFile()) * hc.h.aggregationStep})
 		if ok {
 			findInFile(exactShard2)
 		}
@@ -1187,19 +1431,34 @@ func (hc *HistoryContext) GetNoState(key []byte, txNum uint64) ([]byte, bool, er
 		search.startTxNum = foundStartTxNum
 		search.endTxNum = foundEndTxNum
 		if historyItem, ok = hc.historyFiles.Get(search); !ok {
-			return nil, false, fmt.Errorf("hist file not found: key=%x, %s.%d-%d", key, hc.h.filenameBase, foundStartTxNum/hc.h.aggregationStep, foundEndTxNum/hc.h.aggregationStep)
+			if hc.h.indexOnly.Load() {
+				return nil, false, fmt.Errorf("%w: key=%x, %s.%d-%d", ErrHistoryIndexOnly, key, hc.h.filenameBase, foundStartTxNum/hc.h.aggregationStep, foundEndTxNum/hc.h.aggregationStep)
+			}
+			return nil, false, fmt.Errorf("%w: hist file not found: key=%x, %s.%d-%d", ErrFileCorrupted, key, hc.h.filenameBase, foundStartTxNum/hc.h.aggregationStep, foundEndTxNum/hc.h.aggregationStep)
 		}
 		var txKey [8]byte
 		binary.BigEndian.PutUint64(txKey[:], foundTxNum)
-		offset := historyItem.reader.Lookup2(txKey[:], key)
+		offset, ok := historyItem.reader.TryLookup2(txKey[:], key)
+		if !ok {
+			return nil, false, fmt.Errorf("%w: no value in hist file: key=%x, txNum=%d, %s.%d-%d", ErrFileCorrupted, key, foundTxNum, hc.h.filenameBase, foundStartTxNum/hc.h.aggregationStep, foundEndTxNum/hc.h.aggregationStep)
+		}
+		if err := hc.checkDiscarded(key, foundTxNum, hc.tx); err != nil {
+			return nil, false, err
+		}
 		//fmt.Printf("offset = %d, txKey=[%x], key=[%x]\n", offset, txKey[:], key)
 		g := historyItem.getter
 		g.Reset(offset)
+		var v []byte
 		if hc.h.compressVals {
-			v, _ := g.Next(nil)
-			return v, true, nil
+			v, _ = g.Next(nil)
+		} else {
+			v, _ = g.NextUncompressed()
+		}
+		hc.stats.read(v)
+		hc.h.readSources.files.Inc()
+		if src != nil {
+			*src = ValueSource{Kind: ValueSourceFile, File: filepath.Base(g.FileName()), Offset: offset, TxNum: foundTxNum}
 		}
-		v, _ := g.NextUncompressed()
 		return v, true, nil
 	}
 	return nil, false, nil
@@ -1210,7 +1469,10 @@ func (hs *HistoryStep) GetNoState(key []byte, txNum uint64) ([]byte, bool, uint6
 	if hs.indexFile.reader.Empty() {
 		return nil, false, txNum
 	}
-	offset := hs.indexFile.reader.Lookup(key)
+	offset, ok := hs.indexFile.reader.TryLookup(key)
+	if !ok {
+		return nil, false, txNum
+	}
 	g := hs.indexFile.getter
 	g.Reset(offset)
 	k, _ := g.NextUncompressed()
@@ -1226,7 +1488,9 @@ func (hs *HistoryStep) GetNoState(key []byte, txNum uint64) ([]byte, bool, uint6
 	}
 	var txKey [8]byte
 	binary.BigEndian.PutUint64(txKey[:], n)
-	offset = hs.historyFile.reader.Lookup2(txKey[:], key)
+	if offset, ok = hs.historyFile.reader.TryLookup2(txKey[:], key); !ok {
+		return nil, false, txNum
+	}
 	//fmt.Printf("offset = %d, txKey=[%x], key=[%x]\n", offset, txKey[:], key)
 	g = hs.historyFile.getter
 	g.Reset(offset)
@@ -1242,7 +1506,10 @@ func (hs *HistoryStep) MaxTxNum(key []byte) (bool, uint64) {
 	if hs.indexFile.reader.Empty() {
 		return false, 0
 	}
-	offset := hs.indexFile.reader.Lookup(key)
+	offset, ok := hs.indexFile.reader.TryLookup(key)
+	if !ok {
+		return false, 0
+	}
 	g := hs.indexFile.getter
 	g.Reset(offset)
 	k, _ := g.NextUncompressed()
@@ -1257,7 +1524,11 @@ func (hs *HistoryStep) MaxTxNum(key []byte) (bool, uint64) {
 // GetNoStateWithRecent searches history for a value of specified key before txNum
 // second return value is true if the value is found in the history (even if it is nil)
 func (hc *HistoryContext) GetNoStateWithRecent(key []byte, txNum uint64, roTx kv.Tx) ([]byte, bool, error) {
-	v, ok, err := hc.GetNoState(key, txNum)
+	return hc.getNoStateWithRecent(key, txNum, roTx, nil)
+}
+
+func (hc *HistoryContext) getNoStateWithRecent(key []byte, txNum uint64, roTx kv.Tx, src *ValueSource) ([]byte, bool, error) {
+	v, ok, err := hc.getNoState(key, txNum, src)
 	if err != nil {
 		return nil, ok, err
 	}
@@ -1269,17 +1540,29 @@ func (hc *HistoryContext) GetNoStateWithRecent(key []byte, txNum uint64, roTx kv
 	if roTx == nil {
 		return nil, false, fmt.Errorf("roTx is nil")
 	}
-	v, ok, err = hc.getNoStateFromDB(key, txNum, roTx)
+	v, ok, err = hc.getNoStateFromDB(key, txNum, roTx, src)
 	if err != nil {
 		return nil, ok, err
 	}
 	if ok {
+		hc.h.readSources.db.Inc()
 		return v, true, nil
 	}
+	if hc.h.readPending.Load() {
+		// not flushed writes are newer than db
+		if v, ok = hc.getNoStateFromPending(key, txNum); ok {
+			hc.h.readSources.pending.Inc()
+			if src != nil {
+				*src = ValueSource{Kind: ValueSourcePending}
+			}
+			return v, true, nil
+		}
+	}
+	hc.h.readSources.notFound.Inc()
 	return nil, false, err
 }
 
-func (hc *HistoryContext) getNoStateFromDB(key []byte, txNum uint64, tx kv.Tx) ([]byte, bool, error) {
+func (hc *HistoryContext) getNoStateFromDB(key []byte, txNum uint64, tx kv.Tx, src *ValueSource) ([]byte, bool, error) {
 	indexCursor, err := tx.CursorDupSort(hc.h.indexTable)
 	if err != nil {
 		return nil, false, err
@@ -1308,7 +1591,13 @@ func (hc *HistoryContext) getNoStateFromDB(key []byte, txNum uint64, tx kv.Tx) (
 		if vn, err = historyKeysCursor.SeekBothRange(foundTxNumVal, key); err != nil {
 			return nil, false, err
 		}
+		if err = hc.checkDiscarded(key, binary.BigEndian.Uint64(foundTxNumVal), tx); err != nil {
+			return nil, false, err
+		}
 		valNum := binary.BigEndian.Uint64(vn[len(vn)-8:])
+		if src != nil {
+			*src = ValueSource{Kind: ValueSourceDB, Table: hc.h.historyValsTable, ValNum: valNum, TxNum: binary.BigEndian.Uint64(foundTxNumVal)}
+		}
 		if valNum == 0 {
 			// This is special valNum == 0, which is empty value
 			return nil, true, nil
@@ -1683,6 +1972,10 @@ func (hi *HistoryIterator1) advanceInFiles() {
 		binary.BigEndian.PutUint64(hi.txnKey[:], n)
 		search := ctxItem{startTxNum: top.startTxNum, endTxNum: top.endTxNum}
 		historyItem, ok := hi.hc.historyFiles.Get(search)
+		if !ok && hi.hc.h.indexOnly.Load() { // only keys are known
+			hi.nextFileVal = nil
+			return
+		}
 		if !ok {
 			panic(fmt.Errorf("no %s file found for [%x]", hi.hc.h.filenameBase, hi.nextFileKey))
 		}
@@ -0,0 +1,445 @@
+/*
+   Copyright 2022 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package state
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/ledgerwatch/erigon-lib/common"
+	"github.com/ledgerwatch/erigon-lib/kv"
+	"github.com/ledgerwatch/erigon-lib/kv/order"
+	"golang.org/x/exp/slices"
+)
+
+// Testing utilities: HistoryHarness applies sequence of writes/flushes/builds/merges/prunes/unwinds to History and
+// to HistoryModel (plain in-memory map) and checks that every read API of History (GetNoStateWithRecent, WalkAsOf,
+// IterateChanged, IterateChangedKeys, IterateRange) returns same as model - wherever data is: in buffers, db or files.
+// Sequences are produced by RandomHistoryOps or decoded from fuzzer input by DecodeHistoryOps.
+
+// HistoryOpKind - kind of HistoryOp
+type HistoryOpKind uint8
+
+const (
+	HistoryOpWrite  HistoryOpKind = iota // write Val to Key, txNum is increased by Delta before write
+	HistoryOpFlush                       // flush buffered writes to db
+	HistoryOpBuild                       // build files of all completed steps
+	HistoryOpMerge                       // merge built files
+	HistoryOpPrune                       // prune from db everything what is in files
+	HistoryOpUnwind                      // unwind Delta txNums back (not further than end of files)
+	HistoryOpCheck                       // compare all reads with model
+	historyOpKinds
+)
+
+func (k HistoryOpKind) String() string {
+	switch k {
+	case HistoryOpWrite:
+		return "write"
+	case HistoryOpFlush:
+		return "flush"
+	case HistoryOpBuild:
+		return "build"
+	case HistoryOpMerge:
+		return "merge"
+	case HistoryOpPrune:
+		return "prune"
+	case HistoryOpUnwind:
+		return "unwind"
+	case HistoryOpCheck:
+		return "check"
+	default:
+		return "unknown"
+	}
+}
+
+type HistoryOp struct {
+	Kind     HistoryOpKind
+	Delta    uint64
+	Key, Val []byte
+}
+
+func (op HistoryOp) String() string {
+	switch op.Kind {
+	case HistoryOpWrite:
+		return fmt.Sprintf("write(+%d, %x=%x)", op.Delta, op.Key, op.Val)
+	case HistoryOpUnwind:
+		return fmt.Sprintf("unwind(-%d)", op.Delta)
+	default:
+		return op.Kind.String()
+	}
+}
+
+// DecodeHistoryOps - turns arbitrary bytes (fuzzer input) into sequence of ops over `keys` distinct keys.
+// Every 2 bytes are one op, most of ops are writes.
+func DecodeHistoryOps(data []byte, keys int, aggStep uint64) []HistoryOp {
+	if keys <= 0 {
+		keys = 1
+	}
+	ops := make([]HistoryOp, 0, len(data)/2)
+	for i := 0; i+1 < len(data); i += 2 {
+		b, arg := data[i], data[i+1]
+		if b < 200 {
+			val := []byte{}
+			if arg%5 != 0 { // some values are empty
+				val = []byte{arg, b}
+			}
+			ops = append(ops, HistoryOp{Kind: HistoryOpWrite, Delta: uint64(b % 3), Key: historyModelKey(int(arg) % keys), Val: val})
+			continue
+		}
+		op := HistoryOp{Kind: HistoryOpFlush + HistoryOpKind(b%uint8(historyOpKinds-HistoryOpFlush))}
+		if op.Kind == HistoryOpUnwind {
+			op.Delta = uint64(arg) % (2 * aggStep)
+		}
+		ops = append(ops, op)
+	}
+	return ops
+}
+
+// RandomHistoryOps - `n` random ops over `keys` distinct keys
+func RandomHistoryOps(rnd *rand.Rand, n, keys int, aggStep uint64) []HistoryOp {
+	data := make([]byte, 2*n)
+	rnd.Read(data)
+	return DecodeHistoryOps(data, keys, aggStep)
+}
+
+func historyModelKey(i int) []byte {
+	var k [4]byte
+	binary.BigEndian.PutUint32(k[:], uint32(i))
+	k[0] = 0x01 // keys of different tests are not mixed up while debugging
+	return k[:]
+}
+
+type historyModelChange struct {
+	txNum uint64
+	prev  []byte
+}
+
+// HistoryModel - reference implementation of History: current values of keys and values before each change.
+// Empty value and absence of value are same.
+type HistoryModel struct {
+	values  map[string][]byte
+	changes map[string][]historyModelChange // ascending by txNum, one per txNum
+}
+
+func NewHistoryModel() *HistoryModel {
+	return &HistoryModel{values: map[string][]byte{}, changes: map[string][]historyModelChange{}}
+}
+
+// Write - sets value of key at txNum, returns previous value. txNum is not less than txNum of previous writes.
+func (m *HistoryModel) Write(key, val []byte, txNum uint64) (prev []byte) {
+	prev = m.values[string(key)]
+	changes := m.changes[string(key)]
+	if len(changes) == 0 || changes[len(changes)-1].txNum < txNum {
+		m.changes[string(key)] = append(changes, historyModelChange{txNum: txNum, prev: prev})
+	}
+	m.values[string(key)] = common.Copy(val)
+	return prev
+}
+
+// Unwind - forgets changes done at txNum and after
+func (m *HistoryModel) Unwind(txNum uint64) {
+	for key, changes := range m.changes {
+		i := sort.Search(len(changes), func(i int) bool { return changes[i].txNum >= txNum })
+		if i == len(changes) {
+			continue
+		}
+		m.values[key] = changes[i].prev
+		if i == 0 {
+			delete(m.changes, key)
+			continue
+		}
+		m.changes[key] = changes[:i]
+	}
+}
+
+// Keys - all ever changed keys, sorted
+func (m *HistoryModel) Keys() [][]byte {
+	keys := make([][]byte, 0, len(m.changes))
+	for key := range m.changes {
+		keys = append(keys, []byte(key))
+	}
+	slices.SortFunc(keys, func(a, b []byte) bool { return bytes.Compare(a, b) < 0 })
+	return keys
+}
+
+func (m *HistoryModel) firstChange(key []byte, txNum uint64) (historyModelChange, bool) {
+	changes := m.changes[string(key)]
+	i := sort.Search(len(changes), func(i int) bool { return changes[i].txNum >= txNum })
+	if i == len(changes) {
+		return historyModelChange{}, false
+	}
+	return changes[i], true
+}
+
+// GetNoState - value of key before txNum, false if key didn't change since txNum (value is current one)
+func (m *HistoryModel) GetNoState(key []byte, txNum uint64) ([]byte, bool) {
+	c, ok := m.firstChange(key, txNum)
+	return c.prev, ok
+}
+
+// WalkAsOf - keys of [from, to) changed since txNum and their values before txNum. nil `to` - no upper bound.
+func (m *HistoryModel) WalkAsOf(txNum uint64, from, to []byte) (keys, vals [][]byte) {
+	for _, key := range m.Keys() {
+		if bytes.Compare(key, from) < 0 || (to != nil && bytes.Compare(key, to) >= 0) {
+			continue
+		}
+		if c, ok := m.firstChange(key, txNum); ok {
+			keys, vals = append(keys, key), append(vals, c.prev)
+		}
+	}
+	return keys, vals
+}
+
+// IterateChanged - keys changed in [startTxNum, endTxNum) and their values before startTxNum
+func (m *HistoryModel) IterateChanged(startTxNum, endTxNum uint64) (keys, vals [][]byte) {
+	for _, key := range m.Keys() {
+		if c, ok := m.firstChange(key, startTxNum); ok && c.txNum < endTxNum {
+			keys, vals = append(keys, key), append(vals, c.prev)
+		}
+	}
+	return keys, vals
+}
+
+// TxNums - txNums of [startTxNum, endTxNum) at which key changed, ascending
+func (m *HistoryModel) TxNums(key []byte, startTxNum, endTxNum uint64) (txNums []uint64) {
+	for _, c := range m.changes[string(key)] {
+		if c.txNum >= startTxNum && c.txNum < endTxNum {
+			txNums = append(txNums, c.txNum)
+		}
+	}
+	return txNums
+}
+
+// HistoryHarness - applies ops to History and HistoryModel, see HistoryHarness.Check. History must be empty.
+// All work is done in one RwTx of db, which is rolled back at the end of test.
+type HistoryHarness struct {
+	tb       testing.TB
+	h        *History
+	tx       kv.RwTx
+	model    *HistoryModel
+	logEvery *time.Ticker
+
+	txNum   uint64
+	txKeys  map[string]struct{} // written at txNum
+	builtTo uint64              // end of files
+	applied []HistoryOp         // for failure messages
+}
+
+func NewHistoryHarness(tb testing.TB, db kv.RwDB, h *History) *HistoryHarness {
+	tb.Helper()
+	tx, err := db.BeginRw(context.Background())
+	if err != nil {
+		tb.Fatal(err)
+	}
+	hh := &HistoryHarness{tb: tb, h: h, tx: tx, model: NewHistoryModel(), logEvery: time.NewTicker(30 * time.Second), txKeys: map[string]struct{}{}}
+	h.SetTx(tx)
+	h.StartWrites("")
+	h.SetTxNum(hh.txNum)
+	tb.Cleanup(func() {
+		hh.logEvery.Stop()
+		h.FinishWrites()
+		tx.Rollback()
+	})
+	return hh
+}
+
+func (hh *HistoryHarness) Model() *HistoryModel { return hh.model }
+
+// RunHistoryOps - applies ops and checks reads after each non-write op and at the end
+func RunHistoryOps(tb testing.TB, db kv.RwDB, h *History, ops []HistoryOp) {
+	tb.Helper()
+	hh := NewHistoryHarness(tb, db, h)
+	for _, op := range ops {
+		hh.Apply(op)
+		if op.Kind != HistoryOpWrite && op.Kind != HistoryOpCheck {
+			hh.Check()
+		}
+	}
+	hh.Check()
+}
+
+func (hh *HistoryHarness) fatalf(format string, args ...interface{}) {
+	hh.tb.Helper()
+	hh.tb.Fatalf("%s\nafter %d ops: %v", fmt.Sprintf(format, args...), len(hh.applied), hh.applied)
+}
+
+func (hh *HistoryHarness) noError(err error) {
+	hh.tb.Helper()
+	if err != nil {
+		hh.fatalf("%v", err)
+	}
+}
+
+func (hh *HistoryHarness) Apply(ops ...HistoryOp) {
+	hh.tb.Helper()
+	ctx := context.Background()
+	for _, op := range ops {
+		hh.applied = append(hh.applied, op)
+		switch op.Kind {
+		case HistoryOpWrite:
+			if _, ok := hh.txKeys[string(op.Key)]; ok && op.Delta == 0 {
+				op.Delta = 1 // History keeps one value of key per txNum
+			}
+			if op.Delta > 0 {
+				hh.txNum += op.Delta
+				hh.h.SetTxNum(hh.txNum)
+				hh.txKeys = map[string]struct{}{}
+			}
+			hh.txKeys[string(op.Key)] = struct{}{}
+			prev := hh.model.Write(op.Key, op.Val, hh.txNum)
+			hh.noError(hh.h.AddPrevValue(op.Key, nil, prev))
+		case HistoryOpFlush:
+			hh.flush(ctx)
+		case HistoryOpBuild:
+			hh.flush(ctx)
+			for step := hh.builtTo / hh.h.aggregationStep; (step+1)*hh.h.aggregationStep <= hh.txNum; step++ {
+				txFrom, txTo := step*hh.h.aggregationStep, (step+1)*hh.h.aggregationStep
+				c, err := hh.h.collate(ctx, step, txFrom, txTo, hh.tx, hh.logEvery)
+				hh.noError(err)
+				sf, err := hh.h.buildFiles(ctx, step, c)
+				hh.noError(err)
+				hh.h.integrateFiles(sf, txFrom, txTo)
+				hh.builtTo = txTo
+			}
+		case HistoryOpMerge:
+			maxSpan := StepsInBiggestFile * hh.h.aggregationStep
+			for r := hh.h.findMergeRange(hh.builtTo, maxSpan); r.any(); r = hh.h.findMergeRange(hh.builtTo, maxSpan) {
+				indexOuts, historyOuts, _ := hh.h.staticFilesInRange(r)
+				indexIn, historyIn, err := hh.h.mergeFiles(ctx, indexOuts, historyOuts, r, 1)
+				hh.noError(err)
+				hh.h.integrateMergedFiles(indexOuts, historyOuts, indexIn, historyIn)
+				hh.noError(hh.h.deleteFiles(indexOuts, historyOuts))
+			}
+		case HistoryOpPrune:
+			hh.flush(ctx)
+			hh.noError(hh.h.prune(ctx, 0, hh.builtTo, math.MaxUint64, hh.logEvery))
+		case HistoryOpUnwind:
+			hh.flush(ctx)
+			unwindTo := hh.builtTo // files are not unwindable
+			if hh.txNum > op.Delta && hh.txNum-op.Delta > unwindTo {
+				unwindTo = hh.txNum - op.Delta
+			}
+			hh.noError(hh.h.pruneF(unwindTo, math.MaxUint64, func(uint64, []byte, []byte) error { return nil }))
+			hh.model.Unwind(unwindTo)
+			hh.txNum = unwindTo
+			hh.h.SetTxNum(hh.txNum)
+			hh.txKeys = map[string]struct{}{}
+		case HistoryOpCheck:
+			hh.Check()
+		default:
+			hh.fatalf("unknown op: %s", op)
+		}
+	}
+}
+
+func (hh *HistoryHarness) flush(ctx context.Context) {
+	hh.tb.Helper()
+	hh.noError(hh.h.Rotate().Flush(ctx, hh.tx))
+}
+
+func equalValues(a, b []byte) bool { return bytes.Equal(a, b) } // nil and empty are same
+
+func (hh *HistoryHarness) equalPairs(label string, keys, vals [][]byte, expectKeys, expectVals [][]byte) {
+	hh.tb.Helper()
+	if len(keys) != len(expectKeys) {
+		hh.fatalf("%s: %d keys %x, expected %d keys %x", label, len(keys), keys, len(expectKeys), expectKeys)
+	}
+	for i := range keys {
+		if !bytes.Equal(keys[i], expectKeys[i]) || !equalValues(vals[i], expectVals[i]) {
+			hh.fatalf("%s: %d-th pair %x=%x, expected %x=%x", label, i, keys[i], vals[i], expectKeys[i], expectVals[i])
+		}
+	}
+}
+
+// Check - flushes buffered writes and compares reads of History with model for all txNums
+func (hh *HistoryHarness) Check() {
+	hh.tb.Helper()
+	hh.flush(context.Background())
+	hc := hh.h.MakeContext()
+	ic := hh.h.InvertedIndex.MakeContext()
+	keys := append(hh.model.Keys(), historyModelKey(math.MaxUint16)) // and never written key
+	endTxNum := hh.txNum + 2
+
+	for txNum := uint64(0); txNum < endTxNum; txNum++ {
+		for _, key := range keys {
+			v, ok, err := hc.GetNoStateWithRecent(key, txNum, hh.tx)
+			hh.noError(err)
+			expect, expectOk := hh.model.GetNoState(key, txNum)
+			if ok != expectOk || (ok && !equalValues(v, expect)) {
+				hh.fatalf("GetNoStateWithRecent(%x, %d) = %x, %t, expected %x, %t", key, txNum, v, ok, expect, expectOk)
+			}
+		}
+	}
+
+	var from, to []byte
+	if len(keys) > 2 {
+		from, to = keys[len(keys)/3], keys[2*len(keys)/3]
+	}
+	step := hh.h.aggregationStep / 2
+	for txNum := uint64(0); txNum < endTxNum; txNum += step {
+		for _, bounds := range [][2][]byte{{nil, nil}, {from, nil}, {from, to}} {
+			it := hc.WalkAsOf(txNum, bounds[0], bounds[1], hh.tx, -1)
+			var gotKeys, gotVals [][]byte
+			for it.HasNext() {
+				k, v, err := it.Next()
+				hh.noError(err)
+				gotKeys, gotVals = append(gotKeys, common.Copy(k)), append(gotVals, common.Copy(v))
+			}
+			it.Close()
+			expectKeys, expectVals := hh.model.WalkAsOf(txNum, bounds[0], bounds[1])
+			hh.equalPairs(fmt.Sprintf("WalkAsOf(%d, %x, %x)", txNum, bounds[0], bounds[1]), gotKeys, gotVals, expectKeys, expectVals)
+		}
+
+		for _, rangeEnd := range []uint64{txNum + step, txNum + hh.h.aggregationStep, endTxNum} {
+			it := hc.IterateChanged(txNum, rangeEnd, hh.tx)
+			var gotKeys, gotVals [][]byte
+			for it.HasNext() {
+				k, v, err := it.Next()
+				hh.noError(err)
+				gotKeys, gotVals = append(gotKeys, common.Copy(k)), append(gotVals, common.Copy(v))
+			}
+			it.Close()
+			expectKeys, expectVals := hh.model.IterateChanged(txNum, rangeEnd)
+			label := fmt.Sprintf("IterateChanged(%d, %d)", txNum, rangeEnd)
+			hh.equalPairs(label, gotKeys, gotVals, expectKeys, expectVals)
+
+			keysIt := ic.IterateChangedKeys(txNum, rangeEnd, hh.tx)
+			gotKeys = gotKeys[:0]
+			for keysIt.HasNext() {
+				gotKeys = append(gotKeys, keysIt.Next(nil))
+			}
+			keysIt.Close()
+			hh.equalPairs("IterateChangedKeys"+label[len("IterateChanged"):], gotKeys, make([][]byte, len(gotKeys)), expectKeys, make([][]byte, len(expectKeys)))
+
+			for _, key := range keys {
+				rangeIt, err := ic.IterateRange(key, int(txNum), int(rangeEnd), order.Asc, -1, hh.tx)
+				hh.noError(err)
+				got := rangeIt.ToArray()
+				if expect := hh.model.TxNums(key, txNum, rangeEnd); !slices.Equal(got, expect) {
+					hh.fatalf("IterateRange(%x, %d, %d) = %d, expected %d", key, txNum, rangeEnd, got, expect)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,38 @@
+//go:build !nofuzz
+
+/*
+   Copyright 2022 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package state
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// go test -trimpath -v -fuzz=FuzzHistoryConsistency -fuzztime=60s ./state
+
+func FuzzHistoryConsistency(f *testing.F) {
+	for seed := int64(1); seed <= 4; seed++ {
+		data := make([]byte, 600)
+		rand.New(rand.NewSource(seed)).Read(data)
+		f.Add(data)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		_, db, h := testDbAndHistory(t)
+		RunHistoryOps(t, db, h, DecodeHistoryOps(data, 16, h.aggregationStep))
+	})
+}
@@ -0,0 +1,83 @@
+/*
+   Copyright 2023 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package state
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/ledgerwatch/erigon-lib/common"
+)
+
+// pendingWrites - in-memory copy of history writes which are not flushed to db yet, see History.SetReadPending
+type pendingWrites struct {
+	lock sync.RWMutex
+	vals map[string][]pendingVal // key1+key2 -> previous values, ascending by txNum
+}
+
+type pendingVal struct {
+	txNum uint64
+	val   []byte
+}
+
+func newPendingWrites() *pendingWrites { return &pendingWrites{vals: map[string][]pendingVal{}} }
+
+func (p *pendingWrites) add(key []byte, txNum uint64, val []byte) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.vals[string(key)] = append(p.vals[string(key)], pendingVal{txNum: txNum, val: common.Copy(val)})
+}
+
+// get - value of `key` before it's first change at or after `txNum`. Same semantic as in history files/db:
+// if key changed several times in one txNum - value before first change.
+func (p *pendingWrites) get(key []byte, txNum uint64) ([]byte, bool) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	vals := p.vals[string(key)]
+	i := sort.Search(len(vals), func(i int) bool { return vals[i].txNum >= txNum })
+	if i == len(vals) {
+		return nil, false
+	}
+	return vals[i].val, true
+}
+
+// SetReadPending - makes writes buffered by StartWrites visible to HistoryContext.GetNoStateWithRecent before Flush -
+// for callers which interleave writes and historical reads. Costs in-memory copy of all buffered values.
+// Takes effect for writes added after this call. Writes of rotated buffer are visible only after their Flush.
+func (h *History) SetReadPending(v bool) {
+	h.walLock.Lock()
+	defer h.walLock.Unlock()
+	h.readPending.Store(v)
+	if h.wal == nil {
+		return
+	}
+	if !v {
+		h.wal.pending = nil
+	} else if h.wal.pending == nil {
+		h.wal.pending = newPendingWrites()
+	}
+}
+
+// getNoStateFromPending - see SetReadPending
+func (hc *HistoryContext) getNoStateFromPending(key []byte, txNum uint64) ([]byte, bool) {
+	hc.h.walLock.RLock()
+	defer hc.h.walLock.RUnlock()
+	if hc.h.wal == nil || hc.h.wal.pending == nil {
+		return nil, false
+	}
+	return hc.h.wal.pending.get(key, txNum)
+}
@@ -27,8 +27,10 @@ import (
 	"time"
 
 	"github.com/google/btree"
+	"github.com/ledgerwatch/erigon-lib/common/dbg"
 	"github.com/ledgerwatch/erigon-lib/kv"
 	"github.com/ledgerwatch/erigon-lib/kv/mdbx"
+	"github.com/ledgerwatch/erigon-lib/kv/order"
 	"github.com/ledgerwatch/erigon-lib/recsplit"
 	"github.com/ledgerwatch/erigon-lib/recsplit/eliasfano32"
 	"github.com/ledgerwatch/log/v3"
@@ -94,7 +96,7 @@ func TestHistoryCollationBuild(t *testing.T) {
 	err = h.Rotate().Flush(ctx, tx)
 	require.NoError(err)
 
-	c, err := h.collate(0, 0, 8, tx, logEvery)
+	c, err := h.collate(ctx, 0, 0, 8, tx, logEvery)
 	require.NoError(err)
 	require.True(strings.HasSuffix(c.historyPath, "hist.0-1.v"))
 	require.Equal(6, c.historyCount)
@@ -193,7 +195,7 @@ func TestHistoryAfterPrune(t *testing.T) {
 	err = h.Rotate().Flush(ctx, tx)
 	require.NoError(t, err)
 
-	c, err := h.collate(0, 0, 16, tx, logEvery)
+	c, err := h.collate(ctx, 0, 0, 16, tx, logEvery)
 	require.NoError(t, err)
 
 	sf, err := h.buildFiles(ctx, 0, c)
@@ -218,6 +220,46 @@ func TestHistoryAfterPrune(t *testing.T) {
 	}
 }
 
+func TestHistoryPruneValues(t *testing.T) {
+	logEvery := time.NewTicker(30 * time.Second)
+	defer logEvery.Stop()
+	ctx := context.Background()
+	test := func(t *testing.T, txNums []uint64, expectLeft []uint64) {
+		t.Helper()
+		_, db, h := testDbAndHistory(t)
+		tx, err := db.BeginRw(ctx)
+		require.NoError(t, err)
+		defer tx.Rollback()
+		h.SetTx(tx)
+		h.StartWrites("")
+		defer h.FinishWrites()
+		for _, txNum := range txNums { // ids of values are allocated in this order
+			h.SetTxNum(txNum)
+			require.NoError(t, h.AddPrevValue([]byte("key1"), nil, []byte(fmt.Sprintf("value%d", txNum))))
+		}
+		require.NoError(t, h.Rotate().Flush(ctx, tx))
+
+		require.NoError(t, h.prune(ctx, 0, 8, math.MaxUint64, logEvery))
+		var left []uint64
+		require.NoError(t, tx.ForEach(h.indexKeysTable, nil, func(k, v []byte) error {
+			val, err := tx.GetOne(h.historyValsTable, v[len(v)-8:])
+			require.NoError(t, err)
+			require.Equal(t, fmt.Sprintf("value%d", binary.BigEndian.Uint64(k)), string(val))
+			left = append(left, binary.BigEndian.Uint64(k))
+			return nil
+		}))
+		require.Equal(t, expectLeft, left)
+		var vals int
+		require.NoError(t, tx.ForEach(h.historyValsTable, nil, func(k, v []byte) error {
+			vals++
+			return nil
+		}))
+		require.Equal(t, len(expectLeft), vals)
+	}
+	t.Run("contiguous", func(t *testing.T) { test(t, []uint64{2, 3, 10, 11}, []uint64{10, 11}) })
+	t.Run("interleaved", func(t *testing.T) { test(t, []uint64{10, 2, 11, 3}, []uint64{10, 11}) })
+}
+
 func filledHistory(tb testing.TB) (string, kv.RwDB, *History, uint64) {
 	tb.Helper()
 	path, db, h := testDbAndHistory(tb)
@@ -302,7 +344,7 @@ func TestHistoryHistory(t *testing.T) {
 	// Leave the last 2 aggregation steps un-collated
 	for step := uint64(0); step < txs/h.aggregationStep-1; step++ {
 		func() {
-			c, err := h.collate(step, step*h.aggregationStep, (step+1)*h.aggregationStep, tx, logEvery)
+			c, err := h.collate(ctx, step, step*h.aggregationStep, (step+1)*h.aggregationStep, tx, logEvery)
 			require.NoError(t, err)
 			sf, err := h.buildFiles(ctx, step, c)
 			require.NoError(t, err)
@@ -326,7 +368,7 @@ func collateAndMergeHistory(tb testing.TB, db kv.RwDB, h *History, txs uint64) {
 	// Leave the last 2 aggregation steps un-collated
 	for step := uint64(0); step < txs/h.aggregationStep-1; step++ {
 		func() {
-			c, err := h.collate(step, step*h.aggregationStep, (step+1)*h.aggregationStep, tx, logEvery)
+			c, err := h.collate(ctx, step, step*h.aggregationStep, (step+1)*h.aggregationStep, tx, logEvery)
 			require.NoError(tb, err)
 			sf, err := h.buildFiles(ctx, step, c)
 			require.NoError(tb, err)
@@ -357,6 +399,51 @@ func TestHistoryMergeFiles(t *testing.T) {
 	checkHistoryHistory(t, db, h, txs)
 }
 
+func TestHistoryIndexBloom(t *testing.T) {
+	prev := dbg.Snapshot()["ERIGON_INDEX_BLOOM_BITS"]
+	t.Cleanup(func() { require.NoError(t, dbg.SetFlag("ERIGON_INDEX_BLOOM_BITS", prev)) })
+	require.NoError(t, dbg.SetFlag("ERIGON_INDEX_BLOOM_BITS", "10"))
+	_, db, h, txs := filledHistory(t)
+
+	collateAndMergeHistory(t, db, h, txs)
+	h.files.Ascend(func(item *filesItem) bool {
+		iiItem, ok := h.InvertedIndex.files.Get(item)
+		require.True(t, ok)
+		require.True(t, iiItem.index.HasBloom(), iiItem.decompressor.FileName())
+		return true
+	})
+	checkHistoryHistory(t, db, h, txs)
+
+	hc := h.MakeContext()
+	absent := []byte{0x01, 0, 0, 0, 0, 0, 0, 100}
+	_, ok, err := hc.GetNoState(absent, 1)
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func TestHistoryIndexFingerprints(t *testing.T) {
+	prev := dbg.Snapshot()["ERIGON_INDEX_FINGERPRINTS"]
+	t.Cleanup(func() { require.NoError(t, dbg.SetFlag("ERIGON_INDEX_FINGERPRINTS", prev)) })
+	require.NoError(t, dbg.SetFlag("ERIGON_INDEX_FINGERPRINTS", "true"))
+	_, db, h, txs := filledHistory(t)
+
+	collateAndMergeHistory(t, db, h, txs)
+	h.files.Ascend(func(item *filesItem) bool {
+		require.True(t, item.index.HasFingerprints(), item.decompressor.FileName())
+		iiItem, ok := h.InvertedIndex.files.Get(item)
+		require.True(t, ok)
+		require.True(t, iiItem.index.HasFingerprints(), iiItem.decompressor.FileName())
+		return true
+	})
+	checkHistoryHistory(t, db, h, txs)
+
+	hc := h.MakeContext()
+	absent := []byte{0x01, 0, 0, 0, 0, 0, 0, 100}
+	_, ok, err := hc.GetNoState(absent, 1)
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
 func TestHistoryScanFiles(t *testing.T) {
 	path, db, h, txs := filledHistory(t)
 	var err error
@@ -611,3 +698,248 @@ func TestScanStaticFilesH(t *testing.T) {
 	require.Equal(t, 0, h.files.Len())
 
 }
+
+func TestHistoryReadPending(t *testing.T) {
+	_, db, h := testDbAndHistory(t)
+	ctx := context.Background()
+	tx, err := db.BeginRw(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback()
+	h.SetTx(tx)
+	h.SetReadPending(true)
+	h.StartWrites("")
+	defer h.FinishWrites()
+
+	h.SetTxNum(2)
+	require.NoError(t, h.AddPrevValue([]byte("key1"), nil, nil))
+	h.SetTxNum(6)
+	require.NoError(t, h.AddPrevValue([]byte("key1"), nil, []byte("value1.1")))
+	require.NoError(t, h.AddPrevValue([]byte("key1"), nil, []byte("value1.2")))
+
+	hc := h.MakeContext()
+	check := func(txNum uint64, expect []byte, expectOk bool) {
+		t.Helper()
+		v, ok, err := hc.GetNoStateWithRecent([]byte("key1"), txNum, tx)
+		require.NoError(t, err)
+		require.Equal(t, expectOk, ok)
+		require.Equal(t, expect, v)
+	}
+	check(1, nil, true)
+	check(3, []byte("value1.1"), true)
+	check(6, []byte("value1.1"), true)
+	check(7, nil, false)
+
+	h.SetReadPending(false)
+	check(3, nil, false)
+
+	h.SetReadPending(true)
+	h.SetTxNum(8)
+	require.NoError(t, h.AddPrevValue([]byte("key1"), nil, []byte("value1.3")))
+	check(7, []byte("value1.3"), true)
+	require.NoError(t, h.Rotate().Flush(ctx, tx))
+	check(3, []byte("value1.1"), true) // from db
+}
+
+func TestHistoryIndexOnly(t *testing.T) {
+	path, db, h, txs := filledHistory(t)
+	require.NoError(t, h.SetIndexOnly(true))
+	collateAndMergeHistory(t, db, h, txs)
+
+	check := func(h *History) {
+		t.Helper()
+		require.Zero(t, h.files.Len())
+		require.NotZero(t, h.InvertedIndex.files.Len())
+		require.Equal(t, h.InvertedIndex.endTxNumMinimax(), h.endTxNumMinimax())
+		for _, f := range h.Files() {
+			require.False(t, strings.HasSuffix(f, ".v"), f)
+		}
+
+		tx, err := db.BeginRo(context.Background())
+		require.NoError(t, err)
+		defer tx.Rollback()
+		hc := h.MakeContext()
+		var k [8]byte
+		binary.BigEndian.PutUint64(k[:], 3)
+		k[0] = 1
+		// what it was - not available
+		_, _, err = hc.GetNoState(k[:], 10)
+		require.ErrorIs(t, err, ErrHistoryIndexOnly)
+		// when it was changed - available
+		it, err := hc.indexContext().IterateRange(k[:], 0, 31, order.Asc, -1, tx)
+		require.NoError(t, err)
+		var txNums []uint64
+		for it.HasNext() {
+			n, err := it.Next()
+			require.NoError(t, err)
+			txNums = append(txNums, n)
+		}
+		require.Equal(t, []uint64{3, 6, 9, 12, 15, 18, 21, 24, 27, 30}, txNums)
+		// which keys were changed - available, without values
+		changed := hc.IterateChanged(2, 20, tx)
+		defer changed.Close()
+		var keys int
+		for changed.HasNext() {
+			k, v, err := changed.Next()
+			require.NoError(t, err)
+			require.Equal(t, uint8(1), k[0])
+			require.Nil(t, v)
+			keys++
+		}
+		require.Equal(t, 19, keys) // keyNum 1..19 changed in [2, 20)
+	}
+	check(h)
+
+	// Recreate history and re-scan the files
+	h.Close()
+	h, err := NewHistory(path, path, h.aggregationStep, h.filenameBase, h.indexKeysTable, h.indexTable, h.historyValsTable, h.settingsTable, h.compressVals, nil)
+	require.NoError(t, err)
+	defer h.Close()
+	require.NoError(t, h.SetIndexOnly(true))
+	check(h)
+}
+
+func TestHistoryReadSources(t *testing.T) {
+	logEvery := time.NewTicker(30 * time.Second)
+	defer logEvery.Stop()
+	_, db, h, txs := filledHistory(t)
+	ctx := context.Background()
+	tx, err := db.BeginRw(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback()
+	h.SetTx(tx)
+
+	// files of first step only, rest is in db
+	c, err := h.collate(ctx, 0, 0, h.aggregationStep, tx, logEvery)
+	require.NoError(t, err)
+	sf, err := h.buildFiles(ctx, 0, c)
+	require.NoError(t, err)
+	h.integrateFiles(sf, 0, h.aggregationStep)
+	require.NoError(t, h.prune(ctx, 0, h.aggregationStep, math.MaxUint64, logEvery))
+
+	hc := h.MakeContext()
+	require.Equal(t, h.aggregationStep, hc.filesEndTxNum)
+	var k [8]byte
+	binary.BigEndian.PutUint64(k[:], 1)
+	k[0] = 1
+	read := func(txNum uint64) {
+		t.Helper()
+		_, ok, err := hc.GetNoStateWithRecent(k[:], txNum, tx)
+		require.NoError(t, err)
+		require.True(t, ok)
+	}
+	read(2)
+	require.Equal(t, ReadSourceStats{Files: 1}, h.ReadSourceStats())
+	read(h.aggregationStep + 1)
+	require.Equal(t, ReadSourceStats{Files: 1, DB: 1, FilesSkipped: 1}, h.ReadSourceStats())
+
+	_, ok, err := hc.GetNoStateWithRecent(k[:], txs+1, tx)
+	require.NoError(t, err)
+	require.False(t, ok)
+	require.Equal(t, ReadSourceStats{Files: 1, DB: 1, FilesSkipped: 2, NotFound: 1}, h.ReadSourceStats())
+}
+
+func TestHistoryGetNoStateDebug(t *testing.T) {
+	logEvery := time.NewTicker(30 * time.Second)
+	defer logEvery.Stop()
+	_, db, h, txs := filledHistory(t)
+	ctx := context.Background()
+	tx, err := db.BeginRw(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback()
+	h.SetTx(tx)
+
+	// files of first step only, rest is in db
+	c, err := h.collate(ctx, 0, 0, h.aggregationStep, tx, logEvery)
+	require.NoError(t, err)
+	sf, err := h.buildFiles(ctx, 0, c)
+	require.NoError(t, err)
+	h.integrateFiles(sf, 0, h.aggregationStep)
+	require.NoError(t, h.prune(ctx, 0, h.aggregationStep, math.MaxUint64, logEvery))
+
+	hc := h.MakeContext()
+	var k [8]byte
+	binary.BigEndian.PutUint64(k[:], 1)
+	k[0] = 1
+	for _, txNum := range []uint64{2, h.aggregationStep + 1} {
+		expect, _, err := hc.GetNoStateWithRecent(k[:], txNum, tx)
+		require.NoError(t, err)
+		v, ok, src, err := hc.GetNoStateDebug(k[:], txNum, tx)
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, expect, v)
+		require.Equal(t, txNum, src.TxNum) // key changes on every txNum
+		if txNum < h.aggregationStep {
+			require.Equal(t, ValueSourceFile, src.Kind)
+			require.Equal(t, "hist.0-1.v", src.File)
+			require.NotZero(t, src.Offset)
+		} else {
+			require.Equal(t, ValueSourceDB, src.Kind)
+			require.Equal(t, h.historyValsTable, src.Table)
+			require.NotZero(t, src.ValNum)
+		}
+	}
+
+	_, ok, src, err := hc.GetNoStateDebug(k[:], txs+1, tx)
+	require.NoError(t, err)
+	require.False(t, ok)
+	require.Equal(t, ValueSource{}, src)
+}
+
+func TestHistoryTimeSeries(t *testing.T) {
+	logEvery := time.NewTicker(30 * time.Second)
+	defer logEvery.Stop()
+	_, db, h, txs := filledHistory(t)
+	ctx := context.Background()
+	tx, err := db.BeginRw(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback()
+	h.SetTx(tx)
+	for step := uint64(0); step < 2; step++ { // part of history in files
+		c, err := h.collate(ctx, step, step*h.aggregationStep, (step+1)*h.aggregationStep, tx, logEvery)
+		require.NoError(t, err)
+		sf, err := h.buildFiles(ctx, step, c)
+		require.NoError(t, err)
+		h.integrateFiles(sf, step*h.aggregationStep, (step+1)*h.aggregationStep)
+		require.NoError(t, h.prune(ctx, step*h.aggregationStep, (step+1)*h.aggregationStep, math.MaxUint64, logEvery))
+	}
+
+	const keyNum = 7 // changes on every 7-th txNum
+	var k [8]byte
+	binary.BigEndian.PutUint64(k[:], keyNum)
+	k[0] = 1
+	hc := h.MakeContext()
+	before := h.ReadSourceStats()
+	points, err := hc.TimeSeries(k[:], 0, txs+10, 3, tx)
+	require.NoError(t, err)
+	after := h.ReadSourceStats()
+
+	require.Len(t, points, int((txs+10+2)/3))
+	lookups := map[uint64]struct{}{}
+	for i, p := range points {
+		end := uint64(i+1) * 3
+		if end > txs+10 {
+			end = txs + 10
+		}
+		require.Equal(t, end, p.EndTxNum)
+		nextChange := (end + keyNum - 1) / keyNum * keyNum
+		if nextChange > txs {
+			require.False(t, p.Found, end)
+			continue
+		}
+		lookups[nextChange] = struct{}{}
+		require.True(t, p.Found, end)
+		if end <= keyNum {
+			require.Equal(t, []byte{}, p.Value, end)
+			continue
+		}
+		var v [8]byte
+		binary.BigEndian.PutUint64(v[:], (end-1)/keyNum)
+		v[0] = 0xff
+		require.Equal(t, v[:], p.Value, end)
+	}
+	// one history read per change, not per bucket
+	require.Equal(t, uint64(len(lookups)), after.Files+after.DB-before.Files-before.DB)
+
+	_, err = hc.TimeSeries(k[:], 0, 10, 0, tx)
+	require.Error(t, err)
+}
@@ -0,0 +1,97 @@
+/*
+   Copyright 2023 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package state
+
+import (
+	"fmt"
+
+	"github.com/ledgerwatch/erigon-lib/common"
+	"github.com/ledgerwatch/erigon-lib/kv"
+	"github.com/ledgerwatch/erigon-lib/kv/order"
+)
+
+// TimeSeriesPoint - value of key at the end of bucket: after all changes of txNum < EndTxNum
+type TimeSeriesPoint struct {
+	EndTxNum uint64
+	Value    []byte
+	// Found - false if key has no changes at or after EndTxNum: value is latest state (not in history),
+	// caller reads it from state
+	Found bool
+}
+
+// TimeSeries - one value per bucket [fromTxNum+i*step, fromTxNum+(i+1)*step) of [fromTxNum, toTxNum), for example
+// balance of account over time. Inverted index tells which buckets have changes of key: bucket without changes has
+// value of previous bucket, then history value is read once per changed bucket - not once per bucket or per change.
+func (hc *HistoryContext) TimeSeries(key []byte, fromTxNum, toTxNum, step uint64, roTx kv.Tx) ([]TimeSeriesPoint, error) {
+	if step == 0 {
+		return nil, fmt.Errorf("TimeSeries: step must be positive")
+	}
+	if fromTxNum >= toTxNum {
+		return nil, nil
+	}
+	res := make([]TimeSeriesPoint, 0, (toTxNum-fromTxNum+step-1)/step)
+	firstEnd := fromTxNum + step
+	if firstEnd > toTxNum {
+		firstEnd = toTxNum
+	}
+	changes, err := hc.indexContext().IterateRange(key, int(firstEnd), -1, order.Asc, -1, roTx)
+	if err != nil {
+		return nil, err
+	}
+	var next uint64 // first change at or after end of current bucket, if !exhausted
+	exhausted, started := false, false
+	seek := func(end uint64) error {
+		for !exhausted && (!started || next < end) {
+			if !changes.HasNext() {
+				exhausted = true
+				return nil
+			}
+			if next, err = changes.Next(); err != nil {
+				return err
+			}
+			started = true
+		}
+		return nil
+	}
+	var last TimeSeriesPoint // last read value - value before change `lastChange`
+	var lastChange uint64
+	hasLast := false
+	for start := fromTxNum; start < toTxNum; start += step {
+		end := start + step
+		if end > toTxNum {
+			end = toTxNum
+		}
+		if err = seek(end); err != nil {
+			return nil, err
+		}
+		p := TimeSeriesPoint{EndTxNum: end}
+		switch {
+		case exhausted: // no changes anymore - latest value
+		case hasLast && lastChange == next: // no changes in this bucket
+			p.Value, p.Found = last.Value, last.Found
+		default:
+			v, ok, err := hc.GetNoStateWithRecent(key, end, roTx)
+			if err != nil {
+				return nil, err
+			}
+			p.Value, p.Found = common.Copy(v), ok
+			last, lastChange, hasLast = p, next, true
+		}
+		res = append(res, p)
+	}
+	return res, nil
+}
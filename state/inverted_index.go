@@ -21,6 +21,7 @@ import (
 	"container/heap"
 	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io/fs"
 	"math"
@@ -34,16 +35,17 @@ import (
 	"github.com/RoaringBitmap/roaring/roaring64"
 	"github.com/c2h5oh/datasize"
 	"github.com/google/btree"
+	"github.com/ledgerwatch/erigon-lib/common/cmp"
 	"github.com/ledgerwatch/erigon-lib/common/dbg"
+	"github.com/ledgerwatch/erigon-lib/common/metrics"
 	"github.com/ledgerwatch/erigon-lib/kv/iter"
 	"github.com/ledgerwatch/erigon-lib/kv/order"
 	"github.com/ledgerwatch/log/v3"
+	"go.uber.org/atomic"
 	"golang.org/x/exp/slices"
 	"golang.org/x/sync/errgroup"
 	"golang.org/x/sync/semaphore"
 
-	"github.com/ledgerwatch/erigon-lib/common/cmp"
-	"github.com/ledgerwatch/erigon-lib/common/dir"
 	"github.com/ledgerwatch/erigon-lib/compress"
 	"github.com/ledgerwatch/erigon-lib/etl"
 	"github.com/ledgerwatch/erigon-lib/kv"
@@ -63,12 +65,28 @@ type InvertedIndex struct {
 	aggregationStep uint64
 	txNum           uint64
 	workers         int
+	collateWorkers  int  // see collateSharded
+	deferIndices    bool // see AggregatorV3.SetDeferIndices
+	buildIO         buildIO
+	freezeTiers     []uint64 // see AggregatorV3.SetFreezeTiers
 	txNumBytes      [8]byte
 
 	localityIndex *LocalityIndex
+	mergeIO       *MergeIO
+	fs            FS
+	fds           *fdBudget  // shared by all entities of aggregator, see AggregatorV3.SetFDLimit
+	lanes         *readLanes // collate yields to interactive reads, see AggregatorV3.SetBackgroundMaxYield
+	chain         string     // written into FileMeta of new files and checked in opened ones, "" - not bound
+	withFileMeta  bool       // new files carry FileMeta, see AggregatorV3.EnableFileMeta
+
+	wal         *invertedIndexWAL
+	walLock     sync.RWMutex
+	walProgress func(table string, p etl.Progress) // see AggregatorV3.SetWALProgress
+
+	batchAdded map[string]struct{} // keys added by AddBatch at current txNum, reset by SetTxNum
 
-	wal     *invertedIndexWAL
-	walLock sync.RWMutex
+	walBytes       atomic.Uint64 // see WriteBufferBytes
+	walBytesMetric *metrics.Counter
 }
 
 func NewInvertedIndex(
@@ -79,8 +97,24 @@ func NewInvertedIndex(
 	indexTable string,
 	withLocalityIndex bool,
 	integrityFileExtensions []string,
+) (*InvertedIndex, error) {
+	return newInvertedIndex(OsFS{}, "", dir, tmpdir, aggregationStep, filenameBase, indexKeysTable, indexTable, withLocalityIndex, integrityFileExtensions)
+}
+
+func newInvertedIndex(
+	fsys FS,
+	chain string,
+	dir, tmpdir string,
+	aggregationStep uint64,
+	filenameBase string,
+	indexKeysTable string,
+	indexTable string,
+	withLocalityIndex bool,
+	integrityFileExtensions []string,
 ) (*InvertedIndex, error) {
 	ii := InvertedIndex{
+		fs:              fsys,
+		chain:           chain,
 		dir:             dir,
 		tmpdir:          tmpdir,
 		files:           btree.NewG[*filesItem](32, filesItemLess),
@@ -89,8 +123,9 @@ func NewInvertedIndex(
 		indexKeysTable:  indexKeysTable,
 		indexTable:      indexTable,
 		workers:         1,
+		walBytesMetric:  walBufferedBytesMetric(filenameBase),
 	}
-	files, err := os.ReadDir(dir)
+	files, err := ii.fsys().ReadDir(dir)
 	if err != nil {
 		return nil, fmt.Errorf("NewInvertedIndex: %s, %w", filenameBase, err)
 	}
@@ -100,10 +135,16 @@ func NewInvertedIndex(
 	}
 
 	if withLocalityIndex {
-		ii.localityIndex, err = NewLocalityIndex(dir, tmpdir, aggregationStep, filenameBase)
+		ii.localityIndex, err = newLocalityIndex(fsys, dir, tmpdir, aggregationStep, filenameBase)
 		if err != nil {
 			return nil, fmt.Errorf("NewHistory: %s, %w", filenameBase, err)
 		}
+		var warmFiles []*filesItem
+		ii.files.Ascend(func(item *filesItem) bool {
+			warmFiles = append(warmFiles, item)
+			return true
+		})
+		ii.localityIndex.warmUpdate(warmFiles, nil)
 	}
 
 	return &ii, nil
@@ -112,6 +153,10 @@ func NewInvertedIndex(
 func (ii *InvertedIndex) scanStateFiles(files []fs.DirEntry, integrityFileExtensions []string) (uselessFiles []string) {
 	re := regexp.MustCompile("^" + ii.filenameBase + ".([0-9]+)-([0-9]+).ef$")
 	var err error
+	listed := make(map[string]struct{}, len(files)) // ReadDir of FS lists files available for Open, not only local
+	for _, f := range files {
+		listed[f.Name()] = struct{}{}
+	}
 Loop:
 	for _, f := range files {
 		if !f.Type().IsRegular() {
@@ -144,7 +189,7 @@ Loop:
 
 		for _, ext := range integrityFileExtensions {
 			requiredFile := fmt.Sprintf("%s.%d-%d.%s", ii.filenameBase, startStep, endStep, ext)
-			if !dir.FileExist(filepath.Join(ii.dir, requiredFile)) {
+			if _, ok := listed[requiredFile]; !ok {
 				log.Debug(fmt.Sprintf("[snapshots] skip %s because %s doesn't exists. %s", name, requiredFile, dbg.Stack()))
 				continue Loop
 			}
@@ -186,7 +231,7 @@ Loop:
 func (ii *InvertedIndex) missedIdxFiles() (l []*filesItem) {
 	ii.files.Ascend(func(item *filesItem) bool { // don't run slow logic while iterating on btree
 		fromStep, toStep := item.startTxNum/ii.aggregationStep, item.endTxNum/ii.aggregationStep
-		if !dir.FileExist(filepath.Join(ii.dir, fmt.Sprintf("%s.%d-%d.efi", ii.filenameBase, fromStep, toStep))) {
+		if item.index == nil && !ii.fsys().Exists(filepath.Join(ii.dir, fmt.Sprintf("%s.%d-%d.efi", ii.filenameBase, fromStep, toStep))) {
 			l = append(l, item)
 		}
 		return true
@@ -227,26 +272,32 @@ func (ii *InvertedIndex) openFiles() error {
 	var totalKeys uint64
 	var invalidFileItems []*filesItem
 	ii.files.Ascend(func(item *filesItem) bool {
-		if item.decompressor != nil {
-			item.decompressor.Close()
-		}
+		item.closeDecompressor()
+		item.fsys = ii.fsys()
 		fromStep, toStep := item.startTxNum/ii.aggregationStep, item.endTxNum/ii.aggregationStep
 		datPath := filepath.Join(ii.dir, fmt.Sprintf("%s.%d-%d.ef", ii.filenameBase, fromStep, toStep))
-		if !dir.FileExist(datPath) {
-			invalidFileItems = append(invalidFileItems, item)
-		}
-		if item.decompressor, err = compress.NewDecompressor(datPath); err != nil {
+		if item.decompressor, err = openDecompressor(ii.fsys(), datPath); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				invalidFileItems = append(invalidFileItems, item)
+				err = nil
+				return true
+			}
 			log.Debug("InvertedIndex.openFiles: %w, %s", err, datPath)
 			return false
 		}
+		if err = ii.checkFileMeta(item.decompressor, "ef", item.startTxNum, item.endTxNum); err != nil {
+			return false
+		}
 
 		if item.index == nil {
 			idxPath := filepath.Join(ii.dir, fmt.Sprintf("%s.%d-%d.efi", ii.filenameBase, fromStep, toStep))
-			if dir.FileExist(idxPath) {
-				if item.index, err = recsplit.OpenIndex(idxPath); err != nil {
+			if item.index, err = openIndex(ii.fsys(), idxPath); err != nil {
+				if !errors.Is(err, fs.ErrNotExist) {
 					log.Debug("InvertedIndex.openFiles: %w, %s", err, idxPath)
 					return false
 				}
+				err = nil
+			} else {
 				totalKeys += item.index.KeyCount()
 			}
 		}
@@ -258,17 +309,38 @@ func (ii *InvertedIndex) openFiles() error {
 	if err != nil {
 		return err
 	}
+	ii.fds.touchFiles(ii.files)
+	return nil
+}
+
+// reopenFolder - closes and re-scans files, for example after change of files required by integrityFileExtensions
+func (ii *InvertedIndex) reopenFolder(integrityFileExtensions []string) error {
+	files, err := ii.fsys().ReadDir(ii.dir)
+	if err != nil {
+		return err
+	}
+	var outs, in []*filesItem
+	ii.files.Ascend(func(item *filesItem) bool {
+		outs = append(outs, item)
+		return true
+	})
+	ii.closeFiles()
+	ii.files = btree.NewG[*filesItem](32, filesItemLess)
+	_ = ii.scanStateFiles(files, integrityFileExtensions)
+	if err = ii.openFiles(); err != nil {
+		return err
+	}
+	ii.files.Ascend(func(item *filesItem) bool {
+		in = append(in, item)
+		return true
+	})
+	ii.localityIndex.warmUpdate(in, outs)
 	return nil
 }
 
 func (ii *InvertedIndex) closeFiles() {
 	ii.files.Ascend(func(item *filesItem) bool {
-		if item.decompressor != nil {
-			item.decompressor.Close()
-		}
-		if item.index != nil {
-			item.index.Close()
-		}
+		item.closeFiles()
 		return true
 	})
 }
@@ -295,11 +367,14 @@ func (ii *InvertedIndex) SetTx(tx kv.RwTx) {
 func (ii *InvertedIndex) SetTxNum(txNum uint64) {
 	ii.txNum = txNum
 	binary.BigEndian.PutUint64(ii.txNumBytes[:], ii.txNum)
+	for k := range ii.batchAdded {
+		delete(ii.batchAdded, k)
+	}
 }
 
 func (ii *InvertedIndex) add(key, indexKey []byte) (err error) {
 	ii.walLock.RLock()
-	err = ii.wal.add(key, indexKey)
+	err = ii.wal.add(ii.txNumBytes[:], key, indexKey)
 	ii.walLock.RUnlock()
 	return err
 }
@@ -308,6 +383,27 @@ func (ii *InvertedIndex) Add(key []byte) error {
 	return ii.add(key, key)
 }
 
+// AddBatch - adds keys at current txNum, each (key, txNum) pair is written once: duplicates inside batch and keys
+// already added by AddBatch at this txNum are skipped. For callers which see same key many times per tx (log addresses
+// and topics of busy contracts). Not thread-safe, as SetTxNum.
+func (ii *InvertedIndex) AddBatch(keys [][]byte) (err error) {
+	if ii.batchAdded == nil {
+		ii.batchAdded = map[string]struct{}{}
+	}
+	ii.walLock.RLock()
+	defer ii.walLock.RUnlock()
+	for _, key := range keys {
+		if _, ok := ii.batchAdded[string(key)]; ok {
+			continue
+		}
+		ii.batchAdded[string(key)] = struct{}{}
+		if err = ii.wal.add(ii.txNumBytes[:], key, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (ii *InvertedIndex) DiscardHistory(tmpdir string) {
 	ii.walLock.Lock()
 	defer ii.walLock.Unlock()
@@ -342,6 +438,8 @@ type invertedIndexWAL struct {
 	tmpdir    string
 	buffered  bool
 	discard   bool
+	bytes     uint64 // accounted in ii.walBytes
+	writes    uint64 // amount of add calls, see entity
 }
 
 // loadFunc - is analog of etl.Identity, but it signaling to etl - use .Put instead of .AppendDup - to allow duplicates
@@ -364,10 +462,17 @@ func (ii *invertedIndexWAL) Flush(ctx context.Context, tx kv.RwTx) error {
 	return nil
 }
 
+// entity - name of index and if it has writes to flush
+func (ii *invertedIndexWAL) entity() (name string, written bool) {
+	return ii.ii.filenameBase, ii.writes > 0
+}
+
 func (ii *invertedIndexWAL) close() {
 	if ii == nil {
 		return
 	}
+	ii.ii.walBytesSub(ii.bytes)
+	ii.bytes = 0
 	if ii.index != nil {
 		ii.index.Close()
 	}
@@ -398,32 +503,46 @@ func (ii *InvertedIndex) newWriter(tmpdir string, buffered, discard bool) *inver
 	}
 	if buffered {
 		// etl collector doesn't fsync: means if have enough ram, all files produced by all collectors will be in ram
-		w.index = etl.NewCollector(ii.indexTable, tmpdir, etl.NewSortableBuffer(WALCollectorRam))
-		w.indexKeys = etl.NewCollector(ii.indexKeysTable, tmpdir, etl.NewSortableBuffer(WALCollectorRam))
-		w.index.LogLvl(log.LvlTrace)
-		w.indexKeys.LogLvl(log.LvlTrace)
+		w.index = ii.newWALCollector(ii.indexTable, tmpdir)
+		w.indexKeys = ii.newWALCollector(ii.indexKeysTable, tmpdir)
 	}
 	return w
 }
 
-func (ii *invertedIndexWAL) add(key, indexKey []byte) error {
+// newWALCollector - buffer of writes into `table`, reports progress of spills and Flush by etl metrics and walProgress
+func (ii *InvertedIndex) newWALCollector(table, tmpdir string) *etl.Collector {
+	c := etl.NewCollector(table, tmpdir, etl.NewSortableBuffer(WALCollectorRam))
+	c.LogLvl(log.LvlTrace)
+	c.SetMetrics(table)
+	if f := ii.walProgress; f != nil {
+		c.SetProgress(func(p etl.Progress) { f(table, p) })
+	}
+	return c
+}
+
+// add - txNumBytes passed by caller: wal can be owned by InvertedIndex (see SetTxNum) or by AggregatorWriter
+func (ii *invertedIndexWAL) add(txNumBytes, key, indexKey []byte) error {
 	if ii.discard {
 		return nil
 	}
+	ii.writes++
 
 	if ii.buffered {
-		if err := ii.indexKeys.Collect(ii.ii.txNumBytes[:], key); err != nil {
+		if err := ii.indexKeys.Collect(txNumBytes, key); err != nil {
 			return err
 		}
 
-		if err := ii.index.Collect(indexKey, ii.ii.txNumBytes[:]); err != nil {
+		if err := ii.index.Collect(indexKey, txNumBytes); err != nil {
 			return err
 		}
+		n := uint64(2*len(txNumBytes) + len(key) + len(indexKey))
+		ii.bytes += n
+		ii.ii.walBytesAdd(n)
 	} else {
-		if err := ii.ii.tx.Put(ii.ii.indexKeysTable, ii.ii.txNumBytes[:], key); err != nil {
+		if err := ii.ii.tx.Put(ii.ii.indexKeysTable, txNumBytes, key); err != nil {
 			return err
 		}
-		if err := ii.ii.tx.Put(ii.ii.indexTable, indexKey, ii.ii.txNumBytes[:]); err != nil {
+		if err := ii.ii.tx.Put(ii.ii.indexTable, indexKey, txNumBytes); err != nil {
 			return err
 		}
 	}
@@ -466,11 +585,13 @@ type InvertedIterator struct {
 	stack      []ctxItem
 
 	nextN                       uint64
+	filesEndTxNum               uint64 // txNums below are read from files - db may still have them if not pruned yet
 	hasNextInDb, hasNextInFiles bool
 	nextErrInDB, nextErrInFile  error
 
-	res []uint64
-	bm  *roaring64.Bitmap
+	res   []uint64
+	bm    *roaring64.Bitmap
+	stats *readCounters
 }
 
 func (it *InvertedIterator) Close() {
@@ -489,12 +610,17 @@ func (it *InvertedIterator) advanceInFiles() {
 			}
 			item := it.stack[len(it.stack)-1]
 			it.stack = it.stack[:len(it.stack)-1]
-			offset := item.reader.Lookup(it.key)
+			offset, ok := item.reader.TryLookup(it.key)
+			if !ok {
+				continue
+			}
 			g := item.getter
 			g.Reset(offset)
 			k, _ := g.NextUncompressed()
+			it.stats.lookup(k)
 			if bytes.Equal(k, it.key) {
 				eliasVal, _ := g.NextUncompressed()
+				it.stats.read(eliasVal)
 				ef, _ := eliasfano32.ReadEliasFano(eliasVal)
 
 				if it.orderAscend {
@@ -528,7 +654,7 @@ func (it *InvertedIterator) advanceInFiles() {
 					it.hasNextInFiles = false
 					return
 				}
-				if it.startTxNum >= 0 && int(n) <= it.startTxNum {
+				if it.startTxNum < 0 || int(n) <= it.startTxNum {
 					it.hasNextInFiles = true
 					it.nextN = n
 					return
@@ -561,13 +687,20 @@ func (it *InvertedIterator) advanceInDb() {
 		if it.startTxNum > 0 {
 			binary.BigEndian.PutUint64(keyBytes[:], uint64(it.startTxNum))
 		}
-		if v, err = it.cursor.SeekBothRange(it.key, keyBytes[:]); err != nil {
+		if !it.orderAscend && it.startTxNum < 0 { // unbounded: from last txNum of key
+			v, err = it.cursor.LastDup()
+		} else {
+			v, err = it.cursor.SeekBothRange(it.key, keyBytes[:])
+		}
+		if err != nil {
 			panic(err)
 		}
 		if v == nil {
-			if !it.orderAscend {
-				_, v, _ = it.cursor.PrevDup()
-				if err != nil {
+			if !it.orderAscend { // all txNums of key are before startTxNum
+				if _, _, err = it.cursor.SeekExact(it.key); err != nil {
+					panic(err)
+				}
+				if v, err = it.cursor.LastDup(); err != nil {
 					panic(err)
 				}
 			}
@@ -604,7 +737,7 @@ func (it *InvertedIterator) advanceInDb() {
 				it.hasNextInDb = false
 				return
 			}
-			if int(n) >= it.startTxNum {
+			if int(n) >= it.startTxNum && n >= it.filesEndTxNum {
 				it.hasNextInDb = true
 				it.nextN = n
 				return
@@ -617,11 +750,11 @@ func (it *InvertedIterator) advanceInDb() {
 				panic(err)
 			}
 			n := binary.BigEndian.Uint64(v)
-			if int(n) <= it.endTxNum {
+			if int(n) <= it.endTxNum || n < it.filesEndTxNum {
 				it.hasNextInDb = false
 				return
 			}
-			if it.startTxNum >= 0 && int(n) <= it.startTxNum {
+			if it.startTxNum < 0 || int(n) <= it.startTxNum {
 				it.hasNextInDb = true
 				it.nextN = n
 				return
@@ -694,6 +827,7 @@ type InvertedIndexContext struct {
 	ii            *InvertedIndex
 	files         *btree.BTreeG[ctxItem]
 	localityIndex *LocalityIndex
+	stats         *readCounters
 }
 
 // IterateRange is to be used in public API, therefore it relies on read-only transaction
@@ -716,9 +850,13 @@ func (ic *InvertedIndexContext) IterateRange(key []byte, startTxNum, endTxNum in
 		hasNextInDb: true,
 		orderAscend: asc,
 		limit:       limit,
+		stats:       ic.stats,
+	}
+	if last, ok := ic.files.Max(); ok {
+		it.filesEndTxNum = last.endTxNum
 	}
-	search := ctxItem{startTxNum: 0, endTxNum: 0}
 	if asc {
+		search := ctxItem{startTxNum: 0, endTxNum: 0}
 		if startTxNum >= 0 {
 			search.endTxNum = uint64(startTxNum)
 		}
@@ -733,11 +871,8 @@ func (ic *InvertedIndexContext) IterateRange(key []byte, startTxNum, endTxNum in
 			return true
 		})
 	} else {
-		if startTxNum >= 0 {
-			search.endTxNum = uint64(startTxNum)
-		}
-		ic.files.AscendLessThan(search, func(item ctxItem) bool {
-			if startTxNum < 0 || int(item.startTxNum) < startTxNum {
+		ic.files.Ascend(func(item ctxItem) bool {
+			if startTxNum < 0 || int(item.startTxNum) <= startTxNum { // file may contain startTxNum
 				it.stack = append(it.stack, item)
 				it.hasNextInFiles = true
 			}
@@ -784,9 +919,7 @@ func (it *InvertedIterator1) advanceInFiles() {
 		}
 		if !bytes.Equal(key, it.key) {
 			ef, _ := eliasfano32.ReadEliasFano(val)
-			min := ef.Get(0)
-			max := ef.Max()
-			if min < it.endTxNum && max >= it.startTxNum { // Intersection of [min; max) and [it.startTxNum; it.endTxNum)
+			if n, ok := ef.Search(it.startTxNum); ok && n < it.endTxNum { // some txNum is in [it.startTxNum; it.endTxNum)
 				it.key = key
 				it.nextFileKey = key
 				return
@@ -886,6 +1019,7 @@ func (ic *InvertedIndexContext) IterateChangedKeys(startTxNum, endTxNum uint64,
 			return false
 		}
 		g := item.getter
+		g.Reset(0)
 		if g.HasNext() {
 			key, _ := g.NextUncompressed()
 			heap.Push(&ii1.h, &ReconItem{startTxNum: item.startTxNum, endTxNum: item.endTxNum, g: g, txNum: ^item.endTxNum, key: key})
@@ -902,21 +1036,23 @@ func (ic *InvertedIndexContext) IterateChangedKeys(startTxNum, endTxNum uint64,
 	return ii1
 }
 
+// errCollateDone - stops walk over keys table in collate: range of txNums is over
+var errCollateDone = errors.New("collate: range is done")
+
 func (ii *InvertedIndex) collate(ctx context.Context, txFrom, txTo uint64, roTx kv.Tx, logEvery *time.Ticker) (map[string]*roaring64.Bitmap, error) {
-	keysCursor, err := roTx.CursorDupSort(ii.indexKeysTable)
-	if err != nil {
-		return nil, fmt.Errorf("create %s keys cursor: %w", ii.filenameBase, err)
-	}
-	defer keysCursor.Close()
 	indexBitmaps := map[string]*roaring64.Bitmap{}
 	var txKey [8]byte
 	binary.BigEndian.PutUint64(txKey[:], txFrom)
-	var k, v []byte
-	for k, v, err = keysCursor.Seek(txKey[:]); err == nil && k != nil; k, v, err = keysCursor.Next() {
+	lanes := ii.lanes.scan()
+	if err := roTx.ForEachCtx(ctx, ii.indexKeysTable, txKey[:], func(k, v []byte) error {
 		txNum := binary.BigEndian.Uint64(k)
 		if txNum >= txTo {
-			break
+			return errCollateDone
+		}
+		if err := lanes.yield(ctx); err != nil {
+			return err
 		}
+		ii.buildIO.dbRead.Add(uint64(len(k) + len(v)))
 		var bitmap *roaring64.Bitmap
 		var ok bool
 		if bitmap, ok = indexBitmaps[string(v)]; !ok {
@@ -929,14 +1065,63 @@ func (ii *InvertedIndex) collate(ctx context.Context, txFrom, txTo uint64, roTx
 		case <-logEvery.C:
 			log.Info("[snapshots] collate history", "name", ii.filenameBase, "range", fmt.Sprintf("%.2f-%.2f", float64(txNum)/float64(ii.aggregationStep), float64(txTo)/float64(ii.aggregationStep)))
 			bitmap.RunOptimize()
-		case <-ctx.Done():
-			err := ctx.Err()
-			return nil, err
 		default:
 		}
+		return nil
+	}); err != nil && !errors.Is(err, errCollateDone) {
+		return nil, fmt.Errorf("iterate over %s keys: %w", ii.filenameBase, err)
 	}
-	if err != nil {
-		return nil, fmt.Errorf("iterate over %s keys cursor: %w", ii.filenameBase, err)
+	return indexBitmaps, nil
+}
+
+// collateSharded - same as collate, but [txFrom, txTo) is split between collateWorkers, each walks own shard
+// of indexKeysTable by own read tx. Table is keyed by txNum - so shards are txNum ranges of equal size, and
+// bitmaps of shards are merged at the end. Data of step doesn't change while step is built, so shards are
+// consistent even if they see different views of db.
+func (ii *InvertedIndex) collateSharded(ctx context.Context, txFrom, txTo uint64, db kv.RoDB, logEvery *time.Ticker) (map[string]*roaring64.Bitmap, error) {
+	workers := ii.collateWorkers
+	if span := txTo - txFrom; uint64(workers) > span {
+		workers = int(span)
+	}
+	if workers <= 1 {
+		var indexBitmaps map[string]*roaring64.Bitmap
+		if err := db.View(ctx, func(tx kv.Tx) (err error) {
+			indexBitmaps, err = ii.collate(ctx, txFrom, txTo, tx, logEvery)
+			return err
+		}); err != nil {
+			return nil, err
+		}
+		return indexBitmaps, nil
+	}
+
+	shards := make([]map[string]*roaring64.Bitmap, workers)
+	shardSize := (txTo - txFrom + uint64(workers) - 1) / uint64(workers)
+	g, gCtx := errgroup.WithContext(ctx)
+	for i := 0; i < workers; i++ {
+		i := i
+		from := txFrom + uint64(i)*shardSize
+		to := cmp.Min(from+shardSize, txTo)
+		g.Go(func() error {
+			return db.View(gCtx, func(tx kv.Tx) (err error) {
+				shards[i], err = ii.collate(gCtx, from, to, tx, logEvery)
+				return err
+			})
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	indexBitmaps := shards[0]
+	for _, shard := range shards[1:] {
+		for k, bitmap := range shard {
+			if existing, ok := indexBitmaps[k]; ok {
+				existing.Or(bitmap)
+				bitmapdb.ReturnToPool64(bitmap)
+				continue
+			}
+			indexBitmaps[k] = bitmap
+		}
 	}
 	return indexBitmaps, nil
 }
@@ -976,11 +1161,15 @@ func (ii *InvertedIndex) buildFiles(ctx context.Context, step uint64, bitmaps ma
 	}()
 	txNumFrom := step * ii.aggregationStep
 	txNumTo := (step + 1) * ii.aggregationStep
-	datPath := filepath.Join(ii.dir, fmt.Sprintf("%s.%d-%d.ef", ii.filenameBase, txNumFrom/ii.aggregationStep, txNumTo/ii.aggregationStep))
+	datPath, err := ii.stagingPath(fmt.Sprintf("%s.%d-%d.ef", ii.filenameBase, txNumFrom/ii.aggregationStep, txNumTo/ii.aggregationStep))
+	if err != nil {
+		return InvertedFiles{}, err
+	}
 	comp, err = compress.NewCompressor(ctx, "ef", datPath, ii.tmpdir, compress.MinPatternScore, ii.workers, log.LvlTrace)
 	if err != nil {
 		return InvertedFiles{}, fmt.Errorf("create %s compressor: %w", ii.filenameBase, err)
 	}
+	comp.SetMetadata(ii.fileMeta("ef", txNumFrom, txNumTo))
 	var buf []byte
 	keys := make([]string, 0, len(bitmaps))
 	for key := range bitmaps {
@@ -1002,6 +1191,7 @@ func (ii *InvertedIndex) buildFiles(ctx context.Context, step uint64, bitmaps ma
 		if err = comp.AddUncompressedWord(buf); err != nil {
 			return InvertedFiles{}, fmt.Errorf("add %s val: %w", ii.filenameBase, err)
 		}
+		ii.buildIO.compressed.Add(uint64(len(key) + len(buf)))
 	}
 	if err = comp.Compress(); err != nil {
 		return InvertedFiles{}, fmt.Errorf("compress %s: %w", ii.filenameBase, err)
@@ -1011,21 +1201,37 @@ func (ii *InvertedIndex) buildFiles(ctx context.Context, step uint64, bitmaps ma
 	if decomp, err = compress.NewDecompressor(datPath); err != nil {
 		return InvertedFiles{}, fmt.Errorf("open %s decompressor: %w", ii.filenameBase, err)
 	}
-	idxPath := filepath.Join(ii.dir, fmt.Sprintf("%s.%d-%d.efi", ii.filenameBase, txNumFrom/ii.aggregationStep, txNumTo/ii.aggregationStep))
+	if ii.deferIndices { // .efi is built by buildDeferredIdx
+		if err = publishFiles(ii.dir, []**compress.Decompressor{&decomp}, nil); err != nil {
+			return InvertedFiles{}, fmt.Errorf("publish %s files: %w", ii.filenameBase, err)
+		}
+		closeComp = false
+		return InvertedFiles{decomp: decomp}, nil
+	}
+	idxPath, err := ii.stagingPath(fmt.Sprintf("%s.%d-%d.efi", ii.filenameBase, txNumFrom/ii.aggregationStep, txNumTo/ii.aggregationStep))
+	if err != nil {
+		return InvertedFiles{}, err
+	}
 	if index, err = buildIndex(ctx, decomp, idxPath, ii.tmpdir, len(keys), false /* values */); err != nil {
 		return InvertedFiles{}, fmt.Errorf("build %s efi: %w", ii.filenameBase, err)
 	}
+	if err = publishFiles(ii.dir, []**compress.Decompressor{&decomp}, []**recsplit.Index{&index}); err != nil {
+		return InvertedFiles{}, fmt.Errorf("publish %s files: %w", ii.filenameBase, err)
+	}
 	closeComp = false
 	return InvertedFiles{decomp: decomp, index: index}, nil
 }
 
 func (ii *InvertedIndex) integrateFiles(sf InvertedFiles, txNumFrom, txNumTo uint64) {
-	ii.files.ReplaceOrInsert(&filesItem{
+	item := &filesItem{
 		startTxNum:   txNumFrom,
 		endTxNum:     txNumTo,
 		decompressor: sf.decomp,
 		index:        sf.index,
-	})
+	}
+	ii.files.ReplaceOrInsert(item)
+	ii.fds.touch(item)
+	ii.localityIndex.warmUpdate([]*filesItem{item}, nil)
 }
 
 func (ii *InvertedIndex) warmup(txFrom, limit uint64, tx kv.Tx) error {
@@ -1067,6 +1273,22 @@ func (ii *InvertedIndex) warmup(txFrom, limit uint64, tx kv.Tx) error {
 	return nil
 }
 
+// hasTxNumsFrom - DB has records of txNums >= txFrom (which Unwind to txFrom deletes)
+func (ii *InvertedIndex) hasTxNumsFrom(tx kv.Tx, txFrom uint64) (bool, error) {
+	c, err := tx.Cursor(ii.indexKeysTable)
+	if err != nil {
+		return false, err
+	}
+	defer c.Close()
+	var txKey [8]byte
+	binary.BigEndian.PutUint64(txKey[:], txFrom)
+	k, _, err := c.Seek(txKey[:])
+	if err != nil {
+		return false, err
+	}
+	return k != nil, nil
+}
+
 // [txFrom; txTo)
 func (ii *InvertedIndex) prune(ctx context.Context, txFrom, txTo, limit uint64, logEvery *time.Ticker) error {
 	keysCursor, err := ii.tx.RwCursorDupSort(ii.indexKeysTable)
@@ -1084,9 +1306,7 @@ func (ii *InvertedIndex) prune(ctx context.Context, txFrom, txTo, limit uint64,
 		return nil
 	}
 	txFrom = binary.BigEndian.Uint64(k)
-	if limit != math.MaxUint64 && limit != 0 {
-		txTo = cmp.Min(txTo, txFrom+limit)
-	}
+	txTo = bitmapdb.LimitTo(txFrom, txTo, limit)
 	if txFrom >= txTo {
 		return nil
 	}
@@ -22,10 +22,13 @@ import (
 	"fmt"
 	"math"
 	"os"
+	"path/filepath"
+	"sync"
 	"testing"
 	"testing/fstest"
 	"time"
 
+	"github.com/RoaringBitmap/roaring/roaring64"
 	"github.com/google/btree"
 	"github.com/ledgerwatch/erigon-lib/kv/iter"
 	"github.com/ledgerwatch/erigon-lib/kv/order"
@@ -61,7 +64,7 @@ func testDbAndInvertedIndex(t *testing.T, aggStep uint64) (string, kv.RwDB, *Inv
 func TestInvIndexCollationBuild(t *testing.T) {
 	logEvery := time.NewTicker(30 * time.Second)
 	defer logEvery.Stop()
-	_, db, ii := testDbAndInvertedIndex(t, 16)
+	path, db, ii := testDbAndInvertedIndex(t, 16)
 	ctx := context.Background()
 	tx, err := db.BeginRw(ctx)
 	require.NoError(t, err)
@@ -103,6 +106,13 @@ func TestInvIndexCollationBuild(t *testing.T) {
 	sf, err := ii.buildFiles(ctx, 0, bs)
 	require.NoError(t, err)
 	defer sf.Close()
+	// built in staging dir and published
+	require.Equal(t, filepath.Join(path, "inv.0-1.ef"), sf.decomp.FilePath())
+	require.Equal(t, filepath.Join(path, "inv.0-1.efi"), sf.index.FilePath())
+	staged, err := os.ReadDir(filepath.Join(path, "staging"))
+	require.NoError(t, err)
+	require.Empty(t, staged)
+
 	g := sf.decomp.MakeGetter()
 	g.Reset(0)
 	var words []string
@@ -131,6 +141,38 @@ func TestInvIndexCollationBuild(t *testing.T) {
 	}
 }
 
+func TestInvIndexAddBatch(t *testing.T) {
+	_, db, ii := testDbAndInvertedIndex(t, 16)
+	ctx := context.Background()
+	tx, err := db.BeginRw(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback()
+	ii.SetTx(tx)
+	ii.StartWrites("")
+	defer ii.FinishWrites()
+
+	ii.SetTxNum(2)
+	require.NoError(t, ii.AddBatch([][]byte{[]byte("key1"), []byte("key2"), []byte("key1")}))
+	require.NoError(t, ii.AddBatch([][]byte{[]byte("key2"), []byte("key3")}))
+	ii.SetTxNum(3)
+	require.NoError(t, ii.AddBatch([][]byte{[]byte("key1"), []byte("key1")}))
+	require.NoError(t, ii.Rotate().Flush(ctx, tx))
+
+	var keys []string
+	require.NoError(t, tx.ForEach(ii.indexKeysTable, nil, func(k, v []byte) error {
+		keys = append(keys, fmt.Sprintf("%d:%s", binary.BigEndian.Uint64(k), v))
+		return nil
+	}))
+	require.Equal(t, []string{"2:key1", "2:key2", "2:key3", "3:key1"}, keys)
+
+	c, err := tx.Cursor(ii.indexTable)
+	require.NoError(t, err)
+	defer c.Close()
+	cnt, err := c.Count()
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), cnt)
+}
+
 func TestInvIndexAfterPrune(t *testing.T) {
 	logEvery := time.NewTicker(30 * time.Second)
 	defer logEvery.Stop()
@@ -384,6 +426,32 @@ func TestInvIndexMerge(t *testing.T) {
 	checkRanges(t, db, ii, txs)
 }
 
+func TestInvIndexCollateSharded(t *testing.T) {
+	_, db, ii, txs := filledInvIndex(t)
+	defer db.Close()
+	defer ii.Close()
+	ctx := context.Background()
+	logEvery := time.NewTicker(30 * time.Second)
+	defer logEvery.Stop()
+
+	for _, r := range [][2]uint64{{0, 16}, {16, 48}, {0, txs + 1}, {5, 7}} {
+		var expect map[string]*roaring64.Bitmap
+		require.NoError(t, db.View(ctx, func(tx kv.Tx) (err error) {
+			expect, err = ii.collate(ctx, r[0], r[1], tx, logEvery)
+			return err
+		}))
+		for _, workers := range []int{1, 3, 8} {
+			ii.collateWorkers = workers
+			got, err := ii.collateSharded(ctx, r[0], r[1], db, logEvery)
+			require.NoError(t, err)
+			require.Equal(t, len(expect), len(got))
+			for k, bitmap := range expect {
+				require.True(t, bitmap.Equals(got[k]), "range %d-%d, workers %d, key %x", r[0], r[1], workers, k)
+			}
+		}
+	}
+}
+
 func TestInvIndexScanFiles(t *testing.T) {
 	path, db, ii, txs := filledInvIndex(t)
 	ii.Close()
@@ -493,3 +561,69 @@ func TestScanStaticFiles(t *testing.T) {
 	})
 	require.Equal(t, 0, ii.files.Len())
 }
+
+func TestInvIndexBackfill(t *testing.T) {
+	_, db, ii, _ := filledInvIndex(t)
+	ctx := context.Background()
+	logEvery := time.NewTicker(30 * time.Second)
+	defer logEvery.Stop()
+	collect := func(src BackfillSource, txFrom, txTo uint64) map[string][]uint64 {
+		res := map[string][]uint64{}
+		require.NoError(t, src(ctx, txFrom, txTo, func(txNum uint64, key []byte) error {
+			res[string(key)] = append(res[string(key)], txNum)
+			return nil
+		}))
+		return res
+	}
+
+	// stands for replay of blocks
+	var replayed []uint64
+	var failAt uint64
+	replay := func(ctx context.Context, txFrom, txTo uint64, add func(txNum uint64, key []byte) error) error {
+		step := txFrom / ii.aggregationStep
+		if failAt > 0 && step == failAt {
+			return fmt.Errorf("interrupted")
+		}
+		replayed = append(replayed, step)
+		return db.View(ctx, func(tx kv.Tx) error {
+			bitmaps, err := ii.collate(ctx, txFrom, txTo, tx, logEvery)
+			if err != nil {
+				return err
+			}
+			for k, bitmap := range bitmaps {
+				for it := bitmap.Iterator(); it.HasNext(); {
+					if err = add(it.Next(), []byte(k)); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		})
+	}
+
+	_, _, backfilled := testDbAndInvertedIndex(t, ii.aggregationStep)
+	toTxNum := 5*ii.aggregationStep + 3
+	failAt = 3
+	err := backfilled.backfill(ctx, toTxNum, replay, &sync.RWMutex{}, func(BackfillProgress) {})
+	require.ErrorContains(t, err, "interrupted")
+	require.Equal(t, []uint64{0, 1, 2}, replayed)
+
+	// resume: steps with files are skipped
+	failAt, replayed = 0, nil
+	var skipped []bool
+	require.NoError(t, backfilled.backfill(ctx, toTxNum, replay, &sync.RWMutex{}, func(p BackfillProgress) {
+		require.Equal(t, uint64(5), p.Total)
+		skipped = append(skipped, p.Skipped)
+	}))
+	require.Equal(t, []uint64{3, 4}, replayed)
+	require.Equal(t, []bool{true, true, true, false, false}, skipped)
+	require.Equal(t, 5*ii.aggregationStep, backfilled.endTxNumMinimax())
+
+	files := InvertedIndexSource(backfilled.MakeContext(), nil)
+	for step := uint64(0); step < 5; step++ {
+		txFrom, txTo := step*ii.aggregationStep, (step+1)*ii.aggregationStep
+		require.Equal(t, collect(replay, txFrom, txTo), collect(files, txFrom, txTo), "step %d", step)
+	}
+	lastByte := InvertedIndexSource(backfilled.MakeContext(), func(key []byte) []byte { return key[7:] })
+	require.Equal(t, []uint64{3, 6, 9, 12, 15}, collect(lastByte, 0, ii.aggregationStep)[string([]byte{3})])
+}
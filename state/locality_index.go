@@ -20,54 +20,116 @@ import (
 	"bytes"
 	"container/heap"
 	"context"
+	"errors"
 	"fmt"
 	"io/fs"
-	"os"
 	"path/filepath"
 	"regexp"
 	"strconv"
 	"time"
 
-	"github.com/ledgerwatch/erigon-lib/common/dir"
+	"github.com/ledgerwatch/erigon-lib/common/cmp"
 	"github.com/ledgerwatch/erigon-lib/kv/bitmapdb"
 	"github.com/ledgerwatch/erigon-lib/recsplit"
 	"github.com/ledgerwatch/log/v3"
+	"go.uber.org/atomic"
 )
 
 const LocalityIndexUint64Limit = 64 //bitmap spend 1 bit per file, stored as uint64
 
+// localityIndexGroupSize - amount of frozen files in one group of two-level LocalityIndex
+var localityIndexGroupSize = uint64(LocalityIndexUint64Limit)
+
 // LocalityIndex - has info in which .ef files exists given key
 // Format: key -> bitmap(step_number_list)
 // step_number_list is list of .ef files where exists given key
+//
+// If amount of frozen files > localityIndexGroupSize - index is two-level (selected automatically by amount of files):
+//   - coarse level (.li, .l files): key -> bitmap(group_number_list), group is localityIndexGroupSize files
+//   - fine level (.lgi, .lg files - one pair per group): key -> bitmap(file_number_list) of files in group
+//
+// It keeps bitmap rows narrow and lookup doesn't scan all files bits
 type LocalityIndex struct {
 	//file         *filesItem
-	filenameBase    string
-	dir             string // Directory where static files are created
-	tmpdir          string // Directory where static files are created
-	aggregationStep uint64 // Directory where static files are created
+	filenameBase     string
+	dir              string // Directory where static files are created
+	tmpdir           string // Directory where static files are created
+	aggregationStep  uint64 // Directory where static files are created
+	biggestFileSteps uint64 // see AggregatorV3.SetFreezeTiers, 0 - StepsInBiggestFile
+
+	file   *filesItem
+	bm     *bitmapdb.FixedSizeBitmaps
+	groups []*localityGroup // fine level of two-level index, nil if index is one-level
+
+	warm atomic.Pointer[warmLocality] // covers files after `file`
+	fs   FS
+}
+
+// localityGroup - fine level of two-level LocalityIndex: files of one group. nil - no keys in group
+type localityGroup struct {
+	index *recsplit.Index
+	r     *recsplit.IndexReader // IndexReader is thread-safe
+	bm    *bitmapdb.FixedSizeBitmaps
+}
+
+func (g *localityGroup) Close() {
+	if g == nil {
+		return
+	}
+	if g.index != nil {
+		g.index.Close()
+	}
+	if g.bm != nil {
+		g.bm.Close()
+	}
+}
+
+func localityFilesAmount(fromStep, toStep, stepsInBiggestFile uint64) uint64 {
+	return (toStep - fromStep) / stepsInBiggestFile
+}
+func localityTwoLevel(filesAmount uint64) bool { return filesAmount > localityIndexGroupSize }
+func localityGroupsAmount(filesAmount uint64) uint64 {
+	return (filesAmount + localityIndexGroupSize - 1) / localityIndexGroupSize
+}
+
+// localityGroupFilesAmount - amount of files in group g, last group may be not full
+func localityGroupFilesAmount(filesAmount, g uint64) uint64 {
+	return cmp.Min(localityIndexGroupSize, filesAmount-g*localityIndexGroupSize)
+}
 
-	file *filesItem
-	bm   *bitmapdb.FixedSizeBitmaps
+func (li *LocalityIndex) groupFilePaths(fromStep, toStep, g uint64) (idxPath, bmPath string) {
+	return filepath.Join(li.dir, fmt.Sprintf("%s.%d-%d.%d.lgi", li.filenameBase, fromStep, toStep, g)),
+		filepath.Join(li.dir, fmt.Sprintf("%s.%d-%d.%d.lg", li.filenameBase, fromStep, toStep, g))
 }
 
 func NewLocalityIndex(
 	dir, tmpdir string,
 	aggregationStep uint64,
 	filenameBase string,
+) (*LocalityIndex, error) {
+	return newLocalityIndex(OsFS{}, dir, tmpdir, aggregationStep, filenameBase)
+}
+
+func newLocalityIndex(
+	fsys FS,
+	dir, tmpdir string,
+	aggregationStep uint64,
+	filenameBase string,
 ) (*LocalityIndex, error) {
 	li := &LocalityIndex{
+		fs:              fsys,
 		dir:             dir,
 		tmpdir:          tmpdir,
 		aggregationStep: aggregationStep,
 		filenameBase:    filenameBase,
 	}
-	files, err := os.ReadDir(dir)
+	files, err := li.fsys().ReadDir(dir)
 	if err != nil {
 		return nil, fmt.Errorf("NewInvertedIndex: %s, %w", filenameBase, err)
 	}
 	uselessFiles := li.scanStateFiles(files)
 	for _, f := range uselessFiles {
-		_ = os.Remove(filepath.Join(li.dir, f))
+		_ = li.fsys().Remove(filepath.Join(li.dir, f))
 	}
 	if err = li.openFiles(); err != nil {
 		return nil, fmt.Errorf("NewInvertedIndex: %s, %w", filenameBase, err)
@@ -109,11 +171,6 @@ func (li *LocalityIndex) scanStateFiles(files []fs.DirEntry) (uselessFiles []str
 			log.Warn("LocalityIndex must always starts from step 0")
 			continue
 		}
-		if endStep > StepsInBiggestFile*LocalityIndexUint64Limit {
-			log.Warn("LocalityIndex does store bitmaps as uint64, means it can't handle > 2048 steps. But it's possible to implement")
-			continue
-		}
-
 		startTxNum, endTxNum := startStep*li.aggregationStep, endStep*li.aggregationStep
 		if li.file == nil {
 			li.file = &filesItem{startTxNum: startTxNum, endTxNum: endTxNum}
@@ -133,24 +190,59 @@ func (li *LocalityIndex) openFiles() (err error) {
 	}
 	fromStep, toStep := li.file.startTxNum/li.aggregationStep, li.file.endTxNum/li.aggregationStep
 	idxPath := filepath.Join(li.dir, fmt.Sprintf("%s.%d-%d.li", li.filenameBase, fromStep, toStep))
-	li.file.index, err = recsplit.OpenIndex(idxPath)
+	li.file.index, err = openIndex(li.fsys(), idxPath)
 	if err != nil {
 		return fmt.Errorf("LocalityIndex.openFiles: %w, %s", err, idxPath)
 	}
 	dataPath := filepath.Join(li.dir, fmt.Sprintf("%s.%d-%d.l", li.filenameBase, fromStep, toStep))
-	li.bm, err = bitmapdb.OpenFixedSizeBitmaps(dataPath, int((toStep-fromStep)/StepsInBiggestFile))
-	if err != nil {
+	filesAmount := localityFilesAmount(fromStep, toStep, li.stepsInBiggestFile())
+	if !localityTwoLevel(filesAmount) {
+		li.bm, err = openBitmaps(li.fsys(), dataPath, int(filesAmount))
+		if err != nil {
+			return err
+		}
+		return nil
+	}
+
+	if li.bm, err = openBitmaps(li.fsys(), dataPath, int(localityGroupsAmount(filesAmount))); err != nil {
 		return err
 	}
+	li.groups = make([]*localityGroup, localityGroupsAmount(filesAmount))
+	for g := range li.groups {
+		idxPath, bmPath := li.groupFilePaths(fromStep, toStep, uint64(g))
+		group := &localityGroup{}
+		if group.index, err = openIndex(li.fsys(), idxPath); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue // group without keys
+			}
+			return fmt.Errorf("LocalityIndex.openFiles: %w, %s", err, idxPath)
+		}
+		li.groups[g] = group
+		group.r = recsplit.NewIndexReader(group.index)
+		if group.bm, err = openBitmaps(li.fsys(), bmPath, int(localityGroupFilesAmount(filesAmount, uint64(g)))); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
 func (li *LocalityIndex) closeFiles() {
-	if li.file.index != nil {
+	if li.file != nil && li.file.index != nil {
 		li.file.index.Close()
+		li.fsys().Close(li.file.index.FilePath())
 	}
 	if li.bm != nil {
 		li.bm.Close()
+		li.fsys().Close(li.bm.FilePath())
+	}
+	for _, g := range li.groups {
+		if g != nil && g.index != nil {
+			li.fsys().Close(g.index.FilePath())
+		}
+		if g != nil && g.bm != nil {
+			li.fsys().Close(g.bm.FilePath())
+		}
+		g.Close()
 	}
 }
 
@@ -175,24 +267,85 @@ func (li *LocalityIndex) lookupIdxFiles(r *recsplit.IndexReader, bm *bitmapdb.Fi
 		return 0, 0, fromTxNum, false, false
 	}
 
-	fromFileNum := fromTxNum / li.aggregationStep / StepsInBiggestFile
-	fn1, fn2, ok1, ok2, err := bm.First2At(r.Lookup(key), fromFileNum)
+	fromFileNum := fromTxNum / li.aggregationStep / li.stepsInBiggestFile()
+	var fn1, fn2 uint64
+	var err error
+	if li.groups == nil {
+		fn1, fn2, ok1, ok2, err = bm.First2At(r.Lookup(key), fromFileNum)
+	} else {
+		fn1, fn2, ok1, ok2, err = li.lookupGroups(r, bm, key, fromFileNum)
+	}
 	if err != nil {
 		panic(err)
 	}
-	return fn1 * StepsInBiggestFile, fn2 * StepsInBiggestFile, li.file.endTxNum, ok1, ok2
+	return fn1 * li.stepsInBiggestFile(), fn2 * li.stepsInBiggestFile(), li.file.endTxNum, ok1, ok2
+}
+
+// lookupGroups - first 2 files >= fromFileNum in two-level index: walks coarse level groups of key in ascending order,
+// and takes files from fine level of each group. Usually touches 1-2 groups.
+func (li *LocalityIndex) lookupGroups(r *recsplit.IndexReader, bm *bitmapdb.FixedSizeBitmaps, key []byte, fromFileNum uint64) (fn1, fn2 uint64, ok1, ok2 bool, err error) {
+	row := r.Lookup(key)
+	add := func(g uint64) (done bool, err error) {
+		if g >= uint64(len(li.groups)) || li.groups[g] == nil {
+			return false, nil
+		}
+		group, first := li.groups[g], g*localityIndexGroupSize
+		from := uint64(0)
+		if fromFileNum > first {
+			from = fromFileNum - first
+		}
+		f1, f2, has1, has2, err := group.bm.First2At(group.r.Lookup(key), from)
+		if err != nil {
+			return false, err
+		}
+		for _, f := range [2]struct {
+			n   uint64
+			has bool
+		}{{f1, has1}, {f2, has2}} {
+			if !f.has {
+				break
+			}
+			if !ok1 {
+				fn1, ok1 = first+f.n, true
+				continue
+			}
+			fn2, ok2 = first+f.n, true
+			return true, nil
+		}
+		return false, nil
+	}
+
+	for after := fromFileNum / localityIndexGroupSize; ; {
+		g1, g2, has1, has2, err := bm.First2At(row, after)
+		if err != nil || !has1 {
+			return fn1, fn2, ok1, ok2, err
+		}
+		if done, err := add(g1); err != nil || done {
+			return fn1, fn2, ok1, ok2, err
+		}
+		if !has2 {
+			return fn1, fn2, ok1, ok2, nil
+		}
+		if done, err := add(g2); err != nil || done {
+			return fn1, fn2, ok1, ok2, err
+		}
+		after = g2 + 1
+	}
 }
 
 func (li *LocalityIndex) missedIdxFiles(ii *InvertedIndex) (toStep uint64, idxExists bool) {
 	ii.files.Descend(func(item *filesItem) bool {
-		if item.endTxNum-item.startTxNum == StepsInBiggestFile*li.aggregationStep {
+		if item.endTxNum-item.startTxNum == li.stepsInBiggestFile()*li.aggregationStep {
 			toStep = item.endTxNum / li.aggregationStep
 			return false
 		}
 		return true
 	})
 	fName := fmt.Sprintf("%s.%d-%d.li", li.filenameBase, 0, toStep)
-	return toStep, dir.FileExist(filepath.Join(li.dir, fName))
+	if li.file != nil && li.file.index != nil && li.file.endTxNum == toStep*li.aggregationStep {
+		return toStep, true // opened, maybe without local copy
+	}
+	return toStep, li.fsys().Exists(filepath.Join(li.dir, fName))
 }
 func (li *LocalityIndex) buildFiles(ctx context.Context, ii *InvertedIndex, toStep uint64) (files *LocalityIndexFiles, err error) {
 	defer ii.EnableMadvNormalReadAhead().DisableReadAhead()
@@ -204,9 +357,22 @@ func (li *LocalityIndex) buildFiles(ctx context.Context, ii *InvertedIndex, toSt
 
 	count := 0
 	it := ii.MakeContext().iterateKeysLocality(toStep * li.aggregationStep)
+	filesAmount := it.FilesAmount()
+	twoLevel := localityTwoLevel(filesAmount)
+	var groupsCount []uint64
+	var groups []uint64
+	if twoLevel {
+		groupsCount = make([]uint64, localityGroupsAmount(filesAmount))
+	}
 	for it.HasNext() {
-		_, _ = it.Next()
+		_, inFiles := it.Next()
 		count++
+		if twoLevel {
+			groups = localityGroupsOf(inFiles, groups[:0])
+			for _, g := range groups {
+				groupsCount[g]++
+			}
+		}
 		//select {
 		//case <-ctx.Done():
 		//	return nil, ctx.Err()
@@ -220,38 +386,60 @@ func (li *LocalityIndex) buildFiles(ctx context.Context, ii *InvertedIndex, toSt
 	idxPath := filepath.Join(li.dir, fName)
 	filePath := filepath.Join(li.dir, fmt.Sprintf("%s.%d-%d.l", li.filenameBase, fromStep, toStep))
 
-	rs, err := recsplit.NewRecSplit(recsplit.RecSplitArgs{
-		KeyCount:   count,
-		Enums:      false,
-		BucketSize: 2000,
-		LeafSize:   8,
-		TmpDir:     li.tmpdir,
-		IndexFile:  idxPath,
-	})
-	if err != nil {
-		return nil, fmt.Errorf("create recsplit: %w", err)
+	// top level: files of one-level index or coarse level of two-level index
+	bitsPerBitmap := filesAmount
+	if twoLevel {
+		bitsPerBitmap = localityGroupsAmount(filesAmount)
 	}
-	defer rs.Close()
-	rs.LogLvl(log.LvlTrace)
-
-	i := uint64(0)
-	for {
-		dense, err := bitmapdb.NewFixedSizeBitmapsWriter(filePath, int(it.FilesAmount()), uint64(count))
-		if err != nil {
-			return nil, err
+	top := &localityBuilder{idxPath: idxPath, bmPath: filePath, keyCount: uint64(count), bitsPerBitmap: bitsPerBitmap}
+	builders := []*localityBuilder{top}
+	fine := make([]*localityBuilder, len(groupsCount))
+	for g, keyCount := range groupsCount {
+		if keyCount == 0 {
+			continue
+		}
+		gIdxPath, gBmPath := li.groupFilePaths(fromStep, toStep, uint64(g))
+		fine[g] = &localityBuilder{idxPath: gIdxPath, bmPath: gBmPath, keyCount: keyCount, bitsPerBitmap: localityGroupFilesAmount(filesAmount, uint64(g))}
+		builders = append(builders, fine[g])
+	}
+	defer func() {
+		for _, b := range builders {
+			b.Close()
 		}
-		defer dense.Close()
+	}()
 
+	// all files are built by one pass over keys, pass repeated only for files with recsplit collision
+	for pending := builders; len(pending) > 0; {
+		for _, b := range pending {
+			if err = b.start(li.tmpdir); err != nil {
+				return nil, err
+			}
+		}
+		var groupFiles []uint64
 		it = ii.MakeContext().iterateKeysLocality(toStep * li.aggregationStep)
 		for it.HasNext() {
 			k, inFiles := it.Next()
-			if err := dense.AddArray(i, inFiles); err != nil {
-				return nil, err
-			}
-			if err = rs.AddKey(k, 0); err != nil {
-				return nil, err
+			if !twoLevel {
+				if err = top.add(k, inFiles); err != nil {
+					return nil, err
+				}
+			} else {
+				groups = localityGroupsOf(inFiles, groups[:0])
+				if err = top.add(k, groups); err != nil {
+					return nil, err
+				}
+				for _, g := range groups {
+					groupFiles = groupFiles[:0]
+					for _, f := range inFiles {
+						if f/localityIndexGroupSize == g {
+							groupFiles = append(groupFiles, f%localityIndexGroupSize)
+						}
+					}
+					if err = fine[g].add(k, groupFiles); err != nil {
+						return nil, err
+					}
+				}
 			}
-			i++
 
 			select {
 			case <-ctx.Done():
@@ -262,31 +450,128 @@ func (li *LocalityIndex) buildFiles(ctx context.Context, ii *InvertedIndex, toSt
 			}
 		}
 
-		if err := dense.Build(); err != nil {
-			return nil, err
-		}
-
-		if err = rs.Build(); err != nil {
-			if rs.Collision() {
-				log.Debug("Building recsplit. Collision happened. It's ok. Restarting...")
-				rs.ResetNextSalt()
-			} else {
-				return nil, fmt.Errorf("build idx: %w", err)
+		collided := pending[:0:0]
+		for _, b := range pending {
+			collision, err := b.build()
+			if err != nil {
+				return nil, err
+			}
+			if collision {
+				collided = append(collided, b)
 			}
-		} else {
-			break
 		}
+		pending = collided
 	}
 
-	idx, err := recsplit.OpenIndex(idxPath)
-	if err != nil {
+	files = &LocalityIndexFiles{}
+	if files.index, err = recsplit.OpenIndex(idxPath); err != nil {
 		return nil, err
 	}
-	bm, err := bitmapdb.OpenFixedSizeBitmaps(filePath, int(it.FilesAmount()))
-	if err != nil {
+	if files.bm, err = bitmapdb.OpenFixedSizeBitmaps(filePath, int(bitsPerBitmap)); err != nil {
+		files.Close()
 		return nil, err
 	}
-	return &LocalityIndexFiles{index: idx, bm: bm}, nil
+	if twoLevel {
+		files.groups = make([]*localityGroup, len(fine))
+		for g, b := range fine {
+			if b == nil {
+				continue
+			}
+			group := &localityGroup{}
+			files.groups[g] = group
+			if group.index, err = recsplit.OpenIndex(b.idxPath); err != nil {
+				files.Close()
+				return nil, err
+			}
+			group.r = recsplit.NewIndexReader(group.index)
+			if group.bm, err = bitmapdb.OpenFixedSizeBitmaps(b.bmPath, int(b.bitsPerBitmap)); err != nil {
+				files.Close()
+				return nil, err
+			}
+		}
+	}
+	return files, nil
+}
+
+// localityGroupsOf - sorted list of groups of sorted list of files
+func localityGroupsOf(inFiles []uint64, groups []uint64) []uint64 {
+	for _, f := range inFiles {
+		g := f / localityIndexGroupSize
+		if len(groups) == 0 || groups[len(groups)-1] != g {
+			groups = append(groups, g)
+		}
+	}
+	return groups
+}
+
+// localityBuilder - builds pair of files: recsplit (key -> row number) and bitmaps (row -> list of bits)
+type localityBuilder struct {
+	idxPath, bmPath string
+	keyCount        uint64
+	bitsPerBitmap   uint64
+
+	rs  *recsplit.RecSplit
+	bm  *bitmapdb.FixedSizeBitmapsWriter
+	row uint64
+}
+
+func (b *localityBuilder) start(tmpdir string) (err error) {
+	if b.rs == nil {
+		b.rs, err = recsplit.NewRecSplit(recsplit.RecSplitArgs{
+			KeyCount:   int(b.keyCount),
+			Enums:      false,
+			BucketSize: 2000,
+			LeafSize:   8,
+			TmpDir:     tmpdir,
+			IndexFile:  b.idxPath,
+		})
+		if err != nil {
+			return fmt.Errorf("create recsplit: %w", err)
+		}
+		b.rs.LogLvl(log.LvlTrace)
+	}
+	if b.bm != nil {
+		b.bm.Close()
+	}
+	b.row = 0
+	b.bm, err = bitmapdb.NewFixedSizeBitmapsWriter(b.bmPath, int(b.bitsPerBitmap), b.keyCount)
+	return err
+}
+
+func (b *localityBuilder) add(k []byte, bits []uint64) error {
+	if err := b.bm.AddArray(b.row, bits); err != nil {
+		return err
+	}
+	if err := b.rs.AddKey(k, b.row); err != nil {
+		return err
+	}
+	b.row++
+	return nil
+}
+
+// build - returns collision=true if recsplit must be re-filled with new salt
+func (b *localityBuilder) build() (collision bool, err error) {
+	if err = b.bm.Build(); err != nil {
+		return false, err
+	}
+	if err = b.rs.Build(); err != nil {
+		if b.rs.Collision() {
+			log.Debug("Building recsplit. Collision happened. It's ok. Restarting...")
+			b.rs.ResetNextSalt()
+			return true, nil
+		}
+		return false, fmt.Errorf("build idx: %w", err)
+	}
+	return false, nil
+}
+
+func (b *localityBuilder) Close() {
+	if b.rs != nil {
+		b.rs.Close()
+	}
+	if b.bm != nil {
+		b.bm.Close()
+	}
 }
 
 func (li *LocalityIndex) integrateFiles(sf LocalityIndexFiles, txNumFrom, txNumTo uint64) {
@@ -296,6 +581,7 @@ func (li *LocalityIndex) integrateFiles(sf LocalityIndexFiles, txNumFrom, txNumT
 		index:      sf.index,
 	}
 	li.bm = sf.bm
+	li.groups = sf.groups
 }
 
 func (li *LocalityIndex) BuildMissedIndices(ctx context.Context, ii *InvertedIndex) error {
@@ -315,19 +601,22 @@ func (li *LocalityIndex) BuildMissedIndices(ctx context.Context, ii *InvertedInd
 		return err
 	}
 	var oldFile *filesItem
+	var oldGroups []*localityGroup
 	if li.file != nil {
-		oldFile = li.file
+		oldFile, oldGroups = li.file, li.groups
 	}
 	li.integrateFiles(*f, fromStep*li.aggregationStep, toStep*li.aggregationStep)
-	if err = li.deleteFiles(oldFile); err != nil {
+	li.warmUpdate(nil, nil)
+	if err = li.deleteFiles(oldFile, oldGroups); err != nil {
 		return err
 	}
 	return nil
 }
 
 type LocalityIndexFiles struct {
-	index *recsplit.Index
-	bm    *bitmapdb.FixedSizeBitmaps
+	index  *recsplit.Index
+	bm     *bitmapdb.FixedSizeBitmaps
+	groups []*localityGroup
 }
 
 func (sf LocalityIndexFiles) Close() {
@@ -337,6 +626,9 @@ func (sf LocalityIndexFiles) Close() {
 	if sf.bm != nil {
 		sf.bm.Close()
 	}
+	for _, g := range sf.groups {
+		g.Close()
+	}
 }
 
 type LocalityIterator struct {
@@ -363,7 +655,7 @@ func (si *LocalityIterator) advance() {
 			heap.Push(&si.h, top)
 		}
 
-		inFile := inStep / StepsInBiggestFile
+		inFile := inStep / uint32(si.hc.ii.localityIndex.stepsInBiggestFile())
 
 		if !bytes.Equal(key, si.key) {
 			if si.key == nil {
@@ -401,7 +693,7 @@ func (si *LocalityIterator) Next() ([]byte, []uint64) {
 func (ic *InvertedIndexContext) iterateKeysLocality(uptoTxNum uint64) *LocalityIterator {
 	si := &LocalityIterator{hc: ic}
 	ic.files.Ascend(func(item ctxItem) bool {
-		if (item.endTxNum-item.startTxNum)/ic.ii.aggregationStep != StepsInBiggestFile {
+		if (item.endTxNum-item.startTxNum)/ic.ii.aggregationStep != ic.ii.localityIndex.stepsInBiggestFile() {
 			return false
 		}
 		if item.startTxNum > uptoTxNum {
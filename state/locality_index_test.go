@@ -81,3 +81,99 @@ func TestLocality(t *testing.T) {
 		require.Equal(2*li.aggregationStep*StepsInBiggestFile, from)
 	})
 }
+
+func TestLocalityTwoLevel(t *testing.T) {
+	defer func(size uint64) { localityIndexGroupSize = size }(localityIndexGroupSize)
+	localityIndexGroupSize = 1
+
+	ctx, require := context.Background(), require.New(t)
+	const Module uint64 = 31
+	path, db, ii, txs := filledInvIndexOfSize(t, 300, 4, Module)
+	mergeInverted(t, db, ii, txs)
+
+	expected := map[string][]uint64{}
+	it := ii.MakeContext().iterateKeysLocality(math.MaxUint64)
+	for it.HasNext() {
+		k, inFiles := it.Next()
+		expected[string(k)] = append([]uint64{}, inFiles...)
+	}
+	require.NotEmpty(expected)
+
+	li, err := NewLocalityIndex(path, path, 4, "inv")
+	require.NoError(err)
+	require.NoError(li.BuildMissedIndices(ctx, ii))
+	li.Close()
+
+	// reopen: two-level mode must be selected by amount of files
+	li, err = NewLocalityIndex(path, path, 4, "inv")
+	require.NoError(err)
+	defer li.Close()
+	require.Len(li.groups, 2)
+
+	r := li.NewIdxReader()
+	for k, inFiles := range expected {
+		for from := uint64(0); from < 2; from++ {
+			var want []uint64
+			for _, f := range inFiles {
+				if f >= from && len(want) < 2 {
+					want = append(want, f*StepsInBiggestFile)
+				}
+			}
+			v1, v2, _, ok1, ok2 := li.lookupIdxFiles(r, li.bm, []byte(k), from*li.aggregationStep*StepsInBiggestFile)
+			var got []uint64
+			if ok1 {
+				got = append(got, v1)
+			}
+			if ok2 {
+				got = append(got, v2)
+			}
+			require.Equal(want, got, "key=%x from=%d", k, from)
+		}
+	}
+}
+
+func TestLocalityWarmFiles(t *testing.T) {
+	ctx, require := context.Background(), require.New(t)
+	const Module uint64 = 31
+	path, db, ii, txs := filledInvIndexOfSize(t, 300, 4, Module)
+	mergeInverted(t, db, ii, txs)
+
+	li, err := NewLocalityIndex(path, path, 4, "inv")
+	require.NoError(err)
+	defer li.Close()
+	var all []*filesItem
+	ii.files.Ascend(func(item *filesItem) bool {
+		all = append(all, item)
+		return true
+	})
+	li.warmUpdate(all, nil)
+	require.Len(li.warmLocality().files, len(all))
+
+	// frozen files are covered by LocalityIndex files, only recent files stay warm
+	require.NoError(li.BuildMissedIndices(ctx, ii))
+	warm := li.warmLocality()
+	require.NotEmpty(warm.files)
+	for _, item := range all {
+		_, isWarm := warm.files[warmFileKey{item.startTxNum, item.endTxNum}]
+		require.Equal(item.endTxNum > li.file.endTxNum, isWarm)
+		if !isWarm {
+			continue
+		}
+		g := item.decompressor.MakeGetter()
+		for g.HasNext() {
+			k, _ := g.NextUncompressed()
+			require.True(warm.mayHave(item.startTxNum, item.endTxNum, k))
+			g.SkipUncompressed()
+		}
+	}
+
+	var k [8]byte
+	binary.BigEndian.PutUint64(k[:], Module+1) // no such key
+	var skipped int
+	for key := range warm.files {
+		if !warm.mayHave(key.startTxNum, key.endTxNum, k[:]) {
+			skipped++
+		}
+	}
+	require.NotZero(skipped)
+}
@@ -0,0 +1,140 @@
+/*
+   Copyright 2023 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package state
+
+import (
+	"github.com/spaolacci/murmur3"
+)
+
+const (
+	warmLocalityBitsPerKey = 10 // ~1% of false-positives with warmLocalityProbes
+	warmLocalityProbes     = 4
+)
+
+// warmLocality - in-memory mini LocalityIndex of warm files: recent files which are not covered by LocalityIndex files yet.
+// Has bloom-filter of keys of each warm file: allows skip files without key without touching them (recsplit lookup + key read).
+// Immutable: each update creates new version, readers use version captured at MakeContext.
+type warmLocality struct {
+	files map[warmFileKey]*keysBloom
+}
+
+type warmFileKey struct{ startTxNum, endTxNum uint64 }
+
+// mayHave - false if file [startTxNum, endTxNum) has no key for sure. true if file may have key or file is unknown.
+func (w *warmLocality) mayHave(startTxNum, endTxNum uint64, key []byte) bool {
+	if w == nil {
+		return true
+	}
+	bloom, ok := w.files[warmFileKey{startTxNum, endTxNum}]
+	if !ok {
+		return true
+	}
+	return bloom.has(key)
+}
+
+// with - copy of w, with added `in` and without `outs`
+func (w *warmLocality) with(in []*filesItem, outs []*filesItem, coveredTo uint64) *warmLocality {
+	res := &warmLocality{files: map[warmFileKey]*keysBloom{}}
+	if w != nil {
+		for k, bloom := range w.files {
+			res.files[k] = bloom
+		}
+	}
+	for _, out := range outs {
+		delete(res.files, warmFileKey{out.startTxNum, out.endTxNum})
+	}
+	for _, item := range in {
+		if item.endTxNum <= coveredTo || item.decompressor == nil {
+			continue
+		}
+		res.files[warmFileKey{item.startTxNum, item.endTxNum}] = newKeysBloomFromFile(item)
+	}
+	for k := range res.files {
+		if k.endTxNum <= coveredTo {
+			delete(res.files, k)
+		}
+	}
+	return res
+}
+
+// warmCoveredTo - end of range covered by LocalityIndex files, files after it are warm
+func (li *LocalityIndex) warmCoveredTo() uint64 {
+	if li.file == nil {
+		return 0
+	}
+	return li.file.endTxNum
+}
+
+// warmUpdate - must be called on any change of files of InvertedIndex or of LocalityIndex files
+func (li *LocalityIndex) warmUpdate(in []*filesItem, outs []*filesItem) {
+	if li == nil {
+		return
+	}
+	li.warm.Store(li.warm.Load().with(in, outs, li.warmCoveredTo()))
+}
+
+func (li *LocalityIndex) warmLocality() *warmLocality {
+	if li == nil {
+		return nil
+	}
+	return li.warm.Load()
+}
+
+// keysBloom - bloom-filter of keys of .ef file
+type keysBloom struct {
+	bits []uint64
+}
+
+func newKeysBloomFromFile(item *filesItem) *keysBloom {
+	b := newKeysBloom(uint64(item.decompressor.Count() / 2))
+	g := item.decompressor.MakeGetter()
+	for g.HasNext() {
+		k, _ := g.NextUncompressed()
+		b.add(k)
+		g.SkipUncompressed()
+	}
+	return b
+}
+
+func newKeysBloom(keysCount uint64) *keysBloom {
+	words := (keysCount*warmLocalityBitsPerKey + 63) / 64
+	if words == 0 {
+		words = 1
+	}
+	return &keysBloom{bits: make([]uint64, words)}
+}
+
+func (b *keysBloom) add(key []byte) {
+	h1, h2 := murmur3.Sum128(key)
+	n := uint64(len(b.bits)) * 64
+	for i := uint64(0); i < warmLocalityProbes; i++ {
+		bit := (h1 + i*h2) % n
+		b.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+func (b *keysBloom) has(key []byte) bool {
+	h1, h2 := murmur3.Sum128(key)
+	n := uint64(len(b.bits)) * 64
+	for i := uint64(0); i < warmLocalityProbes; i++ {
+		bit := (h1 + i*h2) % n
+		if b.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
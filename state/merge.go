@@ -22,12 +22,12 @@ import (
 	"context"
 	"encoding/binary"
 	"fmt"
-	"os"
 	"path/filepath"
 	"strings"
 
 	"github.com/ledgerwatch/erigon-lib/common"
 	"github.com/ledgerwatch/erigon-lib/common/cmp"
+	"github.com/ledgerwatch/erigon-lib/common/dbg"
 	"github.com/ledgerwatch/erigon-lib/compress"
 	"github.com/ledgerwatch/erigon-lib/recsplit"
 	"github.com/ledgerwatch/erigon-lib/recsplit/eliasfano32"
@@ -58,9 +58,10 @@ func (ii *InvertedIndex) endTxNumMinimax() uint64 {
 func (ii *InvertedIndex) endIndexedTxNumMinimax() uint64 {
 	var max uint64
 	ii.files.Ascend(func(item *filesItem) bool {
-		if item.index != nil {
-			max = cmp.Max(max, item.endTxNum)
+		if item.index == nil { // same as MakeContext: files after first not indexed are not visible
+			return false
 		}
+		max = cmp.Max(max, item.endTxNum)
 		return true
 	})
 	return max
@@ -68,6 +69,9 @@ func (ii *InvertedIndex) endIndexedTxNumMinimax() uint64 {
 
 func (h *History) endTxNumMinimax() uint64 {
 	minimax := h.InvertedIndex.endTxNumMinimax()
+	if h.indexOnly.Load() { // .v files are not built anymore
+		return minimax
+	}
 	if max, ok := h.files.Max(); ok {
 		endTxNum := max.endTxNum
 		if minimax == 0 || endTxNum < minimax {
@@ -77,6 +81,9 @@ func (h *History) endTxNumMinimax() uint64 {
 	return minimax
 }
 func (h *History) endIndexedTxNumMinimax() uint64 {
+	if h.indexOnly.Load() { // .v files are not built anymore
+		return h.InvertedIndex.endIndexedTxNumMinimax()
+	}
 	var max uint64
 	h.files.Ascend(func(item *filesItem) bool {
 		if item.index == nil {
@@ -121,8 +128,7 @@ func (d *Domain) findMergeRange(maxEndTxNum, maxSpan uint64) DomainRanges {
 			return false
 		}
 		endStep := item.endTxNum / d.aggregationStep
-		spanStep := endStep & -endStep // Extract rightmost bit in the binary representation of endStep, this corresponds to size of maximally possible merge ending at endStep
-		span := cmp.Min(spanStep*d.aggregationStep, maxSpan)
+		span := cmp.Min(mergeSpan(endStep, d.freezeTiers)*d.aggregationStep, maxSpan)
 		start := item.endTxNum - span
 		if start < item.startTxNum {
 			if !r.values || start < r.valuesStartTxNum {
@@ -228,8 +234,7 @@ func (ii *InvertedIndex) findMergeRange(maxEndTxNum, maxSpan uint64) (bool, uint
 			return false
 		}
 		endStep := item.endTxNum / ii.aggregationStep
-		spanStep := endStep & -endStep // Extract rightmost bit in the binary representation of endStep, this corresponds to size of maximally possible merge ending at endStep
-		span := cmp.Min(spanStep*ii.aggregationStep, maxSpan)
+		span := cmp.Min(mergeSpan(endStep, ii.freezeTiers)*ii.aggregationStep, maxSpan)
 		start := item.endTxNum - span
 		if start < item.startTxNum {
 			if !minFound || start < startTxNum {
@@ -308,13 +313,15 @@ func (r HistoryRanges) any() bool {
 func (h *History) findMergeRange(maxEndTxNum, maxSpan uint64) HistoryRanges {
 	var r HistoryRanges
 	r.index, r.indexStartTxNum, r.indexEndTxNum = h.InvertedIndex.findMergeRange(maxEndTxNum, maxSpan)
+	if h.indexOnly.Load() { // existing .v files are not merged: merge only idx
+		return r
+	}
 	h.files.Ascend(func(item *filesItem) bool {
 		if item.endTxNum > maxEndTxNum {
 			return false
 		}
 		endStep := item.endTxNum / h.aggregationStep
-		spanStep := endStep & -endStep // Extract rightmost bit in the binary representation of endStep, this corresponds to size of maximally possible merge ending at endStep
-		span := cmp.Min(spanStep*h.aggregationStep, maxSpan)
+		span := cmp.Min(mergeSpan(endStep, h.freezeTiers)*h.aggregationStep, maxSpan)
 		start := item.endTxNum - span
 		if start < item.startTxNum {
 			if !r.history || start < r.historyStartTxNum {
@@ -504,18 +511,24 @@ func (d *Domain) mergeFiles(ctx context.Context, valuesFiles, indexFiles, histor
 	}
 	if r.values {
 		log.Info(fmt.Sprintf("[snapshots] merge: %s.%d-%d.kv", d.filenameBase, r.valuesStartTxNum/d.aggregationStep, r.valuesEndTxNum/d.aggregationStep))
-		for _, f := range valuesFiles {
-			defer f.decompressor.EnableMadvNormal().DisableReadAhead()
+		inputs, release, err := d.mergeIO.inputs(valuesFiles)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("merge %s open inputs: %w", d.filenameBase, err)
 		}
+		defer release()
 
-		datPath := filepath.Join(d.dir, fmt.Sprintf("%s.%d-%d.kv", d.filenameBase, r.valuesStartTxNum/d.aggregationStep, r.valuesEndTxNum/d.aggregationStep))
+		datPath, err := d.stagingPath(fmt.Sprintf("%s.%d-%d.kv", d.filenameBase, r.valuesStartTxNum/d.aggregationStep, r.valuesEndTxNum/d.aggregationStep))
+		if err != nil {
+			return nil, nil, nil, err
+		}
 		if comp, err = compress.NewCompressor(ctx, "merge", datPath, d.tmpdir, compress.MinPatternScore, workers, log.LvlTrace); err != nil {
 			return nil, nil, nil, fmt.Errorf("merge %s history compressor: %w", d.filenameBase, err)
 		}
+		comp.SetMetadata(d.fileMeta("kv", r.valuesStartTxNum, r.valuesEndTxNum))
 		var cp CursorHeap
 		heap.Init(&cp)
-		for _, item := range valuesFiles {
-			g := item.decompressor.MakeGetter()
+		for i, item := range valuesFiles {
+			g := inputs[i].MakeGetter()
 			g.Reset(0)
 			if g.HasNext() {
 				key, _ := g.NextUncompressed()
@@ -560,6 +573,9 @@ func (d *Domain) mergeFiles(ctx context.Context, valuesFiles, indexFiles, histor
 					heap.Pop(&cp)
 				}
 			}
+			if err = d.mergeIO.throttle(ctx, len(lastKey)+len(lastVal)); err != nil {
+				return nil, nil, nil, err
+			}
 			var skip bool
 			if d.prefixLen > 0 {
 				skip = r.valuesStartTxNum == 0 && len(lastVal) == 0 && len(lastKey) != d.prefixLen
@@ -607,7 +623,10 @@ func (d *Domain) mergeFiles(ctx context.Context, valuesFiles, indexFiles, histor
 		}
 		comp.Close()
 		comp = nil
-		idxPath := filepath.Join(d.dir, fmt.Sprintf("%s.%d-%d.kvi", d.filenameBase, r.valuesStartTxNum/d.aggregationStep, r.valuesEndTxNum/d.aggregationStep))
+		idxPath, err := d.stagingPath(fmt.Sprintf("%s.%d-%d.kvi", d.filenameBase, r.valuesStartTxNum/d.aggregationStep, r.valuesEndTxNum/d.aggregationStep))
+		if err != nil {
+			return nil, nil, nil, err
+		}
 		valuesIn = &filesItem{startTxNum: r.valuesStartTxNum, endTxNum: r.valuesEndTxNum}
 		if valuesIn.decompressor, err = compress.NewDecompressor(datPath); err != nil {
 			return nil, nil, nil, fmt.Errorf("merge %s decompressor [%d-%d]: %w", d.filenameBase, r.valuesStartTxNum, r.valuesEndTxNum, err)
@@ -616,6 +635,9 @@ func (d *Domain) mergeFiles(ctx context.Context, valuesFiles, indexFiles, histor
 		if valuesIn.index, err = buildIndex(ctx, valuesIn.decompressor, idxPath, d.tmpdir, keyCount, false /* values */); err != nil {
 			return nil, nil, nil, fmt.Errorf("merge %s buildIndex [%d-%d]: %w", d.filenameBase, r.valuesStartTxNum, r.valuesEndTxNum, err)
 		}
+		if err = publishFiles(d.dir, []**compress.Decompressor{&valuesIn.decompressor}, []**recsplit.Index{&valuesIn.index}); err != nil {
+			return nil, nil, nil, fmt.Errorf("merge %s publish [%d-%d]: %w", d.filenameBase, r.valuesStartTxNum, r.valuesEndTxNum, err)
+		}
 	}
 	closeItem = false
 	d.stats.MergesCount++
@@ -624,15 +646,16 @@ func (d *Domain) mergeFiles(ctx context.Context, valuesFiles, indexFiles, histor
 }
 
 func (ii *InvertedIndex) mergeFiles(ctx context.Context, files []*filesItem, startTxNum, endTxNum uint64, workers int) (*filesItem, error) {
-	for _, h := range files {
-		defer h.decompressor.EnableMadvNormal().DisableReadAhead()
+	inputs, release, err := ii.mergeIO.inputs(files)
+	if err != nil {
+		return nil, fmt.Errorf("merge %s open inputs: %w", ii.filenameBase, err)
 	}
+	defer release()
 	log.Info(fmt.Sprintf("[snapshots] merge: %s.%d-%d.ef", ii.filenameBase, startTxNum/ii.aggregationStep, endTxNum/ii.aggregationStep))
 
 	var outItem *filesItem
 	var comp *compress.Compressor
 	var decomp *compress.Decompressor
-	var err error
 	var closeItem = true
 	defer func() {
 		if closeItem {
@@ -653,14 +676,18 @@ func (ii *InvertedIndex) mergeFiles(ctx context.Context, files []*filesItem, sta
 			}
 		}
 	}()
-	datPath := filepath.Join(ii.dir, fmt.Sprintf("%s.%d-%d.ef", ii.filenameBase, startTxNum/ii.aggregationStep, endTxNum/ii.aggregationStep))
+	datPath, err := ii.stagingPath(fmt.Sprintf("%s.%d-%d.ef", ii.filenameBase, startTxNum/ii.aggregationStep, endTxNum/ii.aggregationStep))
+	if err != nil {
+		return nil, err
+	}
 	if comp, err = compress.NewCompressor(ctx, "Snapshots merge", datPath, ii.tmpdir, compress.MinPatternScore, workers, log.LvlTrace); err != nil {
 		return nil, fmt.Errorf("merge %s inverted index compressor: %w", ii.filenameBase, err)
 	}
+	comp.SetMetadata(ii.fileMeta("ef", startTxNum, endTxNum))
 	var cp CursorHeap
 	heap.Init(&cp)
-	for _, item := range files {
-		g := item.decompressor.MakeGetter()
+	for i, item := range files {
+		g := inputs[i].MakeGetter()
 		g.Reset(0)
 		if g.HasNext() {
 			key, _ := g.Next(nil)
@@ -709,6 +736,9 @@ func (ii *InvertedIndex) mergeFiles(ctx context.Context, files []*filesItem, sta
 				heap.Pop(&cp)
 			}
 		}
+		if err = ii.mergeIO.throttle(ctx, len(lastKey)+len(lastVal)); err != nil {
+			return nil, err
+		}
 		if keyBuf != nil {
 			if err = comp.AddUncompressedWord(keyBuf); err != nil {
 				return nil, err
@@ -735,7 +765,10 @@ func (ii *InvertedIndex) mergeFiles(ctx context.Context, files []*filesItem, sta
 	}
 	comp.Close()
 	comp = nil
-	idxPath := filepath.Join(ii.dir, fmt.Sprintf("%s.%d-%d.efi", ii.filenameBase, startTxNum/ii.aggregationStep, endTxNum/ii.aggregationStep))
+	idxPath, err := ii.stagingPath(fmt.Sprintf("%s.%d-%d.efi", ii.filenameBase, startTxNum/ii.aggregationStep, endTxNum/ii.aggregationStep))
+	if err != nil {
+		return nil, err
+	}
 	outItem = &filesItem{startTxNum: startTxNum, endTxNum: endTxNum}
 	if outItem.decompressor, err = compress.NewDecompressor(datPath); err != nil {
 		return nil, fmt.Errorf("merge %s decompressor [%d-%d]: %w", ii.filenameBase, startTxNum, endTxNum, err)
@@ -743,6 +776,9 @@ func (ii *InvertedIndex) mergeFiles(ctx context.Context, files []*filesItem, sta
 	if outItem.index, err = buildIndex(ctx, outItem.decompressor, idxPath, ii.tmpdir, keyCount, false /* values */); err != nil {
 		return nil, fmt.Errorf("merge %s buildIndex [%d-%d]: %w", ii.filenameBase, startTxNum, endTxNum, err)
 	}
+	if err = publishFiles(ii.dir, []**compress.Decompressor{&outItem.decompressor}, []**recsplit.Index{&outItem.index}); err != nil {
+		return nil, fmt.Errorf("merge %s publish [%d-%d]: %w", ii.filenameBase, startTxNum, endTxNum, err)
+	}
 	closeItem = false
 	return outItem, nil
 }
@@ -765,12 +801,16 @@ func (h *History) mergeFiles(ctx context.Context, indexFiles, historyFiles []*fi
 	}
 	if r.history {
 		log.Info(fmt.Sprintf("[snapshots] merge: %s.%d-%d.v", h.filenameBase, r.historyStartTxNum/h.aggregationStep, r.historyEndTxNum/h.aggregationStep))
-		for _, f := range indexFiles {
-			defer f.decompressor.EnableMadvNormal().DisableReadAhead()
+		idxInputs, releaseIdx, err := h.mergeIO.inputs(indexFiles)
+		if err != nil {
+			return nil, nil, fmt.Errorf("merge %s open inputs: %w", h.filenameBase, err)
 		}
-		for _, f := range historyFiles {
-			defer f.decompressor.EnableMadvNormal().DisableReadAhead()
+		defer releaseIdx()
+		histInputs, releaseHist, err := h.mergeIO.inputs(historyFiles)
+		if err != nil {
+			return nil, nil, fmt.Errorf("merge %s open inputs: %w", h.filenameBase, err)
 		}
+		defer releaseHist()
 
 		var comp *compress.Compressor
 		var decomp *compress.Decompressor
@@ -801,26 +841,33 @@ func (h *History) mergeFiles(ctx context.Context, indexFiles, historyFiles []*fi
 				}
 			}
 		}()
-		datPath := filepath.Join(h.dir, fmt.Sprintf("%s.%d-%d.v", h.filenameBase, r.historyStartTxNum/h.aggregationStep, r.historyEndTxNum/h.aggregationStep))
-		idxPath := filepath.Join(h.dir, fmt.Sprintf("%s.%d-%d.vi", h.filenameBase, r.historyStartTxNum/h.aggregationStep, r.historyEndTxNum/h.aggregationStep))
+		datPath, err := h.stagingPath(fmt.Sprintf("%s.%d-%d.v", h.filenameBase, r.historyStartTxNum/h.aggregationStep, r.historyEndTxNum/h.aggregationStep))
+		if err != nil {
+			return nil, nil, err
+		}
+		idxPath, err := h.stagingPath(fmt.Sprintf("%s.%d-%d.vi", h.filenameBase, r.historyStartTxNum/h.aggregationStep, r.historyEndTxNum/h.aggregationStep))
+		if err != nil {
+			return nil, nil, err
+		}
 		if comp, err = compress.NewCompressor(ctx, "merge", datPath, h.tmpdir, compress.MinPatternScore, workers, log.LvlTrace); err != nil {
 			return nil, nil, fmt.Errorf("merge %s history compressor: %w", h.filenameBase, err)
 		}
+		comp.SetMetadata(h.fileMeta("v", r.historyStartTxNum, r.historyEndTxNum))
 		var cp CursorHeap
 		heap.Init(&cp)
-		for _, item := range indexFiles {
-			g := item.decompressor.MakeGetter()
+		for i, item := range indexFiles {
+			g := idxInputs[i].MakeGetter()
 			g.Reset(0)
 			if g.HasNext() {
 				var g2 *compress.Getter
-				for _, hi := range historyFiles { // full-scan, because it's ok to have different amount files. by unclean-shutdown.
+				for j, hi := range historyFiles { // full-scan, because it's ok to have different amount files. by unclean-shutdown.
 					if hi.startTxNum == item.startTxNum && hi.endTxNum == item.endTxNum {
-						g2 = hi.decompressor.MakeGetter()
+						g2 = histInputs[j].MakeGetter()
 						break
 					}
 				}
 				if g2 == nil {
-					panic(fmt.Sprintf("for file: %s, not found corresponding file to merge", g.FileName()))
+					return nil, nil, newFileCorruptedError(g.FileName(), fmt.Errorf("not found corresponding %s file to merge", h.filenameBase))
 				}
 				key, _ := g.NextUncompressed()
 				val, _ := g.NextUncompressed()
@@ -850,7 +897,7 @@ func (h *History) mergeFiles(ctx context.Context, indexFiles, historyFiles []*fi
 				count := eliasfano32.Count(ci1.val)
 				for i := uint64(0); i < count; i++ {
 					if !ci1.dg2.HasNext() {
-						panic(fmt.Errorf("assert: no value??? %s, i=%d, count=%d, lastKey=%x, ci1.key=%x", ci1.dg2.FileName(), i, count, lastKey, ci1.key))
+						return nil, nil, newFileCorruptedError(ci1.dg2.FileName(), fmt.Errorf("no value: i=%d, count=%d, lastKey=%x, ci1.key=%x", i, count, lastKey, ci1.key))
 					}
 
 					if h.compressVals {
@@ -866,6 +913,9 @@ func (h *History) mergeFiles(ctx context.Context, indexFiles, historyFiles []*fi
 					}
 				}
 				keyCount += int(count)
+				if err = h.mergeIO.throttle(ctx, len(ci1.key)+len(ci1.val)+len(valBuf)*int(count)); err != nil {
+					return nil, nil, err
+				}
 				if ci1.dg.HasNext() {
 					ci1.key, _ = ci1.dg.NextUncompressed()
 					ci1.val, _ = ci1.dg.NextUncompressed()
@@ -884,12 +934,13 @@ func (h *History) mergeFiles(ctx context.Context, indexFiles, historyFiles []*fi
 			return nil, nil, err
 		}
 		if rs, err = recsplit.NewRecSplit(recsplit.RecSplitArgs{
-			KeyCount:   keyCount,
-			Enums:      false,
-			BucketSize: 2000,
-			LeafSize:   8,
-			TmpDir:     h.tmpdir,
-			IndexFile:  idxPath,
+			KeyCount:     keyCount,
+			Enums:        false,
+			BucketSize:   2000,
+			LeafSize:     8,
+			TmpDir:       h.tmpdir,
+			IndexFile:    idxPath,
+			Fingerprints: dbg.IndexFingerprints(),
 		}); err != nil {
 			return nil, nil, fmt.Errorf("create recsplit: %w", err)
 		}
@@ -939,6 +990,9 @@ func (h *History) mergeFiles(ctx context.Context, indexFiles, historyFiles []*fi
 		if index, err = recsplit.OpenIndex(idxPath); err != nil {
 			return nil, nil, fmt.Errorf("open %s idx: %w", h.filenameBase, err)
 		}
+		if err = publishFiles(h.dir, []**compress.Decompressor{&decomp}, []**recsplit.Index{&index}); err != nil {
+			return nil, nil, fmt.Errorf("merge %s publish: %w", h.filenameBase, err)
+		}
 		historyIn = &filesItem{startTxNum: r.historyStartTxNum, endTxNum: r.historyEndTxNum, decompressor: decomp, index: index}
 		closeItem = false
 	}
@@ -953,6 +1007,7 @@ func (d *Domain) integrateMergedFiles(valuesOuts, indexOuts, historyOuts []*file
 	}
 	d.History.integrateMergedFiles(indexOuts, historyOuts, indexIn, historyIn)
 	d.files.ReplaceOrInsert(valuesIn)
+	d.fds.touch(valuesIn)
 	for _, out := range valuesOuts {
 		if out == nil {
 			panic("must not happen")
@@ -968,29 +1023,32 @@ func (ii *InvertedIndex) integrateMergedFiles(outs []*filesItem, in *filesItem)
 		return
 	}
 	ii.files.ReplaceOrInsert(in)
+	ii.fds.touch(in)
 	for _, out := range outs {
 		if out == nil {
 			panic("must not happen: " + ii.filenameBase)
 		}
 		ii.files.Delete(out)
-		out.decompressor.Close()
-		out.index.Close()
+		out.canDelete = true
+		out.closeFilesIfUnused()
 	}
+	ii.localityIndex.warmUpdate([]*filesItem{in}, outs)
 }
 
 func (h *History) integrateMergedFiles(indexOuts, historyOuts []*filesItem, indexIn, historyIn *filesItem) {
-	if historyIn == nil {
+	h.InvertedIndex.integrateMergedFiles(indexOuts, indexIn)
+	if historyIn == nil { // only idx merged, see SetIndexOnly
 		return
 	}
-	h.InvertedIndex.integrateMergedFiles(indexOuts, indexIn)
 	h.files.ReplaceOrInsert(historyIn)
+	h.fds.touch(historyIn)
 	for _, out := range historyOuts {
 		if out == nil {
 			panic("must not happen: " + h.filenameBase)
 		}
 		h.files.Delete(out)
-		out.decompressor.Close()
-		out.index.Close()
+		out.canDelete = true
+		out.closeFilesIfUnused()
 	}
 }
 
@@ -1003,26 +1061,25 @@ func (d *Domain) deleteFiles(valuesOuts, indexOuts, historyOuts []*filesItem) er
 		out.index.Close()
 
 		datPath := filepath.Join(d.dir, fmt.Sprintf("%s.%d-%d.kv", d.filenameBase, out.startTxNum/d.aggregationStep, out.endTxNum/d.aggregationStep))
-		if err := os.Remove(datPath); err != nil {
+		if err := d.fsys().Remove(datPath); err != nil {
 			return err
 		}
 		idxPath := filepath.Join(d.dir, fmt.Sprintf("%s.%d-%d.kvi", d.filenameBase, out.startTxNum/d.aggregationStep, out.endTxNum/d.aggregationStep))
-		_ = os.Remove(idxPath) // may not exist
+		_ = d.fsys().Remove(idxPath) // may not exist
 	}
 	return nil
 }
 
+// deleteFiles - removes files of `outs`, already closed by integrateMergedFiles or used by contexts: mmap of removed
+// file stays valid till close
 func (ii *InvertedIndex) deleteFiles(outs []*filesItem) error {
 	for _, out := range outs {
-		out.decompressor.Close()
-		out.index.Close()
-
 		datPath := filepath.Join(ii.dir, fmt.Sprintf("%s.%d-%d.ef", ii.filenameBase, out.startTxNum/ii.aggregationStep, out.endTxNum/ii.aggregationStep))
-		if err := os.Remove(datPath); err != nil {
+		if err := ii.fsys().Remove(datPath); err != nil {
 			return err
 		}
 		idxPath := filepath.Join(ii.dir, fmt.Sprintf("%s.%d-%d.efi", ii.filenameBase, out.startTxNum/ii.aggregationStep, out.endTxNum/ii.aggregationStep))
-		_ = os.Remove(idxPath) // may not exist
+		_ = ii.fsys().Remove(idxPath) // may not exist
 	}
 	return nil
 }
@@ -1032,29 +1089,35 @@ func (h *History) deleteFiles(indexOuts, historyOuts []*filesItem) error {
 		return err
 	}
 	for _, out := range historyOuts {
-		out.decompressor.Close()
-		out.index.Close()
-
 		datPath := filepath.Join(h.dir, fmt.Sprintf("%s.%d-%d.v", h.filenameBase, out.startTxNum/h.aggregationStep, out.endTxNum/h.aggregationStep))
-		if err := os.Remove(datPath); err != nil {
+		if err := h.fsys().Remove(datPath); err != nil {
 			return err
 		}
 		idxPath := filepath.Join(h.dir, fmt.Sprintf("%s.%d-%d.vi", h.filenameBase, out.startTxNum/h.aggregationStep, out.endTxNum/h.aggregationStep))
-		_ = os.Remove(idxPath) // may not exist
+		_ = h.fsys().Remove(idxPath) // may not exist
 	}
 	return nil
 }
 
-func (li *LocalityIndex) deleteFiles(out *filesItem) error {
+func (li *LocalityIndex) deleteFiles(out *filesItem, outGroups []*localityGroup) error {
 	if out == nil || out.index == nil {
 		return nil
 	}
 	out.index.Close()
+	for _, g := range outGroups {
+		g.Close()
+	}
 	if li.file != nil && out.endTxNum == li.file.endTxNum { //paranoic protection against delettion of current file
 		return nil
 	}
 
-	idxPath := filepath.Join(li.dir, fmt.Sprintf("%s.%d-%d.li", li.filenameBase, out.startTxNum/li.aggregationStep, out.endTxNum/li.aggregationStep))
-	_ = os.Remove(idxPath) // may not exist
+	fromStep, toStep := out.startTxNum/li.aggregationStep, out.endTxNum/li.aggregationStep
+	idxPath := filepath.Join(li.dir, fmt.Sprintf("%s.%d-%d.li", li.filenameBase, fromStep, toStep))
+	_ = li.fsys().Remove(idxPath) // may not exist
+	for g := range outGroups {
+		gIdxPath, gBmPath := li.groupFilePaths(fromStep, toStep, uint64(g))
+		_ = li.fsys().Remove(gIdxPath) // may not exist
+		_ = li.fsys().Remove(gBmPath)
+	}
 	return nil
 }
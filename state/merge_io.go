@@ -0,0 +1,110 @@
+/*
+   Copyright 2022 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package state
+
+import (
+	"context"
+
+	"github.com/ledgerwatch/erigon-lib/compress"
+	"golang.org/x/time/rate"
+)
+
+const mergeIOChunk = 256 * 1024
+
+// MergeIO - IO scheduling of merges. Merge inputs are often same files which serve foreground reads (RPC, execution),
+// and merge can thrash their page-cache and madvise hints:
+//   - readBytesPerSecond - limits speed of merge reads. 0 - unlimited.
+//   - isolated - merge reads inputs through own mmap of each file: madvise(SEQUENTIAL) of merge doesn't change
+//     access-pattern hints of mapping used by readers. Costs one more open+mmap of each input.
+//
+// nil *MergeIO is valid and means: unlimited and not isolated (default behavior).
+type MergeIO struct {
+	limiter  *rate.Limiter
+	isolated bool
+}
+
+func NewMergeIO(readBytesPerSecond int, isolated bool) *MergeIO {
+	m := &MergeIO{isolated: isolated}
+	if readBytesPerSecond > 0 {
+		burst := readBytesPerSecond
+		if burst < mergeIOChunk {
+			burst = mergeIOChunk
+		}
+		m.limiter = rate.NewLimiter(rate.Limit(readBytesPerSecond), burst)
+	}
+	return m
+}
+
+// throttle - must be called by merge after reading of n bytes
+func (m *MergeIO) throttle(ctx context.Context, n int) error {
+	if m == nil || m.limiter == nil || n <= 0 {
+		return nil
+	}
+	for n > 0 {
+		chunk := n
+		if chunk > m.limiter.Burst() {
+			chunk = m.limiter.Burst()
+		}
+		if err := m.limiter.WaitN(ctx, chunk); err != nil {
+			return err
+		}
+		n -= chunk
+	}
+	return nil
+}
+
+// inputs - decompressors to read merge inputs from. `release` must be called after merge.
+func (m *MergeIO) inputs(files []*filesItem) (ds []*compress.Decompressor, release func(), err error) {
+	ds = make([]*compress.Decompressor, len(files))
+	if m == nil || !m.isolated {
+		for i, item := range files {
+			ds[i] = item.decompressor.EnableMadvNormal()
+		}
+		return ds, func() {
+			for _, d := range ds {
+				d.DisableReadAhead()
+			}
+		}, nil
+	}
+
+	release = func() {
+		for _, d := range ds {
+			if d != nil {
+				d.Close()
+			}
+		}
+	}
+	for i, item := range files {
+		if ds[i], err = compress.NewDecompressor(item.decompressor.FilePath()); err != nil {
+			release()
+			return nil, nil, err
+		}
+		ds[i].EnableReadAhead()
+	}
+	return ds, release, nil
+}
+
+// SetMergeIO - applies IO scheduling to all future merges
+func (a *AggregatorV3) SetMergeIO(m *MergeIO) {
+	a.accounts.mergeIO = m
+	a.storage.mergeIO = m
+	a.code.mergeIO = m
+	a.logAddrs.mergeIO = m
+	a.logTopics.mergeIO = m
+	a.tracesFrom.mergeIO = m
+	a.tracesTo.mergeIO = m
+}
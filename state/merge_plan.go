@@ -0,0 +1,106 @@
+/*
+   Copyright 2022 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package state
+
+import "fmt"
+
+// MergePlan - one merge which `MergeLoop` would do, but not executed. For capacity planning tools and dashboards.
+// Merge only drops duplicated keys, so sum of inputs is used as upper bound estimate of output size.
+type MergePlan struct {
+	Entity                string // "accounts", "storage", "code", "logaddrs", ...
+	Kind                  string // "idx" (.ef + .efi) or "hist" (.v + .vi)
+	StartTxNum, EndTxNum  uint64
+	InputFiles            []string
+	InputSize             int64
+	EstimatedOutputSize   int64
+	EstimatedReadBytes    int64
+	EstimatedWrittenBytes int64
+	StartStep, EndStep    uint64
+}
+
+func (p MergePlan) String() string {
+	return fmt.Sprintf("%s.%s %d-%d: files=%d, in=%d, out~%d", p.Entity, p.Kind, p.StartStep, p.EndStep, len(p.InputFiles), p.InputSize, p.EstimatedOutputSize)
+}
+
+// PlanMerges - returns merges which next step of `MergeLoop` will do, without executing them.
+// maxSpan is in txNums, as in `findMergeRange`
+func (a *AggregatorV3) PlanMerges(maxSpan uint64) []MergePlan {
+	r := a.findMergeRange(a.endIndexedTxNum(), maxSpan)
+	if !r.any() {
+		return nil
+	}
+	return a.planMerges(r, a.staticFilesInRange(r))
+}
+
+func (a *AggregatorV3) planMerges(r RangesV3, sf SelectedStaticFilesV3) []MergePlan {
+	var plans []MergePlan
+	plans = a.accounts.planMerge(plans, r.accounts, sf.accountsIdx, sf.accountsHist)
+	plans = a.storage.planMerge(plans, r.storage, sf.storageIdx, sf.storageHist)
+	plans = a.code.planMerge(plans, r.code, sf.codeIdx, sf.codeHist)
+	plans = a.logAddrs.planMerge(plans, r.logAddrs, r.logAddrsStartTxNum, r.logAddrsEndTxNum, sf.logAddrs)
+	plans = a.logTopics.planMerge(plans, r.logTopics, r.logTopicsStartTxNum, r.logTopicsEndTxNum, sf.logTopics)
+	plans = a.tracesFrom.planMerge(plans, r.tracesFrom, r.tracesFromStartTxNum, r.tracesFromEndTxNum, sf.tracesFrom)
+	plans = a.tracesTo.planMerge(plans, r.tracesTo, r.tracesToStartTxNum, r.tracesToEndTxNum, sf.tracesTo)
+	return plans
+}
+
+func (ii *InvertedIndex) planMerge(plans []MergePlan, need bool, startTxNum, endTxNum uint64, files []*filesItem) []MergePlan {
+	if !need {
+		return plans
+	}
+	p := MergePlan{Entity: ii.filenameBase, Kind: "idx", StartTxNum: startTxNum, EndTxNum: endTxNum,
+		StartStep: startTxNum / ii.aggregationStep, EndStep: endTxNum / ii.aggregationStep}
+	p.addInputs(files)
+	// .ef files read once, .efi built by one more pass over new .ef
+	p.EstimatedOutputSize = p.InputSize
+	p.EstimatedReadBytes = p.InputSize + p.EstimatedOutputSize
+	p.EstimatedWrittenBytes = p.EstimatedOutputSize
+	return append(plans, p)
+}
+
+func (h *History) planMerge(plans []MergePlan, r HistoryRanges, indexFiles, historyFiles []*filesItem) []MergePlan {
+	plans = h.InvertedIndex.planMerge(plans, r.index, r.indexStartTxNum, r.indexEndTxNum, indexFiles)
+	if !r.history {
+		return plans
+	}
+	p := MergePlan{Entity: h.filenameBase, Kind: "hist", StartTxNum: r.historyStartTxNum, EndTxNum: r.historyEndTxNum,
+		StartStep: r.historyStartTxNum / h.aggregationStep, EndStep: r.historyEndTxNum / h.aggregationStep}
+	p.addInputs(historyFiles)
+	p.EstimatedOutputSize = p.InputSize
+	// merge of .v files also walks over .ef files of same range - to build .vi
+	p.EstimatedReadBytes = p.InputSize + p.EstimatedOutputSize
+	for _, item := range indexFiles {
+		if item.decompressor != nil {
+			p.EstimatedReadBytes += item.decompressor.Size()
+		}
+	}
+	p.EstimatedWrittenBytes = p.EstimatedOutputSize
+	return append(plans, p)
+}
+
+func (p *MergePlan) addInputs(files []*filesItem) {
+	for _, item := range files {
+		if item.decompressor != nil {
+			p.InputFiles = append(p.InputFiles, item.decompressor.FileName())
+			p.InputSize += item.decompressor.Size()
+		}
+		if item.index != nil {
+			p.InputFiles = append(p.InputFiles, item.index.FileName())
+			p.InputSize += item.index.Size()
+		}
+	}
+}
@@ -60,6 +60,27 @@ func TestFindMergeRangeMustHandleAbsenseOfSomeFiles(t *testing.T) {
 	})
 }
 
+func TestFindMergeRangeFreezeTiers(t *testing.T) {
+	require.Equal(t, uint64(4), mergeSpan(12, nil))
+	require.Equal(t, uint64(2), mergeSpan(12, []uint64{2, 8})) // 4 is not tier - stays 2
+	require.Equal(t, uint64(8), mergeSpan(16, []uint64{2, 8})) // biggest tier <= 16
+	require.Equal(t, uint64(1), mergeSpan(3, []uint64{2, 8}))  // below first tier - binary
+	require.Equal(t, uint64(2), mergeSpan(2, []uint64{2, 8}))
+
+	ii := &InvertedIndex{aggregationStep: 1, files: btree.NewG[*filesItem](32, filesItemLess), freezeTiers: []uint64{2, 8}}
+	for step := uint64(0); step < 8; step += 2 {
+		ii.files.ReplaceOrInsert(&filesItem{startTxNum: step, endTxNum: step + 2})
+	}
+	found, from, to := ii.findMergeRange(8, 32)
+	require.True(t, found)
+	require.Equal(t, uint64(0), from)
+	require.Equal(t, uint64(8), to)
+
+	ii.files.Delete(&filesItem{startTxNum: 6, endTxNum: 8})
+	found, _, _ = ii.findMergeRange(8, 32) // 0-6 is not tier
+	require.False(t, found)
+}
+
 func Test_mergeEliasFano(t *testing.T) {
 	t.Skip()
 
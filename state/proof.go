@@ -0,0 +1,144 @@
+/*
+   Copyright 2023 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package state
+
+import (
+	"fmt"
+
+	"github.com/holiman/uint256"
+	"golang.org/x/crypto/sha3"
+
+	"github.com/ledgerwatch/erigon-lib/commitment"
+	"github.com/ledgerwatch/erigon-lib/common"
+	"github.com/ledgerwatch/erigon-lib/common/length"
+	"github.com/ledgerwatch/erigon-lib/kv"
+)
+
+// AccountProof - result of eth_getProof: account fields, root of it's storage trie and Merkle proofs (RLP encoded
+// trie nodes from the root) of account and of requested storage slots. Absent account has empty fields and proof of
+// absence.
+type AccountProof struct {
+	Address      []byte
+	Nonce        uint64
+	Balance      uint256.Int
+	CodeHash     []byte
+	StorageHash  []byte
+	AccountProof [][]byte
+	StorageProof []StorageProof
+}
+
+type StorageProof struct {
+	Key   []byte
+	Value []byte // nil if slot is empty
+	Proof [][]byte
+}
+
+// GetProof - proofs of account `addr` and it's `storageKeys` as of beginning of `txNum` (same as ReadAccountDataBeforeTxNum):
+// trie is read from history of commitment domain, accounts and storage - from db and from files. Proof verifies
+// against state root committed before txNum, then txNum must be first txNum of block.
+func (ac *AggregatorContext) GetProof(addr []byte, storageKeys [][]byte, txNum uint64, roTx kv.Tx) (*AccountProof, error) {
+	keccak := sha3.NewLegacyKeccak256()
+	branchFn := func(prefix []byte) ([]byte, error) {
+		v, err := ac.ReadCommitmentBeforeTxNum(prefix, txNum, roTx)
+		if err != nil {
+			return nil, fmt.Errorf("failed read branch %x: %w", commitment.CompactedKeyToHex(prefix), err)
+		}
+		if len(v) < 2 {
+			return nil, nil
+		}
+		return v[2:], nil // Skip touchMap but keep afterMap
+	}
+	accountFn := func(plainKey []byte, cell *commitment.Cell) error {
+		encAccount, err := ac.ReadAccountDataBeforeTxNum(plainKey, txNum, roTx)
+		if err != nil {
+			return err
+		}
+		cell.Nonce = 0
+		cell.Balance.Clear()
+		copy(cell.CodeHash[:], commitment.EmptyCodeHash)
+		if len(encAccount) > 0 {
+			nonce, balance, chash := DecodeAccountBytes(encAccount)
+			cell.Nonce = nonce
+			cell.Balance.Set(balance)
+			if chash != nil {
+				copy(cell.CodeHash[:], chash)
+			}
+		}
+		code, err := ac.ReadAccountCodeBeforeTxNum(plainKey, txNum, roTx)
+		if err != nil {
+			return err
+		}
+		if code != nil {
+			keccak.Reset()
+			keccak.Write(code)
+			copy(cell.CodeHash[:], keccak.Sum(nil))
+		}
+		cell.Delete = len(encAccount) == 0 && len(code) == 0
+		return nil
+	}
+	storageFn := func(plainKey []byte, cell *commitment.Cell) error {
+		enc, err := ac.ReadAccountStorageBeforeTxNum(plainKey[:length.Addr], plainKey[length.Addr:], txNum, roTx)
+		if err != nil {
+			return err
+		}
+		cell.StorageLen = len(enc)
+		copy(cell.Storage[:], enc)
+		cell.Delete = cell.StorageLen == 0
+		return nil
+	}
+
+	plainKeys := make([][]byte, 0, 1+len(storageKeys))
+	plainKeys = append(plainKeys, addr)
+	for _, loc := range storageKeys {
+		plainKeys = append(plainKeys, append(common.Copy(addr), loc...))
+	}
+	hashedKeys := make([][]byte, len(plainKeys))
+	for i, k := range plainKeys {
+		hashedKeys[i] = hashAndNibblizeKey(keccak, k)
+	}
+	trie := commitment.NewHexPatriciaHashed(length.Addr, branchFn, accountFn, storageFn)
+	proofs, err := trie.ProveKeys(plainKeys, hashedKeys)
+	if err != nil {
+		return nil, fmt.Errorf("get proof %x at txNum %d: %w", addr, txNum, err)
+	}
+
+	res := &AccountProof{
+		Address:      common.Copy(addr),
+		CodeHash:     common.Copy(commitment.EmptyCodeHash),
+		StorageHash:  common.Copy(commitment.EmptyRootHash),
+		AccountProof: proofs[0].Nodes,
+	}
+	if !proofs[0].Absent {
+		var cell commitment.Cell
+		if err = accountFn(addr, &cell); err != nil {
+			return nil, err
+		}
+		res.Nonce, res.CodeHash, res.StorageHash = cell.Nonce, common.Copy(cell.CodeHash[:]), proofs[0].StorageRoot
+		res.Balance.Set(&cell.Balance)
+	}
+	for i, loc := range storageKeys {
+		sp := StorageProof{Key: common.Copy(loc), Proof: proofs[1+i].Nodes}
+		if !proofs[1+i].Absent {
+			if sp.Value, err = ac.ReadAccountStorageBeforeTxNum(addr, loc, txNum, roTx); err != nil {
+				return nil, err
+			}
+			sp.Value = common.Copy(sp.Value)
+		}
+		res.StorageProof = append(res.StorageProof, sp)
+	}
+	return res, nil
+}
@@ -0,0 +1,184 @@
+/*
+   Copyright 2022 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package state
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/sync/semaphore"
+)
+
+// ReadLane - priority of reads done by AggregatorV3Context, see WithLane
+type ReadLane uint8
+
+const (
+	// LaneInteractive - point reads of RPC, default lane. Never wait for background work.
+	LaneInteractive ReadLane = iota
+	// LaneBackground - maintenance (collate, cross-check): yields to in-flight interactive reads.
+	LaneBackground
+)
+
+func (l ReadLane) String() string {
+	switch l {
+	case LaneInteractive:
+		return "interactive"
+	case LaneBackground:
+		return "background"
+	default:
+		return "unknown"
+	}
+}
+
+// WithLane - reads done by context go through given lane. Contexts of background work (long scans) must use
+// LaneBackground to not compete with RPC point reads for disk and page cache.
+func WithLane(lane ReadLane) ContextOption {
+	return func(o *contextOptions) { o.lane = lane }
+}
+
+const (
+	interactiveReadSlots  = 1024
+	backgroundMaxYield    = 50 * time.Millisecond // background never stalls longer than this on one yield
+	backgroundYieldPeriod = time.Millisecond
+	backgroundYieldRows   = 4096             // rows of background scan between yields
+	backgroundYieldBudget = 10 * time.Second // total wait of one background scan (collation of one file)
+)
+
+// readLanes - interactive reads hold 1 unit of shared semaphore while reading, background work before each step
+// checks that whole weight is free (no interactive reads in flight) and otherwise waits (bounded by maxYield).
+// Background never takes semaphore by blocking Acquire - so interactive reads never queue behind it.
+// nil-safe, nil means "lanes are not used"
+type readLanes struct {
+	sem      *semaphore.Weighted
+	size     int64
+	maxYield time.Duration
+}
+
+func newReadLanes(slots int64, maxYield time.Duration) *readLanes {
+	return &readLanes{sem: semaphore.NewWeighted(slots), size: slots, maxYield: maxYield}
+}
+
+func noopRelease() {}
+
+// enter - start of interactive read, returned func must be called at the end of read
+func (l *readLanes) enter() func() {
+	if l == nil {
+		return noopRelease
+	}
+	if !l.sem.TryAcquire(1) {
+		if err := l.sem.Acquire(context.Background(), 1); err != nil {
+			return noopRelease
+		}
+	}
+	return l.release
+}
+
+func (l *readLanes) release() { l.sem.Release(1) }
+
+// yield - called by background work between steps: waits while interactive reads are in flight
+func (l *readLanes) yield(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	return l.yieldFor(ctx, l.maxYield)
+}
+
+func (l *readLanes) yieldFor(ctx context.Context, maxYield time.Duration) error {
+	if l.sem.TryAcquire(l.size) {
+		l.sem.Release(l.size)
+		return nil
+	}
+	timeout := time.NewTimer(maxYield)
+	defer timeout.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timeout.C:
+			return nil
+		case <-time.After(backgroundYieldPeriod):
+		}
+		if l.sem.TryAcquire(l.size) {
+			l.sem.Release(l.size)
+			return nil
+		}
+	}
+}
+
+// scanYielder - yields of one background scan: once per backgroundYieldRows rows, and no more than
+// backgroundYieldBudget in total - scan of big step is not stretched by busy RPC indefinitely.
+// nil-safe, nil means "lanes are not used"
+type scanYielder struct {
+	l      *readLanes
+	rows   int
+	budget time.Duration
+}
+
+func (l *readLanes) scan() *scanYielder {
+	if l == nil {
+		return nil
+	}
+	return &scanYielder{l: l, budget: backgroundYieldBudget}
+}
+
+// yield - called by scan on each row
+func (y *scanYielder) yield(ctx context.Context) error {
+	if y == nil || y.budget <= 0 {
+		return nil
+	}
+	if y.rows++; y.rows < backgroundYieldRows {
+		return nil
+	}
+	y.rows = 0
+	maxYield := y.l.maxYield
+	if maxYield > y.budget {
+		maxYield = y.budget
+	}
+	start := time.Now()
+	err := y.l.yieldFor(ctx, maxYield)
+	y.budget -= time.Since(start)
+	return err
+}
+
+// SetBackgroundMaxYield - how long background work (collate, cross-check) may wait for in-flight interactive reads
+// on each yield. Collate yields once per batch of rows, see scanYielder. 0 - background doesn't yield.
+func (a *AggregatorV3) SetBackgroundMaxYield(d time.Duration) {
+	if d <= 0 {
+		a.lanes = nil
+	} else {
+		a.lanes = newReadLanes(interactiveReadSlots, d)
+	}
+	if a.accounts == nil { // files are not opened yet
+		return
+	}
+	a.applyReadLanes()
+}
+
+func (a *AggregatorV3) applyReadLanes() {
+	for _, ii := range []*InvertedIndex{a.accounts.InvertedIndex, a.storage.InvertedIndex, a.code.InvertedIndex, a.logAddrs, a.logTopics, a.tracesFrom, a.tracesTo} {
+		ii.lanes = a.lanes
+	}
+}
+
+// enterRead - start of point read: interactive context takes slot of lane, background one yields
+func (ac *AggregatorV3Context) enterRead() func() {
+	if ac.lane == LaneBackground {
+		_ = ac.a.lanes.yield(ac.a.ctx)
+		return noopRelease
+	}
+	return ac.a.lanes.enter()
+}
@@ -0,0 +1,128 @@
+/*
+   Copyright 2023 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package state
+
+import (
+	"go.uber.org/atomic"
+
+	"github.com/ledgerwatch/erigon-lib/kv"
+)
+
+// History reads answer "value before first change at or after txNum". Sources are disjoint by txNum: files have
+// changes of [0, endTxNum of files), DB has changes of [endTxNum of files, ...) - prune deletes from DB only what is
+// in files, and last keepInDB+aggregationStep txs are never moved to files. Then:
+//  - txNum >= endTxNum of files: files can't have answer - go to DB directly
+//  - found in files: DB can't have earlier change - don't go to DB
+//  - not found in files: answer is first change in DB (or in not flushed writes, see SetReadPending)
+
+// ReadSourceStats - how many reads of history values were answered by each source
+type ReadSourceStats struct {
+	Files, DB, Pending uint64
+	NotFound           uint64
+	FilesSkipped       uint64 // reads which didn't touch files because txNum is after end of files
+}
+
+type readSourceCounters struct {
+	files, db, pending, notFound, filesSkipped atomic.Uint64
+}
+
+func (c *readSourceCounters) stats() ReadSourceStats {
+	return ReadSourceStats{
+		Files:        c.files.Load(),
+		DB:           c.db.Load(),
+		Pending:      c.pending.Load(),
+		NotFound:     c.notFound.Load(),
+		FilesSkipped: c.filesSkipped.Load(),
+	}
+}
+
+// ReadSourceStats - since start of process
+func (h *History) ReadSourceStats() ReadSourceStats { return h.readSources.stats() }
+
+// ReadSourceStats - filenameBase (accounts, storage, code) -> stats, see History.ReadSourceStats
+func (a *AggregatorV3) ReadSourceStats() map[string]ReadSourceStats {
+	res := map[string]ReadSourceStats{}
+	for _, h := range []*History{a.accounts, a.storage, a.code} {
+		res[h.filenameBase] = h.ReadSourceStats()
+	}
+	return res
+}
+
+// filesMayHave - false if files of context can't have changes at or after txNum
+func (hc *HistoryContext) filesMayHave(txNum uint64) bool {
+	if txNum < hc.filesEndTxNum {
+		return true
+	}
+	hc.h.readSources.filesSkipped.Inc()
+	return false
+}
+
+// ValueSourceKind - source which answered history read, see ValueSource
+type ValueSourceKind string
+
+const (
+	ValueSourceNone    ValueSourceKind = ""        // not found: value is in current state
+	ValueSourceFile    ValueSourceKind = "file"    // .v file
+	ValueSourceDB      ValueSourceKind = "db"      // history tables of DB
+	ValueSourcePending ValueSourceKind = "pending" // not flushed writes, see SetReadPending
+)
+
+// ValueSource - where value returned by GetNoStateDebug came from. For investigation of wrong-value reports
+// (serializable, to be returned by diagnostics RPC as is).
+type ValueSource struct {
+	Kind   ValueSourceKind `json:"kind"`
+	TxNum  uint64          `json:"txNum,omitempty"`  // txNum of change found in index (value is the one before it)
+	File   string          `json:"file,omitempty"`   // Kind=file: name of .v file
+	Offset uint64          `json:"offset,omitempty"` // Kind=file: offset of value in File
+	Table  string          `json:"table,omitempty"`  // Kind=db: values table
+	ValNum uint64          `json:"valNum,omitempty"` // Kind=db: key of value in Table (0 - empty value, not stored)
+}
+
+// GetNoStateDebug - GetNoStateWithRecent which also returns location of value
+func (hc *HistoryContext) GetNoStateDebug(key []byte, txNum uint64, roTx kv.Tx) (v []byte, ok bool, src ValueSource, err error) {
+	v, ok, err = hc.getNoStateWithRecent(key, txNum, roTx, &src)
+	if err != nil || !ok {
+		return nil, ok, ValueSource{}, err
+	}
+	return v, true, src, nil
+}
+
+func (ac *AggregatorV3Context) ReadAccountDataNoStateDebug(addr []byte, txNum uint64) ([]byte, bool, ValueSource, error) {
+	if err := ac.checkGeneration(); err != nil {
+		return nil, false, ValueSource{}, err
+	}
+	defer ac.enterRead()()
+	return ac.accounts.GetNoStateDebug(addr, txNum, ac.tx)
+}
+
+func (ac *AggregatorV3Context) ReadAccountStorageNoStateDebug(addr []byte, loc []byte, txNum uint64) ([]byte, bool, ValueSource, error) {
+	if err := ac.checkGeneration(); err != nil {
+		return nil, false, ValueSource{}, err
+	}
+	defer ac.enterRead()()
+	key := make([]byte, 0, len(addr)+len(loc))
+	key = append(append(key, addr...), loc...)
+	return ac.storage.GetNoStateDebug(key, txNum, ac.tx)
+}
+
+func (ac *AggregatorV3Context) ReadAccountCodeNoStateDebug(addr []byte, txNum uint64) ([]byte, bool, ValueSource, error) {
+	if err := ac.checkGeneration(); err != nil {
+		return nil, false, ValueSource{}, err
+	}
+	defer ac.enterRead()()
+	return ac.code.GetNoStateDebug(addr, txNum, ac.tx)
+}
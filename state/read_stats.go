@@ -0,0 +1,134 @@
+/*
+   Copyright 2022 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package state
+
+import (
+	"sync"
+
+	"go.uber.org/atomic"
+)
+
+// ContextOption - optional parameters of `AggregatorV3.MakeContext`
+type ContextOption func(o *contextOptions)
+
+type contextOptions struct {
+	label string
+	lane  ReadLane
+}
+
+// WithLabel - reads done by context will be accounted under given label (usually name of RPC method: "eth_getLogs").
+// Allows attribute cost of files reads to API consumers on shared archive nodes. See `AggregatorV3.ReadStats`.
+func WithLabel(label string) ContextOption {
+	return func(o *contextOptions) { o.label = label }
+}
+
+// EntityReadStats - reads from files of one entity: amount of index lookups and decompressed bytes
+type EntityReadStats struct {
+	Lookups uint64
+	Bytes   uint64
+}
+
+// readCounters - nil-safe, nil means "reads are not accounted"
+type readCounters struct {
+	lookups, bytes atomic.Uint64
+}
+
+func (c *readCounters) lookup(words ...[]byte) {
+	if c == nil {
+		return
+	}
+	c.lookups.Inc()
+	var n int
+	for _, w := range words {
+		n += len(w)
+	}
+	c.bytes.Add(uint64(n))
+}
+
+func (c *readCounters) read(words ...[]byte) {
+	if c == nil {
+		return
+	}
+	var n int
+	for _, w := range words {
+		n += len(w)
+	}
+	c.bytes.Add(uint64(n))
+}
+
+// readStats - counters by label, then by entity (filenameBase)
+type readStats struct {
+	lock    sync.RWMutex
+	byLabel map[string]map[string]*readCounters
+}
+
+func newReadStats() *readStats {
+	return &readStats{byLabel: map[string]map[string]*readCounters{}}
+}
+
+func (s *readStats) forLabel(label string, entities ...string) map[string]*readCounters {
+	s.lock.RLock()
+	counters, ok := s.byLabel[label]
+	s.lock.RUnlock()
+	if ok {
+		return counters
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if counters, ok = s.byLabel[label]; ok {
+		return counters
+	}
+	counters = make(map[string]*readCounters, len(entities))
+	for _, entity := range entities {
+		counters[entity] = &readCounters{}
+	}
+	s.byLabel[label] = counters
+	return counters
+}
+
+func (s *readStats) snapshot() map[string]map[string]EntityReadStats {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	res := make(map[string]map[string]EntityReadStats, len(s.byLabel))
+	for label, counters := range s.byLabel {
+		byEntity := make(map[string]EntityReadStats, len(counters))
+		for entity, c := range counters {
+			byEntity[entity] = EntityReadStats{Lookups: c.lookups.Load(), Bytes: c.bytes.Load()}
+		}
+		res[label] = byEntity
+	}
+	return res
+}
+
+// ReadStats - label -> entity -> reads from files. Only contexts created with `WithLabel` are accounted.
+func (a *AggregatorV3) ReadStats() map[string]map[string]EntityReadStats {
+	return a.readStats.snapshot()
+}
+
+func (a *AggregatorV3) setReadCounters(ac *AggregatorV3Context, label string) {
+	counters := a.readStats.forLabel(label,
+		a.accounts.filenameBase, a.storage.filenameBase, a.code.filenameBase,
+		a.logAddrs.filenameBase, a.logTopics.filenameBase, a.tracesFrom.filenameBase, a.tracesTo.filenameBase)
+	ac.accounts.stats = counters[a.accounts.filenameBase]
+	ac.storage.stats = counters[a.storage.filenameBase]
+	ac.code.stats = counters[a.code.filenameBase]
+	ac.logAddrs.stats = counters[a.logAddrs.filenameBase]
+	ac.logTopics.stats = counters[a.logTopics.filenameBase]
+	ac.tracesFrom.stats = counters[a.tracesFrom.filenameBase]
+	ac.tracesTo.stats = counters[a.tracesTo.filenameBase]
+}
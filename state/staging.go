@@ -0,0 +1,146 @@
+/*
+   Copyright 2023 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package state
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+
+	"github.com/ledgerwatch/erigon-lib/compress"
+	"github.com/ledgerwatch/erigon-lib/recsplit"
+)
+
+// Files of step (or merge) are built in staging dir (in tmpdir) and moved to dir by publishFiles - only after
+// their indices are built. Then ReopenFiles never sees half-written files or data files without index after crash -
+// except of AggregatorV3.SetDeferIndices mode, where data files are published alone and indexed by BuildDeferredIndices.
+// Names of files include step range - builds of different steps/merges don't collide in staging dir.
+
+// stagingPath - path of file `fName` in staging dir
+func (ii *InvertedIndex) stagingPath(fName string) (string, error) {
+	dir := filepath.Join(ii.tmpdir, "staging")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("create staging dir %s: %w", dir, err)
+	}
+	return filepath.Join(dir, fName), nil
+}
+
+// publishFiles - moves built files from staging dir to `dir` and fsyncs `dir`: indices first - data file is never
+// visible without index. Opened decompressors/indices are reopened from new paths (on error they are nil).
+func publishFiles(dir string, decomps []**compress.Decompressor, idxs []**recsplit.Index) (err error) {
+	var idxPaths, decompPaths []string
+	for _, idx := range idxs {
+		if *idx == nil {
+			idxPaths = append(idxPaths, "")
+			continue
+		}
+		idxPaths = append(idxPaths, (*idx).FilePath())
+		(*idx).Close()
+		*idx = nil
+	}
+	for _, d := range decomps {
+		if *d == nil {
+			decompPaths = append(decompPaths, "")
+			continue
+		}
+		decompPaths = append(decompPaths, (*d).FilePath())
+		(*d).Close()
+		*d = nil
+	}
+	for _, paths := range [][]string{idxPaths, decompPaths} {
+		for i, path := range paths {
+			if path == "" {
+				continue
+			}
+			target := filepath.Join(dir, filepath.Base(path))
+			if err = moveFile(path, target); err != nil {
+				return err
+			}
+			paths[i] = target
+		}
+	}
+	if err = fsyncDir(dir); err != nil {
+		return err
+	}
+	for i, path := range idxPaths {
+		if path == "" {
+			continue
+		}
+		if *idxs[i], err = recsplit.OpenIndex(path); err != nil {
+			return err
+		}
+	}
+	for i, path := range decompPaths {
+		if path == "" {
+			continue
+		}
+		if *decomps[i], err = compress.NewDecompressor(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// moveFile - rename, or copy if tmpdir and dir are on different drives
+func moveFile(from, to string) error {
+	err := os.Rename(from, to)
+	if err == nil || !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	tmpPath := to + ".tmp"
+	defer os.Remove(tmpPath)
+	dst, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+	if _, err = io.Copy(dst, src); err != nil {
+		return err
+	}
+	if err = dst.Sync(); err != nil {
+		return err
+	}
+	if err = dst.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmpPath, to); err != nil {
+		return err
+	}
+	return os.Remove(from)
+}
+
+// fsyncDir - makes renames in `dir` durable
+func fsyncDir(dir string) error {
+	if runtime.GOOS == "windows" { // directories can't be opened for sync
+		return nil
+	}
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
@@ -0,0 +1,203 @@
+/*
+   Copyright 2022 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package state
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sync"
+
+	"github.com/ledgerwatch/erigon-lib/kv"
+	"golang.org/x/exp/slices"
+)
+
+// StateChangesListener - notified about plain keys of accounts (addr) and storage (addr+location) changed by
+// AggregatorV3.Flush and AggregatorV3.Unwind. kvcache.Coherent implements it: caches of rpcdaemon drop only changed
+// keys and stay warm across blocks. Notified by AggregatorV3.NotifyStateChanges - after commit of tx of Flush/Unwind,
+// same as OnNewBlock: listener never sees state versions which may be rolled back.
+type StateChangesListener interface {
+	OnStateChanges(stateVersionID uint64, keys [][]byte)
+}
+
+// StateChangesBatchListener - optional extension of StateChangesListener, for push of StateChanges stream: on each
+// Flush and Unwind (even without changed keys) receives current state version, aggTxNum (changes of txNums < aggTxNum
+// are in db) and names of entities (see filenameBase) which have writes in this Flush or were unwound - kvcache and
+// temporal readers advance their views by it without polling.
+type StateChangesBatchListener interface {
+	OnStateChangeBatch(stateVersionID, aggTxNum uint64, flushedEntities []string)
+}
+
+// changedKeys - keys changed since last notification. nil-safe, nil means "nobody listens"
+type changedKeys struct {
+	lock sync.Mutex
+	keys map[string]struct{}
+}
+
+func newChangedKeys() *changedKeys { return &changedKeys{keys: map[string]struct{}{}} }
+
+func (c *changedKeys) add(key1, key2 []byte) {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.keys[string(key1)+string(key2)] = struct{}{}
+}
+
+// take - sorted keys changed since previous take
+func (c *changedKeys) take() [][]byte {
+	if c == nil {
+		return nil
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	keys := make([][]byte, 0, len(c.keys))
+	for k := range c.keys {
+		keys = append(keys, []byte(k))
+	}
+	c.keys = map[string]struct{}{}
+	slices.SortFunc(keys, func(a, b []byte) bool { return bytes.Compare(a, b) < 0 })
+	return keys
+}
+
+// SetStateChangesListener - on each Flush and Unwind `l` receives changed keys with new state version:
+// version (kv.PlainStateVersion in kv.Sequence) is incremented in same tx. nil - no notifications (default).
+func (a *AggregatorV3) SetStateChangesListener(l StateChangesListener) {
+	a.changesListener = l
+	if l == nil {
+		a.changes, a.changesPending = nil, nil
+	} else {
+		a.changes, a.changesPending = newChangedKeys(), &pendingStateChanges{}
+	}
+}
+
+// NotifyStateChanges - delivers to listener state changes of Flush/Unwind since previous call. Must be called after
+// successful commit of tx passed to Flush/Unwind (same as OnNewBlock of kvcache is driven).
+func (a *AggregatorV3) NotifyStateChanges() {
+	batchListener, _ := a.changesListener.(StateChangesBatchListener)
+	for _, n := range a.changesPending.take() {
+		if len(n.keys) > 0 {
+			a.changesListener.OnStateChanges(n.version, n.keys)
+		}
+		if batchListener != nil {
+			batchListener.OnStateChangeBatch(n.version, n.aggTxNum, n.entities)
+		}
+	}
+}
+
+// DiscardStateChanges - tx of Flush/Unwind was rolled back: drops not delivered notifications. Versions of them
+// were rolled back too and will be reused - listener never saw them.
+func (a *AggregatorV3) DiscardStateChanges() {
+	a.changesPending.take()
+}
+
+type stateChangesNotification struct {
+	version  uint64
+	keys     [][]byte
+	aggTxNum uint64
+	entities []string
+}
+
+// flushedEntities - names of entities with writes, in order of first flush
+type flushedEntities []string
+
+// entityFlusher - flusher of entity which knows if it has writes
+type entityFlusher interface {
+	entity() (name string, written bool)
+}
+
+func (e *flushedEntities) add(f flusher) {
+	ef, ok := f.(entityFlusher)
+	if !ok {
+		return
+	}
+	if name, written := ef.entity(); written && !slices.Contains(*e, name) {
+		*e = append(*e, name)
+	}
+}
+
+// unwoundEntities - names of entities which have records to delete by Unwind to txUnwindTo
+func (a *AggregatorV3) unwoundEntities(txUnwindTo uint64) (flushedEntities, error) {
+	if _, ok := a.changesListener.(StateChangesBatchListener); !ok {
+		return nil, nil
+	}
+	var res flushedEntities
+	for _, ii := range []*InvertedIndex{a.accounts.InvertedIndex, a.storage.InvertedIndex, a.commitment.InvertedIndex, a.logAddrs, a.logTopics, a.tracesFrom, a.tracesTo} {
+		has, err := ii.hasTxNumsFrom(a.rwTx, txUnwindTo)
+		if err != nil {
+			return nil, err
+		}
+		if has {
+			res = append(res, ii.filenameBase)
+		}
+	}
+	return res, nil
+}
+
+// pendingStateChanges - notifications of not committed yet Flush/Unwind. nil-safe, nil means "nobody listens"
+type pendingStateChanges struct {
+	lock sync.Mutex
+	list []stateChangesNotification
+}
+
+func (p *pendingStateChanges) add(n stateChangesNotification) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.list = append(p.list, n)
+}
+
+func (p *pendingStateChanges) take() []stateChangesNotification {
+	if p == nil {
+		return nil
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	list := p.list
+	p.list = nil
+	return list
+}
+
+// stageStateChanges - increments state version in `tx` and queues notification till NotifyStateChanges.
+// Changes of txNums < aggTxNum are in `tx`
+func (a *AggregatorV3) stageStateChanges(tx kv.RwTx, keys [][]byte, aggTxNum uint64, entities flushedEntities) error {
+	if a.changesListener == nil {
+		return nil
+	}
+	batchListener, _ := a.changesListener.(StateChangesBatchListener)
+	if len(keys) == 0 && batchListener == nil {
+		return nil
+	}
+	v, err := tx.GetOne(kv.Sequence, kv.PlainStateVersion)
+	if err != nil {
+		return err
+	}
+	var version uint64
+	if len(v) == 8 {
+		version = binary.BigEndian.Uint64(v)
+	}
+	if len(keys) > 0 {
+		version++
+		var versionBytes [8]byte
+		binary.BigEndian.PutUint64(versionBytes[:], version)
+		if err = tx.Put(kv.Sequence, kv.PlainStateVersion, versionBytes[:]); err != nil {
+			return err
+		}
+	}
+	n := stateChangesNotification{version: version, keys: keys, aggTxNum: aggTxNum, entities: entities}
+	a.changesPending.add(n)
+	return nil
+}
@@ -0,0 +1,208 @@
+/*
+   Copyright 2023 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package state
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ledgerwatch/erigon-lib/common"
+	"github.com/ledgerwatch/erigon-lib/kv"
+)
+
+// StateDelta - changes of domains between txNums: keys changed by txs [FromTxNum, ToTxNum) with their values as of
+// ToTxNum. Node which has state as of FromTxNum catches up to ToTxNum by ApplyStateDelta - without replay of blocks.
+// Commitment domain is part of delta: branches are applied as is, without re-computation.
+type StateDelta struct {
+	FromTxNum, ToTxNum uint64
+	Accounts           []DeltaEntry // sorted by key
+	Storage            []DeltaEntry
+	Code               []DeltaEntry
+	Commitment         []DeltaEntry
+}
+
+// DeltaEntry - empty Value means key was deleted
+type DeltaEntry struct {
+	Key, Value []byte
+}
+
+func (d *StateDelta) domains() [4]*[]DeltaEntry {
+	return [4]*[]DeltaEntry{&d.Accounts, &d.Storage, &d.Code, &d.Commitment}
+}
+
+// StateDelta - builds delta between `fromTxNum` and `toTxNum` from history of domains
+func (ac *AggregatorContext) StateDelta(fromTxNum, toTxNum uint64, roTx kv.Tx) (*StateDelta, error) {
+	if fromTxNum >= toTxNum {
+		return nil, fmt.Errorf("state delta: empty range %d-%d", fromTxNum, toTxNum)
+	}
+	d := &StateDelta{FromTxNum: fromTxNum, ToTxNum: toTxNum}
+	for i, dc := range []*DomainContext{ac.accounts, ac.storage, ac.code, ac.commitment} {
+		entries, err := dc.delta(fromTxNum, toTxNum, roTx)
+		if err != nil {
+			return nil, err
+		}
+		*d.domains()[i] = entries
+	}
+	return d, nil
+}
+
+func (dc *DomainContext) delta(fromTxNum, toTxNum uint64, roTx kv.Tx) ([]DeltaEntry, error) {
+	it := dc.hc.IterateChanged(fromTxNum, toTxNum, roTx)
+	defer it.Close()
+	var entries []DeltaEntry
+	for it.HasNext() {
+		k, _, err := it.Next()
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) > 0 && bytes.Equal(entries[len(entries)-1].Key, k) {
+			continue
+		}
+		key := common.Copy(k)
+		v, err := dc.GetBeforeTxNum(key, toTxNum, roTx)
+		if err != nil {
+			return nil, fmt.Errorf("state delta %s: %x: %w", dc.d.filenameBase, key, err)
+		}
+		entries = append(entries, DeltaEntry{Key: key, Value: common.Copy(v)})
+	}
+	return entries, nil
+}
+
+// ApplyStateDelta - writes values of delta at txNum ToTxNum-1. Must be called after SetTx and StartWrites, state of
+// aggregator must be as of FromTxNum. Caller Flushes and commits tx.
+func (a *Aggregator) ApplyStateDelta(d *StateDelta) error {
+	if d.FromTxNum >= d.ToTxNum {
+		return fmt.Errorf("apply state delta: empty range %d-%d", d.FromTxNum, d.ToTxNum)
+	}
+	a.SetTxNum(d.ToTxNum - 1)
+	for i, dom := range []*Domain{a.accounts, a.storage, a.code, a.commitment.Domain} {
+		for _, e := range *d.domains()[i] {
+			var err error
+			if len(e.Value) == 0 {
+				err = dom.Delete(e.Key, nil)
+			} else {
+				err = dom.Put(e.Key, nil, e.Value)
+			}
+			if err != nil {
+				return fmt.Errorf("apply state delta %s: %x: %w", dom.filenameBase, e.Key, err)
+			}
+		}
+	}
+	return nil
+}
+
+var stateDeltaMagic = []byte("erigon-state-delta-v1")
+
+var ErrStateDeltaFormat = errors.New("invalid state delta format")
+
+// WriteTo - compact binary encoding: magic, txNums, then per domain - count and length-prefixed keys and values
+func (d *StateDelta) WriteTo(w io.Writer) (int64, error) {
+	bw := bufio.NewWriter(w)
+	var n int64
+	var numBuf [binary.MaxVarintLen64]byte
+	writeNum := func(x uint64) error {
+		l, err := bw.Write(numBuf[:binary.PutUvarint(numBuf[:], x)])
+		n += int64(l)
+		return err
+	}
+	writeBytes := func(b []byte) error {
+		if err := writeNum(uint64(len(b))); err != nil {
+			return err
+		}
+		l, err := bw.Write(b)
+		n += int64(l)
+		return err
+	}
+	l, err := bw.Write(stateDeltaMagic)
+	n += int64(l)
+	if err != nil {
+		return n, err
+	}
+	if err = writeNum(d.FromTxNum); err != nil {
+		return n, err
+	}
+	if err = writeNum(d.ToTxNum); err != nil {
+		return n, err
+	}
+	for _, entries := range d.domains() {
+		if err = writeNum(uint64(len(*entries))); err != nil {
+			return n, err
+		}
+		for _, e := range *entries {
+			if err = writeBytes(e.Key); err != nil {
+				return n, err
+			}
+			if err = writeBytes(e.Value); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, bw.Flush()
+}
+
+// ReadStateDelta - decodes encoding of StateDelta.WriteTo
+func ReadStateDelta(r io.Reader) (*StateDelta, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(stateDeltaMagic))
+	if _, err := io.ReadFull(br, magic); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrStateDeltaFormat, err)
+	}
+	if !bytes.Equal(magic, stateDeltaMagic) {
+		return nil, fmt.Errorf("%w: unknown magic %q", ErrStateDeltaFormat, magic)
+	}
+	readBytes := func() ([]byte, error) {
+		l, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, err
+		}
+		if l > 1<<30 {
+			return nil, fmt.Errorf("%w: too long word %d", ErrStateDeltaFormat, l)
+		}
+		b := make([]byte, l)
+		_, err = io.ReadFull(br, b)
+		return b, err
+	}
+	d := &StateDelta{}
+	var err error
+	if d.FromTxNum, err = binary.ReadUvarint(br); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrStateDeltaFormat, err)
+	}
+	if d.ToTxNum, err = binary.ReadUvarint(br); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrStateDeltaFormat, err)
+	}
+	for _, entries := range d.domains() {
+		cnt, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrStateDeltaFormat, err)
+		}
+		for i := uint64(0); i < cnt; i++ {
+			var e DeltaEntry
+			if e.Key, err = readBytes(); err != nil {
+				return nil, fmt.Errorf("%w: %s", ErrStateDeltaFormat, err)
+			}
+			if e.Value, err = readBytes(); err != nil {
+				return nil, fmt.Errorf("%w: %s", ErrStateDeltaFormat, err)
+			}
+			*entries = append(*entries, e)
+		}
+	}
+	return d, nil
+}
@@ -0,0 +1,192 @@
+/*
+   Copyright 2022 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package temporaltests - conformance suite of kv.TemporalTx implementations (embedded over AggregatorV3, remote):
+// DomainGet/HistoryGet at boundaries of changes, IndexRange in both orders with limits. Implementation fills
+// fixture in its own way (see Setup) and must pass Run, same as kv/kvtests for plain kv.
+package temporaltests
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ledgerwatch/erigon-lib/kv"
+	"github.com/ledgerwatch/erigon-lib/kv/iter"
+	"github.com/ledgerwatch/erigon-lib/kv/order"
+)
+
+// Names - entities of implementation under test: Domain of keys, its History and inverted Index
+type Names struct {
+	Domain  kv.Domain
+	History kv.History
+	Index   kv.InvertedIdx
+}
+
+// Change - Key was changed at Ts, Prev - value before change (empty - key didn't exist)
+type Change struct {
+	Ts        uint64
+	Key, Prev []byte
+}
+
+// Setup - fresh db with given history: `changes` ordered by Ts, `latest` - values after last change
+// (and values of keys without changes)
+type Setup func(t *testing.T, changes []Change, latest map[string][]byte) kv.TemporalRoDb
+
+var (
+	key1 = bytes.Repeat([]byte{1}, 20) // changed at 2, 5, 9
+	key2 = bytes.Repeat([]byte{2}, 20) // created at 5
+	key3 = bytes.Repeat([]byte{3}, 20) // no history
+	key4 = bytes.Repeat([]byte{4}, 20) // doesn't exist
+)
+
+var changes = []Change{
+	{Ts: 2, Key: key1},
+	{Ts: 5, Key: key1, Prev: []byte("a")},
+	{Ts: 5, Key: key2},
+	{Ts: 9, Key: key1, Prev: []byte("b")},
+}
+
+var latest = map[string][]byte{
+	string(key1): []byte("c"),
+	string(key2): []byte("x"),
+	string(key3): []byte("y"),
+}
+
+func Run(t *testing.T, names Names, setup Setup) {
+	db := setup(t, changes, latest)
+	t.Run("HistoryGet", func(t *testing.T) { testHistoryGet(t, db, names) })
+	t.Run("DomainGet", func(t *testing.T) { testDomainGet(t, db, names) })
+	t.Run("IndexRange", func(t *testing.T) { testIndexRange(t, db, names) })
+}
+
+func view(t *testing.T, db kv.TemporalRoDb, f func(tx kv.TemporalTx)) {
+	t.Helper()
+	require.NoError(t, db.ViewTemporal(context.Background(), func(tx kv.TemporalTx) error {
+		f(tx)
+		return nil
+	}))
+}
+
+// HistoryGet - value before first change at or after ts, ok=false - no changes since ts (read latest state)
+func testHistoryGet(t *testing.T, db kv.TemporalRoDb, names Names) {
+	view(t, db, func(tx kv.TemporalTx) {
+		for _, c := range []struct {
+			key    []byte
+			ts     uint64
+			expect string
+			ok     bool
+		}{
+			{key1, 0, "", true}, {key1, 2, "", true}, // didn't exist
+			{key1, 3, "a", true}, {key1, 5, "a", true},
+			{key1, 6, "b", true}, {key1, 9, "b", true},
+			{key1, 10, "", false},
+			{key2, 5, "", true}, {key2, 6, "", false},
+			{key3, 0, "", false}, {key4, 0, "", false},
+		} {
+			v, ok, err := tx.HistoryGet(names.History, c.key, c.ts)
+			require.NoError(t, err)
+			require.Equal(t, c.ok, ok, "key %x, ts %d", c.key[:1], c.ts)
+			require.Equal(t, c.expect, string(v), "key %x, ts %d", c.key[:1], c.ts)
+		}
+	})
+}
+
+// DomainGet - value of key at ts (before changes of ts), ok=false - no value
+func testDomainGet(t *testing.T, db kv.TemporalRoDb, names Names) {
+	view(t, db, func(tx kv.TemporalTx) {
+		for _, c := range []struct {
+			key    []byte
+			ts     uint64
+			expect string
+		}{
+			{key1, 0, ""}, {key1, 2, ""},
+			{key1, 3, "a"}, {key1, 5, "a"},
+			{key1, 6, "b"}, {key1, 9, "b"},
+			{key1, 10, "c"}, {key1, 1_000, "c"},
+			{key2, 5, ""}, {key2, 6, "x"},
+			{key3, 0, "y"}, {key4, 0, ""}, {key4, 1_000, ""},
+		} {
+			v, ok, err := tx.DomainGet(names.Domain, c.key, nil, c.ts)
+			require.NoError(t, err)
+			require.Equal(t, c.expect != "", ok, "key %x, ts %d", c.key[:1], c.ts)
+			require.Equal(t, c.expect, string(v), "key %x, ts %d", c.key[:1], c.ts)
+		}
+	})
+}
+
+func collect(it iter.U64, err error) ([]uint64, error) {
+	if err != nil {
+		return nil, err
+	}
+	var res []uint64
+	for it.HasNext() {
+		v, err := it.Next()
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, v)
+	}
+	return res, nil
+}
+
+// IndexRange - Asc: [from, to), Desc: (to, from] - `from` inclusive, `to` exclusive in both orders; -1 - unbounded
+func testIndexRange(t *testing.T, db kv.TemporalRoDb, names Names) {
+	view(t, db, func(tx kv.TemporalTx) {
+		for _, c := range []struct {
+			key      []byte
+			from, to int
+			asc      order.By
+			limit    int
+			expect   []uint64
+		}{
+			{key1, -1, -1, order.Asc, -1, []uint64{2, 5, 9}},
+			{key1, 2, 9, order.Asc, -1, []uint64{2, 5}},
+			{key1, 3, 10, order.Asc, -1, []uint64{5, 9}},
+			{key1, 4, 6, order.Asc, -1, []uint64{5}},
+			{key1, -1, 5, order.Asc, -1, []uint64{2}},
+			{key1, 5, -1, order.Asc, -1, []uint64{5, 9}},
+			{key1, 10, -1, order.Asc, -1, nil},
+			{key1, -1, -1, order.Asc, 2, []uint64{2, 5}},
+			{key1, 3, -1, order.Asc, 1, []uint64{5}},
+
+			{key1, -1, -1, order.Desc, -1, []uint64{9, 5, 2}},
+			{key1, 9, 2, order.Desc, -1, []uint64{9, 5}},
+			{key1, 8, -1, order.Desc, -1, []uint64{5, 2}},
+			{key1, 6, -1, order.Desc, -1, []uint64{5, 2}},
+			{key1, 5, 2, order.Desc, -1, []uint64{5}},
+			{key1, -1, 5, order.Desc, -1, []uint64{9}},
+			{key1, 1, -1, order.Desc, -1, nil},
+			{key1, -1, -1, order.Desc, 2, []uint64{9, 5}},
+
+			{key2, -1, -1, order.Asc, -1, []uint64{5}},
+			{key3, -1, -1, order.Asc, -1, nil},
+			{key4, -1, -1, order.Desc, -1, nil},
+		} {
+			res, err := collect(tx.IndexRange(names.Index, c.key, c.from, c.to, c.asc, c.limit))
+			require.NoError(t, err)
+			require.Equal(t, c.expect, res, "key %x, %d-%d, asc %t, limit %d", c.key[:1], c.from, c.to, c.asc, c.limit)
+		}
+
+		// range in wrong direction
+		_, err := collect(tx.IndexRange(names.Index, key1, 9, 2, order.Asc, -1))
+		require.Error(t, err)
+		_, err = collect(tx.IndexRange(names.Index, key1, 2, 9, order.Desc, -1))
+		require.Error(t, err)
+	})
+}
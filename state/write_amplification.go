@@ -0,0 +1,128 @@
+/*
+   Copyright 2023 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package state
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Write amplification of files: each step is read from DB by collate, written to tmpdir (compressor words and
+// files in staging dir), published as files - and then rewritten by every merge which includes it. Numbers are
+// collected since start of process and reported by Status - to choose aggregationStep of deployment.
+
+// stepIOHistory - amount of last built steps kept in report
+const stepIOHistory = 256
+
+// StepIOStatus - bytes moved by build of one step
+type StepIOStatus struct {
+	Step            uint64 `json:"step"`
+	DBReadBytes     uint64 `json:"dbReadBytes"`     // keys and values read from DB by collate
+	TmpWrittenBytes uint64 `json:"tmpWrittenBytes"` // words passed to compressors plus files built in staging dir
+	FilesBytes      uint64 `json:"filesBytes"`      // files of step (with indices, if not deferred)
+
+	// MergeAmplification - (bytes of built steps + bytes written by merges) / bytes of built steps, at end of build of this step
+	MergeAmplification float64 `json:"mergeAmplification"`
+}
+
+// buildIO - counters of one entity, shared by all steps: build takes difference before and after
+type buildIO struct {
+	dbRead     atomic.Uint64
+	compressed atomic.Uint64 // words passed to compressors
+}
+
+type writeAmplification struct {
+	lock        sync.Mutex
+	steps       []StepIOStatus
+	builtBytes  uint64
+	mergedBytes uint64
+}
+
+func (w *writeAmplification) stepBuilt(s StepIOStatus) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.builtBytes += s.FilesBytes
+	s.MergeAmplification = w.amplification()
+	if len(w.steps) == stepIOHistory {
+		w.steps = append(w.steps[:0], w.steps[1:]...)
+	}
+	w.steps = append(w.steps, s)
+}
+
+func (w *writeAmplification) merged(bytes uint64) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.mergedBytes += bytes
+}
+
+func (w *writeAmplification) amplification() float64 {
+	if w.builtBytes == 0 {
+		return 0
+	}
+	return float64(w.builtBytes+w.mergedBytes) / float64(w.builtBytes)
+}
+
+// report - copy of steps (oldest first) and current merge amplification
+func (w *writeAmplification) report() ([]StepIOStatus, float64) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	steps := make([]StepIOStatus, len(w.steps))
+	copy(steps, w.steps)
+	return steps, w.amplification()
+}
+
+// buildIOTotals - sums of counters of all entities
+func (a *AggregatorV3) buildIOTotals() (dbRead, compressed uint64) {
+	for _, ii := range []*InvertedIndex{a.accounts.InvertedIndex, a.storage.InvertedIndex, a.code.InvertedIndex, a.logAddrs, a.logTopics, a.tracesFrom, a.tracesTo} {
+		dbRead += ii.buildIO.dbRead.Load()
+		compressed += ii.buildIO.compressed.Load()
+	}
+	return dbRead, compressed
+}
+
+// filesSize - bytes of files of all entities which cover exactly [txFrom, txTo)
+func (a *AggregatorV3) filesSize(txFrom, txTo uint64) uint64 {
+	var size uint64
+	search := &filesItem{startTxNum: txFrom, endTxNum: txTo}
+	for _, h := range []*History{a.accounts, a.storage, a.code} {
+		if item, ok := h.files.Get(search); ok {
+			size += filesItemsSize(item)
+		}
+	}
+	for _, ii := range []*InvertedIndex{a.accounts.InvertedIndex, a.storage.InvertedIndex, a.code.InvertedIndex, a.logAddrs, a.logTopics, a.tracesFrom, a.tracesTo} {
+		if item, ok := ii.files.Get(search); ok {
+			size += filesItemsSize(item)
+		}
+	}
+	return size
+}
+
+func filesItemsSize(items ...*filesItem) uint64 {
+	var size int64
+	for _, item := range items {
+		if item == nil {
+			continue
+		}
+		if item.decompressor != nil {
+			size += item.decompressor.Size()
+		}
+		if item.index != nil {
+			size += item.index.Size()
+		}
+	}
+	return uint64(size)
+}
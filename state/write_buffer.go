@@ -0,0 +1,112 @@
+/*
+   Copyright 2023 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package state
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ledgerwatch/erigon-lib/common/metrics"
+	"github.com/ledgerwatch/erigon-lib/etl"
+)
+
+// Buffered writes (etl collectors of historyWAL and invertedIndexWAL) are accounted per entity: bytes of keys and
+// values collected since last Flush - by main wal of entity and by wals of all AggregatorWriter's. It's amount of data
+// next Flush will write to db, not ram usage: collectors spill sorted buffers to tmpdir and spilled bytes stay counted.
+
+var (
+	stateMetrics = metrics.GetOrCreateNamespace("state")
+)
+
+func walBufferedBytesMetric(entity string) *metrics.Counter {
+	return stateMetrics.Counter(fmt.Sprintf(`wal_buffered_bytes{entity="%s"}`, entity))
+}
+
+// walBytesAdd - called by wals of `ii` (history vals are accounted in it's inverted index)
+func (ii *InvertedIndex) walBytesAdd(n uint64) {
+	ii.walBytes.Add(n)
+	if ii.walBytesMetric != nil {
+		ii.walBytesMetric.Add(int(n))
+	}
+}
+
+func (ii *InvertedIndex) walBytesSub(n uint64) {
+	if n == 0 {
+		return
+	}
+	ii.walBytes.Sub(n)
+	if ii.walBytesMetric != nil {
+		ii.walBytesMetric.Add(-int(n))
+	}
+}
+
+// WriteBufferBytes - bytes buffered by not flushed writes of entity
+func (ii *InvertedIndex) WriteBufferBytes() uint64 { return ii.walBytes.Load() }
+
+// SetWriteBufferLimit - soft cap of bytes buffered by all entities between Flushes, 0 - unlimited (default).
+// When cap is exceeded: if `autoFlush` - Add* methods of aggregator Flush into tx of SetTx, otherwise caller checks
+// WriteBufferFull (for example after each tx) and Flush itself. AggregatorWriter's never Flush - they don't own tx.
+func (a *AggregatorV3) SetWriteBufferLimit(limit uint64, autoFlush bool) {
+	a.writeBufferLimit.Store(limit)
+	a.writeBufferAutoFlush.Store(autoFlush)
+}
+
+// SetWALProgress - `f` gets progress of etl collectors of buffered writes (`table` - target of collector): on each
+// spill to tmpdir and during Flush. Applied to writers created after call (StartWrites, Rotate). Collectors also
+// update etl metrics labeled by table, see etl.Collector.SetMetrics.
+func (a *AggregatorV3) SetWALProgress(f func(table string, p etl.Progress)) {
+	for _, ii := range a.writeBufferEntities() {
+		ii.walProgress = f
+	}
+}
+
+// WriteBufferBytes - entity => bytes buffered by not flushed writes
+func (a *AggregatorV3) WriteBufferBytes() map[string]uint64 {
+	res := make(map[string]uint64, 8)
+	for _, ii := range a.writeBufferEntities() {
+		res[ii.filenameBase] = ii.WriteBufferBytes()
+	}
+	return res
+}
+
+func (a *AggregatorV3) writeBufferEntities() [8]*InvertedIndex {
+	return [8]*InvertedIndex{a.accounts.InvertedIndex, a.storage.InvertedIndex, a.code.InvertedIndex, a.commitment.InvertedIndex, a.logAddrs, a.logTopics, a.tracesFrom, a.tracesTo}
+}
+
+// WriteBufferFull - buffered writes exceed limit of SetWriteBufferLimit, caller must Flush
+func (a *AggregatorV3) WriteBufferFull() bool {
+	limit := a.writeBufferLimit.Load()
+	if limit == 0 {
+		return false
+	}
+	var total uint64
+	for _, ii := range a.writeBufferEntities() {
+		total += ii.WriteBufferBytes()
+	}
+	return total > limit
+}
+
+// WriteBufferFull - see AggregatorV3.WriteBufferFull, writer shares limit with it's aggregator
+func (w *AggregatorWriter) WriteBufferFull() bool { return w.a.WriteBufferFull() }
+
+// checkWriteBuffer - after each write of aggregator: Flush if buffer is full and `autoFlush`, see SetWriteBufferLimit
+func (a *AggregatorV3) checkWriteBuffer() error {
+	if !a.writeBufferAutoFlush.Load() || a.rwTx == nil || !a.WriteBufferFull() {
+		return nil
+	}
+	return a.Flush(context.Background(), a.rwTx)
+}
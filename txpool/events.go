@@ -0,0 +1,169 @@
+/*
+   Copyright 2023 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package txpool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ledgerwatch/erigon-lib/common"
+)
+
+type EventType uint8
+
+const (
+	EventAdded    EventType = 0
+	EventRemoved  EventType = 1
+	EventReplaced EventType = 2 // Hash replaced ReplacedHash
+	EventMined    EventType = 3
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventAdded:
+		return "added"
+	case EventRemoved:
+		return "removed"
+	case EventReplaced:
+		return "replaced"
+	case EventMined:
+		return "mined"
+	default:
+		return fmt.Sprintf("unknown event type: %d", t)
+	}
+}
+
+type Event struct {
+	Seq          uint64
+	Type         EventType
+	Hash         [32]byte
+	Rlp          []byte        // EventAdded, EventReplaced
+	ReplacedHash [32]byte      // EventReplaced
+	Reason       DiscardReason // EventRemoved
+}
+
+// ErrEventsPruned - events from requested sequence are not in EventLog anymore (or never were - for example
+// sequence from before restart): subscriber must resync full pool content
+var ErrEventsPruned = errors.New("txpool events from requested sequence are not available")
+
+// EventLog - sequence-numbered journal of pool events: keeps last `size` events for replay, subscribers which
+// reconnect with next sequence after last received one don't miss events.
+// In-process API for now: gRPC stream of it needs new messages in ledgerwatch/interfaces txpool.proto.
+// Sequences start from unix-nanos of EventLog creation: sequences of previous process (before restart) are always
+// less than first sequence of new one - and their replay is rejected by ErrEventsPruned.
+type EventLog struct {
+	lock   sync.Mutex
+	events []Event // ring buffer
+	first  uint64  // sequence of oldest event in buffer
+	next   uint64  // sequence of next event
+	notify chan struct{}
+}
+
+const DefaultEventLogSize = 16_384
+
+func NewEventLog(size int) *EventLog {
+	seq := uint64(time.Now().UnixNano())
+	return &EventLog{events: make([]Event, size), first: seq, next: seq, notify: make(chan struct{})}
+}
+
+func (l *EventLog) append(e Event) {
+	if l == nil {
+		return
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	e.Seq = l.next
+	l.events[e.Seq%uint64(len(l.events))] = e
+	l.next++
+	if l.next-l.first > uint64(len(l.events)) {
+		l.first++
+	}
+	close(l.notify)
+	l.notify = make(chan struct{})
+}
+
+func (l *EventLog) added(mt *metaTx) {
+	if l == nil {
+		return
+	}
+	l.append(Event{Type: EventAdded, Hash: mt.Tx.IDHash, Rlp: common.Copy(mt.Tx.Rlp)})
+}
+
+func (l *EventLog) replaced(mt, replaced *metaTx) {
+	if l == nil {
+		return
+	}
+	l.append(Event{Type: EventReplaced, Hash: mt.Tx.IDHash, Rlp: common.Copy(mt.Tx.Rlp), ReplacedHash: replaced.Tx.IDHash})
+}
+
+func (l *EventLog) discarded(mt *metaTx, reason DiscardReason) {
+	switch reason {
+	case ReplacedByHigherTip: // see replaced
+	case Mined:
+		l.append(Event{Type: EventMined, Hash: mt.Tx.IDHash})
+	default:
+		l.append(Event{Type: EventRemoved, Hash: mt.Tx.IDHash, Reason: reason})
+	}
+}
+
+// NextSeq - sequence of next event
+func (l *EventLog) NextSeq() uint64 {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.next
+}
+
+// Subscribe - calls `f` for events starting from sequence `from` (0 - only new events) until `ctx` is done or `f`
+// returns error. Returns ErrEventsPruned if events from `from` are not available - also if subscriber is too slow
+// and events were overwritten before it read them.
+func (l *EventLog) Subscribe(ctx context.Context, from uint64, f func(Event) error) error {
+	var batch []Event
+	l.lock.Lock()
+	if from == 0 {
+		from = l.next
+	}
+	l.lock.Unlock()
+	for {
+		l.lock.Lock()
+		if from < l.first || from > l.next {
+			first, next := l.first, l.next
+			l.lock.Unlock()
+			return fmt.Errorf("%w: requested %d, available %d-%d", ErrEventsPruned, from, first, next)
+		}
+		batch = batch[:0]
+		for seq := from; seq < l.next; seq++ {
+			batch = append(batch, l.events[seq%uint64(len(l.events))])
+		}
+		notify := l.notify
+		l.lock.Unlock()
+
+		for _, e := range batch {
+			if err := f(e); err != nil {
+				return err
+			}
+			from = e.Seq + 1
+		}
+		select { // `notify` is already closed if new events were appended while `f` was working
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-notify:
+		}
+	}
+}
@@ -0,0 +1,223 @@
+/*
+   Copyright 2023 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package txpool
+
+import (
+	"math"
+	"sort"
+
+	"github.com/holiman/uint256"
+
+	"github.com/ledgerwatch/erigon-lib/types"
+)
+
+const (
+	baseFeeChangeDenominator = 8 // EIP-1559
+	elasticityMultiplier     = 2 // EIP-1559
+)
+
+// CalcNextBaseFee - EIP-1559 base fee of block which follows block with given baseFee, gasUsed and gasLimit
+func CalcNextBaseFee(baseFee, gasUsed, gasLimit uint64) uint64 {
+	target := gasLimit / elasticityMultiplier
+	if target == 0 || gasUsed == target {
+		return baseFee
+	}
+	var delta uint256.Int
+	if gasUsed > target {
+		delta.SetUint64(gasUsed - target)
+	} else {
+		delta.SetUint64(target - gasUsed)
+	}
+	delta.Mul(&delta, uint256.NewInt(baseFee))
+	delta.Div(&delta, uint256.NewInt(target))
+	delta.Div(&delta, uint256.NewInt(baseFeeChangeDenominator))
+	if gasUsed > target {
+		if delta.IsZero() {
+			delta.SetOne()
+		}
+		var next uint256.Int
+		if _, overflow := next.AddOverflow(uint256.NewInt(baseFee), &delta); overflow || !next.IsUint64() {
+			return baseFee
+		}
+		return next.Uint64()
+	}
+	if delta.Uint64() >= baseFee {
+		return 0
+	}
+	return baseFee - delta.Uint64()
+}
+
+// ProjectedBaseFee - base fee of block which follows pending block. Gas used by pending block is projected from
+// pending sub-pool: gas of txs which can pay pending base fee, but not more than block gas limit.
+func (p *TxPool) ProjectedBaseFee() uint64 {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	pendingBaseFee, blockGasLimit := p.pendingBaseFee.Load(), p.blockGasLimit.Load()
+	return CalcNextBaseFee(pendingBaseFee, p.pending.projectGasUsed(pendingBaseFee, blockGasLimit), blockGasLimit)
+}
+
+// Best - up to `n` txs of pending sub-pool ordered by effective tip at `projectedBaseFee` (use ProjectedBaseFee or
+// own estimate). Txs which can't pay `projectedBaseFee` are skipped. Pool ordering is not changed.
+func (p *TxPool) Best(n uint16, projectedBaseFee uint64) []*types.TxSlot {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	best := p.pending.bestAt(int(n), projectedBaseFee, p.blockGasLimit.Load())
+	res := make([]*types.TxSlot, len(best))
+	for i, mt := range best {
+		res[i] = mt.Tx
+	}
+	return res
+}
+
+func (p *PendingPool) projectGasUsed(baseFee, blockGasLimit uint64) (gasUsed uint64) {
+	fee := uint256.NewInt(baseFee)
+	for _, mt := range p.best.ms {
+		if mt.minFeeCap.Cmp(fee) < 0 {
+			continue
+		}
+		gasUsed += mt.Tx.Gas
+		if gasUsed >= blockGasLimit {
+			return blockGasLimit
+		}
+	}
+	return gasUsed
+}
+
+// bestAt - like `best` slice, but sorted at given base fee. minFeeCap and minTip are minimums over all previous nonces
+// of sender - then tx is never ahead of it's sender's previous nonces.
+func (p *PendingPool) bestAt(n int, baseFee, blockGasLimit uint64) []*metaTx {
+	fee := uint256.NewInt(baseFee)
+	candidates := make([]*metaTx, 0, len(p.best.ms))
+	for _, mt := range p.best.ms {
+		if mt.minFeeCap.Cmp(fee) < 0 {
+			continue
+		}
+		if blockGasLimit > 0 && mt.Tx.Gas > blockGasLimit {
+			continue
+		}
+		candidates = append(candidates, mt)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].better(candidates[j], *fee)
+	})
+	if len(candidates) > n {
+		candidates = candidates[:n]
+	}
+	return candidates
+}
+
+// DefaultFeeStatsPercentiles - used by FeeStats when percentiles are not given
+var DefaultFeeStatsPercentiles = []float64{10, 25, 50, 75, 90}
+
+// FeeStats - effective tips of executable txs of pending sub-pool: txs which can pay base fee of pending block
+type FeeStats struct {
+	BaseFee     uint64    // base fee of pending block, tips are effective at it
+	Count       int       // amount of executable txs
+	Percentiles []float64 // requested percentiles
+	Tips        []uint64  // effective tips at Percentiles, all zero if Count is 0
+}
+
+// FeeStats - percentiles of effective tips of executable txs. Cheap local source for eth_gasPrice/eth_feeHistory:
+// tips are sorted incrementally with changes of pending sub-pool, call costs O(len(percentiles)).
+func (p *TxPool) FeeStats(percentiles ...float64) FeeStats {
+	if len(percentiles) == 0 {
+		percentiles = DefaultFeeStatsPercentiles
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	tips := p.pending.tips
+	stats := FeeStats{BaseFee: tips.baseFee, Count: len(tips.tips), Percentiles: percentiles, Tips: make([]uint64, len(percentiles))}
+	for i, percentile := range percentiles {
+		stats.Tips[i] = tips.percentile(percentile)
+	}
+	return stats
+}
+
+// feeTips - sorted effective tips at baseFee of executable txs of pending sub-pool. Each tx remembers own tip -
+// to be removed from feeTips when it leaves sub-pool or changes.
+type feeTips struct {
+	baseFee uint64
+	tips    []uint64
+}
+
+// effectiveTip - false if tx can't pay baseFee
+func effectiveTip(mt *metaTx, baseFee uint64) (uint64, bool) {
+	fee := uint256.NewInt(baseFee)
+	if mt.minFeeCap.Cmp(fee) < 0 {
+		return 0, false
+	}
+	var difference uint256.Int
+	difference.Sub(&mt.minFeeCap, fee)
+	if difference.IsUint64() && difference.Uint64() < mt.minTip {
+		return difference.Uint64(), true
+	}
+	return mt.minTip, true
+}
+
+func (f *feeTips) add(mt *metaTx) {
+	tip, ok := effectiveTip(mt, f.baseFee)
+	if !ok {
+		return
+	}
+	i := sort.Search(len(f.tips), func(i int) bool { return f.tips[i] >= tip })
+	f.tips = append(f.tips, 0)
+	copy(f.tips[i+1:], f.tips[i:])
+	f.tips[i] = tip
+	mt.feeTip, mt.inFeeTips = tip, true
+}
+
+func (f *feeTips) remove(mt *metaTx) {
+	if !mt.inFeeTips {
+		return
+	}
+	mt.inFeeTips = false
+	i := sort.Search(len(f.tips), func(i int) bool { return f.tips[i] >= mt.feeTip })
+	if i < len(f.tips) && f.tips[i] == mt.feeTip {
+		f.tips = append(f.tips[:i], f.tips[i+1:]...)
+	}
+}
+
+// reset - base fee changed, tips of all txs are different
+func (f *feeTips) reset(baseFee uint64, ms []*metaTx) {
+	f.baseFee = baseFee
+	f.tips = f.tips[:0]
+	for _, mt := range ms {
+		mt.inFeeTips = false
+		tip, ok := effectiveTip(mt, baseFee)
+		if !ok {
+			continue
+		}
+		f.tips = append(f.tips, tip)
+		mt.feeTip, mt.inFeeTips = tip, true
+	}
+	sort.Slice(f.tips, func(i, j int) bool { return f.tips[i] < f.tips[j] })
+}
+
+// percentile - nearest-rank method
+func (f *feeTips) percentile(percentile float64) uint64 {
+	if len(f.tips) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(percentile / 100 * float64(len(f.tips))))
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > len(f.tips) {
+		rank = len(f.tips)
+	}
+	return f.tips[rank-1]
+}
@@ -30,6 +30,7 @@ import (
 	"github.com/ledgerwatch/erigon-lib/gointerfaces/sentry"
 	"github.com/ledgerwatch/erigon-lib/kv"
 	"github.com/ledgerwatch/erigon-lib/rlp"
+	peerscore "github.com/ledgerwatch/erigon-lib/sentry"
 	types2 "github.com/ledgerwatch/erigon-lib/types"
 	"github.com/ledgerwatch/log/v3"
 	"google.golang.org/grpc"
@@ -50,6 +51,8 @@ type Fetch struct {
 	stateChangesParseCtx     *types2.TxParseContext
 	pooledTxsParseCtx        *types2.TxParseContext
 	sentryClients            []direct.SentryClient // sentry clients that will be used for accessing the network
+	peerScorer               peerscore.PeerScorer  // nil if embedder doesn't track peers
+	seen                     *SeenCache            // dedup of announced hashes
 	stateChangesParseCtxLock sync.Mutex
 	pooledTxsParseCtxLock    sync.Mutex
 }
@@ -60,8 +63,9 @@ type StateChangesClient interface {
 
 // NewFetch creates a new fetch object that will work with given sentry clients. Since the
 // SentryClient here is an interface, it is suitable for mocking in tests (mock will need
-// to implement all the functions of the SentryClient interface).
-func NewFetch(ctx context.Context, sentryClients []direct.SentryClient, pool Pool, stateChangesClient StateChangesClient, coreDB kv.RoDB, db kv.RwDB, chainID uint256.Int) *Fetch {
+// to implement all the functions of the SentryClient interface). `seenCacheSize` - capacity of dedup of announced
+// hashes (see Config.SeenCacheSize), cache can be shared with Send via SeenCache.
+func NewFetch(ctx context.Context, sentryClients []direct.SentryClient, pool Pool, stateChangesClient StateChangesClient, coreDB kv.RoDB, db kv.RwDB, chainID uint256.Int, seenCacheSize int) (*Fetch, error) {
 	f := &Fetch{
 		ctx:                  ctx,
 		sentryClients:        sentryClients,
@@ -74,14 +78,36 @@ func NewFetch(ctx context.Context, sentryClients []direct.SentryClient, pool Poo
 	}
 	f.pooledTxsParseCtx.ValidateRLP(f.pool.ValidateSerializedTxn)
 	f.stateChangesParseCtx.ValidateRLP(f.pool.ValidateSerializedTxn)
+	seen, err := NewSeenCache(seenCacheSize)
+	if err != nil {
+		return nil, fmt.Errorf("seen cache: %w", err)
+	}
+	f.seen = seen
 
-	return f
+	return f, nil
 }
 
 func (f *Fetch) SetWaitGroup(wg *sync.WaitGroup) {
 	f.wg = wg
 }
 
+// SetPeerScorer - report txs delivered by peers and invalid messages of peers
+func (f *Fetch) SetPeerScorer(s peerscore.PeerScorer) {
+	f.peerScorer = s
+}
+
+// SeenCache - dedup of announced hashes, share it with Send (see Send.SetSeenCache)
+func (f *Fetch) SeenCache() *SeenCache {
+	return f.seen
+}
+
+func (f *Fetch) recordPeer(peerID types2.PeerID, event peerscore.PeerEvent, amount uint64) {
+	if f.peerScorer == nil || peerID == nil {
+		return
+	}
+	f.peerScorer.Record(peerID, event, amount)
+}
+
 func (f *Fetch) threadSafeParsePooledTxn(cb func(*types2.TxParseContext) error) error {
 	f.pooledTxsParseCtxLock.Lock()
 	defer f.pooledTxsParseCtxLock.Unlock()
@@ -189,6 +215,7 @@ func (f *Fetch) receiveMessage(ctx context.Context, sentryClient sentry.SentryCl
 			}
 
 			if rlp.IsRLPError(err) {
+				f.recordPeer(req.PeerId, peerscore.Penalty, 1)
 				log.Debug("[txpool.fetch] Handling incoming message", "msg", req.Id.String(), "err", err)
 			} else {
 				log.Warn("[txpool.fetch] Handling incoming message", "msg", req.Id.String(), "err", err)
@@ -224,6 +251,7 @@ func (f *Fetch) handleInboundMessage(ctx context.Context, req *sentry.InboundMes
 		}
 		var hashbuf [32]byte
 		var unknownHashes types2.Hashes
+		now := time.Now()
 		for i := 0; i < hashCount; i++ {
 			_, pos, err = types2.ParseHash(req.Data, pos, hashbuf[:0])
 			if err != nil {
@@ -233,7 +261,7 @@ func (f *Fetch) handleInboundMessage(ctx context.Context, req *sentry.InboundMes
 			if err != nil {
 				return err
 			}
-			if !known {
+			if !known && f.seen.ShouldRequest(req.PeerId, hashbuf[:], now) {
 				unknownHashes = append(unknownHashes, hashbuf[:]...)
 			}
 		}
@@ -342,7 +370,11 @@ func (f *Fetch) handleInboundMessage(ctx context.Context, req *sentry.InboundMes
 		if len(txs.Txs) == 0 {
 			return nil
 		}
+		for i := range txs.Txs {
+			f.seen.MarkPeer(req.PeerId, txs.Txs[i].IDHash[:])
+		}
 		f.pool.AddRemoteTxs(ctx, txs)
+		f.recordPeer(req.PeerId, peerscore.ValidTxs, uint64(len(txs.Txs)))
 	default:
 		defer log.Trace("[txpool] dropped p2p message", "id", req.Id)
 	}
@@ -23,6 +23,7 @@ import (
 	"io"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/ledgerwatch/erigon-lib/common/u256"
 	"github.com/ledgerwatch/erigon-lib/direct"
@@ -45,7 +46,8 @@ func TestFetch(t *testing.T) {
 	sentryClient := direct.NewSentryClientDirect(direct.ETH66, m)
 	pool := &PoolMock{}
 
-	fetch := NewFetch(ctx, []direct.SentryClient{sentryClient}, pool, &remote.KVClientMock{}, nil, nil, *u256.N1)
+	fetch, err := NewFetch(ctx, []direct.SentryClient{sentryClient}, pool, &remote.KVClientMock{}, nil, nil, *u256.N1, DefaultConfig.SeenCacheSize)
+	require.NoError(t, err)
 	var wg sync.WaitGroup
 	fetch.SetWaitGroup(&wg)
 	m.StreamWg.Add(2)
@@ -144,6 +146,69 @@ func TestSendTxPropagate(t *testing.T) {
 			assert.True(t, len(req.Data.Data) > 0)
 		}
 	})
+	t.Run("sync with new peer, dedup", func(t *testing.T) {
+		m := NewMockSentry(ctx)
+		send := NewSend(ctx, []direct.SentryClient{direct.NewSentryClientDirect(direct.ETH66, m)}, nil)
+		seen, err := NewSeenCache(16)
+		require.NoError(t, err)
+		send.SetSeenCache(seen)
+		expectPeers := toPeerIDs(1, 2, 42)
+		seen.MarkPeer(expectPeers[0], toHashes(1))
+		seen.MarkPeer(expectPeers[1], toHashes(1))
+		seen.MarkPeer(expectPeers[1], toHashes(42))
+		send.PropagatePooledTxsToPeersList(expectPeers, toHashes(1, 42))
+		send.PropagatePooledTxsToPeersList(expectPeers, toHashes(1, 42)) // all known
+
+		calls := m.SendMessageByIdCalls()
+		require.Equal(t, 2, len(calls))
+		assert.Equal(t, expectPeers[0], types3.PeerID(calls[0].SendMessageByIdRequest.PeerId))
+		assert.Equal(t, types3.EncodeHashes(toHashes(42), nil), calls[0].SendMessageByIdRequest.Data.Data)
+		assert.Equal(t, expectPeers[2], types3.PeerID(calls[1].SendMessageByIdRequest.PeerId))
+		assert.Equal(t, types3.EncodeHashes(toHashes(1, 42), nil), calls[1].SendMessageByIdRequest.Data.Data)
+	})
+}
+
+func TestSeenCache(t *testing.T) {
+	peers, hashes := toPeerIDs(1, 2), toHashes(1, 2, 3)
+	h1, h2, h3 := hashes[:32], hashes[32:64], hashes[64:]
+	now := time.Now()
+
+	_, err := NewFetch(context.Background(), nil, &PoolMock{}, &remote.KVClientMock{}, nil, nil, *u256.N1, 0)
+	require.Error(t, err)
+
+	c, err := NewSeenCache(2)
+	require.NoError(t, err)
+	require.True(t, c.ShouldRequest(peers[0], h1, now))
+	require.False(t, c.ShouldRequest(peers[0], h1, now))                           // same peer announced again
+	require.False(t, c.ShouldRequest(peers[1], h1, now.Add(time.Second)))          // requested from other peer recently
+	require.True(t, c.ShouldRequest(peers[0], h2, now))                            // per-hash
+	require.False(t, c.ShouldRequest(peers[1], h2, now.Add(seenRequestTimeout/2))) // timeout not passed
+	require.True(t, c.PeerKnows(peers[1], h1))                                     // announced it
+	require.False(t, c.PeerKnows(peers[1], h3))
+	require.True(t, c.PeerKnows(peers[1], h3)) // marked as sent to peer
+
+	// bounded: h2 evicted - requested again
+	require.Equal(t, 2, c.Len())
+	require.True(t, c.ShouldRequest(peers[1], h2, now.Add(time.Second)))
+	require.Equal(t, 2, c.Len())
+}
+
+func TestSeenCacheNotDelivered(t *testing.T) {
+	peers, h := toPeerIDs(1, 2, 3), toHashes(1)
+	now := time.Now()
+	c, err := NewSeenCache(16)
+	require.NoError(t, err)
+
+	require.True(t, c.ShouldRequest(peers[0], h, now)) // requested from peers[0], which never delivers
+	require.False(t, c.ShouldRequest(peers[1], h, now.Add(time.Second)))
+
+	// after timeout peers which already announced hash re-trigger request
+	later := now.Add(seenRequestTimeout + time.Second)
+	require.True(t, c.ShouldRequest(peers[1], h, later))
+	require.False(t, c.ShouldRequest(peers[0], h, later))
+	require.False(t, c.ShouldRequest(peers[2], h, later))
+	evenLater := later.Add(seenRequestTimeout)
+	require.True(t, c.ShouldRequest(peers[0], h, evenLater))
 }
 
 func decodeHex(in string) []byte {
@@ -179,8 +244,9 @@ func TestOnNewBlock(t *testing.T) {
 		},
 	}
 	pool := &PoolMock{}
-	fetch := NewFetch(ctx, nil, pool, stateChanges, coreDB, db, *u256.N1)
-	err := fetch.handleStateChanges(ctx, stateChanges)
+	fetch, err := NewFetch(ctx, nil, pool, stateChanges, coreDB, db, *u256.N1, DefaultConfig.SeenCacheSize)
+	require.NoError(t, err)
+	err = fetch.handleStateChanges(ctx, stateChanges)
 	assert.ErrorIs(t, io.EOF, err)
 	assert.Equal(t, 1, len(pool.OnNewBlockCalls()))
 	assert.Equal(t, 3, len(pool.OnNewBlockCalls()[0].MinedTxs.Txs))
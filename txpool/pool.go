@@ -31,7 +31,6 @@ import (
 	"sync"
 	"time"
 
-	"github.com/VictoriaMetrics/metrics"
 	mapset "github.com/deckarep/golang-set/v2"
 	"github.com/go-stack/stack"
 	"github.com/google/btree"
@@ -46,6 +45,7 @@ import (
 	"github.com/ledgerwatch/erigon-lib/common/dbg"
 	"github.com/ledgerwatch/erigon-lib/common/fixedgas"
 	emath "github.com/ledgerwatch/erigon-lib/common/math"
+	"github.com/ledgerwatch/erigon-lib/common/metrics"
 	"github.com/ledgerwatch/erigon-lib/common/u256"
 	"github.com/ledgerwatch/erigon-lib/gointerfaces"
 	"github.com/ledgerwatch/erigon-lib/gointerfaces/grpcutil"
@@ -58,16 +58,20 @@ import (
 )
 
 var (
-	processBatchTxsTimer    = metrics.NewSummary(`pool_process_remote_txs`)
-	addRemoteTxsTimer       = metrics.NewSummary(`pool_add_remote_txs`)
-	newBlockTimer           = metrics.NewSummary(`pool_new_block`)
-	writeToDBTimer          = metrics.NewSummary(`pool_write_to_db`)
-	propagateToNewPeerTimer = metrics.NewSummary(`pool_propagate_to_new_peer`)
-	propagateNewTxsTimer    = metrics.NewSummary(`pool_propagate_new_txs`)
-	writeToDBBytesCounter   = metrics.GetOrCreateCounter(`pool_write_to_db_bytes`)
-	pendingSubCounter       = metrics.GetOrCreateCounter(`txpool_pending`)
-	queuedSubCounter        = metrics.GetOrCreateCounter(`txpool_queued`)
-	basefeeSubCounter       = metrics.GetOrCreateCounter(`txpool_basefee`)
+	poolMetrics = metrics.GetOrCreateNamespace("txpool")
+
+	processBatchTxsTimer    = poolMetrics.Summary(`pool_process_remote_txs`)
+	addRemoteTxsTimer       = poolMetrics.Summary(`pool_add_remote_txs`)
+	newBlockTimer           = poolMetrics.Summary(`pool_new_block`)
+	writeToDBTimer          = poolMetrics.Summary(`pool_write_to_db`)
+	propagateToNewPeerTimer = poolMetrics.Summary(`pool_propagate_to_new_peer`)
+	propagateNewTxsTimer    = poolMetrics.Summary(`pool_propagate_new_txs`)
+	writeToDBBytesCounter   = poolMetrics.Counter(`pool_write_to_db_bytes`)
+	pendingSubCounter       = poolMetrics.Counter(`txpool_pending`)
+	queuedSubCounter        = poolMetrics.Counter(`txpool_queued`)
+	basefeeSubCounter       = poolMetrics.Counter(`txpool_basefee`)
+	senderSlotsExceeded     = poolMetrics.Counter(`pool_sender_slots_exceeded`)
+	senderBytesExceeded     = poolMetrics.Counter(`pool_sender_bytes_exceeded`)
 )
 
 const ASSERT = false
@@ -85,6 +89,9 @@ type Config struct {
 	MinFeeCap             uint64
 	AccountSlots          uint64 // Number of executable transaction slots guaranteed per account
 	PriceBump             uint64 // Price bump percentage to replace an already existing transaction
+	MaxSlotsPerSender     uint64 // Hard limit of amount of sender's transactions in pool (local too), 0 - no limit
+	MaxBytesPerSender     uint64 // Hard limit of total rlp size of sender's transactions in pool (local too), 0 - no limit
+	SeenCacheSize         int    // Amount of tx hashes remembered by p2p dedup (see SeenCache)
 	OverrideShanghaiTime  *big.Int
 }
 
@@ -101,6 +108,7 @@ var DefaultConfig = Config{
 	MinFeeCap:            1,
 	AccountSlots:         16, //TODO: to choose right value (16 to be compatible with Geth)
 	PriceBump:            10, // Price bump percentage to replace an already existing transaction
+	SeenCacheSize:        32_768,
 	OverrideShanghaiTime: nil,
 }
 
@@ -171,6 +179,8 @@ const (
 	NotReplaced         DiscardReason = 20 // There was an existing transaction with the same sender and nonce, not enough price bump to replace
 	DuplicateHash       DiscardReason = 21 // There was an existing transaction with the same hash
 	InitCodeTooLarge    DiscardReason = 22 // EIP-3860 - transaction init code is too large
+	SenderSlotsExceeded DiscardReason = 23 // Sender has Config.MaxSlotsPerSender transactions in pool
+	SenderBytesExceeded DiscardReason = 24 // Sender's transactions in pool would take more than Config.MaxBytesPerSender
 )
 
 func (r DiscardReason) String() string {
@@ -221,6 +231,10 @@ func (r DiscardReason) String() string {
 		return "existing tx with same hash"
 	case InitCodeTooLarge:
 		return "initcode too large"
+	case SenderSlotsExceeded:
+		return "sender slots limit exceeded"
+	case SenderBytesExceeded:
+		return "sender bytes limit exceeded"
 	default:
 		panic(fmt.Sprintf("discard reason: %d", r))
 	}
@@ -239,6 +253,8 @@ type metaTx struct {
 	subPool                   SubPoolMarker
 	currentSubPool            SubPoolType
 	alreadyYielded            bool
+	feeTip                    uint64 // effective tip in PendingPool.tips, see feeTips
+	inFeeTips                 bool
 }
 
 func newMetaTx(slot *types.TxSlot, isLocal bool, timestmap uint64) *metaTx {
@@ -329,6 +345,7 @@ type TxPool struct {
 	blockGasLimit           atomic.Uint64
 	shanghaiTime            *big.Int
 	isPostShanghai          atomic.Bool
+	events                  *EventLog // see Events
 }
 
 func New(newTxs chan types.Hashes, coreDB kv.RoDB, cfg Config, cache kvcache.Cache, chainID uint256.Int, shanghaiTime *big.Int) (*TxPool, error) {
@@ -345,6 +362,7 @@ func New(newTxs chan types.Hashes, coreDB kv.RoDB, cfg Config, cache kvcache.Cac
 		tree:             btree.NewG[*metaTx](32, SortByNonceLess),
 		search:           &metaTx{Tx: &types.TxSlot{}},
 		senderIDTxnCount: map[uint64]int{},
+		senderIDTxnBytes: map[uint64]int{},
 	}
 	tracedSenders := make(map[string]struct{})
 	for _, sender := range cfg.TracedSenders {
@@ -370,9 +388,13 @@ func New(newTxs chan types.Hashes, coreDB kv.RoDB, cfg Config, cache kvcache.Cac
 		unprocessedRemoteByHash: map[string]int{},
 		promoted:                make(types.Hashes, 0, 32*1024),
 		shanghaiTime:            shanghaiTime,
+		events:                  NewEventLog(DefaultEventLogSize),
 	}, nil
 }
 
+// Events - journal of pool events (added/removed/replaced/mined txs)
+func (p *TxPool) Events() *EventLog { return p.events }
+
 func (p *TxPool) OnNewBlock(ctx context.Context, stateChanges *remote.StateChangeBatch, unwindTxs, minedTxs types.TxSlots, tx kv.Tx) error {
 	defer newBlockTimer.UpdateDuration(time.Now())
 	//t := time.Now()
@@ -419,6 +441,7 @@ func (p *TxPool) OnNewBlock(ctx context.Context, stateChanges *remote.StateChang
 		p.baseFee.worst.pendingBaseFee = pendingBaseFee
 		p.queued.best.pendingBastFee = pendingBaseFee
 		p.queued.worst.pendingBaseFee = pendingBaseFee
+		p.pending.tips.reset(pendingBaseFee, p.pending.best.ms)
 	}
 
 	p.blockGasLimit.Store(stateChanges.BlockGasLimit)
@@ -772,7 +795,8 @@ func (p *TxPool) validateTx(txn *types.TxSlot, isLocal bool, stateCache kvcache.
 	// Transactor should have enough funds to cover the costs
 	total := uint256.NewInt(txn.Gas)
 	total.Mul(total, &txn.FeeCap)
-	total.Add(total, &txn.Value)
+	value, _ := txn.Value() // txs of pool are parsed eagerly
+	total.Add(total, value)
 	if senderBalance.Cmp(total) < 0 {
 		if txn.Traced {
 			log.Info(fmt.Sprintf("TX TRACING: validateTx insufficient funds idHash=%x balance in state=%d, txn.gas*txn.tip=%d", txn.IDHash, senderBalance, total))
@@ -1116,6 +1140,9 @@ func (p *TxPool) setBaseFee(baseFee uint64) (uint64, bool) {
 func (p *TxPool) addLocked(mt *metaTx) DiscardReason {
 	// Insert to pending pool, if pool doesn't have txn with same Nonce and bigger Tip
 	found := p.all.get(mt.Tx.SenderID, mt.Tx.Nonce)
+	if reason := p.checkSenderQuota(mt, found); reason != NotSet {
+		return reason
+	}
 	if found != nil {
 		tipThreshold := uint256.NewInt(0)
 		tipThreshold = tipThreshold.Mul(&found.Tx.Tip, uint256.NewInt(100+p.cfg.PriceBump))
@@ -1157,6 +1184,9 @@ func (p *TxPool) addLocked(mt *metaTx) DiscardReason {
 		}
 
 		p.discardLocked(found, ReplacedByHigherTip)
+		p.events.replaced(mt, found)
+	} else {
+		p.events.added(mt)
 	}
 
 	p.byHash[string(mt.Tx.IDHash[:])] = mt
@@ -1175,6 +1205,32 @@ func (p *TxPool) addLocked(mt *metaTx) DiscardReason {
 	return NotSet
 }
 
+// checkSenderQuota - Config.MaxSlotsPerSender and Config.MaxBytesPerSender. `found` - transaction which `mt` replaces
+// (if any): replacement doesn't take new slot, only difference of sizes counts
+func (p *TxPool) checkSenderQuota(mt, found *metaTx) DiscardReason {
+	if p.cfg.MaxSlotsPerSender > 0 && found == nil && uint64(p.all.count(mt.Tx.SenderID)) >= p.cfg.MaxSlotsPerSender {
+		if mt.Tx.Traced {
+			log.Info(fmt.Sprintf("TX TRACING: addLocked sender slots exceeded idHash=%x slots=%d, limit=%d", mt.Tx.IDHash, p.all.count(mt.Tx.SenderID), p.cfg.MaxSlotsPerSender))
+		}
+		senderSlotsExceeded.Inc()
+		return SenderSlotsExceeded
+	}
+	if p.cfg.MaxBytesPerSender > 0 {
+		size := p.all.bytes(mt.Tx.SenderID) + int(mt.Tx.Size)
+		if found != nil {
+			size -= int(found.Tx.Size)
+		}
+		if uint64(size) > p.cfg.MaxBytesPerSender {
+			if mt.Tx.Traced {
+				log.Info(fmt.Sprintf("TX TRACING: addLocked sender bytes exceeded idHash=%x bytes=%d, limit=%d", mt.Tx.IDHash, size, p.cfg.MaxBytesPerSender))
+			}
+			senderBytesExceeded.Inc()
+			return SenderBytesExceeded
+		}
+	}
+	return NotSet
+}
+
 // dropping transaction from all sub-structures and from db
 // Important: don't call it while iterating by all
 func (p *TxPool) discardLocked(mt *metaTx, reason DiscardReason) {
@@ -1182,6 +1238,7 @@ func (p *TxPool) discardLocked(mt *metaTx, reason DiscardReason) {
 	p.deletedTxs = append(p.deletedTxs, mt)
 	p.all.delete(mt)
 	p.discardReasonsLRU.Add(string(mt.Tx.IDHash[:]), reason)
+	p.events.discarded(mt, reason)
 }
 
 func (p *TxPool) NonceFromAddress(addr [20]byte) (nonce uint64, inPool bool) {
@@ -1297,7 +1354,8 @@ func onSenderStateChange(senderID uint64, senderNonce uint64, senderBalance uint
 		// Sender has enough balance for: gasLimit x feeCap + transferred_value
 		needBalance := uint256.NewInt(mt.Tx.Gas)
 		needBalance.Mul(needBalance, &mt.Tx.FeeCap)
-		needBalance.Add(needBalance, &mt.Tx.Value)
+		value, _ := mt.Tx.Value() // txs of pool are parsed eagerly
+		needBalance.Add(needBalance, value)
 		// 1. Minimum fee requirement. Set to 1 if feeCap of the transaction is no less than in-protocol
 		// parameter of minimal base fee. Set to 0 if feeCap is less than minimum base fee, which means
 		// this transaction will never be included into this particular chain.
@@ -1730,6 +1788,7 @@ func (p *TxPool) fromDB(ctx context.Context, tx kv.Tx, coreTx kv.Tx) error {
 		return err
 	}
 	p.pendingBaseFee.Store(pendingBaseFee)
+	p.pending.tips.reset(pendingBaseFee, p.pending.best.ms)
 
 	return nil
 }
@@ -2051,6 +2110,7 @@ type BySenderAndNonce struct {
 	tree             *btree.BTreeG[*metaTx]
 	search           *metaTx
 	senderIDTxnCount map[uint64]int // count of sender's txns in the pool - may differ from nonce
+	senderIDTxnBytes map[uint64]int // total size of sender's txns in the pool
 }
 
 func (b *BySenderAndNonce) nonce(senderID uint64) (nonce uint64, ok bool) {
@@ -2097,6 +2157,9 @@ func (b *BySenderAndNonce) descend(senderID uint64, f func(*metaTx) bool) {
 func (b *BySenderAndNonce) count(senderID uint64) int {
 	return b.senderIDTxnCount[senderID]
 }
+func (b *BySenderAndNonce) bytes(senderID uint64) int {
+	return b.senderIDTxnBytes[senderID]
+}
 func (b *BySenderAndNonce) hasTxs(senderID uint64) bool {
 	has := false
 	b.ascend(senderID, func(*metaTx) bool {
@@ -2125,17 +2188,21 @@ func (b *BySenderAndNonce) delete(mt *metaTx) {
 		count := b.senderIDTxnCount[senderID]
 		if count > 1 {
 			b.senderIDTxnCount[senderID] = count - 1
+			b.senderIDTxnBytes[senderID] -= int(mt.Tx.Size)
 		} else {
 			delete(b.senderIDTxnCount, senderID)
+			delete(b.senderIDTxnBytes, senderID)
 		}
 	}
 }
 func (b *BySenderAndNonce) replaceOrInsert(mt *metaTx) *metaTx {
 	it, ok := b.tree.ReplaceOrInsert(mt)
 	if ok {
+		b.senderIDTxnBytes[mt.Tx.SenderID] += int(mt.Tx.Size) - int(it.Tx.Size)
 		return it
 	}
 	b.senderIDTxnCount[mt.Tx.SenderID]++
+	b.senderIDTxnBytes[mt.Tx.SenderID] += int(mt.Tx.Size)
 	return nil
 }
 
@@ -2145,6 +2212,7 @@ func (b *BySenderAndNonce) replaceOrInsert(mt *metaTx) *metaTx {
 type PendingPool struct {
 	best   *bestSlice
 	worst  *WorstQueue
+	tips   feeTips // see FeeStats
 	added  types.Hashes
 	limit  int
 	t      SubPoolType
@@ -2215,10 +2283,13 @@ func (p *PendingPool) PopWorst() *metaTx { //nolint
 	if i.bestIndex >= 0 {
 		p.best.UnsafeRemove(i)
 	}
+	p.tips.remove(i)
 	return i
 }
 func (p *PendingPool) Updated(mt *metaTx) {
 	heap.Fix(p.worst, mt.worstIndex)
+	p.tips.remove(mt)
+	p.tips.add(mt)
 }
 func (p *PendingPool) Len() int { return len(p.best.ms) }
 
@@ -2229,6 +2300,7 @@ func (p *PendingPool) Remove(i *metaTx) {
 	if i.bestIndex >= 0 {
 		p.best.UnsafeRemove(i)
 	}
+	p.tips.remove(i)
 	i.currentSubPool = 0
 }
 
@@ -2242,6 +2314,7 @@ func (p *PendingPool) Add(i *metaTx) {
 	i.currentSubPool = p.t
 	heap.Push(p.worst, i)
 	p.best.UnsafeAdd(i)
+	p.tips.add(i)
 }
 func (p *PendingPool) DebugPrint(prefix string) {
 	for i, it := range p.best.ms {
@@ -201,10 +201,10 @@ func poolsFromFuzzBytes(rawTxNonce, rawValues, rawTips, rawFeeCap, rawSender []b
 	for i := range txNonce {
 		txs.Txs[i] = &types.TxSlot{
 			Nonce:  txNonce[i],
-			Value:  values[i%len(values)],
 			Tip:    *uint256.NewInt(tips[i%len(tips)]),
 			FeeCap: *uint256.NewInt(feeCap[i%len(feeCap)]),
 		}
+		txs.Txs[i].SetValue(&values[i%len(values)])
 		txRlp := fakeRlpTx(txs.Txs[i], senders.At(i%senders.Len()))
 		_, err := parseCtx.ParseTransaction(txRlp, 0, txs.Txs[i], nil, false, nil)
 		if err != nil {
@@ -219,11 +219,12 @@ func poolsFromFuzzBytes(rawTxNonce, rawValues, rawTips, rawFeeCap, rawSender []b
 
 // fakeRlpTx add anything what identifying tx to `data` to make hash unique
 func fakeRlpTx(slot *types.TxSlot, data []byte) []byte {
+	value, _ := slot.Value()
 	dataLen := rlp.U64Len(1) + //chainID
 		rlp.U64Len(slot.Nonce) + rlp.U256Len(&slot.Tip) + rlp.U256Len(&slot.FeeCap) +
 		rlp.U64Len(0) + // gas
 		rlp.StringLen(0) + // dest addr
-		rlp.U256Len(&slot.Value) +
+		rlp.U256Len(value) +
 		rlp.StringLen(len(data)) + // data
 		rlp.ListPrefixLen(0) + //access list
 		+3 // v,r,s
@@ -243,8 +244,8 @@ func fakeRlpTx(slot *types.TxSlot, data []byte) []byte {
 	p += rlp.EncodeU64(0, buf[p:])           //gas
 	p += rlp.EncodeString([]byte{}, buf[p:]) //destrination addr
 	bb = bytes.NewBuffer(buf[p:p])
-	_ = slot.Value.EncodeRLP(bb)
-	p += rlp.U256Len(&slot.Value)
+	_ = value.EncodeRLP(bb)
+	p += rlp.U256Len(value)
 	p += rlp.EncodeString(data, buf[p:])  //data
 	p += rlp.EncodeListPrefix(0, buf[p:]) // access list
 	p += rlp.EncodeU64(1, buf[p:])        //v
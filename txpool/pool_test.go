@@ -20,11 +20,14 @@ import (
 	"bytes"
 	"container/heap"
 	"context"
+	"errors"
 	"fmt"
 	"math"
 	"math/big"
 	"math/rand"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/holiman/uint256"
 	"github.com/stretchr/testify/assert"
@@ -326,6 +329,114 @@ func TestReplaceWithHigherFee(t *testing.T) {
 		assert.True(ok)
 		assert.Equal(uint64(3), nonce)
 	}
+
+	var events []Event
+	errStop := errors.New("stop")
+	err = pool.Events().Subscribe(ctx, pool.Events().NextSeq()-2, func(e Event) error {
+		events = append(events, e)
+		if len(events) == 2 {
+			return errStop
+		}
+		return nil
+	})
+	require.ErrorIs(err, errStop)
+	assert.Equal(EventAdded, events[0].Type)
+	assert.Equal(byte(1), events[0].Hash[0])
+	assert.Equal(EventReplaced, events[1].Type)
+	assert.Equal(byte(4), events[1].Hash[0])
+	assert.Equal(byte(1), events[1].ReplacedHash[0])
+	assert.Equal(events[0].Seq+1, events[1].Seq)
+}
+
+func TestSenderQuotas(t *testing.T) {
+	assert, require := assert.New(t), require.New(t)
+	ch := make(chan types.Hashes, 100)
+	db, coreDB := memdb.NewTestPoolDB(t), memdb.NewTestDB(t)
+
+	cfg := DefaultConfig
+	cfg.MaxSlotsPerSender = 2
+	cfg.MaxBytesPerSender = 250
+	sendersCache := kvcache.New(kvcache.DefaultCoherentConfig)
+	pool, err := New(ch, coreDB, cfg, sendersCache, *u256.N1, nil)
+	assert.NoError(err)
+	require.True(pool != nil)
+	ctx := context.Background()
+	h1 := gointerfaces.ConvertHashToH256([32]byte{})
+	change := &remote.StateChangeBatch{
+		PendingBlockBaseFee: 200000,
+		BlockGasLimit:       1000000,
+		ChangeBatch: []*remote.StateChange{
+			{BlockHeight: 0, BlockHash: h1},
+		},
+	}
+	var addr [20]byte
+	addr[0] = 1
+	v := make([]byte, types.EncodeSenderLengthForStorage(2, *uint256.NewInt(1 * common.Ether)))
+	types.EncodeSender(2, *uint256.NewInt(1 * common.Ether), v)
+	change.ChangeBatch[0].Changes = append(change.ChangeBatch[0].Changes, &remote.AccountChange{
+		Action:  remote.Action_UPSERT,
+		Address: gointerfaces.ConvertAddressToH160(addr),
+		Data:    v,
+	})
+	tx, err := db.BeginRw(ctx)
+	require.NoError(err)
+	defer tx.Rollback()
+	err = pool.OnNewBlock(ctx, change, types.TxSlots{}, types.TxSlots{}, tx)
+	assert.NoError(err)
+
+	add := func(id byte, nonce, fee uint64, size uint32, expect DiscardReason) {
+		t.Helper()
+		var txSlots types.TxSlots
+		txSlot := &types.TxSlot{
+			Tip:    *uint256.NewInt(fee),
+			FeeCap: *uint256.NewInt(fee),
+			Gas:    100000,
+			Nonce:  nonce,
+			Size:   size,
+		}
+		txSlot.IDHash[0] = id
+		txSlots.Append(txSlot, addr[:], true)
+		reasons, err := pool.AddLocalTxs(ctx, txSlots, tx)
+		assert.NoError(err)
+		require.Equal([]DiscardReason{expect}, reasons)
+	}
+	add(1, 2, 300000, 100, Success)
+	add(2, 3, 300000, 100, Success)
+	add(3, 4, 300000, 10, SenderSlotsExceeded)
+	// replacement doesn't take new slot, only difference of sizes counts
+	add(4, 3, 400000, 150, Success)
+	add(5, 3, 500000, 200, SenderBytesExceeded)
+
+	senderID, ok := pool.senders.getID(addr[:])
+	require.True(ok)
+	require.Equal(2, pool.all.count(senderID))
+	require.Equal(250, pool.all.bytes(senderID))
+}
+
+func TestEventLog(t *testing.T) {
+	l := NewEventLog(4)
+	first := l.NextSeq()
+	for i := 0; i < 6; i++ {
+		l.append(Event{Type: EventRemoved, Hash: [32]byte{byte(i)}})
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.ErrorIs(t, l.Subscribe(ctx, first, func(e Event) error { return nil }), ErrEventsPruned)
+	require.ErrorIs(t, l.Subscribe(ctx, first+7, func(e Event) error { return nil }), ErrEventsPruned)
+
+	var hashes []byte
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		l.append(Event{Type: EventMined, Hash: [32]byte{6}})
+	}()
+	require.ErrorIs(t, l.Subscribe(ctx, first+2, func(e Event) error {
+		hashes = append(hashes, e.Hash[0])
+		if e.Type == EventMined {
+			cancel()
+		}
+		return nil
+	}), context.Canceled)
+	require.Equal(t, []byte{2, 3, 4, 5, 6}, hashes)
 }
 
 func TestReverseNonces(t *testing.T) {
@@ -760,3 +871,78 @@ func TestShanghaiValidateTx(t *testing.T) {
 		})
 	}
 }
+
+func TestCalcNextBaseFee(t *testing.T) {
+	require.Equal(t, uint64(1000), CalcNextBaseFee(1000, 500, 1000))
+	require.Equal(t, uint64(1125), CalcNextBaseFee(1000, 1000, 1000))
+	require.Equal(t, uint64(875), CalcNextBaseFee(1000, 0, 1000))
+	require.Equal(t, uint64(8), CalcNextBaseFee(7, 1000, 1000)) // increase at least by 1
+	require.Equal(t, uint64(7), CalcNextBaseFee(7, 0, 1000))
+}
+
+func TestPendingBestAtBaseFee(t *testing.T) {
+	p := NewPendingSubPool(PendingSubPool, 1024)
+	add := func(id byte, feeCap, tip, gas uint64) {
+		mt := newMetaTx(&types.TxSlot{IDHash: [32]byte{id}, Gas: gas}, false, 0)
+		mt.minFeeCap = *uint256.NewInt(feeCap)
+		mt.minTip = tip
+		mt.subPool = BaseFeePoolBits
+		p.Add(mt)
+	}
+	add(1, 20, 10, 100)   // effective tip: 10 at base fee 10, 0 at base fee 20
+	add(2, 30, 5, 100)    // 5 at 10, 5 at 20
+	add(3, 15, 10, 100)   // 5 at 10, skipped at 20
+	add(4, 100, 10, 1000) // fits only block with gas limit >= 1000
+
+	ids := func(mts []*metaTx) (res []byte) {
+		for _, mt := range mts {
+			res = append(res, mt.Tx.IDHash[0])
+		}
+		return res
+	}
+	require.Equal(t, []byte{1, 2, 3}, ids(p.bestAt(10, 10, 999)))
+	require.Equal(t, []byte{1, 4, 2, 3}, ids(p.bestAt(10, 10, 1000)))
+	require.Equal(t, []byte{1, 4, 2}, ids(p.bestAt(3, 10, 0)))
+	require.Equal(t, []byte{2, 1}, ids(p.bestAt(10, 20, 999)))
+
+	require.Equal(t, uint64(1200), p.projectGasUsed(20, 10_000))
+	require.Equal(t, uint64(500), p.projectGasUsed(10, 500))
+}
+
+func TestFeeStats(t *testing.T) {
+	p := NewPendingSubPool(PendingSubPool, 1024)
+	pool := &TxPool{lock: &sync.Mutex{}, pending: p}
+	require.Equal(t, 0, pool.FeeStats().Count)
+
+	p.tips.reset(10, nil)
+	var mts []*metaTx
+	add := func(id byte, feeCap, tip uint64) {
+		mt := newMetaTx(&types.TxSlot{IDHash: [32]byte{id}, Gas: 100}, false, 0)
+		mt.minFeeCap = *uint256.NewInt(feeCap)
+		mt.minTip = tip
+		mt.subPool = BaseFeePoolBits
+		p.Add(mt)
+		mts = append(mts, mt)
+	}
+	add(1, 20, 10) // effective tip: 10 at base fee 10, 0 at base fee 20
+	add(2, 30, 5)  // 5 at 10, 5 at 20
+	add(3, 15, 10) // 5 at 10, not executable at 20
+	add(4, 100, 30)
+	add(5, 5, 1) // not executable
+
+	stats := pool.FeeStats(0, 50, 75, 100)
+	require.Equal(t, uint64(10), stats.BaseFee)
+	require.Equal(t, 4, stats.Count)
+	require.Equal(t, []uint64{5, 5, 10, 30}, stats.Tips)
+
+	p.Remove(mts[3])
+	mts[1].minTip = 50
+	p.Updated(mts[1])
+	require.Equal(t, []uint64{5, 10, 20}, pool.FeeStats(0, 50, 100).Tips)
+
+	p.tips.reset(20, p.best.ms)
+	stats = pool.FeeStats(0, 100)
+	require.Equal(t, 2, stats.Count)
+	require.Equal(t, []uint64{0, 10}, stats.Tips)
+	require.Equal(t, len(DefaultFeeStatsPercentiles), len(pool.FeeStats().Tips))
+}
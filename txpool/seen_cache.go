@@ -0,0 +1,144 @@
+/*
+   Copyright 2022 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package txpool
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hashicorp/golang-lru/simplelru"
+
+	"github.com/ledgerwatch/erigon-lib/gointerfaces"
+	"github.com/ledgerwatch/erigon-lib/types"
+)
+
+var (
+	seenCacheHit  = poolMetrics.Counter(`pool_seen_cache_hit`)
+	seenCacheMiss = poolMetrics.Counter(`pool_seen_cache_miss`)
+)
+
+const (
+	seenPeersPerHash   = 16              // peers remembered per hash, older are forgotten
+	seenRequestTimeout = 5 * time.Second // hash requested from one peer is not requested from others during timeout
+)
+
+// SeenCache - bounded memory of tx hashes propagated via p2p: which peers know hash (announced it, delivered it,
+// or hash was sent to them) and when hash was requested last time. Used to not request same hash from every
+// announcing peer while request is in flight and to not send hash back to peers which already know it. During gossip storms oldest hashes
+// are evicted - memory doesn't grow. Safe for concurrent use.
+type SeenCache struct {
+	lock sync.Mutex
+	lru  *simplelru.LRU // tx_hash => *seenEntry
+}
+
+type seenEntry struct {
+	peers     [][64]byte // ring of last seenPeersPerHash peers
+	next      int
+	requested time.Time
+}
+
+func (e *seenEntry) has(peer [64]byte) bool {
+	for i := range e.peers {
+		if e.peers[i] == peer {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *seenEntry) add(peer [64]byte) {
+	if e.has(peer) {
+		return
+	}
+	if len(e.peers) < seenPeersPerHash {
+		e.peers = append(e.peers, peer)
+		return
+	}
+	e.peers[e.next] = peer
+	e.next = (e.next + 1) % seenPeersPerHash
+}
+
+func NewSeenCache(size int) (*SeenCache, error) {
+	lru, err := simplelru.NewLRU(size, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &SeenCache{lru: lru}, nil
+}
+
+func (c *SeenCache) entry(hash []byte) *seenEntry {
+	if e, ok := c.lru.Get(string(hash)); ok {
+		return e.(*seenEntry)
+	}
+	e := &seenEntry{}
+	c.lru.Add(string(hash), e)
+	return e
+}
+
+// MarkPeer - peer knows hash: announced or delivered it, or hash was sent to it
+func (c *SeenCache) MarkPeer(peerID types.PeerID, hash []byte) {
+	if peerID == nil {
+		return
+	}
+	peer := gointerfaces.ConvertH512ToHash(peerID)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.entry(hash).add(peer)
+}
+
+// PeerKnows - peer is known to have hash. Marks peer, because caller is going to send hash to it
+func (c *SeenCache) PeerKnows(peerID types.PeerID, hash []byte) bool {
+	if peerID == nil {
+		return false
+	}
+	peer := gointerfaces.ConvertH512ToHash(peerID)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	e := c.entry(hash)
+	if e.has(peer) {
+		seenCacheHit.Inc()
+		return true
+	}
+	seenCacheMiss.Inc()
+	e.add(peer)
+	return false
+}
+
+// ShouldRequest - hash unknown to pool is announced by peer: false - hash was requested recently (from this or other
+// peer) and still may be delivered. true - hash is marked as requested at `now`. If requested peer never delivers it,
+// next announcement after seenRequestTimeout - by any peer, also by one which announced it before - requests it again
+func (c *SeenCache) ShouldRequest(peerID types.PeerID, hash []byte, now time.Time) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	e := c.entry(hash)
+	if peerID != nil { // announcer knows hash - don't send it back, see PeerKnows
+		e.add(gointerfaces.ConvertH512ToHash(peerID))
+	}
+	if !e.requested.IsZero() && now.Sub(e.requested) < seenRequestTimeout {
+		seenCacheHit.Inc()
+		return false
+	}
+	seenCacheMiss.Inc()
+	e.requested = now
+	return true
+}
+
+func (c *SeenCache) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.lru.Len()
+}
@@ -39,6 +39,7 @@ type Send struct {
 	pool          Pool
 	wg            *sync.WaitGroup
 	sentryClients []direct.SentryClient // sentry clients that will be used for accessing the network
+	seen          *SeenCache            // nil - no dedup of hashes sent to peers
 }
 
 func NewSend(ctx context.Context, sentryClients []direct.SentryClient, pool Pool) *Send {
@@ -53,6 +54,11 @@ func (f *Send) SetWaitGroup(wg *sync.WaitGroup) {
 	f.wg = wg
 }
 
+// SetSeenCache - PropagatePooledTxsToPeersList skips hashes already known to peer
+func (f *Send) SetSeenCache(c *SeenCache) {
+	f.seen = c
+}
+
 const (
 	// This is the target size for the packs of transactions or announcements. A
 	// pack can get larger than this if a single transactions exceeds this size.
@@ -172,12 +178,19 @@ func (f *Send) PropagatePooledTxsToPeersList(peers []types2.PeerID, txs []byte)
 		}
 
 		data := types2.EncodeHashes(pending, nil)
+		perPeerData := f.unknownToPeers(peers, pending)
 		for _, sentryClient := range f.sentryClients {
 			if !sentryClient.Ready() {
 				continue
 			}
 
-			for _, peer := range peers {
+			for i, peer := range peers {
+				data := data
+				if perPeerData != nil {
+					if data = perPeerData[i]; data == nil {
+						continue
+					}
+				}
 				switch sentryClient.Protocol() {
 				case direct.ETH66, direct.ETH67:
 					req66 := &sentry.SendMessageByIdRequest{
@@ -195,3 +208,23 @@ func (f *Send) PropagatePooledTxsToPeersList(peers []types2.PeerID, txs []byte)
 		}
 	}
 }
+
+// unknownToPeers - encoded hashes of pending not known to each of peers (nil - all known), nil - no SeenCache
+func (f *Send) unknownToPeers(peers []types2.PeerID, pending types2.Hashes) [][]byte {
+	if f.seen == nil {
+		return nil
+	}
+	res := make([][]byte, len(peers))
+	for i, peer := range peers {
+		var unknown types2.Hashes
+		for j := 0; j < len(pending); j += 32 {
+			if !f.seen.PeerKnows(peer, pending[j:j+32]) {
+				unknown = append(unknown, pending[j:j+32]...)
+			}
+		}
+		if len(unknown) > 0 {
+			res[i] = types2.EncodeHashes(unknown, nil)
+		}
+	}
+	return res
+}
@@ -127,11 +127,19 @@ func AllComponents(ctx context.Context, cfg txpool.Config, cache kvcache.Cache,
 		return nil, nil, nil, nil, nil, err
 	}
 
-	fetch := txpool.NewFetch(ctx, sentryClients, txPool, stateChangesClient, chainDB, txPoolDB, *chainID)
+	seenCacheSize := cfg.SeenCacheSize
+	if seenCacheSize <= 0 {
+		seenCacheSize = txpool.DefaultConfig.SeenCacheSize
+	}
+	fetch, err := txpool.NewFetch(ctx, sentryClients, txPool, stateChangesClient, chainDB, txPoolDB, *chainID, seenCacheSize)
+	if err != nil {
+		return nil, nil, nil, nil, nil, err
+	}
 	//fetch.ConnectCore()
 	//fetch.ConnectSentries()
 
 	send := txpool.NewSend(ctx, sentryClients, txPool)
+	send.SetSeenCache(fetch.SeenCache())
 	txpoolGrpcServer := txpool.NewGrpcServer(ctx, txPool, txPoolDB, *chainID)
 	return txPoolDB, txPool, fetch, send, txpoolGrpcServer, nil
 }
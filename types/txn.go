@@ -34,6 +34,7 @@ import (
 	"github.com/ledgerwatch/erigon-lib/common/length"
 	"github.com/ledgerwatch/erigon-lib/common/u256"
 	"github.com/ledgerwatch/erigon-lib/crypto"
+	"github.com/ledgerwatch/erigon-lib/crypto/cryptopool"
 	"github.com/ledgerwatch/erigon-lib/gointerfaces/types"
 	"github.com/ledgerwatch/erigon-lib/rlp"
 )
@@ -61,6 +62,7 @@ type TxParseContext struct {
 	withSender      bool
 	allowPreEip2s   bool // Allow s > secp256k1n/2; see EIP-2
 	chainIDRequired bool
+	lazy            bool // see WithLazy
 	IsProtected     bool
 }
 
@@ -84,19 +86,21 @@ func NewTxParseContext(chainID uint256.Int) *TxParseContext {
 // Also, it contains some auxillary information, like ephemeral fields, and indices within priority queues
 type TxSlot struct {
 	Rlp            []byte      // TxPool set it to nil after save it to db
-	Value          uint256.Int // Value transferred by the transaction
+	Size           uint32      // Size of Rlp, available after Rlp is dropped
+	value          uint256.Int // Value transferred by the transaction, see Value
 	Tip            uint256.Int // Maximum tip that transaction is giving to miner/block proposer
 	FeeCap         uint256.Int // Maximum fee that transaction burns and gives to the miner/block proposer
 	SenderID       uint64      // SenderID - require external mapping to it's address
 	Nonce          uint64      // Nonce of the transaction
 	DataLen        int         // Length of transaction's data (for calculation of intrinsic gas)
-	DataNonZeroLen int
-	AlAddrCount    int      // Number of addresses in the access list
-	AlStorCount    int      // Number of storage keys in the access list
-	Gas            uint64   // Gas limit of the transaction
-	IDHash         [32]byte // Transaction hash for the purposes of using it as a transaction Id
-	Traced         bool     // Whether transaction needs to be traced throughout transaction pool code and generate debug printing
-	Creation       bool     // Set to true if "To" field of the transaction is not set
+	DataNonZeroLen int         // Not counted by lazy parsing until Resolve, see TxParseContext.WithLazy
+	AlAddrCount    int         // Number of addresses in the access list. Not counted by lazy parsing until Resolve
+	AlStorCount    int         // Number of storage keys in the access list. Not counted by lazy parsing until Resolve
+	Gas            uint64      // Gas limit of the transaction
+	IDHash         [32]byte    // Transaction hash for the purposes of using it as a transaction Id
+	Traced         bool        // Whether transaction needs to be traced throughout transaction pool code and generate debug printing
+	Creation       bool        // Set to true if "To" field of the transaction is not set
+	lazy           bool        // parsed by lazy TxParseContext: value, DataNonZeroLen and access list counts are not decoded yet
 }
 
 const (
@@ -115,6 +119,12 @@ var ErrRlpTooBig = errors.New("txn rlp too big")
 func (ctx *TxParseContext) ValidateRLP(f func(txnRlp []byte) error) { ctx.validateRlp = f }
 func (ctx *TxParseContext) WithSender(v bool)                       { ctx.withSender = v }
 func (ctx *TxParseContext) WithAllowPreEip2s(v bool)                { ctx.allowPreEip2s = v }
+
+// WithLazy - ParseTransaction checks only bounds of value, data and access list: value is not decoded, non-zero bytes
+// of data and items of access list are not counted. They are decoded from retained tx.Rlp by TxSlot.Resolve (or on
+// demand by TxSlot.Value) - for readers which need only hash, sender, nonce and fees of most txs. IdHash and sender
+// are computed as usual: they need whole payload.
+func (ctx *TxParseContext) WithLazy(v bool) { ctx.lazy = v }
 func (ctx *TxParseContext) ChainIDRequired() *TxParseContext {
 	ctx.chainIDRequired = true
 	return ctx
@@ -179,6 +189,7 @@ func (ctx *TxParseContext) ParseTransaction(payload []byte, pos int, slot *TxSlo
 	} else {
 		slot.Rlp = payload[pos : dataPos+dataLen]
 	}
+	slot.Size = uint32(len(slot.Rlp))
 
 	if ctx.validateRlp != nil {
 		if err := ctx.validateRlp(slot.Rlp); err != nil {
@@ -243,9 +254,20 @@ func (ctx *TxParseContext) ParseTransaction(payload []byte, pos int, slot *TxSlo
 	slot.Creation = dataLen == 0
 	p = dataPos + dataLen
 	// Next follows value
-	p, err = rlp.U256(payload, p, &slot.Value)
-	if err != nil {
-		return 0, fmt.Errorf("%w: value: %s", ErrParseTxn, err)
+	slot.lazy = ctx.lazy
+	if ctx.lazy {
+		if dataPos, dataLen, err = rlp.String(payload, p); err != nil {
+			return 0, fmt.Errorf("%w: value: %s", ErrParseTxn, err)
+		}
+		if dataLen > 32 {
+			return 0, fmt.Errorf("%w: value: too long: %d", ErrParseTxn, dataLen)
+		}
+		p = dataPos + dataLen
+	} else {
+		p, err = rlp.U256(payload, p, &slot.value)
+		if err != nil {
+			return 0, fmt.Errorf("%w: value: %s", ErrParseTxn, err)
+		}
 	}
 	// Next goes data, but we are only interesting in its length
 	dataPos, dataLen, err = rlp.String(payload, p)
@@ -256,10 +278,8 @@ func (ctx *TxParseContext) ParseTransaction(payload []byte, pos int, slot *TxSlo
 
 	// Zero and non-zero bytes are priced differently
 	slot.DataNonZeroLen = 0
-	for _, byt := range payload[dataPos : dataPos+dataLen] {
-		if byt != 0 {
-			slot.DataNonZeroLen++
-		}
+	if !ctx.lazy {
+		slot.DataNonZeroLen = nonZeroLen(payload[dataPos : dataPos+dataLen])
 	}
 
 	p = dataPos + dataLen
@@ -279,40 +299,11 @@ func (ctx *TxParseContext) ParseTransaction(payload []byte, pos int, slot *TxSlo
 		if err != nil {
 			return 0, fmt.Errorf("%w: access list len: %s", ErrParseTxn, err)
 		}
-		tuplePos := dataPos
-		var tupleLen int
-		for tuplePos < dataPos+dataLen {
-			tuplePos, tupleLen, err = rlp.List(payload, tuplePos)
-			if err != nil {
-				return 0, fmt.Errorf("%w: tuple len: %s", ErrParseTxn, err)
-			}
-			var addrPos int
-			addrPos, err = rlp.StringOfLen(payload, tuplePos, 20)
-			if err != nil {
-				return 0, fmt.Errorf("%w: tuple addr len: %s", ErrParseTxn, err)
-			}
-			slot.AlAddrCount++
-			var storagePos, storageLen int
-			storagePos, storageLen, err = rlp.List(payload, addrPos+20)
-			if err != nil {
-				return 0, fmt.Errorf("%w: storage key list len: %s", ErrParseTxn, err)
-			}
-			skeyPos := storagePos
-			for skeyPos < storagePos+storageLen {
-				skeyPos, err = rlp.StringOfLen(payload, skeyPos, 32)
-				if err != nil {
-					return 0, fmt.Errorf("%w: tuple storage key len: %s", ErrParseTxn, err)
-				}
-				slot.AlStorCount++
-				skeyPos += 32
-			}
-			if skeyPos != storagePos+storageLen {
-				return 0, fmt.Errorf("%w: extraneous space in the tuple after storage key list", ErrParseTxn)
+		slot.AlAddrCount, slot.AlStorCount = 0, 0
+		if !ctx.lazy {
+			if slot.AlAddrCount, slot.AlStorCount, err = parseAccessList(payload, dataPos, dataLen); err != nil {
+				return 0, err
 			}
-			tuplePos += tupleLen
-		}
-		if tuplePos != dataPos+dataLen {
-			return 0, fmt.Errorf("%w: extraneous space in the access list after all tuples", ErrParseTxn)
 		}
 		p = dataPos + dataLen
 	}
@@ -680,9 +671,198 @@ func bytesToUint64(buf []byte) (x uint64) {
 	return
 }
 
+func nonZeroLen(data []byte) (n int) {
+	for _, byt := range data {
+		if byt != 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// parseAccessList - validates access list of `length` bytes at `pos` and counts its addresses and storage keys
+func parseAccessList(payload []byte, pos, length int) (addrCount, storCount int, err error) {
+	tuplePos := pos
+	var tupleLen int
+	for tuplePos < pos+length {
+		tuplePos, tupleLen, err = rlp.List(payload, tuplePos)
+		if err != nil {
+			return 0, 0, fmt.Errorf("%w: tuple len: %s", ErrParseTxn, err)
+		}
+		var addrPos int
+		addrPos, err = rlp.StringOfLen(payload, tuplePos, 20)
+		if err != nil {
+			return 0, 0, fmt.Errorf("%w: tuple addr len: %s", ErrParseTxn, err)
+		}
+		addrCount++
+		var storagePos, storageLen int
+		storagePos, storageLen, err = rlp.List(payload, addrPos+20)
+		if err != nil {
+			return 0, 0, fmt.Errorf("%w: storage key list len: %s", ErrParseTxn, err)
+		}
+		skeyPos := storagePos
+		for skeyPos < storagePos+storageLen {
+			skeyPos, err = rlp.StringOfLen(payload, skeyPos, 32)
+			if err != nil {
+				return 0, 0, fmt.Errorf("%w: tuple storage key len: %s", ErrParseTxn, err)
+			}
+			storCount++
+			skeyPos += 32
+		}
+		if skeyPos != storagePos+storageLen {
+			return 0, 0, fmt.Errorf("%w: extraneous space in the tuple after storage key list", ErrParseTxn)
+		}
+		tuplePos += tupleLen
+	}
+	if tuplePos != pos+length {
+		return 0, 0, fmt.Errorf("%w: extraneous space in the access list after all tuples", ErrParseTxn)
+	}
+	return addrCount, storCount, nil
+}
+
+// ErrRlpNotRetained - To/Data/DataHash/Resolve called after TxPool dropped tx.Rlp (see TxSlot.Rlp)
+var ErrRlpNotRetained = errors.New("txn rlp not retained")
+
+// To, Data and DataHash read fields which ParseTransaction validates but doesn't keep in TxSlot. Resolve decodes
+// fields skipped by lazy parsing (see TxParseContext.WithLazy). They work only while tx.Rlp is retained, else return
+// ErrRlpNotRetained.
+
+// Resolve - decodes fields skipped by lazy parsing: value, DataNonZeroLen, AlAddrCount, AlStorCount. No-op for txs
+// parsed eagerly. Must be called before tx.Rlp is dropped.
+func (tx *TxSlot) Resolve() error {
+	if !tx.lazy {
+		return nil
+	}
+	p, err := tx.toFieldPos()
+	if err != nil {
+		return err
+	}
+	dataPos, dataLen, err := rlp.String(tx.Rlp, p) // to
+	if err != nil {
+		return fmt.Errorf("%w: to len: %s", ErrParseTxn, err)
+	}
+	var value uint256.Int
+	if p, err = rlp.U256(tx.Rlp, dataPos+dataLen, &value); err != nil {
+		return fmt.Errorf("%w: value: %s", ErrParseTxn, err)
+	}
+	if dataPos, dataLen, err = rlp.String(tx.Rlp, p); err != nil {
+		return fmt.Errorf("%w: data len: %s", ErrParseTxn, err)
+	}
+	nonZero := nonZeroLen(tx.Rlp[dataPos : dataPos+dataLen])
+	p = dataPos + dataLen
+	var alAddrCount, alStorCount int
+	if legacy := tx.Rlp[0] >= 192; !legacy {
+		if int(tx.Rlp[0]) == StarknetTxType { // salt
+			if dataPos, dataLen, err = rlp.String(tx.Rlp, p); err != nil {
+				return fmt.Errorf("%w: data len: %s", ErrParseTxn, err)
+			}
+			p = dataPos + dataLen
+		}
+		if dataPos, dataLen, err = rlp.List(tx.Rlp, p); err != nil {
+			return fmt.Errorf("%w: access list len: %s", ErrParseTxn, err)
+		}
+		if alAddrCount, alStorCount, err = parseAccessList(tx.Rlp, dataPos, dataLen); err != nil {
+			return err
+		}
+	}
+	tx.value, tx.DataNonZeroLen, tx.AlAddrCount, tx.AlStorCount = value, nonZero, alAddrCount, alStorCount
+	tx.lazy = false
+	return nil
+}
+
+// Value - value transferred by the transaction. Txs of lazy parsing are resolved by first call, see Resolve
+func (tx *TxSlot) Value() (*uint256.Int, error) {
+	if err := tx.Resolve(); err != nil {
+		return nil, err
+	}
+	return &tx.value, nil
+}
+
+// SetValue - for txs which are not parsed from rlp
+func (tx *TxSlot) SetValue(v *uint256.Int) { tx.value.Set(v) }
+
+// toFieldPos - position of "to" field inside tx.Rlp. Fields before it are skipped without decoding values.
+// Rlp of non-legacy txs starts from type byte (see ParseTransaction)
+func (tx *TxSlot) toFieldPos() (int, error) {
+	if len(tx.Rlp) == 0 {
+		return 0, ErrRlpNotRetained
+	}
+	p, skip := 0, 3 // nonce, gasPrice, gas
+	if legacy := tx.Rlp[0] >= 192; !legacy {
+		if int(tx.Rlp[0]) >= DynamicFeeTxType {
+			skip++ // feeCap
+		}
+		skip++ // chainID
+		p++
+	}
+	p, _, err := rlp.List(tx.Rlp, p)
+	if err != nil {
+		return 0, fmt.Errorf("%w: envelope Prefix: %s", ErrParseTxn, err)
+	}
+	for i := 0; i < skip; i++ {
+		dataPos, dataLen, err := rlp.String(tx.Rlp, p)
+		if err != nil {
+			return 0, fmt.Errorf("%w: field %d: %s", ErrParseTxn, i, err)
+		}
+		p = dataPos + dataLen
+	}
+	return p, nil
+}
+
+// To - destination address, decoded from tx.Rlp on demand.
+// Returns nil for contract creation. Result points into tx.Rlp - copy it if need to keep it longer than tx.Rlp.
+func (tx *TxSlot) To() ([]byte, error) {
+	p, err := tx.toFieldPos()
+	if err != nil {
+		return nil, err
+	}
+	dataPos, dataLen, err := rlp.String(tx.Rlp, p)
+	if err != nil {
+		return nil, fmt.Errorf("%w: to len: %s", ErrParseTxn, err)
+	}
+	if dataLen == 0 {
+		return nil, nil
+	}
+	return tx.Rlp[dataPos : dataPos+dataLen], nil
+}
+
+// Data - transaction's data (input), decoded from tx.Rlp on demand. Result points into tx.Rlp.
+func (tx *TxSlot) Data() ([]byte, error) {
+	p, err := tx.toFieldPos()
+	if err != nil {
+		return nil, err
+	}
+	dataPos, dataLen, err := rlp.String(tx.Rlp, p) // to
+	if err != nil {
+		return nil, fmt.Errorf("%w: to len: %s", ErrParseTxn, err)
+	}
+	dataPos, dataLen, err = rlp.String(tx.Rlp, dataPos+dataLen) // value
+	if err != nil {
+		return nil, fmt.Errorf("%w: value: %s", ErrParseTxn, err)
+	}
+	dataPos, dataLen, err = rlp.String(tx.Rlp, dataPos+dataLen)
+	if err != nil {
+		return nil, fmt.Errorf("%w: data len: %s", ErrParseTxn, err)
+	}
+	return tx.Rlp[dataPos : dataPos+dataLen], nil
+}
+
+// DataHash - keccak256 of transaction's data, computed from tx.Rlp on demand
+func (tx *TxSlot) DataHash() (h [32]byte, err error) {
+	data, err := tx.Data()
+	if err != nil {
+		return h, err
+	}
+	keccak := cryptopool.GetLegacyKeccak256()
+	defer cryptopool.ReturnLegacyKeccak256(keccak)
+	_, _ = keccak.Write(data)
+	_, _ = keccak.(io.Reader).Read(h[:])
+	return h, nil
+}
+
 // nolint
 func (tx *TxSlot) PrintDebug(prefix string) {
-	fmt.Printf("%s: senderID=%d,nonce=%d,tip=%d,v=%d\n", prefix, tx.SenderID, tx.Nonce, tx.Tip, tx.Value.Uint64())
+	fmt.Printf("%s: senderID=%d,nonce=%d,tip=%d,v=%d\n", prefix, tx.SenderID, tx.Nonce, tx.Tip, tx.value.Uint64())
 	//fmt.Printf("%s: senderID=%d,nonce=%d,tip=%d,hash=%x\n", prefix, tx.senderID, tx.nonce, tx.tip, tx.IdHash)
 }
 
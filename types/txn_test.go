@@ -26,6 +26,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/ledgerwatch/erigon-lib/common/hexutility"
+	"github.com/ledgerwatch/erigon-lib/rlp"
 )
 
 func TestParseTransactionRLP(t *testing.T) {
@@ -34,6 +35,8 @@ func TestParseTransactionRLP(t *testing.T) {
 		t.Run(strconv.Itoa(int(testSet.chainID.Uint64())), func(t *testing.T) {
 			require := require.New(t)
 			ctx := NewTxParseContext(testSet.chainID)
+			lazyCtx := NewTxParseContext(testSet.chainID)
+			lazyCtx.WithLazy(true)
 			tx, txSender := &TxSlot{}, [20]byte{}
 			for i, tt := range testSet.tests {
 				tt := tt
@@ -61,6 +64,22 @@ func TestParseTransactionRLP(t *testing.T) {
 						}
 					}
 					require.Equal(tt.Nonce, tx.Nonce)
+
+					to, err := tx.To()
+					require.NoError(err)
+					require.Equal(tx.Creation, to == nil)
+					data, err := tx.Data()
+					require.NoError(err)
+					require.Equal(tx.DataLen, len(data))
+
+					// lazy parsing gives same txn after Resolve
+					lazyTx, lazySender := &TxSlot{}, [20]byte{}
+					lazyEnd, err := lazyCtx.ParseTransaction(payload, 0, lazyTx, lazySender[:], false /* hasEnvelope */, nil)
+					require.NoError(err)
+					require.Equal(parseEnd, lazyEnd)
+					require.Equal(txSender, lazySender)
+					require.NoError(lazyTx.Resolve())
+					require.Equal(tx, lazyTx)
 				})
 			}
 		})
@@ -90,6 +109,128 @@ func TestTransactionSignatureValidity1(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestLazyAccessors(t *testing.T) {
+	ctx := NewTxParseContext(*uint256.NewInt(1))
+	tx, txSender := &TxSlot{}, [20]byte{}
+	_, err := tx.To()
+	require.ErrorIs(t, err, ErrRlpNotRetained)
+
+	txn := hexutility.MustDecodeHex("f83f800182520894095e7baea6a6c7c4c2dfeb977efac326af552d870b801ba048b55bfa915ac795c431978d8a6a992b628d557da5ff759b307d495a3664935301")
+	_, err = ctx.ParseTransaction(txn, 0, tx, txSender[:], false /* hasEnvelope */, nil)
+	require.NoError(t, err)
+	to, err := tx.To()
+	require.NoError(t, err)
+	require.Equal(t, hexutility.MustDecodeHex("095e7baea6a6c7c4c2dfeb977efac326af552d87"), to)
+	data, err := tx.Data()
+	require.NoError(t, err)
+	require.Empty(t, data)
+	dataHash, err := tx.DataHash()
+	require.NoError(t, err)
+	require.Equal(t, hexutility.MustDecodeHex("c5d2460186f7233c927e7db2dcc703c0e500b653ca82273b7bfad8045d85a470"), dataHash[:])
+
+	// dynamic fee txn, parsing fails on signature check, but Rlp is already retained
+	tx = &TxSlot{}
+	ctx = NewTxParseContext(*uint256.NewInt(5))
+	txn = hexutility.MustDecodeHex("02f8720513844190ab00848321560082520894cab441d2f45a3fee83d15c6b6b6c36a139f55b6288054607fc96a6000080c001a0dffe4cb5651e663d0eac8c4d002de734dd24db0f1109b062d17da290a133cc02a0913fb9f53f7a792bcd9e4d7cced1b8545d1ab82c77432b0bc2e9384ba6c250c5")
+	_, _ = ctx.ParseTransaction(txn, 0, tx, txSender[:], false /* hasEnvelope */, nil)
+	to, err = tx.To()
+	require.NoError(t, err)
+	require.Equal(t, hexutility.MustDecodeHex("cab441d2f45a3fee83d15c6b6b6c36a139f55b62"), to)
+}
+
+func TestLazyParse(t *testing.T) {
+	ctx := NewTxParseContext(*uint256.NewInt(1))
+	ctx.WithLazy(true)
+	txn := benchTxn(100, 2, 3)
+	tx, txSender := &TxSlot{}, [20]byte{}
+	_, err := ctx.ParseTransaction(txn, 0, tx, txSender[:], false /* hasEnvelope */, nil)
+	require.NoError(t, err)
+	require.Zero(t, tx.DataNonZeroLen)
+	require.Zero(t, tx.AlAddrCount)
+
+	v, err := tx.Value()
+	require.NoError(t, err)
+	require.Equal(t, uint256.NewInt(1_000_000), v)
+	require.Equal(t, 100, tx.DataNonZeroLen)
+	require.Equal(t, 2, tx.AlAddrCount)
+	require.Equal(t, 6, tx.AlStorCount)
+
+	// not resolved before Rlp is dropped
+	tx = &TxSlot{}
+	_, err = ctx.ParseTransaction(txn, 0, tx, txSender[:], false /* hasEnvelope */, nil)
+	require.NoError(t, err)
+	tx.Rlp = nil
+	_, err = tx.Value()
+	require.ErrorIs(t, err, ErrRlpNotRetained)
+}
+
+// benchTxn - dynamic fee txn (without valid signature) with `dataLen` bytes of data and access list of `addrs`
+// addresses with `keys` storage keys each
+func benchTxn(dataLen, addrs, keys int) []byte {
+	var body []byte
+	appendU64 := func(v uint64) {
+		buf := make([]byte, 10)
+		body = append(body, buf[:rlp.EncodeU64(v, buf)]...)
+	}
+	appendString := func(s []byte) {
+		buf := make([]byte, rlp.StringLen(len(s))+9)
+		body = append(body, buf[:rlp.EncodeString(s, buf)]...)
+	}
+	appendListPrefix := func(l int) {
+		buf := make([]byte, 10)
+		body = append(body, buf[:rlp.EncodeListPrefix(l, buf)]...)
+	}
+	appendU64(1)             // chainID
+	appendU64(7)             // nonce
+	appendU64(1_000_000_000) // tip
+	appendU64(2_000_000_000) // feeCap
+	appendU64(1_000_000)     // gas
+	appendString(bytes.Repeat([]byte{0xaa}, 20))
+	appendU64(1_000_000) // value
+	appendString(bytes.Repeat([]byte{0xbb}, dataLen))
+	tupleLen := 21 + rlp.ListPrefixLen(33*keys) + 33*keys
+	appendListPrefix(addrs * (rlp.ListPrefixLen(tupleLen) + tupleLen))
+	for i := 0; i < addrs; i++ {
+		appendListPrefix(tupleLen)
+		appendString(bytes.Repeat([]byte{byte(i + 1)}, 20))
+		appendListPrefix(33 * keys)
+		for j := 0; j < keys; j++ {
+			appendString(bytes.Repeat([]byte{byte(j + 1)}, 32))
+		}
+	}
+	appendU64(1) // v
+	appendU64(1) // r
+	appendU64(1) // s
+
+	txn := []byte{byte(DynamicFeeTxType)}
+	buf := make([]byte, 10)
+	txn = append(txn, buf[:rlp.EncodeListPrefix(len(body), buf)]...)
+	return append(txn, body...)
+}
+
+// BenchmarkParseTransaction - lazy parsing skips counting of data bytes and access list items: noticeable for txs
+// with big data, without sender recovery (which dominates otherwise)
+func BenchmarkParseTransaction(b *testing.B) {
+	txn := benchTxn(64*1024, 16, 16)
+	for _, lazy := range []bool{false, true} {
+		lazy := lazy
+		b.Run("lazy="+strconv.FormatBool(lazy), func(b *testing.B) {
+			ctx := NewTxParseContext(*uint256.NewInt(1))
+			ctx.WithSender(false)
+			ctx.WithLazy(lazy)
+			tx := &TxSlot{}
+			b.SetBytes(int64(len(txn)))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := ctx.ParseTransaction(txn, 0, tx, nil, false /* hasEnvelope */, nil); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
 // Problematic txn included in a bad block on Görli
 func TestTransactionSignatureValidity2(t *testing.T) {
 	chainId := new(uint256.Int).SetUint64(5)